@@ -0,0 +1,735 @@
+// Package archive implements a backend to read the contents of
+// archive files stored on another remote.
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+const metadataTimeFormat = time.RFC3339Nano
+
+// errReadOnly is returned when trying to change something inside an archive
+var errReadOnly = fmt.Errorf("archives are read only: %w", fs.ErrorPermissionDenied)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "archive",
+		Description: "Read archives",
+		NewFs:       NewFs,
+		MetadataInfo: &fs.MetadataInfo{
+			System: map[string]fs.MetadataHelp{
+				"mtime": {
+					Help:     "Time of last modification",
+					Type:     "RFC 3339",
+					Example:  "2006-01-02T15:04:05.999999999Z07:00",
+					ReadOnly: true,
+				},
+				"mode": {
+					Help:     "File mode",
+					Type:     "octal, unix style",
+					Example:  "644",
+					ReadOnly: true,
+				},
+				"uid": {
+					Help:     "User ID of owner (tar only)",
+					Type:     "decimal number",
+					Example:  "500",
+					ReadOnly: true,
+				},
+				"gid": {
+					Help:     "Group ID of owner (tar only)",
+					Type:     "decimal number",
+					Example:  "500",
+					ReadOnly: true,
+				},
+				"uname": {
+					Help:     "User name of owner (tar only)",
+					Type:     "string",
+					Example:  "alice",
+					ReadOnly: true,
+				},
+				"gname": {
+					Help:     "Group name of owner (tar only)",
+					Type:     "string",
+					Example:  "staff",
+					ReadOnly: true,
+				},
+				"comment": {
+					Help:     "File comment (zip only)",
+					Type:     "string",
+					Example:  "My file",
+					ReadOnly: true,
+				},
+			},
+			Help: `Files inside archives have the system metadata read from the archive.
+
+Any metadata supported by the underlying remote is read and written
+for files outside archives.`,
+		},
+		Options: []fs.Option{{
+			Name:     "remote",
+			Help:     "Remote containing the archives.\n\nNormally should contain a ':' and a path, e.g. \"myremote:path/to/dir\",\n\"myremote:bucket\" or maybe \"myremote:\" (not recommended).",
+			Required: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote string `config:"remote"`
+}
+
+// Fs represents a remote with archives shown as directories
+type Fs struct {
+	fs.Fs
+	wrapper  fs.Fs
+	name     string
+	root     string
+	prefix   string // path in the wrapped remote of our root if it is inside an archive
+	opt      Options
+	features *fs.Features // optional features
+
+	mu      sync.Mutex
+	indexes map[string]*index // archives read so far by path in the wrapped remote
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point archive remote at itself - check the value of the remote setting")
+	}
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:    name,
+		root:    root,
+		opt:     *opt,
+		indexes: make(map[string]*index),
+	}
+
+	// If the root is inside an archive then the wrapped remote is
+	// rooted at the directory containing the archive and all the
+	// paths are looked up with prefix.
+	var isFile bool
+	if root != "" {
+		elems := strings.Split(root, "/")
+		for i, elem := range elems {
+			if findFormat(elem) == nil {
+				continue
+			}
+			parent := path.Join(elems[:i]...)
+			baseFs, err := cache.Get(ctx, fspath.JoinRootPath(opt.Remote, parent))
+			if err != nil {
+				continue
+			}
+			if _, err = baseFs.NewObject(ctx, elem); err != nil {
+				// probably a directory called something.zip
+				continue
+			}
+			f.Fs = baseFs
+			f.prefix = path.Join(elems[i:]...)
+			break
+		}
+	}
+	if f.Fs == nil {
+		f.Fs, err = cache.Get(ctx, fspath.JoinRootPath(opt.Remote, root))
+		if err != nil && err != fs.ErrorIsFile {
+			return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+		}
+		isFile = err == fs.ErrorIsFile
+	} else {
+		x, inner, err := f.findArchive(ctx, f.prefix)
+		if err != nil {
+			return nil, err
+		}
+		if e := x.find(inner); e != nil && !e.isDir {
+			f.prefix = path.Dir(f.prefix)
+			isFile = true
+		}
+	}
+	// Correct root if definitely pointing to a file
+	if isFile {
+		f.root = path.Dir(f.root)
+		if f.root == "." || f.root == "/" {
+			f.root = ""
+		}
+	}
+
+	// the features here are ones we could support, and they are
+	// ANDed with the ones from the wrapped remote
+	f.features = (&fs.Features{
+		CaseInsensitive:          true,
+		DuplicateFiles:           false,
+		ReadMimeType:             true,
+		WriteMimeType:            true,
+		CanHaveEmptyDirectories:  true,
+		BucketBased:              true,
+		SetTier:                  true,
+		GetTier:                  true,
+		ReadMetadata:             true,
+		WriteMetadata:            true,
+		UserMetadata:             true,
+		ReadDirMetadata:          true,
+		WriteDirMetadata:         true,
+		WriteDirSetModTime:       true,
+		UserDirMetadata:          true,
+		DirModTimeUpdatesOnWrite: true,
+		PartialUploads:           true,
+	}).Fill(ctx, f).Mask(ctx, f.Fs).WrapsFs(f, f.Fs)
+
+	cache.PinUntilFinalized(f.Fs, f)
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("archive:%s:%s", f.name, f.root)
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() hash.Set {
+	return f.Fs.Hashes()
+}
+
+// Precision returns the precision of this Fs
+func (f *Fs) Precision() time.Duration {
+	return f.Fs.Precision()
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.Fs
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// wrappedPath returns the path in the wrapped remote of remote
+func (f *Fs) wrappedPath(remote string) string {
+	if f.prefix == "" {
+		return remote
+	}
+	return path.Join(f.prefix, remote)
+}
+
+// localPath returns the path in this remote of a wrapped path
+func (f *Fs) localPath(wrappedPath string) string {
+	if f.prefix == "" {
+		return wrappedPath
+	}
+	if wrappedPath == f.prefix {
+		return ""
+	}
+	return strings.TrimPrefix(wrappedPath, f.prefix+"/")
+}
+
+// findArchive looks for an archive in the wrapped path remote.
+//
+// If remote is inside an archive (or is an archive) it returns the
+// index of the archive and the path within it, otherwise it returns
+// a nil index.
+func (f *Fs) findArchive(ctx context.Context, remote string) (x *index, inner string, err error) {
+	if remote == "" || remote == "." {
+		return nil, "", nil
+	}
+	elems := strings.Split(remote, "/")
+	for i, elem := range elems {
+		format := findFormat(elem)
+		if format == nil {
+			continue
+		}
+		archivePath := path.Join(elems[:i+1]...)
+		x, err = f.getIndex(ctx, archivePath, format, nil)
+		if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorNotAFile) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return x, path.Join(elems[i+1:]...), nil
+	}
+	return nil, "", nil
+}
+
+// getIndex returns the index of the archive at archivePath in the
+// wrapped remote, reading it if necessary.
+//
+// If o is set then it is used to check the cached index is still
+// valid, otherwise the object is looked up if needed.
+func (f *Fs) getIndex(ctx context.Context, archivePath string, format *format, o fs.Object) (*index, error) {
+	f.mu.Lock()
+	x := f.indexes[archivePath]
+	f.mu.Unlock()
+	if x != nil && (o == nil || sameArchive(ctx, x.src, o)) {
+		return x, nil
+	}
+	if o == nil {
+		var err error
+		o, err = f.Fs.NewObject(ctx, archivePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fs.Debugf(f, "Reading %s archive %q", format.name, archivePath)
+	// The index is used long after this call so shouldn't be
+	// cancelled with it.
+	x, err := format.newIndex(context.WithoutCancel(ctx), o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %q: %w", archivePath, err)
+	}
+	x.remote = archivePath
+	f.mu.Lock()
+	f.indexes[archivePath] = x
+	f.mu.Unlock()
+	return x, nil
+}
+
+// forgetIndex removes the cached index for the wrapped path remote
+func (f *Fs) forgetIndex(remote string) {
+	f.mu.Lock()
+	delete(f.indexes, remote)
+	f.mu.Unlock()
+}
+
+// sameArchive returns true if a and b look like the same archive
+func sameArchive(ctx context.Context, a, b fs.Object) bool {
+	return a.Size() == b.Size() && a.ModTime(ctx).Equal(b.ModTime(ctx))
+}
+
+// checkWritable returns an error if remote is inside an archive
+func (f *Fs) checkWritable(ctx context.Context, remote string) error {
+	if f.prefix != "" {
+		return errReadOnly
+	}
+	dir := path.Dir(remote)
+	if dir == "." {
+		return nil
+	}
+	x, _, err := f.findArchive(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if x != nil {
+		return errReadOnly
+	}
+	return nil
+}
+
+// wrapEntries wraps the entries from the wrapped remote showing any
+// archives as directories
+func (f *Fs) wrapEntries(ctx context.Context, entries fs.DirEntries) fs.DirEntries {
+	newEntries := entries[:0] // in place filter
+	for _, entry := range entries {
+		switch x := entry.(type) {
+		case fs.Object:
+			if findFormat(path.Base(x.Remote())) != nil {
+				f.mu.Lock()
+				cached := f.indexes[x.Remote()]
+				f.mu.Unlock()
+				if cached != nil && !sameArchive(ctx, cached.src, x) {
+					f.forgetIndex(x.Remote())
+				}
+				newEntries = append(newEntries, fs.NewDir(x.Remote(), x.ModTime(ctx)))
+				continue
+			}
+			newEntries = append(newEntries, f.newObject(x))
+		default:
+			newEntries = append(newEntries, entry)
+		}
+	}
+	return newEntries
+}
+
+// listArchive lists the directory inner of the archive x
+func (f *Fs) listArchive(x *index, inner string) (entries fs.DirEntries, err error) {
+	if e := x.find(inner); e == nil || !e.isDir {
+		return nil, fs.ErrorDirNotFound
+	}
+	for _, e := range x.children[inner] {
+		remote := f.localPath(path.Join(x.remote, e.name))
+		if e.isDir {
+			entries = append(entries, fs.NewDir(remote, e.modTime))
+		} else {
+			entries = append(entries, f.newMember(x, e, remote))
+		}
+	}
+	return entries, nil
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	x, inner, err := f.findArchive(ctx, f.wrappedPath(dir))
+	if err != nil {
+		return nil, err
+	}
+	if x != nil {
+		return f.listArchive(x, inner)
+	}
+	entries, err = f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return f.wrapEntries(ctx, entries), nil
+}
+
+// NewObject finds the Object at remote.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	x, inner, err := f.findArchive(ctx, f.wrappedPath(remote))
+	if err != nil {
+		return nil, err
+	}
+	if x != nil {
+		e := x.find(inner)
+		if e == nil {
+			return nil, fs.ErrorObjectNotFound
+		}
+		if e.isDir {
+			return nil, fs.ErrorIsDir
+		}
+		return f.newMember(x, e, remote), nil
+	}
+	o, err := f.Fs.NewObject(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(o), nil
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	if err := f.checkWritable(ctx, src.Remote()); err != nil {
+		return nil, err
+	}
+	f.forgetIndex(src.Remote())
+	o, err := f.Fs.Put(ctx, in, src, options...)
+	if o != nil {
+		return f.newObject(o), err
+	}
+	return nil, err
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	do := f.Fs.Features().PutStream
+	if do == nil {
+		return nil, errors.New("PutStream not supported")
+	}
+	if err := f.checkWritable(ctx, src.Remote()); err != nil {
+		return nil, err
+	}
+	f.forgetIndex(src.Remote())
+	o, err := do(ctx, in, src, options...)
+	if o != nil {
+		return f.newObject(o), err
+	}
+	return nil, err
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	x, inner, err := f.findArchive(ctx, f.wrappedPath(dir))
+	if err != nil {
+		return err
+	}
+	if x != nil {
+		if e := x.find(inner); e != nil && e.isDir {
+			return nil
+		}
+		return errReadOnly
+	}
+	return f.Fs.Mkdir(ctx, dir)
+}
+
+// MkdirMetadata makes the directory passed in as dir.
+//
+// It shouldn't return an error if it already exists.
+//
+// If the metadata is not nil it is set.
+//
+// It returns the directory that was created.
+func (f *Fs) MkdirMetadata(ctx context.Context, dir string, metadata fs.Metadata) (fs.Directory, error) {
+	do := f.Fs.Features().MkdirMetadata
+	if do == nil {
+		return nil, fs.ErrorNotImplemented
+	}
+	if err := f.checkWritable(ctx, dir); err != nil {
+		return nil, err
+	}
+	return do(ctx, dir, metadata)
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	x, _, err := f.findArchive(ctx, f.wrappedPath(dir))
+	if err != nil {
+		return err
+	}
+	if x != nil {
+		return errReadOnly
+	}
+	return f.Fs.Rmdir(ctx, dir)
+}
+
+// Purge all files in the directory specified
+//
+// Implement this if you have a way of deleting all the files
+// quicker than just running Remove() on the result of List()
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	do := f.Fs.Features().Purge
+	if do == nil {
+		return fs.ErrorCantPurge
+	}
+	x, _, err := f.findArchive(ctx, f.wrappedPath(dir))
+	if err != nil {
+		return err
+	}
+	if x != nil {
+		return errReadOnly
+	}
+	err = do(ctx, dir)
+	f.mu.Lock()
+	for remote := range f.indexes {
+		if dir == "" || remote == dir || strings.HasPrefix(remote, dir+"/") {
+			delete(f.indexes, remote)
+		}
+	}
+	f.mu.Unlock()
+	return err
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Copy
+	if do == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	if err := f.checkWritable(ctx, remote); err != nil {
+		return nil, err
+	}
+	f.forgetIndex(remote)
+	oResult, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult), nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	if err := f.checkWritable(ctx, remote); err != nil {
+		return nil, err
+	}
+	f.forgetIndex(remote)
+	oResult, err := do(ctx, o.Object, remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.Fs.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debugf(srcFs, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	if srcFs.prefix != "" || f.prefix != "" {
+		return errReadOnly
+	}
+	for _, check := range []struct {
+		f      *Fs
+		remote string
+	}{{srcFs, srcRemote}, {f, dstRemote}} {
+		x, _, err := check.f.findArchive(ctx, check.remote)
+		if err != nil {
+			return err
+		}
+		if x != nil {
+			return errReadOnly
+		}
+	}
+	return do(ctx, srcFs.Fs, srcRemote, dstRemote)
+}
+
+// DirSetModTime sets the directory modtime for dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	do := f.Fs.Features().DirSetModTime
+	if do == nil {
+		return fs.ErrorNotImplemented
+	}
+	x, _, err := f.findArchive(ctx, f.wrappedPath(dir))
+	if err != nil {
+		return err
+	}
+	if x != nil {
+		return errReadOnly
+	}
+	return do(ctx, dir, modTime)
+}
+
+// CleanUp the trash in the Fs
+func (f *Fs) CleanUp(ctx context.Context) error {
+	do := f.Fs.Features().CleanUp
+	if do == nil {
+		return errors.New("not supported by underlying remote")
+	}
+	return do(ctx)
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	do := f.Fs.Features().About
+	if do == nil {
+		return nil, errors.New("not supported by underlying remote")
+	}
+	return do(ctx)
+}
+
+// ChangeNotify calls the passed function with a path that has had changes.
+//
+// Changes to an archive are reported as changes to a directory.
+func (f *Fs) ChangeNotify(ctx context.Context, notifyFunc func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	do := f.Fs.Features().ChangeNotify
+	if do == nil {
+		return
+	}
+	wrappedNotifyFunc := func(remote string, entryType fs.EntryType) {
+		if entryType == fs.EntryObject && findFormat(path.Base(remote)) != nil {
+			f.forgetIndex(remote)
+			entryType = fs.EntryDirectory
+		}
+		notifyFunc(remote, entryType)
+	}
+	do(ctx, wrappedNotifyFunc, pollIntervalChan)
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	do := f.Fs.Features().Shutdown
+	if do == nil {
+		return nil
+	}
+	return do(ctx)
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.DirSetModTimer  = (*Fs)(nil)
+	_ fs.MkdirMetadataer = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Wrapper         = (*Fs)(nil)
+	_ fs.ChangeNotifier  = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+)
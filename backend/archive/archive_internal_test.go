@@ -0,0 +1,260 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+)
+
+func TestFindFormat(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"file.zip", "zip"},
+		{"FILE.ZIP", "zip"},
+		{"file.tar", "tar"},
+		{"file.tar.gz", "tar.gz"},
+		{"file.tgz", "tar.gz"},
+		{"file.tar.zst", "tar.zst"},
+		{"file.tar.bz2", "tar.bz2"},
+		{"file.gz", ""},
+		{"file.txt", ""},
+		{".zip", ""},
+		{"zip", ""},
+	} {
+		got := ""
+		if format := findFormat(test.in); format != nil {
+			got = format.name
+		}
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+	}{
+		{"file", "file"},
+		{"dir/", "dir"},
+		{"/dir/file", "dir/file"},
+		{"./dir/file", "dir/file"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"dir\\file", "dir/file"},
+		{"", ""},
+		{"/", ""},
+	} {
+		assert.Equal(t, test.want, cleanName(test.in), test.in)
+	}
+}
+
+// testFile is a file to put in a test archive
+type testFile struct {
+	name    string
+	content string
+}
+
+var (
+	testModTime = time.Date(2020, 1, 2, 3, 4, 6, 0, time.UTC)
+	testFiles   = []testFile{
+		{"hello.txt", "hello world"},
+		{"dir/sub/file.txt", "0123456789abcdefghijklmnopqrstuvwxyz"},
+		{"dir/empty.txt", ""},
+	}
+)
+
+// makeZip makes a zip archive of testFiles, storing the first file
+// and deflating the rest
+func makeZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	_, err := zw.CreateHeader(&zip.FileHeader{Name: "emptydir/", Modified: testModTime})
+	require.NoError(t, err)
+	for i, file := range testFiles {
+		method := zip.Deflate
+		if i == 0 {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: file.name, Method: method, Modified: testModTime})
+		require.NoError(t, err)
+		_, err = w.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// makeTar makes a tar archive of testFiles
+func makeTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "emptydir/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: testModTime}))
+	for _, file := range testFiles {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     file.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(file.content)),
+			ModTime:  testModTime,
+			Uname:    "user",
+		}))
+		_, err := tw.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "hello.txt", ModTime: testModTime}))
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	_, err := gw.Write(makeTar(t))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func makeTarZst(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = zw.Write(makeTar(t))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// listNames returns the sorted names in dir of f with a / on directories
+func listNames(ctx context.Context, t *testing.T, f fs.Fs, dir string) (names []string) {
+	entries, err := f.List(ctx, dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		name := entry.Remote()
+		if _, isDir := entry.(fs.Directory); isDir {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readObject(ctx context.Context, t *testing.T, o fs.Object, options ...fs.OpenOption) string {
+	rc, err := o.Open(ctx, options...)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	return string(data)
+}
+
+func TestArchives(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name string
+		make func(t *testing.T) []byte
+	}{
+		{"test.zip", makeZip},
+		{"test.tar", makeTar},
+		{"test.tar.gz", makeTarGz},
+		{"test.tar.zst", makeTarZst},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.Mkdir(filepath.Join(dir, "drop"), 0777))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "drop", test.name), test.make(t), 0666))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "drop", "plain.txt"), []byte("plain"), 0666))
+			m := configmap.Simple{"remote": dir}
+
+			f, err := NewFs(ctx, "TestArchive", "", m)
+			require.NoError(t, err)
+
+			// The archive shows as a directory
+			assert.Equal(t, []string{"drop/plain.txt", "drop/" + test.name + "/"}, listNames(ctx, t, f, "drop"))
+			archiveDir := "drop/" + test.name
+			assert.Equal(t, []string{archiveDir + "/dir/", archiveDir + "/emptydir/", archiveDir + "/hello.txt"}, listNames(ctx, t, f, archiveDir))
+			assert.Equal(t, []string{archiveDir + "/dir/empty.txt", archiveDir + "/dir/sub/"}, listNames(ctx, t, f, archiveDir+"/dir"))
+			_, err = f.List(ctx, archiveDir+"/notfound")
+			assert.ErrorIs(t, err, fs.ErrorDirNotFound)
+
+			// Read files whole and in parts
+			for _, file := range testFiles {
+				o, err := f.NewObject(ctx, archiveDir+"/"+file.name)
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(file.content)), o.Size())
+				assert.True(t, testModTime.Equal(o.ModTime(ctx)))
+				assert.Equal(t, file.content, readObject(ctx, t, o))
+				if len(file.content) > 10 {
+					assert.Equal(t, file.content[3:8], readObject(ctx, t, o, &fs.RangeOption{Start: 3, End: 7}))
+					assert.Equal(t, file.content[5:], readObject(ctx, t, o, &fs.SeekOption{Offset: 5}))
+					assert.Equal(t, file.content[len(file.content)-4:], readObject(ctx, t, o, &fs.RangeOption{Start: -1, End: 4}))
+				}
+				if test.name == "test.zip" {
+					sum, err := o.Hash(ctx, hash.CRC32)
+					require.NoError(t, err)
+					assert.Equal(t, fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(file.content))), sum)
+				}
+			}
+			_, err = f.NewObject(ctx, archiveDir+"/dir")
+			assert.ErrorIs(t, err, fs.ErrorIsDir)
+			_, err = f.NewObject(ctx, archiveDir)
+			assert.ErrorIs(t, err, fs.ErrorIsDir)
+			_, err = f.NewObject(ctx, archiveDir+"/link")
+			assert.ErrorIs(t, err, fs.ErrorObjectNotFound)
+
+			// Objects outside the archive are passed through
+			o, err := f.NewObject(ctx, "drop/plain.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "plain", readObject(ctx, t, o))
+
+			// Can't write inside the archive
+			src := object.NewStaticObjectInfo(archiveDir+"/new.txt", testModTime, 1, true, nil, nil)
+			_, err = f.Put(ctx, bytes.NewBufferString("x"), src)
+			assert.ErrorIs(t, err, fs.ErrorPermissionDenied)
+			assert.ErrorIs(t, f.Mkdir(ctx, archiveDir+"/newdir"), fs.ErrorPermissionDenied)
+			assert.NoError(t, f.Mkdir(ctx, archiveDir+"/dir"))
+			assert.ErrorIs(t, f.Rmdir(ctx, archiveDir+"/emptydir"), fs.ErrorPermissionDenied)
+
+			// Root inside the archive
+			f2, err := NewFs(ctx, "TestArchive", archiveDir+"/dir", m)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"empty.txt", "sub/"}, listNames(ctx, t, f2, ""))
+			o, err = f2.NewObject(ctx, "sub/file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, testFiles[1].content, readObject(ctx, t, o))
+			_, err = f2.Put(ctx, bytes.NewBufferString("x"), object.NewStaticObjectInfo("new.txt", testModTime, 1, true, nil, nil))
+			assert.ErrorIs(t, err, fs.ErrorPermissionDenied)
+
+			// Root pointing to a file inside the archive
+			f3, err := NewFs(ctx, "TestArchive", archiveDir+"/dir/sub/file.txt", m)
+			assert.Equal(t, fs.ErrorIsFile, err)
+			require.NotNil(t, f3)
+			assert.Equal(t, archiveDir+"/dir/sub", f3.Root())
+			o, err = f3.NewObject(ctx, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, testFiles[1].content, readObject(ctx, t, o))
+		})
+	}
+}
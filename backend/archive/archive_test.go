@@ -0,0 +1,86 @@
+// Test Archive filesystem interface
+package archive_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/backend/archive"
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+// TestIntegration runs integration tests against a concrete remote
+// set by the -remote flag. If the flag is not set, it creates a
+// dynamic archive overlay wrapping a local temporary directory.
+func TestIntegration(t *testing.T) {
+	opt := fstests.Opt{
+		RemoteName: *fstest.RemoteName,
+		NilObject:  (*archive.Object)(nil),
+		UnimplementableFsMethods: []string{
+			"ListR",
+			"ListP",
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"ResumeChunkWriter",
+			"MergeDirs",
+			"DirCacheFlush",
+			"PutUnchecked",
+			"PublicLink",
+			"UserInfo",
+			"Disconnect",
+		},
+		UnimplementableObjectMethods: []string{},
+	}
+	if *fstest.RemoteName == "" {
+		name := "TestArchive"
+		opt.RemoteName = name + ":"
+		tempDir := filepath.Join(os.TempDir(), "rclone-archive-test")
+		opt.ExtraConfig = []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "archive"},
+			{Name: name, Key: "remote", Value: tempDir},
+		}
+		opt.QuickTestOK = true
+	}
+	fstests.Run(t, &opt)
+}
+
+// TestMemory runs integration tests against the memory remote
+func TestMemory(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestArchiveMemory"
+	opt := fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*archive.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "archive"},
+			{Name: name, Key: "remote", Value: ":memory:bucket"},
+		},
+		UnimplementableFsMethods: []string{
+			"ListR",
+			"ListP",
+			"OpenWriterAt",
+			"OpenChunkWriter",
+			"ResumeChunkWriter",
+			"MergeDirs",
+			"DirCacheFlush",
+			"PutUnchecked",
+			"PublicLink",
+			"UserInfo",
+			"Disconnect",
+		},
+		UnimplementableObjectMethods: []string{
+			"MimeType",
+			"GetTier",
+			"SetTier",
+			"ID",
+		},
+		QuickTestOK: true,
+	}
+	fstests.Run(t, &opt)
+}
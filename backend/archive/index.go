@@ -0,0 +1,161 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// blockSize is the size of the reads made when reading the structure
+// of an archive. Reading in blocks means that the many small reads
+// made when parsing headers mostly hit the cache.
+const blockSize = 1024 * 1024
+
+// format describes an archive format the backend understands
+type format struct {
+	name       string   // name of the format
+	extensions []string // file extensions, lower case, including the leading .
+	newIndex   func(ctx context.Context, o fs.Object) (*index, error)
+}
+
+// formats lists the supported archive formats
+var formats = []*format{
+	{name: "zip", extensions: []string{".zip"}, newIndex: newZipIndex},
+	{name: "tar", extensions: []string{".tar"}, newIndex: newTarIndex},
+	{name: "tar.gz", extensions: []string{".tar.gz", ".tgz"}, newIndex: newCompressedTarIndex(gzipDecompressor)},
+	{name: "tar.zst", extensions: []string{".tar.zst", ".tzst"}, newIndex: newCompressedTarIndex(zstdDecompressor)},
+	{name: "tar.bz2", extensions: []string{".tar.bz2", ".tbz2"}, newIndex: newCompressedTarIndex(bzip2Decompressor)},
+}
+
+// findFormat returns the archive format for the file name passed in
+// or nil if it isn't an archive
+func findFormat(name string) *format {
+	name = strings.ToLower(name)
+	for _, format := range formats {
+		for _, ext := range format.extensions {
+			if strings.HasSuffix(name, ext) && len(name) > len(ext) {
+				return format
+			}
+		}
+	}
+	return nil
+}
+
+// entry is a file or directory inside an archive
+type entry struct {
+	name     string    // path inside the archive with no leading or trailing /
+	size     int64     // uncompressed size of the file
+	modTime  time.Time // modification time
+	isDir    bool      // set if this is a directory
+	crc32    string    // CRC-32 as hex if known
+	metadata fs.Metadata
+	// open returns a reader for limit bytes of the file starting
+	// at offset. limit will be -1 to read to the end.
+	open func(ctx context.Context, offset, limit int64) (io.ReadCloser, error)
+}
+
+// index is the in memory table of contents of an archive
+type index struct {
+	src      fs.Object           // the archive itself
+	remote   string              // path of the archive in the wrapped remote
+	entries  map[string]*entry   // all the entries by name
+	children map[string][]*entry // entries by the directory they are in
+}
+
+// newIndex makes an empty index for the archive src
+func newIndex(src fs.Object) *index {
+	return &index{
+		src:      src,
+		entries:  make(map[string]*entry),
+		children: make(map[string][]*entry),
+	}
+}
+
+// cleanName cleans up a name read from an archive so that it can't
+// escape the archive and has no leading or trailing /
+func cleanName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// add inserts e into the index, creating any missing parent
+// directories.
+func (x *index) add(ctx context.Context, e *entry) {
+	e.name = cleanName(e.name)
+	if e.name == "" {
+		return
+	}
+	if existing := x.entries[e.name]; existing != nil {
+		if existing.isDir && e.isDir {
+			// Directories may be implied before they are seen
+			existing.modTime = e.modTime
+			existing.metadata = e.metadata
+			return
+		}
+		fs.Debugf(x.src, "Ignoring duplicate entry %q in archive", e.name)
+		return
+	}
+	x.entries[e.name] = e
+	dir := path.Dir(e.name)
+	if dir == "." {
+		dir = ""
+	}
+	x.children[dir] = append(x.children[dir], e)
+	if dir != "" && x.entries[dir] == nil {
+		x.add(ctx, &entry{
+			name:    dir,
+			modTime: x.src.ModTime(ctx),
+			isDir:   true,
+		})
+	}
+}
+
+// sort the children of each directory so listings are stable
+func (x *index) sort() {
+	for _, children := range x.children {
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].name < children[j].name
+		})
+	}
+}
+
+// find returns the entry for name or nil if not found.
+//
+// The root of the archive is returned as a directory.
+func (x *index) find(name string) *entry {
+	if name == "" {
+		return &entry{isDir: true}
+	}
+	return x.entries[name]
+}
+
+// emptyReader returns an io.ReadCloser with no data
+func emptyReader() io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(nil))
+}
+
+// openRange opens limit bytes of o at offset. If limit is -1 then it
+// reads to the end.
+func openRange(ctx context.Context, o fs.Object, offset, limit int64) (io.ReadCloser, error) {
+	if limit == 0 {
+		return emptyReader(), nil
+	}
+	end := int64(-1)
+	if limit > 0 {
+		end = offset + limit - 1
+	}
+	return o.Open(ctx, &fs.RangeOption{Start: offset, End: end})
+}
+
+// readCloser joins a Reader and a Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
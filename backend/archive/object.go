@@ -0,0 +1,227 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object is an object outside any archive in the wrapped remote
+type Object struct {
+	fs.Object
+	f *Fs
+}
+
+// newObject wraps o
+func (f *Fs) newObject(o fs.Object) *Object {
+	return &Object{
+		Object: o,
+		f:      f,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.Object.String()
+}
+
+// UnWrap returns the wrapped Object
+func (o *Object) UnWrap() fs.Object {
+	return o.Object
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	o.f.forgetIndex(o.Remote())
+	return o.Object.Update(ctx, in, src, options...)
+}
+
+// ID returns the ID of the Object if known, or "" if not
+func (o *Object) ID() string {
+	do, ok := o.Object.(fs.IDer)
+	if !ok {
+		return ""
+	}
+	return do.ID()
+}
+
+// MimeType returns the content type of the Object if
+// known, or "" if not
+func (o *Object) MimeType(ctx context.Context) string {
+	do, ok := o.Object.(fs.MimeTyper)
+	if !ok {
+		return ""
+	}
+	return do.MimeType(ctx)
+}
+
+// GetTier returns storage tier or class of the Object
+func (o *Object) GetTier() string {
+	do, ok := o.Object.(fs.GetTierer)
+	if !ok {
+		return ""
+	}
+	return do.GetTier()
+}
+
+// SetTier performs changing storage tier of the Object if
+// multiple storage classes supported
+func (o *Object) SetTier(tier string) error {
+	do, ok := o.Object.(fs.SetTierer)
+	if !ok {
+		return errors.New("archive: underlying remote does not support SetTier")
+	}
+	return do.SetTier(tier)
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (fs.Metadata, error) {
+	do, ok := o.Object.(fs.Metadataer)
+	if !ok {
+		return nil, nil
+	}
+	return do.Metadata(ctx)
+}
+
+// SetMetadata sets metadata for an Object
+//
+// It should return fs.ErrorNotImplemented if it can't set metadata
+func (o *Object) SetMetadata(ctx context.Context, metadata fs.Metadata) error {
+	do, ok := o.Object.(fs.SetMetadataer)
+	if !ok {
+		return fs.ErrorNotImplemented
+	}
+	return do.SetMetadata(ctx, metadata)
+}
+
+// Member is a file inside an archive
+type Member struct {
+	f      *Fs
+	x      *index // the archive this is in
+	e      *entry // the entry in the archive
+	remote string
+}
+
+// newMember makes a Member for e in x
+func (f *Fs) newMember(x *index, e *entry, remote string) *Member {
+	return &Member{
+		f:      f,
+		x:      x,
+		e:      e,
+		remote: remote,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Member) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Member) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Member) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification time of the file
+func (o *Member) ModTime(ctx context.Context) time.Time {
+	return o.e.modTime
+}
+
+// Size returns the size of the file
+func (o *Member) Size() int64 {
+	return o.e.size
+}
+
+// Hash returns the selected checksum of the file
+//
+// Only the CRC-32 of files in zip archives is known.
+func (o *Member) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht == hash.CRC32 && o.e.crc32 != "" {
+		return o.e.crc32, nil
+	}
+	return "", hash.ErrUnsupported
+}
+
+// Storable returns whether this object is storable
+func (o *Member) Storable() bool {
+	return true
+}
+
+// SetModTime sets the modification time of the file
+func (o *Member) SetModTime(ctx context.Context, t time.Time) error {
+	return errReadOnly
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Member) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.e.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if offset > o.e.size {
+		offset = o.e.size
+	}
+	return o.e.open(ctx, offset, limit)
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Member) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	return errReadOnly
+}
+
+// Remove an object
+func (o *Member) Remove(ctx context.Context) error {
+	return errReadOnly
+}
+
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Member) Metadata(ctx context.Context) (fs.Metadata, error) {
+	if o.e.metadata == nil {
+		return nil, nil
+	}
+	metadata := make(fs.Metadata, len(o.e.metadata))
+	for k, v := range o.e.metadata {
+		metadata[k] = v
+	}
+	return metadata, nil
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.FullObject = (*Object)(nil)
+	_ fs.Object     = (*Member)(nil)
+	_ fs.Metadataer = (*Member)(nil)
+)
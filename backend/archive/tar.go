@@ -0,0 +1,215 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/readers"
+)
+
+// decompressor wraps in with a decompressing reader
+type decompressor func(in io.Reader) (io.ReadCloser, error)
+
+func gzipDecompressor(in io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(in)
+}
+
+func zstdDecompressor(in io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
+
+func bzip2Decompressor(in io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(in)), nil
+}
+
+// tarEntry makes an entry from a tar header, returning nil if the
+// header should be skipped.
+func tarEntry(ctx context.Context, o fs.Object, hdr *tar.Header) *entry {
+	e := &entry{
+		name:    hdr.Name,
+		modTime: hdr.ModTime,
+		metadata: fs.Metadata{
+			"mtime": hdr.ModTime.Format(metadataTimeFormat),
+			"mode":  fmt.Sprintf("%o", hdr.Mode),
+			"uid":   strconv.Itoa(hdr.Uid),
+			"gid":   strconv.Itoa(hdr.Gid),
+		},
+	}
+	if hdr.Uname != "" {
+		e.metadata["uname"] = hdr.Uname
+	}
+	if hdr.Gname != "" {
+		e.metadata["gname"] = hdr.Gname
+	}
+	// Metadata stored by rclone archive create
+	for k, v := range hdr.PAXRecords {
+		if key, found := strings.CutPrefix(k, "RCLONE.meta."); found {
+			e.metadata[key] = v
+		}
+	}
+	if hdr.ModTime.IsZero() {
+		e.modTime = o.ModTime(ctx)
+		delete(e.metadata, "mtime")
+	}
+	switch {
+	case hdr.Typeflag == tar.TypeDir:
+		e.isDir = true
+	case hdr.Typeflag == tar.TypeGNUSparse || isPAXSparse(hdr):
+		fs.Debugf(o, "Skipping %q in archive as sparse files aren't supported", hdr.Name)
+		return nil
+	case hdr.FileInfo().Mode().IsRegular():
+		e.size = hdr.Size
+	default:
+		fs.Debugf(o, "Skipping %q in archive as not a regular file", hdr.Name)
+		return nil
+	}
+	return e
+}
+
+// isPAXSparse returns true if hdr describes a sparse file in PAX format
+func isPAXSparse(hdr *tar.Header) bool {
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// newTarIndex reads the headers of an uncompressed tar file.
+//
+// The contents of the files are skipped over with range requests so
+// only the headers are read. Files can be read directly from the
+// archive at their offset.
+func newTarIndex(ctx context.Context, o fs.Object) (*index, error) {
+	ra := operations.NewReaderAt(ctx, o, blockSize)
+	in := io.NewSectionReader(ra, 0, ra.Size())
+	tr := tar.NewReader(in)
+	x := newIndex(o)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := tarEntry(ctx, o, hdr)
+		if e == nil {
+			continue
+		}
+		if !e.isDir {
+			// tar.Reader doesn't buffer so the data starts here
+			dataOffset, err := in.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			size := e.size
+			e.open = func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+				if limit < 0 || offset+limit > size {
+					limit = size - offset
+				}
+				if limit <= 0 {
+					return emptyReader(), nil
+				}
+				return openRange(ctx, o, dataOffset+offset, limit)
+			}
+		}
+		x.add(ctx, e)
+	}
+	x.sort()
+	return x, nil
+}
+
+// newCompressedTarIndex returns a function to read the headers of a
+// compressed tar file.
+//
+// As the compressed stream can't be seeked the whole archive has to
+// be read to make the index, and reading a file means decompressing
+// the archive from the start up to the file.
+func newCompressedTarIndex(decompress decompressor) func(ctx context.Context, o fs.Object) (*index, error) {
+	return func(ctx context.Context, o fs.Object) (x *index, err error) {
+		rc, err := o.Open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer fs.CheckClose(rc, &err)
+		dec, err := decompress(rc)
+		if err != nil {
+			return nil, err
+		}
+		defer fs.CheckClose(dec, &err)
+		in := readers.NewCountingReader(dec)
+		tr := tar.NewReader(in)
+		x = newIndex(o)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			e := tarEntry(ctx, o, hdr)
+			if e == nil {
+				continue
+			}
+			if !e.isDir {
+				dataOffset := int64(in.BytesRead())
+				size := e.size
+				e.open = func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+					if limit < 0 || offset+limit > size {
+						limit = size - offset
+					}
+					if limit <= 0 {
+						return emptyReader(), nil
+					}
+					rc, err := o.Open(ctx)
+					if err != nil {
+						return nil, err
+					}
+					dec, err := decompress(rc)
+					if err != nil {
+						_ = rc.Close()
+						return nil, err
+					}
+					return skip(readCloser{
+						Reader: dec,
+						Closer: multiCloser{dec, rc},
+					}, dataOffset+offset, limit)
+				}
+			}
+			x.add(ctx, e)
+		}
+		x.sort()
+		return x, nil
+	}
+}
+
+// multiCloser closes all of its members returning the first error
+type multiCloser []io.Closer
+
+// Close all the closers
+func (mc multiCloser) Close() (err error) {
+	for _, c := range mc {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
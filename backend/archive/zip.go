@@ -0,0 +1,115 @@
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// newZipIndex reads the central directory of the zip file o.
+//
+// Only the end of the archive is read to do this. Files stored or
+// compressed with deflate are read straight from the archive with a
+// range request, anything else goes through archive/zip.
+func newZipIndex(ctx context.Context, o fs.Object) (*index, error) {
+	ra := operations.NewReaderAt(ctx, o, blockSize)
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		return nil, err
+	}
+	x := newIndex(o)
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		e := &entry{
+			name:    zf.Name,
+			modTime: zf.Modified,
+			isDir:   mode.IsDir(),
+			metadata: fs.Metadata{
+				"mtime": zf.Modified.Format(metadataTimeFormat),
+				"mode":  fmt.Sprintf("%o", mode&os.ModePerm),
+			},
+		}
+		if zf.Modified.IsZero() {
+			e.modTime = o.ModTime(ctx)
+			delete(e.metadata, "mtime")
+		}
+		if !e.isDir {
+			if !mode.IsRegular() {
+				fs.Debugf(o, "Skipping %q in archive as not a regular file", zf.Name)
+				continue
+			}
+			e.size = int64(zf.UncompressedSize64)
+			e.crc32 = fmt.Sprintf("%08x", zf.CRC32)
+			if zf.Comment != "" {
+				e.metadata["comment"] = zf.Comment
+			}
+			e.open = zipOpener(o, zf)
+		}
+		x.add(ctx, e)
+	}
+	x.sort()
+	return x, nil
+}
+
+// zipOpener returns the function to open zf within o
+func zipOpener(o fs.Object, zf *zip.File) func(ctx context.Context, offset, limit int64) (io.ReadCloser, error) {
+	return func(ctx context.Context, offset, limit int64) (rc io.ReadCloser, err error) {
+		size := int64(zf.UncompressedSize64)
+		if limit < 0 || offset+limit > size {
+			limit = size - offset
+		}
+		if limit <= 0 {
+			return emptyReader(), nil
+		}
+		switch zf.Method {
+		case zip.Store, zip.Deflate:
+		default:
+			// Let archive/zip deal with anything unusual
+			rc, err = zf.Open()
+			if err != nil {
+				return nil, err
+			}
+			return skip(rc, offset, limit)
+		}
+		dataOffset, err := zf.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find data for %q: %w", zf.Name, err)
+		}
+		if zf.Method == zip.Store {
+			return openRange(ctx, o, dataOffset+offset, limit)
+		}
+		in, err := openRange(ctx, o, dataOffset, int64(zf.CompressedSize64))
+		if err != nil {
+			return nil, err
+		}
+		return skip(readCloser{
+			Reader: flate.NewReader(in),
+			Closer: in,
+		}, offset, limit)
+	}
+}
+
+// skip discards offset bytes from rc then returns a reader for the
+// next limit bytes
+func skip(rc io.ReadCloser, offset, limit int64) (io.ReadCloser, error) {
+	if offset > 0 {
+		_, err := io.CopyN(io.Discard, rc, offset)
+		if err != nil {
+			_ = rc.Close()
+			return nil, fmt.Errorf("failed to seek to %d: %w", offset, err)
+		}
+	}
+	if limit >= 0 {
+		return readCloser{
+			Reader: io.LimitReader(rc, limit),
+			Closer: rc,
+		}, nil
+	}
+	return rc, nil
+}
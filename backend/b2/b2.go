@@ -65,6 +65,28 @@ const (
 	defaultMaxAge       = 24 * time.Hour
 )
 
+// systemMetadataInfo describes the system metadata read from B2
+var systemMetadataInfo = map[string]fs.MetadataHelp{
+	"btime": {
+		Help:     "Time of file birth (creation) read from the upload timestamp",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999Z07:00",
+		ReadOnly: true,
+	},
+	"content-type": {
+		Help:     "The MIME type of the file.",
+		Type:     "string",
+		Example:  "text/plain",
+		ReadOnly: true,
+	},
+	"mtime": {
+		Help:     "Time of last modification, read from rclone metadata",
+		Type:     "RFC 3339",
+		Example:  "2006-01-02T15:04:05.999Z07:00",
+		ReadOnly: true,
+	},
+}
+
 // Globals
 var (
 	errNotWithVersions  = errors.New("can't modify or delete files in --b2-versions mode")
@@ -78,6 +100,10 @@ func init() {
 		Description: "Backblaze B2",
 		NewFs:       NewFs,
 		CommandHelp: commandHelp,
+		MetadataInfo: &fs.MetadataInfo{
+			System: systemMetadataInfo,
+			Help:   `B2 doesn't support user metadata. The system metadata can only be read.`,
+		},
 		Options: []fs.Option{{
 			Name:      "account",
 			Help:      "Account ID or Application Key ID.",
@@ -305,6 +331,7 @@ type Object struct {
 	remote   string    // The remote path
 	id       string    // b2 id of the file
 	modTime  time.Time // The modified time of the object if known
+	uploaded time.Time // When the object was uploaded if known
 	sha1     string    // SHA-1 hash if known
 	size     int64     // Size of the object
 	mimeType string    // Content-Type of the object
@@ -521,6 +548,7 @@ func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, e
 	f.features = (&fs.Features{
 		ReadMimeType:          true,
 		WriteMimeType:         true,
+		ReadMetadata:          true,
 		BucketBased:           true,
 		BucketBasedRootOK:     true,
 		ChunkWriterDoesntSeek: true,
@@ -1626,8 +1654,9 @@ func (o *Object) decodeMetaDataRaw(ID, SHA1 string, Size int64, UploadTimestamp
 	}
 	o.sha1 = cleanSHA1(o.sha1)
 	o.size = Size
+	o.uploaded = time.Time(UploadTimestamp)
 	// Use the UploadTimestamp if can't get file info
-	o.modTime = time.Time(UploadTimestamp)
+	o.modTime = o.uploaded
 	err = o.parseTimeString(Info[timeKey])
 	if err != nil {
 		return err
@@ -2257,6 +2286,23 @@ func (o *Object) MimeType(ctx context.Context) string {
 	return o.mimeType
 }
 
+// Metadata returns metadata for an object
+//
+// It should return nil if there is no Metadata
+func (o *Object) Metadata(ctx context.Context) (metadata fs.Metadata, err error) {
+	metadata = make(fs.Metadata, 3)
+	if !o.uploaded.IsZero() {
+		metadata["btime"] = o.uploaded.Format(time.RFC3339Nano)
+	}
+	if !o.modTime.IsZero() {
+		metadata["mtime"] = o.modTime.Format(time.RFC3339Nano)
+	}
+	if o.mimeType != "" {
+		metadata["content-type"] = o.mimeType
+	}
+	return metadata, nil
+}
+
 // ID returns the ID of the Object if known, or "" if not
 func (o *Object) ID() string {
 	return o.id
@@ -2470,5 +2516,6 @@ var (
 	_ fs.Commander       = &Fs{}
 	_ fs.Object          = &Object{}
 	_ fs.MimeTyper       = &Object{}
+	_ fs.Metadataer      = &Object{}
 	_ fs.IDer            = &Object{}
 )
@@ -0,0 +1,788 @@
+// Package cdc implements a backend which deduplicates the data stored
+// on another remote using content defined chunking.
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/walk"
+	libcache "github.com/rclone/rclone/lib/cache"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "cdc",
+		Description: "Deduplicate a remote with content defined chunking",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name:     "remote",
+			Help:     "Remote to store the deduplicated data in.\n\nNormally should contain a ':' and a path, e.g. \"myremote:path/to/dir\",\n\"myremote:bucket\" or maybe \"myremote:\" (not recommended).",
+			Required: true,
+		}, {
+			Name: "min_chunk_size",
+			Help: `Minimum size of a chunk.
+
+Chunk boundaries are never placed closer together than this except at
+the end of a file.
+
+Changing any of the chunk sizes changes where files are split so new
+uploads won't share chunks with files uploaded before the change.`,
+			Default:  fs.SizeSuffix(256 * 1024),
+			Advanced: true,
+		}, {
+			Name: "avg_chunk_size",
+			Help: `Average size of a chunk.
+
+Smaller chunks find more duplicate data but need more objects to be
+stored and bigger manifests.`,
+			Default:  fs.SizeSuffix(1024 * 1024),
+			Advanced: true,
+		}, {
+			Name:     "max_chunk_size",
+			Help:     `Maximum size of a chunk.`,
+			Default:  fs.SizeSuffix(4 * 1024 * 1024),
+			Advanced: true,
+		}, {
+			Name: "cleanup_min_age",
+			Help: `Only delete unreferenced chunks older than this in cleanup.
+
+This stops "rclone cleanup" deleting the chunks of a file which is
+being uploaded while it runs, as its manifest is only written once
+all its chunks are stored.
+
+Existing chunks which are reused by an upload have their modification
+time refreshed if they are older than half of this, so uploads of a
+single file should take less than half of this time.`,
+			Default:  fs.Duration(time.Hour),
+			Advanced: true,
+		}},
+	})
+}
+
+// Options defines the configuration for this backend
+type Options struct {
+	Remote        string        `config:"remote"`
+	MinChunkSize  fs.SizeSuffix `config:"min_chunk_size"`
+	AvgChunkSize  fs.SizeSuffix `config:"avg_chunk_size"`
+	MaxChunkSize  fs.SizeSuffix `config:"max_chunk_size"`
+	CleanupMinAge fs.Duration   `config:"cleanup_min_age"`
+}
+
+// Fs represents a wrapped fs.Fs
+//
+// The manifests are stored in Fs at the paths of the files they
+// describe and the chunks are stored in base, the root of the remote.
+type Fs struct {
+	fs.Fs
+	base      fs.Fs // the root of the remote where the chunks are
+	wrapper   fs.Fs
+	name      string
+	root      string
+	opt       Options
+	features  *fs.Features    // optional features
+	manifests *libcache.Cache // *dirManifests for each directory listed
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(ctx context.Context, name, root string, m configmap.Mapper) (fs.Fs, error) {
+	// Parse config into Options struct
+	opt := new(Options)
+	err := configstruct.Set(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(opt.Remote, name+":") {
+		return nil, errors.New("can't point cdc remote at itself - check the value of the remote setting")
+	}
+	minSize, avgSize, maxSize := int(opt.MinChunkSize), int(opt.AvgChunkSize), int(opt.MaxChunkSize)
+	if minSize <= 0 || minSize >= avgSize || avgSize >= maxSize {
+		return nil, errors.New("chunk sizes must satisfy 0 < min_chunk_size < avg_chunk_size < max_chunk_size")
+	}
+	root = strings.Trim(root, "/")
+
+	f := &Fs{
+		name:      name,
+		root:      root,
+		opt:       *opt,
+		manifests: libcache.New(),
+	}
+	f.base, err = cache.Get(ctx, opt.Remote)
+	if err != nil && err != fs.ErrorIsFile {
+		return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+	}
+	if err == fs.ErrorIsFile {
+		return nil, fmt.Errorf("remote %q to wrap must be a directory", opt.Remote)
+	}
+
+	// Check to see if the root points to a manifest
+	isFile := false
+	if root != "" {
+		parent := path.Dir(root)
+		if parent == "." {
+			parent = ""
+		}
+		parentFs, err := cache.Get(ctx, fspath.JoinRootPath(opt.Remote, parent))
+		if err != nil && err != fs.ErrorIsFile {
+			return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+		}
+		if err == nil {
+			_, err = findManifest(ctx, parentFs, path.Base(root))
+			if err == nil {
+				isFile = true
+				f.root = parent
+				f.Fs = parentFs
+			} else if err != fs.ErrorObjectNotFound {
+				return nil, err
+			}
+		}
+	}
+	if !isFile {
+		f.Fs, err = cache.Get(ctx, fspath.JoinRootPath(opt.Remote, root))
+		if err != nil && err != fs.ErrorIsFile {
+			return nil, fmt.Errorf("failed to make remote %q to wrap: %w", opt.Remote, err)
+		}
+	}
+	cache.PinUntilFinalized(f.Fs, f)
+
+	// the features here are ones we could support, and they are
+	// ANDed with the ones from the wrapped Fs
+	f.features = (&fs.Features{
+		CaseInsensitive:          true,
+		DuplicateFiles:           false,
+		CanHaveEmptyDirectories:  true,
+		ReadDirMetadata:          true,
+		WriteDirMetadata:         true,
+		UserDirMetadata:          true,
+		WriteDirSetModTime:       true,
+		DirModTimeUpdatesOnWrite: true,
+	}).Fill(ctx, f).Mask(ctx, f.Fs).WrapsFs(f, f.Fs)
+	// Uploads are split into chunks so the size is never needed
+	f.features.PutStream = f.PutStream
+
+	if isFile {
+		return f, fs.ErrorIsFile
+	}
+	return f, nil
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// Features returns the optional features of this Fs
+func (f *Fs) Features() *fs.Features {
+	return f.features
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("cdc:%s:%s", f.name, f.root)
+}
+
+// Hashes returns the supported hash sets.
+//
+// These are calculated on upload and stored in the manifest.
+func (f *Fs) Hashes() hash.Set {
+	return hash.NewHashSet(hash.MD5, hash.SHA1)
+}
+
+// isChunkDir returns true if dir in the wrapped Fs is where the chunks
+// are stored
+func (f *Fs) isChunkDir(dir string) bool {
+	return f.root == "" && dir == chunkDir
+}
+
+// List the objects and directories in dir into entries.  The
+// entries can be returned in any order but should be for a
+// complete directory.
+//
+// dir should be "" to list the root, and should not have
+// trailing slashes.
+//
+// This should return ErrDirNotFound if the directory isn't
+// found.
+func (f *Fs) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
+	if f.isChunkDir(dir) {
+		return nil, fs.ErrorDirNotFound
+	}
+	baseEntries, err := f.Fs.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	f.cacheManifests(ctx, dir, baseEntries)
+	entries = make(fs.DirEntries, 0, len(baseEntries))
+	for _, entry := range baseEntries {
+		switch x := entry.(type) {
+		case fs.Object:
+			remote, size, ok := parseManifestName(x.Remote())
+			if !ok {
+				fs.Debugf(x, "Ignoring file which isn't a manifest")
+				continue
+			}
+			entries = append(entries, f.newObject(x, remote, size))
+		case fs.Directory:
+			if f.isChunkDir(x.Remote()) {
+				continue
+			}
+			entries = append(entries, x)
+		default:
+			return nil, fmt.Errorf("unknown object type %T", entry)
+		}
+	}
+	return entries, nil
+}
+
+// manifestCacheTime is how long the manifests found in a directory
+// listing are used for before the directory is listed again
+const manifestCacheTime = 10 * time.Second
+
+// dirManifests are the manifests found in a directory listing
+type dirManifests struct {
+	listed    time.Time
+	manifests map[string]fs.Object // newest manifest for each file
+}
+
+// parentDir returns the directory containing remote
+func parentDir(remote string) string {
+	dir := path.Dir(remote)
+	if dir == "." {
+		dir = ""
+	}
+	return dir
+}
+
+// newestManifests returns the manifest for each file in entries.
+//
+// If there is more than one manifest for a file, which can happen if
+// an upload was interrupted, the newest is used.
+func newestManifests(ctx context.Context, entries fs.DirEntries) map[string]fs.Object {
+	manifests := make(map[string]fs.Object)
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok {
+			continue
+		}
+		name, _, ok := parseManifestName(o.Remote())
+		if !ok {
+			continue
+		}
+		if manifest := manifests[name]; manifest == nil || o.ModTime(ctx).After(manifest.ModTime(ctx)) {
+			manifests[name] = o
+		}
+	}
+	return manifests
+}
+
+// findManifest finds the manifest for remote in the wrapped Fs.
+//
+// The size of the file is part of the name of the manifest so the
+// directory has to be listed to find it.
+func findManifest(ctx context.Context, wrapped fs.Fs, remote string) (manifest fs.Object, err error) {
+	entries, err := wrapped.List(ctx, parentDir(remote))
+	if err == fs.ErrorDirNotFound {
+		return nil, fs.ErrorObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest = newestManifests(ctx, entries)[remote]
+	if manifest == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return manifest, nil
+}
+
+// cacheManifests remembers the manifests in the listing of dir
+func (f *Fs) cacheManifests(ctx context.Context, dir string, entries fs.DirEntries) *dirManifests {
+	dm := &dirManifests{
+		listed:    time.Now(),
+		manifests: newestManifests(ctx, entries),
+	}
+	f.manifests.Put(dir, dm)
+	return dm
+}
+
+// invalidate forgets the cached manifests of the directory containing
+// remote
+func (f *Fs) invalidate(remote string) {
+	f.manifests.Delete(parentDir(remote))
+}
+
+// findManifest finds the manifest for remote in f.Fs.
+//
+// The listing of the directory is cached for a short time so looking
+// up every file in a directory only lists it once.
+func (f *Fs) findManifest(ctx context.Context, remote string) (fs.Object, error) {
+	dir := parentDir(remote)
+	value, found := f.manifests.GetMaybe(dir)
+	dm, _ := value.(*dirManifests)
+	if !found || dm == nil || time.Since(dm.listed) > manifestCacheTime {
+		entries, err := f.Fs.List(ctx, dir)
+		if err != nil && err != fs.ErrorDirNotFound {
+			return nil, err
+		}
+		dm = f.cacheManifests(ctx, dir, entries)
+	}
+	manifest := dm.manifests[remote]
+	if manifest == nil {
+		return nil, fs.ErrorObjectNotFound
+	}
+	return manifest, nil
+}
+
+// NewObject finds the Object at remote.  If it can't be found
+// it returns the error fs.ErrorObjectNotFound.
+func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	o, err := f.findManifest(ctx, remote)
+	if err != nil {
+		return nil, err
+	}
+	_, size, _ := parseManifestName(o.Remote())
+	return f.newObject(o, remote, size), nil
+}
+
+// putChunk uploads the chunk with the hash given unless it is
+// already stored
+//
+// A stored chunk may be unreferenced, so its modification time is
+// refreshed if needed to stop a concurrent CleanUp deleting it before
+// the manifest which uses it is written.
+func (f *Fs) putChunk(ctx context.Context, hashStr string, chunk []byte) error {
+	remote := chunkPath(hashStr)
+	o, err := f.base.NewObject(ctx, remote)
+	if err == nil {
+		if time.Since(o.ModTime(ctx)) < time.Duration(f.opt.CleanupMinAge)/2 {
+			return nil
+		}
+		err = o.SetModTime(ctx, time.Now())
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, fs.ErrorCantSetModTime) && !errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			return fmt.Errorf("failed to refresh chunk: %w", err)
+		}
+		// Upload the chunk again to refresh it instead
+	} else if !errors.Is(err, fs.ErrorObjectNotFound) && !errors.Is(err, fs.ErrorDirNotFound) {
+		return fmt.Errorf("failed to check for chunk: %w", err)
+	}
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(chunk)), true, nil, f.base)
+	_, err = f.base.Put(ctx, bytes.NewReader(chunk), info)
+	if err != nil {
+		return fmt.Errorf("failed to upload chunk: %w", err)
+	}
+	return nil
+}
+
+// upload splits in into chunks, uploads the ones which aren't already
+// stored and then writes the manifest for remote.
+//
+// If old is set it is the manifest being replaced and it is removed if
+// the new manifest has a different name.
+func (f *Fs) upload(ctx context.Context, in io.Reader, src fs.ObjectInfo, remote string, old fs.Object, options ...fs.OpenOption) (*Object, error) {
+	hasher, err := hash.NewMultiHasherTypes(f.Hashes())
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSplitter(io.TeeReader(in, hasher), int(f.opt.MinChunkSize), int(f.opt.AvgChunkSize), int(f.opt.MaxChunkSize))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{Version: manifestVersion}
+	for {
+		chunk, err := s.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(chunk)
+		hashStr := hex.EncodeToString(sum[:])
+		if err = f.putChunk(ctx, hashStr, chunk); err != nil {
+			return nil, err
+		}
+		m.Chunks = append(m.Chunks, manifestChunk{Hash: hashStr, Size: int64(len(chunk))})
+		m.Size += int64(len(chunk))
+	}
+	if src.Size() >= 0 && src.Size() != m.Size {
+		return nil, fmt.Errorf("upload failed: expecting %d bytes but read %d", src.Size(), m.Size)
+	}
+	sums := hasher.Sums()
+	m.MD5 = sums[hash.MD5]
+	m.SHA1 = sums[hash.SHA1]
+
+	// Write the manifest last so the file only appears once all its
+	// chunks are stored
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	name := makeManifestName(remote, m.Size)
+	info := object.NewStaticObjectInfo(name, src.ModTime(ctx), int64(len(data)), true, nil, f.Fs)
+	mo, err := f.Fs.Put(ctx, bytes.NewReader(data), info, options...)
+	f.invalidate(remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	if old != nil && old.Remote() != mo.Remote() {
+		if err = old.Remove(ctx); err != nil {
+			return nil, fmt.Errorf("failed to remove old manifest: %w", err)
+		}
+	}
+	o := f.newObject(mo, remote, m.Size)
+	o.manifest = m
+	return o, nil
+}
+
+// put uploads in to src.Remote() replacing any existing file
+func (f *Fs) put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	old, err := f.findManifest(ctx, src.Remote())
+	if err != nil && err != fs.ErrorObjectNotFound {
+		return nil, err
+	}
+	return f.upload(ctx, in, src, src.Remote(), old, options...)
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, in, src, options...)
+}
+
+// PutStream uploads to the remote path with the modTime given of indeterminate size
+func (f *Fs) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	return f.put(ctx, in, src, options...)
+}
+
+// Mkdir makes the directory (container, bucket)
+//
+// Shouldn't return an error if it already exists
+func (f *Fs) Mkdir(ctx context.Context, dir string) error {
+	return f.Fs.Mkdir(ctx, dir)
+}
+
+// MkdirMetadata makes the root directory of the Fs object
+func (f *Fs) MkdirMetadata(ctx context.Context, dir string, metadata fs.Metadata) (fs.Directory, error) {
+	if do := f.Fs.Features().MkdirMetadata; do != nil {
+		return do(ctx, dir, metadata)
+	}
+	return nil, fs.ErrorNotImplemented
+}
+
+// Rmdir removes the directory (container, bucket) if empty
+//
+// Return an error if it doesn't exist or isn't empty
+func (f *Fs) Rmdir(ctx context.Context, dir string) error {
+	err := f.Fs.Rmdir(ctx, dir)
+	f.manifests.Delete(dir)
+	return err
+}
+
+// Purge all files in the directory
+//
+// The chunks are left behind to be removed by CleanUp as other files
+// may use them.
+func (f *Fs) Purge(ctx context.Context, dir string) error {
+	if f.root == "" && dir == "" {
+		// Purging the root would remove the chunks of all the
+		// files outside it too
+		return fs.ErrorCantPurge
+	}
+	if do := f.Fs.Features().Purge; do != nil {
+		err := do(ctx, dir)
+		f.manifests.DeletePrefix(dir)
+		return err
+	}
+	return fs.ErrorCantPurge
+}
+
+// sameStore returns true if src stores its chunks in the same place
+// as f so its manifests can be copied or moved server-side.
+func (f *Fs) sameStore(src *Fs) bool {
+	return fs.ConfigString(f.base) == fs.ConfigString(src.base)
+}
+
+// prepareDst removes any existing manifest for remote so it can be
+// replaced by a server-side copy or move. The name of the new manifest
+// may differ as it contains the size.
+func (f *Fs) prepareDst(ctx context.Context, remote string) error {
+	old, err := f.findManifest(ctx, remote)
+	if err == fs.ErrorObjectNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = old.Remove(ctx)
+	f.invalidate(remote)
+	return err
+}
+
+// Copy src to this remote using server-side copy operations.
+//
+// Only the manifest is copied as the chunks are shared.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Copy
+	if do == nil {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok || !f.sameStore(o.f) {
+		return nil, fs.ErrorCantCopy
+	}
+	if err := f.prepareDst(ctx, remote); err != nil {
+		return nil, err
+	}
+	mo, err := do(ctx, o.o, makeManifestName(remote, o.size))
+	f.invalidate(remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(mo, remote, o.size), nil
+}
+
+// Move src to this remote using server-side move operations.
+//
+// Only the manifest is moved as the chunks are shared.
+//
+// This is stored with the remote path given.
+//
+// It returns the destination Object and a possible error.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
+	do := f.Fs.Features().Move
+	if do == nil {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok || !f.sameStore(o.f) {
+		return nil, fs.ErrorCantMove
+	}
+	if err := f.prepareDst(ctx, remote); err != nil {
+		return nil, err
+	}
+	mo, err := do(ctx, o.o, makeManifestName(remote, o.size))
+	f.invalidate(remote)
+	o.f.invalidate(o.remote)
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(mo, remote, o.size), nil
+}
+
+// DirMove moves src, srcRemote to this remote at dstRemote
+// using server-side move operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	do := f.Fs.Features().DirMove
+	if do == nil {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok || !f.sameStore(srcFs) {
+		fs.Debugf(srcFs, "Can't move directory - not same chunk store")
+		return fs.ErrorCantDirMove
+	}
+	if srcFs.isChunkDir(srcRemote) || f.isChunkDir(dstRemote) {
+		return fs.ErrorCantDirMove
+	}
+	err := do(ctx, srcFs.Fs, srcRemote, dstRemote)
+	srcFs.manifests.DeletePrefix(srcRemote)
+	f.manifests.DeletePrefix(dstRemote)
+	return err
+}
+
+// DirSetModTime sets the directory modtime for dir
+func (f *Fs) DirSetModTime(ctx context.Context, dir string, modTime time.Time) error {
+	if do := f.Fs.Features().DirSetModTime; do != nil {
+		return do(ctx, dir, modTime)
+	}
+	return fs.ErrorNotImplemented
+}
+
+// chunkRefs reads all the manifests in the remote and returns the
+// number of references to each chunk.
+func (f *Fs) chunkRefs(ctx context.Context) (refs map[string]int, err error) {
+	refs = make(map[string]int)
+	err = walk.Walk(ctx, f.base, "", true, -1, func(dirPath string, entries fs.DirEntries, err error) error {
+		if err != nil {
+			return err
+		}
+		if dirPath == chunkDir {
+			return walk.ErrorSkipDir
+		}
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			if _, _, ok := parseManifestName(path.Base(o.Remote())); !ok {
+				continue
+			}
+			m, err := readManifest(ctx, o)
+			if err != nil {
+				// Stop rather than delete chunks this manifest uses
+				return fmt.Errorf("failed to read manifest %q: %w", o.Remote(), err)
+			}
+			for _, chunk := range m.Chunks {
+				refs[chunk.Hash]++
+			}
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// CleanUp removes the chunks which are no longer used by any file.
+//
+// The references to the chunks are counted from all the manifests in
+// the remote, not just the ones under the root, as the chunks are
+// shared between them all.
+func (f *Fs) CleanUp(ctx context.Context) error {
+	refs, err := f.chunkRefs(ctx)
+	if err != nil {
+		return err
+	}
+	fs.Debugf(f, "Found %d referenced chunks", len(refs))
+	var removed, kept int
+	var removedBytes int64
+	err = walk.ListR(ctx, f.base, chunkDir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			if refs[path.Base(o.Remote())] > 0 {
+				continue
+			}
+			if time.Since(o.ModTime(ctx)) < time.Duration(f.opt.CleanupMinAge) {
+				kept++
+				continue
+			}
+			// Read the chunk again in case an upload has
+			// refreshed it since it was listed
+			o, err := f.base.NewObject(ctx, o.Remote())
+			if errors.Is(err, fs.ErrorObjectNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to check chunk: %w", err)
+			}
+			if time.Since(o.ModTime(ctx)) < time.Duration(f.opt.CleanupMinAge) {
+				kept++
+				continue
+			}
+			if err := o.Remove(ctx); err != nil {
+				return fmt.Errorf("failed to remove chunk: %w", err)
+			}
+			removed++
+			removedBytes += o.Size()
+		}
+		return nil
+	})
+	if err == fs.ErrorDirNotFound {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	fs.Infof(f, "Removed %d unreferenced chunks (%v)", removed, fs.SizeSuffix(removedBytes))
+	if kept > 0 {
+		fs.Infof(f, "Kept %d unreferenced chunks newer than %v", kept, f.opt.CleanupMinAge)
+	}
+	return nil
+}
+
+// About gets quota information from the Fs
+func (f *Fs) About(ctx context.Context) (*fs.Usage, error) {
+	if do := f.Fs.Features().About; do != nil {
+		return do(ctx)
+	}
+	return nil, errors.New("not supported by underlying remote")
+}
+
+// Shutdown the backend, closing any background tasks and any
+// cached connections.
+func (f *Fs) Shutdown(ctx context.Context) error {
+	if do := f.Fs.Features().Shutdown; do != nil {
+		return do(ctx)
+	}
+	return nil
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.Fs
+}
+
+// WrapFs returns the Fs that is wrapping this Fs
+func (f *Fs) WrapFs() fs.Fs {
+	return f.wrapper
+}
+
+// SetWrapper sets the Fs that is wrapping this Fs
+func (f *Fs) SetWrapper(wrapper fs.Fs) {
+	f.wrapper = wrapper
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs              = (*Fs)(nil)
+	_ fs.Purger          = (*Fs)(nil)
+	_ fs.Copier          = (*Fs)(nil)
+	_ fs.Mover           = (*Fs)(nil)
+	_ fs.DirMover        = (*Fs)(nil)
+	_ fs.PutStreamer     = (*Fs)(nil)
+	_ fs.CleanUpper      = (*Fs)(nil)
+	_ fs.Abouter         = (*Fs)(nil)
+	_ fs.Shutdowner      = (*Fs)(nil)
+	_ fs.UnWrapper       = (*Fs)(nil)
+	_ fs.Wrapper         = (*Fs)(nil)
+	_ fs.DirSetModTimer  = (*Fs)(nil)
+	_ fs.MkdirMetadataer = (*Fs)(nil)
+	_ fs.Object          = (*Object)(nil)
+	_ fs.ObjectUnWrapper = (*Object)(nil)
+)
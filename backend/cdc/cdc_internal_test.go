@@ -0,0 +1,200 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// randomData returns n bytes of repeatable random data
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	_, _ = rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// split returns the sizes of the chunks data is split into
+func split(t *testing.T, data []byte, minSize, avgSize, maxSize int) (sizes []int) {
+	s, err := newSplitter(bytes.NewReader(data), minSize, avgSize, maxSize)
+	require.NoError(t, err)
+	for {
+		chunk, err := s.next()
+		if err == io.EOF {
+			return sizes
+		}
+		require.NoError(t, err)
+		sizes = append(sizes, len(chunk))
+	}
+}
+
+func TestSplitter(t *testing.T) {
+	_, err := newSplitter(nil, 100, 100, 1000)
+	assert.Error(t, err)
+
+	assert.Nil(t, split(t, nil, 64, 256, 1024))
+	assert.Equal(t, []int{10}, split(t, make([]byte, 10), 64, 256, 1024))
+
+	// Data with no boundaries is cut at the max size
+	assert.Equal(t, []int{1024, 1024, 100}, split(t, make([]byte, 2148), 64, 256, 1024))
+
+	data := randomData(1, 1<<20)
+	sizes := split(t, data, 1024, 4096, 16384)
+	total := 0
+	for i, size := range sizes {
+		total += size
+		assert.LessOrEqual(t, size, 16384)
+		if i < len(sizes)-1 {
+			assert.GreaterOrEqual(t, size, 1024)
+		}
+	}
+	assert.Equal(t, len(data), total)
+	avg := total / len(sizes)
+	assert.True(t, avg > 2048 && avg < 8192, "average chunk size %d", avg)
+
+	// Inserting data near the start only changes the first chunks
+	edited := append(append(append([]byte{}, data[:5000]...), "hello"...), data[5000:]...)
+	editedSizes := split(t, edited, 1024, 4096, 16384)
+	assert.Equal(t, sizes[len(sizes)-10:], editedSizes[len(editedSizes)-10:])
+}
+
+func TestManifestName(t *testing.T) {
+	for _, test := range []struct {
+		remote string
+		size   int64
+	}{
+		{"file.txt", 0},
+		{"dir/file.txt", 1},
+		{"file.tar.cdc", 1 << 40},
+	} {
+		name := makeManifestName(test.remote, test.size)
+		remote, size, ok := parseManifestName(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, test.remote, remote)
+		assert.Equal(t, test.size, size)
+	}
+	for _, name := range []string{"file.txt", "file.cdc", "file.AAAAAAAAAA.cdc", "file.________.cdc", "file.__________8.cdc"} {
+		_, _, ok := parseManifestName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+// countChunks returns the number of chunks stored in f
+func countChunks(ctx context.Context, t *testing.T, f *Fs) int {
+	n := 0
+	err := operations.ListFn(ctx, f.base, func(o fs.Object) {
+		if _, _, ok := parseManifestName(o.Remote()); !ok {
+			n++
+		}
+	})
+	require.NoError(t, err)
+	return n
+}
+
+func TestDedupeAndCleanUp(t *testing.T) {
+	ctx := context.Background()
+	fi, err := NewFs(ctx, "TestCdcInternal", "", configmap.Simple{
+		"remote":          ":memory:cdc-internal",
+		"min_chunk_size":  "1Ki",
+		"avg_chunk_size":  "4Ki",
+		"max_chunk_size":  "16Ki",
+		"cleanup_min_age": "0s",
+	})
+	require.NoError(t, err)
+	f := fi.(*Fs)
+	t1 := fstest.Time("2001-02-03T04:05:06Z")
+
+	put := func(remote string, data []byte) fs.Object {
+		src := object.NewStaticObjectInfo(remote, t1, int64(len(data)), true, nil, nil)
+		o, err := f.Put(ctx, bytes.NewReader(data), src)
+		require.NoError(t, err)
+		return o
+	}
+
+	data := randomData(2, 256*1024)
+	put("a.bin", data)
+	chunks := countChunks(ctx, t, f)
+	assert.Greater(t, chunks, 10)
+
+	// A copy of the data needs no more chunks
+	put("dir/b.bin", data)
+	assert.Equal(t, chunks, countChunks(ctx, t, f))
+
+	// A small edit only needs a few more chunks
+	edited := append([]byte{}, data...)
+	copy(edited[100000:], "hello world")
+	o := put("a.bin", edited)
+	editedChunks := countChunks(ctx, t, f)
+	assert.Greater(t, editedChunks, chunks)
+	assert.Less(t, editedChunks, chunks+4)
+
+	// Read back with a range over several chunks
+	rc, err := o.Open(ctx, &fs.RangeOption{Start: 90000, End: 150000})
+	require.NoError(t, err)
+	got, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, edited[90000:150001], got)
+
+	// Nothing is referenced by a single file so cleanup keeps everything
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, editedChunks, countChunks(ctx, t, f))
+
+	// Removing the copy leaves the old chunks unreferenced
+	b, err := f.NewObject(ctx, "dir/b.bin")
+	require.NoError(t, err)
+	require.NoError(t, b.Remove(ctx))
+	require.NoError(t, f.CleanUp(ctx))
+	assert.Equal(t, chunks, countChunks(ctx, t, f))
+
+	// The remaining file can still be read
+	o, err = f.NewObject(ctx, "a.bin")
+	require.NoError(t, err)
+	rc, err = o.Open(ctx)
+	require.NoError(t, err)
+	got, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	assert.Equal(t, edited, got)
+}
+
+func TestReusedChunkRefreshed(t *testing.T) {
+	ctx := context.Background()
+	fi, err := NewFs(ctx, "TestCdcInternalRefresh", "", configmap.Simple{
+		"remote":          ":memory:cdc-internal-refresh",
+		"min_chunk_size":  "1Ki",
+		"avg_chunk_size":  "4Ki",
+		"max_chunk_size":  "16Ki",
+		"cleanup_min_age": "1h",
+	})
+	require.NoError(t, err)
+	f := fi.(*Fs)
+
+	// An old chunk which isn't referenced by any manifest
+	chunk := []byte("chunk data")
+	require.NoError(t, f.putChunk(ctx, "0123", chunk))
+	o, err := f.base.NewObject(ctx, chunkPath("0123"))
+	require.NoError(t, err)
+	require.NoError(t, o.SetModTime(ctx, time.Now().Add(-2*time.Hour)))
+
+	// Reusing it refreshes it so cleanup keeps it until the
+	// manifest using it is written
+	require.NoError(t, f.putChunk(ctx, "0123", chunk))
+	o, err = f.base.NewObject(ctx, chunkPath("0123"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), o.ModTime(ctx), time.Minute)
+	require.NoError(t, f.CleanUp(ctx))
+	_, err = f.base.NewObject(ctx, chunkPath("0123"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,81 @@
+// Test Cdc filesystem interface
+package cdc_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rclone/rclone/backend/cdc"
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/backend/memory"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/fstests"
+)
+
+var unimplementableFsMethods = []string{
+	"ListR",
+	"ListP",
+	"ChangeNotify",
+	"OpenWriterAt",
+	"OpenChunkWriter",
+	"ResumeChunkWriter",
+	"MergeDirs",
+	"DirCacheFlush",
+	"PutUnchecked",
+	"PublicLink",
+	"UserInfo",
+	"Disconnect",
+}
+
+var unimplementableObjectMethods = []string{
+	"MimeType",
+	"ID",
+	"GetTier",
+	"SetTier",
+	"Metadata",
+	"SetMetadata",
+}
+
+// TestIntegration runs integration tests against a concrete remote
+// set by the -remote flag. If the flag is not set, it creates a
+// dynamic cdc overlay wrapping a local temporary directory.
+func TestIntegration(t *testing.T) {
+	opt := fstests.Opt{
+		RemoteName:                   *fstest.RemoteName,
+		NilObject:                    (*cdc.Object)(nil),
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+	}
+	if *fstest.RemoteName == "" {
+		name := "TestCdc"
+		opt.RemoteName = name + ":"
+		tempDir := filepath.Join(os.TempDir(), "rclone-cdc-test")
+		opt.ExtraConfig = []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "cdc"},
+			{Name: name, Key: "remote", Value: tempDir},
+		}
+		opt.QuickTestOK = true
+	}
+	fstests.Run(t, &opt)
+}
+
+// TestMemory runs integration tests against the memory remote
+func TestMemory(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	name := "TestCdcMemory"
+	opt := fstests.Opt{
+		RemoteName: name + ":",
+		NilObject:  (*cdc.Object)(nil),
+		ExtraConfig: []fstests.ExtraConfigItem{
+			{Name: name, Key: "type", Value: "cdc"},
+			{Name: name, Key: "remote", Value: ":memory:bucket"},
+		},
+		UnimplementableFsMethods:     unimplementableFsMethods,
+		UnimplementableObjectMethods: unimplementableObjectMethods,
+		QuickTestOK:                  true,
+	}
+	fstests.Run(t, &opt)
+}
@@ -0,0 +1,135 @@
+package cdc
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"math/rand"
+)
+
+// gear is the table of random values used by the rolling hash.
+//
+// It is made from a fixed seed as the chunk boundaries, and so the
+// deduplication, depend on it. It must never be changed.
+var gear [256]uint64
+
+func init() {
+	rng := rand.New(rand.NewSource(0x63646321)) // "cdc!"
+	for i := range gear {
+		gear[i] = rng.Uint64()
+	}
+}
+
+// splitter splits a stream into content defined chunks using the
+// FastCDC algorithm.
+//
+// A rolling hash of the data is kept and a chunk boundary is made
+// wherever the hash matches a mask. As the boundaries depend only on
+// the data near them, inserting or deleting bytes in a stream only
+// changes the chunks near the edit.
+//
+// Normalized chunking is used - a harder to match mask is used below
+// the average size and an easier one above it, which keeps the chunk
+// sizes close to the average.
+type splitter struct {
+	in      io.Reader
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 // mask used below avgSize
+	maskL   uint64 // mask used above avgSize
+	buf     []byte // buffered data
+	start   int    // start of unread data in buf
+	end     int    // end of data in buf
+	eof     bool   // set when in is exhausted
+}
+
+// topMask returns a mask with the top n bits set.
+//
+// The top bits are used as each byte shifts the hash left so the top
+// bits depend on the most data.
+func topMask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// newSplitter returns a splitter reading from in making chunks of
+// between minSize and maxSize bytes averaging around avgSize.
+func newSplitter(in io.Reader, minSize, avgSize, maxSize int) (*splitter, error) {
+	if minSize <= 0 || minSize >= avgSize || avgSize >= maxSize {
+		return nil, errors.New("chunk sizes must satisfy 0 < min < avg < max")
+	}
+	avgBits := bits.Len(uint(avgSize)) - 1
+	return &splitter{
+		in:      in,
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		maskS:   topMask(avgBits + 1),
+		maskL:   topMask(avgBits - 1),
+		buf:     make([]byte, 2*maxSize),
+	}, nil
+}
+
+// fill reads data into buf until it holds at least maxSize bytes or
+// the input is exhausted.
+func (s *splitter) fill() error {
+	if s.end-s.start >= s.maxSize || s.eof {
+		return nil
+	}
+	// Move the unread data to the start of the buffer
+	if s.start > 0 {
+		s.end = copy(s.buf, s.buf[s.start:s.end])
+		s.start = 0
+	}
+	for s.end < s.maxSize && !s.eof {
+		n, err := s.in.Read(s.buf[s.end:])
+		s.end += n
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the first chunk in data
+func (s *splitter) cut(data []byte) int {
+	n := len(data)
+	if n <= s.minSize {
+		return n
+	}
+	n = min(n, s.maxSize)
+	normal := min(n, s.avgSize)
+	var fp uint64
+	i := s.minSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&s.maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&s.maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
+
+// next returns the next chunk or io.EOF when there are no more.
+//
+// The chunk is only valid until the next call.
+func (s *splitter) next() ([]byte, error) {
+	if err := s.fill(); err != nil {
+		return nil, err
+	}
+	if s.start == s.end {
+		return nil, io.EOF
+	}
+	n := s.cut(s.buf[s.start:s.end])
+	chunk := s.buf[s.start : s.start+n]
+	s.start += n
+	return chunk, nil
+}
@@ -0,0 +1,98 @@
+package cdc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/rclone/rclone/fs"
+)
+
+const (
+	manifestVersion = 1
+	manifestExt     = ".cdc"
+	chunkDir        = ".cdc" // directory in the root of the remote holding the chunks
+)
+
+// manifestRegexp matches the name of a manifest, capturing the name
+// of the file and its encoded size
+var manifestRegexp = regexp.MustCompile(`^(.+)\.([A-Za-z0-9_-]{11})` + regexp.QuoteMeta(manifestExt) + `$`)
+
+// manifestChunk is a reference to a chunk in a manifest
+type manifestChunk struct {
+	Hash string `json:"hash"` // SHA-256 of the chunk as hex
+	Size int64  `json:"size"` // size of the chunk
+}
+
+// manifest describes how to put a file back together from its chunks
+type manifest struct {
+	Version int             `json:"version"`
+	Size    int64           `json:"size"`
+	MD5     string          `json:"md5"`
+	SHA1    string          `json:"sha1"`
+	Chunks  []manifestChunk `json:"chunks"`
+}
+
+// readManifest reads and checks the manifest in o
+func readManifest(ctx context.Context, o fs.Object) (m *manifest, err error) {
+	rc, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(rc, &err)
+	m = new(manifest)
+	if err = json.NewDecoder(rc).Decode(m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d - try a newer version of rclone", m.Version)
+	}
+	var size int64
+	for _, chunk := range m.Chunks {
+		size += chunk.Size
+	}
+	if size != m.Size {
+		return nil, errors.New("manifest is corrupt: chunk sizes don't add up to file size")
+	}
+	return m, nil
+}
+
+// makeManifestName returns the name of the manifest for remote with
+// the size given.
+//
+// The size is kept in the name so listings can show the size of files
+// without reading their manifests.
+func makeManifestName(remote string, size int64) string {
+	sizeBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(sizeBytes, uint64(size))
+	return remote + "." + base64.RawURLEncoding.EncodeToString(sizeBytes) + manifestExt
+}
+
+// parseManifestName returns the name and size of the file the manifest
+// called name describes. ok is false if name isn't a manifest.
+func parseManifestName(name string) (remote string, size int64, ok bool) {
+	match := manifestRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, false
+	}
+	sizeBytes, err := base64.RawURLEncoding.DecodeString(match[2])
+	if err != nil || len(sizeBytes) != 8 {
+		return "", 0, false
+	}
+	size = int64(binary.LittleEndian.Uint64(sizeBytes))
+	if size < 0 {
+		return "", 0, false
+	}
+	return match[1], size, true
+}
+
+// chunkPath returns the path of the chunk with hash in the root of
+// the wrapped remote
+func chunkPath(hash string) string {
+	return path.Join(chunkDir, hash[:2], hash)
+}
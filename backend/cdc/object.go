@@ -0,0 +1,240 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Object describes a file stored as a manifest and its chunks
+type Object struct {
+	f        *Fs
+	o        fs.Object // the manifest
+	remote   string
+	size     int64
+	mu       sync.Mutex
+	manifest *manifest // read on first use
+}
+
+// newObject makes an Object from the manifest o
+func (f *Fs) newObject(o fs.Object, remote string, size int64) *Object {
+	return &Object{
+		f:      f,
+		o:      o,
+		remote: remote,
+		size:   size,
+	}
+}
+
+// getManifest reads the manifest if it hasn't been read already
+func (o *Object) getManifest(ctx context.Context) (*manifest, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.manifest == nil {
+		m, err := readManifest(ctx, o.o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest for %q: %w", o.remote, err)
+		}
+		o.manifest = m
+	}
+	return o.manifest, nil
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the file
+func (o *Object) ModTime(ctx context.Context) time.Time {
+	return o.o.ModTime(ctx)
+}
+
+// SetModTime sets the modification time of the file
+func (o *Object) SetModTime(ctx context.Context, modTime time.Time) error {
+	err := o.o.SetModTime(ctx, modTime)
+	o.f.invalidate(o.remote)
+	return err
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Hash returns the selected checksum of the file
+func (o *Object) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	if ht != hash.MD5 && ht != hash.SHA1 {
+		return "", hash.ErrUnsupported
+	}
+	m, err := o.getManifest(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ht == hash.MD5 {
+		return m.MD5, nil
+	}
+	return m.SHA1, nil
+}
+
+// UnWrap returns the manifest object
+func (o *Object) UnWrap() fs.Object {
+	return o.o
+}
+
+// Remove an object
+//
+// Only the manifest is removed. Chunks no longer used by any file are
+// removed by CleanUp.
+func (o *Object) Remove(ctx context.Context) error {
+	err := o.o.Remove(ctx)
+	o.f.invalidate(o.remote)
+	return err
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	newO, err := o.f.upload(ctx, in, src, o.remote, o.o, options...)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	o.o = newO.o
+	o.size = newO.size
+	o.manifest = newO.manifest
+	o.mu.Unlock()
+	return nil
+}
+
+// Open an object for read
+func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	m, err := o.getManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(m.Size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	if limit < 0 || offset+limit > m.Size {
+		limit = max(m.Size-offset, 0)
+	}
+	r := &chunkReader{
+		ctx:       ctx,
+		f:         o.f,
+		remaining: limit,
+	}
+	// Find the chunk the offset is in
+	r.chunks = m.Chunks
+	for len(r.chunks) > 0 && offset >= r.chunks[0].Size {
+		offset -= r.chunks[0].Size
+		r.chunks = r.chunks[1:]
+	}
+	r.skip = offset
+	return r, nil
+}
+
+// chunkReader reads a file from its chunks opening each one as it is
+// needed
+type chunkReader struct {
+	ctx       context.Context
+	f         *Fs
+	chunks    []manifestChunk // chunks still to be opened
+	skip      int64           // bytes to skip at the start of the next chunk
+	remaining int64           // bytes left to read
+	in        io.ReadCloser   // the chunk being read
+}
+
+// openChunk opens the next chunk
+func (r *chunkReader) openChunk() error {
+	chunk := r.chunks[0]
+	r.chunks = r.chunks[1:]
+	o, err := r.f.base.NewObject(r.ctx, chunkPath(chunk.Hash))
+	if err != nil {
+		return fmt.Errorf("failed to find chunk %s: %w", chunk.Hash, err)
+	}
+	var options []fs.OpenOption
+	if r.skip > 0 || r.remaining < chunk.Size {
+		end := min(chunk.Size, r.skip+r.remaining) - 1
+		options = append(options, &fs.RangeOption{Start: r.skip, End: end})
+	}
+	r.skip = 0
+	r.in, err = o.Open(r.ctx, options...)
+	if err != nil {
+		return fmt.Errorf("failed to open chunk %s: %w", chunk.Hash, err)
+	}
+	return nil
+}
+
+// Read data from the chunks into p
+func (r *chunkReader) Read(p []byte) (n int, err error) {
+	for r.remaining > 0 {
+		if r.in == nil {
+			if len(r.chunks) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			if err = r.openChunk(); err != nil {
+				return 0, err
+			}
+		}
+		if int64(len(p)) > r.remaining {
+			p = p[:r.remaining]
+		}
+		n, err = r.in.Read(p)
+		r.remaining -= int64(n)
+		if err == io.EOF {
+			err = r.in.Close()
+			r.in = nil
+			if err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
+
+// Close the chunk being read
+func (r *chunkReader) Close() error {
+	if r.in == nil {
+		return nil
+	}
+	err := r.in.Close()
+	r.in = nil
+	return err
+}
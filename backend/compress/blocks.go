@@ -0,0 +1,385 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+)
+
+// The zstd, lz4 and xz modes split the data into blocks of blockSize
+// bytes and compress each block into an independent frame. The
+// compressed size of each block is kept in the metadata so that a read
+// at any offset only needs to decompress the block containing it
+// onwards.
+//
+// Concatenated frames are valid zstd, lz4 and xz streams so the data
+// files can be decompressed with the standard tools.
+
+const blockSize = 1048576
+
+// BlockMetadata describes the blocks of a file compressed with zstd, lz4 or xz
+type BlockMetadata struct {
+	BlockSize int      // uncompressed size of each block apart from the last
+	Size      int64    // uncompressed size of the file
+	BlockData []uint32 // compressed size of each block
+}
+
+// blockCodec compresses and decompresses single blocks
+type blockCodec interface {
+	// encode appends the compressed frame of src to dst
+	encode(dst, src []byte) ([]byte, error)
+	// decode appends the decompressed frame src to dst
+	decode(dst, src []byte) ([]byte, error)
+	// trailer returns any data to write after the last block
+	trailer(meta *BlockMetadata) []byte
+	// Close releases any resources
+	Close() error
+}
+
+// zstdCodec implements blockCodec for zstd
+type zstdCodec struct {
+	level zstd.EncoderLevel
+	enc   *zstd.Encoder
+	dec   *zstd.Decoder
+}
+
+func newZstdCodec(level int) *zstdCodec {
+	return &zstdCodec{level: zstd.EncoderLevelFromZstd(level)}
+}
+
+func (c *zstdCodec) encode(dst, src []byte) (out []byte, err error) {
+	if c.enc == nil {
+		c.enc, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(c.level), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.enc.EncodeAll(src, dst), nil
+}
+
+func (c *zstdCodec) decode(dst, src []byte) (out []byte, err error) {
+	if c.dec == nil {
+		c.dec, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.dec.DecodeAll(src, dst)
+}
+
+// Magic numbers for the zstd seekable format
+const (
+	zstdSkippableFrameMagic = 0x184D2A5E
+	zstdSeekableMagic       = 0x8F92EAB1
+)
+
+// trailer returns the seek table in the zstd seekable format so that
+// other tools which understand it can seek in the data file. It is
+// held in a skippable frame so is ignored by ordinary decompressors.
+func (c *zstdCodec) trailer(meta *BlockMetadata) []byte {
+	n := len(meta.BlockData)
+	frameSize := n*8 + 9
+	buf := make([]byte, 0, 8+frameSize)
+	buf = binary.LittleEndian.AppendUint32(buf, zstdSkippableFrameMagic)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(frameSize))
+	remaining := meta.Size
+	for _, compressedSize := range meta.BlockData {
+		size := min(remaining, int64(meta.BlockSize))
+		remaining -= size
+		buf = binary.LittleEndian.AppendUint32(buf, compressedSize)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+	}
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(n))
+	buf = append(buf, 0) // descriptor - no checksums
+	buf = binary.LittleEndian.AppendUint32(buf, zstdSeekableMagic)
+	return buf
+}
+
+func (c *zstdCodec) Close() error {
+	if c.enc != nil {
+		_ = c.enc.Close()
+	}
+	if c.dec != nil {
+		c.dec.Close()
+	}
+	return nil
+}
+
+// lz4Codec implements blockCodec for lz4
+type lz4Codec struct {
+	level lz4.CompressionLevel
+	w     *lz4.Writer
+	r     *lz4.Reader
+	buf   bytes.Buffer
+}
+
+// newLz4Codec makes an lz4 codec with level 0 (fast) to 9
+func newLz4Codec(level int) *lz4Codec {
+	c := &lz4Codec{level: lz4.Fast}
+	if level > 0 {
+		c.level = lz4.Level1 << (level - 1)
+	}
+	return c
+}
+
+func (c *lz4Codec) encode(dst, src []byte) ([]byte, error) {
+	c.buf.Reset()
+	if c.w == nil {
+		c.w = lz4.NewWriter(&c.buf)
+		err := c.w.Apply(
+			lz4.CompressionLevelOption(c.level),
+			lz4.BlockSizeOption(lz4.Block1Mb),
+			lz4.ConcurrencyOption(1),
+		)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		c.w.Reset(&c.buf)
+	}
+	if _, err := c.w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := c.w.Close(); err != nil {
+		return nil, err
+	}
+	return append(dst, c.buf.Bytes()...), nil
+}
+
+func (c *lz4Codec) decode(dst, src []byte) ([]byte, error) {
+	if c.r == nil {
+		c.r = lz4.NewReader(bytes.NewReader(src))
+	} else {
+		c.r.Reset(bytes.NewReader(src))
+	}
+	out := bytes.NewBuffer(dst)
+	if _, err := out.ReadFrom(c.r); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (c *lz4Codec) trailer(meta *BlockMetadata) []byte {
+	return nil
+}
+
+func (c *lz4Codec) Close() error {
+	return nil
+}
+
+// xzCodec implements blockCodec for xz
+//
+// Each block is written as an independent xz stream.
+type xzCodec struct {
+	buf bytes.Buffer
+}
+
+func newXzCodec() *xzCodec {
+	return &xzCodec{}
+}
+
+func (c *xzCodec) encode(dst, src []byte) ([]byte, error) {
+	c.buf.Reset()
+	w, err := xz.NewWriter(&c.buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return append(dst, c.buf.Bytes()...), nil
+}
+
+func (c *xzCodec) decode(dst, src []byte) ([]byte, error) {
+	r, err := xz.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	out := bytes.NewBuffer(dst)
+	if _, err := out.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func (c *xzCodec) trailer(meta *BlockMetadata) []byte {
+	return nil
+}
+
+func (c *xzCodec) Close() error {
+	return nil
+}
+
+// blockWriter compresses the data written to it in blocks
+type blockWriter struct {
+	out   io.Writer
+	codec blockCodec
+	buf   []byte // uncompressed data not yet written
+	cbuf  []byte // buffer for compressed data
+	meta  BlockMetadata
+}
+
+func newBlockWriter(out io.Writer, codec blockCodec) *blockWriter {
+	return &blockWriter{
+		out:   out,
+		codec: codec,
+		buf:   make([]byte, 0, blockSize),
+		meta:  BlockMetadata{BlockSize: blockSize},
+	}
+}
+
+// writeBlock compresses and writes a single block
+func (w *blockWriter) writeBlock(block []byte) (err error) {
+	w.cbuf, err = w.codec.encode(w.cbuf[:0], block)
+	if err != nil {
+		return err
+	}
+	if _, err = w.out.Write(w.cbuf); err != nil {
+		return err
+	}
+	w.meta.BlockData = append(w.meta.BlockData, uint32(len(w.cbuf)))
+	w.meta.Size += int64(len(block))
+	return nil
+}
+
+// Write compresses p
+func (w *blockWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := min(len(p), blockSize-len(w.buf))
+		w.buf = append(w.buf, p[:chunk]...)
+		p = p[chunk:]
+		n += chunk
+		if len(w.buf) == blockSize {
+			if err = w.writeBlock(w.buf); err != nil {
+				return n, err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Close writes any remaining data and the trailer
+func (w *blockWriter) Close() (err error) {
+	defer func() {
+		closeErr := w.codec.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	// Always write at least one block so empty files are valid streams
+	if len(w.buf) > 0 || len(w.meta.BlockData) == 0 {
+		if err = w.writeBlock(w.buf); err != nil {
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+	if trailer := w.codec.trailer(&w.meta); len(trailer) > 0 {
+		if _, err = w.out.Write(trailer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setMetadata stores the compression metadata in meta
+func (w *blockWriter) setMetadata(meta *ObjectMetadata) {
+	meta.Size = w.meta.Size
+	meta.CompressionMetadataBlocks = &w.meta
+}
+
+// blockReader decompresses data written by blockWriter
+type blockReader struct {
+	in    io.Reader
+	codec blockCodec
+	meta  *BlockMetadata
+	block int    // index of the next block to read
+	data  []byte // decompressed data not yet returned
+	dbuf  []byte // buffer for decompressed data
+	cbuf  []byte // buffer for compressed data
+}
+
+// newBlockReader returns a reader for the data in rs starting at the
+// uncompressed offset given.
+func newBlockReader(rs io.ReadSeeker, codec blockCodec, meta *BlockMetadata, offset int64) (*blockReader, error) {
+	if meta == nil || meta.BlockSize <= 0 {
+		return nil, errors.New("missing block compression metadata")
+	}
+	r := &blockReader{
+		in:    rs,
+		codec: codec,
+		meta:  meta,
+	}
+	if offset >= meta.Size {
+		r.block = len(meta.BlockData)
+		return r, nil
+	}
+	r.block = int(offset / int64(meta.BlockSize))
+	if r.block >= len(meta.BlockData) {
+		return nil, fmt.Errorf("offset %d is beyond the end of the block metadata", offset)
+	}
+	var compressedOffset int64
+	for _, compressedSize := range meta.BlockData[:r.block] {
+		compressedOffset += int64(compressedSize)
+	}
+	if _, err := rs.Seek(compressedOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := r.readBlock(); err != nil {
+		return nil, err
+	}
+	skip := offset % int64(meta.BlockSize)
+	if skip > int64(len(r.data)) {
+		return nil, fmt.Errorf("block %d is shorter than expected", r.block-1)
+	}
+	r.data = r.data[skip:]
+	return r, nil
+}
+
+// readBlock reads and decompresses the next block
+func (r *blockReader) readBlock() (err error) {
+	compressedSize := int(r.meta.BlockData[r.block])
+	if cap(r.cbuf) < compressedSize {
+		r.cbuf = make([]byte, compressedSize)
+	}
+	r.cbuf = r.cbuf[:compressedSize]
+	if _, err = io.ReadFull(r.in, r.cbuf); err != nil {
+		return fmt.Errorf("failed to read compressed block %d: %w", r.block, err)
+	}
+	r.dbuf, err = r.codec.decode(r.dbuf[:0], r.cbuf)
+	if err != nil {
+		return fmt.Errorf("failed to decompress block %d: %w", r.block, err)
+	}
+	r.data = r.dbuf
+	r.block++
+	return nil
+}
+
+// Read decompressed data into p
+func (r *blockReader) Read(p []byte) (n int, err error) {
+	for len(r.data) == 0 {
+		if r.block >= len(r.meta.BlockData) {
+			return 0, io.EOF
+		}
+		if err = r.readBlock(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Close releases the codec
+func (r *blockReader) Close() error {
+	return r.codec.Close()
+}
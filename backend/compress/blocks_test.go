@@ -0,0 +1,88 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockCompression(t *testing.T) {
+	// Compressible data over several blocks
+	data := make([]byte, 3*blockSize+12345)
+	rng := rand.New(rand.NewSource(1))
+	for i := range data {
+		data[i] = byte('a' + rng.Intn(4))
+	}
+	for _, test := range []struct {
+		name     string
+		newCodec func() blockCodec
+	}{
+		{"zstd", func() blockCodec { return newZstdCodec(3) }},
+		{"lz4", func() blockCodec { return newLz4Codec(0) }},
+		{"lz4-level9", func() blockCodec { return newLz4Codec(9) }},
+		{"xz", func() blockCodec { return newXzCodec() }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, size := range []int{0, 1, blockSize, len(data)} {
+				var buf bytes.Buffer
+				w := newBlockWriter(&buf, test.newCodec())
+				_, err := w.Write(data[:size])
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				meta := &ObjectMetadata{}
+				w.setMetadata(meta)
+				blocks := meta.CompressionMetadataBlocks
+				assert.Equal(t, int64(size), meta.Size)
+				assert.Equal(t, max(1, (size+blockSize-1)/blockSize), len(blocks.BlockData))
+
+				if test.name == "zstd" {
+					// Check a standard decoder can read the stream
+					dec, err := zstd.NewReader(bytes.NewReader(buf.Bytes()))
+					require.NoError(t, err)
+					got, err := io.ReadAll(dec)
+					dec.Close()
+					require.NoError(t, err)
+					assert.Equal(t, data[:size], got)
+				}
+
+				for _, offset := range []int{0, 1, blockSize - 1, blockSize, blockSize + 7, 3*blockSize + 100, size} {
+					if offset > size {
+						continue
+					}
+					r, err := newBlockReader(bytes.NewReader(buf.Bytes()), test.newCodec(), blocks, int64(offset))
+					require.NoError(t, err)
+					got, err := io.ReadAll(r)
+					require.NoError(t, err)
+					require.NoError(t, r.Close())
+					assert.Equal(t, data[offset:size], got, "size %d offset %d", size, offset)
+				}
+			}
+		})
+	}
+}
+
+func TestZstdSeekTable(t *testing.T) {
+	meta := &BlockMetadata{
+		BlockSize: blockSize,
+		Size:      blockSize + 10,
+		BlockData: []uint32{100, 20},
+	}
+	trailer := newZstdCodec(3).trailer(meta)
+	require.Equal(t, 8+2*8+9, len(trailer))
+	le := binary.LittleEndian
+	assert.Equal(t, uint32(zstdSkippableFrameMagic), le.Uint32(trailer[0:]))
+	assert.Equal(t, uint32(2*8+9), le.Uint32(trailer[4:]))
+	assert.Equal(t, uint32(100), le.Uint32(trailer[8:]))
+	assert.Equal(t, uint32(blockSize), le.Uint32(trailer[12:]))
+	assert.Equal(t, uint32(20), le.Uint32(trailer[16:]))
+	assert.Equal(t, uint32(10), le.Uint32(trailer[20:]))
+	assert.Equal(t, uint32(2), le.Uint32(trailer[24:]))
+	assert.Equal(t, byte(0), trailer[28])
+	assert.Equal(t, uint32(zstdSeekableMagic), le.Uint32(trailer[29:]))
+}
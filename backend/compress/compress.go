@@ -46,6 +46,9 @@ const (
 	minCompressionRatio = 1.1
 
 	gzFileExt           = ".gz"
+	zstdFileExt         = ".zst"
+	lz4FileExt          = ".lz4"
+	xzFileExt           = ".xz"
 	metaFileExt         = ".json"
 	uncompressedFileExt = ".bin"
 )
@@ -54,6 +57,9 @@ const (
 const (
 	Uncompressed = 0
 	Gzip         = 2
+	Zstd         = 3
+	Lz4          = 4
+	Xz           = 5
 )
 
 var nameRegexp = regexp.MustCompile(`^(.+?)\.([A-Za-z0-9-_]{11})$`)
@@ -66,6 +72,18 @@ func init() {
 			Value: "gzip",
 			Help:  "Standard gzip compression with fastest parameters.",
 		},
+		{
+			Value: "zstd",
+			Help:  "Zstandard compression - faster and smaller than gzip.",
+		},
+		{
+			Value: "lz4",
+			Help:  "LZ4 compression - very fast with lower compression.",
+		},
+		{
+			Value: "xz",
+			Help:  "XZ compression - slow but compresses best.",
+		},
 	}
 
 	// Register our remote
@@ -98,6 +116,23 @@ are doing.
 Level 0 turns off compression.`,
 			Default:  sgzip.DefaultCompression,
 			Advanced: true,
+		}, {
+			Name: "zstd_level",
+			Help: `ZSTD compression level (1 to 22).
+
+This is used when the mode is zstd. The levels are mapped onto the
+levels of the zstd library used which are fastest (1-2), default (3-5),
+better (6-9) and best (10 and above).`,
+			Default:  3,
+			Advanced: true,
+		}, {
+			Name: "lz4_level",
+			Help: `LZ4 compression level (0 to 9).
+
+This is used when the mode is lz4. Level 0 is the fastest. Levels 1
+to 9 increase compression at the cost of speed.`,
+			Default:  0,
+			Advanced: true,
 		}, {
 			Name: "ram_cache_limit",
 			Help: `Some remotes don't allow the upload of files with unknown size.
@@ -117,6 +152,8 @@ type Options struct {
 	Remote           string        `config:"remote"`
 	CompressionMode  string        `config:"mode"`
 	CompressionLevel int           `config:"level"`
+	ZstdLevel        int           `config:"zstd_level"`
+	Lz4Level         int           `config:"lz4_level"`
 	RAMCacheLimit    fs.SizeSuffix `config:"ram_cache_limit"`
 }
 
@@ -142,6 +179,13 @@ func NewFs(ctx context.Context, name, rpath string, m configmap.Mapper) (fs.Fs,
 		return nil, err
 	}
 
+	if opt.ZstdLevel < 1 || opt.ZstdLevel > 22 {
+		return nil, fmt.Errorf("zstd_level must be between 1 and 22, got %d", opt.ZstdLevel)
+	}
+	if opt.Lz4Level < 0 || opt.Lz4Level > 9 {
+		return nil, fmt.Errorf("lz4_level must be between 0 and 9, got %d", opt.Lz4Level)
+	}
+
 	remote := opt.Remote
 	if strings.HasPrefix(remote, name+":") {
 		return nil, errors.New("can't point press remote at itself - check the value of the remote setting")
@@ -219,6 +263,12 @@ func compressionModeFromName(name string) int {
 	switch name {
 	case "gzip":
 		return Gzip
+	case "zstd":
+		return Zstd
+	case "lz4":
+		return Lz4
+	case "xz":
+		return Xz
 	default:
 		return Uncompressed
 	}
@@ -253,6 +303,9 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if extension == uncompressedFileExt {
 		return nameWithSize, extension, -2, nil
 	}
+	if modeFromExtension(extension) == Uncompressed {
+		return "", "", 0, errors.New("unknown compressed file extension")
+	}
 	match := nameRegexp.FindStringSubmatch(nameWithSize)
 	if match == nil || len(match) != 3 {
 		return "", "", 0, errors.New("invalid filename")
@@ -261,7 +314,38 @@ func processFileName(compressedFileName string) (origFileName string, extension
 	if err != nil {
 		return "", "", 0, errors.New("could not decode size")
 	}
-	return match[1], gzFileExt, size, nil
+	return match[1], extension, size, nil
+}
+
+// Returns the file extension used for data compressed with mode
+func modeExtension(mode int) string {
+	switch mode {
+	case Zstd:
+		return zstdFileExt
+	case Lz4:
+		return lz4FileExt
+	case Xz:
+		return xzFileExt
+	default:
+		return gzFileExt
+	}
+}
+
+// Returns the compression mode of a data file with the given extension
+// or Uncompressed if it isn't a compressed data file
+func modeFromExtension(extension string) int {
+	switch extension {
+	case gzFileExt:
+		return Gzip
+	case zstdFileExt:
+		return Zstd
+	case lz4FileExt:
+		return Lz4
+	case xzFileExt:
+		return Xz
+	default:
+		return Uncompressed
+	}
 }
 
 // Generates the file name for a metadata file
@@ -287,7 +371,7 @@ func unwrapMetadataFile(filename string) (string, bool) {
 // makeDataName generates the file name for a data file with specified compression mode
 func makeDataName(remote string, size int64, mode int) (newRemote string) {
 	if mode != Uncompressed {
-		newRemote = remote + "." + int64ToBase64(size) + gzFileExt
+		newRemote = remote + "." + int64ToBase64(size) + modeExtension(mode)
 	} else {
 		newRemote = remote + uncompressedFileExt
 	}
@@ -427,8 +511,9 @@ func (f *Fs) NewObject(ctx context.Context, remote string) (fs.Object, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error decoding metadata: %w", err)
 	}
-	// Create our Object
-	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.CompressionMetadata.Size, meta.Mode))
+	// Create our Object - the data name holds the uncompressed size
+	// which is only in CompressionMetadata for gzip
+	o, err := f.Fs.NewObject(ctx, makeDataName(remote, meta.Size, meta.Mode))
 	if err != nil {
 		return nil, err
 	}
@@ -495,8 +580,56 @@ func (f *Fs) verifyObjectHash(ctx context.Context, o fs.Object, hasher *hash.Mul
 type putFn func(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error)
 
 type compressionResult struct {
-	err  error
-	meta sgzip.GzipMetadata
+	err error
+	c   compressor
+}
+
+// compressor is a compressing writer which can describe the data it
+// wrote once it is closed
+type compressor interface {
+	io.WriteCloser
+	// setMetadata stores the compression metadata in meta
+	setMetadata(meta *ObjectMetadata)
+}
+
+// gzipCompressor is a compressor for the gzip mode
+type gzipCompressor struct {
+	*sgzip.Writer
+}
+
+// setMetadata stores the compression metadata in meta
+func (c gzipCompressor) setMetadata(meta *ObjectMetadata) {
+	meta.CompressionMetadata = c.MetaData()
+	meta.Size = meta.CompressionMetadata.Size
+}
+
+// newCodec returns the block codec for mode
+func (f *Fs) newCodec(mode int) (blockCodec, error) {
+	switch mode {
+	case Zstd:
+		return newZstdCodec(f.opt.ZstdLevel), nil
+	case Lz4:
+		return newLz4Codec(f.opt.Lz4Level), nil
+	case Xz:
+		return newXzCodec(), nil
+	}
+	return nil, fmt.Errorf("unknown compression mode %d - try a newer version of rclone", mode)
+}
+
+// newCompressor returns a compressor for the mode of the Fs writing to out
+func (f *Fs) newCompressor(out io.Writer) (compressor, error) {
+	if f.mode == Gzip {
+		gz, err := sgzip.NewWriterLevel(out, f.opt.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+		return gzipCompressor{gz}, nil
+	}
+	codec, err := f.newCodec(f.mode)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockWriter(out, codec), nil
 }
 
 // replicating some of operations.Rcat functionality because we want to support remotes without streaming
@@ -559,17 +692,18 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	pipeReader, pipeWriter := io.Pipe()
 	results := make(chan compressionResult)
 	go func() {
-		gz, err := sgzip.NewWriterLevel(pipeWriter, f.opt.CompressionLevel)
+		c, err := f.newCompressor(pipeWriter)
 		if err != nil {
-			results <- compressionResult{err: err, meta: sgzip.GzipMetadata{}}
+			_ = pipeWriter.CloseWithError(err)
+			results <- compressionResult{err: err}
 			return
 		}
-		_, err = io.Copy(gz, in)
-		gzErr := gz.Close()
-		if gzErr != nil {
-			fs.Errorf(nil, "Failed to close compress: %v", gzErr)
+		_, err = io.Copy(c, in)
+		cErr := c.Close()
+		if cErr != nil {
+			fs.Errorf(nil, "Failed to close compress: %v", cErr)
 			if err == nil {
-				err = gzErr
+				err = cErr
 			}
 		}
 		closeErr := pipeWriter.Close()
@@ -579,9 +713,9 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 				err = closeErr
 			}
 		}
-		results <- compressionResult{err: err, meta: gz.MetaData()}
+		results <- compressionResult{err: err, c: c}
 	}()
-	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as sgzip has it's own buffering
+	wrappedIn := wrap(bufio.NewReaderSize(pipeReader, bufferSize)) // Probably no longer needed as the compressors do their own buffering
 
 	// Find a hash the destination supports to compute a hash of
 	// the compressed data.
@@ -626,7 +760,8 @@ func (f *Fs) putCompress(ctx context.Context, in io.Reader, src fs.ObjectInfo, o
 	}
 
 	// Generate metadata
-	meta := newMetadata(result.meta.Size, f.mode, result.meta, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	meta := newMetadata(0, f.mode, sgzip.GzipMetadata{}, hex.EncodeToString(metaHasher.Sum(nil)), mimeType)
+	result.c.setMetadata(meta)
 
 	// Check the hashes of the compressed data if we were comparing them
 	if ht != hash.None && hasher != nil {
@@ -1095,6 +1230,8 @@ type ObjectMetadata struct {
 	MD5                 string // MD5 hash of the file.
 	MimeType            string // Mime type of the file
 	CompressionMetadata sgzip.GzipMetadata
+	// Block metadata for the zstd, lz4 and xz modes
+	CompressionMetadataBlocks *BlockMetadata `json:",omitempty"`
 }
 
 // Object with external metadata
@@ -1397,12 +1534,30 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 	chunkedReader := chunkedreader.New(ctx, o.Object, initialChunkSize, maxChunkSize, chunkStreams)
 	// Get file handle
 	var file io.Reader
-	if offset != 0 {
-		file, err = sgzip.NewReaderAt(chunkedReader, &o.meta.CompressionMetadata, offset)
-	} else {
-		file, err = sgzip.NewReader(chunkedReader)
+	var closer io.Closer = chunkedReader
+	switch o.meta.Mode {
+	case Gzip:
+		if offset != 0 {
+			file, err = sgzip.NewReaderAt(chunkedReader, &o.meta.CompressionMetadata, offset)
+		} else {
+			file, err = sgzip.NewReader(chunkedReader)
+		}
+	default:
+		var codec blockCodec
+		codec, err = o.f.newCodec(o.meta.Mode)
+		if err == nil {
+			var br *blockReader
+			br, err = newBlockReader(chunkedReader, codec, o.meta.CompressionMetadataBlocks, offset)
+			if err != nil {
+				_ = codec.Close()
+			} else {
+				file = br
+				closer = multiCloser{br, chunkedReader}
+			}
+		}
 	}
 	if err != nil {
+		_ = chunkedReader.Close()
 		return nil, err
 	}
 
@@ -1413,7 +1568,20 @@ func (o *Object) Open(ctx context.Context, options ...fs.OpenOption) (rc io.Read
 		fileReader = file
 	}
 	// Return a ReadCloser
-	return ReadCloserWrapper{Reader: fileReader, Closer: chunkedReader}, nil
+	return ReadCloserWrapper{Reader: fileReader, Closer: closer}, nil
+}
+
+// multiCloser closes all of its members returning the first error
+type multiCloser []io.Closer
+
+// Close all the closers
+func (mc multiCloser) Close() (err error) {
+	for _, c := range mc {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
 }
 
 // ObjectInfo describes a wrapped fs.ObjectInfo for being the source
@@ -2,16 +2,26 @@
 package compress
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/rclone/rclone/backend/drive"
 	_ "github.com/rclone/rclone/backend/local"
 	_ "github.com/rclone/rclone/backend/s3"
 	_ "github.com/rclone/rclone/backend/swift"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/fstest/fstests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var defaultOpt = fstests.Opt{
@@ -20,6 +30,7 @@ var defaultOpt = fstests.Opt{
 	UnimplementableFsMethods: []string{
 		"OpenWriterAt",
 		"OpenChunkWriter",
+		"ResumeChunkWriter",
 		"MergeDirs",
 		"DirCacheFlush",
 		"PutUnchecked",
@@ -53,3 +64,105 @@ func TestRemoteGzip(t *testing.T) {
 	opt.QuickTestOK = true
 	fstests.Run(t, &opt)
 }
+
+// TestRemoteZstd tests ZSTD compression
+func TestRemoteZstd(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-compress-test-zstd")
+	name := "TestCompressZstd"
+	opt := defaultOpt
+	opt.RemoteName = name + ":"
+	opt.ExtraConfig = []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "compress"},
+		{Name: name, Key: "remote", Value: tempdir},
+		{Name: name, Key: "mode", Value: "zstd"},
+	}
+	opt.QuickTestOK = true
+	fstests.Run(t, &opt)
+}
+
+// TestRemoteLz4 tests LZ4 compression
+func TestRemoteLz4(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-compress-test-lz4")
+	name := "TestCompressLz4"
+	opt := defaultOpt
+	opt.RemoteName = name + ":"
+	opt.ExtraConfig = []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "compress"},
+		{Name: name, Key: "remote", Value: tempdir},
+		{Name: name, Key: "mode", Value: "lz4"},
+	}
+	opt.QuickTestOK = true
+	fstests.Run(t, &opt)
+}
+
+// TestRemoteXz tests XZ compression
+func TestRemoteXz(t *testing.T) {
+	if *fstest.RemoteName != "" {
+		t.Skip("Skipping as -remote set")
+	}
+	tempdir := filepath.Join(os.TempDir(), "rclone-compress-test-xz")
+	name := "TestCompressXz"
+	opt := defaultOpt
+	opt.RemoteName = name + ":"
+	opt.ExtraConfig = []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "compress"},
+		{Name: name, Key: "remote", Value: tempdir},
+		{Name: name, Key: "mode", Value: "xz"},
+	}
+	opt.QuickTestOK = true
+	fstests.Run(t, &opt)
+}
+
+// TestCompressibleRoundTrip checks compressible data is stored
+// compressed in each mode and can be found and read back
+func TestCompressibleRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	data := []byte(strings.Repeat("potato sandwich with extra potato\n", 20000))
+	for _, mode := range []string{"gzip", "zstd", "lz4", "xz"} {
+		t.Run(mode, func(t *testing.T) {
+			f, err := fs.NewFs(ctx, fmt.Sprintf(":compress,remote='%s',mode=%s:", t.TempDir(), mode))
+			require.NoError(t, err)
+
+			src := object.NewStaticObjectInfo("file.txt", time.Now(), int64(len(data)), true, nil, nil)
+			o, err := f.Put(ctx, bytes.NewReader(data), src)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), o.Size())
+			assert.Less(t, o.(*Object).Object.Size(), int64(len(data)))
+
+			o, err = f.NewObject(ctx, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), o.Size())
+			in, err := o.Open(ctx)
+			require.NoError(t, err)
+			got, err := io.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.True(t, bytes.Equal(data, got))
+
+			in, err = o.Open(ctx, &fs.RangeOption{Start: 100000, End: 100099})
+			require.NoError(t, err)
+			got, err = io.ReadAll(in)
+			require.NoError(t, err)
+			require.NoError(t, in.Close())
+			assert.Equal(t, data[100000:100100], got)
+
+			// Overwriting finds and replaces the existing object
+			data2 := data[:len(data)/2]
+			src = object.NewStaticObjectInfo("file.txt", time.Now(), int64(len(data2)), true, nil, nil)
+			_, err = f.Put(ctx, bytes.NewReader(data2), src)
+			require.NoError(t, err)
+			o, err = f.NewObject(ctx, "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data2)), o.Size())
+			entries, err := f.List(ctx, "")
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
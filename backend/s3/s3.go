@@ -3039,9 +3039,16 @@ it would do.
     rclone backend cleanup -o max-age=7w s3:bucket/path/to/object
 
 Durations are parsed as per the rest of rclone, 2h, 7d, 7w etc.
+
+Uploads which were saved with --multi-thread-resume so they can be
+resumed are not removed unless the resumable option is given, in
+which case their saved state is removed too.
+
+    rclone backend cleanup -o resumable s3:bucket/path/to/object
 `,
 	Opts: map[string]string{
-		"max-age": "Max age of upload to delete",
+		"max-age":   "Max age of upload to delete",
+		"resumable": "Remove uploads which could be resumed as well",
 	},
 }, {
 	Name:  "cleanup-hidden",
@@ -3184,7 +3191,8 @@ func (f *Fs) Command(ctx context.Context, name string, arg []string, opt map[str
 				return nil, fmt.Errorf("bad max-age: %w", err)
 			}
 		}
-		return nil, f.cleanUp(ctx, maxAge)
+		_, removeResumable := opt["resumable"]
+		return nil, f.cleanUp(ctx, maxAge, removeResumable)
 	case "cleanup-hidden":
 		return nil, f.CleanUpHidden(ctx)
 	case "versioning":
@@ -3325,13 +3333,18 @@ func (f *Fs) listMultipartUploadsAll(ctx context.Context) (uploadsMap map[string
 }
 
 // cleanUpBucket removes all pending multipart uploads for a given bucket over the age of maxAge
-func (f *Fs) cleanUpBucket(ctx context.Context, bucket string, maxAge time.Duration, uploads []types.MultipartUpload) (err error) {
+//
+// Uploads in resumable are only removed if removeResumable is set
+func (f *Fs) cleanUpBucket(ctx context.Context, bucket string, maxAge time.Duration, uploads []types.MultipartUpload, resumable map[string]*multipart.ResumeState, removeResumable bool) (err error) {
 	fs.Infof(f, "cleaning bucket %q of pending multipart uploads older than %v", bucket, maxAge)
 	for _, upload := range uploads {
 		if upload.Initiated != nil && upload.Key != nil && upload.UploadId != nil {
 			age := time.Since(*upload.Initiated)
 			what := fmt.Sprintf("pending multipart upload for bucket %q key %q dated %v (%v ago)", bucket, *upload.Key, upload.Initiated, age)
-			if age > maxAge {
+			rs := resumable[*upload.UploadId]
+			if age > maxAge && rs != nil && !removeResumable {
+				fs.Infof(f, "not removing %s as it can be resumed", what)
+			} else if age > maxAge {
 				fs.Infof(f, "removing %s", what)
 				if operations.SkipDestructive(ctx, what, "remove pending upload") {
 					continue
@@ -3345,6 +3358,10 @@ func (f *Fs) cleanUpBucket(ctx context.Context, bucket string, maxAge time.Durat
 				if abortErr != nil {
 					err = fmt.Errorf("failed to remove %s: %w", what, abortErr)
 					fs.Errorf(f, "%v", err)
+				} else if rs != nil {
+					if removeErr := rs.Remove(); removeErr != nil {
+						fs.Errorf(f, "%v", removeErr)
+					}
 				}
 			} else {
 				fs.Debugf(f, "ignoring %s", what)
@@ -3355,13 +3372,24 @@ func (f *Fs) cleanUpBucket(ctx context.Context, bucket string, maxAge time.Durat
 }
 
 // CleanUp removes all pending multipart uploads
-func (f *Fs) cleanUp(ctx context.Context, maxAge time.Duration) (err error) {
+//
+// Uploads with saved state which could be resumed are only removed if
+// removeResumable is set
+func (f *Fs) cleanUp(ctx context.Context, maxAge time.Duration, removeResumable bool) (err error) {
 	uploadsMap, err := f.listMultipartUploadsAll(ctx)
 	if err != nil {
 		return err
 	}
+	states, err := multipart.ListResumeStates()
+	if err != nil {
+		return err
+	}
+	resumable := make(map[string]*multipart.ResumeState, len(states))
+	for _, rs := range states {
+		resumable[rs.UploadID] = rs
+	}
 	for bucket, uploads := range uploadsMap {
-		cleanErr := f.cleanUpBucket(ctx, bucket, maxAge, uploads)
+		cleanErr := f.cleanUpBucket(ctx, bucket, maxAge, uploads, resumable, removeResumable)
 		if err != nil {
 			fs.Errorf(f, "Failed to cleanup bucket %q: %v", bucket, cleanErr)
 			err = cleanErr
@@ -3436,7 +3464,7 @@ func (f *Fs) setGetVersioning(ctx context.Context, arg ...string) (status types.
 
 // CleanUp removes all pending multipart uploads older than 24 hours
 func (f *Fs) CleanUp(ctx context.Context) (err error) {
-	return f.cleanUp(ctx, 24*time.Hour)
+	return f.cleanUp(ctx, 24*time.Hour, false)
 }
 
 // purge deletes all the files and directories
@@ -4023,6 +4051,74 @@ type s3ChunkWriter struct {
 // Pass in the remote and the src object
 // You can also use options to hint at the desired chunk size
 func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	return f.openChunkWriter(ctx, remote, src, nil, options...)
+}
+
+// s3ChunkWriterState is the state of an s3ChunkWriter saved so the
+// upload can be resumed
+type s3ChunkWriterState struct {
+	UploadID  string            `json:"uploadId"`
+	ChunkSize int64             `json:"chunkSize"`
+	Parts     []s3CompletedPart `json:"parts"`
+}
+
+// s3CompletedPart is an uploaded part in s3ChunkWriterState
+type s3CompletedPart struct {
+	PartNumber int32  `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// ResumeChunkWriter re-opens an interrupted upload started with
+// OpenChunkWriter using the state from ChunkWriterState
+func (f *Fs) ResumeChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, state []byte, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	var resume s3ChunkWriterState
+	err = json.Unmarshal(state, &resume)
+	if err != nil {
+		return info, nil, fmt.Errorf("failed to decode upload state: %w", err)
+	}
+	if resume.UploadID == "" || resume.ChunkSize <= 0 {
+		return info, nil, errors.New("invalid upload state")
+	}
+	return f.openChunkWriter(ctx, remote, src, &resume, options...)
+}
+
+// listParts returns the ETags of the parts uploaded so far to
+// uploadID indexed by part number
+func (f *Fs) listParts(ctx context.Context, bucket, key, uploadID *string, requestPayer types.RequestPayer) (eTags map[int32]string, err error) {
+	eTags = make(map[int32]string)
+	var partNumberMarker *string
+	for {
+		req := s3.ListPartsInput{
+			Bucket:           bucket,
+			Key:              key,
+			UploadId:         uploadID,
+			PartNumberMarker: partNumberMarker,
+			RequestPayer:     requestPayer,
+		}
+		var resp *s3.ListPartsOutput
+		err = f.pacer.Call(func() (bool, error) {
+			resp, err = f.c.ListParts(ctx, &req)
+			return f.shouldRetry(ctx, err)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list parts of multipart upload %q: %w", *uploadID, err)
+		}
+		for _, part := range resp.Parts {
+			if part.PartNumber != nil && part.ETag != nil {
+				eTags[*part.PartNumber] = *part.ETag
+			}
+		}
+		if !deref(resp.IsTruncated) || resp.NextPartNumberMarker == nil {
+			break
+		}
+		partNumberMarker = resp.NextPartNumberMarker
+	}
+	return eTags, nil
+}
+
+// openChunkWriter opens a new multipart upload or, if resume is set,
+// re-opens the one described by resume
+func (f *Fs) openChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, resume *s3ChunkWriterState, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
 	// Temporary Object under construction
 	o := &Object{
 		fs:     f,
@@ -4060,47 +4156,93 @@ func (f *Fs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectIn
 		chunkSize = chunksize.Calculator(src, size, uploadParts, chunkSize)
 	}
 
-	var mOut *s3.CreateMultipartUploadOutput
-	err = f.pacer.Call(func() (bool, error) {
-		mOut, err = f.c.CreateMultipartUpload(ctx, &mReq)
-		if err == nil {
-			if mOut == nil {
-				err = fserrors.RetryErrorf("internal error: no info from multipart upload")
-			} else if mOut.UploadId == nil {
-				err = fserrors.RetryErrorf("internal error: no UploadId in multipart upload: %#v", *mOut)
-			}
-		}
-		return f.shouldRetry(ctx, err)
-	})
-	if err != nil {
-		return info, nil, fmt.Errorf("create multipart upload failed: %w", err)
-	}
-
 	chunkWriter := &s3ChunkWriter{
 		chunkSize:            int64(chunkSize),
 		size:                 size,
 		f:                    f,
 		bucket:               ui.req.Bucket,
 		key:                  ui.req.Key,
-		uploadID:             mOut.UploadId,
 		multiPartUploadInput: &mReq,
 		completedParts:       make([]types.CompletedPart, 0),
 		ui:                   ui,
 		o:                    o,
 	}
+
+	if resume != nil {
+		// Check the parts we think are uploaded are still there
+		chunkWriter.chunkSize = resume.ChunkSize
+		chunkWriter.uploadID = aws.String(resume.UploadID)
+		eTags, err := f.listParts(ctx, chunkWriter.bucket, chunkWriter.key, chunkWriter.uploadID, mReq.RequestPayer)
+		if err != nil {
+			return info, nil, err
+		}
+		for _, part := range resume.Parts {
+			if eTags[part.PartNumber] != part.ETag {
+				return info, nil, fmt.Errorf("part %d of multipart upload %q is missing or changed", part.PartNumber, resume.UploadID)
+			}
+			chunkWriter.addCompletedPart(aws.Int32(part.PartNumber), aws.String(part.ETag))
+		}
+		fs.Debugf(o, "open chunk writer: resumed multipart upload: %v", resume.UploadID)
+	} else {
+		var mOut *s3.CreateMultipartUploadOutput
+		err = f.pacer.Call(func() (bool, error) {
+			mOut, err = f.c.CreateMultipartUpload(ctx, &mReq)
+			if err == nil {
+				if mOut == nil {
+					err = fserrors.RetryErrorf("internal error: no info from multipart upload")
+				} else if mOut.UploadId == nil {
+					err = fserrors.RetryErrorf("internal error: no UploadId in multipart upload: %#v", *mOut)
+				}
+			}
+			return f.shouldRetry(ctx, err)
+		})
+		if err != nil {
+			return info, nil, fmt.Errorf("create multipart upload failed: %w", err)
+		}
+		chunkWriter.uploadID = mOut.UploadId
+		fs.Debugf(o, "open chunk writer: started multipart upload: %v", *mOut.UploadId)
+	}
+
 	info = fs.ChunkWriterInfo{
-		ChunkSize:         int64(chunkSize),
+		ChunkSize:         chunkWriter.chunkSize,
 		Concurrency:       o.fs.opt.UploadConcurrency,
 		LeavePartsOnError: o.fs.opt.LeavePartsOnError,
 	}
-	fs.Debugf(o, "open chunk writer: started multipart upload: %v", *mOut.UploadId)
-	return info, chunkWriter, err
+	return info, chunkWriter, nil
+}
+
+// ChunkWriterState returns the upload ID and the state of the upload
+// so it can be resumed with ResumeChunkWriter
+func (w *s3ChunkWriter) ChunkWriterState() (uploadID string, state []byte, err error) {
+	w.completedPartsMu.Lock()
+	resume := s3ChunkWriterState{
+		UploadID:  *w.uploadID,
+		ChunkSize: w.chunkSize,
+		Parts:     make([]s3CompletedPart, 0, len(w.completedParts)),
+	}
+	for _, part := range w.completedParts {
+		resume.Parts = append(resume.Parts, s3CompletedPart{
+			PartNumber: *part.PartNumber,
+			ETag:       deref(part.ETag),
+		})
+	}
+	w.completedPartsMu.Unlock()
+	state, err = json.Marshal(resume)
+	return resume.UploadID, state, err
 }
 
 // add a part number and etag to the completed parts
+//
+// If the part was uploaded already, eg before a resume, it is replaced
 func (w *s3ChunkWriter) addCompletedPart(partNum *int32, eTag *string) {
 	w.completedPartsMu.Lock()
 	defer w.completedPartsMu.Unlock()
+	for i := range w.completedParts {
+		if *w.completedParts[i].PartNumber == *partNum {
+			w.completedParts[i].ETag = eTag
+			return
+		}
+	}
 	w.completedParts = append(w.completedParts, types.CompletedPart{
 		PartNumber: partNum,
 		ETag:       eTag,
@@ -32,9 +32,11 @@ docs = [
     "fichier.md",
     "alias.md",
     "s3.md",
+    "archive.md",
     "b2.md",
     "box.md",
     "cache.md",
+    "cdc.md",
     "chunker.md",
     "cloudinary.md",
     "sharefile.md",
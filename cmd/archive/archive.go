@@ -0,0 +1,249 @@
+// Package archive provides the archive command.
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cmd.Root.AddCommand(Command)
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "archive <action>",
+	Short: `Create and extract archives on remotes.`,
+	Long: `Rclone archive is used to pack a remote directory into an archive
+file or to unpack an archive file into a remote directory.
+
+Select which action you want with the subcommand, eg
+
+` + "```sh" + `
+rclone archive create remote:path remote:backup.tar.zst
+rclone archive extract remote:backup.zip remote:restored
+` + "```" + `
+
+The archive is streamed to or from the remote so nothing is staged on
+local disk.
+
+The supported formats are ` + "`zip`, `tar`, `tar.gz` and `tar.zst`" + `.
+The format is chosen from the extension of the archive file name
+unless the ` + "`--format`" + ` flag is used.
+
+Modification times are preserved. If ` + "`--metadata`/`-M`" + ` is in
+use then the metadata of files and directories is stored in the
+archive and restored when it is extracted. Tar archives keep the
+metadata in PAX records named ` + "`RCLONE.meta.<key>`" + ` and zip
+archives in a private extra field, so other tools will ignore it.
+
+Each subcommand has its own options which you can see in their help.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+}
+
+// format describes an archive format
+type format struct {
+	name       string   // name of the format
+	extensions []string // file extensions, lower case, including the leading .
+	isZip      bool     // set for zip, otherwise it is a tar
+	// compress and decompress are nil for uncompressed formats
+	compress   func(out io.Writer) (io.WriteCloser, error)
+	decompress func(in io.Reader) (io.ReadCloser, error)
+}
+
+// formats lists the supported archive formats
+var formats = []*format{
+	{
+		name:       "zip",
+		extensions: []string{".zip"},
+		isZip:      true,
+	},
+	{
+		name:       "tar",
+		extensions: []string{".tar"},
+	},
+	{
+		name:       "tar.gz",
+		extensions: []string{".tar.gz", ".tgz"},
+		compress: func(out io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(out), nil
+		},
+		decompress: func(in io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(in)
+		},
+	},
+	{
+		name:       "tar.zst",
+		extensions: []string{".tar.zst", ".tzst"},
+		compress: func(out io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(out)
+		},
+		decompress: func(in io.Reader) (io.ReadCloser, error) {
+			dec, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return dec.IOReadCloser(), nil
+		},
+	},
+}
+
+// formatNames returns the names of the supported formats
+func formatNames() string {
+	var names []string
+	for _, format := range formats {
+		names = append(names, format.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// findFormat returns the format called formatName if set, otherwise
+// the format matching the extension of fileName.
+func findFormat(formatName, fileName string) (*format, error) {
+	if formatName != "" {
+		for _, format := range formats {
+			if strings.EqualFold(format.name, formatName) {
+				return format, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown archive format %q - must be one of: %s", formatName, formatNames())
+	}
+	lowerName := strings.ToLower(fileName)
+	for _, format := range formats {
+		for _, ext := range format.extensions {
+			if strings.HasSuffix(lowerName, ext) {
+				return format, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("can't work out archive format from %q - use --format with one of: %s", fileName, formatNames())
+}
+
+// cleanName cleans up a name read from an archive so that it can't
+// escape the destination and has no leading or trailing /
+func cleanName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// metadataTimeFormat is the format used for times in metadata
+const metadataTimeFormat = time.RFC3339Nano
+
+// paxMetadataPrefix is the prefix of the PAX records used to store
+// metadata in tar archives
+const paxMetadataPrefix = "RCLONE.meta."
+
+// tarHeader makes a tar header for name from the metadata passed in
+func tarHeader(name string, typeflag byte, size int64, modTime time.Time, meta fs.Metadata) *tar.Header {
+	hdr := &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	}
+	if typeflag == tar.TypeDir {
+		hdr.Mode = 0755
+	}
+	if mode, err := strconv.ParseInt(meta["mode"], 8, 64); err == nil {
+		hdr.Mode = mode & 07777
+	}
+	if uid, err := strconv.Atoi(meta["uid"]); err == nil {
+		hdr.Uid = uid
+	}
+	if gid, err := strconv.Atoi(meta["gid"]); err == nil {
+		hdr.Gid = gid
+	}
+	hdr.Uname = meta["uname"]
+	hdr.Gname = meta["gname"]
+	if len(meta) > 0 {
+		hdr.PAXRecords = make(map[string]string, len(meta))
+		for k, v := range meta {
+			hdr.PAXRecords[paxMetadataPrefix+k] = v
+		}
+	}
+	return hdr
+}
+
+// tarMetadata reads the metadata from a tar header.
+//
+// Metadata stored by rclone is used in preference to the header
+// fields.
+func tarMetadata(hdr *tar.Header) fs.Metadata {
+	meta := fs.Metadata{
+		"mtime": hdr.ModTime.Format(metadataTimeFormat),
+		"mode":  fmt.Sprintf("%o", hdr.Mode),
+		"uid":   strconv.Itoa(hdr.Uid),
+		"gid":   strconv.Itoa(hdr.Gid),
+	}
+	if hdr.Uname != "" {
+		meta["uname"] = hdr.Uname
+	}
+	if hdr.Gname != "" {
+		meta["gname"] = hdr.Gname
+	}
+	for k, v := range hdr.PAXRecords {
+		if key, found := strings.CutPrefix(k, paxMetadataPrefix); found {
+			meta[key] = v
+		}
+	}
+	return meta
+}
+
+// zipExtraID is the ID of the zip extra field used to store metadata
+// as JSON. It is in the range not reserved by the zip specification.
+const zipExtraID = 0x5243 // "CR"
+
+// zipExtra encodes meta as a zip extra field returning nil if it
+// can't be stored.
+func zipExtra(meta fs.Metadata) []byte {
+	if len(meta) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil || len(data) > 0xFFFF {
+		return nil
+	}
+	extra := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint16(extra[0:], zipExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(len(data)))
+	return append(extra, data...)
+}
+
+// zipMetadata reads the metadata stored by zipExtra from the extra
+// fields of a zip file, returning nil if not found.
+func zipMetadata(extra []byte) fs.Metadata {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == zipExtraID {
+			var meta fs.Metadata
+			if json.Unmarshal(extra[:size], &meta) == nil {
+				return meta
+			}
+		}
+		extra = extra[size:]
+	}
+	return nil
+}
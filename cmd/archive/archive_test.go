@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	t1 = fstest.Time("2017-02-03T04:05:06Z")
+	t2 = fstest.Time("2019-07-08T09:10:11Z")
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+func TestFindFormat(t *testing.T) {
+	for _, test := range []struct {
+		formatName string
+		fileName   string
+		want       string
+	}{
+		{"", "backup.zip", "zip"},
+		{"", "backup.TAR", "tar"},
+		{"", "backup.tar.gz", "tar.gz"},
+		{"", "backup.tgz", "tar.gz"},
+		{"", "backup.tar.zst", "tar.zst"},
+		{"zip", "backup", "zip"},
+		{"TAR.ZST", "backup.zip", "tar.zst"},
+		{"", "backup", ""},
+		{"rar", "backup.zip", ""},
+	} {
+		format, err := findFormat(test.formatName, test.fileName)
+		if test.want == "" {
+			assert.Error(t, err, test.fileName)
+			continue
+		}
+		require.NoError(t, err, test.fileName)
+		assert.Equal(t, test.want, format.name, test.fileName)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	for in, want := range map[string]string{
+		"file.txt":         "file.txt",
+		"/dir/file.txt":    "dir/file.txt",
+		"dir/":             "dir",
+		"../../etc/passwd": "etc/passwd",
+		"a\\b":             "a/b",
+		".":                "",
+	} {
+		assert.Equal(t, want, cleanName(in), in)
+	}
+}
+
+func TestTarMetadata(t *testing.T) {
+	meta := fs.Metadata{"mode": "100640", "uid": "1000", "gid": "100", "uname": "alice", "btime": "2001-02-03T04:05:06Z"}
+	hdr := tarHeader("file.txt", tar.TypeReg, 10, t1, meta)
+	assert.Equal(t, int64(0640), hdr.Mode)
+	assert.Equal(t, 1000, hdr.Uid)
+	assert.Equal(t, 100, hdr.Gid)
+	assert.Equal(t, "alice", hdr.Uname)
+	got := tarMetadata(hdr)
+	for k, v := range meta {
+		assert.Equal(t, v, got[k], k)
+	}
+	assert.Equal(t, "640", tarMetadata(&tar.Header{Mode: 0640})["mode"])
+}
+
+func TestZipMetadata(t *testing.T) {
+	meta := fs.Metadata{"mode": "644", "btime": "2001-02-03T04:05:06Z"}
+	extra := zipExtra(meta)
+	// another extra field before ours
+	other := []byte{0x55, 0x54, 0x01, 0x00, 0x00}
+	assert.Equal(t, meta, zipMetadata(append(other, extra...)))
+	assert.Nil(t, zipMetadata(other))
+	assert.Nil(t, zipMetadata(extra[:5]))
+	assert.Nil(t, zipExtra(nil))
+}
+
+func TestCreateExtract(t *testing.T) {
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := fstest.NewRun(t)
+			file1 := r.WriteFile("file1.txt", "hello world", t1)
+			file2 := r.WriteFile("dir/file2.txt", "potato", t2)
+			file3 := r.WriteFile("dir/sub/empty.txt", "", t1)
+
+			archiveName := "archive" + format.extensions[0]
+			require.NoError(t, Create(ctx, r.Flocal, r.Fremote, archiveName, ""))
+			src, err := r.Fremote.NewObject(ctx, archiveName)
+			require.NoError(t, err)
+
+			fdst, err := fs.NewFs(ctx, r.FremoteName+"/out")
+			require.NoError(t, err)
+			require.NoError(t, Extract(ctx, src, fdst, ""))
+			fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{file1, file2, file3}, []string{"dir", "dir/sub"}, time.Second)
+		})
+	}
+}
+
+func TestExtractFiltered(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	file1 := r.WriteFile("file1.txt", "hello world", t1)
+	r.WriteFile("dir/file2.jpg", "potato", t2)
+	require.NoError(t, Create(ctx, r.Flocal, r.Fremote, "archive.tar", ""))
+	src, err := r.Fremote.NewObject(ctx, "archive.tar")
+	require.NoError(t, err)
+
+	fi, err := filter.NewFilter(nil)
+	require.NoError(t, err)
+	require.NoError(t, fi.AddRule("+ *.txt"))
+	require.NoError(t, fi.AddRule("- *"))
+	filterCtx := filter.ReplaceConfig(ctx, fi)
+
+	fdst, err := fs.NewFs(ctx, r.FremoteName+"/out")
+	require.NoError(t, err)
+	require.NoError(t, Extract(filterCtx, src, fdst, ""))
+	fstest.CheckListingWithPrecision(t, fdst, []fstest.Item{file1}, []string{}, time.Second)
+}
@@ -0,0 +1,248 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/spf13/cobra"
+)
+
+var (
+	createFormat = ""
+)
+
+func init() {
+	Command.AddCommand(createDefinition)
+	cmdFlags := createDefinition.Flags()
+	flags.StringVarP(cmdFlags, &createFormat, "format", "", createFormat, "Archive format to create: "+formatNames(), "")
+}
+
+var createDefinition = &cobra.Command{
+	Use:   "create source:path dest:path/to/archive",
+	Short: `Create an archive from the files in source:path.`,
+	Long: `Packs the files and directories in source:path into the archive file
+dest:path/to/archive.
+
+` + "```sh" + `
+rclone archive create remote:photos remote:backup/photos.tar.zst
+rclone archive create --format zip remote:docs remote:docs-archive
+` + "```" + `
+
+The archive is written as it is made with streaming upload so no
+local disk space is used, see ` + "[rcat](/commands/rclone_rcat/)" + `
+for the details and limitations of streaming uploads.
+
+Filter flags can be used to choose which files go into the archive.
+
+Files are stored in the archive with their paths relative to
+source:path, along with their modification times. If
+` + "`--metadata`/`-M`" + ` is in use then their metadata is stored too.
+
+Files of unknown size can't be stored in tar archives.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc := cmd.NewFsSrc(args)
+		fdst, dstFileName := cmd.NewFsDstFile(args[1:])
+		cmd.Run(false, true, command, func() error {
+			return Create(context.Background(), fsrc, fdst, dstFileName, createFormat)
+		})
+	},
+}
+
+// archiveWriter writes entries to an archive
+type archiveWriter interface {
+	// dir adds the directory name
+	dir(name string, modTime time.Time, meta fs.Metadata) error
+	// file adds the file name reading size bytes from in
+	file(name string, size int64, modTime time.Time, meta fs.Metadata, in io.Reader) error
+	// Close finishes the archive
+	Close() error
+}
+
+// tarWriter writes tar archives
+type tarWriter struct {
+	tw  *tar.Writer
+	out io.WriteCloser // compressor or nil
+}
+
+func newTarWriter(out io.Writer, compress func(io.Writer) (io.WriteCloser, error)) (*tarWriter, error) {
+	w := &tarWriter{}
+	if compress != nil {
+		var err error
+		w.out, err = compress(out)
+		if err != nil {
+			return nil, err
+		}
+		out = w.out
+	}
+	w.tw = tar.NewWriter(out)
+	return w, nil
+}
+
+func (w *tarWriter) dir(name string, modTime time.Time, meta fs.Metadata) error {
+	return w.tw.WriteHeader(tarHeader(name+"/", tar.TypeDir, 0, modTime, meta))
+}
+
+func (w *tarWriter) file(name string, size int64, modTime time.Time, meta fs.Metadata, in io.Reader) error {
+	if size < 0 {
+		return fmt.Errorf("can't store %q in a tar archive as its size is unknown", name)
+	}
+	err := w.tw.WriteHeader(tarHeader(name, tar.TypeReg, size, modTime, meta))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w.tw, in)
+	return err
+}
+
+func (w *tarWriter) Close() (err error) {
+	err = w.tw.Close()
+	if w.out != nil {
+		fs.CheckClose(w.out, &err)
+	}
+	return err
+}
+
+// zipWriter writes zip archives
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func newZipWriter(out io.Writer) *zipWriter {
+	return &zipWriter{zw: zip.NewWriter(out)}
+}
+
+// header makes a zip header
+func (w *zipWriter) header(name string, modTime time.Time, meta fs.Metadata, isDir bool) *zip.FileHeader {
+	fh := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+		Extra:    zipExtra(meta),
+	}
+	mode := os.FileMode(0644)
+	if isDir {
+		fh.Name += "/"
+		fh.Method = zip.Store
+		mode = 0755 | os.ModeDir
+	}
+	if metaMode, err := strconv.ParseUint(meta["mode"], 8, 32); err == nil {
+		mode = mode&^os.ModePerm | os.FileMode(metaMode)&os.ModePerm
+	}
+	fh.SetMode(mode)
+	return fh
+}
+
+func (w *zipWriter) dir(name string, modTime time.Time, meta fs.Metadata) error {
+	_, err := w.zw.CreateHeader(w.header(name, modTime, meta, true))
+	return err
+}
+
+func (w *zipWriter) file(name string, size int64, modTime time.Time, meta fs.Metadata, in io.Reader) error {
+	out, err := w.zw.CreateHeader(w.header(name, modTime, meta, false))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// writeArchive writes the contents of fsrc to out as an archive in
+// the format given
+func writeArchive(ctx context.Context, fsrc fs.Fs, format *format, out io.Writer) (err error) {
+	ci := fs.GetConfig(ctx)
+	var w archiveWriter
+	if format.isZip {
+		w = newZipWriter(out)
+	} else {
+		w, err = newTarWriter(out, format.compress)
+		if err != nil {
+			return err
+		}
+	}
+	defer fs.CheckClose(w, &err)
+	// fn is never called concurrently and parents are seen before children
+	return walk.Walk(ctx, fsrc, "", false, -1, func(dirPath string, entries fs.DirEntries, err error) error {
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			var meta fs.Metadata
+			if ci.Metadata {
+				meta, err = fs.GetMetadata(ctx, entry)
+				if err != nil {
+					return fmt.Errorf("failed to read metadata of %q: %w", entry.Remote(), err)
+				}
+			}
+			switch x := entry.(type) {
+			case fs.Directory:
+				err = w.dir(x.Remote(), x.ModTime(ctx), meta)
+			case fs.Object:
+				err = addObject(ctx, w, x, meta)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to add %q to archive: %w", entry.Remote(), err)
+			}
+		}
+		return nil
+	})
+}
+
+// addObject adds the object o to the archive
+func addObject(ctx context.Context, w archiveWriter, o fs.Object, meta fs.Metadata) (err error) {
+	in, err := operations.Open(ctx, o)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	fs.Debugf(o, "Adding to archive")
+	return w.file(o.Remote(), o.Size(), o.ModTime(ctx), meta, in)
+}
+
+// Create makes an archive called dstFileName in fdst from the
+// contents of fsrc.
+//
+// If formatName is empty then the format is worked out from
+// dstFileName.
+func Create(ctx context.Context, fsrc fs.Fs, fdst fs.Fs, dstFileName string, formatName string) error {
+	format, err := findFormat(formatName, dstFileName)
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	writeErr := make(chan error, 1)
+	go func() {
+		err := writeArchive(ctx, fsrc, format, pw)
+		_ = pw.CloseWithError(err)
+		writeErr <- err
+	}()
+	_, err = operations.Rcat(ctx, fdst, dstFileName, pr, time.Now(), nil)
+	// Make sure the writer stops if the upload failed
+	_ = pr.CloseWithError(fmt.Errorf("archive upload finished: %w", io.ErrClosedPipe))
+	wErr := <-writeErr
+	if err != nil {
+		return fmt.Errorf("failed to upload archive: %w", err)
+	}
+	if wErr != nil {
+		return fmt.Errorf("failed to create archive: %w", wErr)
+	}
+	return nil
+}
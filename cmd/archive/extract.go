@@ -0,0 +1,235 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+var (
+	extractFormat = ""
+)
+
+func init() {
+	Command.AddCommand(extractDefinition)
+	cmdFlags := extractDefinition.Flags()
+	flags.StringVarP(cmdFlags, &extractFormat, "format", "", extractFormat, "Archive format to extract: "+formatNames(), "")
+}
+
+var extractDefinition = &cobra.Command{
+	Use:   "extract source:path/to/archive dest:path",
+	Short: `Extract the files in an archive to dest:path.`,
+	Long: `Unpacks the archive file source:path/to/archive into dest:path.
+
+` + "```sh" + `
+rclone archive extract remote:backup/photos.tar.zst remote:photos
+` + "```" + `
+
+The archive is read as a stream, except for zip files where the
+directory at the end is read first then the files are read with range
+requests. No local disk space is used.
+
+Filter flags can be used to choose which files are extracted. They
+are applied to the paths inside the archive. When filters are in use
+only the directories needed for the extracted files are made.
+
+Files are uploaded with the modification times stored in the archive.
+If ` + "`--metadata`/`-M`" + ` is in use then the metadata stored in the
+archive is restored too.
+
+Symbolic links, hard links and other special files in the archive are
+skipped. Paths which would escape dest:path are cleaned so they stay
+inside it.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc, srcFileName, fdst := cmd.NewFsSrcFileDst(args)
+		cmd.Run(false, true, command, func() error {
+			ctx := context.Background()
+			if srcFileName == "" {
+				return errors.New("source must be an archive file")
+			}
+			src, err := fsrc.NewObject(ctx, srcFileName)
+			if err != nil {
+				return err
+			}
+			return Extract(ctx, src, fdst, extractFormat)
+		})
+	},
+}
+
+// blockSize is the size of the range requests used to read zip files
+const blockSize = 1024 * 1024
+
+// extractor writes the entries of an archive to a remote
+type extractor struct {
+	ctx     context.Context
+	ci      *fs.ConfigInfo
+	fi      *filter.Filter
+	fdst    fs.Fs
+	dirs    []string    // directories seen in the archive
+	modTime []time.Time // ... and their modification times
+}
+
+// dir makes the directory name
+func (x *extractor) dir(name string, modTime time.Time, meta fs.Metadata) (err error) {
+	if !x.fi.InActive() {
+		// Directories needed for the included files are made
+		// when they are uploaded
+		return nil
+	}
+	if x.ci.Metadata && len(meta) > 0 {
+		_, err = operations.MkdirMetadata(x.ctx, x.fdst, name, meta)
+	} else {
+		err = operations.Mkdir(x.ctx, x.fdst, name)
+	}
+	if err != nil {
+		return err
+	}
+	// Set the modification times at the end as writing the files
+	// changes them
+	x.dirs = append(x.dirs, name)
+	x.modTime = append(x.modTime, modTime)
+	return nil
+}
+
+// file uploads the file name reading it from in
+func (x *extractor) file(name string, size int64, modTime time.Time, meta fs.Metadata, in io.ReadCloser) error {
+	if !x.fi.Include(name, size, modTime, meta) {
+		fs.Debugf(name, "Excluded from extract")
+		return in.Close()
+	}
+	if !x.ci.Metadata {
+		meta = nil
+	}
+	_, err := operations.Rcat(x.ctx, x.fdst, name, in, modTime, meta)
+	return err
+}
+
+// finish sets the modification times of the directories made
+func (x *extractor) finish() error {
+	for i := len(x.dirs) - 1; i >= 0; i-- {
+		_, err := operations.SetDirModTime(x.ctx, x.fdst, nil, x.dirs[i], x.modTime[i])
+		if err != nil && !errors.Is(err, fs.ErrorNotImplemented) {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZip extracts the zip file src
+func (x *extractor) extractZip(src fs.Object) error {
+	ra := operations.NewReaderAt(x.ctx, src, blockSize)
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		name := cleanName(zf.Name)
+		if name == "" {
+			continue
+		}
+		meta := zipMetadata(zf.Extra)
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.dir(name, zf.Modified, meta)
+		case mode.IsRegular():
+			var in io.ReadCloser
+			in, err = zf.Open()
+			if err == nil {
+				err = x.file(name, int64(zf.UncompressedSize64), zf.Modified, meta, in)
+			}
+		default:
+			fs.Logf(src, "Skipping %q in archive as not a regular file", zf.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %q: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+// extractTar extracts the tar file src decompressing it if required
+func (x *extractor) extractTar(src fs.Object, decompress func(io.Reader) (io.ReadCloser, error)) (err error) {
+	rc, err := operations.Open(x.ctx, src)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(rc, &err)
+	var in io.Reader = rc
+	if decompress != nil {
+		var dec io.ReadCloser
+		dec, err = decompress(rc)
+		if err != nil {
+			return err
+		}
+		defer fs.CheckClose(dec, &err)
+		in = dec
+	}
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := cleanName(hdr.Name)
+		if name == "" {
+			continue
+		}
+		meta := tarMetadata(hdr)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(name, hdr.ModTime, meta)
+		case tar.TypeReg:
+			err = x.file(name, hdr.Size, hdr.ModTime, meta, io.NopCloser(tr))
+		default:
+			fs.Logf(src, "Skipping %q in archive as not a regular file", hdr.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %q: %w", hdr.Name, err)
+		}
+	}
+}
+
+// Extract unpacks the archive src into fdst.
+//
+// If formatName is empty then the format is worked out from the name
+// of src.
+func Extract(ctx context.Context, src fs.Object, fdst fs.Fs, formatName string) (err error) {
+	format, err := findFormat(formatName, src.Remote())
+	if err != nil {
+		return err
+	}
+	x := &extractor{
+		ctx:  ctx,
+		ci:   fs.GetConfig(ctx),
+		fi:   filter.GetConfig(ctx),
+		fdst: fdst,
+	}
+	if format.isZip {
+		err = x.extractZip(src)
+	} else {
+		err = x.extractTar(src, format.decompress)
+	}
+	if err != nil {
+		return err
+	}
+	return x.finish()
+}
@@ -0,0 +1,263 @@
+// Package backup provides the backup command.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sync/errgroup"
+)
+
+// Options for pruning old snapshots
+type Options struct {
+	KeepDaily  int // number of daily snapshots to keep
+	KeepWeekly int // number of weekly snapshots to keep
+}
+
+// pruning returns true if any of the --keep flags are set
+func (opt *Options) pruning() bool {
+	return opt.KeepDaily > 0 || opt.KeepWeekly > 0
+}
+
+var opt = Options{}
+
+// addKeepFlags adds the flags to choose which snapshots to keep
+func addKeepFlags(cmdFlags *pflag.FlagSet) {
+	flags.IntVarP(cmdFlags, &opt.KeepDaily, "keep-daily", "", opt.KeepDaily, "Keep the last snapshot of each of this many days", "")
+	flags.IntVarP(cmdFlags, &opt.KeepWeekly, "keep-weekly", "", opt.KeepWeekly, "Keep the last snapshot of each of this many weeks", "")
+}
+
+func init() {
+	cmd.Root.AddCommand(Command)
+	addKeepFlags(Command.Flags())
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "backup source:path dest:path",
+	Short: `Make a snapshot of source:path in dest:path.`,
+	Long: `Makes a point in time snapshot of the files in source:path and
+stores it in dest:path. Use the subcommands to list the snapshots,
+restore them and remove old ones.
+
+` + "```sh" + `
+rclone backup remote:photos backups:photos
+rclone backup list backups:photos
+rclone backup restore --at 2025-01-02 backups:photos remote:photos-restored
+rclone backup prune --keep-daily 7 --keep-weekly 4 backups:photos
+` + "```" + `
+
+Each snapshot is stored as a manifest listing the files in it in
+` + "`dest:path/snapshots`" + `. The contents of the files are stored
+once in ` + "`dest:path/data`" + ` named by their hash so files which
+are unchanged since the previous snapshot, or which have the same
+contents as another file, aren't uploaded again. If the source doesn't
+support hashes then files are only considered unchanged if their path,
+size and modification time are the same.
+
+If source:path and dest:path are on the same remote then new contents
+are copied with server-side copies where possible.
+
+Filter flags can be used to choose which files are backed up.
+
+The snapshot is only written once all the files have been stored, so an
+interrupted backup doesn't leave a partial snapshot behind, and running
+it again will only upload what is missing. Files which fail to be backed
+up are left out of the snapshot and reported as errors.
+
+If ` + "`--keep-daily` or `--keep-weekly`" + ` are used then old
+snapshots are removed after the backup is made as described in
+` + "[rclone backup prune](/commands/rclone_backup_prune/)" + `.
+
+While a backup is running it holds a lock file in
+` + "`dest:path/locks`" + ` so that ` + "`rclone backup prune`" + ` won't run
+at the same time. If rclone is killed the lock file is left behind
+but is ignored once it is 30 minutes old.
+
+Modification times are preserved but metadata isn't.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fsrc, fdst := cmd.NewFsSrcDst(args)
+		cmd.Run(true, true, command, func() error {
+			ctx := context.Background()
+			_, err := Backup(ctx, fsrc, fdst)
+			if err != nil || !opt.pruning() {
+				return err
+			}
+			return Prune(ctx, fdst, opt)
+		})
+	},
+}
+
+// backupFile is a file being backed up
+type backupFile struct {
+	src  fs.Object
+	file *snapshotFile
+}
+
+// Backup makes a snapshot of fsrc in fdst returning its ID.
+func Backup(ctx context.Context, fsrc, fdst fs.Fs) (id string, err error) {
+	ci := fs.GetConfig(ctx)
+	now := time.Now()
+	m := &manifest{
+		Version:  manifestVersion,
+		Time:     now,
+		Source:   fs.ConfigString(fsrc),
+		HashType: fsrc.Hashes().GetOne().String(),
+		id:       snapshotID(now),
+	}
+	var hashType hash.Type
+	_ = hashType.Set(m.HashType)
+
+	// Stop a prune removing the contents this backup uses until the
+	// snapshot is saved
+	l, err := takeLock(ctx, fdst, lockBackup, lockPrune)
+	if err != nil {
+		return "", err
+	}
+	defer l.unlock(ctx)
+
+	// Unchanged files can use the entries of the previous snapshot
+	// rather than being hashed again
+	prev, err := loadLatest(ctx, fdst, time.Time{})
+	if err != nil {
+		return "", err
+	}
+	if prev != nil && prev.HashType != m.HashType {
+		prev = nil
+	}
+	have, err := listData(ctx, fdst)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		mu     sync.Mutex
+		files  []*backupFile
+		failed atomic.Int64
+	)
+	// The errors have been counted in the stats already
+	fail := func(o fs.DirEntry, what string, err error) {
+		fs.Errorf(o, "Failed to %s: %v", what, err)
+		failed.Add(1)
+	}
+
+	// Find the keys of the source files
+	g := new(errgroup.Group)
+	g.SetLimit(ci.Transfers)
+	err = walk.ListR(ctx, fsrc, "", false, ci.MaxDepth, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			o, ok := entry.(fs.Object)
+			if !ok {
+				continue
+			}
+			g.Go(func() error {
+				file, err := newSnapshotFile(ctx, o, hashType, prev)
+				if err != nil {
+					fail(o, "read hash", err)
+					return nil
+				}
+				mu.Lock()
+				files = append(files, &backupFile{src: o, file: file})
+				mu.Unlock()
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		_ = g.Wait()
+		return "", fmt.Errorf("failed to list source: %w", err)
+	}
+	_ = g.Wait()
+
+	// Upload the contents which aren't in the backup yet, once per key
+	uploads := make(map[string]*backupFile)
+	for _, f := range files {
+		if _, found := have[f.file.Key]; !found && uploads[f.file.Key] == nil {
+			uploads[f.file.Key] = f
+		}
+	}
+	uploadFailed := make(map[string]struct{})
+	for key, f := range uploads {
+		g.Go(func() error {
+			_, err := operations.Copy(ctx, fdst, nil, dataRemote(key), f.src)
+			if err != nil {
+				fail(f.src, "back up", err)
+				mu.Lock()
+				uploadFailed[key] = struct{}{}
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	// Write the snapshot with the files which were stored
+	for _, f := range files {
+		if _, found := uploadFailed[f.file.Key]; !found {
+			m.Files = append(m.Files, f.file)
+		}
+	}
+	slices.SortFunc(m.Files, func(a, b *snapshotFile) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	err = m.save(ctx, fdst)
+	if err != nil {
+		return "", err
+	}
+	fs.Infof(fdst, "Saved snapshot %s with %d files (%d new) totalling %v", m.id, len(m.Files), len(uploads)-len(uploadFailed), fs.SizeSuffix(m.size()).ByteUnit())
+	if n := failed.Load(); n > 0 {
+		return m.id, fmt.Errorf("failed to back up %d files", n)
+	}
+	return m.id, nil
+}
+
+// newSnapshotFile makes the entry in the snapshot for o.
+//
+// If o is unchanged since the previous snapshot prev, which may be
+// nil, then its entry is reused, otherwise o is hashed.
+func newSnapshotFile(ctx context.Context, o fs.Object, hashType hash.Type, prev *manifest) (file *snapshotFile, err error) {
+	file = &snapshotFile{
+		Path:    o.Remote(),
+		Size:    o.Size(),
+		ModTime: o.ModTime(ctx),
+	}
+	if prev != nil {
+		if old := prev.lookup(file.Path); old != nil && old.Size == file.Size && old.ModTime.Equal(file.ModTime) {
+			file.Hash = old.Hash
+			file.Key = old.Key
+			return file, nil
+		}
+	}
+	if hashType != hash.None {
+		tr := accounting.Stats(ctx).NewCheckingTransfer(o, "hashing")
+		file.Hash, err = o.Hash(ctx, hashType)
+		tr.Done(ctx, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	file.Key = dataKey(hashType, file.Hash, file.Path, file.Size, file.ModTime)
+	return file, nil
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	t1 = fstest.Time("2017-02-03T04:05:06Z")
+	t2 = fstest.Time("2019-07-08T09:10:11Z")
+)
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fstest.TestMain(m)
+}
+
+func TestPruneKeep(t *testing.T) {
+	// The periods are in local time
+	oldLocal := time.Local
+	time.Local = time.UTC
+	defer func() {
+		time.Local = oldLocal
+	}()
+	ids := []string{
+		"2025-01-01T100000.000Z", // Wednesday
+		"2025-01-01T120000.000Z",
+		"2025-01-03T120000.000Z",
+		"2025-01-05T120000.000Z", // Sunday
+		"2025-01-06T100000.000Z", // Monday
+		"2025-01-06T120000.000Z",
+	}
+	for _, test := range []struct {
+		opt  Options
+		want []string
+	}{
+		{Options{KeepDaily: 1}, []string{"2025-01-06T120000.000Z"}},
+		{Options{KeepDaily: 3}, []string{"2025-01-03T120000.000Z", "2025-01-05T120000.000Z", "2025-01-06T120000.000Z"}},
+		{Options{KeepDaily: 10}, []string{"2025-01-01T120000.000Z", "2025-01-03T120000.000Z", "2025-01-05T120000.000Z", "2025-01-06T120000.000Z"}},
+		{Options{KeepWeekly: 2}, []string{"2025-01-05T120000.000Z", "2025-01-06T120000.000Z"}},
+		{Options{KeepDaily: 1, KeepWeekly: 2}, []string{"2025-01-05T120000.000Z", "2025-01-06T120000.000Z"}},
+	} {
+		keep := pruneKeep(ids, test.opt)
+		var got []string
+		for _, id := range ids {
+			if _, found := keep[id]; found {
+				got = append(got, id)
+			}
+		}
+		assert.Equal(t, test.want, got, test.opt)
+	}
+}
+
+func TestBackupRestorePrune(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+
+	// First snapshot
+	file1 := r.WriteFile("one.txt", "one", t1)
+	file2 := r.WriteFile("dir/two.txt", "two", t1)
+	id1, err := Backup(ctx, r.Flocal, r.Fremote)
+	require.NoError(t, err)
+	data, err := listData(ctx, r.Fremote)
+	require.NoError(t, err)
+	assert.Len(t, data, 2)
+
+	// Second snapshot with a changed file and a duplicate
+	time.Sleep(10 * time.Millisecond)
+	file2b := r.WriteFile("dir/two.txt", "TWO", t2)
+	file3 := r.WriteFile("three.txt", "one", t2)
+	id2, err := Backup(ctx, r.Flocal, r.Fremote)
+	require.NoError(t, err)
+	assert.NotEqual(t, id1, id2)
+	data, err = listData(ctx, r.Fremote)
+	require.NoError(t, err)
+	assert.Len(t, data, 3)
+
+	ids, err := listSnapshots(ctx, r.Fremote)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id1, id2}, ids)
+
+	// Restore the latest snapshot
+	fdst, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, Restore(ctx, r.Fremote, fdst, time.Time{}))
+	fstest.CheckItems(t, fdst, file1, file2b, file3)
+
+	// Restore the first snapshot over it
+	at, err := time.Parse(snapshotIDFmt, id1)
+	require.NoError(t, err)
+	require.NoError(t, Restore(ctx, r.Fremote, fdst, at))
+	fstest.CheckItems(t, fdst, file1, file2, file3)
+
+	// No snapshot before the first
+	assert.Error(t, Restore(ctx, r.Fremote, fdst, at.Add(-time.Second)))
+
+	// Pruning needs a policy
+	assert.Error(t, Prune(ctx, r.Fremote, Options{}))
+
+	// Both snapshots were made today so only the second is kept
+	require.NoError(t, Prune(ctx, r.Fremote, Options{KeepDaily: 1}))
+	ids, err = listSnapshots(ctx, r.Fremote)
+	require.NoError(t, err)
+	assert.Equal(t, []string{id2}, ids)
+	data, err = listData(ctx, r.Fremote)
+	require.NoError(t, err)
+	assert.Len(t, data, 2)
+
+	// The remaining snapshot can still be restored
+	fdst, err = fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, Restore(ctx, r.Fremote, fdst, time.Time{}))
+	fstest.CheckItems(t, fdst, file1, file2b, file3)
+}
+
+func TestLocks(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	r.WriteFile("one.txt", "one", t1)
+	_, err := Backup(ctx, r.Flocal, r.Fremote)
+	require.NoError(t, err)
+
+	// A running backup stops a prune and vice versa
+	l, err := takeLock(ctx, r.Fremote, lockBackup, lockPrune)
+	require.NoError(t, err)
+	err = Prune(ctx, r.Fremote, Options{KeepDaily: 1})
+	assert.ErrorContains(t, err, "a backup is running")
+	_, err = takeLock(ctx, r.Fremote, lockPrune, lockBackup)
+	assert.Error(t, err)
+
+	// Backups don't stop each other
+	l2, err := takeLock(ctx, r.Fremote, lockBackup, lockPrune)
+	require.NoError(t, err)
+	l2.unlock(ctx)
+
+	// Stale locks are ignored
+	obj, err := r.Fremote.NewObject(ctx, l.remote)
+	require.NoError(t, err)
+	require.NoError(t, obj.SetModTime(ctx, time.Now().Add(-2*lockStale)))
+	require.NoError(t, Prune(ctx, r.Fremote, Options{KeepDaily: 1}))
+	l.unlock(ctx)
+
+	// The lock files are removed
+	entries, err := r.Fremote.List(ctx, lockDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+}
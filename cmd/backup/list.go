@@ -0,0 +1,58 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	Command.AddCommand(listDefinition)
+}
+
+var listDefinition = &cobra.Command{
+	Use:   "list dest:path",
+	Short: `List the snapshots in dest:path.`,
+	Long: `Lists the snapshots made by ` + "`rclone backup`" + ` in dest:path,
+oldest first, showing the ID of each snapshot, when it was made, the
+number of files in it and their total size.
+
+` + "```sh" + `
+$ rclone backup list backups:photos
+2025-01-01T020000.000Z  2025-01-01 02:00:00  1234 files  5.432 GiB
+2025-01-02T020000.000Z  2025-01-02 02:00:00  1236 files  5.441 GiB
+` + "```" + `
+
+The time shown can be used with ` + "`rclone backup restore --at`" + `.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsDir(args)
+		cmd.Run(false, false, command, func() error {
+			return List(context.Background(), f)
+		})
+	},
+}
+
+// List prints the snapshots in f to standard output
+func List(ctx context.Context, f fs.Fs) error {
+	ids, err := listSnapshots(ctx, f)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		m, err := loadManifest(ctx, f, id)
+		if err != nil {
+			fs.Errorf(f, "%v", err)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s  %s  %d files  %v\n", id, m.Time.Local().Format("2006-01-02 15:04:05"), len(m.Files), fs.SizeSuffix(m.size()).ByteUnit())
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// Lock files stop prune removing contents which a backup running at
+// the same time has uploaded, or is relying on, but hasn't written
+// the snapshot for yet.
+//
+// Each backup and prune writes a lock file into lockDir and then
+// checks for locks of the other kind, so if they start at the same
+// time at least one of them will see the other and stop. The lock
+// files are rewritten regularly while held so the locks of rclone
+// processes which were killed can be recognised as stale.
+const (
+	lockDir     = "locks" // directory the lock files are kept in
+	lockExt     = ".lock" // extension of the lock files
+	lockBackup  = "backup"
+	lockPrune   = "prune"
+	lockRefresh = 5 * time.Minute  // how often the lock files are rewritten
+	lockStale   = 30 * time.Minute // locks not rewritten for this long are ignored
+)
+
+// lock is a lock file held in a backup destination
+type lock struct {
+	f      fs.Fs
+	remote string
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// takeLock writes a lock file of kind in f and then checks there
+// are no locks of the conflicting kind.
+func takeLock(ctx context.Context, f fs.Fs, kind, conflict string) (*lock, error) {
+	l := &lock{
+		f:      f,
+		remote: path.Join(lockDir, kind+"-"+snapshotID(time.Now())+"-"+random.String(8)+lockExt),
+		stop:   make(chan struct{}),
+	}
+	if err := l.write(ctx); err != nil {
+		return nil, err
+	}
+	if err := checkLocks(ctx, f, conflict); err != nil {
+		l.unlock(ctx)
+		return nil, err
+	}
+	l.wg.Add(1)
+	go l.refresher(ctx)
+	return l, nil
+}
+
+// write the lock file
+func (l *lock) write(ctx context.Context) error {
+	host, _ := os.Hostname()
+	data := fmt.Appendf(nil, "host=%s pid=%d\n", host, os.Getpid())
+	_, err := operations.RcatSize(ctx, l.f, l.remote, io.NopCloser(bytes.NewReader(data)), int64(len(data)), time.Now(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+// refresher rewrites the lock file until it is unlocked
+func (l *lock) refresher(ctx context.Context) {
+	defer l.wg.Done()
+	ticker := time.NewTicker(lockRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.write(ctx); err != nil {
+				fs.Errorf(l.f, "%v", err)
+			}
+		}
+	}
+}
+
+// unlock stops refreshing the lock file and removes it
+func (l *lock) unlock(ctx context.Context) {
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+	l.wg.Wait()
+	obj, err := l.f.NewObject(ctx, l.remote)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return
+	} else if err == nil {
+		err = operations.DeleteFile(ctx, obj)
+	}
+	if err != nil {
+		fs.Errorf(l.f, "Failed to remove lock file %s: %v", l.remote, err)
+	}
+}
+
+// checkLocks returns an error if there are any locks of kind in f
+// which aren't stale.
+func checkLocks(ctx context.Context, f fs.Fs, kind string) error {
+	entries, err := f.List(ctx, lockDir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list lock files: %w", err)
+	}
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok || !strings.HasPrefix(path.Base(o.Remote()), kind+"-") {
+			continue
+		}
+		if age := time.Since(o.ModTime(ctx)); age > lockStale {
+			fs.Logf(o, "Ignoring stale lock file last written %v ago", age.Truncate(time.Second))
+			continue
+		}
+		return fmt.Errorf("a %s is running on %v, found lock file %s - remove it if it isn't", kind, f, o.Remote())
+	}
+	return nil
+}
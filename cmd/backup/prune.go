@@ -0,0 +1,163 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	Command.AddCommand(pruneDefinition)
+	addKeepFlags(pruneDefinition.Flags())
+}
+
+var pruneDefinition = &cobra.Command{
+	Use:   "prune dest:path",
+	Short: `Remove old snapshots from dest:path.`,
+	Long: `Removes the snapshots made by ` + "`rclone backup`" + ` in dest:path
+which aren't chosen to be kept by the ` + "`--keep-daily` and `--keep-weekly`" + `
+flags, then removes any file contents which are no longer used by a
+snapshot.
+
+` + "`--keep-daily N`" + ` keeps the last snapshot of each of the last N
+days which have snapshots, and ` + "`--keep-weekly N`" + ` the last
+snapshot of each of the last N weeks which have snapshots. A snapshot
+is kept if either flag chooses it. Days and weeks are in local time
+and weeks start on Monday.
+
+` + "```sh" + `
+rclone backup prune --keep-daily 7 --keep-weekly 4 backups:photos
+` + "```" + `
+
+The latest snapshot is always kept. At least one of the flags must be
+given.
+
+Test first with ` + "`--dry-run`" + ` to see what would be removed.
+
+Prune won't start while a backup is being made to dest:path, and a
+backup won't start while a prune is running, as the contents the
+backup uploads could be removed. This is done with lock files in
+` + "`dest:path/locks`" + `. If rclone is killed the lock file is left
+behind but is ignored once it is 30 minutes old.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsDir(args)
+		cmd.Run(true, true, command, func() error {
+			return Prune(context.Background(), f, opt)
+		})
+	},
+}
+
+// pruneKeep returns the IDs of the snapshots to keep.
+//
+// ids should be sorted oldest first.
+func pruneKeep(ids []string, opt Options) map[string]struct{} {
+	keep := make(map[string]struct{})
+	policies := []struct {
+		n      int
+		period func(t time.Time) string
+	}{
+		{opt.KeepDaily, func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}},
+		{opt.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+	}
+	for _, policy := range policies {
+		n, last := policy.n, ""
+		for _, id := range slices.Backward(ids) {
+			if n <= 0 {
+				break
+			}
+			t, _ := time.Parse(snapshotIDFmt, id)
+			period := policy.period(t.Local())
+			if period != last {
+				keep[id] = struct{}{}
+				last = period
+				n--
+			}
+		}
+	}
+	return keep
+}
+
+// Prune removes the snapshots in f which opt doesn't choose to keep
+// and then the contents which are no longer used.
+func Prune(ctx context.Context, f fs.Fs, opt Options) error {
+	if !opt.pruning() {
+		return errors.New("need --keep-daily or --keep-weekly to choose which snapshots to keep")
+	}
+	// Don't remove contents which a running backup has uploaded but
+	// not written the snapshot for yet
+	l, err := takeLock(ctx, f, lockPrune, lockBackup)
+	if err != nil {
+		return err
+	}
+	defer l.unlock(ctx)
+	ids, err := listSnapshots(ctx, f)
+	if err != nil {
+		return err
+	}
+	keep := pruneKeep(ids, opt)
+
+	// Remove the snapshots first so the contents they use can be removed
+	removed := 0
+	for _, id := range ids {
+		if _, found := keep[id]; found {
+			continue
+		}
+		obj, err := f.NewObject(ctx, snapshotRemote(id))
+		if err != nil {
+			return fmt.Errorf("failed to find snapshot %s: %w", id, err)
+		}
+		err = operations.DeleteFile(ctx, obj)
+		if err != nil {
+			return fmt.Errorf("failed to remove snapshot %s: %w", id, err)
+		}
+		removed++
+	}
+
+	// Find the contents used by the remaining snapshots. If any of
+	// them can't be read then nothing can be removed safely.
+	used := make(map[string]struct{})
+	for id := range keep {
+		m, err := loadManifest(ctx, f, id)
+		if err != nil {
+			return fmt.Errorf("not removing unused contents: %w", err)
+		}
+		for _, file := range m.Files {
+			used[file.Key] = struct{}{}
+		}
+	}
+	have, err := listData(ctx, f)
+	if err != nil {
+		return err
+	}
+	toBeDeleted := make(fs.ObjectsChan, len(have))
+	unused := 0
+	for key, obj := range have {
+		if _, found := used[key]; !found {
+			toBeDeleted <- obj
+			unused++
+		}
+	}
+	close(toBeDeleted)
+	err = operations.DeleteFiles(ctx, toBeDeleted)
+	if err != nil {
+		return fmt.Errorf("failed to remove unused contents: %w", err)
+	}
+	fs.Infof(f, "Removed %d snapshots and %d unused files, kept %d snapshots", removed, unused, len(keep))
+	return nil
+}
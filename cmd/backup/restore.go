@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	restoreAt fs.Time
+)
+
+func init() {
+	Command.AddCommand(restoreDefinition)
+	cmdFlags := restoreDefinition.Flags()
+	flags.FVarP(cmdFlags, &restoreAt, "at", "", "Restore the last snapshot made at or before this time or duration ago (default the latest)", "")
+}
+
+var restoreDefinition = &cobra.Command{
+	Use:   "restore dest:path target:path",
+	Short: `Restore a snapshot from dest:path to target:path.`,
+	Long: `Restores the files in a snapshot made by ` + "`rclone backup`" + ` in
+dest:path to target:path.
+
+By default the latest snapshot is restored. Use ` + "`--at`" + ` to
+restore the files as they were at a given time. This restores the last
+snapshot made at or before that time. It takes the same formats as
+` + "`--max-age`" + `, so it can be a date, a date and time or a duration
+before now.
+
+` + "```sh" + `
+rclone backup restore backups:photos remote:photos-restored
+rclone backup restore --at 2025-01-02 backups:photos remote:photos-restored
+rclone backup restore --at "2025-01-02 15:04:05" backups:photos remote:photos-restored
+rclone backup restore --at 7d backups:photos remote:photos-restored
+` + "```" + `
+
+Files in target:path which are the same as in the snapshot are left
+alone and files which aren't in the snapshot aren't deleted.
+
+Filter flags can be used to choose which files are restored, but only
+the rules on the file names are used.
+
+If dest:path and target:path are on the same remote then the files are
+restored with server-side copies where possible.`,
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(2, 2, command, args)
+		fbackup, fdst := cmd.NewFsSrcDst(args)
+		cmd.Run(true, true, command, func() error {
+			return Restore(context.Background(), fbackup, fdst, time.Time(restoreAt))
+		})
+	},
+}
+
+// Restore the last snapshot in fbackup made at or before at to fdst.
+//
+// If at is zero then the latest snapshot is restored.
+func Restore(ctx context.Context, fbackup, fdst fs.Fs, at time.Time) error {
+	ci := fs.GetConfig(ctx)
+	fi := filter.GetConfig(ctx)
+	m, err := loadLatest(ctx, fbackup, at)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		if at.IsZero() {
+			return errors.New("no snapshots found")
+		}
+		return fmt.Errorf("no snapshots found made at or before %v", at)
+	}
+	fs.Infof(fbackup, "Restoring snapshot %s made at %v", m.id, m.Time)
+	var hashType hash.Type
+	err = hashType.Set(m.HashType)
+	if err != nil {
+		return fmt.Errorf("snapshot %s: %w", m.id, err)
+	}
+	var failed atomic.Int64
+	g := new(errgroup.Group)
+	g.SetLimit(ci.Transfers)
+	for _, file := range m.Files {
+		if !fi.IncludeRemote(file.Path) {
+			continue
+		}
+		g.Go(func() error {
+			err := restoreFile(ctx, fbackup, fdst, hashType, file)
+			if err != nil {
+				fs.Errorf(file.Path, "Failed to restore: %v", err)
+				failed.Add(1)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("failed to restore %d files", n)
+	}
+	return nil
+}
+
+// restoreFile restores file from the snapshot in fbackup to fdst
+// unless it is there already.
+//
+// Any errors returned have been counted in the stats.
+func restoreFile(ctx context.Context, fbackup, fdst fs.Fs, hashType hash.Type, file *snapshotFile) error {
+	dst, err := fdst.NewObject(ctx, file.Path)
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		dst = nil
+	} else if err != nil {
+		return fs.CountError(ctx, err)
+	}
+	if dst != nil {
+		var hashes map[hash.Type]string
+		if file.Hash != "" {
+			hashes = map[hash.Type]string{hashType: file.Hash}
+		}
+		info := object.NewStaticObjectInfo(file.Path, file.ModTime, file.Size, true, hashes, nil)
+		if operations.Equal(ctx, info, dst) {
+			fs.Debugf(dst, "Unchanged skipping")
+			return nil
+		}
+	}
+	src, err := fbackup.NewObject(ctx, dataRemote(file.Key))
+	if err != nil {
+		return fs.CountError(ctx, fmt.Errorf("contents missing from backup: %w", err))
+	}
+	newDst, err := operations.Copy(ctx, fdst, dst, file.Path, src)
+	if err != nil || newDst == nil {
+		return err
+	}
+	// The contents may be shared with a file with a different
+	// modification time, so set it if needed
+	window := fs.GetModifyWindow(ctx, fdst)
+	if window == fs.ModTimeNotSupported {
+		return nil
+	}
+	dt := newDst.ModTime(ctx).Sub(file.ModTime)
+	if dt > window || dt < -window {
+		err = newDst.SetModTime(ctx, file.ModTime)
+		if errors.Is(err, fs.ErrorCantSetModTime) || errors.Is(err, fs.ErrorCantSetModTimeWithoutDelete) {
+			fs.Debugf(newDst, "Can't set modification time: %v", err)
+		} else if err != nil {
+			return fs.CountError(ctx, fmt.Errorf("failed to set modification time: %w", err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/walk"
+)
+
+// Layout of the backup destination
+const (
+	snapshotDir     = "snapshots" // directory the snapshot manifests are kept in
+	snapshotExt     = ".json"     // extension of the snapshot manifests
+	dataDir         = "data"      // directory the file contents are kept in
+	snapshotIDFmt   = "2006-01-02T150405.000Z"
+	manifestVersion = 1
+)
+
+// snapshotFile describes one file in a snapshot
+type snapshotFile struct {
+	Path    string    `json:"path"`           // path relative to the root of the backup
+	Size    int64     `json:"size"`           // size in bytes
+	ModTime time.Time `json:"modTime"`        // modification time
+	Hash    string    `json:"hash,omitempty"` // hash of the contents of type manifest.HashType, if known
+	Key     string    `json:"key"`            // name of the contents in the data directory
+}
+
+// manifest describes a snapshot
+type manifest struct {
+	Version  int             `json:"version"`  // version of the manifest format
+	Time     time.Time       `json:"time"`     // when the snapshot was made
+	Source   string          `json:"source"`   // the source of the backup
+	HashType string          `json:"hashType"` // type of the hashes in Files
+	Files    []*snapshotFile `json:"files"`    // the files in the snapshot sorted by Path
+	id       string          // ID of the snapshot
+}
+
+// snapshotID makes the ID for a snapshot taken at t
+func snapshotID(t time.Time) string {
+	return t.UTC().Format(snapshotIDFmt)
+}
+
+// snapshotRemote returns the path of the manifest for the snapshot id
+func snapshotRemote(id string) string {
+	return path.Join(snapshotDir, id+snapshotExt)
+}
+
+// dataRemote returns the path the contents with key are stored in
+func dataRemote(key string) string {
+	return path.Join(dataDir, key[:2], key)
+}
+
+// dataKey makes the key the contents of a file are stored under.
+//
+// If the hash of the contents is known then files with the same
+// contents get the same key. If not then the path, size and
+// modification time are used which means unchanged files are still
+// only stored once.
+func dataKey(hashType hash.Type, sum string, remote string, size int64, modTime time.Time) string {
+	var in string
+	if sum != "" {
+		in = fmt.Sprintf("hash\x00%s\x00%s\x00%d", hashType, sum, size)
+	} else {
+		in = fmt.Sprintf("file\x00%s\x00%d\x00%d", remote, size, modTime.UnixNano())
+	}
+	key := sha256.Sum256([]byte(in))
+	return hex.EncodeToString(key[:])
+}
+
+// lookup returns the file at remote in the snapshot or nil if not found
+func (m *manifest) lookup(remote string) *snapshotFile {
+	i, found := slices.BinarySearchFunc(m.Files, remote, func(f *snapshotFile, remote string) int {
+		return strings.Compare(f.Path, remote)
+	})
+	if !found {
+		return nil
+	}
+	return m.Files[i]
+}
+
+// size returns the total size of the files in the snapshot
+func (m *manifest) size() (total int64) {
+	for _, file := range m.Files {
+		total += file.Size
+	}
+	return total
+}
+
+// save writes the manifest for the snapshot to f
+func (m *manifest) save(ctx context.Context, f fs.Fs) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	_, err = operations.RcatSize(ctx, f, snapshotRemote(m.id), io.NopCloser(bytes.NewReader(data)), int64(len(data)), m.Time, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot %s: %w", m.id, err)
+	}
+	return nil
+}
+
+// loadManifest reads the manifest for the snapshot id from f
+func loadManifest(ctx context.Context, f fs.Fs, id string) (m *manifest, err error) {
+	obj, err := f.NewObject(ctx, snapshotRemote(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find snapshot %s: %w", id, err)
+	}
+	in, err := operations.Open(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot %s: %w", id, err)
+	}
+	defer fs.CheckClose(in, &err)
+	m = new(manifest)
+	err = json.NewDecoder(in).Decode(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", id, err)
+	}
+	if m.Version != manifestVersion {
+		return nil, fmt.Errorf("snapshot %s has unsupported version %d", id, m.Version)
+	}
+	m.id = id
+	return m, nil
+}
+
+// listSnapshots returns the IDs of the snapshots in f, oldest first
+func listSnapshots(ctx context.Context, f fs.Fs) (ids []string, err error) {
+	entries, err := f.List(ctx, snapshotDir)
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Object); !ok {
+			continue
+		}
+		id, ok := strings.CutSuffix(path.Base(entry.Remote()), snapshotExt)
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(snapshotIDFmt, id); err != nil {
+			fs.Debugf(entry, "Ignoring unknown file in snapshots directory")
+			continue
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// loadLatest loads the newest snapshot in f taken at or before at.
+//
+// If at is zero then the newest snapshot is loaded. It returns nil
+// with no error if there isn't one.
+func loadLatest(ctx context.Context, f fs.Fs, at time.Time) (*manifest, error) {
+	ids, err := listSnapshots(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range slices.Backward(ids) {
+		t, _ := time.Parse(snapshotIDFmt, id)
+		if at.IsZero() || !t.After(at) {
+			return loadManifest(ctx, f, id)
+		}
+	}
+	return nil, nil
+}
+
+// hasDir returns true if dir exists in the root of f
+func hasDir(ctx context.Context, f fs.Fs, dir string) (bool, error) {
+	entries, err := f.List(ctx, "")
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if _, ok := entry.(fs.Directory); ok && entry.Remote() == dir {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listData returns the data objects in f by key
+func listData(ctx context.Context, f fs.Fs) (map[string]fs.Object, error) {
+	objs := make(map[string]fs.Object)
+	// Check the directory exists first as walk logs an error if not
+	found, err := hasDir(ctx, f, dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup data: %w", err)
+	} else if !found {
+		return objs, nil
+	}
+	err = walk.ListR(ctx, f, dataDir, true, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+		for _, entry := range entries {
+			if o, ok := entry.(fs.Object); ok {
+				objs[path.Base(o.Remote())] = o
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup data: %w", err)
+	}
+	return objs, nil
+}
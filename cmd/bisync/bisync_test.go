@@ -33,6 +33,7 @@ import (
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/sync"
 	"github.com/rclone/rclone/fs/walk"
 	"github.com/rclone/rclone/fstest"
@@ -180,6 +181,45 @@ var (
 	argPCount       = flag.Int("pcount", 2, "number of parallel subtests to run for TestBisyncConcurrent") // go test ./cmd/bisync -race -pcount 10
 )
 
+func init() {
+	rc.Add(rc.Call{
+		Path: "test/bisync-conflict-hook",
+		Fn:   testConflictHook,
+	})
+}
+
+// testConflictHook is a --conflict-hook-rc which merges file1.txt
+// and keeps the Path2 version of anything else
+func testConflictHook(ctx context.Context, in rc.Params) (rc.Params, error) {
+	var info struct {
+		Path1  struct{ Name, Local string }
+		Path2  struct{ Local string }
+		Merged string
+	}
+	if err := rc.Reshape(&info, in); err != nil {
+		return nil, err
+	}
+	if path.Base(info.Path1.Name) != "file1.txt" {
+		return rc.Params{"action": "path2", "loser": "delete"}, nil
+	}
+	data1, err := os.ReadFile(info.Path1.Local)
+	if err != nil {
+		return nil, err
+	}
+	data2, err := os.ReadFile(info.Path2.Local)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(info.Merged, append(data1, data2...), 0600); err != nil {
+		return nil, err
+	}
+	mergedTime := time.Date(2001, time.June, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(info.Merged, mergedTime, mergedTime); err != nil {
+		return nil, err
+	}
+	return rc.Params{"action": "merge"}, nil
+}
+
 // bisyncTest keeps all test data in a single place
 type bisyncTest struct {
 	// per-test state
@@ -1189,6 +1229,8 @@ func (b *bisyncTest) runBisync(ctx context.Context, args []string) (err error) {
 			_ = opt.ConflictLoser.Set(val)
 		case "conflict-suffix":
 			opt.ConflictSuffixFlag = val
+		case "conflict-hook-rc":
+			opt.ConflictHookRC = val
 		case "resync-mode":
 			_ = opt.ResyncMode.Set(val)
 		default:
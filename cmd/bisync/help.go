@@ -40,6 +40,8 @@ var rcHelp = makeHelp(`This takes the following parameters
 - backupdir1 - --backup-dir for Path1. Must be a non-overlapping path on the same remote.
 - backupdir2 - --backup-dir for Path2. Must be a non-overlapping path on the same remote.
 - noCleanup - retain working files
+- conflictHook - program to run to decide how to resolve each sync conflict
+- conflictHookRc - rc method to call to decide how to resolve each sync conflict
 
 See [bisync command help](https://rclone.org/commands/rclone_bisync/)
 and [full bisync description](https://rclone.org/bisync/)
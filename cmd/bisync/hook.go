@@ -0,0 +1,258 @@
+package bisync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/cmd/bisync/bilib"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fs/operations"
+	"github.com/rclone/rclone/fs/rc"
+)
+
+// Decisions which can be returned by a --conflict-hook
+const (
+	hookActionNone  = "none"  // no decision - use --conflict-resolve
+	hookActionPath1 = "path1" // keep the Path1 version
+	hookActionPath2 = "path2" // keep the Path2 version
+	hookActionBoth  = "both"  // keep both versions, renaming them with the --conflict-suffix
+	hookActionMerge = "merge" // replace both versions with the merged file
+)
+
+// conflictVersion describes one version of a conflicting file for a
+// --conflict-hook
+type conflictVersion struct {
+	Remote   string      `json:"remote"`             // the file on the remote, e.g. "remote:path/file.txt"
+	Name     string      `json:"name"`               // name of the file relative to the root of the path
+	Size     int64       `json:"size"`               // size of the file in bytes
+	ModTime  time.Time   `json:"modTime"`            // modification time of the file
+	Hash     string      `json:"hash,omitempty"`     // hash of the file, if known
+	HashType string      `json:"hashType,omitempty"` // type of Hash
+	Metadata fs.Metadata `json:"metadata,omitempty"` // metadata of the file, if supported
+	Local    string      `json:"local"`              // local copy of the file
+}
+
+// conflictInfo is passed to a --conflict-hook
+type conflictInfo struct {
+	Path1  conflictVersion `json:"path1"`  // the version on Path1
+	Path2  conflictVersion `json:"path2"`  // the version on Path2
+	Merged string          `json:"merged"` // where to write the merged file for the merge action
+}
+
+// conflictDecision is returned by a --conflict-hook
+type conflictDecision struct {
+	Action string `json:"action"` // one of the hookAction constants
+	Loser  string `json:"loser"`  // if set, overrides --conflict-loser for this file
+}
+
+// checkConflictHook checks the --conflict-hook options are valid
+func (b *bisyncRun) checkConflictHook() error {
+	if len(b.opt.ConflictHook) > 0 && b.opt.ConflictHookRC != "" {
+		return errors.New("can't use --conflict-hook and --conflict-hook-rc together")
+	}
+	if b.opt.ConflictHookRC != "" && rc.Calls.Get(b.opt.ConflictHookRC) == nil {
+		return fmt.Errorf("--conflict-hook-rc: couldn't find method %q", b.opt.ConflictHookRC)
+	}
+	return nil
+}
+
+// haveConflictHook returns true if a conflict hook is configured
+func (b *bisyncRun) haveConflictHook() bool {
+	return len(b.opt.ConflictHook) > 0 || b.opt.ConflictHookRC != ""
+}
+
+// conflictVersion makes the conflictVersion for remote on f, saving a
+// local copy of it in dir
+func (b *bisyncRun) conflictVersion(ctx context.Context, f fs.Fs, ds *deltaSet, hashType hash.Type, remote, dir string) (v conflictVersion, err error) {
+	v = conflictVersion{
+		Remote:  bilib.FsPath(f) + remote,
+		Name:    remote,
+		Size:    ds.size[remote],
+		ModTime: ds.time[remote],
+		Hash:    ds.hash[remote],
+		Local:   filepath.Join(dir, filepath.Base(filepath.FromSlash(remote))),
+	}
+	if v.Hash != "" {
+		v.HashType = hashType.String()
+	}
+	obj, err := f.NewObject(ctx, remote)
+	if err != nil {
+		return v, err
+	}
+	v.Metadata, err = fs.GetMetadata(ctx, obj)
+	if err != nil {
+		fs.Debugf(obj, "conflict hook: failed to read metadata: %v", err)
+	}
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return v, err
+	}
+	in, err := operations.Open(ctx, obj)
+	if err != nil {
+		return v, err
+	}
+	defer fs.CheckClose(in, &err)
+	out, err := os.Create(v.Local)
+	if err != nil {
+		return v, err
+	}
+	defer fs.CheckClose(out, &err)
+	_, err = io.Copy(out, in)
+	return v, err
+}
+
+// runConflictHook calls the --conflict-hook or --conflict-hook-rc
+// with info and returns its decision
+func (b *bisyncRun) runConflictHook(ctx context.Context, info *conflictInfo) (decision conflictDecision, err error) {
+	if b.opt.ConflictHookRC != "" {
+		call := rc.Calls.Get(b.opt.ConflictHookRC)
+		if call == nil {
+			return decision, fmt.Errorf("couldn't find method %q", b.opt.ConflictHookRC)
+		}
+		in := rc.Params{}
+		err = rc.Reshape(&in, info)
+		if err != nil {
+			return decision, err
+		}
+		out, err := call.Fn(ctx, in)
+		if err != nil {
+			return decision, err
+		}
+		err = rc.Reshape(&decision, out)
+		return decision, err
+	}
+	in, err := json.Marshal(info)
+	if err != nil {
+		return decision, err
+	}
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, b.opt.ConflictHook[0], b.opt.ConflictHook[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return decision, fmt.Errorf("failed to run %q: %w", b.opt.ConflictHook.String(), err)
+	}
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return decision, nil
+	}
+	err = json.Unmarshal(stdout.Bytes(), &decision)
+	if err != nil {
+		return decision, fmt.Errorf("failed to parse output of %q: %w", b.opt.ConflictHook.String(), err)
+	}
+	return decision, nil
+}
+
+// conflictHook asks the --conflict-hook what to do about the conflict
+// between file on Path1 and alias on Path2.
+//
+// It returns the winning path, 0 for none, and the action to take on
+// the loser. If the hook doesn't decide then ok is false.
+//
+// If the hook merges the files then the merged file is uploaded to
+// Path1 and Path1 is returned as the winner with the loser deleted.
+func (b *bisyncRun) conflictHook(ctx context.Context, file, alias string, ds1, ds2 *deltaSet) (winningPath int, loser ConflictLoserAction, ok bool, err error) {
+	loser = b.opt.ConflictLoser
+	dir, err := os.MkdirTemp("", "rclone-bisync-conflict-")
+	if err != nil {
+		return 0, loser, false, err
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	info := conflictInfo{
+		Merged: filepath.Join(dir, "merged", filepath.Base(filepath.FromSlash(file))),
+	}
+	info.Path1, err = b.conflictVersion(ctx, b.fs1, ds1, b.opt.Compare.HashType1, file, filepath.Join(dir, "path1"))
+	if err != nil {
+		return 0, loser, false, fmt.Errorf("conflict hook: failed to read Path1 version: %w", err)
+	}
+	info.Path2, err = b.conflictVersion(ctx, b.fs2, ds2, b.opt.Compare.HashType2, alias, filepath.Join(dir, "path2"))
+	if err != nil {
+		return 0, loser, false, fmt.Errorf("conflict hook: failed to read Path2 version: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(info.Merged), 0700)
+	if err != nil {
+		return 0, loser, false, err
+	}
+
+	decision, err := b.runConflictHook(ctx, &info)
+	if err != nil {
+		return 0, loser, false, fmt.Errorf("conflict hook: %w", err)
+	}
+	fs.Debugf(file, "conflict hook: decision %+v", decision)
+	if decision.Loser != "" {
+		err = loser.Set(decision.Loser)
+		if err != nil {
+			return 0, loser, false, fmt.Errorf("conflict hook: bad loser: %w", err)
+		}
+	}
+	switch strings.ToLower(decision.Action) {
+	case hookActionNone, "":
+		return 0, loser, false, nil
+	case hookActionPath1:
+		return 1, loser, true, nil
+	case hookActionPath2:
+		return 2, loser, true, nil
+	case hookActionBoth:
+		return 0, loser, true, nil
+	case hookActionMerge:
+		err = b.uploadMerged(ctx, file, info.Merged)
+		if err != nil {
+			return 0, loser, false, fmt.Errorf("conflict hook: %w", err)
+		}
+		return 1, ConflictLoserDelete, true, nil
+	default:
+		return 0, loser, false, fmt.Errorf("conflict hook: unknown action %q", decision.Action)
+	}
+}
+
+// uploadMerged replaces file on Path1 with the merged file
+func (b *bisyncRun) uploadMerged(ctx context.Context, file, merged string) (err error) {
+	fi, err := os.Stat(merged)
+	if err != nil {
+		return fmt.Errorf("merged file not found: %w", err)
+	}
+	if operations.SkipDestructive(ctx, file, "replace with merged file") {
+		return nil
+	}
+	b.indent("!Path1", file, "Replacing Path1 copy with merged file")
+	ctx = b.setBackupDir(ctx, 1)
+	ci := fs.GetConfig(ctx)
+	if ci.BackupDir != "" {
+		backupDir, err := operations.BackupDir(ctx, b.fs1, b.fs1, file)
+		if err != nil {
+			return err
+		}
+		obj, err := b.fs1.NewObject(ctx, file)
+		if err != nil {
+			return err
+		}
+		err = operations.MoveBackupDir(ctx, backupDir, obj)
+		if err != nil {
+			return fmt.Errorf("failed to move old version to backup dir: %w", err)
+		}
+	}
+	in, err := os.Open(merged)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	_, err = operations.RcatSize(ctx, b.fs1, file, in, fi.Size(), fi.ModTime(), nil)
+	if err != nil {
+		b.critical = true
+		return fmt.Errorf("failed to upload merged file: %w", err)
+	}
+	return nil
+}
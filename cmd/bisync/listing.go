@@ -590,6 +590,14 @@ func (b *bisyncRun) modifyListing(ctx context.Context, src fs.Fs, dst fs.Fs, res
 		for _, rename := range b.renames {
 			srcOldName, srcNewName, dstOldName, dstNewName := rename.getNames(is1to2)
 			fs.Debugf(nil, "%s: srcOldName: %v srcNewName: %v dstOldName: %v dstNewName: %v", direction, srcOldName, srcNewName, dstOldName, dstNewName)
+			if srcNewName == "" && dstNewName != "" {
+				// src was deleted by --conflict-loser delete and
+				// replaced with a copy of dst. Removing srcOldName
+				// here would drop that copy from the listing (see
+				// "deletes on both sides" in test_resolve) so we'll
+				// handle it when we go the other direction
+				continue
+			}
 			// we'll handle the other side when we go the other direction
 			var new *fileInfo
 			// we prefer to get the info from the newNamed versions
@@ -80,6 +80,18 @@ func rcBisync(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	if opt.BackupDir2, err = in.GetString("backupdir2"); rc.NotErrParamNotFound(err) {
 		return
 	}
+	if opt.ConflictHookRC, err = in.GetString("conflictHookRc"); rc.NotErrParamNotFound(err) {
+		return
+	}
+	conflictHook, err := in.GetString("conflictHook")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if conflictHook != "" {
+		if err := opt.ConflictHook.Set(conflictHook); err != nil {
+			return nil, err
+		}
+	}
 
 	checkSync, err := in.GetString("checkSync")
 	if rc.NotErrParamNotFound(err) {
@@ -116,7 +116,7 @@ func (b *bisyncRun) setResolveDefaults() error {
 		b.opt.ConflictResolve = PreferNone
 	}
 
-	return nil
+	return b.checkConflictHook()
 }
 
 type (
@@ -137,7 +137,20 @@ type namePair struct {
 
 func (b *bisyncRun) resolve(ctxMove context.Context, path1, path2, file, alias string, renameSkipped, copy1to2, copy2to1 *bilib.Names, ds1, ds2 *deltaSet) (err error) {
 	winningPath := 0
-	if b.opt.ConflictResolve != PreferNone {
+	loser := b.opt.ConflictLoser
+	decided := false
+	if b.haveConflictHook() {
+		winningPath, loser, decided, err = b.conflictHook(ctxMove, file, alias, ds1, ds2)
+		if err != nil {
+			return err
+		}
+		if decided && winningPath > 0 {
+			fs.Infof(file, Color(terminal.GreenFg, "The conflict hook chose: Path%d"), winningPath)
+		} else if decided {
+			fs.Infof(file, "The conflict hook chose to keep both versions")
+		}
+	}
+	if !decided && b.opt.ConflictResolve != PreferNone {
 		winningPath = b.conflictWinner(ds1, ds2, file, alias)
 		if winningPath > 0 {
 			fs.Infof(file, Color(terminal.GreenFg, "The winner is: Path%d"), winningPath)
@@ -148,7 +161,7 @@ func (b *bisyncRun) resolve(ctxMove context.Context, path1, path2, file, alias s
 
 	suff1 := b.opt.ConflictSuffix1 // copy to new var to make sure our changes here don't persist
 	suff2 := b.opt.ConflictSuffix2
-	if b.opt.ConflictLoser == ConflictLoserPathname && b.opt.ConflictSuffix1 == b.opt.ConflictSuffix2 {
+	if loser == ConflictLoserPathname && b.opt.ConflictSuffix1 == b.opt.ConflictSuffix2 {
 		// numerate, but not if user supplied two different suffixes
 		suff1 += "1"
 		suff2 += "2"
@@ -168,7 +181,7 @@ func (b *bisyncRun) resolve(ctxMove context.Context, path1, path2, file, alias s
 	// handle auto-numbering
 	// note that we still queue copies for both files, whether or not we renamed
 	// we also set these for ConflictLoserDelete in case there is no winner.
-	if b.opt.ConflictLoser == ConflictLoserNumber || b.opt.ConflictLoser == ConflictLoserDelete {
+	if loser == ConflictLoserNumber || loser == ConflictLoserDelete {
 		num := b.numerate(ctxMove, 1, file, alias)
 		switch winningPath {
 		case 1: // keep path1, rename path2
@@ -195,7 +208,7 @@ func (b *bisyncRun) resolve(ctxMove context.Context, path1, path2, file, alias s
 
 	// when winningPath == 0 (no winner), we ignore settings and rename both, do not delete
 	// note also that deletes and renames are mutually exclusive -- we never delete one path and rename the other.
-	if b.opt.ConflictLoser == ConflictLoserDelete && winningPath == 1 {
+	if loser == ConflictLoserDelete && winningPath == 1 {
 		// delete 2, copy 1 to 2
 		err = b.delete(ctxMove, r.path2, path2, b.fs2, 2, renameSkipped)
 		if err != nil {
@@ -205,7 +218,7 @@ func (b *bisyncRun) resolve(ctxMove context.Context, path1, path2, file, alias s
 		// copy the one that wasn't deleted
 		b.indent("Path1", r.path1.oldName, "Queue copy to Path2")
 		copy1to2.Add(r.path1.oldName)
-	} else if b.opt.ConflictLoser == ConflictLoserDelete && winningPath == 2 {
+	} else if loser == ConflictLoserDelete && winningPath == 2 {
 		// delete 1, copy 2 to 1
 		err = b.delete(ctxMove, r.path1, path1, b.fs1, 1, renameSkipped)
 		if err != nil {
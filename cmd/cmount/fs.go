@@ -3,6 +3,7 @@
 package cmount
 
 import (
+	"context"
 	"io"
 	"os"
 	"path"
@@ -532,25 +533,75 @@ func (fsys *FS) Fsyncdir(path string, datasync bool, fh uint64) (errc int) {
 // Setxattr sets extended attributes.
 func (fsys *FS) Setxattr(path string, name string, value []byte, flags int) (errc int) {
 	defer log.Trace(path, "name=%q, value=%q, flags=%d", name, value, flags)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	if !fsys.opt.Xattrs {
+		return -fuse.ENOSYS
+	}
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	return translateError(vfs.SetXattr(context.TODO(), node, name, value, translateXattrFlags(flags)))
+}
+
+// translateXattrFlags turns the fuse setxattr flags into vfs ones as
+// their values depend on the OS
+func translateXattrFlags(flags int) (vfsFlags int) {
+	if flags&fuse.XATTR_CREATE != 0 {
+		vfsFlags |= vfs.XattrCreate
+	}
+	if flags&fuse.XATTR_REPLACE != 0 {
+		vfsFlags |= vfs.XattrReplace
+	}
+	return vfsFlags
 }
 
 // Getxattr gets extended attributes.
 func (fsys *FS) Getxattr(path string, name string) (errc int, value []byte) {
 	defer log.Trace(path, "name=%q", name)("errc=%d, value=%q", &errc, &value)
-	return -fuse.ENOSYS, nil
+	if !fsys.opt.Xattrs {
+		return -fuse.ENOSYS, nil
+	}
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc, nil
+	}
+	value, err := vfs.GetXattr(context.TODO(), node, name)
+	return translateError(err), value
 }
 
 // Removexattr removes extended attributes.
 func (fsys *FS) Removexattr(path string, name string) (errc int) {
 	defer log.Trace(path, "name=%q", name)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	if !fsys.opt.Xattrs {
+		return -fuse.ENOSYS
+	}
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	return translateError(vfs.RemoveXattr(context.TODO(), node, name))
 }
 
 // Listxattr lists extended attributes.
 func (fsys *FS) Listxattr(path string, fill func(name string) bool) (errc int) {
 	defer log.Trace(path, "fill=%p", fill)("errc=%d", &errc)
-	return -fuse.ENOSYS
+	if !fsys.opt.Xattrs {
+		return -fuse.ENOSYS
+	}
+	node, errc := fsys.lookupNode(path)
+	if errc != 0 {
+		return errc
+	}
+	names, err := vfs.ListXattr(context.TODO(), node)
+	if err != nil {
+		return translateError(err)
+	}
+	for _, name := range names {
+		if !fill(name) {
+			return -fuse.ERANGE
+		}
+	}
+	return 0
 }
 
 // Getpath allows a case-insensitive file system to report the correct case of
@@ -599,6 +650,12 @@ func translateError(err error) (errc int) {
 		return -fuse.EINVAL
 	case vfs.ELOOP:
 		return -fuse.ELOOP
+	case vfs.EAGAIN:
+		return -fuse.EAGAIN
+	case vfs.ENOATTR:
+		return -fuse.ENOATTR
+	case vfs.ENOTSUP:
+		return -fuse.ENOTSUP
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return -fuse.EIO
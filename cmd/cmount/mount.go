@@ -122,6 +122,10 @@ func waitFor(fn func() bool) (ok bool) {
 // returns an error, and an error channel for the serve process to
 // report an error when fusermount is called.
 func mount(VFS *vfs.VFS, mountPath string, opt *mountlib.Options) (<-chan error, func() error, error) {
+	if opt.Xattrs && runtime.GOOS == "windows" {
+		return nil, nil, errors.New("--xattrs is not supported on Windows")
+	}
+
 	// Get mountpoint using OS specific logic
 	f := VFS.Fs()
 	mountpoint, err := getMountpoint(f, mountPath, opt)
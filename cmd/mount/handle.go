@@ -5,9 +5,12 @@ package mount
 import (
 	"context"
 	"io"
+	"math"
+	"syscall"
 
 	"bazil.org/fuse"
 	fusefs "bazil.org/fuse/fs"
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/vfs"
 )
@@ -67,6 +70,8 @@ var _ fusefs.HandleFlusher = (*FileHandle)(nil)
 // some writes, or that if will be called at all.
 func (fh *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// POSIX locks are released on any close of the file
+	fh.unlock(ctx, req.LockOwner)
 	return translateError(fh.Handle.Flush())
 }
 
@@ -78,5 +83,124 @@ var _ fusefs.HandleReleaser = (*FileHandle)(nil)
 // the kernel
 func (fh *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) (err error) {
 	defer log.Trace(fh, "")("err=%v", &err)
+	// flock locks are released on the last close of the file
+	if req.ReleaseFlags&fuse.ReleaseFlockUnlock != 0 {
+		fh.unlock(ctx, req.LockOwner)
+	}
 	return translateError(fh.Handle.Release())
 }
+
+// toVFSLock converts the lock lk held by owner into a vfs.Lock
+func toVFSLock(owner fuse.LockOwner, lk fuse.FileLock) (vfs.Lock, error) {
+	out := vfs.Lock{
+		Start: int64(min(lk.Start, math.MaxInt64)),
+		End:   int64(min(lk.End, math.MaxInt64)),
+		Owner: uint64(owner),
+		Pid:   uint32(lk.PID),
+	}
+	switch lk.Type {
+	case fuse.LockRead:
+		out.Type = vfs.LockRead
+	case fuse.LockWrite:
+		out.Type = vfs.LockWrite
+	case fuse.LockUnlock:
+		out.Type = vfs.LockUnlock
+	default:
+		return out, vfs.EINVAL
+	}
+	return out, nil
+}
+
+// file returns the vfs.File for the handle
+func (fh *FileHandle) file() (*vfs.File, error) {
+	file, ok := fh.Handle.Node().(*vfs.File)
+	if !ok {
+		return nil, vfs.EBADF
+	}
+	return file, nil
+}
+
+// setLock obtains or releases a lock, waiting for it if wait is set
+func (fh *FileHandle) setLock(ctx context.Context, owner fuse.LockOwner, lk fuse.FileLock, wait bool) (err error) {
+	defer log.Trace(fh, "owner=%v, lock=%+v, wait=%v", owner, lk, wait)("err=%v", &err)
+	file, err := fh.file()
+	if err != nil {
+		return translateError(err)
+	}
+	vlk, err := toVFSLock(owner, lk)
+	if err != nil {
+		return translateError(err)
+	}
+	err = file.Lock(ctx, vlk, wait)
+	if err != nil && ctx.Err() != nil {
+		return fuse.Errno(syscall.EINTR)
+	}
+	return translateError(err)
+}
+
+// unlock releases the locks held by owner
+func (fh *FileHandle) unlock(ctx context.Context, owner fuse.LockOwner) {
+	file, err := fh.file()
+	if err != nil {
+		return
+	}
+	err = file.Unlock(ctx, uint64(owner))
+	if err != nil {
+		fs.Errorf(file, "Failed to release locks: %v", err)
+	}
+}
+
+// Check interface satisfied
+var (
+	_ fusefs.HandleFlockLocker = (*FileHandle)(nil)
+	_ fusefs.HandlePOSIXLocker = (*FileHandle)(nil)
+)
+
+// Lock tries to acquire a lock on a byte range of the node. If a
+// conflicting lock is already held, returns syscall.EAGAIN.
+func (fh *FileHandle) Lock(ctx context.Context, req *fuse.LockRequest) error {
+	return fh.setLock(ctx, req.LockOwner, req.Lock, false)
+}
+
+// LockWait acquires a lock on a byte range of the node, waiting
+// until the lock can be obtained (or context is canceled).
+func (fh *FileHandle) LockWait(ctx context.Context, req *fuse.LockWaitRequest) error {
+	return fh.setLock(ctx, req.LockOwner, req.Lock, true)
+}
+
+// Unlock releases the lock on a byte range of the node.
+func (fh *FileHandle) Unlock(ctx context.Context, req *fuse.UnlockRequest) error {
+	return fh.setLock(ctx, req.LockOwner, req.Lock, false)
+}
+
+// QueryLock returns the current state of locks held for the byte
+// range of the node.
+func (fh *FileHandle) QueryLock(ctx context.Context, req *fuse.QueryLockRequest, resp *fuse.QueryLockResponse) (err error) {
+	defer log.Trace(fh, "owner=%v, lock=%+v", req.LockOwner, req.Lock)("resp=%+v, err=%v", &resp.Lock, &err)
+	file, err := fh.file()
+	if err != nil {
+		return translateError(err)
+	}
+	vlk, err := toVFSLock(req.LockOwner, req.Lock)
+	if err != nil {
+		return translateError(err)
+	}
+	conflict, err := file.TestLock(ctx, vlk)
+	if err != nil || conflict == nil {
+		return translateError(err)
+	}
+	resp.Lock = fuse.FileLock{
+		Start: uint64(conflict.Start),
+		End:   uint64(conflict.End),
+		Type:  fuse.LockRead,
+		PID:   int32(conflict.Pid),
+	}
+	if conflict.Type == vfs.LockWrite {
+		resp.Lock.Type = fuse.LockWrite
+	}
+	// locks held on other hosts have no process
+	if conflict.Pid == 0 {
+		resp.Lock.PID = -1
+	}
+	return nil
+}
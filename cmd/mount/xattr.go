@@ -0,0 +1,127 @@
+//go:build linux
+
+package mount
+
+import (
+	"context"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/rclone/rclone/fs/log"
+	"github.com/rclone/rclone/vfs"
+)
+
+// getxattr gets the extended attribute req.Name of node
+func getxattr(ctx context.Context, fsys *FS, node vfs.Node, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) (err error) {
+	defer log.Trace(node, "name=%q", req.Name)("err=%v", &err)
+	if !fsys.opt.Xattrs {
+		return syscall.ENOSYS
+	}
+	value, err := vfs.GetXattr(ctx, node, req.Name)
+	if err != nil {
+		return translateError(err)
+	}
+	resp.Xattr = value
+	return nil
+}
+
+// listxattr lists the extended attributes of node
+func listxattr(ctx context.Context, fsys *FS, node vfs.Node, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) (err error) {
+	defer log.Trace(node, "")("err=%v", &err)
+	if !fsys.opt.Xattrs {
+		return syscall.ENOSYS
+	}
+	names, err := vfs.ListXattr(ctx, node)
+	if err != nil {
+		return translateError(err)
+	}
+	resp.Append(names...)
+	return nil
+}
+
+// setxattr sets the extended attribute req.Name of node
+func setxattr(ctx context.Context, fsys *FS, node vfs.Node, req *fuse.SetxattrRequest) (err error) {
+	defer log.Trace(node, "name=%q", req.Name)("err=%v", &err)
+	if !fsys.opt.Xattrs {
+		return syscall.ENOSYS
+	}
+	return translateError(vfs.SetXattr(ctx, node, req.Name, req.Xattr, int(req.Flags)))
+}
+
+// removexattr removes the extended attribute req.Name of node
+func removexattr(ctx context.Context, fsys *FS, node vfs.Node, req *fuse.RemovexattrRequest) (err error) {
+	defer log.Trace(node, "name=%q", req.Name)("err=%v", &err)
+	if !fsys.opt.Xattrs {
+		return syscall.ENOSYS
+	}
+	return translateError(vfs.RemoveXattr(ctx, node, req.Name))
+}
+
+// Getxattr gets an extended attribute by the given name from the
+// node.
+//
+// If there is no xattr by that name, returns fuse.ErrNoXattr.
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(ctx, f.fsys, f.File, req, resp)
+}
+
+var _ fusefs.NodeGetxattrer = (*File)(nil)
+
+// Listxattr lists the extended attributes recorded for the node.
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return listxattr(ctx, f.fsys, f.File, req, resp)
+}
+
+var _ fusefs.NodeListxattrer = (*File)(nil)
+
+// Setxattr sets an extended attribute with the given name and
+// value for the node.
+func (f *File) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return setxattr(ctx, f.fsys, f.File, req)
+}
+
+var _ fusefs.NodeSetxattrer = (*File)(nil)
+
+// Removexattr removes an extended attribute for the name.
+//
+// If there is no xattr by that name, returns fuse.ErrNoXattr.
+func (f *File) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return removexattr(ctx, f.fsys, f.File, req)
+}
+
+var _ fusefs.NodeRemovexattrer = (*File)(nil)
+
+// Getxattr gets an extended attribute by the given name from the
+// node.
+//
+// If there is no xattr by that name, returns fuse.ErrNoXattr.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(ctx, d.fsys, d.Dir, req, resp)
+}
+
+var _ fusefs.NodeGetxattrer = (*Dir)(nil)
+
+// Listxattr lists the extended attributes recorded for the node.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	return listxattr(ctx, d.fsys, d.Dir, req, resp)
+}
+
+var _ fusefs.NodeListxattrer = (*Dir)(nil)
+
+// Setxattr sets an extended attribute with the given name and
+// value for the node.
+func (d *Dir) Setxattr(ctx context.Context, req *fuse.SetxattrRequest) error {
+	return setxattr(ctx, d.fsys, d.Dir, req)
+}
+
+var _ fusefs.NodeSetxattrer = (*Dir)(nil)
+
+// Removexattr removes an extended attribute for the name.
+//
+// If there is no xattr by that name, returns fuse.ErrNoXattr.
+func (d *Dir) Removexattr(ctx context.Context, req *fuse.RemovexattrRequest) error {
+	return removexattr(ctx, d.fsys, d.Dir, req)
+}
+
+var _ fusefs.NodeRemovexattrer = (*Dir)(nil)
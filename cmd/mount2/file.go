@@ -6,10 +6,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
+	"sync"
 	"syscall"
 
 	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/vfs"
 )
@@ -35,6 +38,9 @@ import (
 type FileHandle struct {
 	h    vfs.Handle
 	fsys *FS
+
+	mu     sync.Mutex          // protects the following
+	owners map[uint64]struct{} // lock owners which have locked the file using this handle
 }
 
 // Create a new FileHandle
@@ -95,6 +101,7 @@ var _ fusefs.FileFlusher = (*FileHandle)(nil)
 // so any cleanup that requires specific synchronization or
 // could fail with I/O errors should happen in Flush instead.
 func (f *FileHandle) Release(ctx context.Context) syscall.Errno {
+	f.releaseLocks(ctx)
 	return translateError(f.h.Release())
 }
 
@@ -149,3 +156,133 @@ func (f *FileHandle) Setattr(ctx context.Context, in *fuse.SetAttrIn, out *fuse.
 }
 
 var _ fusefs.FileSetattrer = (*FileHandle)(nil)
+
+// toVFSLock converts the lock lk held by owner into a vfs.Lock
+func toVFSLock(owner uint64, lk *fuse.FileLock) (vfs.Lock, syscall.Errno) {
+	out := vfs.Lock{
+		Start: int64(min(lk.Start, math.MaxInt64)),
+		End:   int64(min(lk.End, math.MaxInt64)),
+		Owner: owner,
+		Pid:   lk.Pid,
+	}
+	switch lk.Typ {
+	case syscall.F_RDLCK:
+		out.Type = vfs.LockRead
+	case syscall.F_WRLCK:
+		out.Type = vfs.LockWrite
+	case syscall.F_UNLCK:
+		out.Type = vfs.LockUnlock
+	default:
+		return out, syscall.EINVAL
+	}
+	return out, 0
+}
+
+// file returns the vfs.File for the handle
+func (f *FileHandle) file() (*vfs.File, syscall.Errno) {
+	file, ok := f.h.Node().(*vfs.File)
+	if !ok {
+		return nil, syscall.EBADF
+	}
+	return file, 0
+}
+
+// Getlk returns locks that would conflict with the given input
+// lock. If no locks conflict, the output has type L_UNLCK.
+func (f *FileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%d, lk=%+v", owner, lk)("out=%+v, errno=%v", out, &errno)
+	file, errno := f.file()
+	if errno != 0 {
+		return errno
+	}
+	vlk, errno := toVFSLock(owner, lk)
+	if errno != 0 {
+		return errno
+	}
+	conflict, err := file.TestLock(ctx, vlk)
+	if err != nil {
+		return translateError(err)
+	}
+	if conflict == nil {
+		*out = fuse.FileLock{Typ: syscall.F_UNLCK}
+		return 0
+	}
+	*out = fuse.FileLock{
+		Start: uint64(conflict.Start),
+		End:   uint64(conflict.End),
+		Typ:   syscall.F_RDLCK,
+		Pid:   conflict.Pid,
+	}
+	if conflict.Type == vfs.LockWrite {
+		out.Typ = syscall.F_WRLCK
+	}
+	return 0
+}
+
+var _ fusefs.FileGetlker = (*FileHandle)(nil)
+
+// setLock obtains or releases a lock, waiting for it if wait is set
+func (f *FileHandle) setLock(ctx context.Context, owner uint64, lk *fuse.FileLock, wait bool) (errno syscall.Errno) {
+	defer log.Trace(f, "owner=%d, lk=%+v, wait=%v", owner, lk, wait)("errno=%v", &errno)
+	file, errno := f.file()
+	if errno != 0 {
+		return errno
+	}
+	vlk, errno := toVFSLock(owner, lk)
+	if errno != 0 {
+		return errno
+	}
+	// Remember the owner so its locks are released with the handle
+	if vlk.Type != vfs.LockUnlock {
+		f.mu.Lock()
+		if f.owners == nil {
+			f.owners = make(map[uint64]struct{})
+		}
+		f.owners[owner] = struct{}{}
+		f.mu.Unlock()
+	}
+	err := file.Lock(ctx, vlk, wait)
+	if err != nil && ctx.Err() != nil {
+		return syscall.EINTR
+	}
+	return translateError(err)
+}
+
+// Setlk obtains a lock on a file, or fail if the lock could not
+// obtained.
+func (f *FileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	return f.setLock(ctx, owner, lk, false)
+}
+
+var _ fusefs.FileSetlker = (*FileHandle)(nil)
+
+// Setlkw obtains a lock on a file, waiting if necessary.
+func (f *FileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	return f.setLock(ctx, owner, lk, true)
+}
+
+var _ fusefs.FileSetlkwer = (*FileHandle)(nil)
+
+// releaseLocks releases the locks taken using this handle.
+//
+// go-fuse doesn't tell us the lock owner on Flush or Release so locks
+// are released when the handle is released.
+func (f *FileHandle) releaseLocks(ctx context.Context) {
+	f.mu.Lock()
+	owners := f.owners
+	f.owners = nil
+	f.mu.Unlock()
+	if len(owners) == 0 {
+		return
+	}
+	file, errno := f.file()
+	if errno != 0 {
+		return
+	}
+	for owner := range owners {
+		err := file.Unlock(ctx, owner)
+		if err != nil {
+			fs.Errorf(file, "Failed to release locks: %v", err)
+		}
+	}
+}
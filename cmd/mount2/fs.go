@@ -9,6 +9,7 @@ import (
 	"syscall"
 	"time"
 
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 	"github.com/rclone/rclone/cmd/mountlib"
 	"github.com/rclone/rclone/fs"
@@ -135,6 +136,12 @@ func translateError(err error) syscall.Errno {
 		return syscall.EINVAL
 	case vfs.ELOOP:
 		return syscall.ELOOP
+	case vfs.EAGAIN:
+		return syscall.EAGAIN
+	case vfs.ENOATTR:
+		return fusefs.ENOATTR
+	case vfs.ENOTSUP:
+		return syscall.ENOTSUP
 	}
 	fs.Errorf(nil, "IO error: %v", err)
 	return syscall.EIO
@@ -426,8 +426,16 @@ var _ = (fusefs.NodeRenamer)((*Node)(nil))
 // `dest` and return the number of bytes. If `dest` is too
 // small, it should return ERANGE and the size of the attribute.
 // If not defined, Getxattr will return ENOATTR.
-func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (uint32, syscall.Errno) {
-	return 0, syscall.ENOSYS // we never implement this
+func (n *Node) Getxattr(ctx context.Context, attr string, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("size=%d, errno=%v", &size, &errno)
+	if !n.fsys.opt.Xattrs {
+		return 0, syscall.ENOSYS
+	}
+	value, err := vfs.GetXattr(ctx, n.node, attr)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	return copyXattr(dest, value)
 }
 
 var _ fusefs.NodeGetxattrer = (*Node)(nil)
@@ -435,16 +443,24 @@ var _ fusefs.NodeGetxattrer = (*Node)(nil)
 // Setxattr should store data for the given attribute.  See
 // setxattr(2) for information about flags.
 // If not defined, Setxattr will return ENOATTR.
-func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+func (n *Node) Setxattr(ctx context.Context, attr string, data []byte, flags uint32) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q, flags=0x%X", attr, flags)("errno=%v", &errno)
+	if !n.fsys.opt.Xattrs {
+		return syscall.ENOSYS
+	}
+	return translateError(vfs.SetXattr(ctx, n.node, attr, data, int(flags)))
 }
 
 var _ fusefs.NodeSetxattrer = (*Node)(nil)
 
 // Removexattr should delete the given attribute.
 // If not defined, Removexattr will return ENOATTR.
-func (n *Node) Removexattr(ctx context.Context, attr string) syscall.Errno {
-	return syscall.ENOSYS // we never implement this
+func (n *Node) Removexattr(ctx context.Context, attr string) (errno syscall.Errno) {
+	defer log.Trace(n, "attr=%q", attr)("errno=%v", &errno)
+	if !n.fsys.opt.Xattrs {
+		return syscall.ENOSYS
+	}
+	return translateError(vfs.RemoveXattr(ctx, n.node, attr))
 }
 
 var _ fusefs.NodeRemovexattrer = (*Node)(nil)
@@ -453,12 +469,34 @@ var _ fusefs.NodeRemovexattrer = (*Node)(nil)
 // `dest`. If the `dest` buffer is too small, it should return ERANGE
 // and the correct size.  If not defined, return an empty list and
 // success.
-func (n *Node) Listxattr(ctx context.Context, dest []byte) (uint32, syscall.Errno) {
-	return 0, syscall.ENOSYS // we never implement this
+func (n *Node) Listxattr(ctx context.Context, dest []byte) (size uint32, errno syscall.Errno) {
+	defer log.Trace(n, "")("size=%d, errno=%v", &size, &errno)
+	if !n.fsys.opt.Xattrs {
+		return 0, syscall.ENOSYS
+	}
+	names, err := vfs.ListXattr(ctx, n.node)
+	if err != nil {
+		return 0, translateError(err)
+	}
+	var value []byte
+	for _, name := range names {
+		value = append(value, name...)
+		value = append(value, 0)
+	}
+	return copyXattr(dest, value)
 }
 
 var _ fusefs.NodeListxattrer = (*Node)(nil)
 
+// copyXattr copies value into dest returning ERANGE and the size
+// needed if it is too small.
+func copyXattr(dest, value []byte) (uint32, syscall.Errno) {
+	if len(value) > len(dest) {
+		return uint32(len(value)), syscall.ERANGE
+	}
+	return uint32(copy(dest, value)), 0
+}
+
 var _ fusefs.NodeReadlinker = (*Node)(nil)
 
 // Readlink read symbolic link target.
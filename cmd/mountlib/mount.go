@@ -143,6 +143,11 @@ var OptionsInfo = fs.Options{{
 	Default: false,
 	Help:    "Mount as remote network drive, instead of fixed disk drive (supported on Windows only)",
 	Groups:  "Mount",
+}, {
+	Name:    "xattrs",
+	Default: false,
+	Help:    "Show the metadata of files and directories as extended attributes (not supported on Windows)",
+	Groups:  "Mount",
 }, {
 	Name: "daemon_wait",
 	Default: func() fs.Duration {
@@ -189,6 +194,7 @@ type Options struct {
 	NetworkMode        bool          `config:"network_mode"` // Windows only
 	DirectIO           bool          `config:"direct_io"`    // use Direct IO for file access
 	CaseInsensitive    fs.Tristate   `config:"mount_case_insensitive"`
+	Xattrs             bool          `config:"xattrs"` // show metadata as extended attributes
 }
 
 type (
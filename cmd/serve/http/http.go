@@ -12,6 +12,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -45,6 +46,7 @@ type Options struct {
 	HTTP       libhttp.Config
 	Template   libhttp.TemplateConfig
 	DisableZip bool
+	AllowWrite bool
 }
 
 // DefaultOpt is the default values used for Options
@@ -71,6 +73,7 @@ func init() {
 	vfsflags.AddFlags(flagSet)
 	proxyflags.AddFlags(flagSet)
 	flagSet.BoolVar(&Opt.DisableZip, "disable-zip", false, "Disable zip download of directories")
+	flagSet.BoolVar(&Opt.AllowWrite, "allow-write", false, "Allow uploading, creating, renaming and deleting files and directories")
 	cmdserve.Command.AddCommand(Command)
 	cmdserve.AddRc("http", func(ctx context.Context, f fs.Fs, in rc.Params) (cmdserve.Handle, error) {
 		// Read VFS Opts
@@ -112,6 +115,38 @@ The server will log errors.  Use ` + "`-v`" + ` to see access logs.
 ` + "`--bwlimit`" + ` will be respected for file transfers.  Use ` + "`--stats`" + ` to
 control the stats printing.
 
+### Writing files
+
+By default the server is read only. Use ` + "`--allow-write`" + ` to let
+clients change the remote. The directory listing then has controls to
+upload files, create folders and rename and delete files and empty
+folders.
+
+Files are streamed straight into the VFS and can also be uploaded with
+a PUT request to their URL, for example
+
+    curl -T file.txt http://localhost:8080/dir/
+
+An upload is written to a partial file with the ` + "`--partial-suffix`" + `
+which replaces the file only once all of it has been received, so a
+failed upload leaves an existing file alone.
+
+Large files are uploaded from the browser in chunks which can be
+resumed if the upload fails. A chunk is a PUT request with a
+` + "`Content-Range: bytes start-end/total`" + ` header and an ` + "`Upload-Key`" + `
+header which is the same for all the chunks of an upload. The chunks
+are appended to a partial file named from the key with the
+` + "`--partial-suffix`" + ` until the last chunk arrives. Send
+` + "`Content-Range: bytes */total`" + ` with no body to find out how much has
+been received already, which is returned in the ` + "`Upload-Offset`" + `
+header. Partial files of uploads which haven't had a chunk for 24
+hours are removed. Chunked uploads need ` + "`--vfs-cache-mode writes`" + `
+or ` + "`full`" + `.
+
+Use authentication with ` + "`--allow-write`" + ` otherwise anyone who can
+connect to the server can change the files. The ` + "`--read-only`" + ` flag
+turns off writing even if ` + "`--allow-write`" + ` is set.
+
 ` + strings.TrimSpace(libhttp.Help(flagPrefix)+libhttp.TemplateHelp(flagPrefix)+libhttp.AuthHelp(flagPrefix)+vfs.Help()+proxy.Help),
 	Annotations: map[string]string{
 		"versionIntroduced": "v1.39",
@@ -145,6 +180,9 @@ type HTTP struct {
 	opt    Options
 	proxy  *proxy.Proxy
 	ctx    context.Context // for global config
+
+	uploadsMu sync.Mutex
+	uploads   map[partialUpload]time.Time // when chunked uploads were last used
 }
 
 // Gets the VFS in use for this request
@@ -203,6 +241,13 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	)
 	router.Get("/*", s.handler)
 	router.Head("/*", s.handler)
+	if s.opt.AllowWrite {
+		if s.opt.Auth.BasicUser == "" && s.opt.Auth.HtPasswd == "" && s.opt.Auth.CustomAuthFn == nil {
+			fs.Logf(nil, "Warning: --allow-write is set without authentication so anyone can change files")
+		}
+		router.Post("/*", s.postHandler)
+		router.Put("/*", s.putHandler)
+	}
 
 	return s, nil
 }
@@ -301,6 +346,12 @@ func (s *HTTP) serveDir(w http.ResponseWriter, r *http.Request, dirRemote string
 	w.Header().Set("Last-Modified", dir.ModTime().UTC().Format(http.TimeFormat))
 
 	directory.DisableZip = s.opt.DisableZip
+	if s.opt.AllowWrite && !VFS.Opt.ReadOnly {
+		directory.AllowWrite = true
+		if VFS.Opt.CacheMode >= vfscommon.CacheModeWrites {
+			directory.UploadChunkSize = uploadChunkSize
+		}
+	}
 
 	directory.Serve(w, r)
 }
@@ -1,15 +1,21 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"io"
 	stdfs "io/fs"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	_ "github.com/rclone/rclone/backend/local"
@@ -36,11 +42,14 @@ const (
 )
 
 func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string) {
-	opts := Options{
-		HTTP: libhttp.DefaultCfg(),
-		Template: libhttp.TemplateConfig{
-			Path: testTemplate,
-		},
+	return startOpt(ctx, t, f, Options{}, &vfscommon.Opt)
+}
+
+// startOpt starts the server with opts which has the test settings added
+func startOpt(ctx context.Context, t *testing.T, f fs.Fs, opts Options, vfsOpt *vfscommon.Options) (s *HTTP, testURL string) {
+	opts.HTTP = libhttp.DefaultCfg()
+	opts.Template = libhttp.TemplateConfig{
+		Path: testTemplate,
 	}
 	opts.HTTP.ListenAddr = []string{testBindAddress}
 	if proxy.Opt.AuthProxy == "" {
@@ -48,7 +57,7 @@ func start(ctx context.Context, t *testing.T, f fs.Fs) (s *HTTP, testURL string)
 		opts.Auth.BasicPass = testPass
 	}
 
-	s, err := newServer(ctx, f, &opts, &vfscommon.Opt, &proxy.Opt)
+	s, err := newServer(ctx, f, &opts, vfsOpt, &proxy.Opt)
 	require.NoError(t, err, "failed to start server")
 	go func() {
 		require.NoError(t, s.Serve())
@@ -303,3 +312,186 @@ func TestRc(t *testing.T) {
 		"vfs_cache_mode": "off",
 	})
 }
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = vfscommon.CacheModeWrites
+	s, testURL := startOpt(ctx, t, f, Options{AllowWrite: true}, &vfsOpt)
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	// don't follow the redirects to the directory listing
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method, URL string, body io.Reader, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, testURL+URL, body)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.SetBasicAuth(testUser, testPass)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp
+	}
+	form := func(values url.Values) *http.Response {
+		return do("POST", "", strings.NewReader(values.Encode()), http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		})
+	}
+	// the files are read from the VFS as they are uploaded to the remote later
+	checkFile := func(name, want string) {
+		got, err := s._vfs.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+	exists := func(name string) bool {
+		_, err := s._vfs.Stat(name)
+		return err == nil
+	}
+
+	// Upload with a multipart form
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	part, err := mw.CreateFormFile("file", "form.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("from a form"))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+	resp := do("POST", "", &buf, http.Header{"Content-Type": {mw.FormDataContentType()}})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	checkFile("form.txt", "from a form")
+
+	// Upload with PUT
+	resp = do("PUT", "put.txt", strings.NewReader("put"), nil)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	checkFile("put.txt", "put")
+
+	// Failed uploads leave the existing file alone and don't leave
+	// partial files behind
+	broken := func() io.Reader {
+		return io.MultiReader(strings.NewReader("broken"), iotest.ErrReader(errors.New("connection lost")))
+	}
+	files := func() (names []string) {
+		nodes, err := s._vfs.ReadDir("")
+		require.NoError(t, err)
+		for _, node := range nodes {
+			names = append(names, node.Name())
+		}
+		return names
+	}
+	before := files()
+	rec := httptest.NewRecorder()
+	s.putHandler(rec, httptest.NewRequest("PUT", "/put.txt", broken()))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	checkFile("put.txt", "put")
+	buf.Reset()
+	mw = multipart.NewWriter(&buf)
+	part, err = mw.CreateFormFile("file", "form.txt")
+	require.NoError(t, err)
+	_, err = part.Write([]byte("broken"))
+	require.NoError(t, err)
+	req := httptest.NewRequest("POST", "/", io.MultiReader(&buf, iotest.ErrReader(errors.New("connection lost"))))
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec = httptest.NewRecorder()
+	s.postHandler(rec, req)
+	assert.NotEqual(t, http.StatusSeeOther, rec.Code)
+	checkFile("form.txt", "from a form")
+	assert.Equal(t, before, files())
+
+	// Upload in chunks
+	chunk := func(contentRange, body string) *http.Response {
+		return do("PUT", "chunked.txt", strings.NewReader(body), http.Header{"Content-Range": {contentRange}, "Upload-Key": {"one"}})
+	}
+	resp = chunk("bytes */10", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("Upload-Offset"))
+	resp = chunk("bytes 0-3/10", "0123")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "4", resp.Header.Get("Upload-Offset"))
+	resp = chunk("bytes 8-9/10", "89")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "4", resp.Header.Get("Upload-Offset"))
+	resp = chunk("bytes */10", "")
+	assert.Equal(t, "4", resp.Header.Get("Upload-Offset"))
+	resp = chunk("bytes 4-9/10", "456789")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	checkFile("chunked.txt", "0123456789")
+	assert.False(t, exists(partialName(ctx, "chunked.txt", "one")))
+	resp = chunk("bytes 4-20/10", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do("PUT", "chunked.txt", strings.NewReader("0"), http.Header{"Content-Range": {"bytes 0-0/10"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// Each upload has its own partial file
+	resp = chunk("bytes 0-3/10", "0123")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do("PUT", "chunked.txt", strings.NewReader("ab"), http.Header{"Content-Range": {"bytes 0-1/4"}, "Upload-Key": {"two"}})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Upload-Offset"))
+	resp = chunk("bytes */10", "")
+	assert.Equal(t, "4", resp.Header.Get("Upload-Offset"))
+
+	// Uploads which aren't used are removed
+	partial := partialName(ctx, "chunked.txt", "one")
+	require.True(t, exists(partial))
+	s.uploadsMu.Lock()
+	s.uploads[partialUpload{VFS: s._vfs, remote: partial}] = time.Now().Add(-uploadExpiry)
+	s.uploadsMu.Unlock()
+	resp = do("PUT", "chunked.txt", strings.NewReader("cd"), http.Header{"Content-Range": {"bytes 2-3/4"}, "Upload-Key": {"two"}})
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	checkFile("chunked.txt", "abcd")
+	assert.False(t, exists(partial))
+
+	// Make a directory, rename and delete
+	resp = form(url.Values{"action": {"mkdir"}, "name": {"dir"}})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.True(t, exists("dir"))
+	resp = form(url.Values{"action": {"rename"}, "name": {"put.txt"}, "to": {"renamed.txt"}})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	checkFile("renamed.txt", "put")
+	resp = form(url.Values{"action": {"delete"}, "name": {"dir/"}})
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.False(t, exists("dir"))
+
+	// Bad requests are refused
+	resp = form(url.Values{"action": {"delete"}, "name": {"../renamed.txt"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do("PUT", "dir/../../other.txt", strings.NewReader("x"), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = do("PUT", "a//b.txt", strings.NewReader("x"), nil)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = form(url.Values{"action": {"delete"}, "name": {"missing"}})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do("PUT", "other.txt", strings.NewReader("x"), http.Header{"Origin": {"http://example.com"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	checkFile("renamed.txt", "put")
+}
+
+func TestWriteReadOnly(t *testing.T) {
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	vfsOpt := vfscommon.Opt
+	vfsOpt.ReadOnly = true
+	s, testURL := startOpt(ctx, t, f, Options{AllowWrite: true}, &vfsOpt)
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+
+	req, err := http.NewRequest("PUT", testURL+"file.txt", strings.NewReader("data"))
+	require.NoError(t, err)
+	req.SetBasicAuth(testUser, testPass)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,419 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/http/serve"
+	"github.com/rclone/rclone/lib/random"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// uploadChunkSize is the size of the chunks the directory listing
+// uses to upload large files
+const uploadChunkSize = 16 * 1024 * 1024
+
+// uploadExpiry is how long a chunked upload which hasn't been added
+// to is kept before its partial file is removed
+const uploadExpiry = 24 * time.Hour
+
+// partialUpload identifies the partial file of a chunked upload
+type partialUpload struct {
+	VFS    *vfs.VFS
+	remote string
+}
+
+// writeVFS returns the VFS to change for this request or writes an
+// error to w and returns nil
+func (s *HTTP) writeVFS(w http.ResponseWriter, r *http.Request) *vfs.VFS {
+	// Refuse requests from other sites so a page elsewhere can't
+	// use the credentials cached in the browser to change files
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			http.Error(w, "Cross origin request refused", http.StatusForbidden)
+			return nil
+		}
+	}
+	VFS, err := s.getVFS(r.Context())
+	if err != nil {
+		http.Error(w, "Root directory not found", http.StatusNotFound)
+		fs.Errorf(nil, "Failed to write: %v", err)
+		return nil
+	}
+	if VFS.Opt.ReadOnly {
+		http.Error(w, "Read only file system", http.StatusForbidden)
+		return nil
+	}
+	return VFS
+}
+
+// writeError writes err from the VFS to w with a suitable status
+func writeError(w http.ResponseWriter, r *http.Request, remote string, err error) {
+	switch {
+	case errors.Is(err, vfs.ENOENT):
+		http.Error(w, "Not found", http.StatusNotFound)
+	case errors.Is(err, vfs.EEXIST):
+		http.Error(w, "Already exists", http.StatusConflict)
+	case errors.Is(err, vfs.ENOTEMPTY):
+		http.Error(w, "Directory not empty", http.StatusConflict)
+	case errors.Is(err, vfs.EROFS), errors.Is(err, vfs.EPERM):
+		http.Error(w, "Permission denied", http.StatusForbidden)
+	case errors.Is(err, vfs.EINVAL):
+		http.Error(w, "Invalid name", http.StatusBadRequest)
+	default:
+		serve.Error(r.Context(), remote, w, "Failed to write", err)
+	}
+}
+
+// checkLeaf returns the name of an entry in a directory from a form
+// or an error if it isn't valid
+func checkLeaf(name string) (string, error) {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return "", vfs.EINVAL
+	}
+	return name, nil
+}
+
+// checkRemote returns an error if any of the path segments of remote
+// aren't valid names
+func checkRemote(remote string) error {
+	for leaf := range strings.SplitSeq(remote, "/") {
+		if _, err := checkLeaf(leaf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postHandler changes the directory at the URL from the forms in the
+// directory listing.
+//
+// A multipart form uploads its files into the directory otherwise the
+// form's action is done.
+func (s *HTTP) postHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	VFS := s.writeVFS(w, r)
+	if VFS == nil {
+		return
+	}
+	dirRemote := strings.Trim(r.URL.Path, "/")
+	node, err := VFS.Stat(dirRemote)
+	if err != nil {
+		writeError(w, r, dirRemote, err)
+		return
+	}
+	if !node.IsDir() {
+		http.Error(w, "Not a directory", http.StatusNotFound)
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		err = s.uploadForm(r, VFS, dirRemote)
+	} else {
+		err = s.formAction(r, VFS, dirRemote)
+	}
+	if err != nil {
+		writeError(w, r, dirRemote, err)
+		return
+	}
+	// Show the directory listing again
+	http.Redirect(w, r, r.URL.String(), http.StatusSeeOther)
+}
+
+// uploadForm streams the files in a multipart form into dirRemote
+func (s *HTTP) uploadForm(r *http.Request, VFS *vfs.VFS, dirRemote string) error {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return fmt.Errorf("bad form: %w", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("bad form: %w", err)
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			continue
+		}
+		leaf, err := checkLeaf(part.FileName())
+		if err != nil {
+			return err
+		}
+		remote := path.Join(dirRemote, leaf)
+		fs.Infof(remote, "%s: Uploading file", r.RemoteAddr)
+		err = uploadFile(r.Context(), VFS, remote, part)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// formAction does the action in the form to the entry name in dirRemote
+func (s *HTTP) formAction(r *http.Request, VFS *vfs.VFS, dirRemote string) error {
+	err := r.ParseForm()
+	if err != nil {
+		return fmt.Errorf("bad form: %w", err)
+	}
+	leaf, err := checkLeaf(r.PostForm.Get("name"))
+	if err != nil {
+		return err
+	}
+	remote := path.Join(dirRemote, leaf)
+	switch action := r.PostForm.Get("action"); action {
+	case "mkdir":
+		fs.Infof(remote, "%s: Creating directory", r.RemoteAddr)
+		return VFS.Mkdir(remote, 0777)
+	case "rename":
+		toLeaf, err := checkLeaf(r.PostForm.Get("to"))
+		if err != nil {
+			return err
+		}
+		toRemote := path.Join(dirRemote, toLeaf)
+		fs.Infof(remote, "%s: Renaming to %q", r.RemoteAddr, toRemote)
+		return VFS.Rename(remote, toRemote)
+	case "delete":
+		fs.Infof(remote, "%s: Deleting", r.RemoteAddr)
+		return VFS.Remove(remote)
+	default:
+		return fmt.Errorf("unknown action %q: %w", action, vfs.EINVAL)
+	}
+}
+
+// upload streams in into the file at remote opened with extra flags
+func upload(VFS *vfs.VFS, remote string, flags int, in io.Reader) error {
+	fd, err := VFS.OpenFile(remote, os.O_WRONLY|os.O_CREATE|flags, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fd, in)
+	closeErr := fd.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// uploadFile streams in into the file at remote.
+//
+// The data is written to a partial file which is only renamed to
+// remote once all of it has been received so a failed upload doesn't
+// replace an existing file.
+func uploadFile(ctx context.Context, VFS *vfs.VFS, remote string, in io.Reader) error {
+	partial := remote + "." + random.String(8) + fs.GetConfig(ctx).PartialSuffix
+	err := upload(VFS, partial, os.O_TRUNC, in)
+	if err == nil {
+		err = VFS.Rename(partial, remote)
+	}
+	if err != nil {
+		removeErr := VFS.Remove(partial)
+		if removeErr != nil && !errors.Is(removeErr, vfs.ENOENT) {
+			fs.Errorf(partial, "Failed to remove partial upload: %v", removeErr)
+		}
+	}
+	return err
+}
+
+// parseContentRange parses a Content-Range header of a chunked upload
+// which is either "bytes start-end/total" or "bytes */total" to ask
+// how much has been uploaded. start is -1 in the second case.
+func parseContentRange(contentRange string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(contentRange, "bytes ")
+	if !ok {
+		return 0, 0, 0, errors.New("unit must be bytes")
+	}
+	rng, totalString, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, errors.New("missing total size")
+	}
+	total, err = strconv.ParseInt(totalString, 10, 64)
+	if err != nil || total < 0 {
+		return 0, 0, 0, errors.New("bad total size")
+	}
+	if rng == "*" {
+		return -1, -1, total, nil
+	}
+	startString, endString, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, errors.New("bad range")
+	}
+	start, err = strconv.ParseInt(startString, 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.New("bad range start")
+	}
+	end, err = strconv.ParseInt(endString, 10, 64)
+	if err != nil {
+		return 0, 0, 0, errors.New("bad range end")
+	}
+	if start < 0 || end < start || end >= total {
+		return 0, 0, 0, errors.New("range outside total size")
+	}
+	return start, end, total, nil
+}
+
+// partialName returns the name of the partial file for the chunked
+// upload of remote identified by key
+func partialName(ctx context.Context, remote, key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return remote + "." + hex.EncodeToString(hash[:8]) + fs.GetConfig(ctx).PartialSuffix
+}
+
+// touchUpload records that the partial file of a chunked upload has
+// been used and removes the partial files of uploads which haven't
+// been used for uploadExpiry.
+func (s *HTTP) touchUpload(upload partialUpload) {
+	now := time.Now()
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	if s.uploads == nil {
+		s.uploads = make(map[partialUpload]time.Time)
+	}
+	s.uploads[upload] = now
+	for old, used := range s.uploads {
+		if now.Sub(used) < uploadExpiry {
+			continue
+		}
+		fs.Infof(old.remote, "Removing expired partial upload")
+		err := old.VFS.Remove(old.remote)
+		if err != nil && !errors.Is(err, vfs.ENOENT) {
+			fs.Errorf(old.remote, "Failed to remove expired partial upload: %v", err)
+		}
+		delete(s.uploads, old)
+	}
+}
+
+// finishUpload forgets the chunked upload
+func (s *HTTP) finishUpload(upload partialUpload) {
+	s.uploadsMu.Lock()
+	delete(s.uploads, upload)
+	s.uploadsMu.Unlock()
+}
+
+// putHandler uploads the body of the request to the file at the URL.
+//
+// With a Content-Range header the body is a chunk of the file which
+// is appended to a partial file until the last chunk arrives. The
+// Upload-Key header identifies the upload so each upload has its own
+// partial file. The Upload-Offset header of the response says how
+// much of the file has been received so a failed upload can carry on
+// from there.
+func (s *HTTP) putHandler(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "Can't upload to a directory", http.StatusMethodNotAllowed)
+		return
+	}
+	VFS := s.writeVFS(w, r)
+	if VFS == nil {
+		return
+	}
+	remote := strings.Trim(r.URL.Path, "/")
+	if err := checkRemote(remote); err != nil {
+		writeError(w, r, remote, err)
+		return
+	}
+	contentRange := r.Header.Get("Content-Range")
+	if contentRange == "" {
+		fs.Infof(remote, "%s: Uploading file", r.RemoteAddr)
+		err := uploadFile(r.Context(), VFS, remote, r.Body)
+		if err != nil {
+			writeError(w, r, remote, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	start, end, total, err := parseContentRange(contentRange)
+	if err != nil {
+		http.Error(w, "Bad Content-Range: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.Header.Get("Upload-Key")
+	if key == "" {
+		http.Error(w, "Chunked uploads need an Upload-Key header", http.StatusBadRequest)
+		return
+	}
+	partial := partialName(r.Context(), remote, key)
+	pu := partialUpload{VFS: VFS, remote: partial}
+	s.touchUpload(pu)
+
+	// Find out how much has been uploaded already
+	var offset int64
+	node, err := VFS.Stat(partial)
+	if err == nil {
+		offset = node.Size()
+	} else if err != vfs.ENOENT {
+		writeError(w, r, partial, err)
+		return
+	}
+	if start < 0 || (start > 0 && start != offset) {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		if start < 0 {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			http.Error(w, "Chunk doesn't follow on from the upload", http.StatusConflict)
+		}
+		return
+	}
+	if start > 0 && VFS.Opt.CacheMode < vfscommon.CacheModeWrites {
+		http.Error(w, "Chunked uploads need --vfs-cache-mode writes or full", http.StatusNotImplemented)
+		return
+	}
+
+	// Append the chunk to the partial file
+	flags := os.O_APPEND
+	if start == 0 {
+		flags = os.O_TRUNC
+	}
+	size := end - start + 1
+	fs.Debugf(remote, "%s: Uploading chunk %d-%d/%d", r.RemoteAddr, start, end, total)
+	err = upload(VFS, partial, flags, io.LimitReader(r.Body, size))
+	if err != nil {
+		writeError(w, r, partial, err)
+		return
+	}
+	node, err = VFS.Stat(partial)
+	if err != nil {
+		writeError(w, r, partial, err)
+		return
+	}
+	offset = node.Size()
+	if offset != end+1 {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "Chunk was short", http.StatusBadRequest)
+		return
+	}
+	if offset < total {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Move the completed upload into place
+	fs.Infof(remote, "%s: Uploaded file in chunks", r.RemoteAddr)
+	err = VFS.Rename(partial, remote)
+	if err != nil {
+		writeError(w, r, remote, err)
+		return
+	}
+	s.finishUpload(pu)
+	w.WriteHeader(http.StatusCreated)
+}
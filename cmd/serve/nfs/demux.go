@@ -0,0 +1,105 @@
+//go:build unix
+
+package nfs
+
+import (
+	"bufio"
+	"errors"
+	"net"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// RPC program and version numbers looked at when sorting connections
+const (
+	nfsProgram = 100003
+	nfsV4      = 4
+)
+
+// demuxListener wraps the listener passed to go-nfs.
+//
+// go-nfs only speaks NFSv3 so the first call on each new connection
+// is peeked at and connections for NFSv4 are served by the nfs4Server
+// instead. Everything else, including the MOUNT protocol, is passed
+// on to go-nfs.
+type demuxListener struct {
+	net.Listener
+	v4    *nfs4Server
+	conns chan net.Conn // connections for go-nfs
+	done  chan struct{} // closed when the listener has stopped
+	err   error         // error which stopped the listener
+}
+
+// newDemuxListener starts sorting the connections accepted on l
+func newDemuxListener(l net.Listener, v4 *nfs4Server) *demuxListener {
+	dl := &demuxListener{
+		Listener: l,
+		v4:       v4,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	go dl.acceptLoop()
+	return dl
+}
+
+// acceptLoop accepts connections until the listener is closed
+func (dl *demuxListener) acceptLoop() {
+	for {
+		c, err := dl.Listener.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			dl.err = err
+			close(dl.done)
+			return
+		}
+		go dl.sort(c)
+	}
+}
+
+// sort reads the start of the first call on c and sends c to the
+// server which speaks its protocol version
+func (dl *demuxListener) sort(c net.Conn) {
+	br := bufio.NewReaderSize(c, 64*1024)
+	// record mark, xid, msg_type, rpcvers, prog, vers
+	hdr, err := br.Peek(24)
+	if err != nil {
+		fs.Debugf("nfs", "Closing connection from %v: %v", c.RemoteAddr(), err)
+		_ = c.Close()
+		return
+	}
+	bc := &bufferedConn{Conn: c, r: br}
+	if be.Uint32(hdr[16:]) == nfsProgram && be.Uint32(hdr[20:]) == nfsV4 {
+		dl.v4.serveConn(bc)
+		return
+	}
+	select {
+	case dl.conns <- bc:
+	case <-dl.done:
+		_ = c.Close()
+	}
+}
+
+// Accept returns the next connection for go-nfs
+func (dl *demuxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-dl.conns:
+		return c, nil
+	case <-dl.done:
+		return nil, dl.err
+	}
+}
+
+// bufferedConn is a net.Conn which reads through a bufio.Reader so
+// the bytes peeked at aren't lost
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read reads from the buffer
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -1,6 +1,6 @@
 //go:build unix
 
-// Package nfs implements a server to serve a VFS remote over the NFSv3 protocol
+// Package nfs implements a server to serve a VFS remote over the NFSv3 and NFSv4 protocols
 //
 // There is no authentication available on this server and it is
 // served on the loopback interface by default.
@@ -126,7 +126,9 @@ var Command = &cobra.Command{
 	Short: `Serve the remote as an NFS mount`,
 	Long: strings.ReplaceAll(`Create an NFS server that serves the given remote over the network.
 
-This implements an NFSv3 server to serve any rclone remote via NFS.
+This implements an NFS server to serve any rclone remote via NFS. It
+speaks NFSv3 and NFSv4.0/4.1 on the same port, choosing the protocol
+from the first request on each connection.
 
 The primary purpose for this command is to enable the [mount
 command](/commands/rclone_mount/) on recent macOS versions where
@@ -194,6 +196,26 @@ Where |$PORT| is the same port number used in the |serve nfs| command
 and |$HOSTNAME| is the network address of the machine that |serve nfs|
 was run on.
 
+To mount using NFSv4 instead, which doesn't need the separate mount
+protocol, use:
+
+|||sh
+mount -t nfs -o vers=4.1,port=$PORT $HOSTNAME:/ path/to/mountpoint
+|||
+
+The NFSv4 pseudo root is the root of the remote. NFSv4 clients get
+stateful opens and byte range locks which are backed by the VFS so are
+shared with other users of the same VFS, and with other hosts using
+the remote if |--vfs-lock-remote| is set. Delegations are not supported. NFSv4 file
+handles are stored in the NFS handle cache in the same way as NFSv3
+ones. Open and lock state is held in memory so is lost if the server
+is restarted.
+
+NFSv3 clients don't get locks as the NFSv3 lock protocol (NLM) isn't
+implemented. Mount them with |nolock| (or |local_lock=all| on Linux)
+so applications which take locks don't fail, or use NFSv4 if the
+locks need to be shared.
+
 If |--vfs-metadata-extension| is in use then for the |--nfs-cache-type disk|
 and |--nfs-cache-type cache| the metadata files will have the file
 handle of their parent file suffixed with |0x00, 0x00, 0x00, 0x01|.
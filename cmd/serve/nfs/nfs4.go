@@ -0,0 +1,729 @@
+//go:build unix
+
+package nfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// ONC RPC constants
+const (
+	rpcVersion      = 2
+	rpcCall         = 0
+	rpcReply        = 1
+	rpcMsgAccepted  = 0
+	rpcMsgDenied    = 1
+	rpcSuccess      = 0
+	rpcProgUnavail  = 1
+	rpcProgMismatch = 2
+	rpcProcUnavail  = 3
+	rpcGarbageArgs  = 4
+	rpcMismatch     = 0
+	rpcAuthError    = 1
+	rpcAuthBadCred  = 1
+	authNone        = 0
+	authSys         = 1
+	rpcsecGSS       = 6
+	maxAuthBytes    = 400
+)
+
+// NFSv4 procedures
+const (
+	nfs4ProcNull     = 0
+	nfs4ProcCompound = 1
+)
+
+// NFSv4 operations
+const (
+	opAccess             = 3
+	opClose              = 4
+	opCommit             = 5
+	opCreate             = 6
+	opDelegPurge         = 7
+	opDelegReturn        = 8
+	opGetattr            = 9
+	opGetFH              = 10
+	opLink               = 11
+	opLock               = 12
+	opLockT              = 13
+	opLockU              = 14
+	opLookup             = 15
+	opLookupP            = 16
+	opNVerify            = 17
+	opOpen               = 18
+	opOpenAttr           = 19
+	opOpenConfirm        = 20
+	opOpenDowngrade      = 21
+	opPutFH              = 22
+	opPutPubFH           = 23
+	opPutRootFH          = 24
+	opRead               = 25
+	opReaddir            = 26
+	opReadlink           = 27
+	opRemove             = 28
+	opRename             = 29
+	opRenew              = 30
+	opRestoreFH          = 31
+	opSaveFH             = 32
+	opSecinfo            = 33
+	opSetattr            = 34
+	opSetClientID        = 35
+	opSetClientIDConfirm = 36
+	opVerify             = 37
+	opWrite              = 38
+	opReleaseLockOwner   = 39
+	opBackchannelCtl     = 40
+	opBindConnToSession  = 41
+	opExchangeID         = 42
+	opCreateSession      = 43
+	opDestroySession     = 44
+	opFreeStateID        = 45
+	opGetDirDelegation   = 46
+	opGetDeviceInfo      = 47
+	opGetDeviceList      = 48
+	opLayoutCommit       = 49
+	opLayoutGet          = 50
+	opLayoutReturn       = 51
+	opSecinfoNoName      = 52
+	opSequence           = 53
+	opSetSSV             = 54
+	opTestStateID        = 55
+	opWantDelegation     = 56
+	opDestroyClientID    = 57
+	opReclaimComplete    = 58
+	opIllegal            = 10044
+)
+
+// nfsStat4 is an NFSv4 status code
+//
+// Handlers return these as errors to fail an operation with a
+// specific status.
+type nfsStat4 uint32
+
+// NFSv4 status codes
+const (
+	nfs4OK                   nfsStat4 = 0
+	nfs4ErrPerm              nfsStat4 = 1
+	nfs4ErrNoEnt             nfsStat4 = 2
+	nfs4ErrIO                nfsStat4 = 5
+	nfs4ErrAccess            nfsStat4 = 13
+	nfs4ErrExist             nfsStat4 = 17
+	nfs4ErrNotDir            nfsStat4 = 20
+	nfs4ErrIsDir             nfsStat4 = 21
+	nfs4ErrInval             nfsStat4 = 22
+	nfs4ErrROFS              nfsStat4 = 30
+	nfs4ErrNameTooLong       nfsStat4 = 63
+	nfs4ErrNotEmpty          nfsStat4 = 66
+	nfs4ErrStale             nfsStat4 = 70
+	nfs4ErrBadHandle         nfsStat4 = 10001
+	nfs4ErrBadCookie         nfsStat4 = 10003
+	nfs4ErrNotSupp           nfsStat4 = 10004
+	nfs4ErrTooSmall          nfsStat4 = 10005
+	nfs4ErrBadType           nfsStat4 = 10007
+	nfs4ErrDelay             nfsStat4 = 10008
+	nfs4ErrSame              nfsStat4 = 10009
+	nfs4ErrDenied            nfsStat4 = 10010
+	nfs4ErrShareDenied       nfsStat4 = 10015
+	nfs4ErrResource          nfsStat4 = 10018
+	nfs4ErrMoved             nfsStat4 = 10019
+	nfs4ErrNoFileHandle      nfsStat4 = 10020
+	nfs4ErrMinorVersMismatch nfsStat4 = 10021
+	nfs4ErrStaleClientID     nfsStat4 = 10022
+	nfs4ErrStaleStateID      nfsStat4 = 10023
+	nfs4ErrOldStateID        nfsStat4 = 10024
+	nfs4ErrBadStateID        nfsStat4 = 10025
+	nfs4ErrBadSeqid          nfsStat4 = 10026
+	nfs4ErrNotSame           nfsStat4 = 10027
+	nfs4ErrSymlink           nfsStat4 = 10029
+	nfs4ErrRestoreFH         nfsStat4 = 10030
+	nfs4ErrAttrNotSupp       nfsStat4 = 10032
+	nfs4ErrNoGrace           nfsStat4 = 10033
+	nfs4ErrBadXDR            nfsStat4 = 10036
+	nfs4ErrLocksHeld         nfsStat4 = 10037
+	nfs4ErrOpenMode          nfsStat4 = 10038
+	nfs4ErrBadName           nfsStat4 = 10041
+	nfs4ErrOpIllegal         nfsStat4 = 10044
+	nfs4ErrBadSession        nfsStat4 = 10052
+	nfs4ErrBadSlot           nfsStat4 = 10053
+	nfs4ErrSeqMisordered     nfsStat4 = 10063
+	nfs4ErrSequencePos       nfsStat4 = 10064
+	nfs4ErrRetryUncachedRep  nfsStat4 = 10068
+	nfs4ErrTooManyOps        nfsStat4 = 10070
+	nfs4ErrOpNotInSession    nfsStat4 = 10071
+	nfs4ErrClientIDBusy      nfsStat4 = 10074
+	nfs4ErrEncrAlgUnsupp     nfsStat4 = 10079
+	nfs4ErrNotOnlyOp         nfsStat4 = 10081
+)
+
+// Error satisfies the error interface
+func (s nfsStat4) Error() string {
+	return fmt.Sprintf("NFS4 status %d", uint32(s))
+}
+
+// Limits on the sizes of things
+const (
+	nfs4MaxFH      = 128                 // largest file handle
+	nfs4MaxName    = 255                 // longest file name
+	nfs4MaxOpaque  = 1024                // longest owner or other identifier
+	nfs4MaxIO      = 1024 * 1024         // largest read or write
+	nfs4MaxRecord  = nfs4MaxIO + 64*1024 // largest RPC record
+	nfs4MaxOps     = 64                  // most operations in a 4.1 compound
+	nfs4MaxSlots   = 64                  // most slots in a 4.1 session
+	nfs4LeaseTime  = 90                  // lease time in seconds
+	nfs4MaxReaddir = 256                 // directory snapshots kept for READDIR
+)
+
+// nfs4Server serves NFSv4.0 and NFSv4.1 over connections handed to
+// it by the demuxListener.
+type nfs4Server struct {
+	ctx      context.Context
+	h        *Handler
+	vfs      *vfs.VFS
+	readOnly bool          // set if the filesystem can't be written to
+	boot     uint32        // changes each time the server is started
+	verifier [8]byte       // write verifier
+	counter  atomic.Uint64 // for making unique IDs
+
+	connMu sync.Mutex
+	conns  map[net.Conn]struct{} // open connections
+
+	mu        sync.Mutex                 // protects the state below
+	clients   map[uint64]*nfs4Client     // clients by clientid
+	sessions  map[[16]byte]*nfs4Session  // sessions by sessionid
+	states    map[[12]byte]*nfs4State    // open and lock states by stateid
+	opens     map[*vfs.File][]*nfs4State // open states by file
+	exclusive map[string][8]byte         // verifiers of exclusively created files
+	dirMu     sync.Mutex                 // protects dirs and dirOrder
+	dirs      map[uint64]nfs4Dir         // READDIR snapshots by cookie verifier
+	dirOrder  []uint64                   // order to discard snapshots
+
+	unlockMu  sync.Mutex    // held while releasing the VFS locks of removed states
+	pendingMu sync.Mutex    // protects pending
+	pending   []nfs4Unlock  // VFS locks of removed states to release
+	stop      chan struct{} // closed to stop the expirer
+	expirerWg sync.WaitGroup
+}
+
+// newNFS4Server makes a server for the filesystem served by h
+func newNFS4Server(ctx context.Context, h *Handler) *nfs4Server {
+	now := time.Now()
+	s := &nfs4Server{
+		ctx:       ctx,
+		h:         h,
+		vfs:       h.vfs,
+		readOnly:  h.vfs.Opt.CacheMode == vfscommon.CacheModeOff || h.vfs.Opt.ReadOnly,
+		boot:      uint32(now.Unix()),
+		conns:     make(map[net.Conn]struct{}),
+		clients:   make(map[uint64]*nfs4Client),
+		sessions:  make(map[[16]byte]*nfs4Session),
+		states:    make(map[[12]byte]*nfs4State),
+		opens:     make(map[*vfs.File][]*nfs4State),
+		exclusive: make(map[string][8]byte),
+		dirs:      make(map[uint64]nfs4Dir),
+		stop:      make(chan struct{}),
+	}
+	be.PutUint64(s.verifier[:], uint64(now.UnixNano()))
+	s.expirerWg.Add(1)
+	go s.expirer()
+	return s
+}
+
+// nextID returns a new ID unique to this run of the server
+func (s *nfs4Server) nextID() uint64 {
+	return uint64(s.boot)<<32 | s.counter.Add(1)
+}
+
+// shutdown closes all the connections and releases all the state
+func (s *nfs4Server) shutdown() {
+	close(s.stop)
+	s.expirerWg.Wait()
+	s.connMu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.connMu.Unlock()
+	s.mu.Lock()
+	for _, client := range s.clients {
+		s.expireClient(client)
+	}
+	s.mu.Unlock()
+	s.releaseLocks()
+}
+
+// nfs4Conn is a connection from an NFSv4 client
+type nfs4Conn struct {
+	s      *nfs4Server
+	c      net.Conn
+	what   string
+	wg     sync.WaitGroup // requests in progress
+	sendMu sync.Mutex     // serializes replies
+}
+
+// serveConn serves NFSv4 on c until it is closed
+//
+// Records are read here then each call is run in its own goroutine
+// so slow operations don't hold up the others.
+func (s *nfs4Server) serveConn(c net.Conn) {
+	nc := &nfs4Conn{
+		s:    s,
+		c:    c,
+		what: fmt.Sprintf("serve nfs4 %s->%s", c.RemoteAddr(), c.LocalAddr()),
+	}
+	s.connMu.Lock()
+	s.conns[c] = struct{}{}
+	s.connMu.Unlock()
+	fs.Debugf(nc.what, "New connection")
+	defer func() {
+		_ = c.Close()
+		nc.wg.Wait()
+		s.connMu.Lock()
+		delete(s.conns, c)
+		s.connMu.Unlock()
+		fs.Debugf(nc.what, "Connection closed")
+	}()
+	for {
+		msg, err := nc.readRecord()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(nc.what, "Closing connection: %v", err)
+			}
+			return
+		}
+		nc.wg.Add(1)
+		go func() {
+			defer nc.wg.Done()
+			reply := nc.call(msg)
+			if reply != nil {
+				nc.send(reply)
+			}
+		}()
+	}
+}
+
+// readRecord reads a complete RPC record joining up its fragments
+func (nc *nfs4Conn) readRecord() ([]byte, error) {
+	var msg []byte
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(nc.c, hdr[:]); err != nil {
+			return nil, err
+		}
+		mark := be.Uint32(hdr[:])
+		size := int(mark & 0x7FFFFFFF)
+		if len(msg)+size > nfs4MaxRecord {
+			return nil, fmt.Errorf("record too large: %d bytes", len(msg)+size)
+		}
+		start := len(msg)
+		msg = append(msg, make([]byte, size)...)
+		if _, err := io.ReadFull(nc.c, msg[start:]); err != nil {
+			return nil, err
+		}
+		if mark&0x80000000 != 0 {
+			return msg, nil
+		}
+	}
+}
+
+// send writes reply as a single record
+func (nc *nfs4Conn) send(reply []byte) {
+	be.PutUint32(reply, uint32(len(reply)-4)|0x80000000)
+	nc.sendMu.Lock()
+	defer nc.sendMu.Unlock()
+	if _, err := nc.c.Write(reply); err != nil {
+		fs.Debugf(nc.what, "Failed to send reply: %v", err)
+		_ = nc.c.Close()
+	}
+}
+
+// call runs the RPC call in msg returning the reply with space for
+// the record mark at the start, or nil if there is no reply to send
+func (nc *nfs4Conn) call(msg []byte) []byte {
+	d := &xdrDecoder{b: msg}
+	xid := d.uint32()
+	msgType := d.uint32()
+	rpcVers := d.uint32()
+	prog := d.uint32()
+	vers := d.uint32()
+	proc := d.uint32()
+	credFlavor := d.uint32()
+	_ = d.opaque(maxAuthBytes)
+	_ = d.uint32() // verifier flavor
+	_ = d.opaque(maxAuthBytes)
+	if d.err != nil || msgType != rpcCall {
+		fs.Debugf(nc.what, "Ignoring bad RPC call")
+		return nil
+	}
+	e := &xdrEncoder{b: make([]byte, 4, 256)}
+	e.uint32(xid)
+	e.uint32(rpcReply)
+	switch {
+	case rpcVers != rpcVersion:
+		e.uint32(rpcMsgDenied)
+		e.uint32(rpcMismatch)
+		e.uint32(rpcVersion)
+		e.uint32(rpcVersion)
+		return e.b
+	case credFlavor != authNone && credFlavor != authSys:
+		e.uint32(rpcMsgDenied)
+		e.uint32(rpcAuthError)
+		e.uint32(rpcAuthBadCred)
+		return e.b
+	}
+	e.uint32(rpcMsgAccepted)
+	e.uint32(authNone) // verifier
+	e.uint32(0)
+	switch {
+	case prog != nfsProgram:
+		e.uint32(rpcProgUnavail)
+	case vers != nfsV4:
+		e.uint32(rpcProgMismatch)
+		e.uint32(nfsV4)
+		e.uint32(nfsV4)
+	case proc == nfs4ProcNull:
+		e.uint32(rpcSuccess)
+	case proc == nfs4ProcCompound:
+		start := len(e.b)
+		e.uint32(rpcSuccess)
+		if err := nc.s.compound(d, e); err != nil {
+			fs.Debugf(nc.what, "Bad COMPOUND: %v", err)
+			e.b = e.b[:start]
+			e.uint32(rpcGarbageArgs)
+		}
+	default:
+		e.uint32(rpcProcUnavail)
+	}
+	return e.b
+}
+
+// nfs4FH is a file handle along with the path it refers to
+type nfs4FH struct {
+	path   string
+	handle []byte
+}
+
+// compound holds the state of a COMPOUND while it is run
+type compound struct {
+	s       *nfs4Server
+	minor   uint32       // minor version
+	cfh     *nfs4FH      // current file handle or nil
+	sfh     *nfs4FH      // saved file handle or nil
+	cur     nfs4Stateid  // current stateid (4.1)
+	saved   nfs4Stateid  // saved stateid (4.1)
+	session *nfs4Session // session from SEQUENCE (4.1)
+	slot    *nfs4Slot    // slot from SEQUENCE (4.1)
+	cache   bool         // set if the reply should be cached in the slot
+	keep    bool         // set if the body of a failed result should be kept
+	replay  []byte       // reply to return instead of running the compound
+	owner   *nfs4Owner   // owner whose seqid the current operation uses (4.0)
+	seqid   uint32       // seqid the current operation uses (4.0)
+	cached  *nfs4Replay  // result to replay for a retransmitted operation (4.0)
+	ctx     context.Context
+}
+
+// opHandler decodes the arguments of an operation from d and writes
+// the result body to e
+type opHandler func(c *compound, d *xdrDecoder, e *xdrEncoder) error
+
+// handlers for each operation
+var opHandlers = map[uint32]opHandler{
+	opAccess:             (*compound).access,
+	opClose:              (*compound).close,
+	opCommit:             (*compound).commit,
+	opCreate:             (*compound).create,
+	opDelegPurge:         (*compound).notSupported,
+	opDelegReturn:        (*compound).delegReturn,
+	opGetattr:            (*compound).getattr,
+	opGetFH:              (*compound).getFH,
+	opLink:               (*compound).notSupported,
+	opLock:               (*compound).lock,
+	opLockT:              (*compound).lockT,
+	opLockU:              (*compound).lockU,
+	opLookup:             (*compound).lookup,
+	opLookupP:            (*compound).lookupP,
+	opNVerify:            (*compound).nverify,
+	opOpen:               (*compound).open,
+	opOpenAttr:           (*compound).notSupported,
+	opOpenConfirm:        (*compound).openConfirm,
+	opOpenDowngrade:      (*compound).openDowngrade,
+	opPutFH:              (*compound).putFH,
+	opPutPubFH:           (*compound).putRootFH,
+	opPutRootFH:          (*compound).putRootFH,
+	opRead:               (*compound).read,
+	opReaddir:            (*compound).readdir,
+	opReadlink:           (*compound).readlink,
+	opRemove:             (*compound).remove,
+	opRename:             (*compound).rename,
+	opRenew:              (*compound).renew,
+	opRestoreFH:          (*compound).restoreFH,
+	opSaveFH:             (*compound).saveFH,
+	opSecinfo:            (*compound).secinfo,
+	opSetattr:            (*compound).setattr,
+	opSetClientID:        (*compound).setClientID,
+	opSetClientIDConfirm: (*compound).setClientIDConfirm,
+	opVerify:             (*compound).verify,
+	opWrite:              (*compound).write,
+	opReleaseLockOwner:   (*compound).releaseLockOwner,
+	opBackchannelCtl:     (*compound).backchannelCtl,
+	opBindConnToSession:  (*compound).bindConnToSession,
+	opExchangeID:         (*compound).exchangeID,
+	opCreateSession:      (*compound).createSession,
+	opDestroySession:     (*compound).destroySession,
+	opFreeStateID:        (*compound).freeStateID,
+	opGetDirDelegation:   (*compound).notSupported,
+	opGetDeviceInfo:      (*compound).notSupported,
+	opGetDeviceList:      (*compound).notSupported,
+	opLayoutCommit:       (*compound).notSupported,
+	opLayoutGet:          (*compound).notSupported,
+	opLayoutReturn:       (*compound).notSupported,
+	opSecinfoNoName:      (*compound).secinfoNoName,
+	opSequence:           (*compound).sequence,
+	opSetSSV:             (*compound).notSupported,
+	opTestStateID:        (*compound).testStateID,
+	opWantDelegation:     (*compound).notSupported,
+	opDestroyClientID:    (*compound).destroyClientID,
+	opReclaimComplete:    (*compound).reclaimComplete,
+}
+
+// isV40Only returns true for the operations removed in NFSv4.1
+func isV40Only(op uint32) bool {
+	switch op {
+	case opOpenConfirm, opRenew, opSetClientID, opSetClientIDConfirm, opReleaseLockOwner:
+		return true
+	}
+	return false
+}
+
+// isSessionless returns true for the NFSv4.1 operations which may be
+// sent without a SEQUENCE
+func isSessionless(op uint32) bool {
+	switch op {
+	case opExchangeID, opCreateSession, opDestroySession, opBindConnToSession, opDestroyClientID:
+		return true
+	}
+	return false
+}
+
+// compound runs the COMPOUND procedure with arguments in d writing
+// the results to e
+//
+// It only returns an error if the arguments can't be decoded.
+func (s *nfs4Server) compound(d *xdrDecoder, e *xdrEncoder) error {
+	tag := d.opaque(nfs4MaxOpaque)
+	minor := d.uint32()
+	numOps := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	c := &compound{
+		s:     s,
+		minor: minor,
+		ctx:   s.ctx,
+	}
+	start := len(e.b)
+	status := nfs4OK
+	var results xdrEncoder
+	var n uint32
+	if minor > 1 {
+		status = nfs4ErrMinorVersMismatch
+	} else {
+		status, n = c.run(d, &results, numOps)
+	}
+	if c.replay != nil {
+		e.b = append(e.b, c.replay...)
+		return nil
+	}
+	e.uint32(uint32(status))
+	e.opaque(tag)
+	e.uint32(n)
+	e.b = append(e.b, results.b...)
+	c.finish(e.b[start:])
+	return nil
+}
+
+// run runs each operation in turn until one fails
+//
+// It returns the status of the last operation run and how many were
+// run.
+func (c *compound) run(d *xdrDecoder, e *xdrEncoder, numOps uint32) (status nfsStat4, n uint32) {
+	for i := uint32(0); i < numOps; i++ {
+		op := d.uint32()
+		if d.err != nil {
+			// arguments ran out before the operations did
+			e.uint32(opIllegal)
+			e.uint32(uint32(nfs4ErrBadXDR))
+			return nfs4ErrBadXDR, n + 1
+		}
+		status = c.check(op, i, numOps)
+		fn := opHandlers[op]
+		if status == nfs4OK && fn == nil {
+			status = nfs4ErrOpIllegal
+		}
+		if status == nfs4ErrOpIllegal {
+			op = opIllegal
+		}
+		e.uint32(op)
+		statusPos := len(e.b)
+		e.uint32(0)
+		bodyPos := len(e.b)
+		if status == nfs4OK {
+			c.keep, c.owner, c.cached = false, nil, nil
+			err := fn(c, d, e)
+			c.s.releaseLocks()
+			if c.replay != nil {
+				return nfs4OK, 0
+			}
+			if err == nil && d.err != nil {
+				err = nfs4ErrBadXDR
+			}
+			if c.cached != nil {
+				// retransmission of an operation using a seqid
+				e.b = append(e.b[:bodyPos], c.cached.body...)
+				if c.cached.fh != nil {
+					c.setFH(c.cached.fh)
+				}
+				status = c.cached.status
+			} else {
+				status = c.s.mapError(err)
+				if status != nfs4OK && !c.keep {
+					e.b = e.b[:bodyPos]
+				}
+				if status != nfs4OK {
+					fs.Debugf("nfs4", "Operation %d failed: %v (status %d)", op, err, status)
+				}
+				c.s.bumpSeqid(c, status, e.b[bodyPos:])
+			}
+		}
+		be.PutUint32(e.b[statusPos:], uint32(status))
+		n++
+		if status != nfs4OK {
+			break
+		}
+	}
+	return status, n
+}
+
+// check returns an error status if op can't be run as the index'th
+// of numOps operations in this minor version
+func (c *compound) check(op, index, numOps uint32) nfsStat4 {
+	if c.minor == 0 {
+		if op >= opBackchannelCtl && op <= opReclaimComplete {
+			return nfs4ErrOpIllegal
+		}
+		return nfs4OK
+	}
+	if isV40Only(op) {
+		return nfs4ErrNotSupp
+	}
+	if index == 0 {
+		if op == opSequence {
+			return nfs4OK
+		}
+		if isSessionless(op) {
+			if numOps != 1 {
+				return nfs4ErrNotOnlyOp
+			}
+			return nfs4OK
+		}
+		if _, found := opHandlers[op]; !found {
+			return nfs4ErrOpIllegal
+		}
+		return nfs4ErrOpNotInSession
+	}
+	if op == opSequence {
+		return nfs4ErrSequencePos
+	}
+	if c.session != nil && index >= c.session.maxOps {
+		return nfs4ErrTooManyOps
+	}
+	return nfs4OK
+}
+
+// finish releases the slot used by the compound caching the reply
+// if required
+func (c *compound) finish(reply []byte) {
+	if c.slot == nil {
+		return
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if c.cache {
+		c.slot.reply = append([]byte(nil), reply...)
+	}
+	c.slot.inUse = false
+}
+
+// setFH sets the current file handle
+func (c *compound) setFH(fh *nfs4FH) {
+	c.cfh = fh
+	c.cur = nfs4Stateid{}
+}
+
+// notSupported is used for the operations which aren't implemented
+func (c *compound) notSupported(d *xdrDecoder, e *xdrEncoder) error {
+	return nfs4ErrNotSupp
+}
+
+// mapError translates errors from the VFS into NFSv4 status codes
+func (s *nfs4Server) mapError(err error) nfsStat4 {
+	if err == nil {
+		return nfs4OK
+	}
+	var status nfsStat4
+	if errors.As(err, &status) {
+		return status
+	}
+	if errors.Is(err, errBadXDR) {
+		return nfs4ErrBadXDR
+	}
+	if errors.Is(err, os.ErrExist) {
+		return nfs4ErrExist
+	}
+	_, uErr := fserrors.Cause(err)
+	switch uErr {
+	case vfs.OK:
+		return nfs4OK
+	case vfs.ENOENT, fs.ErrorDirNotFound, fs.ErrorObjectNotFound:
+		return nfs4ErrNoEnt
+	case vfs.EEXIST, fs.ErrorDirExists:
+		return nfs4ErrExist
+	case vfs.EPERM:
+		return nfs4ErrPerm
+	case fs.ErrorPermissionDenied:
+		return nfs4ErrAccess
+	case vfs.ECLOSED:
+		return nfs4ErrBadStateID
+	case vfs.ENOTEMPTY:
+		return nfs4ErrNotEmpty
+	case vfs.ESPIPE:
+		return nfs4ErrInval
+	case vfs.EBADF:
+		return nfs4ErrOpenMode
+	case vfs.EROFS:
+		return nfs4ErrROFS
+	case vfs.ENOSYS, fs.ErrorNotImplemented, vfs.ENOTSUP:
+		return nfs4ErrNotSupp
+	case vfs.EINVAL:
+		return nfs4ErrInval
+	case vfs.ELOOP:
+		return nfs4ErrSymlink
+	case vfs.EAGAIN:
+		return nfs4ErrDelay
+	case vfs.ENOATTR:
+		return nfs4ErrAttrNotSupp
+	}
+	fs.Errorf("nfs4", "IO error: %v", err)
+	return nfs4ErrIO
+}
@@ -0,0 +1,359 @@
+//go:build unix
+
+package nfs
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rclone/rclone/vfs"
+)
+
+// File attributes
+const (
+	attrSupportedAttrs    = 0
+	attrType              = 1
+	attrFHExpireType      = 2
+	attrChange            = 3
+	attrSize              = 4
+	attrLinkSupport       = 5
+	attrSymlinkSupport    = 6
+	attrNamedAttr         = 7
+	attrFSID              = 8
+	attrUniqueHandles     = 9
+	attrLeaseTime         = 10
+	attrRdattrError       = 11
+	attrACLSupport        = 13
+	attrCanSetTime        = 15
+	attrCaseInsensitive   = 16
+	attrCasePreserving    = 17
+	attrChownRestricted   = 18
+	attrFilehandle        = 19
+	attrFileid            = 20
+	attrFilesAvail        = 21
+	attrFilesFree         = 22
+	attrFilesTotal        = 23
+	attrHomogeneous       = 26
+	attrMaxFileSize       = 27
+	attrMaxLink           = 28
+	attrMaxName           = 29
+	attrMaxRead           = 30
+	attrMaxWrite          = 31
+	attrMode              = 33
+	attrNoTrunc           = 34
+	attrNumLinks          = 35
+	attrOwner             = 36
+	attrOwnerGroup        = 37
+	attrRawDev            = 41
+	attrSpaceAvail        = 42
+	attrSpaceFree         = 43
+	attrSpaceTotal        = 44
+	attrSpaceUsed         = 45
+	attrTimeAccess        = 47
+	attrTimeAccessSet     = 48
+	attrTimeDelta         = 51
+	attrTimeMetadata      = 52
+	attrTimeModify        = 53
+	attrTimeModifySet     = 54
+	attrMountedOnFileid   = 55
+	attrSuppattrExclcreat = 75
+	attrWords             = 3 // words needed for the largest attribute
+	setTimeToServerTime   = 0
+	setTimeToClientTime   = 1
+	fileTypeReg           = 1 // NF4REG
+	fileTypeDir           = 2 // NF4DIR
+	fileTypeLnk           = 5 // NF4LNK
+	fhExpirePersistent    = 0
+	nfs4FilesAvail        = 1000000000 // there is no limit on the number of files
+	nfs4MaxFileSize       = math.MaxInt64
+)
+
+// makeBitmap makes a bitmap4 with the attrs set
+func makeBitmap(attrs ...int) []uint32 {
+	bitmap := make([]uint32, attrWords)
+	for _, attr := range attrs {
+		bitmap[attr/32] |= 1 << (attr % 32)
+	}
+	return bitmap
+}
+
+// isSet returns true if attr is set in bitmap
+func isSet(bitmap []uint32, attr int) bool {
+	return attr/32 < len(bitmap) && bitmap[attr/32]&(1<<(attr%32)) != 0
+}
+
+// settableAttrs are the attributes which SETATTR accepts
+var settableAttrs = makeBitmap(attrSize, attrMode, attrOwner, attrOwnerGroup, attrTimeAccessSet, attrTimeModifySet)
+
+// supportedAttrs are the attributes supported in NFSv4.0
+var supportedAttrs = makeBitmap(
+	attrSupportedAttrs, attrType, attrFHExpireType, attrChange, attrSize,
+	attrLinkSupport, attrSymlinkSupport, attrNamedAttr, attrFSID,
+	attrUniqueHandles, attrLeaseTime, attrRdattrError, attrACLSupport,
+	attrCanSetTime, attrCaseInsensitive, attrCasePreserving,
+	attrChownRestricted, attrFilehandle, attrFileid, attrFilesAvail,
+	attrFilesFree, attrFilesTotal, attrHomogeneous, attrMaxFileSize,
+	attrMaxLink, attrMaxName, attrMaxRead, attrMaxWrite, attrMode,
+	attrNoTrunc, attrNumLinks, attrOwner, attrOwnerGroup, attrRawDev,
+	attrSpaceAvail, attrSpaceFree, attrSpaceTotal, attrSpaceUsed,
+	attrTimeAccess, attrTimeAccessSet, attrTimeDelta, attrTimeMetadata,
+	attrTimeModify, attrTimeModifySet, attrMountedOnFileid,
+)
+
+// supportedAttrs41 are the attributes supported in NFSv4.1
+var supportedAttrs41 = func() []uint32 {
+	bitmap := append([]uint32(nil), supportedAttrs...)
+	bitmap[attrSuppattrExclcreat/32] |= 1 << (attrSuppattrExclcreat % 32)
+	return bitmap
+}()
+
+// supported returns the attributes supported by the minor version
+func (c *compound) supported() []uint32 {
+	if c.minor == 0 {
+		return supportedAttrs
+	}
+	return supportedAttrs41
+}
+
+// nfsTime writes an nfstime4
+func (e *xdrEncoder) nfsTime(t time.Time) {
+	e.uint64(uint64(t.Unix()))
+	e.uint32(uint32(t.Nanosecond()))
+}
+
+// nfsTime reads an nfstime4
+func (d *xdrDecoder) nfsTime() time.Time {
+	secs := int64(d.uint64())
+	nsecs := d.uint32()
+	if nsecs >= 1e9 {
+		d.err = nfs4ErrInval
+	}
+	return time.Unix(secs, int64(nsecs))
+}
+
+// change returns the change attribute for node
+func change(node vfs.Node) uint64 {
+	return uint64(node.ModTime().UnixNano()) + uint64(node.Size())
+}
+
+// changeOf returns the change attribute for the node at p or 0 if it
+// can't be found
+func (s *nfs4Server) changeOf(p string) uint64 {
+	node, err := s.vfs.Stat(p)
+	if err != nil {
+		return 0
+	}
+	return change(node)
+}
+
+// attrs writes the fattr4 with the attributes in request for node at
+// path p
+//
+// Attributes which aren't supported are left out.
+func (c *compound) attrs(e *xdrEncoder, p string, node vfs.Node, request []uint32) {
+	s := c.s
+	supported := c.supported()
+	var vals xdrEncoder
+	returned := make([]uint32, attrWords)
+	var statfsDone bool
+	var total, free int64
+	statfs := func() {
+		if !statfsDone {
+			total, _, free = s.vfs.Statfs()
+			statfsDone = true
+		}
+	}
+	for attr := 0; attr < attrWords*32; attr++ {
+		if !isSet(request, attr) || !isSet(supported, attr) {
+			continue
+		}
+		switch attr {
+		case attrSupportedAttrs:
+			vals.bitmap(supported)
+		case attrType:
+			switch {
+			case node.IsDir():
+				vals.uint32(fileTypeDir)
+			case node.Mode()&os.ModeSymlink != 0:
+				vals.uint32(fileTypeLnk)
+			default:
+				vals.uint32(fileTypeReg)
+			}
+		case attrFHExpireType:
+			vals.uint32(fhExpirePersistent)
+		case attrChange:
+			vals.uint64(change(node))
+		case attrSize:
+			vals.uint64(uint64(node.Size()))
+		case attrLinkSupport:
+			vals.bool(false)
+		case attrSymlinkSupport:
+			vals.bool(s.vfs.Opt.Links)
+		case attrNamedAttr:
+			vals.bool(false)
+		case attrFSID:
+			vals.uint64(1)
+			vals.uint64(0)
+		case attrUniqueHandles:
+			vals.bool(true)
+		case attrLeaseTime:
+			vals.uint32(nfs4LeaseTime)
+		case attrRdattrError:
+			vals.uint32(uint32(nfs4OK))
+		case attrACLSupport:
+			vals.uint32(0)
+		case attrCanSetTime:
+			vals.bool(true)
+		case attrCaseInsensitive:
+			vals.bool(false)
+		case attrCasePreserving:
+			vals.bool(true)
+		case attrChownRestricted:
+			vals.bool(true)
+		case attrFilehandle:
+			vals.opaque(s.fh(p).handle)
+		case attrFileid, attrMountedOnFileid:
+			vals.uint64(node.Inode())
+		case attrFilesAvail, attrFilesFree, attrFilesTotal:
+			vals.uint64(nfs4FilesAvail)
+		case attrHomogeneous:
+			vals.bool(true)
+		case attrMaxFileSize:
+			vals.uint64(nfs4MaxFileSize)
+		case attrMaxLink:
+			vals.uint32(1)
+		case attrMaxName:
+			vals.uint32(nfs4MaxName)
+		case attrMaxRead, attrMaxWrite:
+			vals.uint64(nfs4MaxIO)
+		case attrMode:
+			vals.uint32(uint32(node.Mode().Perm()))
+		case attrNoTrunc:
+			vals.bool(true)
+		case attrNumLinks:
+			vals.uint32(1)
+		case attrOwner:
+			vals.string(strconv.FormatUint(uint64(s.vfs.Opt.UID), 10))
+		case attrOwnerGroup:
+			vals.string(strconv.FormatUint(uint64(s.vfs.Opt.GID), 10))
+		case attrRawDev:
+			vals.uint32(0)
+			vals.uint32(0)
+		case attrSpaceAvail, attrSpaceFree:
+			statfs()
+			vals.uint64(uint64(max(free, 0)))
+		case attrSpaceTotal:
+			statfs()
+			vals.uint64(uint64(max(total, 0)))
+		case attrSpaceUsed:
+			vals.uint64(uint64(node.Size()))
+		case attrTimeAccess, attrTimeMetadata, attrTimeModify:
+			vals.nfsTime(node.ModTime())
+		case attrTimeDelta:
+			vals.nfsTime(time.Unix(0, 1))
+		case attrSuppattrExclcreat:
+			vals.bitmap(settableAttrs)
+		default:
+			// write only attributes
+			continue
+		}
+		returned[attr/32] |= 1 << (attr % 32)
+	}
+	e.bitmap(returned)
+	e.opaque(vals.b)
+}
+
+// nfs4SetAttrs are the attributes sent by SETATTR, CREATE and OPEN
+type nfs4SetAttrs struct {
+	mask     []uint32 // attributes present
+	hasSize  bool
+	size     uint64
+	setMtime bool
+	mtime    time.Time
+}
+
+// setAttrs reads a fattr4 of attributes to set
+func (d *xdrDecoder) setAttrs() (*nfs4SetAttrs, error) {
+	mask := d.bitmap()
+	vals := d.opaque(nfs4MaxRecord)
+	if d.err != nil {
+		return nil, d.err
+	}
+	a := &nfs4SetAttrs{mask: mask}
+	vd := &xdrDecoder{b: vals}
+	for attr := 0; attr < len(mask)*32; attr++ {
+		if !isSet(mask, attr) {
+			continue
+		}
+		if !isSet(settableAttrs, attr) {
+			if isSet(supportedAttrs41, attr) {
+				return nil, nfs4ErrInval
+			}
+			return nil, nfs4ErrAttrNotSupp
+		}
+		switch attr {
+		case attrSize:
+			a.hasSize = true
+			a.size = vd.uint64()
+		case attrMode:
+			_ = vd.uint32()
+		case attrOwner, attrOwnerGroup:
+			_ = vd.string(nfs4MaxOpaque)
+		case attrTimeAccessSet, attrTimeModifySet:
+			var t time.Time
+			switch vd.uint32() {
+			case setTimeToServerTime:
+				t = time.Now()
+			case setTimeToClientTime:
+				t = vd.nfsTime()
+			default:
+				return nil, nfs4ErrInval
+			}
+			if attr == attrTimeModifySet {
+				a.setMtime = true
+				a.mtime = t
+			}
+		}
+	}
+	if vd.err != nil {
+		return nil, vd.err
+	}
+	return a, nil
+}
+
+// apply sets the attributes on node
+//
+// It returns the attributes which were set.
+func (a *nfs4SetAttrs) apply(node vfs.Node) (attrset []uint32, err error) {
+	attrset = make([]uint32, attrWords)
+	set := func(attr int) {
+		attrset[attr/32] |= 1 << (attr % 32)
+	}
+	if a.hasSize {
+		if node.IsDir() {
+			return attrset, nfs4ErrIsDir
+		}
+		if a.size > math.MaxInt64 {
+			return attrset, nfs4ErrInval
+		}
+		if err := node.Truncate(int64(a.size)); err != nil {
+			return attrset, err
+		}
+		set(attrSize)
+	}
+	if a.setMtime {
+		if err := node.SetModTime(a.mtime); err != nil {
+			return attrset, err
+		}
+		set(attrTimeModifySet)
+	}
+	// These are accepted but can't be changed
+	for _, attr := range []int{attrMode, attrOwner, attrOwnerGroup, attrTimeAccessSet} {
+		if isSet(a.mask, attr) {
+			set(attr)
+		}
+	}
+	return attrset, nil
+}
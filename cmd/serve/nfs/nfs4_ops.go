@@ -0,0 +1,735 @@
+//go:build unix
+
+package nfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/vfs"
+)
+
+// ACCESS bits
+const (
+	accessRead    = 0x01
+	accessLookup  = 0x02
+	accessModify  = 0x04
+	accessExtend  = 0x08
+	accessDelete  = 0x10
+	accessExecute = 0x20
+	accessAll     = accessRead | accessLookup | accessModify | accessExtend | accessDelete | accessExecute
+)
+
+// Other operation arguments and results
+const (
+	fileSync        = 2    // FILE_SYNC4
+	maxPathLen      = 4096 // longest symlink target
+	readdirOverhead = 16   // size of READDIR4resok without any entries
+)
+
+// join returns the VFS path of name in dir
+func join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// parent returns the VFS path of the directory containing p
+func parent(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// splitPath splits a VFS path into the form used by the handle cache
+func splitPath(p string) []string {
+	if p == "" {
+		return []string{}
+	}
+	return strings.Split(p, "/")
+}
+
+// checkName checks name is a valid single path element
+func checkName(name string) error {
+	switch {
+	case name == "":
+		return nfs4ErrInval
+	case len(name) > nfs4MaxName:
+		return nfs4ErrNameTooLong
+	case name == "." || name == ".." || strings.ContainsAny(name, "/\x00"):
+		return nfs4ErrBadName
+	}
+	return nil
+}
+
+// fh returns the file handle for the VFS path p
+func (s *nfs4Server) fh(p string) *nfs4FH {
+	return &nfs4FH{
+		path:   p,
+		handle: s.h.ToHandle(s.h.billyFS, splitPath(p)),
+	}
+}
+
+// invalidate invalidates the file handle for p after it has been
+// removed or renamed
+func (s *nfs4Server) invalidate(p string) {
+	fh := s.fh(p)
+	_ = s.h.InvalidateHandle(s.h.billyFS, fh.handle)
+}
+
+// node returns the VFS node for the current file handle
+func (c *compound) node() (vfs.Node, error) {
+	if c.cfh == nil {
+		return nil, nfs4ErrNoFileHandle
+	}
+	node, err := c.s.vfs.Stat(c.cfh.path)
+	if errors.Is(err, vfs.ENOENT) {
+		return nil, nfs4ErrStale
+	}
+	return node, err
+}
+
+// dir returns the VFS directory for the current file handle
+func (c *compound) dir() (*vfs.Dir, error) {
+	node, err := c.node()
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return nil, nfs4ErrNotDir
+	}
+	return dir, nil
+}
+
+// file returns the VFS file for the current file handle
+func (c *compound) file() (*vfs.File, error) {
+	node, err := c.node()
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return nil, nfs4ErrIsDir
+	}
+	file, ok := node.(*vfs.File)
+	if !ok || file.IsSymlink() {
+		return nil, nfs4ErrInval
+	}
+	return file, nil
+}
+
+// changeInfo writes a change_info4
+func changeInfo(e *xdrEncoder, before, after uint64) {
+	e.bool(false) // not atomic
+	e.uint64(before)
+	e.uint64(after)
+}
+
+// secinfo writes the security flavors supported
+func secinfo(e *xdrEncoder) {
+	e.uint32(2)
+	e.uint32(authSys)
+	e.uint32(authNone)
+}
+
+// PUTFH object
+// PUTFH
+func (c *compound) putFH(d *xdrDecoder, e *xdrEncoder) error {
+	handle := d.opaque(nfs4MaxFH)
+	if d.err != nil {
+		return d.err
+	}
+	if len(handle) == 0 {
+		return nfs4ErrBadHandle
+	}
+	_, split, err := c.s.h.FromHandle(handle)
+	if err != nil {
+		return nfs4ErrStale
+	}
+	c.setFH(&nfs4FH{
+		path:   path.Join(split...),
+		handle: append([]byte(nil), handle...),
+	})
+	return nil
+}
+
+// PUTROOTFH
+// PUTROOTFH
+//
+// This is also used for PUTPUBFH. The root of the pseudo filesystem
+// is the root of the remote being served.
+func (c *compound) putRootFH(d *xdrDecoder, e *xdrEncoder) error {
+	c.setFH(c.s.fh(""))
+	return nil
+}
+
+// GETFH
+// GETFH object
+func (c *compound) getFH(d *xdrDecoder, e *xdrEncoder) error {
+	if c.cfh == nil {
+		return nfs4ErrNoFileHandle
+	}
+	e.opaque(c.cfh.handle)
+	return nil
+}
+
+// SAVEFH
+// SAVEFH
+func (c *compound) saveFH(d *xdrDecoder, e *xdrEncoder) error {
+	if c.cfh == nil {
+		return nfs4ErrNoFileHandle
+	}
+	c.sfh, c.saved = c.cfh, c.cur
+	return nil
+}
+
+// RESTOREFH
+// RESTOREFH
+func (c *compound) restoreFH(d *xdrDecoder, e *xdrEncoder) error {
+	if c.sfh == nil {
+		return nfs4ErrRestoreFH
+	}
+	c.cfh, c.cur = c.sfh, c.saved
+	return nil
+}
+
+// LOOKUP objname
+// LOOKUP
+func (c *compound) lookup(d *xdrDecoder, e *xdrEncoder) error {
+	name := d.string(nfs4MaxOpaque)
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.dir(); err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	p := join(c.cfh.path, name)
+	if _, err := c.s.vfs.Stat(p); err != nil {
+		return err
+	}
+	c.setFH(c.s.fh(p))
+	return nil
+}
+
+// LOOKUPP
+// LOOKUPP
+func (c *compound) lookupP(d *xdrDecoder, e *xdrEncoder) error {
+	if _, err := c.dir(); err != nil {
+		return err
+	}
+	if c.cfh.path == "" {
+		return nfs4ErrNoEnt
+	}
+	c.setFH(c.s.fh(parent(c.cfh.path)))
+	return nil
+}
+
+// GETATTR attr_request
+// GETATTR obj_attributes
+func (c *compound) getattr(d *xdrDecoder, e *xdrEncoder) error {
+	request := d.bitmap()
+	if d.err != nil {
+		return d.err
+	}
+	node, err := c.node()
+	if err != nil {
+		return err
+	}
+	c.attrs(e, c.cfh.path, node, request)
+	return nil
+}
+
+// sameAttrs reads the fattr4 of VERIFY and NVERIFY and returns
+// whether it matches the attributes of the current file
+func (c *compound) sameAttrs(d *xdrDecoder) (bool, error) {
+	mask := d.bitmap()
+	vals := d.opaque(nfs4MaxRecord)
+	if d.err != nil {
+		return false, d.err
+	}
+	node, err := c.node()
+	if err != nil {
+		return false, err
+	}
+	supported := c.supported()
+	for attr := 0; attr < len(mask)*32; attr++ {
+		if !isSet(mask, attr) {
+			continue
+		}
+		switch {
+		case attr == attrRdattrError || attr == attrTimeAccessSet || attr == attrTimeModifySet:
+			return false, nfs4ErrInval
+		case !isSet(supported, attr):
+			return false, nfs4ErrAttrNotSupp
+		}
+	}
+	var ours xdrEncoder
+	c.attrs(&ours, c.cfh.path, node, mask)
+	od := &xdrDecoder{b: ours.b}
+	_ = od.bitmap()
+	return bytes.Equal(od.opaque(nfs4MaxRecord), vals), nil
+}
+
+// VERIFY obj_attributes
+// VERIFY
+func (c *compound) verify(d *xdrDecoder, e *xdrEncoder) error {
+	same, err := c.sameAttrs(d)
+	if err == nil && !same {
+		err = nfs4ErrNotSame
+	}
+	return err
+}
+
+// NVERIFY obj_attributes
+// NVERIFY
+func (c *compound) nverify(d *xdrDecoder, e *xdrEncoder) error {
+	same, err := c.sameAttrs(d)
+	if err == nil && same {
+		err = nfs4ErrSame
+	}
+	return err
+}
+
+// SETATTR stateid obj_attributes
+// SETATTR attrsset
+func (c *compound) setattr(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.stateid()
+	attrs, err := d.setAttrs()
+	if err != nil {
+		return err
+	}
+	// attrsset is returned even on failure
+	attrset := make([]uint32, attrWords)
+	defer func() {
+		e.bitmap(attrset)
+		c.keep = true
+	}()
+	node, err := c.node()
+	if err != nil {
+		return err
+	}
+	if attrs.hasSize || attrs.setMtime {
+		if c.s.readOnly {
+			return nfs4ErrROFS
+		}
+	}
+	if attrs.hasSize && id != anonymousStateid && id != bypassStateid {
+		c.s.mu.Lock()
+		st, err := c.findState(id)
+		if err == nil && st.open != nil {
+			st = st.open
+		}
+		if err == nil && st.access&shareAccessWrite == 0 {
+			err = nfs4ErrOpenMode
+		}
+		c.s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	attrset, err = attrs.apply(node)
+	return err
+}
+
+// ACCESS access
+// ACCESS supported access
+func (c *compound) access(d *xdrDecoder, e *xdrEncoder) error {
+	access := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.node(); err != nil {
+		return err
+	}
+	allowed := uint32(accessAll)
+	if c.s.readOnly {
+		allowed &^= accessModify | accessExtend | accessDelete
+	}
+	e.uint32(accessAll)
+	e.uint32(access & allowed)
+	return nil
+}
+
+// READLINK
+// READLINK link
+func (c *compound) readlink(d *xdrDecoder, e *xdrEncoder) error {
+	node, err := c.node()
+	if err != nil {
+		return err
+	}
+	if node.Mode()&os.ModeSymlink == 0 {
+		return nfs4ErrInval
+	}
+	link, err := c.s.vfs.Readlink(c.cfh.path)
+	if err != nil {
+		return err
+	}
+	e.string(link)
+	return nil
+}
+
+// READ stateid offset count
+// READ eof data
+func (c *compound) read(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.stateid()
+	offset := d.uint64()
+	count := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	h, temporary, err := c.ioHandle(id, false)
+	if err != nil {
+		return err
+	}
+	if temporary {
+		defer func() {
+			_ = h.Close()
+		}()
+	}
+	if offset > math.MaxInt64 {
+		e.bool(true)
+		e.opaque(nil)
+		return nil
+	}
+	buf := make([]byte, min(count, nfs4MaxIO))
+	n, err := h.ReadAt(buf, int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	eof := err != nil || int64(offset)+int64(n) >= h.Node().Size()
+	e.bool(eof)
+	e.opaque(buf[:n])
+	return nil
+}
+
+// WRITE stateid offset stable data
+// WRITE count committed writeverf
+func (c *compound) write(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.stateid()
+	offset := d.uint64()
+	_ = d.uint32() // stable - all writes are treated as FILE_SYNC
+	data := d.opaque(nfs4MaxIO)
+	if d.err != nil {
+		return d.err
+	}
+	if offset > math.MaxInt64 {
+		return nfs4ErrInval
+	}
+	h, temporary, err := c.ioHandle(id, true)
+	if err != nil {
+		return err
+	}
+	if temporary {
+		defer func() {
+			_ = h.Close()
+		}()
+	}
+	n, err := h.WriteAt(data, int64(offset))
+	if err != nil {
+		return err
+	}
+	e.uint32(uint32(n))
+	e.uint32(fileSync)
+	e.fixed(c.s.verifier[:])
+	return nil
+}
+
+// COMMIT offset count
+// COMMIT writeverf
+func (c *compound) commit(d *xdrDecoder, e *xdrEncoder) error {
+	_ = d.uint64()
+	_ = d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.file(); err != nil {
+		return err
+	}
+	e.fixed(c.s.verifier[:])
+	return nil
+}
+
+// CREATE objtype objname createattrs
+// CREATE cinfo attrset
+func (c *compound) create(d *xdrDecoder, e *xdrEncoder) error {
+	typ := d.uint32()
+	var link string
+	switch typ {
+	case fileTypeLnk:
+		link = d.string(maxPathLen)
+	case 3, 4: // block and character devices
+		_ = d.uint32()
+		_ = d.uint32()
+	}
+	name := d.string(nfs4MaxOpaque)
+	attrs, err := d.setAttrs()
+	if err != nil {
+		return err
+	}
+	dir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if c.s.readOnly {
+		return nfs4ErrROFS
+	}
+	p := join(c.cfh.path, name)
+	if _, err := c.s.vfs.Stat(p); err == nil {
+		return nfs4ErrExist
+	}
+	before := change(dir)
+	switch typ {
+	case fileTypeDir:
+		_, err = dir.Mkdir(name)
+	case fileTypeLnk:
+		err = c.s.vfs.Symlink(link, p)
+	default:
+		return nfs4ErrBadType
+	}
+	if err != nil {
+		return err
+	}
+	node, err := c.s.vfs.Stat(p)
+	if err != nil {
+		return err
+	}
+	attrs.hasSize = false
+	attrset, err := attrs.apply(node)
+	if err != nil {
+		return err
+	}
+	changeInfo(e, before, change(dir))
+	e.bitmap(attrset)
+	c.setFH(c.s.fh(p))
+	return nil
+}
+
+// REMOVE target
+// REMOVE cinfo
+func (c *compound) remove(d *xdrDecoder, e *xdrEncoder) error {
+	name := d.string(nfs4MaxOpaque)
+	if d.err != nil {
+		return d.err
+	}
+	dir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if c.s.readOnly {
+		return nfs4ErrROFS
+	}
+	p := join(c.cfh.path, name)
+	node, err := c.s.vfs.Stat(p)
+	if err != nil {
+		return err
+	}
+	before := change(dir)
+	if err := node.Remove(); err != nil {
+		return err
+	}
+	c.s.invalidate(p)
+	changeInfo(e, before, change(dir))
+	return nil
+}
+
+// RENAME oldname newname
+// RENAME source_cinfo target_cinfo
+//
+// The saved file handle is the source directory and the current file
+// handle is the target directory.
+func (c *compound) rename(d *xdrDecoder, e *xdrEncoder) error {
+	oldName := d.string(nfs4MaxOpaque)
+	newName := d.string(nfs4MaxOpaque)
+	if d.err != nil {
+		return d.err
+	}
+	if c.sfh == nil {
+		return nfs4ErrNoFileHandle
+	}
+	dstDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	srcNode, err := c.s.vfs.Stat(c.sfh.path)
+	if err != nil {
+		return nfs4ErrStale
+	}
+	srcDir, ok := srcNode.(*vfs.Dir)
+	if !ok {
+		return nfs4ErrNotDir
+	}
+	if err := checkName(oldName); err != nil {
+		return err
+	}
+	if err := checkName(newName); err != nil {
+		return err
+	}
+	if c.s.readOnly {
+		return nfs4ErrROFS
+	}
+	oldPath := join(c.sfh.path, oldName)
+	newPath := join(c.cfh.path, newName)
+	srcBefore, dstBefore := change(srcDir), change(dstDir)
+	if oldPath != newPath {
+		if err := c.s.vfs.Rename(oldPath, newPath); err != nil {
+			return err
+		}
+		c.s.invalidate(oldPath)
+	}
+	changeInfo(e, srcBefore, change(srcDir))
+	changeInfo(e, dstBefore, change(dstDir))
+	return nil
+}
+
+// nfs4Dir is a snapshot of a directory listing used by READDIR
+type nfs4Dir struct {
+	path    string
+	entries vfs.Nodes
+}
+
+// addDir stores a snapshot of the entries of the directory at p
+// returning its cookie verifier
+func (s *nfs4Server) addDir(p string, entries vfs.Nodes) uint64 {
+	verifier := s.nextID()
+	s.dirMu.Lock()
+	defer s.dirMu.Unlock()
+	s.dirs[verifier] = nfs4Dir{path: p, entries: entries}
+	s.dirOrder = append(s.dirOrder, verifier)
+	if len(s.dirOrder) > nfs4MaxReaddir {
+		delete(s.dirs, s.dirOrder[0])
+		s.dirOrder = s.dirOrder[1:]
+	}
+	return verifier
+}
+
+// getDir returns the snapshot of the directory at p with verifier or
+// nil if it isn't found
+func (s *nfs4Server) getDir(p string, verifier uint64) vfs.Nodes {
+	s.dirMu.Lock()
+	defer s.dirMu.Unlock()
+	dir, found := s.dirs[verifier]
+	if !found || dir.path != p {
+		return nil
+	}
+	return dir.entries
+}
+
+// READDIR cookie cookieverf dircount maxcount attr_request
+// READDIR cookieverf reply[entries eof]
+//
+// The cookie of an entry is its index in the listing plus 3 as 1 and
+// 2 are reserved.
+func (c *compound) readdir(d *xdrDecoder, e *xdrEncoder) error {
+	cookie := d.uint64()
+	verf := d.fixed(8)
+	_ = d.uint32() // dircount
+	maxCount := d.uint32()
+	request := d.bitmap()
+	if d.err != nil {
+		return d.err
+	}
+	dir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	if cookie == 1 || cookie == 2 {
+		return nfs4ErrBadCookie
+	}
+	var verifier uint64
+	var entries vfs.Nodes
+	if cookie != 0 {
+		verifier = be.Uint64(verf)
+		entries = c.s.getDir(c.cfh.path, verifier)
+	}
+	if entries == nil {
+		// new listing or the snapshot has been discarded
+		entries, err = dir.ReadDirAll()
+		if err != nil {
+			return err
+		}
+		verifier = c.s.addDir(c.cfh.path, entries)
+	}
+	start := 0
+	if cookie != 0 {
+		start = int(min(cookie-2, uint64(len(entries))))
+	}
+	e.uint64(verifier)
+	size := readdirOverhead
+	eof := true
+	var entry xdrEncoder
+	for i := start; i < len(entries); i++ {
+		node := entries[i]
+		entry.b = entry.b[:0]
+		entry.bool(true)
+		entry.uint64(uint64(i + 3))
+		entry.string(node.Name())
+		c.attrs(&entry, join(c.cfh.path, node.Name()), node, request)
+		if size+len(entry.b) > int(maxCount) {
+			if i == start {
+				return nfs4ErrTooSmall
+			}
+			eof = false
+			break
+		}
+		size += len(entry.b)
+		e.b = append(e.b, entry.b...)
+	}
+	e.bool(false)
+	e.bool(eof)
+	return nil
+}
+
+// SECINFO name
+// SECINFO flavors<>
+func (c *compound) secinfo(d *xdrDecoder, e *xdrEncoder) error {
+	name := d.string(nfs4MaxOpaque)
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.dir(); err != nil {
+		return err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, err := c.s.vfs.Stat(join(c.cfh.path, name)); err != nil {
+		return err
+	}
+	secinfo(e)
+	if c.minor != 0 {
+		c.setFH(nil)
+	}
+	return nil
+}
+
+// SECINFO_NO_NAME style
+// SECINFO_NO_NAME flavors<>
+func (c *compound) secinfoNoName(d *xdrDecoder, e *xdrEncoder) error {
+	_ = d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	if c.cfh == nil {
+		return nfs4ErrNoFileHandle
+	}
+	secinfo(e)
+	c.setFH(nil)
+	return nil
+}
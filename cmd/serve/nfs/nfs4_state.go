@@ -0,0 +1,1533 @@
+//go:build unix
+
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// OPEN arguments and results
+const (
+	shareAccessRead   = 1
+	shareAccessWrite  = 2
+	shareAccessBoth   = 3
+	shareDenyBoth     = 3
+	openNoCreate      = 0
+	openCreate        = 1
+	createUnchecked   = 0
+	createGuarded     = 1
+	createExclusive   = 2
+	createExclusive4  = 3 // EXCLUSIVE4_1
+	claimNull         = 0
+	claimPrevious     = 1
+	claimFH           = 4
+	openLocktypePOSIX = 4 // OPEN4_RESULT_LOCKTYPE_POSIX
+	openDelegateNone  = 0
+)
+
+// LOCK types
+const (
+	lockRead   = 1 // READ_LT
+	lockWrite  = 2 // WRITE_LT
+	lockReadW  = 3 // READW_LT
+	lockWriteW = 4 // WRITEW_LT
+)
+
+// EXCHANGE_ID, CREATE_SESSION and BIND_CONN_TO_SESSION constants
+const (
+	exchgidUseNonPNFS = 0x00010000
+	exchgidConfirmedR = 0x80000000
+	sp4None           = 0
+	sp4MachCred       = 1
+	cdfs4Fore         = 1
+	openOwnerPrefix   = "o"
+	lockOwnerPrefix   = "l"
+)
+
+// expiryInterval is how often to look for expired clients
+var expiryInterval = 10 * time.Second
+
+// nfs4Stateid identifies open or lock state
+type nfs4Stateid struct {
+	seqid uint32
+	other [12]byte
+}
+
+// Special stateids
+var (
+	anonymousStateid = nfs4Stateid{}
+	bypassStateid    = nfs4Stateid{seqid: math.MaxUint32, other: [12]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}}
+	currentStateid   = nfs4Stateid{seqid: 1}
+	invalidStateid   = nfs4Stateid{seqid: math.MaxUint32}
+)
+
+// stateid reads a stateid4
+func (d *xdrDecoder) stateid() (id nfs4Stateid) {
+	id.seqid = d.uint32()
+	copy(id.other[:], d.fixed(12))
+	return id
+}
+
+// stateid writes a stateid4
+func (e *xdrEncoder) stateid(id nfs4Stateid) {
+	e.uint32(id.seqid)
+	e.fixed(id.other[:])
+}
+
+// nfs4Client is a client which has identified itself with
+// SETCLIENTID or EXCHANGE_ID
+type nfs4Client struct {
+	id        uint64
+	minor     uint32
+	owner     string  // the client's identifier for itself
+	verifier  [8]byte // changes when the client reboots
+	confirm   [8]byte // verifier for SETCLIENTID_CONFIRM (4.0)
+	confirmed bool
+	seq       uint32 // sequence id expected by CREATE_SESSION (4.1)
+	csReply   []byte // reply to the last CREATE_SESSION (4.1)
+	renewed   time.Time
+	sessions  map[[16]byte]*nfs4Session
+	owners    map[string]*nfs4Owner
+	states    map[[12]byte]*nfs4State
+}
+
+// nfs4Owner is an open owner or a lock owner
+type nfs4Owner struct {
+	client  *nfs4Client
+	key     string
+	vfsLock uint64      // owner of VFS locks (lock owners only)
+	started bool        // set once seqid is valid (4.0)
+	seqid   uint32      // last seqid used (4.0)
+	last    *nfs4Replay // result of the last operation (4.0)
+}
+
+// nfs4Replay is the result of an operation kept to reply to
+// retransmissions
+type nfs4Replay struct {
+	status nfsStat4
+	body   []byte
+	fh     *nfs4FH
+}
+
+// nfs4State is the state for an open file or for the locks held on it
+type nfs4State struct {
+	id     nfs4Stateid
+	owner  *nfs4Owner
+	file   *vfs.File
+	handle vfs.Handle   // open handle (open states only)
+	access uint32       // share access (open states only)
+	deny   uint32       // share deny (open states only)
+	locks  []*nfs4State // lock states using this open (open states only)
+	open   *nfs4State   // the open state (lock states only)
+}
+
+// nfs4Session is an NFSv4.1 session
+type nfs4Session struct {
+	id     [16]byte
+	client *nfs4Client
+	maxOps uint32
+	slots  []nfs4Slot
+}
+
+// nfs4Slot is a slot in the session's reply cache
+type nfs4Slot struct {
+	seq   uint32
+	inUse bool
+	reply []byte
+}
+
+// newClient makes a new client
+//
+// Call with s.mu held
+func (s *nfs4Server) newClient(minor uint32, owner string, verifier []byte) *nfs4Client {
+	client := &nfs4Client{
+		id:       s.nextID(),
+		minor:    minor,
+		owner:    owner,
+		seq:      1,
+		renewed:  time.Now(),
+		sessions: make(map[[16]byte]*nfs4Session),
+		owners:   make(map[string]*nfs4Owner),
+		states:   make(map[[12]byte]*nfs4State),
+	}
+	copy(client.verifier[:], verifier)
+	s.clients[client.id] = client
+	return client
+}
+
+// getOwner finds or makes the owner with key for client
+//
+// Call with s.mu held
+func (client *nfs4Client) getOwner(key string) *nfs4Owner {
+	o := client.owners[key]
+	if o == nil {
+		o = &nfs4Owner{client: client, key: key}
+		if key[:1] == lockOwnerPrefix {
+			o.vfsLock = client.vfsLockOwner(key[1:])
+		}
+		client.owners[key] = o
+	}
+	return o
+}
+
+// vfsLockOwner returns the owner of the VFS locks taken by the lock
+// owner called name
+func (client *nfs4Client) vfsLockOwner(name string) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], client.id)
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(name))
+	return h.Sum64()
+}
+
+// newState makes a new state for owner on file
+//
+// Call with s.mu held
+func (s *nfs4Server) newState(owner *nfs4Owner, file *vfs.File) *nfs4State {
+	st := &nfs4State{
+		owner: owner,
+		file:  file,
+	}
+	binary.BigEndian.PutUint32(st.id.other[:4], s.boot)
+	binary.BigEndian.PutUint64(st.id.other[4:], s.counter.Add(1))
+	return st
+}
+
+// addState makes st findable by its stateid
+//
+// Call with s.mu held
+func (s *nfs4Server) addState(st *nfs4State) {
+	s.states[st.id.other] = st
+	st.owner.client.states[st.id.other] = st
+	if st.open != nil {
+		st.open.locks = append(st.open.locks, st)
+	} else {
+		s.opens[st.file] = append(s.opens[st.file], st)
+	}
+}
+
+// nfs4Unlock is the VFS locks of a removed state to release
+type nfs4Unlock struct {
+	file  *vfs.File
+	owner uint64
+}
+
+// queueUnlock arranges for the VFS locks held by owner on file to be
+// released by releaseLocks
+//
+// Call with s.mu held
+func (s *nfs4Server) queueUnlock(file *vfs.File, owner uint64) {
+	s.pendingMu.Lock()
+	s.pending = append(s.pending, nfs4Unlock{file: file, owner: owner})
+	s.pendingMu.Unlock()
+}
+
+// releaseLocks releases the VFS locks of the states which have been
+// removed.
+//
+// This isn't done with s.mu held as releasing a lock may need remote
+// I/O with --vfs-lock-remote so call it after s.mu is unlocked.
+func (s *nfs4Server) releaseLocks() {
+	s.unlockMu.Lock()
+	defer s.unlockMu.Unlock()
+	s.pendingMu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.pendingMu.Unlock()
+	for _, u := range pending {
+		err := u.file.Unlock(s.ctx, u.owner)
+		if err != nil {
+			fs.Errorf(u.file, "Failed to release lock: %v", err)
+		}
+	}
+}
+
+// removeState releases the state st and queues the release of any
+// locks it holds
+//
+// Call with s.mu held then call releaseLocks without it
+func (s *nfs4Server) removeState(st *nfs4State) (err error) {
+	delete(s.states, st.id.other)
+	delete(st.owner.client.states, st.id.other)
+	if st.open != nil {
+		s.queueUnlock(st.file, st.owner.vfsLock)
+		locks := st.open.locks
+		for i, lockState := range locks {
+			if lockState == st {
+				st.open.locks = append(locks[:i:i], locks[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	for _, lockState := range st.locks {
+		lockState.open = nil
+		delete(s.states, lockState.id.other)
+		delete(lockState.owner.client.states, lockState.id.other)
+		s.queueUnlock(st.file, lockState.owner.vfsLock)
+	}
+	st.locks = nil
+	opens := s.opens[st.file]
+	for i, openState := range opens {
+		if openState == st {
+			opens = append(opens[:i:i], opens[i+1:]...)
+			break
+		}
+	}
+	if len(opens) == 0 {
+		delete(s.opens, st.file)
+		delete(s.exclusive, st.file.Path())
+	} else {
+		s.opens[st.file] = opens
+	}
+	if st.handle != nil {
+		err = st.handle.Close()
+		st.handle = nil
+	}
+	return err
+}
+
+// expireClient throws away client and all its state
+//
+// Call with s.mu held
+func (s *nfs4Server) expireClient(client *nfs4Client) {
+	fs.Debugf("nfs4", "Expiring client %x", client.id)
+	for _, st := range client.states {
+		if st.open == nil {
+			err := s.removeState(st)
+			if err != nil {
+				fs.Errorf(st.file, "Failed to close file: %v", err)
+			}
+		}
+	}
+	for _, session := range client.sessions {
+		delete(s.sessions, session.id)
+	}
+	delete(s.clients, client.id)
+}
+
+// expireClients throws away the clients which haven't renewed their
+// lease for a while
+func (s *nfs4Server) expireClients() {
+	s.mu.Lock()
+	now := time.Now()
+	for _, client := range s.clients {
+		if now.Sub(client.renewed) > 2*nfs4LeaseTime*time.Second {
+			s.expireClient(client)
+		}
+	}
+	s.mu.Unlock()
+	s.releaseLocks()
+}
+
+// expirer looks for expired clients every expiryInterval until
+// the server is shut down so their locks are released even if no
+// requests arrive
+func (s *nfs4Server) expirer() {
+	defer s.expirerWg.Done()
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.expireClients()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// expireOthers throws away the other clients with the same owner as
+// client after client has been confirmed
+//
+// Call with s.mu held
+func (s *nfs4Server) expireOthers(client *nfs4Client) {
+	for _, other := range s.clients {
+		if other != client && other.minor == client.minor && other.owner == client.owner {
+			s.expireClient(other)
+		}
+	}
+}
+
+// getClient returns the client for clientID renewing its lease
+//
+// In 4.1 the client of the session is used instead.
+//
+// Call with s.mu held
+func (c *compound) getClient(clientID uint64) (*nfs4Client, error) {
+	if c.minor != 0 {
+		c.session.client.renewed = time.Now()
+		return c.session.client, nil
+	}
+	client := c.s.clients[clientID]
+	if client == nil || !client.confirmed {
+		return nil, nfs4ErrStaleClientID
+	}
+	client.renewed = time.Now()
+	return client, nil
+}
+
+// findState returns the state for id renewing the lease of its client
+//
+// Call with s.mu held
+func (c *compound) findState(id nfs4Stateid) (*nfs4State, error) {
+	if c.minor != 0 && id == currentStateid {
+		id = c.cur
+	}
+	st := c.s.states[id.other]
+	if st == nil {
+		if c.minor == 0 && binary.BigEndian.Uint32(id.other[:4]) != c.s.boot {
+			return nil, nfs4ErrStaleStateID
+		}
+		return nil, nfs4ErrBadStateID
+	}
+	if c.minor != 0 && st.owner.client != c.session.client {
+		return nil, nfs4ErrBadStateID
+	}
+	if id.seqid != st.id.seqid && (c.minor == 0 || id.seqid != 0) {
+		if id.seqid > st.id.seqid {
+			return nil, nfs4ErrBadStateID
+		}
+		return nil, nfs4ErrOldStateID
+	}
+	st.owner.client.renewed = time.Now()
+	return st, nil
+}
+
+// checkSeqid checks the seqid of an operation by owner (4.0)
+//
+// If the operation is a retransmission c.cached is set to its
+// result.
+//
+// Call with s.mu held
+func (c *compound) checkSeqid(o *nfs4Owner, seqid uint32) error {
+	if c.minor != 0 {
+		return nil
+	}
+	if o.started {
+		if seqid == o.seqid && o.last != nil {
+			c.cached = o.last
+			return nil
+		}
+		if seqid != o.seqid+1 {
+			return nfs4ErrBadSeqid
+		}
+	}
+	c.owner, c.seqid = o, seqid
+	return nil
+}
+
+// bumpSeqid records the result of an operation which used a seqid
+// (4.0)
+func (s *nfs4Server) bumpSeqid(c *compound, status nfsStat4, body []byte) {
+	if c.owner == nil {
+		return
+	}
+	switch status {
+	case nfs4ErrStaleClientID, nfs4ErrStaleStateID, nfs4ErrBadStateID, nfs4ErrBadSeqid,
+		nfs4ErrBadXDR, nfs4ErrResource, nfs4ErrNoFileHandle, nfs4ErrMoved:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.owner.started = true
+	c.owner.seqid = c.seqid
+	c.owner.last = &nfs4Replay{
+		status: status,
+		body:   append([]byte(nil), body...),
+		fh:     c.cfh,
+	}
+}
+
+// ioHandle returns a handle to do I/O on the current file with
+//
+// If the stateid is a special stateid or its open doesn't allow
+// reading then a temporary handle is opened which must be closed by
+// the caller.
+func (c *compound) ioHandle(id nfs4Stateid, write bool) (h vfs.Handle, temporary bool, err error) {
+	file, err := c.file()
+	if err != nil {
+		return nil, false, err
+	}
+	if id != anonymousStateid && id != bypassStateid {
+		c.s.mu.Lock()
+		st, err := c.findState(id)
+		if err == nil && st.open != nil {
+			st = st.open
+		}
+		c.s.mu.Unlock()
+		if err != nil {
+			return nil, false, err
+		}
+		if st.file != file {
+			return nil, false, nfs4ErrBadStateID
+		}
+		if write && st.access&shareAccessWrite == 0 {
+			return nil, false, nfs4ErrOpenMode
+		}
+		if write || st.access&shareAccessRead != 0 {
+			return st.handle, false, nil
+		}
+	}
+	if write && c.s.readOnly {
+		return nil, false, nfs4ErrROFS
+	}
+	flags := os.O_RDONLY
+	if write {
+		flags = os.O_WRONLY
+	}
+	h, err = file.Open(flags)
+	if err != nil {
+		return nil, false, err
+	}
+	return h, true, nil
+}
+
+// accessFlags returns the open flags for share access
+func accessFlags(access uint32) int {
+	switch access {
+	case shareAccessWrite:
+		return os.O_WRONLY
+	case shareAccessBoth:
+		return os.O_RDWR
+	}
+	return os.O_RDONLY
+}
+
+// vfsLock converts an NFSv4 lock type and range into a vfs.Lock
+func vfsLock(lockType uint32, offset, length uint64) (lk vfs.Lock, err error) {
+	switch lockType {
+	case lockRead, lockReadW:
+		lk.Type = vfs.LockRead
+	case lockWrite, lockWriteW:
+		lk.Type = vfs.LockWrite
+	default:
+		return lk, nfs4ErrInval
+	}
+	if length == 0 || (length != math.MaxUint64 && length > math.MaxUint64-offset) || offset > math.MaxInt64 {
+		return lk, nfs4ErrInval
+	}
+	lk.Start = int64(offset)
+	if length == math.MaxUint64 || length-1 > uint64(math.MaxInt64-lk.Start) {
+		lk.End = vfs.LockEOF
+	} else {
+		lk.End = lk.Start + int64(length-1)
+	}
+	return lk, nil
+}
+
+// lockDenied writes a LOCK4denied for the conflicting lock
+func (c *compound) lockDenied(e *xdrEncoder, conflict *vfs.Lock) error {
+	length := uint64(math.MaxUint64)
+	if conflict.End != vfs.LockEOF {
+		length = uint64(conflict.End-conflict.Start) + 1
+	}
+	lockType := uint32(lockWrite)
+	if conflict.Type == vfs.LockRead {
+		lockType = lockRead
+	}
+	e.uint64(uint64(conflict.Start))
+	e.uint64(length)
+	e.uint32(lockType)
+	e.uint64(0) // owner isn't known
+	e.opaque(nil)
+	c.keep = true
+	return nfs4ErrDenied
+}
+
+// SETCLIENTID client[verifier owner] callback[program netid addr] callback_ident
+// SETCLIENTID clientid confirm
+func (c *compound) setClientID(d *xdrDecoder, e *xdrEncoder) error {
+	verifier := d.fixed(8)
+	owner := d.string(nfs4MaxOpaque)
+	_ = d.uint32()              // callback program
+	_ = d.string(nfs4MaxOpaque) // netid
+	_ = d.string(nfs4MaxOpaque) // address
+	_ = d.uint32()              // callback ident
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var client *nfs4Client
+	for _, other := range s.clients {
+		if other.minor != 0 || other.owner != owner {
+			continue
+		}
+		if other.confirmed && string(other.verifier[:]) == string(verifier) {
+			client = other
+		} else if !other.confirmed {
+			s.expireClient(other)
+		}
+	}
+	if client == nil {
+		client = s.newClient(0, owner, verifier)
+	}
+	binary.BigEndian.PutUint64(client.confirm[:], s.nextID())
+	e.uint64(client.id)
+	e.fixed(client.confirm[:])
+	return nil
+}
+
+// SETCLIENTID_CONFIRM clientid confirm
+// SETCLIENTID_CONFIRM
+func (c *compound) setClientIDConfirm(d *xdrDecoder, e *xdrEncoder) error {
+	clientID := d.uint64()
+	confirm := d.fixed(8)
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.clients[clientID]
+	if client == nil || string(client.confirm[:]) != string(confirm) {
+		return nfs4ErrStaleClientID
+	}
+	client.confirmed = true
+	client.renewed = time.Now()
+	s.expireOthers(client)
+	return nil
+}
+
+// RENEW clientid
+// RENEW
+func (c *compound) renew(d *xdrDecoder, e *xdrEncoder) error {
+	clientID := d.uint64()
+	if d.err != nil {
+		return d.err
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	_, err := c.getClient(clientID)
+	return err
+}
+
+// EXCHANGE_ID clientowner[verifier owner] flags state_protect impl_id<1>
+// EXCHANGE_ID clientid sequenceid flags state_protect server_owner server_scope impl_id<1>
+func (c *compound) exchangeID(d *xdrDecoder, e *xdrEncoder) error {
+	verifier := d.fixed(8)
+	owner := d.string(nfs4MaxOpaque)
+	_ = d.uint32() // flags
+	switch d.uint32() {
+	case sp4None:
+	case sp4MachCred:
+		_ = d.bitmap() // must enforce
+		_ = d.bitmap() // must allow
+	default:
+		if d.err == nil {
+			return nfs4ErrEncrAlgUnsupp
+		}
+	}
+	for range d.length(1) {
+		_ = d.string(nfs4MaxOpaque) // domain
+		_ = d.string(nfs4MaxOpaque) // name
+		_ = d.uint64()              // date
+		_ = d.uint32()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var client *nfs4Client
+	for _, other := range s.clients {
+		if other.minor != 1 || other.owner != owner {
+			continue
+		}
+		if string(other.verifier[:]) == string(verifier) {
+			client = other
+		} else if !other.confirmed {
+			// confirmed clients are replaced when the new
+			// client is confirmed by CREATE_SESSION
+			s.expireClient(other)
+		}
+	}
+	if client == nil {
+		client = s.newClient(1, owner, verifier)
+	}
+	client.renewed = time.Now()
+	flags := uint32(exchgidUseNonPNFS)
+	if client.confirmed {
+		flags |= exchgidConfirmedR
+	}
+	e.uint64(client.id)
+	e.uint32(client.seq)
+	e.uint32(flags)
+	e.uint32(sp4None)
+	e.uint64(0) // server owner minor id
+	e.opaque(s.serverOwner())
+	e.opaque(s.serverOwner()) // server scope
+	e.uint32(0)               // no implementation id
+	return nil
+}
+
+// serverOwner returns an ID unique to this server
+//
+// Clients use it to tell whether two addresses reach the same server.
+func (s *nfs4Server) serverOwner() []byte {
+	return fmt.Appendf(nil, "rclone-%x", s.verifier)
+}
+
+// channelAttrs are the attributes of a session channel
+type channelAttrs struct {
+	headerPad   uint32
+	maxRequest  uint32
+	maxResponse uint32
+	maxCached   uint32
+	maxOps      uint32
+	maxRequests uint32
+	rdmaIRD     []uint32
+}
+
+// channelAttrs reads a channel_attrs4
+func (d *xdrDecoder) channelAttrs() (a channelAttrs) {
+	a.headerPad = d.uint32()
+	a.maxRequest = d.uint32()
+	a.maxResponse = d.uint32()
+	a.maxCached = d.uint32()
+	a.maxOps = d.uint32()
+	a.maxRequests = d.uint32()
+	for range d.length(1) {
+		a.rdmaIRD = append(a.rdmaIRD, d.uint32())
+	}
+	return a
+}
+
+// channelAttrs writes a channel_attrs4
+func (e *xdrEncoder) channelAttrs(a channelAttrs) {
+	e.uint32(a.headerPad)
+	e.uint32(a.maxRequest)
+	e.uint32(a.maxResponse)
+	e.uint32(a.maxCached)
+	e.uint32(a.maxOps)
+	e.uint32(a.maxRequests)
+	e.uint32(0) // no RDMA
+}
+
+// negotiate limits the channel attributes a to what the server supports
+func (a channelAttrs) negotiate() channelAttrs {
+	a.headerPad = 0
+	a.maxRequest = min(a.maxRequest, nfs4MaxRecord)
+	a.maxResponse = min(a.maxResponse, nfs4MaxRecord)
+	a.maxCached = min(a.maxCached, nfs4MaxRecord)
+	a.maxOps = min(a.maxOps, nfs4MaxOps)
+	a.maxRequests = max(min(a.maxRequests, nfs4MaxSlots), 1)
+	return a
+}
+
+// secParms reads the callback_sec_parms4 array
+func (d *xdrDecoder) secParms() {
+	for range d.length(16) {
+		switch d.uint32() {
+		case authNone:
+		case authSys:
+			_ = d.uint32()    // stamp
+			_ = d.string(255) // machine name
+			_ = d.uint32()    // uid
+			_ = d.uint32()    // gid
+			for range d.length(16) {
+				_ = d.uint32() // gids
+			}
+		case rpcsecGSS:
+			_ = d.uint32() // service
+			_ = d.opaque(nfs4MaxOpaque)
+			_ = d.opaque(nfs4MaxOpaque)
+		default:
+			if d.err == nil {
+				d.err = errBadXDR
+			}
+		}
+	}
+}
+
+// CREATE_SESSION clientid sequence flags fore_chan_attrs back_chan_attrs cb_program sec_parms<>
+// CREATE_SESSION sessionid sequence flags fore_chan_attrs back_chan_attrs
+func (c *compound) createSession(d *xdrDecoder, e *xdrEncoder) error {
+	clientID := d.uint64()
+	seq := d.uint32()
+	_ = d.uint32() // flags - no persistence or back channel
+	fore := d.channelAttrs()
+	back := d.channelAttrs()
+	_ = d.uint32() // callback program
+	d.secParms()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.clients[clientID]
+	if client == nil || client.minor != 1 {
+		return nfs4ErrStaleClientID
+	}
+	if seq == client.seq-1 && client.csReply != nil {
+		e.b = append(e.b, client.csReply...)
+		return nil
+	}
+	if seq != client.seq {
+		return nfs4ErrSeqMisordered
+	}
+	fore = fore.negotiate()
+	back = back.negotiate()
+	session := &nfs4Session{
+		client: client,
+		maxOps: fore.maxOps,
+		slots:  make([]nfs4Slot, fore.maxRequests),
+	}
+	binary.BigEndian.PutUint32(session.id[:4], s.boot)
+	binary.BigEndian.PutUint64(session.id[4:], s.counter.Add(1))
+	s.sessions[session.id] = session
+	client.sessions[session.id] = session
+	if !client.confirmed {
+		client.confirmed = true
+		s.expireOthers(client)
+	}
+	client.renewed = time.Now()
+	client.seq++
+	start := len(e.b)
+	e.fixed(session.id[:])
+	e.uint32(seq)
+	e.uint32(0) // flags
+	e.channelAttrs(fore)
+	e.channelAttrs(back)
+	client.csReply = append([]byte(nil), e.b[start:]...)
+	return nil
+}
+
+// sessionID reads a sessionid4
+func (d *xdrDecoder) sessionID() (id [16]byte) {
+	copy(id[:], d.fixed(16))
+	return id
+}
+
+// DESTROY_SESSION sessionid
+// DESTROY_SESSION
+func (c *compound) destroySession(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.sessionID()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.sessions[id]
+	if session == nil {
+		return nfs4ErrBadSession
+	}
+	delete(s.sessions, id)
+	delete(session.client.sessions, id)
+	return nil
+}
+
+// DESTROY_CLIENTID clientid
+// DESTROY_CLIENTID
+func (c *compound) destroyClientID(d *xdrDecoder, e *xdrEncoder) error {
+	clientID := d.uint64()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client := s.clients[clientID]
+	if client == nil || client.minor != 1 {
+		return nfs4ErrStaleClientID
+	}
+	if len(client.sessions) > 0 {
+		return nfs4ErrClientIDBusy
+	}
+	s.expireClient(client)
+	return nil
+}
+
+// SEQUENCE sessionid sequenceid slotid highest_slotid cachethis
+// SEQUENCE sessionid sequenceid slotid highest_slotid target_highest_slotid status_flags
+func (c *compound) sequence(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.sessionID()
+	seq := d.uint32()
+	slotID := d.uint32()
+	_ = d.uint32() // highest slot
+	cacheThis := d.bool()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := s.sessions[id]
+	if session == nil {
+		return nfs4ErrBadSession
+	}
+	if slotID >= uint32(len(session.slots)) {
+		return nfs4ErrBadSlot
+	}
+	slot := &session.slots[slotID]
+	switch {
+	case slot.inUse:
+		return nfs4ErrDelay
+	case seq == slot.seq && slot.reply != nil:
+		c.replay = slot.reply
+		return nil
+	case seq == slot.seq:
+		return nfs4ErrRetryUncachedRep
+	case seq != slot.seq+1:
+		return nfs4ErrSeqMisordered
+	}
+	slot.seq = seq
+	slot.inUse = true
+	slot.reply = nil
+	c.session, c.slot, c.cache = session, slot, cacheThis
+	session.client.renewed = time.Now()
+	highest := uint32(len(session.slots) - 1)
+	e.fixed(id[:])
+	e.uint32(seq)
+	e.uint32(slotID)
+	e.uint32(highest)
+	e.uint32(highest) // target highest slot
+	e.uint32(0)       // status flags
+	return nil
+}
+
+// BIND_CONN_TO_SESSION sessionid dir use_conn_in_rdma_mode
+// BIND_CONN_TO_SESSION sessionid dir use_conn_in_rdma_mode
+func (c *compound) bindConnToSession(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.sessionID()
+	_ = d.uint32() // direction
+	_ = d.bool()   // RDMA
+	if d.err != nil {
+		return d.err
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if c.s.sessions[id] == nil {
+		return nfs4ErrBadSession
+	}
+	e.fixed(id[:])
+	e.uint32(cdfs4Fore)
+	e.bool(false)
+	return nil
+}
+
+// BACKCHANNEL_CTL cb_program sec_parms<>
+// BACKCHANNEL_CTL
+//
+// There is no back channel so this is ignored.
+func (c *compound) backchannelCtl(d *xdrDecoder, e *xdrEncoder) error {
+	_ = d.uint32()
+	d.secParms()
+	return d.err
+}
+
+// RECLAIM_COMPLETE one_fs
+// RECLAIM_COMPLETE
+//
+// There is nothing to reclaim so this is ignored.
+func (c *compound) reclaimComplete(d *xdrDecoder, e *xdrEncoder) error {
+	_ = d.bool()
+	return d.err
+}
+
+// OPEN seqid share_access share_deny owner[clientid owner] openhow claim
+// OPEN stateid cinfo rflags attrset delegation
+func (c *compound) open(d *xdrDecoder, e *xdrEncoder) error {
+	seqid := d.uint32()
+	access := d.uint32() & 0xFF // ignore the 4.1 delegation wants
+	deny := d.uint32()
+	clientID := d.uint64()
+	ownerName := d.string(nfs4MaxOpaque)
+	openType := d.uint32()
+	var (
+		how      uint32
+		verifier [8]byte
+		attrs    *nfs4SetAttrs
+		err      error
+	)
+	if openType == openCreate {
+		how = d.uint32()
+		switch how {
+		case createUnchecked, createGuarded:
+			attrs, err = d.setAttrs()
+		case createExclusive:
+			copy(verifier[:], d.fixed(8))
+		case createExclusive4:
+			copy(verifier[:], d.fixed(8))
+			attrs, err = d.setAttrs()
+		default:
+			err = nfs4ErrInval
+		}
+		if err != nil {
+			return err
+		}
+	} else if openType != openNoCreate {
+		return nfs4ErrInval
+	}
+	claim := d.uint32()
+	var name string
+	switch claim {
+	case claimNull:
+		name = d.string(nfs4MaxOpaque)
+	case claimFH:
+		if c.minor == 0 {
+			return nfs4ErrInval
+		}
+	case claimPrevious:
+		return nfs4ErrNoGrace
+	default:
+		return nfs4ErrNotSupp
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if access == 0 || access > shareAccessBoth || deny > shareDenyBoth {
+		return nfs4ErrInval
+	}
+	if c.cfh == nil {
+		return nfs4ErrNoFileHandle
+	}
+	dirPath := c.cfh.path
+	p := dirPath
+	if claim == claimNull {
+		if _, err := c.dir(); err != nil {
+			return err
+		}
+		if err := checkName(name); err != nil {
+			return err
+		}
+		p = join(dirPath, name)
+	} else {
+		if openType == openCreate {
+			return nfs4ErrInval
+		}
+		dirPath = parent(p)
+	}
+	if c.s.readOnly && (access&shareAccessWrite != 0 || openType == openCreate) {
+		return nfs4ErrROFS
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, err := c.getClient(clientID)
+	if err != nil {
+		return err
+	}
+	o := client.getOwner(openOwnerPrefix + ownerName)
+	if err := c.checkSeqid(o, seqid); err != nil || c.cached != nil {
+		return err
+	}
+	before := s.changeOf(dirPath)
+
+	// Find or create the file
+	var handle vfs.Handle
+	var attrset []uint32
+	node, err := s.vfs.Stat(p)
+	if err != nil && !errors.Is(err, vfs.ENOENT) {
+		return err
+	}
+	exists := err == nil
+	if openType == openCreate {
+		switch how {
+		case createGuarded:
+			if exists {
+				return nfs4ErrExist
+			}
+		case createExclusive, createExclusive4:
+			if exists && s.exclusive[p] != verifier {
+				return nfs4ErrExist
+			}
+		}
+		if !exists {
+			handle, err = s.vfs.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+			if err != nil {
+				return err
+			}
+			node = handle.Node()
+			if how == createExclusive || how == createExclusive4 {
+				s.exclusive[p] = verifier
+			}
+		}
+	} else if !exists {
+		return nfs4ErrNoEnt
+	}
+	file, ok := node.(*vfs.File)
+	switch {
+	case node.IsDir():
+		return nfs4ErrIsDir
+	case !ok:
+		return nfs4ErrInval
+	case node.Mode()&os.ModeSymlink != 0:
+		return nfs4ErrSymlink
+	}
+
+	// Check the share reservations and open the file
+	var st *nfs4State
+	for _, other := range s.opens[file] {
+		if other.owner == o {
+			st = other
+			continue
+		}
+		if other.deny&access != 0 || other.access&deny != 0 {
+			return nfs4ErrShareDenied
+		}
+	}
+	if st != nil {
+		if newAccess := st.access | access; newAccess != st.access {
+			h, err := file.Open(accessFlags(newAccess))
+			if err != nil {
+				return err
+			}
+			if err := st.handle.Close(); err != nil {
+				fs.Errorf(file, "Failed to close file: %v", err)
+			}
+			st.handle = h
+			st.access = newAccess
+		}
+		st.deny |= deny
+		st.id.seqid++
+	} else {
+		if handle == nil {
+			handle, err = file.Open(accessFlags(access))
+			if err != nil {
+				return err
+			}
+		}
+		st = s.newState(o, file)
+		st.handle = handle
+		st.access = access
+		st.deny = deny
+		st.id.seqid = 1
+		s.addState(st)
+	}
+
+	// Set the attributes if creating
+	if attrs != nil && (how != createUnchecked || !exists) {
+		attrset, err = attrs.apply(file)
+	} else if attrs != nil && attrs.hasSize {
+		// only the size is set on an existing file
+		attrset, err = (&nfs4SetAttrs{hasSize: true, size: attrs.size}).apply(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.stateid(st.id)
+	e.bool(false) // change info isn't atomic
+	e.uint64(before)
+	e.uint64(s.changeOf(dirPath))
+	e.uint32(openLocktypePOSIX)
+	e.bitmap(attrset)
+	e.uint32(openDelegateNone)
+	if claim == claimNull {
+		c.setFH(c.s.fh(p))
+	}
+	c.cur = st.id
+	return nil
+}
+
+// OPEN_CONFIRM open_stateid seqid
+// OPEN_CONFIRM open_stateid
+//
+// Opens never need confirming but clients may still send this.
+func (c *compound) openConfirm(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.stateid()
+	seqid := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := c.findState(id)
+	if err != nil {
+		return err
+	}
+	if st.open != nil {
+		return nfs4ErrBadStateID
+	}
+	if err := c.checkSeqid(st.owner, seqid); err != nil || c.cached != nil {
+		return err
+	}
+	st.id.seqid++
+	e.stateid(st.id)
+	return nil
+}
+
+// OPEN_DOWNGRADE open_stateid seqid share_access share_deny
+// OPEN_DOWNGRADE open_stateid
+func (c *compound) openDowngrade(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.stateid()
+	seqid := d.uint32()
+	access := d.uint32() & 0xFF
+	deny := d.uint32()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := c.findState(id)
+	if err != nil {
+		return err
+	}
+	if st.open != nil {
+		return nfs4ErrBadStateID
+	}
+	if err := c.checkSeqid(st.owner, seqid); err != nil || c.cached != nil {
+		return err
+	}
+	if access == 0 || access&^st.access != 0 || deny&^st.deny != 0 {
+		return nfs4ErrInval
+	}
+	st.access = access
+	st.deny = deny
+	st.id.seqid++
+	e.stateid(st.id)
+	c.cur = st.id
+	return nil
+}
+
+// CLOSE seqid open_stateid
+// CLOSE open_stateid
+func (c *compound) close(d *xdrDecoder, e *xdrEncoder) error {
+	seqid := d.uint32()
+	id := d.stateid()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := c.findState(id)
+	if err != nil {
+		return err
+	}
+	if st.open != nil {
+		return nfs4ErrBadStateID
+	}
+	if err := c.checkSeqid(st.owner, seqid); err != nil || c.cached != nil {
+		return err
+	}
+	if err := s.removeState(st); err != nil {
+		return err
+	}
+	if c.minor == 0 {
+		st.id.seqid++
+		e.stateid(st.id)
+	} else {
+		e.stateid(invalidStateid)
+	}
+	c.cur = invalidStateid
+	return nil
+}
+
+// LOCK locktype reclaim offset length locker
+// LOCK lock_stateid | denied
+func (c *compound) lock(d *xdrDecoder, e *xdrEncoder) error {
+	lockType := d.uint32()
+	reclaim := d.bool()
+	offset := d.uint64()
+	length := d.uint64()
+	newOwner := d.bool()
+	var (
+		openSeqid, lockSeqid uint32
+		openID, lockID       nfs4Stateid
+		ownerName            string
+	)
+	if newOwner {
+		openSeqid = d.uint32()
+		openID = d.stateid()
+		lockSeqid = d.uint32()
+		_ = d.uint64() // clientid is the one from the open
+		ownerName = d.string(nfs4MaxOpaque)
+	} else {
+		lockID = d.stateid()
+		lockSeqid = d.uint32()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if reclaim {
+		return nfs4ErrNoGrace
+	}
+	lk, err := vfsLock(lockType, offset, length)
+	if err != nil {
+		return err
+	}
+	s := c.s
+	s.mu.Lock()
+	st, err := c.lockState(newOwner, openID, openSeqid, lockID, lockSeqid, ownerName, lk.Type)
+	s.mu.Unlock()
+	if err != nil || c.cached != nil {
+		return err
+	}
+
+	// Take the lock without s.mu held as it may need remote I/O
+	// with --vfs-lock-remote. Release the locks of removed states
+	// first as they may belong to the same owner.
+	s.releaseLocks()
+	lk.Owner = st.owner.vfsLock
+	err = st.file.Lock(c.ctx, lk, false)
+	if errors.Is(err, vfs.EAGAIN) {
+		conflict, err := st.file.TestLock(c.ctx, lk)
+		if err != nil {
+			return err
+		}
+		if conflict == nil {
+			// the lock went away so try again
+			return nfs4ErrDelay
+		}
+		return c.lockDenied(e, conflict)
+	} else if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.states[st.open.id.other] != st.open || (st.id.seqid != 0 && s.states[st.id.other] != st) {
+		// the state was removed while the lock was being taken
+		s.queueUnlock(st.file, st.owner.vfsLock)
+		s.mu.Unlock()
+		return nfs4ErrBadStateID
+	}
+	defer s.mu.Unlock()
+	if st.id.seqid == 0 {
+		// use the state of another LOCK by the owner if it got here first
+		if other := st.open.lockStateOf(st.owner); other != nil {
+			st = other
+		} else {
+			s.addState(st)
+		}
+	}
+	st.id.seqid++
+	e.stateid(st.id)
+	c.cur = st.id
+	return nil
+}
+
+// lockState finds the lock state for a LOCK operation, making a new
+// one which hasn't been added yet if the lock owner is new.
+//
+// Call with s.mu held
+func (c *compound) lockState(newOwner bool, openID nfs4Stateid, openSeqid uint32, lockID nfs4Stateid, lockSeqid uint32, ownerName string, lockType vfs.LockType) (st *nfs4State, err error) {
+	if newOwner {
+		open, err := c.findState(openID)
+		if err != nil {
+			return nil, err
+		}
+		if open.open != nil {
+			return nil, nfs4ErrBadStateID
+		}
+		if err := c.checkSeqid(open.owner, openSeqid); err != nil || c.cached != nil {
+			return nil, err
+		}
+		o := open.owner.client.getOwner(lockOwnerPrefix + ownerName)
+		if c.minor == 0 {
+			o.started, o.seqid = true, lockSeqid
+		}
+		st = open.lockStateOf(o)
+		if st == nil {
+			st = c.s.newState(o, open.file)
+			st.open = open
+		}
+	} else {
+		st, err = c.findState(lockID)
+		if err != nil {
+			return nil, err
+		}
+		if st.open == nil {
+			return nil, nfs4ErrBadStateID
+		}
+		if err := c.checkSeqid(st.owner, lockSeqid); err != nil || c.cached != nil {
+			return nil, err
+		}
+	}
+	if lockType == vfs.LockWrite && st.open.access&shareAccessWrite == 0 {
+		return nil, nfs4ErrOpenMode
+	}
+	return st, nil
+}
+
+// lockStateOf returns the lock state of owner for the open state or
+// nil if it doesn't have one
+//
+// Call with s.mu held
+func (open *nfs4State) lockStateOf(owner *nfs4Owner) *nfs4State {
+	for _, lockState := range open.locks {
+		if lockState.owner == owner {
+			return lockState
+		}
+	}
+	return nil
+}
+
+// LOCKT locktype offset length owner[clientid owner]
+// LOCKT | denied
+func (c *compound) lockT(d *xdrDecoder, e *xdrEncoder) error {
+	lockType := d.uint32()
+	offset := d.uint64()
+	length := d.uint64()
+	clientID := d.uint64()
+	ownerName := d.string(nfs4MaxOpaque)
+	if d.err != nil {
+		return d.err
+	}
+	lk, err := vfsLock(lockType, offset, length)
+	if err != nil {
+		return err
+	}
+	file, err := c.file()
+	if err != nil {
+		return err
+	}
+	c.s.mu.Lock()
+	client, err := c.getClient(clientID)
+	if err == nil {
+		lk.Owner = client.vfsLockOwner(ownerName)
+	}
+	c.s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	conflict, err := file.TestLock(c.ctx, lk)
+	if err != nil || conflict == nil {
+		return err
+	}
+	return c.lockDenied(e, conflict)
+}
+
+// LOCKU locktype seqid lock_stateid offset length
+// LOCKU lock_stateid
+func (c *compound) lockU(d *xdrDecoder, e *xdrEncoder) error {
+	lockType := d.uint32()
+	seqid := d.uint32()
+	id := d.stateid()
+	offset := d.uint64()
+	length := d.uint64()
+	if d.err != nil {
+		return d.err
+	}
+	lk, err := vfsLock(lockType, offset, length)
+	if err != nil {
+		return err
+	}
+	s := c.s
+	s.mu.Lock()
+	st, err := c.findState(id)
+	if err == nil && st.open == nil {
+		err = nfs4ErrBadStateID
+	}
+	if err == nil {
+		err = c.checkSeqid(st.owner, seqid)
+	}
+	s.mu.Unlock()
+	if err != nil || c.cached != nil {
+		return err
+	}
+
+	// Release the lock without s.mu held as it may need remote
+	// I/O with --vfs-lock-remote
+	lk.Type = vfs.LockUnlock
+	lk.Owner = st.owner.vfsLock
+	if err := st.file.Lock(c.ctx, lk, false); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.id.seqid++
+	e.stateid(st.id)
+	c.cur = st.id
+	return nil
+}
+
+// RELEASE_LOCKOWNER owner[clientid owner]
+// RELEASE_LOCKOWNER
+func (c *compound) releaseLockOwner(d *xdrDecoder, e *xdrEncoder) error {
+	clientID := d.uint64()
+	ownerName := d.string(nfs4MaxOpaque)
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, err := c.getClient(clientID)
+	if err != nil {
+		return err
+	}
+	key := lockOwnerPrefix + ownerName
+	o := client.owners[key]
+	if o == nil {
+		return nil
+	}
+	for _, st := range client.states {
+		if st.owner == o {
+			if err := s.removeState(st); err != nil {
+				return err
+			}
+		}
+	}
+	delete(client.owners, key)
+	return nil
+}
+
+// FREE_STATEID stateid
+// FREE_STATEID
+func (c *compound) freeStateID(d *xdrDecoder, e *xdrEncoder) error {
+	id := d.stateid()
+	if d.err != nil {
+		return d.err
+	}
+	s := c.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, err := c.findState(id)
+	if err != nil {
+		return err
+	}
+	if st.open == nil {
+		return nfs4ErrLocksHeld
+	}
+	return s.removeState(st)
+}
+
+// TEST_STATEID stateids<>
+// TEST_STATEID status_codes<>
+func (c *compound) testStateID(d *xdrDecoder, e *xdrEncoder) error {
+	ids := make([]nfs4Stateid, d.length(nfs4MaxOpaque))
+	for i := range ids {
+		ids[i] = d.stateid()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	e.uint32(uint32(len(ids)))
+	for _, id := range ids {
+		_, err := c.findState(id)
+		e.uint32(uint32(c.s.mapError(err)))
+	}
+	return nil
+}
+
+// DELEGRETURN stateid
+//
+// Delegations are never handed out so the stateid can't be valid.
+func (c *compound) delegReturn(d *xdrDecoder, e *xdrEncoder) error {
+	_ = d.stateid()
+	if d.err != nil {
+		return d.err
+	}
+	return nfs4ErrBadStateID
+}
@@ -0,0 +1,644 @@
+//go:build unix
+
+package nfs
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts a server serving a temporary directory
+// returning the server and the directory
+func startServer(t *testing.T, cacheMode vfscommon.CacheMode) (s *Server, dir string) {
+	fstest.Initialise()
+	dir = t.TempDir()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = cacheMode
+	VFS := vfs.New(f, &vfsOpt)
+	opt := Opt
+	opt.ListenAddr = "localhost:0"
+	s, err = NewServer(context.Background(), VFS, &opt)
+	require.NoError(t, err)
+	go func() {
+		_ = s.Serve()
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+		VFS.Shutdown()
+	})
+	return s, dir
+}
+
+// testClient is a minimal NFSv4 client for testing
+type testClient struct {
+	t        *testing.T
+	c        net.Conn
+	xid      uint32
+	minor    uint32
+	clientID uint64
+	session  [16]byte
+	seq      uint32 // last sequence id used on slot 0 (4.1)
+	seqid    uint32 // next open owner seqid (4.0)
+}
+
+// dial connects to the server without setting up a client ID
+func dial(t *testing.T, s *Server, minor uint32) *testClient {
+	c, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	return &testClient{t: t, c: c, minor: minor}
+}
+
+// call makes an RPC call returning the accept status and a decoder
+// for the rest of the reply
+func (tc *testClient) call(vers, proc uint32, args []byte) (uint32, *xdrDecoder) {
+	t := tc.t
+	tc.xid++
+	e := &xdrEncoder{b: make([]byte, 4)}
+	e.uint32(tc.xid)
+	e.uint32(rpcCall)
+	e.uint32(rpcVersion)
+	e.uint32(nfsProgram)
+	e.uint32(vers)
+	e.uint32(proc)
+	var cred xdrEncoder
+	cred.uint32(0) // stamp
+	cred.string("test")
+	cred.uint32(0) // uid
+	cred.uint32(0) // gid
+	cred.uint32(0) // gids
+	e.uint32(authSys)
+	e.opaque(cred.b)
+	e.uint32(authNone)
+	e.opaque(nil)
+	e.b = append(e.b, args...)
+	be.PutUint32(e.b, uint32(len(e.b)-4)|0x80000000)
+	_, err := tc.c.Write(e.b)
+	require.NoError(t, err)
+
+	var hdr [4]byte
+	_, err = io.ReadFull(tc.c, hdr[:])
+	require.NoError(t, err)
+	mark := be.Uint32(hdr[:])
+	require.NotZero(t, mark&0x80000000, "expecting a single fragment")
+	reply := make([]byte, mark&0x7FFFFFFF)
+	_, err = io.ReadFull(tc.c, reply)
+	require.NoError(t, err)
+
+	d := &xdrDecoder{b: reply}
+	assert.Equal(t, tc.xid, d.uint32())
+	assert.Equal(t, uint32(rpcReply), d.uint32())
+	require.Equal(t, uint32(rpcMsgAccepted), d.uint32())
+	_ = d.uint32() // verifier
+	_ = d.opaque(maxAuthBytes)
+	acceptStat := d.uint32()
+	require.NoError(t, d.err)
+	return acceptStat, d
+}
+
+// op writes an operation and its arguments
+type op func(e *xdrEncoder)
+
+// compound runs ops returning the status of the COMPOUND and a
+// decoder positioned at the first result
+//
+// In 4.1 a SEQUENCE is added at the start once there is a session.
+func (tc *testClient) compound(ops ...op) (nfsStat4, *xdrDecoder) {
+	t := tc.t
+	var e xdrEncoder
+	e.string("test")
+	e.uint32(tc.minor)
+	sequenced := tc.minor != 0 && tc.session != [16]byte{}
+	if sequenced {
+		tc.seq++
+		ops = append([]op{func(e *xdrEncoder) {
+			e.uint32(opSequence)
+			e.fixed(tc.session[:])
+			e.uint32(tc.seq)
+			e.uint32(0) // slot
+			e.uint32(0) // highest slot
+			e.bool(false)
+		}}, ops...)
+	}
+	e.uint32(uint32(len(ops)))
+	for _, o := range ops {
+		o(&e)
+	}
+	acceptStat, d := tc.call(nfsV4, nfs4ProcCompound, e.b)
+	require.Equal(t, uint32(rpcSuccess), acceptStat)
+	status := nfsStat4(d.uint32())
+	assert.Equal(t, "test", d.string(nfs4MaxOpaque))
+	_ = d.uint32() // number of results
+	if sequenced {
+		require.Equal(t, nfs4OK, result(t, d, opSequence))
+		d.fixed(16 + 5*4)
+	}
+	require.NoError(t, d.err)
+	return status, d
+}
+
+// result reads the operation and status of the next result
+func result(t *testing.T, d *xdrDecoder, op uint32) nfsStat4 {
+	require.Equal(t, op, d.uint32())
+	return nfsStat4(d.uint32())
+}
+
+// setup sets up the client ID and the session in 4.1
+func (tc *testClient) setup() {
+	t := tc.t
+	if tc.minor == 0 {
+		status, d := tc.compound(func(e *xdrEncoder) {
+			e.uint32(opSetClientID)
+			e.fixed([]byte("verifier"))
+			e.string("test client")
+			e.uint32(0x40000000) // callback program
+			e.string("tcp")
+			e.string("127.0.0.1.0.0")
+			e.uint32(1)
+		})
+		require.Equal(t, nfs4OK, status)
+		require.Equal(t, nfs4OK, result(t, d, opSetClientID))
+		tc.clientID = d.uint64()
+		confirm := d.fixed(8)
+		status, _ = tc.compound(func(e *xdrEncoder) {
+			e.uint32(opSetClientIDConfirm)
+			e.uint64(tc.clientID)
+			e.fixed(confirm)
+		})
+		require.Equal(t, nfs4OK, status)
+		return
+	}
+	status, d := tc.compound(func(e *xdrEncoder) {
+		e.uint32(opExchangeID)
+		e.fixed([]byte("verifier"))
+		e.string("test client")
+		e.uint32(0) // flags
+		e.uint32(sp4None)
+		e.uint32(0) // no implementation id
+	})
+	require.Equal(t, nfs4OK, status)
+	require.Equal(t, nfs4OK, result(t, d, opExchangeID))
+	tc.clientID = d.uint64()
+	csSeq := d.uint32()
+	status, d = tc.compound(func(e *xdrEncoder) {
+		e.uint32(opCreateSession)
+		e.uint64(tc.clientID)
+		e.uint32(csSeq)
+		e.uint32(0) // flags
+		for range 2 {
+			e.channelAttrs(channelAttrs{maxRequest: 1 << 20, maxResponse: 1 << 20, maxCached: 4096, maxOps: 16, maxRequests: 4})
+		}
+		e.uint32(0x40000000) // callback program
+		e.uint32(1)
+		e.uint32(authNone)
+	})
+	require.Equal(t, nfs4OK, status)
+	require.Equal(t, nfs4OK, result(t, d, opCreateSession))
+	copy(tc.session[:], d.fixed(16))
+	status, _ = tc.compound(func(e *xdrEncoder) {
+		e.uint32(opReclaimComplete)
+		e.bool(false)
+	})
+	require.Equal(t, nfs4OK, status)
+}
+
+// nextSeqid returns the next seqid for the open owner
+func (tc *testClient) nextSeqid() uint32 {
+	tc.seqid++
+	return tc.seqid
+}
+
+func putRootFH(e *xdrEncoder) {
+	e.uint32(opPutRootFH)
+}
+
+func getFH(e *xdrEncoder) {
+	e.uint32(opGetFH)
+}
+
+func putFH(fh []byte) op {
+	return func(e *xdrEncoder) {
+		e.uint32(opPutFH)
+		e.opaque(fh)
+	}
+}
+
+func lookup(name string) op {
+	return func(e *xdrEncoder) {
+		e.uint32(opLookup)
+		e.string(name)
+	}
+}
+
+func getattr(attrs ...int) op {
+	return func(e *xdrEncoder) {
+		e.uint32(opGetattr)
+		e.bitmap(makeBitmap(attrs...))
+	}
+}
+
+// open opens name creating it if create is set
+func (tc *testClient) open(name string, access uint32, create bool) op {
+	seqid := tc.nextSeqid()
+	return func(e *xdrEncoder) {
+		e.uint32(opOpen)
+		e.uint32(seqid)
+		e.uint32(access)
+		e.uint32(0) // deny
+		e.uint64(tc.clientID)
+		e.string("open owner")
+		if create {
+			e.uint32(openCreate)
+			e.uint32(createUnchecked)
+			e.bitmap(nil)
+			e.opaque(nil)
+		} else {
+			e.uint32(openNoCreate)
+		}
+		e.uint32(claimNull)
+		e.string(name)
+	}
+}
+
+// openResult reads the result of OPEN returning the stateid
+func openResult(t *testing.T, d *xdrDecoder) nfs4Stateid {
+	require.Equal(t, nfs4OK, result(t, d, opOpen))
+	id := d.stateid()
+	d.fixed(4 + 8 + 8) // change info
+	assert.Equal(t, uint32(openLocktypePOSIX), d.uint32())
+	_ = d.bitmap()
+	assert.Equal(t, uint32(openDelegateNone), d.uint32())
+	return id
+}
+
+func readOp(id nfs4Stateid, offset uint64, count uint32) op {
+	return func(e *xdrEncoder) {
+		e.uint32(opRead)
+		e.stateid(id)
+		e.uint64(offset)
+		e.uint32(count)
+	}
+}
+
+// lock takes a lock for a new lock owner
+func (tc *testClient) lock(openID nfs4Stateid, owner string, lockType uint32, offset, length uint64) op {
+	seqid := tc.nextSeqid()
+	return func(e *xdrEncoder) {
+		e.uint32(opLock)
+		e.uint32(lockType)
+		e.bool(false) // reclaim
+		e.uint64(offset)
+		e.uint64(length)
+		e.bool(true) // new lock owner
+		e.uint32(seqid)
+		e.stateid(openID)
+		e.uint32(0) // lock seqid
+		e.uint64(tc.clientID)
+		e.string(owner)
+	}
+}
+
+func TestNFS4(t *testing.T) {
+	for _, minor := range []uint32{0, 1} {
+		t.Run(map[uint32]string{0: "v4.0", 1: "v4.1"}[minor], func(t *testing.T) {
+			s, dir := startServer(t, vfscommon.CacheModeFull)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("potato"), 0666))
+			tc := dial(t, s, minor)
+			tc.setup()
+
+			// Look up an existing file and read it without opening it
+			status, d := tc.compound(putRootFH, lookup("existing.txt"), getattr(attrType, attrSize), readOp(anonymousStateid, 0, 100))
+			require.Equal(t, nfs4OK, status)
+			require.Equal(t, nfs4OK, result(t, d, opPutRootFH))
+			require.Equal(t, nfs4OK, result(t, d, opLookup))
+			require.Equal(t, nfs4OK, result(t, d, opGetattr))
+			assert.Equal(t, makeBitmap(attrType, attrSize)[:1], d.bitmap())
+			attrs := &xdrDecoder{b: d.opaque(nfs4MaxRecord)}
+			assert.Equal(t, uint32(fileTypeReg), attrs.uint32())
+			assert.Equal(t, uint64(6), attrs.uint64())
+			require.Equal(t, nfs4OK, result(t, d, opRead))
+			assert.True(t, d.bool())
+			assert.Equal(t, "potato", string(d.opaque(nfs4MaxIO)))
+			require.NoError(t, d.err)
+
+			// Missing files
+			status, d = tc.compound(putRootFH, lookup("missing.txt"))
+			assert.Equal(t, nfs4ErrNoEnt, status)
+			status, _ = tc.compound(putRootFH, lookup(".."))
+			assert.Equal(t, nfs4ErrBadName, status)
+
+			// Create a file and write to it
+			status, d = tc.compound(putRootFH, tc.open("file.txt", shareAccessBoth, true), getFH)
+			require.Equal(t, nfs4OK, status)
+			require.Equal(t, nfs4OK, result(t, d, opPutRootFH))
+			openID := openResult(t, d)
+			require.Equal(t, nfs4OK, result(t, d, opGetFH))
+			fh := d.opaque(nfs4MaxFH)
+			require.NoError(t, d.err)
+			status, d = tc.compound(putFH(fh), func(e *xdrEncoder) {
+				e.uint32(opWrite)
+				e.stateid(openID)
+				e.uint64(0)
+				e.uint32(fileSync)
+				e.opaque([]byte("hello world"))
+			}, readOp(openID, 6, 100))
+			require.Equal(t, nfs4OK, status)
+			require.Equal(t, nfs4OK, result(t, d, opPutFH))
+			require.Equal(t, nfs4OK, result(t, d, opWrite))
+			assert.Equal(t, uint32(11), d.uint32())
+			assert.Equal(t, uint32(fileSync), d.uint32())
+			_ = d.fixed(8)
+			require.Equal(t, nfs4OK, result(t, d, opRead))
+			assert.True(t, d.bool())
+			assert.Equal(t, "world", string(d.opaque(nfs4MaxIO)))
+
+			// Byte range locks
+			status, d = tc.compound(putFH(fh), tc.lock(openID, "owner A", lockWrite, 0, 5))
+			require.Equal(t, nfs4OK, status)
+			_ = result(t, d, opPutFH)
+			require.Equal(t, nfs4OK, result(t, d, opLock))
+			lockID := d.stateid()
+			status, d = tc.compound(putFH(fh), tc.lock(openID, "owner B", lockRead, 2, 10))
+			require.Equal(t, nfs4ErrDenied, status)
+			_ = result(t, d, opPutFH)
+			require.Equal(t, nfs4ErrDenied, result(t, d, opLock))
+			assert.Equal(t, uint64(0), d.uint64())
+			assert.Equal(t, uint64(5), d.uint64())
+			assert.Equal(t, uint32(lockWrite), d.uint32())
+			status, _ = tc.compound(putFH(fh), func(e *xdrEncoder) {
+				e.uint32(opLockT)
+				e.uint32(lockRead)
+				e.uint64(5)
+				e.uint64(lockToEOF)
+				e.uint64(tc.clientID)
+				e.string("owner B")
+			})
+			assert.Equal(t, nfs4OK, status)
+			status, d = tc.compound(putFH(fh), func(e *xdrEncoder) {
+				e.uint32(opLockU)
+				e.uint32(lockWrite)
+				e.uint32(1) // lock seqid
+				e.stateid(lockID)
+				e.uint64(0)
+				e.uint64(5)
+			})
+			require.Equal(t, nfs4OK, status)
+			_ = result(t, d, opPutFH)
+			require.Equal(t, nfs4OK, result(t, d, opLockU))
+			assert.Equal(t, lockID.seqid+1, d.stateid().seqid)
+			status, _ = tc.compound(putFH(fh), tc.lock(openID, "owner B", lockRead, 2, 10))
+			require.Equal(t, nfs4OK, status)
+
+			// Close the file which releases the locks
+			status, d = tc.compound(putFH(fh), func(e *xdrEncoder) {
+				e.uint32(opClose)
+				e.uint32(tc.nextSeqid())
+				e.stateid(openID)
+			})
+			require.Equal(t, nfs4OK, status)
+			status, _ = tc.compound(putFH(fh), readOp(openID, 0, 100))
+			assert.Equal(t, nfs4ErrBadStateID, status)
+
+			// Rename the file and list the directory
+			status, _ = tc.compound(putRootFH, func(e *xdrEncoder) {
+				e.uint32(opSaveFH)
+			}, func(e *xdrEncoder) {
+				e.uint32(opRename)
+				e.string("file.txt")
+				e.string("renamed.txt")
+			})
+			require.Equal(t, nfs4OK, status)
+			status, d = tc.compound(putRootFH, func(e *xdrEncoder) {
+				e.uint32(opReaddir)
+				e.uint64(0)
+				e.fixed(make([]byte, 8))
+				e.uint32(4096)
+				e.uint32(4096)
+				e.bitmap(makeBitmap(attrSize))
+			})
+			require.Equal(t, nfs4OK, status)
+			_ = result(t, d, opPutRootFH)
+			require.Equal(t, nfs4OK, result(t, d, opReaddir))
+			_ = d.fixed(8)
+			names := map[string]uint64{}
+			for d.bool() {
+				_ = d.uint64() // cookie
+				name := d.string(nfs4MaxName)
+				_ = d.bitmap()
+				attrs := &xdrDecoder{b: d.opaque(nfs4MaxRecord)}
+				names[name] = attrs.uint64()
+			}
+			assert.True(t, d.bool())
+			require.NoError(t, d.err)
+			assert.Equal(t, map[string]uint64{"existing.txt": 6, "renamed.txt": 11}, names)
+
+			// Make and remove a directory
+			status, _ = tc.compound(putRootFH, func(e *xdrEncoder) {
+				e.uint32(opCreate)
+				e.uint32(fileTypeDir)
+				e.string("dir")
+				e.bitmap(nil)
+				e.opaque(nil)
+			}, getattr(attrType))
+			require.Equal(t, nfs4OK, status)
+			assert.DirExists(t, filepath.Join(dir, "dir"))
+			status, _ = tc.compound(putRootFH, func(e *xdrEncoder) {
+				e.uint32(opRemove)
+				e.string("dir")
+			})
+			require.Equal(t, nfs4OK, status)
+			assert.NoDirExists(t, filepath.Join(dir, "dir"))
+		})
+	}
+}
+
+func TestNFS4Expiry(t *testing.T) {
+	oldExpiryInterval := expiryInterval
+	expiryInterval = 10 * time.Millisecond
+	t.Cleanup(func() {
+		expiryInterval = oldExpiryInterval
+	})
+	s, _ := startServer(t, vfscommon.CacheModeFull)
+	tc := dial(t, s, 0)
+	tc.setup()
+
+	// Take a lock
+	status, d := tc.compound(putRootFH, tc.open("file.txt", shareAccessBoth, true), getFH)
+	require.Equal(t, nfs4OK, status)
+	_ = result(t, d, opPutRootFH)
+	openID := openResult(t, d)
+	require.Equal(t, nfs4OK, result(t, d, opGetFH))
+	fh := d.opaque(nfs4MaxFH)
+	status, _ = tc.compound(putFH(fh), tc.lock(openID, "owner A", lockWrite, 0, lockToEOF))
+	require.Equal(t, nfs4OK, status)
+	node, err := s.v4.vfs.Stat("file.txt")
+	require.NoError(t, err)
+	file := node.(*vfs.File)
+	locked := func() bool {
+		conflict, err := file.TestLock(context.Background(), vfs.Lock{Type: vfs.LockWrite, End: vfs.LockEOF, Owner: 1})
+		require.NoError(t, err)
+		return conflict != nil
+	}
+	require.True(t, locked())
+
+	// When the lease runs out the client is expired and its lock
+	// released without any more requests
+	s.v4.mu.Lock()
+	for _, client := range s.v4.clients {
+		client.renewed = time.Now().Add(-3 * nfs4LeaseTime * time.Second)
+	}
+	s.v4.mu.Unlock()
+	assert.Eventually(t, func() bool {
+		return !locked()
+	}, 5*time.Second, 10*time.Millisecond)
+	s.v4.mu.Lock()
+	assert.Len(t, s.v4.clients, 0)
+	s.v4.mu.Unlock()
+}
+
+// lockToEOF is a lock length meaning to the end of the file
+const lockToEOF = ^uint64(0)
+
+func TestNFS4Compound(t *testing.T) {
+	s, _ := startServer(t, vfscommon.CacheModeOff)
+
+	t.Run("Null", func(t *testing.T) {
+		tc := dial(t, s, 0)
+		acceptStat, _ := tc.call(nfsV4, nfs4ProcNull, nil)
+		assert.Equal(t, uint32(rpcSuccess), acceptStat)
+		acceptStat, _ = tc.call(nfsV4, 99, nil)
+		assert.Equal(t, uint32(rpcProcUnavail), acceptStat)
+	})
+
+	t.Run("NFSv3", func(t *testing.T) {
+		// NFSv3 connections are still served by go-nfs
+		tc := dial(t, s, 0)
+		acceptStat, _ := tc.call(3, 0, nil)
+		assert.Equal(t, uint32(rpcSuccess), acceptStat)
+	})
+
+	t.Run("MinorVersion", func(t *testing.T) {
+		tc := dial(t, s, 2)
+		status, _ := tc.compound(putRootFH)
+		assert.Equal(t, nfs4ErrMinorVersMismatch, status)
+	})
+
+	t.Run("Illegal", func(t *testing.T) {
+		tc := dial(t, s, 0)
+		status, d := tc.compound(putRootFH, func(e *xdrEncoder) {
+			e.uint32(9999)
+		}, getFH)
+		assert.Equal(t, nfs4ErrOpIllegal, status)
+		assert.Equal(t, nfs4OK, result(t, d, opPutRootFH))
+		assert.Equal(t, nfs4ErrOpIllegal, result(t, d, opIllegal))
+		assert.Empty(t, d.b)
+	})
+
+	t.Run("NoFileHandle", func(t *testing.T) {
+		tc := dial(t, s, 0)
+		status, _ := tc.compound(getFH)
+		assert.Equal(t, nfs4ErrNoFileHandle, status)
+		status, _ = tc.compound(putFH([]byte("bad handle")))
+		assert.Equal(t, nfs4ErrStale, status)
+	})
+
+	t.Run("NotInSession", func(t *testing.T) {
+		tc := dial(t, s, 1)
+		status, _ := tc.compound(putRootFH)
+		assert.Equal(t, nfs4ErrOpNotInSession, status)
+		status, _ = tc.compound(func(e *xdrEncoder) {
+			e.uint32(opSetClientID)
+		})
+		assert.Equal(t, nfs4ErrNotSupp, status)
+	})
+
+	t.Run("StaleClientID", func(t *testing.T) {
+		tc := dial(t, s, 0)
+		tc.clientID = 12345
+		status, _ := tc.compound(putRootFH, tc.open("file.txt", shareAccessRead, false))
+		assert.Equal(t, nfs4ErrStaleClientID, status)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		tc := dial(t, s, 1)
+		tc.setup()
+		args := func(cache bool) []byte {
+			var e xdrEncoder
+			e.string("test")
+			e.uint32(1)
+			e.uint32(2)
+			e.uint32(opSequence)
+			e.fixed(tc.session[:])
+			e.uint32(tc.seq + 1)
+			e.uint32(0)
+			e.uint32(0)
+			e.bool(cache)
+			putRootFH(&e)
+			return e.b
+		}
+		_, d := tc.call(nfsV4, nfs4ProcCompound, args(true))
+		first := d.b
+		_, d = tc.call(nfsV4, nfs4ProcCompound, args(true))
+		assert.Equal(t, first, d.b)
+		tc.seq++
+		_, d = tc.call(nfsV4, nfs4ProcCompound, args(false))
+		assert.Equal(t, nfs4OK, nfsStat4(d.uint32()))
+		_, d = tc.call(nfsV4, nfs4ProcCompound, args(false))
+		assert.Equal(t, nfs4ErrRetryUncachedRep, nfsStat4(d.uint32()))
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		tc := dial(t, s, 1)
+		tc.setup()
+		status, _ := tc.compound(putRootFH, tc.open("new.txt", shareAccessWrite, true))
+		assert.Equal(t, nfs4ErrROFS, status)
+		status, d := tc.compound(putRootFH, func(e *xdrEncoder) {
+			e.uint32(opAccess)
+			e.uint32(accessRead | accessModify)
+		})
+		require.Equal(t, nfs4OK, status)
+		_ = result(t, d, opPutRootFH)
+		_ = result(t, d, opAccess)
+		assert.Equal(t, uint32(accessAll), d.uint32())
+		assert.Equal(t, uint32(accessRead), d.uint32())
+	})
+}
+
+func TestNFS4MapError(t *testing.T) {
+	s := &nfs4Server{}
+	for _, test := range []struct {
+		err  error
+		want nfsStat4
+	}{
+		{nil, nfs4OK},
+		{nfs4ErrDenied, nfs4ErrDenied},
+		{vfs.ENOENT, nfs4ErrNoEnt},
+		{fs.ErrorDirNotFound, nfs4ErrNoEnt},
+		{vfs.EEXIST, nfs4ErrExist},
+		{os.ErrExist, nfs4ErrExist},
+		{vfs.ENOTEMPTY, nfs4ErrNotEmpty},
+		{vfs.EROFS, nfs4ErrROFS},
+		{vfs.ENOSYS, nfs4ErrNotSupp},
+		{errBadXDR, nfs4ErrBadXDR},
+		{io.ErrUnexpectedEOF, nfs4ErrIO},
+	} {
+		assert.Equal(t, test.want, s.mapError(test.err), test.err)
+	}
+}
@@ -20,6 +20,7 @@ type Server struct {
 	handler             nfs.Handler
 	ctx                 context.Context // for global config
 	listener            net.Listener
+	v4                  *nfs4Server
 	UnmountedExternally bool
 }
 
@@ -41,6 +42,7 @@ func NewServer(ctx context.Context, vfs *vfs.VFS, opt *Options) (s *Server, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to make NFS handler: %w", err)
 	}
+	s.v4 = newNFS4Server(ctx, s.handler.(*Handler))
 	s.listener, err = net.Listen("tcp", s.opt.ListenAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open listening socket: %w", err)
@@ -55,11 +57,16 @@ func (s *Server) Addr() net.Addr {
 
 // Shutdown stops the server
 func (s *Server) Shutdown() error {
-	return s.listener.Close()
+	err := s.listener.Close()
+	s.v4.shutdown()
+	return err
 }
 
 // Serve starts the server
+//
+// NFSv3 is served by go-nfs and NFSv4 by the nfs4Server on the same
+// port.
 func (s *Server) Serve() (err error) {
 	fs.Logf(nil, "NFS Server running at %s\n", s.listener.Addr())
-	return nfs.Serve(s.listener, s.handler)
+	return nfs.Serve(newDemuxListener(s.listener, s.v4), s.handler)
 }
@@ -0,0 +1,156 @@
+//go:build unix
+
+package nfs
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var be = binary.BigEndian
+
+// errBadXDR is returned when decoding runs off the end of a message
+// or finds a value which is too long
+var errBadXDR = errors.New("bad XDR encoding")
+
+// xdrDecoder reads XDR encoded values from a buffer
+//
+// Errors are sticky so only need to be checked at the end.
+type xdrDecoder struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes skipping the padding after them
+func (d *xdrDecoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	padded := (n + 3) &^ 3
+	if n < 0 || padded > len(d.b) {
+		d.err = errBadXDR
+		d.b = nil
+		return nil
+	}
+	p := d.b[:n:n]
+	d.b = d.b[padded:]
+	return p
+}
+
+func (d *xdrDecoder) uint32() uint32 {
+	if p := d.next(4); p != nil {
+		return be.Uint32(p)
+	}
+	return 0
+}
+
+func (d *xdrDecoder) uint64() uint64 {
+	if p := d.next(8); p != nil {
+		return be.Uint64(p)
+	}
+	return 0
+}
+
+func (d *xdrDecoder) bool() bool {
+	return d.uint32() != 0
+}
+
+// length reads an array or opaque length checking it is at most max
+func (d *xdrDecoder) length(max int) int {
+	n := d.uint32()
+	if d.err == nil && uint64(n) > uint64(max) {
+		d.err = errBadXDR
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// opaque reads variable length opaque data of at most max bytes
+func (d *xdrDecoder) opaque(max int) []byte {
+	n := d.length(max)
+	if d.err != nil {
+		return nil
+	}
+	return d.next(n)
+}
+
+// fixed reads n bytes of fixed length opaque data
+func (d *xdrDecoder) fixed(n int) []byte {
+	return d.next(n)
+}
+
+// string reads a string of at most max bytes
+func (d *xdrDecoder) string(max int) string {
+	return string(d.opaque(max))
+}
+
+// bitmap reads a bitmap4
+func (d *xdrDecoder) bitmap() []uint32 {
+	n := d.length(8)
+	bitmap := make([]uint32, n)
+	for i := range bitmap {
+		bitmap[i] = d.uint32()
+	}
+	return bitmap
+}
+
+// xdrEncoder builds XDR encoded values
+type xdrEncoder struct {
+	b []byte
+}
+
+func (e *xdrEncoder) uint32(x uint32) {
+	e.b = be.AppendUint32(e.b, x)
+}
+
+func (e *xdrEncoder) uint64(x uint64) {
+	e.b = be.AppendUint64(e.b, x)
+}
+
+func (e *xdrEncoder) bool(x bool) {
+	if x {
+		e.uint32(1)
+	} else {
+		e.uint32(0)
+	}
+}
+
+// pad writes zeros to align the buffer to 4 bytes after n bytes of data
+func (e *xdrEncoder) pad(n int) {
+	for ; n&3 != 0; n++ {
+		e.b = append(e.b, 0)
+	}
+}
+
+// opaque writes variable length opaque data
+func (e *xdrEncoder) opaque(p []byte) {
+	e.uint32(uint32(len(p)))
+	e.fixed(p)
+}
+
+// fixed writes fixed length opaque data
+func (e *xdrEncoder) fixed(p []byte) {
+	e.b = append(e.b, p...)
+	e.pad(len(p))
+}
+
+// string writes a string
+func (e *xdrEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.b = append(e.b, s...)
+	e.pad(len(s))
+}
+
+// bitmap writes a bitmap4 leaving off trailing zero words
+func (e *xdrEncoder) bitmap(bitmap []uint32) {
+	n := len(bitmap)
+	for n > 0 && bitmap[n-1] == 0 {
+		n--
+	}
+	e.uint32(uint32(n))
+	for _, word := range bitmap[:n] {
+		e.uint32(word)
+	}
+}
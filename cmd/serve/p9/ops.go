@@ -0,0 +1,1098 @@
+package p9
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+)
+
+// fid is a client's reference to a file or directory
+//
+// The fields are protected by conn.mu
+type fid struct {
+	path    string              // path of the file in the VFS
+	root    string              // path of the attach point which ".." can't go above
+	opened  bool                // set when opened with Tlopen or Tlcreate
+	handle  vfs.Handle          // open file handle or nil
+	entries []dirEntry          // snapshot of the directory for Treaddir
+	owners  map[uint64]struct{} // lock owners which have used this fid
+}
+
+// dirEntry is an entry in a directory listing
+type dirEntry struct {
+	name string
+	qid  qid
+	typ  uint8
+}
+
+// join returns the VFS path of name in dir
+func join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// parent returns the VFS path of the directory containing p
+func parent(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// checkName checks name is a valid single path element
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return eINVAL
+	}
+	return nil
+}
+
+// qidOf returns the qid for node
+func qidOf(node vfs.Node) qid {
+	if node.IsDir() {
+		return qid{typ: qtDir, path: node.Inode()}
+	}
+	return qid{typ: qtFile, path: node.Inode()}
+}
+
+// osFlags converts Linux open flags into os flags
+func osFlags(flags uint32) (int, error) {
+	var out int
+	switch flags & lOAccMode {
+	case lORdOnly:
+		out = os.O_RDONLY
+	case lOWrOnly:
+		out = os.O_WRONLY
+	case lORdWr:
+		out = os.O_RDWR
+	default:
+		return 0, eINVAL
+	}
+	if flags&lOTrunc != 0 {
+		out |= os.O_TRUNC
+	}
+	if flags&lOAppend != 0 {
+		out |= os.O_APPEND
+	}
+	return out, nil
+}
+
+// iounit returns the largest read or write which fits in a message
+func (c *conn) iounit() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.msize - ioHeaderSize
+}
+
+// getFid returns the fid with id
+func (c *conn) getFid(id uint32) (*fid, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.fids[id]
+	if f == nil {
+		return nil, eBADF
+	}
+	return f, nil
+}
+
+// addFid adds f as id returning an error if id is in use
+func (c *conn) addFid(id uint32, f *fid) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id == noFid || c.fids[id] != nil {
+		return eINVAL
+	}
+	c.fids[id] = f
+	return nil
+}
+
+// paths returns the path and root of f
+func (c *conn) paths(f *fid) (p, root string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return f.path, f.root
+}
+
+// node returns the VFS node for f
+func (c *conn) node(f *fid) (vfs.Node, error) {
+	c.mu.Lock()
+	h, p := f.handle, f.path
+	c.mu.Unlock()
+	if h != nil {
+		return h.Node(), nil
+	}
+	return c.s.vfs.Stat(p)
+}
+
+// dirNode returns the VFS directory for f
+func (c *conn) dirNode(f *fid) (*vfs.Dir, error) {
+	node, err := c.node(f)
+	if err != nil {
+		return nil, err
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return nil, eNOTDIR
+	}
+	return dir, nil
+}
+
+// getHandle returns the open handle for f
+func (c *conn) getHandle(f *fid) (vfs.Handle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.handle == nil {
+		if f.opened {
+			return nil, eISDIR
+		}
+		return nil, eBADF
+	}
+	return f.handle, nil
+}
+
+// release closes f's handle dropping any locks it holds
+func (c *conn) release(f *fid) error {
+	c.mu.Lock()
+	h, owners := f.handle, f.owners
+	f.handle, f.owners = nil, nil
+	c.mu.Unlock()
+	if h == nil {
+		return nil
+	}
+	if file, ok := h.Node().(*vfs.File); ok {
+		for owner := range owners {
+			err := file.Unlock(c.s.ctx, owner)
+			if err != nil {
+				fs.Errorf(file, "Failed to release lock: %v", err)
+			}
+		}
+	}
+	return h.Close()
+}
+
+// clunkAll releases all the fids
+func (c *conn) clunkAll() {
+	c.mu.Lock()
+	fids := c.fids
+	c.fids = make(map[uint32]*fid)
+	c.mu.Unlock()
+	for _, f := range fids {
+		err := c.release(f)
+		if err != nil {
+			fs.Errorf(c.what, "Failed to close file: %v", err)
+		}
+	}
+}
+
+// renamed updates the paths of fids after oldPath was renamed to newPath
+func (c *conn) renamed(oldPath, newPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update := func(p string) string {
+		if p == oldPath {
+			return newPath
+		}
+		if strings.HasPrefix(p, oldPath+"/") {
+			return newPath + p[len(oldPath):]
+		}
+		return p
+	}
+	for _, f := range c.fids {
+		f.path = update(f.path)
+		f.root = update(f.root)
+	}
+}
+
+// lockOwner makes a lock owner from the client's lock identity
+func (c *conn) lockOwner(clientID string, procID uint32) uint64 {
+	h := fnv.New64a()
+	var buf [12]byte
+	le.PutUint64(buf[:], c.id)
+	le.PutUint32(buf[8:], procID)
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(clientID))
+	return h.Sum64()
+}
+
+// lockRange converts a 9P start and length into a vfs.Lock range
+func lockRange(start, length uint64) (int64, int64, error) {
+	if start > math.MaxInt64 {
+		return 0, 0, eINVAL
+	}
+	if length == 0 || length > math.MaxInt64-start {
+		return int64(start), vfs.LockEOF, nil
+	}
+	return int64(start), int64(start + length - 1), nil
+}
+
+// Tversion msize[4] version[s]
+// Rversion msize[4] version[s]
+func (c *conn) version(d *decoder, e *encoder) error {
+	msize := d.u32()
+	version := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	c.clunkAll()
+	msize = min(msize, uint32(c.s.opt.Msize))
+	if msize < minMsize {
+		return eINVAL
+	}
+	if version != protocolVersion {
+		version = "unknown"
+	}
+	c.mu.Lock()
+	c.msize = msize
+	c.mu.Unlock()
+	e.u32(msize)
+	e.str(version)
+	return nil
+}
+
+// Tauth afid[4] uname[s] aname[s] n_uname[4]
+//
+// Authentication isn't supported
+func (c *conn) auth(d *decoder, e *encoder) error {
+	return eOPNOTSUPP
+}
+
+// Tattach fid[4] afid[4] uname[s] aname[s] n_uname[4]
+// Rattach qid[13]
+func (c *conn) attach(d *decoder, e *encoder) error {
+	id := d.u32()
+	afid := d.u32()
+	uname := d.str()
+	aname := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if afid != noFid {
+		return eINVAL
+	}
+	root := strings.Trim(path.Clean("/"+aname), "/")
+	node, err := c.s.vfs.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !node.IsDir() {
+		return eNOTDIR
+	}
+	err = c.addFid(id, &fid{path: root, root: root})
+	if err != nil {
+		return err
+	}
+	fs.Infof(c.what, "9P attach from %q to %q", uname, "/"+root)
+	e.qid(qidOf(node))
+	return nil
+}
+
+// Tflush oldtag[2]
+// Rflush
+//
+// The VFS operations can't be cancelled so this waits for the old
+// request to be answered.
+func (c *conn) flush(d *decoder, e *encoder) error {
+	oldTag := d.u16()
+	if d.err != nil {
+		return d.err
+	}
+	c.sendMu.Lock()
+	done := c.tags[oldTag]
+	c.sendMu.Unlock()
+	if done != nil {
+		<-done
+	}
+	return nil
+}
+
+// Twalk fid[4] newfid[4] nwname[2] nwname*(wname[s])
+// Rwalk nwqid[2] nwqid*(wqid[13])
+func (c *conn) walk(d *decoder, e *encoder) error {
+	id := d.u32()
+	newID := d.u32()
+	names := make([]string, d.u16())
+	if len(names) > maxWalkElements {
+		return eINVAL
+	}
+	for i := range names {
+		names[i] = d.str()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	p, root := c.paths(f)
+	qids := make([]qid, 0, len(names))
+	for i, name := range names {
+		var newPath string
+		switch {
+		case name == "..":
+			newPath = p
+			if p != root {
+				newPath = parent(p)
+			}
+		case checkName(name) == nil:
+			newPath = join(p, name)
+		default:
+			err = eINVAL
+		}
+		var node vfs.Node
+		if err == nil {
+			node, err = c.s.vfs.Stat(newPath)
+		}
+		if err == nil && i < len(names)-1 && !node.IsDir() {
+			err = eNOTDIR
+		}
+		if err != nil {
+			// Only the first element failing is an error
+			if i == 0 {
+				return err
+			}
+			break
+		}
+		qids = append(qids, qidOf(node))
+		p = newPath
+	}
+	if len(qids) == len(names) {
+		if newID == id {
+			c.mu.Lock()
+			f.path = p
+			c.mu.Unlock()
+		} else {
+			err = c.addFid(newID, &fid{path: p, root: root})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	e.u16(uint16(len(qids)))
+	for _, q := range qids {
+		e.qid(q)
+	}
+	return nil
+}
+
+// setOpen marks f as opened with handle h
+func (c *conn) setOpen(f *fid, h vfs.Handle) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.opened {
+		return eINVAL
+	}
+	f.opened = true
+	f.handle = h
+	if h != nil {
+		f.path = h.Node().Path()
+	}
+	return nil
+}
+
+// Tlopen fid[4] flags[4]
+// Rlopen qid[13] iounit[4]
+func (c *conn) lopen(d *decoder, e *encoder) error {
+	id := d.u32()
+	flags := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	node, err := c.node(f)
+	if err != nil {
+		return err
+	}
+	var h vfs.Handle
+	if node.IsDir() {
+		if flags&lOAccMode != lORdOnly {
+			return eISDIR
+		}
+	} else {
+		if flags&lODirectory != 0 {
+			return eNOTDIR
+		}
+		oFlags, err := osFlags(flags)
+		if err != nil {
+			return err
+		}
+		h, err = node.Open(oFlags)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.setOpen(f, h)
+	if err != nil {
+		if h != nil {
+			_ = h.Close()
+		}
+		return err
+	}
+	e.qid(qidOf(node))
+	e.u32(c.iounit())
+	return nil
+}
+
+// Tlcreate fid[4] name[s] flags[4] mode[4] gid[4]
+// Rlcreate qid[13] iounit[4]
+func (c *conn) lcreate(d *decoder, e *encoder) error {
+	id := d.u32()
+	name := d.str()
+	flags := d.u32()
+	mode := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	dir, err := c.dirNode(f)
+	if err != nil {
+		return err
+	}
+	oFlags, err := osFlags(flags)
+	if err != nil {
+		return err
+	}
+	p := join(dir.Path(), name)
+	if flags&lOExcl != 0 {
+		if _, err := c.s.vfs.Stat(p); err == nil {
+			return eEXIST
+		}
+	}
+	h, err := c.s.vfs.OpenFile(p, oFlags|os.O_CREATE, os.FileMode(mode).Perm())
+	if err != nil {
+		return err
+	}
+	err = c.setOpen(f, h)
+	if err != nil {
+		_ = h.Close()
+		return err
+	}
+	fs.Infof(h.Node(), "9P create")
+	e.qid(qidOf(h.Node()))
+	e.u32(c.iounit())
+	return nil
+}
+
+// Tread fid[4] offset[8] count[4]
+// Rread count[4] data[count]
+func (c *conn) read(d *decoder, e *encoder) error {
+	id := d.u32()
+	offset := d.u64()
+	count := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if offset > math.MaxInt64 {
+		return eINVAL
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	h, err := c.getHandle(f)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, min(count, c.iounit()))
+	n, err := h.ReadAt(buf, int64(offset))
+	if err != nil && !errors.Is(err, io.EOF) && n == 0 {
+		return err
+	}
+	e.data(buf[:n])
+	return nil
+}
+
+// Twrite fid[4] offset[8] count[4] data[count]
+// Rwrite count[4]
+func (c *conn) write(d *decoder, e *encoder) error {
+	id := d.u32()
+	offset := d.u64()
+	data := d.data()
+	if d.err != nil {
+		return d.err
+	}
+	if offset > math.MaxInt64 {
+		return eINVAL
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	h, err := c.getHandle(f)
+	if err != nil {
+		return err
+	}
+	n, err := h.WriteAt(data, int64(offset))
+	if err != nil && n == 0 {
+		return err
+	}
+	e.u32(uint32(n))
+	return nil
+}
+
+// Tclunk fid[4]
+// Rclunk
+func (c *conn) clunk(d *decoder, e *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	c.mu.Lock()
+	f := c.fids[id]
+	delete(c.fids, id)
+	c.mu.Unlock()
+	if f == nil {
+		return eBADF
+	}
+	return c.release(f)
+}
+
+// Tremove fid[4]
+// Rremove
+//
+// The fid is clunked even if the remove fails.
+func (c *conn) remove(d *decoder, e *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	c.mu.Lock()
+	f := c.fids[id]
+	delete(c.fids, id)
+	c.mu.Unlock()
+	if f == nil {
+		return eBADF
+	}
+	node, err := c.node(f)
+	releaseErr := c.release(f)
+	if err != nil {
+		return err
+	}
+	err = node.Remove()
+	if err != nil {
+		return err
+	}
+	fs.Infof(node, "9P remove")
+	return releaseErr
+}
+
+// Tstatfs fid[4]
+// Rstatfs type[4] bsize[4] blocks[8] bfree[8] bavail[8] files[8] ffree[8] fsid[8] namelen[4]
+func (c *conn) statfs(d *decoder, e *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if _, err := c.getFid(id); err != nil {
+		return err
+	}
+	const blockSize = 4096
+	total, _, free := c.s.vfs.Statfs()
+	e.u32(v9fsMagic)
+	e.u32(blockSize)
+	e.u64(uint64(max(total, 0)) / blockSize) // blocks
+	e.u64(uint64(max(free, 0)) / blockSize)  // bfree
+	e.u64(uint64(max(free, 0)) / blockSize)  // bavail
+	e.u64(1e9)                               // files
+	e.u64(1e9)                               // ffree
+	e.u64(0)                                 // fsid
+	e.u32(255)                               // namelen
+	return nil
+}
+
+// putTime writes t as seconds and nanoseconds
+func (e *encoder) putTime(t time.Time) {
+	e.u64(uint64(t.Unix()))
+	e.u64(uint64(t.Nanosecond()))
+}
+
+// Tgetattr fid[4] request_mask[8]
+// Rgetattr valid[8] qid[13] mode[4] uid[4] gid[4] nlink[8] rdev[8] size[8]
+// blksize[8] blocks[8] atime_sec[8] atime_nsec[8] mtime_sec[8] mtime_nsec[8]
+// ctime_sec[8] ctime_nsec[8] btime_sec[8] btime_nsec[8] gen[8] data_version[8]
+func (c *conn) getattr(d *decoder, e *encoder) error {
+	id := d.u32()
+	_ = d.u64() // request_mask - we always return the basic attributes
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	node, err := c.node(f)
+	if err != nil {
+		return err
+	}
+	mode, nlink := uint32(sIFREG), uint64(1)
+	if node.IsDir() {
+		mode, nlink = sIFDIR, 2
+	}
+	mode |= uint32(node.Mode().Perm())
+	size := uint64(max(node.Size(), 0))
+	modTime := node.ModTime()
+	opt := &c.s.vfs.Opt
+	e.u64(getattrBasic)
+	e.qid(qidOf(node))
+	e.u32(mode)
+	e.u32(opt.UID)
+	e.u32(opt.GID)
+	e.u64(nlink)
+	e.u64(0) // rdev
+	e.u64(size)
+	e.u64(4096)               // blksize
+	e.u64((size + 511) / 512) // blocks
+	e.putTime(modTime)        // atime
+	e.putTime(modTime)        // mtime
+	e.putTime(modTime)        // ctime
+	e.u64(0)                  // btime_sec
+	e.u64(0)                  // btime_nsec
+	e.u64(0)                  // gen
+	e.u64(0)                  // data_version
+	return nil
+}
+
+// Tsetattr fid[4] valid[4] mode[4] uid[4] gid[4] size[8] atime_sec[8]
+// atime_nsec[8] mtime_sec[8] mtime_nsec[8]
+// Rsetattr
+//
+// Changes to the mode, owner and atime are ignored.
+func (c *conn) setattr(d *decoder, e *encoder) error {
+	id := d.u32()
+	valid := d.u32()
+	_ = d.u32() // mode
+	_ = d.u32() // uid
+	_ = d.u32() // gid
+	size := d.u64()
+	_ = d.u64() // atime_sec
+	_ = d.u64() // atime_nsec
+	mtimeSec := d.u64()
+	mtimeNsec := d.u64()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	node, err := c.node(f)
+	if err != nil {
+		return err
+	}
+	if valid&setattrSize != 0 {
+		if node.IsDir() {
+			return eISDIR
+		}
+		if size > math.MaxInt64 {
+			return eINVAL
+		}
+		err = node.Truncate(int64(size))
+		if err != nil {
+			return err
+		}
+	}
+	if valid&setattrMtime != 0 {
+		mtime := time.Now()
+		if valid&setattrMtimeSet != 0 {
+			mtime = time.Unix(int64(mtimeSec), int64(mtimeNsec))
+		}
+		err = node.SetModTime(mtime)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDir reads the directory for f into entries
+func (c *conn) readDir(f *fid) ([]dirEntry, error) {
+	dir, err := c.dirNode(f)
+	if err != nil {
+		return nil, err
+	}
+	items, err := dir.ReadDirAll()
+	if err != nil {
+		return nil, err
+	}
+	_, root := c.paths(f)
+	dirQid, parentQid := qidOf(dir), qidOf(dir)
+	if dir.Path() != root {
+		if parentNode, err := c.s.vfs.Stat(parent(dir.Path())); err == nil {
+			parentQid = qidOf(parentNode)
+		}
+	}
+	entries := make([]dirEntry, 0, len(items)+2)
+	entries = append(entries, dirEntry{name: ".", qid: dirQid, typ: dtDir})
+	entries = append(entries, dirEntry{name: "..", qid: parentQid, typ: dtDir})
+	for _, item := range items {
+		typ := uint8(dtReg)
+		if item.IsDir() {
+			typ = dtDir
+		}
+		entries = append(entries, dirEntry{name: item.Name(), qid: qidOf(item), typ: typ})
+	}
+	return entries, nil
+}
+
+// Treaddir fid[4] offset[8] count[4]
+// Rreaddir count[4] data[count]
+//
+// The data is made of qid[13] offset[8] type[1] name[s] entries. The
+// offset is the position of the next entry in a snapshot of the
+// directory which is taken when reading from offset 0.
+func (c *conn) readdir(d *decoder, e *encoder) error {
+	id := d.u32()
+	offset := d.u64()
+	count := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	entries := f.entries
+	c.mu.Unlock()
+	if offset == 0 || entries == nil {
+		entries, err = c.readDir(f)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		f.entries = entries
+		c.mu.Unlock()
+	}
+	count = min(count, c.iounit())
+	out := &encoder{}
+	for i := offset; i < uint64(len(entries)); i++ {
+		entry := &entries[i]
+		if len(out.b)+24+len(entry.name) > int(count) {
+			break
+		}
+		out.qid(entry.qid)
+		out.u64(i + 1)
+		out.u8(entry.typ)
+		out.str(entry.name)
+	}
+	e.data(out.b)
+	return nil
+}
+
+// Tfsync fid[4] datasync[4]
+// Rfsync
+func (c *conn) fsync(d *decoder, e *encoder) error {
+	id := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	h := f.handle
+	c.mu.Unlock()
+	if h == nil {
+		return nil
+	}
+	return h.Sync()
+}
+
+// Tmkdir dfid[4] name[s] mode[4] gid[4]
+// Rmkdir qid[13]
+func (c *conn) mkdir(d *decoder, e *encoder) error {
+	id := d.u32()
+	name := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	dir, err := c.dirNode(f)
+	if err != nil {
+		return err
+	}
+	newDir, err := dir.Mkdir(name)
+	if err != nil {
+		return err
+	}
+	fs.Infof(newDir, "9P mkdir")
+	e.qid(qidOf(newDir))
+	return nil
+}
+
+// renamePath renames oldPath to newPath updating the fids
+func (c *conn) renamePath(oldPath, newPath string) error {
+	err := c.s.vfs.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	fs.Infof(newPath, "9P rename from %q", oldPath)
+	c.renamed(oldPath, newPath)
+	return nil
+}
+
+// Trename fid[4] dfid[4] name[s]
+// Rrename
+func (c *conn) rename(d *decoder, e *encoder) error {
+	id := d.u32()
+	dirID := d.u32()
+	name := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	dirFid, err := c.getFid(dirID)
+	if err != nil {
+		return err
+	}
+	oldPath, _ := c.paths(f)
+	dirPath, _ := c.paths(dirFid)
+	return c.renamePath(oldPath, join(dirPath, name))
+}
+
+// Trenameat olddirfid[4] oldname[s] newdirfid[4] newname[s]
+// Rrenameat
+func (c *conn) renameat(d *decoder, e *encoder) error {
+	oldDirID := d.u32()
+	oldName := d.str()
+	newDirID := d.u32()
+	newName := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if err := checkName(oldName); err != nil {
+		return err
+	}
+	if err := checkName(newName); err != nil {
+		return err
+	}
+	oldDir, err := c.getFid(oldDirID)
+	if err != nil {
+		return err
+	}
+	newDir, err := c.getFid(newDirID)
+	if err != nil {
+		return err
+	}
+	oldDirPath, _ := c.paths(oldDir)
+	newDirPath, _ := c.paths(newDir)
+	return c.renamePath(join(oldDirPath, oldName), join(newDirPath, newName))
+}
+
+// Tunlinkat dirfd[4] name[s] flags[4]
+// Runlinkat
+func (c *conn) unlinkat(d *decoder, e *encoder) error {
+	id := d.u32()
+	name := d.str()
+	flags := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	dirPath, _ := c.paths(f)
+	node, err := c.s.vfs.Stat(join(dirPath, name))
+	if err != nil {
+		return err
+	}
+	if flags&atRemoveDir != 0 {
+		if !node.IsDir() {
+			return eNOTDIR
+		}
+	} else if node.IsDir() {
+		return eISDIR
+	}
+	err = node.Remove()
+	if err != nil {
+		return err
+	}
+	fs.Infof(node, "9P remove")
+	return nil
+}
+
+// lockFile returns the VFS file open on f
+func (c *conn) lockFile(f *fid) (*vfs.File, error) {
+	h, err := c.getHandle(f)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := h.Node().(*vfs.File)
+	if !ok {
+		return nil, eBADF
+	}
+	return file, nil
+}
+
+// Tlock fid[4] type[1] flags[4] start[8] length[8] proc_id[4] client_id[s]
+// Rlock status[1]
+//
+// The server never blocks waiting for a lock. It returns the blocked
+// status instead and the client retries.
+func (c *conn) lock(d *decoder, e *encoder) error {
+	id := d.u32()
+	typ := d.u8()
+	_ = d.u32() // flags
+	start := d.u64()
+	length := d.u64()
+	procID := d.u32()
+	clientID := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	file, err := c.lockFile(f)
+	if err != nil {
+		return err
+	}
+	lk := vfs.Lock{
+		Owner: c.lockOwner(clientID, procID),
+		Pid:   procID,
+	}
+	lk.Start, lk.End, err = lockRange(start, length)
+	if err != nil {
+		return err
+	}
+	switch typ {
+	case lockTypeRead:
+		lk.Type = vfs.LockRead
+	case lockTypeWrite:
+		lk.Type = vfs.LockWrite
+	case lockTypeUnlock:
+		lk.Type = vfs.LockUnlock
+	default:
+		return eINVAL
+	}
+	err = file.Lock(c.s.ctx, lk, false)
+	switch {
+	case err == nil:
+		e.u8(lockSuccess)
+	case errors.Is(err, vfs.EAGAIN):
+		e.u8(lockBlocked)
+		return nil
+	default:
+		return err
+	}
+	if lk.Type != vfs.LockUnlock {
+		c.mu.Lock()
+		if f.owners == nil {
+			f.owners = make(map[uint64]struct{})
+		}
+		f.owners[lk.Owner] = struct{}{}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// Tgetlock fid[4] type[1] start[8] length[8] proc_id[4] client_id[s]
+// Rgetlock type[1] start[8] length[8] proc_id[4] client_id[s]
+func (c *conn) getlock(d *decoder, e *encoder) error {
+	id := d.u32()
+	typ := d.u8()
+	start := d.u64()
+	length := d.u64()
+	procID := d.u32()
+	clientID := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	f, err := c.getFid(id)
+	if err != nil {
+		return err
+	}
+	file, err := c.lockFile(f)
+	if err != nil {
+		return err
+	}
+	lk := vfs.Lock{
+		Type:  vfs.LockWrite,
+		Owner: c.lockOwner(clientID, procID),
+		Pid:   procID,
+	}
+	if typ == lockTypeRead {
+		lk.Type = vfs.LockRead
+	}
+	lk.Start, lk.End, err = lockRange(start, length)
+	if err != nil {
+		return err
+	}
+	conflict, err := file.TestLock(c.s.ctx, lk)
+	if err != nil {
+		return err
+	}
+	if conflict == nil {
+		e.u8(lockTypeUnlock)
+		e.u64(start)
+		e.u64(length)
+		e.u32(procID)
+		e.str(clientID)
+		return nil
+	}
+	conflictType := uint8(lockTypeWrite)
+	if conflict.Type == vfs.LockRead {
+		conflictType = lockTypeRead
+	}
+	conflictLength := uint64(0)
+	if conflict.End != vfs.LockEOF {
+		conflictLength = uint64(conflict.End-conflict.Start) + 1
+	}
+	e.u8(conflictType)
+	e.u64(uint64(conflict.Start))
+	e.u64(conflictLength)
+	e.u32(conflict.Pid)
+	e.str("")
+	return nil
+}
+
+// Treadlink fid[4]
+// Rreadlink target[s]
+//
+// Symlinks aren't supported so nothing is a link
+func (c *conn) readlink(d *decoder, e *encoder) error {
+	return eINVAL
+}
+
+// notSupported answers requests for features the VFS doesn't have
+func (c *conn) notSupported(d *decoder, e *encoder) error {
+	return eOPNOTSUPP
+}
@@ -0,0 +1,150 @@
+// Package p9 implements a 9P2000.L server to serve an rclone VFS
+package p9
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// OptionsInfo descripts the Options in use
+var OptionsInfo = fs.Options{{
+	Name:    "addr",
+	Default: "localhost:5640",
+	Help:    "IPaddress:Port, :Port or [unix://]/path/to/socket to bind server to",
+}, {
+	Name:    "msize",
+	Default: fs.SizeSuffix(maxMsize),
+	Help:    "Maximum 9P message size to negotiate with clients",
+}}
+
+// Options contains options for the 9P Server
+type Options struct {
+	ListenAddr string        `config:"addr"`  // Port or socket to listen on
+	Msize      fs.SizeSuffix `config:"msize"` // maximum message size
+}
+
+func init() {
+	fs.RegisterGlobalOptions(fs.OptionsInfo{Name: "9p", Opt: &Opt, Options: OptionsInfo})
+}
+
+// Opt is options set by command line flags
+var Opt Options
+
+// AddFlags adds flags for the 9p server
+func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
+	flags.AddFlagsFromOptions(flagSet, "", OptionsInfo)
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags(), &Opt)
+	serve.Command.AddCommand(Command)
+	serve.AddRc("9p", func(ctx context.Context, f fs.Fs, in rc.Params) (serve.Handle, error) {
+		// Read VFS Opts
+		var vfsOpt = vfscommon.Opt // set default opts
+		err := configstruct.SetAny(in, &vfsOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read opts
+		var opt = Opt // set default opts
+		err = configstruct.SetAny(in, &opt)
+		if err != nil {
+			return nil, err
+		}
+		// Create server
+		return newServer(ctx, f, &opt, &vfsOpt)
+	})
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "9p remote:path",
+	Short: `Serve the remote over 9P.`,
+	Long: `Run a 9P server to serve a remote using the 9P2000.L protocol.
+This can be used to mount the remote in a virtual machine or
+container using the Linux kernel v9fs client, QEMU or other 9P clients.
+
+You can use the [filter](/filtering) flags (e.g. ` + "`--include`, `--exclude`" + `)
+to control what is served.
+
+The server will log errors.  Use ` + "`-v`" + ` to see access logs.
+
+` + "`--bwlimit`" + ` will be respected for file transfers.
+Use ` + "`--stats`" + ` to control the stats printing.
+
+### Server options
+
+By default the server binds to localhost:5640 - if you want it to be
+reachable externally then supply ` + "`--addr :5640`" + ` for example.
+
+You can use a unix socket by setting the address to
+` + "`unix:///path/to/socket`" + ` or just by using an absolute path
+name.
+
+This also supports being run with socket activation, in which case it will
+listen on the first passed FD.
+
+The largest message size the server will negotiate can be set with
+` + "`--msize`" + `. Larger messages make reading and writing big files
+quicker.
+
+The 9P protocol has no encryption and the server doesn't do any
+authentication, so don't expose it to untrusted networks. Clients
+may give a path in the attach name (` + "`aname`" + `) to mount a
+subdirectory of the remote.
+
+On Linux you can mount the server with
+
+    mount -t 9p -o trans=tcp,port=5640,version=9p2000.L,cache=none 127.0.0.1 /mnt/point
+
+or for a unix socket
+
+    mount -t 9p -o trans=unix,version=9p2000.L /path/to/socket /mnt/point
+
+The server supports byte range locks with ` + "`Tlock`" + ` and
+` + "`Tgetlock`" + ` which are shared with the other users of the VFS.
+Symlinks, hard links, device nodes and extended attributes are not
+supported. Changes to permissions and ownership are ignored - use
+` + "`--uid`, `--gid`, `--file-perms` and `--dir-perms`" + ` to set them.
+
+### VFS cache mode
+
+9P clients open files for reading and writing at the same time and
+write at random offsets so the server will work best with
+` + "`--vfs-cache-mode writes`" + ` or ` + "`--vfs-cache-mode full`" + `.
+With ` + "`--vfs-cache-mode off`" + ` files can only be written
+sequentially when they are created or overwritten.
+
+` + strings.TrimSpace(vfs.Help()),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Filter",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		cmd.CheckArgs(1, 1, command, args)
+		f := cmd.NewFsSrc(args)
+		cmd.Run(false, true, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt)
+			if err != nil {
+				fs.Fatal(nil, fmt.Sprint(err))
+			}
+			defer systemd.Notify()()
+			return s.Serve()
+		})
+	},
+}
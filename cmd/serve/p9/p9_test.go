@@ -0,0 +1,508 @@
+package p9
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/servetest"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRc(t *testing.T) {
+	servetest.TestRc(t, rc.Params{
+		"type":           "9p",
+		"vfs_cache_mode": "off",
+	})
+}
+
+// startServer starts a server listening on addr serving a temporary
+// directory returning the server and the directory
+func startServer(t *testing.T, addr string, cacheMode vfscommon.CacheMode) (s *server, dir string) {
+	fstest.Initialise()
+	dir = t.TempDir()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	opt := Opt
+	opt.ListenAddr = addr
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = cacheMode
+	s, err = newServer(context.Background(), f, &opt, &vfsOpt)
+	require.NoError(t, err)
+	go func() {
+		assert.NoError(t, s.Serve())
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+	})
+	return s, dir
+}
+
+// client is a minimal 9P2000.L client for testing
+type client struct {
+	t   *testing.T
+	c   net.Conn
+	tag uint16
+}
+
+// dial connects to the server and negotiates the version
+func dial(t *testing.T, s *server) *client {
+	addr := s.Addr()
+	c, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	cl := &client{t: t, c: c}
+	d, err := cl.rpcTag(tversion, noTag, func(e *encoder) {
+		e.u32(64 * 1024)
+		e.str(protocolVersion)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(64*1024), d.u32())
+	assert.Equal(t, protocolVersion, d.str())
+	return cl
+}
+
+// rpc sends a request with the body made by build and returns the
+// body of the reply, or the errno from an Rlerror
+func (cl *client) rpc(typ uint8, build func(e *encoder)) (*decoder, error) {
+	cl.tag++
+	return cl.rpcTag(typ, cl.tag, build)
+}
+
+func (cl *client) rpcTag(typ uint8, tag uint16, build func(e *encoder)) (*decoder, error) {
+	e := newMessage(typ, tag)
+	if build != nil {
+		build(e)
+	}
+	_, err := cl.c.Write(e.bytes())
+	require.NoError(cl.t, err)
+	var hdr [headerSize]byte
+	_, err = io.ReadFull(cl.c, hdr[:])
+	require.NoError(cl.t, err)
+	msg := make([]byte, le.Uint32(hdr[:])-headerSize)
+	_, err = io.ReadFull(cl.c, msg)
+	require.NoError(cl.t, err)
+	require.Equal(cl.t, tag, le.Uint16(hdr[5:]))
+	d := &decoder{b: msg}
+	if hdr[4] == rlerror {
+		return nil, errno(d.u32())
+	}
+	require.Equal(cl.t, typ+1, hdr[4])
+	return d, nil
+}
+
+func (cl *client) attach(id uint32, aname string) error {
+	_, err := cl.rpc(tattach, func(e *encoder) {
+		e.u32(id)
+		e.u32(noFid)
+		e.str("user")
+		e.str(aname)
+		e.u32(1000)
+	})
+	return err
+}
+
+func (cl *client) walk(id, newID uint32, names ...string) (int, error) {
+	d, err := cl.rpc(twalk, func(e *encoder) {
+		e.u32(id)
+		e.u32(newID)
+		e.u16(uint16(len(names)))
+		for _, name := range names {
+			e.str(name)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(d.u16()), nil
+}
+
+func (cl *client) lopen(id uint32, flags uint32) error {
+	_, err := cl.rpc(tlopen, func(e *encoder) {
+		e.u32(id)
+		e.u32(flags)
+	})
+	return err
+}
+
+func (cl *client) lcreate(id uint32, name string, flags uint32) error {
+	_, err := cl.rpc(tlcreate, func(e *encoder) {
+		e.u32(id)
+		e.str(name)
+		e.u32(flags)
+		e.u32(0644)
+		e.u32(1000)
+	})
+	return err
+}
+
+func (cl *client) write(id uint32, offset uint64, data string) (int, error) {
+	d, err := cl.rpc(twrite, func(e *encoder) {
+		e.u32(id)
+		e.u64(offset)
+		e.data([]byte(data))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(d.u32()), nil
+}
+
+func (cl *client) read(id uint32, offset uint64, count uint32) (string, error) {
+	d, err := cl.rpc(tread, func(e *encoder) {
+		e.u32(id)
+		e.u64(offset)
+		e.u32(count)
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(d.data()), nil
+}
+
+func (cl *client) clunk(id uint32) error {
+	_, err := cl.rpc(tclunk, func(e *encoder) {
+		e.u32(id)
+	})
+	return err
+}
+
+// getattr returns the mode, size and mtime of the fid
+func (cl *client) getattr(id uint32) (mode uint32, size uint64, mtime time.Time, err error) {
+	d, err := cl.rpc(tgetattr, func(e *encoder) {
+		e.u32(id)
+		e.u64(getattrBasic)
+	})
+	if err != nil {
+		return 0, 0, time.Time{}, err
+	}
+	assert.Equal(cl.t, uint64(getattrBasic), d.u64())
+	d.next(13) // qid
+	mode = d.u32()
+	d.next(4 + 4 + 8 + 8) // uid, gid, nlink, rdev
+	size = d.u64()
+	d.next(8 + 8 + 16) // blksize, blocks, atime
+	mtime = time.Unix(int64(d.u64()), int64(d.u64()))
+	require.NoError(cl.t, d.err)
+	return mode, size, mtime, nil
+}
+
+// readdir returns the names in the directory
+func (cl *client) readdir(id uint32) (names []string, err error) {
+	offset := uint64(0)
+	for {
+		d, err := cl.rpc(treaddir, func(e *encoder) {
+			e.u32(id)
+			e.u64(offset)
+			e.u32(64) // small count to test continuation
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries := &decoder{b: d.data()}
+		if len(entries.b) == 0 {
+			return names, nil
+		}
+		for len(entries.b) > 0 {
+			entries.next(13) // qid
+			offset = entries.u64()
+			entries.u8() // type
+			names = append(names, entries.str())
+		}
+		require.NoError(cl.t, entries.err)
+	}
+}
+
+func (cl *client) lock(id uint32, typ uint8, start, length uint64, procID uint32) (uint8, error) {
+	d, err := cl.rpc(tlock, func(e *encoder) {
+		e.u32(id)
+		e.u8(typ)
+		e.u32(0)
+		e.u64(start)
+		e.u64(length)
+		e.u32(procID)
+		e.str("client")
+	})
+	if err != nil {
+		return 0, err
+	}
+	return d.u8(), nil
+}
+
+// Test the file operations over TCP and unix sockets
+func TestP9(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		addr func(t *testing.T) string
+	}{
+		{"tcp", func(t *testing.T) string { return "localhost:0" }},
+		{"unix", func(t *testing.T) string { return "unix://" + filepath.Join(t.TempDir(), "9p.sock") }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, dir := startServer(t, test.addr(t), vfscommon.CacheModeWrites)
+			cl := dial(t, s)
+			require.NoError(t, cl.attach(0, ""))
+
+			// Make a directory and create a file in it
+			_, err := cl.rpc(tmkdir, func(e *encoder) {
+				e.u32(0)
+				e.str("dir")
+				e.u32(0755)
+				e.u32(1000)
+			})
+			require.NoError(t, err)
+			n, err := cl.walk(0, 1, "dir")
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			require.NoError(t, cl.lcreate(1, "file.txt", lORdWr))
+			n, err = cl.write(1, 0, "hello world")
+			require.NoError(t, err)
+			assert.Equal(t, 11, n)
+			n, err = cl.write(1, 6, "WORLD")
+			require.NoError(t, err)
+			assert.Equal(t, 5, n)
+			got, err := cl.read(1, 0, 100)
+			require.NoError(t, err)
+			assert.Equal(t, "hello WORLD", got)
+			got, err = cl.read(1, 100, 100)
+			require.NoError(t, err)
+			assert.Equal(t, "", got)
+
+			// Truncate and set the modification time
+			mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+			_, err = cl.rpc(tsetattr, func(e *encoder) {
+				e.u32(1)
+				e.u32(setattrSize | setattrMtime | setattrMtimeSet)
+				e.u32(0)
+				e.u32(0)
+				e.u32(0)
+				e.u64(5)
+				e.u64(0)
+				e.u64(0)
+				e.u64(uint64(mtime.Unix()))
+				e.u64(0)
+			})
+			require.NoError(t, err)
+			mode, size, gotMtime, err := cl.getattr(1)
+			require.NoError(t, err)
+			assert.Equal(t, uint32(sIFREG), mode&^0777)
+			assert.Equal(t, uint64(5), size)
+			assert.True(t, mtime.Equal(gotMtime), gotMtime)
+			require.NoError(t, cl.clunk(1))
+			_, err = cl.read(1, 0, 100)
+			assert.Equal(t, eBADF, err)
+
+			// Read it back with a new fid
+			n, err = cl.walk(0, 2, "dir", "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, 2, n)
+			require.NoError(t, cl.lopen(2, lORdOnly))
+			got, err = cl.read(2, 0, 100)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", got)
+
+			// Walks which fail part way return the qids so far
+			n, err = cl.walk(0, 3, "dir", "potato", "file.txt")
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			_, err = cl.walk(3, 4)
+			assert.Equal(t, eBADF, err)
+			_, err = cl.walk(0, 3, "potato")
+			assert.Equal(t, eNOENT, err)
+			_, err = cl.walk(0, 3, "dir", "file.txt", "potato")
+			require.NoError(t, err)
+			n, err = cl.walk(0, 3, "..", "dir", "..", "dir")
+			require.NoError(t, err)
+			assert.Equal(t, 4, n)
+
+			// Rename the file and list the directory
+			_, err = cl.rpc(trenameat, func(e *encoder) {
+				e.u32(3)
+				e.str("file.txt")
+				e.u32(3)
+				e.str("file2.txt")
+			})
+			require.NoError(t, err)
+			got, err = cl.read(2, 1, 100)
+			require.NoError(t, err)
+			assert.Equal(t, "ello", got)
+			require.NoError(t, cl.lopen(3, lORdOnly|lODirectory))
+			names, err := cl.readdir(3)
+			require.NoError(t, err)
+			assert.Equal(t, []string{".", "..", "file2.txt"}, names)
+
+			// Remove things
+			unlink := func(name string, flags uint32) error {
+				_, err := cl.rpc(tunlinkat, func(e *encoder) {
+					e.u32(0)
+					e.str(name)
+					e.u32(flags)
+				})
+				return err
+			}
+			assert.Equal(t, eISDIR, unlink("dir", 0))
+			assert.Equal(t, eNOTEMPTY, unlink("dir", atRemoveDir))
+			assert.Equal(t, eNOENT, unlink("potato", 0))
+			assert.Equal(t, eINVAL, unlink("dir/file2.txt", 0))
+			require.NoError(t, cl.clunk(3))
+			_, err = cl.walk(0, 3, "dir")
+			require.NoError(t, err)
+			_, err = cl.rpc(tremove, func(e *encoder) {
+				e.u32(2)
+			})
+			require.NoError(t, err)
+			_, _, _, err = cl.getattr(2)
+			assert.Equal(t, eBADF, err)
+			require.NoError(t, unlink("dir", atRemoveDir))
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, entries, 0)
+
+			// Filesystem info
+			d, err := cl.rpc(tstatfs, func(e *encoder) {
+				e.u32(0)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, uint32(v9fsMagic), d.u32())
+			assert.Equal(t, uint32(4096), d.u32())
+
+			// Unsupported things
+			_, err = cl.rpc(tsymlink, nil)
+			assert.Equal(t, eOPNOTSUPP, err)
+			_, err = cl.rpc(tauth, nil)
+			assert.Equal(t, eOPNOTSUPP, err)
+			_, err = cl.rpc(tflush, func(e *encoder) {
+				e.u16(999)
+			})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAttach(t *testing.T) {
+	s, dir := startServer(t, "localhost:0", vfscommon.CacheModeOff)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub", "dir"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file.txt"), []byte("potato"), 0666))
+	cl := dial(t, s)
+
+	assert.Equal(t, eNOENT, cl.attach(0, "/potato"))
+	assert.Equal(t, eNOTDIR, cl.attach(0, "file.txt"))
+	require.NoError(t, cl.attach(0, "/sub"))
+	assert.Equal(t, eINVAL, cl.attach(0, "/sub"))
+
+	// Can't walk above the attach point
+	n, err := cl.walk(0, 1, "..", "..", "dir")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = cl.walk(0, 2, "..", "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, eBADF, cl.clunk(2))
+
+	// Sequential writes work without the cache
+	require.NoError(t, cl.lcreate(1, "new.txt", lOWrOnly|lOTrunc))
+	_, err = cl.write(1, 0, "hello")
+	require.NoError(t, err)
+	require.NoError(t, cl.clunk(1))
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "dir", "new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// A new version clunks all the fids
+	cl = dial(t, s)
+	require.NoError(t, cl.attach(0, ""))
+	_, err = cl.rpcTag(tversion, noTag, func(e *encoder) {
+		e.u32(8192)
+		e.str("9P2000")
+	})
+	require.NoError(t, err)
+	_, err = cl.walk(0, 1)
+	assert.Equal(t, eBADF, err)
+}
+
+func TestLocks(t *testing.T) {
+	s, _ := startServer(t, "localhost:0", vfscommon.CacheModeWrites)
+	cl := dial(t, s)
+	require.NoError(t, cl.attach(0, ""))
+	_, err := cl.walk(0, 1)
+	require.NoError(t, err)
+	require.NoError(t, cl.lcreate(1, "file.txt", lORdWr))
+	_, err = cl.walk(0, 2, "file.txt")
+	require.NoError(t, err)
+	require.NoError(t, cl.lopen(2, lORdWr))
+
+	// Locks on unopened fids fail
+	_, err = cl.walk(0, 3, "file.txt")
+	require.NoError(t, err)
+	_, err = cl.lock(3, lockTypeRead, 0, 0, 1)
+	assert.Equal(t, eBADF, err)
+
+	status, err := cl.lock(1, lockTypeWrite, 0, 10, 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(lockSuccess), status)
+	status, err = cl.lock(2, lockTypeRead, 5, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(lockBlocked), status)
+	status, err = cl.lock(2, lockTypeRead, 10, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(lockSuccess), status)
+
+	// Test for the conflicting lock
+	d, err := cl.rpc(tgetlock, func(e *encoder) {
+		e.u32(2)
+		e.u8(lockTypeWrite)
+		e.u64(0)
+		e.u64(0)
+		e.u32(2)
+		e.str("client")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(lockTypeWrite), d.u8())
+	assert.Equal(t, uint64(0), d.u64())
+	assert.Equal(t, uint64(10), d.u64())
+	assert.Equal(t, uint32(1), d.u32())
+
+	// Closing the fid releases its locks
+	require.NoError(t, cl.clunk(1))
+	status, err = cl.lock(2, lockTypeWrite, 0, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(lockSuccess), status)
+	status, err = cl.lock(2, lockTypeUnlock, 0, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint8(lockSuccess), status)
+}
+
+func TestMapError(t *testing.T) {
+	c := &conn{what: "test"}
+	for _, test := range []struct {
+		err  error
+		want errno
+	}{
+		{vfs.ENOENT, eNOENT},
+		{fs.ErrorObjectNotFound, eNOENT},
+		{vfs.EEXIST, eEXIST},
+		{vfs.ENOTEMPTY, eNOTEMPTY},
+		{vfs.ECLOSED, eBADF},
+		{vfs.EAGAIN, eAGAIN},
+		{vfs.ENOATTR, eNODATA},
+		{eISDIR, eISDIR},
+		{errShortMessage, eINVAL},
+		{errors.New("potato"), eIO},
+	} {
+		assert.Equal(t, test.want, c.mapError(test.err), test.err)
+	}
+}
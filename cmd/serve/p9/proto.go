@@ -0,0 +1,280 @@
+package p9
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 9P2000.L message types. The reply to each T-message has the next
+// type number.
+const (
+	rlerror      = 7
+	tstatfs      = 8
+	tlopen       = 12
+	tlcreate     = 14
+	tsymlink     = 16
+	tmknod       = 18
+	trename      = 20
+	treadlink    = 22
+	tgetattr     = 24
+	tsetattr     = 26
+	txattrwalk   = 30
+	txattrcreate = 32
+	treaddir     = 40
+	tfsync       = 50
+	tlock        = 52
+	tgetlock     = 54
+	tlink        = 70
+	tmkdir       = 72
+	trenameat    = 74
+	tunlinkat    = 76
+	tversion     = 100
+	tauth        = 102
+	tattach      = 104
+	tflush       = 108
+	twalk        = 110
+	tread        = 116
+	twrite       = 118
+	tclunk       = 120
+	tremove      = 122
+)
+
+const (
+	protocolVersion = "9P2000.L"
+	noTag           = 0xFFFF     // tag used by Tversion
+	noFid           = 0xFFFFFFFF // fid meaning no fid
+	headerSize      = 7          // size[4] type[1] tag[2]
+	ioHeaderSize    = 24         // overhead of Rread and Twrite around the data
+	maxWalkElements = 16         // maximum number of names in a Twalk
+	minMsize        = 4096       // smallest msize we will negotiate
+	maxMsize        = 1024 * 1024
+)
+
+// qid types
+const (
+	qtDir  = 0x80
+	qtFile = 0x00
+)
+
+// directory entry types used in Rreaddir
+const (
+	dtDir = 4
+	dtReg = 8
+)
+
+// file mode bits used in Rgetattr
+const (
+	sIFDIR = 0040000
+	sIFREG = 0100000
+)
+
+// Linux open flags used in Tlopen and Tlcreate
+const (
+	lOAccMode   = 0x3
+	lORdOnly    = 0x0
+	lOWrOnly    = 0x1
+	lORdWr      = 0x2
+	lOCreat     = 0x40
+	lOExcl      = 0x80
+	lOTrunc     = 0x200
+	lOAppend    = 0x400
+	lODirectory = 0x10000
+)
+
+// Tgetattr request mask and Rgetattr valid bits
+const (
+	getattrBasic = 0x000007ff // mode, nlink, uid, gid, rdev, atime, mtime, ctime, ino, size, blocks
+)
+
+// Tsetattr valid bits
+const (
+	setattrMode     = 0x001
+	setattrUID      = 0x002
+	setattrGID      = 0x004
+	setattrSize     = 0x008
+	setattrAtime    = 0x010
+	setattrMtime    = 0x020
+	setattrCtime    = 0x040
+	setattrAtimeSet = 0x080
+	setattrMtimeSet = 0x100
+)
+
+// Tunlinkat flags
+const (
+	atRemoveDir = 0x200
+)
+
+// Tlock and Tgetlock types
+const (
+	lockTypeRead   = 0
+	lockTypeWrite  = 1
+	lockTypeUnlock = 2
+)
+
+// Rlock status
+const (
+	lockSuccess = 0
+	lockBlocked = 1
+	lockError   = 2
+)
+
+// statfs magic number reported in Rstatfs (V9FS_MAGIC)
+const v9fsMagic = 0x01021997
+
+// errno is a Linux error number returned in Rlerror
+//
+// These are the Linux values whatever the platform the server is
+// running on as that is what 9P2000.L clients expect.
+type errno uint32
+
+// Linux error numbers
+const (
+	ePERM      errno = 1
+	eNOENT     errno = 2
+	eIO        errno = 5
+	eBADF      errno = 9
+	eAGAIN     errno = 11
+	eEXIST     errno = 17
+	eNOTDIR    errno = 20
+	eISDIR     errno = 21
+	eINVAL     errno = 22
+	eSPIPE     errno = 29
+	eROFS      errno = 30
+	eNOSYS     errno = 38
+	eNOTEMPTY  errno = 39
+	eLOOP      errno = 40
+	eNODATA    errno = 61
+	eOPNOTSUPP errno = 95
+)
+
+// Error satisfies the error interface
+func (e errno) Error() string {
+	return fmt.Sprintf("errno %d", uint32(e))
+}
+
+var le = binary.LittleEndian
+
+// errShortMessage is returned when decoding runs off the end of a message
+var errShortMessage = errors.New("message too short")
+
+// qid is the server's unique identification for a file
+type qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+// decoder reads the fields of a message in order
+//
+// Errors are sticky so only need to be checked at the end.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+// next returns the next n bytes of the message
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = errShortMessage
+		d.b = nil
+		return nil
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *decoder) u8() uint8 {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if p := d.next(2); p != nil {
+		return le.Uint16(p)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if p := d.next(4); p != nil {
+		return le.Uint32(p)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if p := d.next(8); p != nil {
+		return le.Uint64(p)
+	}
+	return 0
+}
+
+// str reads a string prefixed by its length
+func (d *decoder) str() string {
+	return string(d.next(int(d.u16())))
+}
+
+// data reads bytes prefixed by a 32 bit count
+func (d *decoder) data() []byte {
+	return d.next(int(d.u32()))
+}
+
+// encoder builds a message
+type encoder struct {
+	b []byte
+}
+
+// newMessage starts a message of type typ with tag
+func newMessage(typ uint8, tag uint16) *encoder {
+	e := &encoder{b: make([]byte, headerSize, 64)}
+	e.b[4] = typ
+	le.PutUint16(e.b[5:], tag)
+	return e
+}
+
+// bytes finishes the message by filling in its size
+func (e *encoder) bytes() []byte {
+	le.PutUint32(e.b, uint32(len(e.b)))
+	return e.b
+}
+
+func (e *encoder) u8(x uint8) {
+	e.b = append(e.b, x)
+}
+
+func (e *encoder) u16(x uint16) {
+	e.b = le.AppendUint16(e.b, x)
+}
+
+func (e *encoder) u32(x uint32) {
+	e.b = le.AppendUint32(e.b, x)
+}
+
+func (e *encoder) u64(x uint64) {
+	e.b = le.AppendUint64(e.b, x)
+}
+
+// str writes a string prefixed by its length
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+// data writes bytes prefixed by a 32 bit count
+func (e *encoder) data(p []byte) {
+	e.u32(uint32(len(p)))
+	e.b = append(e.b, p...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.typ)
+	e.u32(q.version)
+	e.u64(q.path)
+}
@@ -0,0 +1,334 @@
+package p9
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/fserrors"
+	sdActivation "github.com/rclone/rclone/lib/sdactivation"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// server contains everything to run the server
+type server struct {
+	f        fs.Fs
+	opt      Options
+	vfs      *vfs.VFS
+	ctx      context.Context // for global config
+	listener net.Listener
+	stopped  chan struct{} // for waiting on the listener to stop
+	connID   atomic.Uint64 // last connection ID issued
+
+	mu    sync.Mutex
+	conns map[*conn]struct{} // open connections
+}
+
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options) (*server, error) {
+	s := &server{
+		f:       f,
+		ctx:     ctx,
+		opt:     *opt,
+		stopped: make(chan struct{}),
+		conns:   make(map[*conn]struct{}),
+	}
+	if s.opt.Msize < minMsize {
+		return nil, fmt.Errorf("9p: --msize must be at least %d", minMsize)
+	}
+	if s.opt.Msize > 1<<30 {
+		return nil, errors.New("9p: --msize too large")
+	}
+	s.vfs = vfs.New(f, vfsOpt)
+
+	// In case we run in a socket-activated environment, listen on (the first)
+	// passed FD.
+	sdListeners, err := sdActivation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("9p: unable to acquire listeners: %w", err)
+	}
+	if len(sdListeners) > 0 {
+		if len(sdListeners) > 1 {
+			fs.LogPrintf(fs.LogLevelWarning, nil, "more than one listener passed, ignoring all but the first.\n")
+		}
+		s.listener = sdListeners[0]
+	} else if addr := s.opt.ListenAddr; strings.HasPrefix(addr, "unix://") || filepath.IsAbs(addr) {
+		s.listener, err = net.Listen("unix", strings.TrimPrefix(addr, "unix://"))
+		if err != nil {
+			return nil, fmt.Errorf("9p: failed to listen on unix socket: %w", err)
+		}
+	} else {
+		s.listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("9p: failed to listen for connection: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Serve 9P until the server is Shutdown
+func (s *server) Serve() (err error) {
+	fs.Logf(nil, "9P server listening on %v\n", s.listener.Addr())
+	for {
+		nConn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			fs.Errorf(nil, "Failed to accept incoming connection: %v", err)
+			continue
+		}
+		c := newConn(s, nConn)
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+	close(s.stopped)
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Wait blocks while the listener is open.
+func (s *server) Wait() {
+	<-s.stopped
+}
+
+// Shutdown shuts the running server down
+func (s *server) Shutdown() error {
+	err := s.listener.Close()
+	s.Wait()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.c.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// conn is a single client connection
+type conn struct {
+	s    *server
+	c    net.Conn
+	what string
+	id   uint64         // unique ID used to make lock owners
+	wg   sync.WaitGroup // requests in progress
+
+	sendMu sync.Mutex               // serializes replies and protects tags
+	tags   map[uint16]chan struct{} // requests in progress closed when replied to
+	mu     sync.Mutex               // protects the fields below and the fields of each fid
+	msize  uint32                   // negotiated maximum message size
+	fids   map[uint32]*fid          // fids in use
+}
+
+func newConn(s *server, c net.Conn) *conn {
+	return &conn{
+		s:     s,
+		c:     c,
+		what:  fmt.Sprintf("serve 9p %s->%s", c.RemoteAddr(), c.LocalAddr()),
+		id:    s.connID.Add(1),
+		tags:  make(map[uint16]chan struct{}),
+		msize: minMsize,
+		fids:  make(map[uint32]*fid),
+	}
+}
+
+// serve the connection until it is closed
+//
+// Requests are read here then each is run in its own goroutine so
+// slow operations don't hold up the others.
+func (c *conn) serve() {
+	fs.Debugf(c.what, "New connection")
+	defer func() {
+		_ = c.c.Close()
+		c.wg.Wait()
+		c.clunkAll()
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+		fs.Debugf(c.what, "Connection closed")
+	}()
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(c.what, "Closing connection: %v", err)
+			}
+			return
+		}
+		typ, tag := msg[4], le.Uint16(msg[5:])
+		if typ == tversion {
+			// Tversion resets the connection so wait for
+			// everything else to finish first
+			c.wg.Wait()
+			c.handle(typ, tag, msg[headerSize:], nil)
+			continue
+		}
+		done := make(chan struct{})
+		c.sendMu.Lock()
+		if _, found := c.tags[tag]; found {
+			c.sendMu.Unlock()
+			fs.Infof(c.what, "Closing connection: tag %d is already in use", tag)
+			return
+		}
+		c.tags[tag] = done
+		c.sendMu.Unlock()
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.handle(typ, tag, msg[headerSize:], done)
+		}()
+	}
+}
+
+// readMessage reads a single message
+func (c *conn) readMessage() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.c, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := le.Uint32(hdr[:])
+	c.mu.Lock()
+	msize := c.msize
+	c.mu.Unlock()
+	if size < headerSize || size > msize {
+		return nil, fmt.Errorf("bad message size %d", size)
+	}
+	msg := make([]byte, size)
+	copy(msg, hdr[:])
+	if _, err := io.ReadFull(c.c, msg[4:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// reply sends the reply msg and marks the request as done
+func (c *conn) reply(msg []byte, done chan struct{}) {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if _, err := c.c.Write(msg); err != nil {
+		fs.Debugf(c.what, "Failed to send reply: %v", err)
+		_ = c.c.Close()
+	}
+	if done != nil {
+		delete(c.tags, le.Uint16(msg[5:]))
+		close(done)
+	}
+}
+
+// handler processes the body of a request in d writing the body of
+// the reply to e
+type handler func(c *conn, d *decoder, e *encoder) error
+
+// handlers for each T-message type
+var handlers = map[uint8]handler{
+	tversion:     (*conn).version,
+	tauth:        (*conn).auth,
+	tattach:      (*conn).attach,
+	tflush:       (*conn).flush,
+	twalk:        (*conn).walk,
+	tlopen:       (*conn).lopen,
+	tlcreate:     (*conn).lcreate,
+	tread:        (*conn).read,
+	twrite:       (*conn).write,
+	tclunk:       (*conn).clunk,
+	tremove:      (*conn).remove,
+	tstatfs:      (*conn).statfs,
+	tgetattr:     (*conn).getattr,
+	tsetattr:     (*conn).setattr,
+	treaddir:     (*conn).readdir,
+	tfsync:       (*conn).fsync,
+	tmkdir:       (*conn).mkdir,
+	trename:      (*conn).rename,
+	trenameat:    (*conn).renameat,
+	tunlinkat:    (*conn).unlinkat,
+	tlock:        (*conn).lock,
+	tgetlock:     (*conn).getlock,
+	treadlink:    (*conn).readlink,
+	tsymlink:     (*conn).notSupported,
+	tmknod:       (*conn).notSupported,
+	tlink:        (*conn).notSupported,
+	txattrwalk:   (*conn).notSupported,
+	txattrcreate: (*conn).notSupported,
+}
+
+// handle runs the request and sends the reply
+func (c *conn) handle(typ uint8, tag uint16, body []byte, done chan struct{}) {
+	var err error
+	e := newMessage(typ+1, tag)
+	fn := handlers[typ]
+	if fn == nil {
+		fs.Debugf(c.what, "Unsupported message type %d", typ)
+		err = eOPNOTSUPP
+	} else {
+		d := &decoder{b: body}
+		err = fn(c, d, e)
+		if err == nil && d.err != nil {
+			err = eINVAL
+		}
+	}
+	if err != nil {
+		errNo := c.mapError(err)
+		fs.Debugf(c.what, "Message type %d failed: %v (errno %d)", typ, err, errNo)
+		e = newMessage(rlerror, tag)
+		e.u32(uint32(errNo))
+	}
+	c.reply(e.bytes(), done)
+}
+
+// mapError translates errors from the VFS into Linux error numbers
+func (c *conn) mapError(err error) errno {
+	var e errno
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, errShortMessage) {
+		return eINVAL
+	}
+	_, uErr := fserrors.Cause(err)
+	switch uErr {
+	case vfs.OK:
+		return 0
+	case vfs.ENOENT, fs.ErrorDirNotFound, fs.ErrorObjectNotFound:
+		return eNOENT
+	case vfs.EEXIST, fs.ErrorDirExists:
+		return eEXIST
+	case vfs.EPERM, fs.ErrorPermissionDenied:
+		return ePERM
+	case vfs.ECLOSED:
+		return eBADF
+	case vfs.ENOTEMPTY:
+		return eNOTEMPTY
+	case vfs.ESPIPE:
+		return eSPIPE
+	case vfs.EBADF:
+		return eBADF
+	case vfs.EROFS:
+		return eROFS
+	case vfs.ENOSYS, fs.ErrorNotImplemented:
+		return eNOSYS
+	case vfs.EINVAL:
+		return eINVAL
+	case vfs.ELOOP:
+		return eLOOP
+	case vfs.EAGAIN:
+		return eAGAIN
+	case vfs.ENOATTR:
+		return eNODATA
+	case vfs.ENOTSUP:
+		return eOPNOTSUPP
+	}
+	fs.Errorf(c.what, "IO error: %v", err)
+	return eIO
+}
@@ -49,9 +49,10 @@ This config generated must have this extra parameter
 
 - |_root| - root to use for the backend
 
-And it may have this parameter
+And it may have these parameters
 
 - |_obscure| - comma separated strings for parameters to obscure
+- |_password| - the password for the |user| (see below)
 
 If password authentication was used by the client, input to the proxy
 process (on STDIN) would look similar to this:
@@ -73,6 +74,11 @@ proxy process (on STDIN) would look similar to this:
 }
 |||
 
+If the protocol never sends the password to the server, as is the case
+for |rclone serve smb|, then the input will only contain the |user|.
+The proxy must then return the password for the |user| in
+|_password| which rclone uses to check the login.
+
 And as an example return this on STDOUT
 
 |||json
@@ -138,8 +144,9 @@ type Proxy struct {
 
 // cacheEntry is what is stored in the vfsCache
 type cacheEntry struct {
-	vfs    *vfs.VFS          // stored VFS
-	pwHash [sha256.Size]byte // sha256 hash of the password/publicKey
+	vfs      *vfs.VFS          // stored VFS
+	pwHash   [sha256.Size]byte // sha256 hash of the password/publicKey
+	password string            // password returned by the proxy for CallVerify
 }
 
 // New creates a new proxy with the Options passed in
@@ -216,6 +223,17 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error)
 		return nil, err
 	}
 
+	// We hash the auth here so we don't copy the auth more than we
+	// need to in memory. An attacker would find it easier to go
+	// after the unencrypted password in memory most likely.
+	return p.newEntry(user, config, cacheEntry{
+		pwHash: sha256.Sum256([]byte(auth)),
+	})
+}
+
+// newEntry makes the backend described by config and stores it in
+// the VFS cache under user using entry as a template
+func (p *Proxy) newEntry(user string, config configmap.Simple, entry cacheEntry) (value any, err error) {
 	// Look for required fields in the answer
 	fsName, ok := config.Get("type")
 	if !ok {
@@ -252,14 +270,7 @@ func (p *Proxy) call(user, auth string, isPublicKey bool) (value any, err error)
 		if err != nil {
 			return nil, false, err
 		}
-
-		// We hash the auth here so we don't copy the auth more than we
-		// need to in memory. An attacker would find it easier to go
-		// after the unencrypted password in memory most likely.
-		entry := cacheEntry{
-			vfs:    vfs.New(f, &p.vfsOpt),
-			pwHash: sha256.Sum256([]byte(auth)),
-		}
+		entry.vfs = vfs.New(f, &p.vfsOpt)
 		return entry, true, nil
 	})
 	if err != nil {
@@ -304,6 +315,58 @@ func (p *Proxy) Call(user, auth string, isPublicKey bool) (VFS *vfs.VFS, vfsKey
 	return entry.vfs, user, nil
 }
 
+// CallVerify runs the auth proxy with just the username for
+// protocols, like SMB, where the client never sends the password.
+//
+// The proxy must return the password for the user in the "_password"
+// parameter and this is passed to verify to check the credentials
+// the client sent. It returns a *vfs.VFS and the key used in the VFS
+// cache.
+func (p *Proxy) CallVerify(user string, verify func(password string) bool) (VFS *vfs.VFS, vfsKey string, err error) {
+	// Look in the cache first
+	value, ok := p.vfsCache.GetMaybe(user)
+
+	// If not found then call the proxy for a fresh answer
+	if !ok {
+		config, err := p.run(map[string]string{
+			"user": user,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		password, ok := config.Get("_password")
+		if !ok {
+			return nil, "", errors.New("proxy: _password not set in result")
+		}
+		delete(config, "_password")
+
+		// Check the password before creating the backend
+		if !verify(password) {
+			return nil, "", errors.New("proxy: incorrect password")
+		}
+		value, err = p.newEntry(user, config, cacheEntry{
+			password: password,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// check we got what we were expecting
+	entry, ok := value.(cacheEntry)
+	if !ok {
+		return nil, "", fmt.Errorf("proxy: value is not cache entry: %#v", value)
+	}
+
+	// Check the credentials against the cached entry. If it was
+	// made by Call it has no password so can't be used here.
+	if entry.password == "" || !verify(entry.password) {
+		return nil, "", errors.New("proxy: incorrect password")
+	}
+
+	return entry.vfs, user, nil
+}
+
 // Get VFS from the cache using key - returns nil if not found
 func (p *Proxy) Get(key string) *vfs.VFS {
 	value, ok := p.vfsCache.GetMaybe(key)
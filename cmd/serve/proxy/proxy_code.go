@@ -34,6 +34,10 @@ func main() {
 	if out["_root"] == "" {
 		out["_root"] = ""
 	}
+	// If no auth was passed in return the password as for CallVerify
+	if in["pass"] == "" && in["public_key"] == "" {
+		out["_password"] = "testPass"
+	}
 	json.NewEncoder(os.Stdout).Encode(&out)
 	if err != nil {
 		log.Fatal(err)
@@ -148,6 +148,74 @@ func TestRun(t *testing.T) {
 
 	})
 
+	t.Run("CallVerify", func(t *testing.T) {
+		// check cache empty
+		assert.Equal(t, 0, p.vfsCache.Entries())
+		defer p.vfsCache.Clear()
+
+		var gotPassword string
+		verify := func(password string) bool {
+			gotPassword = password
+			return password == testPass
+		}
+		vfs, vfsKey, err := p.CallVerify(testUser, verify)
+		require.NoError(t, err)
+		require.NotNil(t, vfs)
+		assert.Equal(t, testPass, gotPassword)
+		assert.Equal(t, "proxy-"+testUser, vfs.Fs().Name())
+		assert.Equal(t, testUser, vfsKey)
+
+		// check it is in the cache
+		assert.Equal(t, 1, p.vfsCache.Entries())
+		assert.Equal(t, vfs, p.Get(testUser))
+
+		// now try again from the cache
+		gotPassword = ""
+		vfs, vfsKey, err = p.CallVerify(testUser, verify)
+		require.NoError(t, err)
+		require.NotNil(t, vfs)
+		assert.Equal(t, testPass, gotPassword)
+		assert.Equal(t, testUser, vfsKey)
+
+		// now try again from the cache with the wrong credentials
+		vfs, vfsKey, err = p.CallVerify(testUser, func(string) bool { return false })
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "incorrect password")
+		require.Nil(t, vfs)
+		require.Equal(t, "", vfsKey)
+
+		// check cache is at the same level
+		assert.Equal(t, 1, p.vfsCache.Entries())
+	})
+
+	t.Run("CallVerify w/wrong password", func(t *testing.T) {
+		// check cache empty
+		assert.Equal(t, 0, p.vfsCache.Entries())
+		defer p.vfsCache.Clear()
+
+		vfs, _, err := p.CallVerify(testUser, func(string) bool { return false })
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "incorrect password")
+		require.Nil(t, vfs)
+
+		// check nothing was cached
+		assert.Equal(t, 0, p.vfsCache.Entries())
+	})
+
+	t.Run("CallVerify after Call", func(t *testing.T) {
+		// check cache empty
+		assert.Equal(t, 0, p.vfsCache.Entries())
+		defer p.vfsCache.Clear()
+
+		_, _, err := p.Call(testUser, testPass, false)
+		require.NoError(t, err)
+
+		// The cached entry has no password to verify against
+		vfs, _, err := p.CallVerify(testUser, func(string) bool { return true })
+		require.Error(t, err)
+		require.Nil(t, vfs)
+	})
+
 	privateKey, privateKeyErr := rsa.GenerateKey(rand.Reader, 2048)
 	if privateKeyErr != nil {
 		fs.Fatal(nil, "error generating test private key "+privateKeyErr.Error())
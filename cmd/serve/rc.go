@@ -75,7 +75,7 @@ func init() {
 
 This takes the following parameters:
 
-- |type| - type of server: |http|, |webdav|, |ftp|, |sftp|, |nfs|, etc.
+- |type| - type of server: |http|, |webdav|, |ftp|, |sftp|, |nfs|, |smb|, |9p|, etc.
 - |fs| - remote storage path to serve
 - |addr| - the ip:port to run the server on, eg ":1234" or "localhost:1234"
 
@@ -25,8 +25,9 @@ var (
 // s3Backend implements the gofacess3.Backend interface to make an S3
 // backend for gofakes3
 type s3Backend struct {
-	s    *Server
-	meta *sync.Map
+	s            *Server
+	meta         *sync.Map
+	versionLocks keyLocks
 }
 
 // newBackend creates a new SimpleBucketBackend.
@@ -50,6 +51,9 @@ func (b *s3Backend) ListBuckets(ctx context.Context) ([]gofakes3.BucketInfo, err
 	var response []gofakes3.BucketInfo
 	for _, entry := range dirEntries {
 		if entry.IsDir() {
+			if b.s.opt.Versioning == versioningDir && entry.Name() == b.s.opt.VersionsDir {
+				continue
+			}
 			response = append(response, gofakes3.BucketInfo{
 				Name:         entry.Name(),
 				CreationDate: gofakes3.NewContentTime(entry.ModTime()),
@@ -67,7 +71,7 @@ func (b *s3Backend) ListBucket(ctx context.Context, bucket string, prefix *gofak
 	if err != nil {
 		return nil, err
 	}
-	_, err = _vfs.Stat(bucket)
+	_, err = b.statBucket(_vfs, bucket)
 	if err != nil {
 		return nil, gofakes3.BucketNotFound(bucket)
 	}
@@ -112,7 +116,7 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 	if err != nil {
 		return nil, err
 	}
-	_, err = _vfs.Stat(bucketName)
+	_, err = b.statBucket(_vfs, bucketName)
 	if err != nil {
 		return nil, gofakes3.BucketNotFound(bucketName)
 	}
@@ -123,6 +127,22 @@ func (b *s3Backend) HeadObject(ctx context.Context, bucketName, objectName strin
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
+	obj, err := b.headNode(node, fp, objectName)
+	if err != nil {
+		return nil, err
+	}
+	if b.s.opt.Versioning != versioningOff {
+		obj.VersionID, err = b.currentID(_vfs, bucketName, objectName, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// headNode returns the fileinfo for node at fp in the VFS for the
+// object name.
+func (b *s3Backend) headNode(node vfs.Node, fp, objectName string) (*gofakes3.Object, error) {
 	if !node.IsFile() {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
@@ -161,7 +181,7 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 	if err != nil {
 		return nil, err
 	}
-	_, err = _vfs.Stat(bucketName)
+	_, err = b.statBucket(_vfs, bucketName)
 	if err != nil {
 		return nil, gofakes3.BucketNotFound(bucketName)
 	}
@@ -172,6 +192,21 @@ func (b *s3Backend) GetObject(ctx context.Context, bucketName, objectName string
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
+	obj, err = b.getNode(node, fp, objectName, rangeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if b.s.opt.Versioning != versioningOff {
+		obj.VersionID, err = b.currentID(_vfs, bucketName, objectName, node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// getNode fetches node at fp in the VFS for the object name.
+func (b *s3Backend) getNode(node vfs.Node, fp, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (obj *gofakes3.Object, err error) {
 	if !node.IsFile() {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
@@ -286,17 +321,51 @@ func (b *s3Backend) TouchObject(ctx context.Context, fp string, meta map[string]
 }
 
 // PutObject creates or overwrites the object with the given name.
+//
+// If versioning is on the version it replaces is kept.
 func (b *s3Backend) PutObject(
 	ctx context.Context,
 	bucketName, objectName string,
 	meta map[string]string,
 	input io.Reader, size int64,
+) (result gofakes3.PutObjectResult, err error) {
+	if b.s.opt.Versioning == versioningOff {
+		return b.putObject(ctx, bucketName, objectName, meta, input, size)
+	}
+	_vfs, err := b.s.getVFS(ctx)
+	if err != nil {
+		return result, err
+	}
+	fp := path.Join(bucketName, objectName)
+	defer b.versionLocks.lock(fp)()
+	archived, err := b.archive(_vfs, bucketName, objectName)
+	if err != nil {
+		return result, err
+	}
+	result, err = b.putObject(ctx, bucketName, objectName, meta, input, size)
+	if err != nil {
+		if archived != "" {
+			// put the old version back
+			_ = _vfs.Rename(archived, fp)
+		}
+		return result, err
+	}
+	result.VersionID, err = b.markWritten(_vfs, bucketName, objectName, time.Now())
+	return result, err
+}
+
+// putObject creates or overwrites the object with the given name.
+func (b *s3Backend) putObject(
+	ctx context.Context,
+	bucketName, objectName string,
+	meta map[string]string,
+	input io.Reader, size int64,
 ) (result gofakes3.PutObjectResult, err error) {
 	_vfs, err := b.s.getVFS(ctx)
 	if err != nil {
 		return result, err
 	}
-	_, err = _vfs.Stat(bucketName)
+	_, err = b.statBucket(_vfs, bucketName)
 	if err != nil {
 		return result, gofakes3.BucketNotFound(bucketName)
 	}
@@ -361,7 +430,7 @@ func (b *s3Backend) PutObject(
 // DeleteMulti deletes multiple objects in a single request.
 func (b *s3Backend) DeleteMulti(ctx context.Context, bucketName string, objects ...string) (result gofakes3.MultiDeleteResult, rerr error) {
 	for _, object := range objects {
-		if err := b.deleteObject(ctx, bucketName, object); err != nil {
+		if _, err := b.deleteObject(ctx, bucketName, object); err != nil {
 			fs.Errorf("serve s3", "delete object failed: %v", err)
 			result.Error = append(result.Error, gofakes3.ErrorResult{
 				Code:    gofakes3.ErrInternal,
@@ -380,30 +449,40 @@ func (b *s3Backend) DeleteMulti(ctx context.Context, bucketName string, objects
 
 // DeleteObject deletes the object with the given name.
 func (b *s3Backend) DeleteObject(ctx context.Context, bucketName, objectName string) (result gofakes3.ObjectDeleteResult, rerr error) {
-	return result, b.deleteObject(ctx, bucketName, objectName)
+	return b.deleteObject(ctx, bucketName, objectName)
 }
 
 // deleteObject deletes the object from the filesystem.
-func (b *s3Backend) deleteObject(ctx context.Context, bucketName, objectName string) error {
+//
+// If versions are kept in the versions directory the object is moved
+// there and a delete marker is added instead.
+func (b *s3Backend) deleteObject(ctx context.Context, bucketName, objectName string) (result gofakes3.ObjectDeleteResult, err error) {
 	_vfs, err := b.s.getVFS(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
-	_, err = _vfs.Stat(bucketName)
+	_, err = b.statBucket(_vfs, bucketName)
 	if err != nil {
-		return gofakes3.BucketNotFound(bucketName)
+		return result, gofakes3.BucketNotFound(bucketName)
 	}
 
 	fp := path.Join(bucketName, objectName)
-	// S3 does not report an error when attempting to delete a key that does not exist, so
-	// we need to skip IsNotExist errors.
-	if err := _vfs.Remove(fp); err != nil && !os.IsNotExist(err) {
-		return err
+	if b.s.opt.Versioning == versioningDir {
+		unlock := b.versionLocks.lock(fp)
+		result, err = b.addDeleteMarker(_vfs, bucketName, objectName)
+		unlock()
+		if err != nil {
+			return result, err
+		}
+	} else if err := _vfs.Remove(fp); err != nil && !os.IsNotExist(err) {
+		// S3 does not report an error when attempting to delete a key that does not exist, so
+		// we need to skip IsNotExist errors.
+		return result, err
 	}
 
 	// FIXME: unsafe operation
 	rmdirRecursive(fp, _vfs)
-	return nil
+	return result, nil
 }
 
 // CreateBucket creates a new bucket.
@@ -417,7 +496,8 @@ func (b *s3Backend) CreateBucket(ctx context.Context, name string) error {
 		return gofakes3.ErrInternal
 	}
 
-	if err == nil {
+	// the versions directory can't be used as a bucket
+	if err == nil || (b.s.opt.Versioning == versioningDir && name == b.s.opt.VersionsDir) {
 		return gofakes3.ErrBucketAlreadyExists
 	}
 
@@ -433,7 +513,7 @@ func (b *s3Backend) DeleteBucket(ctx context.Context, name string) error {
 	if err != nil {
 		return err
 	}
-	_, err = _vfs.Stat(name)
+	_, err = b.statBucket(_vfs, name)
 	if err != nil {
 		return gofakes3.BucketNotFound(name)
 	}
@@ -451,7 +531,7 @@ func (b *s3Backend) BucketExists(ctx context.Context, name string) (exists bool,
 	if err != nil {
 		return false, err
 	}
-	_, err = _vfs.Stat(name)
+	_, err = b.statBucket(_vfs, name)
 	if err != nil {
 		return false, nil
 	}
@@ -465,6 +545,9 @@ func (b *s3Backend) CopyObject(ctx context.Context, srcBucket, srcKey, dstBucket
 	if err != nil {
 		return result, err
 	}
+	if _, err = b.statBucket(_vfs, srcBucket); err != nil {
+		return result, gofakes3.BucketNotFound(srcBucket)
+	}
 	fp := path.Join(srcBucket, srcKey)
 	if srcBucket == dstBucket && srcKey == dstKey {
 		b.meta.Store(fp, meta)
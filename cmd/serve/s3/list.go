@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/lib/version"
 	"github.com/rclone/rclone/vfs"
 )
 
@@ -26,6 +27,11 @@ func (b *s3Backend) entryListR(_vfs *vfs.VFS, bucket, fdPath, name string, addPr
 			continue
 		}
 
+		// hide the old versions shown by the remote
+		if b.s.opt.Versioning == versioningRemote && !entry.IsDir() && version.Match(object) {
+			continue
+		}
+
 		if entry.IsDir() {
 			if addPrefix {
 				prefixWithTrailingSlash := objectPath + "/"
@@ -37,6 +37,14 @@ var OptionsInfo = fs.Options{{
 	Name:    "no_cleanup",
 	Default: false,
 	Help:    "Not to cleanup empty folder after object is deleted",
+}, {
+	Name:    "versioning",
+	Default: versioningOff,
+	Help:    "Keep versions of objects - off, remote or dir",
+}, {
+	Name:    "versions_dir",
+	Default: ".versions",
+	Help:    "Directory in the root to keep old versions in with --versioning dir",
 }}.
 	Add(httplib.ConfigInfo).
 	Add(httplib.AuthConfigInfo)
@@ -44,10 +52,12 @@ var OptionsInfo = fs.Options{{
 // Options contains options for the s3 Server
 type Options struct {
 	//TODO add more options
-	ForcePathStyle bool     `config:"force_path_style"`
-	EtagHash       string   `config:"etag_hash"`
-	AuthKey        []string `config:"auth_key"`
-	NoCleanup      bool     `config:"no_cleanup"`
+	ForcePathStyle bool           `config:"force_path_style"`
+	EtagHash       string         `config:"etag_hash"`
+	AuthKey        []string       `config:"auth_key"`
+	NoCleanup      bool           `config:"no_cleanup"`
+	Versioning     versioningMode `config:"versioning"`
+	VersionsDir    string         `config:"versions_dir"`
 	Auth           httplib.AuthConfig
 	HTTP           httplib.Config
 }
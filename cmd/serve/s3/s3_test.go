@@ -9,9 +9,12 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"os"
 	"path"
 	"path/filepath"
 	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,9 +41,13 @@ const (
 
 // Configure and serve the server
 func serveS3(t *testing.T, f fs.Fs) (testURL string, keyid string, keysec string, w *Server) {
+	return serveS3Opt(t, f, Opt)
+}
+
+// Configure and serve the server with opt
+func serveS3Opt(t *testing.T, f fs.Fs, opt Options) (testURL string, keyid string, keysec string, w *Server) {
 	keyid = random.String(16)
 	keysec = random.String(16)
-	opt := Opt // copy default options
 	opt.AuthKey = []string{fmt.Sprintf("%s,%s", keyid, keysec)}
 	opt.HTTP.ListenAddr = []string{endpoint}
 	w, _ = newServer(context.Background(), f, &opt, &vfscommon.Opt, &proxy.Opt)
@@ -291,6 +298,222 @@ func TestListBucketsAuthProxy(t *testing.T) {
 	testListBuckets(t, cases, true)
 }
 
+func TestVersioning(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "bucket"), 0777))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	opt := Opt
+	opt.Versioning = versioningDir
+	opt.VersionsDir = "versions" // a valid bucket name
+	endpoint, keyid, keysec, s := serveS3Opt(t, f, opt)
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+	testURL, _ := url.Parse(endpoint)
+	client, err := minio.New(testURL.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(keyid, keysec, ""),
+		Secure: false,
+	})
+	require.NoError(t, err)
+
+	// Versioning is always on
+	config, err := client.GetBucketVersioning(ctx, "bucket")
+	require.NoError(t, err)
+	assert.True(t, config.Enabled())
+	require.NoError(t, client.EnableVersioning(ctx, "bucket"))
+	assert.Error(t, client.SuspendVersioning(ctx, "bucket"))
+
+	put := func(contents string) string {
+		info, err := client.PutObject(ctx, "bucket", "dir/file.txt", strings.NewReader(contents), int64(len(contents)), minio.PutObjectOptions{})
+		require.NoError(t, err)
+		require.NotEmpty(t, info.VersionID)
+		return info.VersionID
+	}
+	get := func(versionID string) string {
+		obj, err := client.GetObject(ctx, "bucket", "dir/file.txt", minio.GetObjectOptions{VersionID: versionID})
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		return string(data)
+	}
+	list := func() (versions []minio.ObjectInfo) {
+		for info := range client.ListObjects(ctx, "bucket", minio.ListObjectsOptions{Recursive: true, WithVersions: true}) {
+			require.NoError(t, info.Err)
+			versions = append(versions, info)
+		}
+		return versions
+	}
+
+	// Overwriting keeps the old version
+	v1 := put("one")
+	time.Sleep(10 * time.Millisecond)
+	v2 := put("two")
+	assert.NotEqual(t, v1, v2)
+	assert.Equal(t, "two", get(""))
+	assert.Equal(t, "one", get(v1))
+	assert.Equal(t, "two", get(v2))
+	versions := list()
+	require.Len(t, versions, 2)
+	assert.Equal(t, v2, versions[0].VersionID)
+	assert.True(t, versions[0].IsLatest)
+	assert.Equal(t, v1, versions[1].VersionID)
+	assert.False(t, versions[1].IsLatest)
+
+	// The versions directory isn't a bucket
+	buckets, err := client.ListBuckets(ctx)
+	require.NoError(t, err)
+	require.Len(t, buckets, 1)
+	assert.Equal(t, "bucket", buckets[0].Name)
+
+	// Deleting leaves a delete marker
+	require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/file.txt", minio.RemoveObjectOptions{}))
+	_, err = client.StatObject(ctx, "bucket", "dir/file.txt", minio.StatObjectOptions{})
+	assert.Error(t, err)
+	versions = list()
+	require.Len(t, versions, 3)
+	assert.True(t, versions[0].IsDeleteMarker)
+	assert.Equal(t, "two", get(v2))
+
+	// Deleting the delete marker restores the previous version
+	require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/file.txt", minio.RemoveObjectOptions{VersionID: versions[0].VersionID}))
+	assert.Equal(t, "two", get(""))
+
+	// Deleting the current version makes the older one current
+	require.NoError(t, client.RemoveObject(ctx, "bucket", "dir/file.txt", minio.RemoveObjectOptions{VersionID: v2}))
+	assert.Equal(t, "one", get(""))
+	versions = list()
+	require.Len(t, versions, 1)
+	assert.Equal(t, v1, versions[0].VersionID)
+
+	// The versions directory can't be read as a bucket
+	exists, err := client.BucketExists(ctx, "versions")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	for info := range client.ListObjects(ctx, "versions", minio.ListObjectsOptions{Recursive: true}) {
+		assert.Error(t, info.Err)
+	}
+	_, err = client.StatObject(ctx, "versions", "bucket/dir/"+path.Base(versions[0].Key), minio.StatObjectOptions{})
+	assert.Error(t, err)
+	assert.Error(t, client.MakeBucket(ctx, "versions", minio.MakeBucketOptions{}))
+
+	// Concurrent writes all keep their versions
+	var wg sync.WaitGroup
+	for i := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			contents := fmt.Sprint(i)
+			_, err := client.PutObject(ctx, "bucket", "dir/file.txt", strings.NewReader(contents), int64(len(contents)), minio.PutObjectOptions{})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	versions = list()
+	assert.Len(t, versions, 6)
+
+	// Listings in pages give the same versions
+	for _, key := range []string{"a.txt", "dir/a.txt", "dir/sub/b.txt", "z.txt"} {
+		_, err := client.PutObject(ctx, "bucket", key, strings.NewReader(key), int64(len(key)), minio.PutObjectOptions{})
+		require.NoError(t, err)
+	}
+	versions = list()
+	require.Len(t, versions, 10)
+	var paged []minio.ObjectInfo
+	for info := range client.ListObjects(ctx, "bucket", minio.ListObjectsOptions{Recursive: true, WithVersions: true, MaxKeys: 1}) {
+		require.NoError(t, info.Err)
+		paged = append(paged, info)
+	}
+	require.Len(t, paged, len(versions))
+	for i := range versions {
+		assert.Equal(t, versions[i].Key, paged[i].Key)
+		assert.Equal(t, versions[i].VersionID, paged[i].VersionID)
+		assert.Equal(t, versions[i].IsLatest, paged[i].IsLatest)
+	}
+	assert.Equal(t, "a.txt", versions[0].Key)
+	assert.Equal(t, "dir/a.txt", versions[1].Key)
+	assert.Equal(t, "z.txt", versions[9].Key)
+
+	// Versions are ordered by when they were written, not by the
+	// modification times the client set which are left alone
+	var ids []string
+	for _, mtime := range []int64{1044245106, 981173106, 1012709106} {
+		contents := fmt.Sprint(mtime)
+		info, err := client.PutObject(ctx, "bucket", "mtime.txt", strings.NewReader(contents), int64(len(contents)), minio.PutObjectOptions{
+			UserMetadata: map[string]string{"mtime": contents},
+		})
+		require.NoError(t, err)
+		ids = append([]string{info.VersionID}, ids...)
+		fi, err := os.Stat(filepath.Join(dir, "bucket", "mtime.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, time.Unix(mtime, 0).UTC(), fi.ModTime().UTC())
+	}
+	var got []string
+	for info := range client.ListObjects(ctx, "bucket", minio.ListObjectsOptions{Prefix: "mtime.txt", WithVersions: true}) {
+		require.NoError(t, info.Err)
+		got = append(got, info.VersionID)
+	}
+	assert.Equal(t, ids, got)
+	for i, contents := range []string{"1012709106", "981173106", "1044245106"} {
+		obj, err := client.GetObject(ctx, "bucket", "mtime.txt", minio.GetObjectOptions{VersionID: ids[i]})
+		require.NoError(t, err)
+		data, err := io.ReadAll(obj)
+		require.NoError(t, err)
+		assert.Equal(t, contents, string(data))
+	}
+	stat, err := client.StatObject(ctx, "bucket", "mtime.txt", minio.StatObjectOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ids[0], stat.VersionID)
+}
+
+func TestVersioningRemote(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "bucket"), 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bucket", "file.txt"), []byte("new"), 0666))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bucket", "file-v2001-02-03-040506-000.txt"), []byte("old"), 0666))
+	f, err := fs.NewFs(ctx, dir)
+	require.NoError(t, err)
+
+	opt := Opt
+	opt.Versioning = versioningRemote
+	endpoint, keyid, keysec, s := serveS3Opt(t, f, opt)
+	defer func() {
+		assert.NoError(t, s.server.Shutdown())
+	}()
+	testURL, _ := url.Parse(endpoint)
+	client, err := minio.New(testURL.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(keyid, keysec, ""),
+		Secure: false,
+	})
+	require.NoError(t, err)
+
+	// The old versions are hidden from the listing
+	var keys []string
+	for info := range client.ListObjects(ctx, "bucket", minio.ListObjectsOptions{Recursive: true}) {
+		require.NoError(t, info.Err)
+		keys = append(keys, info.Key)
+	}
+	assert.Equal(t, []string{"file.txt"}, keys)
+
+	// But are shown as versions
+	var versions []string
+	for info := range client.ListObjects(ctx, "bucket", minio.ListObjectsOptions{Recursive: true, WithVersions: true}) {
+		require.NoError(t, info.Err)
+		assert.Equal(t, "file.txt", info.Key)
+		versions = append(versions, info.VersionID)
+	}
+	require.Len(t, versions, 2)
+	assert.Equal(t, "v2001-02-03-040506-000", versions[1])
+	obj, err := client.GetObject(ctx, "bucket", "file.txt", minio.GetObjectOptions{VersionID: versions[1]})
+	require.NoError(t, err)
+	data, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+}
+
 func TestRc(t *testing.T) {
 	servetest.TestRc(t, rc.Params{
 		"type":           "s3",
@@ -64,6 +64,11 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 		fs.Debugf(f, "Using hash %v for ETag", w.etagHashType)
 	}
 
+	err = checkVersioning(&w.opt, proxyOpt.AuthProxy != "")
+	if err != nil {
+		return nil, err
+	}
+
 	if len(opt.AuthKey) == 0 {
 		fs.Logf("serve s3", "No auth provided so allowing anonymous access")
 	} else {
@@ -71,15 +76,17 @@ func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	}
 
 	var newLogger logger
-	w.faker = gofakes3.New(
-		newBackend(w),
+	options := []gofakes3.Option{
 		gofakes3.WithHostBucket(!opt.ForcePathStyle),
 		gofakes3.WithLogger(newLogger),
 		gofakes3.WithRequestID(rand.Uint64()),
-		gofakes3.WithoutVersioning(),
 		gofakes3.WithV4Auth(authlistResolver(opt.AuthKey)),
 		gofakes3.WithIntegrityCheck(true), // Check Content-MD5 if supplied
-	)
+	}
+	if w.opt.Versioning == versioningOff {
+		options = append(options, gofakes3.WithoutVersioning())
+	}
+	w.faker = gofakes3.New(newBackend(w), options...)
 
 	w.handler = w.faker.Server()
 
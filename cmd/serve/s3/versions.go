@@ -0,0 +1,829 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/gofakes3"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/version"
+	"github.com/rclone/rclone/vfs"
+)
+
+type versioningChoices struct{}
+
+func (versioningChoices) Choices() []string {
+	return []string{
+		versioningOff:    "off",
+		versioningRemote: "remote",
+		versioningDir:    "dir",
+	}
+}
+
+// Type of the value
+func (versioningChoices) Type() string {
+	return "Versioning"
+}
+
+// versioningMode chooses how object versions are kept
+type versioningMode = fs.Enum[versioningChoices]
+
+// versioningMode options
+const (
+	versioningOff    versioningMode = iota // no versions
+	versioningRemote                       // versions shown in the listings of the remote
+	versioningDir                          // versions kept in --versions-dir
+)
+
+// deleteMarkerSuffix is added to the name of an object to make the
+// name of its delete markers in the versions directory
+const deleteMarkerSuffix = ".delete-marker"
+
+// writtenMarkerSuffix is added to the name of an object to make the
+// name of the marker in the versions directory which records when the
+// current version of the object was written and so its version ID
+const writtenMarkerSuffix = ".written"
+
+// nullVersionID is the version ID S3 uses for objects without one
+const nullVersionID gofakes3.VersionID = "null"
+
+// checkVersioning checks the versioning options are usable
+func checkVersioning(opt *Options, useProxy bool) error {
+	if opt.Versioning == versioningOff {
+		return nil
+	}
+	if useProxy {
+		return errors.New("--versioning can't be used with --auth-proxy")
+	}
+	if opt.Versioning == versioningDir {
+		dir := strings.Trim(opt.VersionsDir, "/")
+		if dir == "" || strings.Contains(dir, "/") {
+			return errors.New("--versions-dir must be a directory in the root of the remote")
+		}
+		opt.VersionsDir = dir
+	}
+	return nil
+}
+
+// versionID returns the version ID for a version made at t
+//
+// This is the version string lib/version puts in file names, for
+// example "v2006-01-02-150405-000".
+func versionID(t time.Time) gofakes3.VersionID {
+	return gofakes3.VersionID(strings.TrimPrefix(version.Add("", t.UTC()), "-"))
+}
+
+// objectVersion is a version of an object
+type objectVersion struct {
+	key     string             // key of the object in the bucket
+	id      gofakes3.VersionID // version ID
+	fp      string             // path of the version in the VFS
+	node    vfs.Node           // the version
+	modTime time.Time          // when the version was written
+	marker  bool               // set if this is a delete marker
+	written bool               // set if this is the written marker of the current version
+	current bool               // set if this is the object itself
+}
+
+// byNewest sorts versions of objects by key then newest first with
+// the object itself before its versions
+type byNewest []objectVersion
+
+func (v byNewest) Len() int      { return len(v) }
+func (v byNewest) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byNewest) Less(i, j int) bool {
+	if v[i].key != v[j].key {
+		return v[i].key < v[j].key
+	}
+	if v[i].current != v[j].current {
+		return v[i].current
+	}
+	return v[i].modTime.After(v[j].modTime)
+}
+
+// statBucket returns the directory of bucket in the VFS or an error
+// if there isn't one. The directory which holds the versions isn't a
+// bucket.
+func (b *s3Backend) statBucket(_vfs *vfs.VFS, bucket string) (vfs.Node, error) {
+	if b.s.opt.Versioning == versioningDir && bucket == b.s.opt.VersionsDir {
+		return nil, vfs.ENOENT
+	}
+	return _vfs.Stat(bucket)
+}
+
+// keyLocks serialises the changes to the versions of each object so
+// concurrent changes don't archive the same version or lose one
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// keyLock is the lock for one object
+type keyLock struct {
+	mu    sync.Mutex
+	users int // number of users holding or waiting for mu
+}
+
+// lock locks the object at fp and returns a function to unlock it
+func (k *keyLocks) lock(fp string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l := k.locks[fp]
+	if l == nil {
+		l = &keyLock{}
+		k.locks[fp] = l
+	}
+	l.users++
+	k.mu.Unlock()
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		k.mu.Lock()
+		l.users--
+		if l.users == 0 {
+			delete(k.locks, fp)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// versionsDir returns the directory in the VFS which holds the old
+// versions of the objects in the directory dir of bucket
+func (b *s3Backend) versionsDir(bucket, dir string) string {
+	if b.s.opt.Versioning == versioningDir {
+		return path.Join(b.s.opt.VersionsDir, bucket, dir)
+	}
+	return path.Join(bucket, dir)
+}
+
+// parseVersion returns the version of the object in dir of the
+// bucket which node in the versions directory holds, or false if it
+// doesn't hold one
+func (b *s3Backend) parseVersion(dir string, node vfs.Node) (v objectVersion, ok bool) {
+	if !node.IsFile() {
+		return v, false
+	}
+	t, leaf := version.Remove(node.Name())
+	if t.IsZero() {
+		return v, false
+	}
+	if b.s.opt.Versioning == versioningDir {
+		leaf, v.marker = strings.CutSuffix(leaf, deleteMarkerSuffix)
+		if !v.marker {
+			leaf, v.written = strings.CutSuffix(leaf, writtenMarkerSuffix)
+		}
+	}
+	v.key = path.Join(dir, leaf)
+	v.id = versionID(t)
+	v.fp = node.Path()
+	v.node = node
+	v.modTime = t
+	return v, true
+}
+
+// uploadTime returns when the object in node was uploaded to the
+// remote, which is the time the remote names its old versions with,
+// or its modification time if the remote doesn't say.
+func uploadTime(node vfs.Node) time.Time {
+	if o, ok := node.DirEntry().(fs.Object); ok {
+		metadata, err := fs.GetMetadata(context.Background(), o)
+		if err != nil {
+			fs.Debugf(o, "Failed to read upload time: %v", err)
+		} else if t, err := time.Parse(time.RFC3339Nano, metadata["btime"]); err == nil {
+			return t
+		}
+	}
+	return node.ModTime()
+}
+
+// currentVersion returns the object itself as a version.
+//
+// Its version ID is the time it was written which is read from the
+// remote with --versioning remote and from written, its written
+// marker if it has one, with --versioning dir.
+func (b *s3Backend) currentVersion(_vfs *vfs.VFS, key string, node vfs.Node, written *objectVersion) objectVersion {
+	var t time.Time
+	if b.s.opt.Versioning == versioningRemote {
+		t = uploadTime(node)
+	} else if written != nil && writtenMatches(_vfs, *written, node) {
+		t = written.modTime
+	} else {
+		// written outside serve s3 so the best guess is its modification time
+		t = node.ModTime()
+	}
+	return objectVersion{
+		key:     key,
+		id:      versionID(t),
+		fp:      node.Path(),
+		node:    node,
+		modTime: t,
+		current: true,
+	}
+}
+
+// currentID returns the version ID of the object at key in bucket
+// whose node is node
+func (b *s3Backend) currentID(_vfs *vfs.VFS, bucket, key string, node vfs.Node) (gofakes3.VersionID, error) {
+	if b.s.opt.Versioning != versioningDir {
+		return versionID(uploadTime(node)), nil
+	}
+	_, written, err := b.readVersions(_vfs, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	return b.currentVersion(_vfs, key, node, newestWritten(written)).id, nil
+}
+
+// writtenMatches returns true if the written marker still describes
+// the object in node.
+//
+// The marker is given the modification time of the object when it is
+// made so a different modification time means the object has been
+// changed without serve s3.
+func writtenMatches(_vfs *vfs.VFS, written objectVersion, node vfs.Node) bool {
+	dt := node.ModTime().Sub(written.node.ModTime())
+	if dt < 0 {
+		dt = -dt
+	}
+	return dt <= max(_vfs.Fs().Precision(), time.Millisecond)
+}
+
+// objectVersions returns the versions of the object at key in
+// bucket, newest first
+func (b *s3Backend) objectVersions(_vfs *vfs.VFS, bucket, key string) (versions []objectVersion, err error) {
+	versions, _, err = b.readVersions(_vfs, bucket, key)
+	return versions, err
+}
+
+// readVersions returns the versions of the object at key in bucket,
+// newest first, and the written markers of the object
+func (b *s3Backend) readVersions(_vfs *vfs.VFS, bucket, key string) (versions, written []objectVersion, err error) {
+	node, err := _vfs.Stat(path.Join(bucket, key))
+	if err != nil && err != vfs.ENOENT {
+		return nil, nil, err
+	}
+	dir := path.Dir(key)
+	if dir == "." {
+		dir = ""
+	}
+	dirEntries, err := getDirEntries(b.versionsDir(bucket, dir), _vfs)
+	if err == gofakes3.ErrNoSuchKey {
+		dirEntries = nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range dirEntries {
+		v, ok := b.parseVersion(dir, entry)
+		if !ok || v.key != key {
+			continue
+		}
+		if v.written {
+			written = append(written, v)
+		} else {
+			versions = append(versions, v)
+		}
+	}
+	if node != nil && node.IsFile() {
+		versions = append(versions, b.currentVersion(_vfs, key, node, newestWritten(written)))
+	}
+	sort.Sort(byNewest(versions))
+	return versions, written, nil
+}
+
+// newestWritten returns the newest of the written markers or nil if
+// there aren't any
+func newestWritten(written []objectVersion) (newest *objectVersion) {
+	for i := range written {
+		if newest == nil || written[i].modTime.After(newest.modTime) {
+			newest = &written[i]
+		}
+	}
+	return newest
+}
+
+// errStopWalk is returned by the function passed to walkVersions to
+// stop the walk
+var errStopWalk = errors.New("stop walk")
+
+// walkVersions calls fn with the versions of each object under dir in
+// bucket, newest first, in key order.
+//
+// Directories are only read when they are reached so the walk can be
+// stopped early by returning errStopWalk from fn. The directories
+// whose keys start with a prefix that skipDir returns true for aren't
+// read at all.
+func (b *s3Backend) walkVersions(_vfs *vfs.VFS, bucket, dir string, skipDir func(prefix string) bool, fn func(versions []objectVersion) error) error {
+	byKey := map[string][]objectVersion{}
+	written := map[string]*objectVersion{}
+	var names []string
+	addDir := func(name string) {
+		prefix := path.Join(dir, name) + "/"
+		if _, found := byKey[prefix]; !found {
+			byKey[prefix] = nil
+			names = append(names, prefix)
+		}
+	}
+	addVersion := func(v objectVersion) {
+		if _, found := byKey[v.key]; !found {
+			names = append(names, v.key)
+		}
+		byKey[v.key] = append(byKey[v.key], v)
+	}
+	dirEntries, err := getDirEntries(path.Join(bucket, dir), _vfs)
+	if err != nil && err != gofakes3.ErrNoSuchKey {
+		return err
+	}
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			addDir(entry.Name())
+			continue
+		}
+		if b.s.opt.Versioning == versioningRemote {
+			if v, ok := b.parseVersion(dir, entry); ok {
+				addVersion(v)
+				continue
+			}
+		}
+		addVersion(objectVersion{key: path.Join(dir, entry.Name()), node: entry, current: true})
+	}
+	if b.s.opt.Versioning == versioningDir {
+		dirEntries, err = getDirEntries(b.versionsDir(bucket, dir), _vfs)
+		if err != nil && err != gofakes3.ErrNoSuchKey {
+			return err
+		}
+		for _, entry := range dirEntries {
+			if entry.IsDir() {
+				addDir(entry.Name())
+			} else if v, ok := b.parseVersion(dir, entry); !ok {
+				continue
+			} else if v.written {
+				if old := written[v.key]; old == nil || v.modTime.After(old.modTime) {
+					written[v.key] = &v
+				}
+			} else {
+				addVersion(v)
+			}
+		}
+	}
+
+	// Keys within a directory sort in the same order as the
+	// directory names with a "/" on the end
+	sort.Strings(names)
+	for _, name := range names {
+		if prefix, ok := strings.CutSuffix(name, "/"); ok {
+			if skipDir(name) {
+				continue
+			}
+			err = b.walkVersions(_vfs, bucket, prefix, skipDir, fn)
+		} else {
+			versions := byKey[name]
+			for i, v := range versions {
+				if v.current {
+					versions[i] = b.currentVersion(_vfs, name, v.node, written[name])
+				}
+			}
+			sort.Sort(byNewest(versions))
+			err = fn(versions)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findVersion returns the version id of the object at key in bucket
+func (b *s3Backend) findVersion(_vfs *vfs.VFS, bucketName, objectName string, id gofakes3.VersionID) (v objectVersion, err error) {
+	_, err = b.statBucket(_vfs, bucketName)
+	if err != nil {
+		return v, gofakes3.BucketNotFound(bucketName)
+	}
+	versions, err := b.objectVersions(_vfs, bucketName, objectName)
+	if err != nil {
+		return v, err
+	}
+	if len(versions) == 0 {
+		return v, gofakes3.KeyNotFound(objectName)
+	}
+	for _, v := range versions {
+		if v.id == id || (id == nullVersionID && v.current) {
+			return v, nil
+		}
+	}
+	return v, gofakes3.ErrNoSuchVersion
+}
+
+// archive moves the object at key in bucket into the versions
+// directory if versions are kept there. The old version keeps the
+// version ID it had as the current version.
+//
+// It returns the path it was moved to or "" if it wasn't moved.
+func (b *s3Backend) archive(_vfs *vfs.VFS, bucket, key string) (string, error) {
+	if b.s.opt.Versioning != versioningDir {
+		return "", nil
+	}
+	versions, written, err := b.readVersions(_vfs, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 || !versions[0].current {
+		return "", nil
+	}
+	current := versions[0]
+	dst, err := b.archivePath(_vfs, bucket, key, current.modTime)
+	if err != nil {
+		return "", err
+	}
+	fs.Debugf(current.fp, "Keeping old version as %q", dst)
+	err = _vfs.Rename(current.fp, dst)
+	if err != nil {
+		return "", err
+	}
+	return dst, removeWritten(_vfs, written)
+}
+
+// removeWritten removes the written markers
+func removeWritten(_vfs *vfs.VFS, written []objectVersion) error {
+	for _, v := range written {
+		err := _vfs.Remove(v.fp)
+		if err != nil && err != vfs.ENOENT {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath returns an unused path in the versions directory for
+// the version of the object at key in bucket made at t, making the
+// directory if necessary
+func (b *s3Backend) archivePath(_vfs *vfs.VFS, bucket, key string, t time.Time) (string, error) {
+	dir := b.versionsDir(bucket, path.Dir(key))
+	err := mkdirRecursive(dir, _vfs)
+	if err != nil {
+		return "", err
+	}
+	leaf := path.Base(key)
+	for {
+		fp := path.Join(dir, version.Add(leaf, t.UTC()))
+		_, err := _vfs.Stat(fp)
+		if err == vfs.ENOENT {
+			return fp, nil
+		} else if err != nil {
+			return "", err
+		}
+		// IDs only have millisecond precision
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// unusedTime returns the first time from t on which doesn't give the
+// ID of one of the versions which aren't current
+func unusedTime(versions []objectVersion, t time.Time) time.Time {
+	used := make(map[gofakes3.VersionID]bool, len(versions))
+	for _, v := range versions {
+		if !v.current {
+			used[v.id] = true
+		}
+	}
+	for used[versionID(t)] {
+		// IDs only have millisecond precision
+		t = t.Add(time.Millisecond)
+	}
+	return t
+}
+
+// markWritten records that the current version of the object at key
+// in bucket was written at t and returns its version ID.
+//
+// With --versioning remote the ID is read from the remote instead. If
+// an old version has the same ID, for example if it was made in the
+// same millisecond, t is moved on until it doesn't.
+func (b *s3Backend) markWritten(_vfs *vfs.VFS, bucket, key string, t time.Time) (gofakes3.VersionID, error) {
+	fp := path.Join(bucket, key)
+	node, err := _vfs.Stat(fp)
+	if err != nil {
+		return "", err
+	}
+	if b.s.opt.Versioning != versioningDir {
+		return versionID(uploadTime(node)), nil
+	}
+	versions, written, err := b.readVersions(_vfs, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	err = removeWritten(_vfs, written)
+	if err != nil {
+		return "", err
+	}
+	t = unusedTime(versions, t)
+	markerPath, err := b.archivePath(_vfs, bucket, key+writtenMarkerSuffix, t)
+	if err != nil {
+		return "", err
+	}
+	fd, err := _vfs.Create(markerPath)
+	if err != nil {
+		return "", err
+	}
+	err = fd.Close()
+	if err != nil {
+		return "", err
+	}
+	// Give the marker the modification time of the object so changes
+	// made without serve s3 can be noticed
+	modTime := node.ModTime()
+	err = _vfs.Chtimes(markerPath, modTime, modTime)
+	if err != nil {
+		fs.Debugf(markerPath, "Failed to set modification time: %v", err)
+	}
+	return versionID(t), nil
+}
+
+// addDeleteMarker archives the object at key in bucket and puts a
+// delete marker in its place
+func (b *s3Backend) addDeleteMarker(_vfs *vfs.VFS, bucket, key string) (result gofakes3.ObjectDeleteResult, err error) {
+	archived, err := b.archive(_vfs, bucket, key)
+	if err != nil || archived == "" {
+		return result, err
+	}
+	versions, err := b.objectVersions(_vfs, bucket, key)
+	if err != nil {
+		return result, err
+	}
+	fp, err := b.archivePath(_vfs, bucket, key+deleteMarkerSuffix, unusedTime(versions, time.Now()))
+	if err != nil {
+		return result, err
+	}
+	fd, err := _vfs.Create(fp)
+	if err != nil {
+		return result, err
+	}
+	err = fd.Close()
+	if err != nil {
+		return result, err
+	}
+	t, _ := version.Remove(path.Base(fp))
+	return gofakes3.ObjectDeleteResult{
+		IsDeleteMarker: true,
+		VersionID:      versionID(t),
+	}, nil
+}
+
+// restoreLatest moves the newest version of the object at key in
+// bucket back from the versions directory if the object doesn't
+// exist and that version isn't a delete marker, as S3 makes the
+// previous version current when the current one is deleted.
+func (b *s3Backend) restoreLatest(_vfs *vfs.VFS, bucket, key string) error {
+	if b.s.opt.Versioning != versioningDir {
+		return nil
+	}
+	versions, err := b.objectVersions(_vfs, bucket, key)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	latest := versions[0]
+	if latest.current || latest.marker {
+		return nil
+	}
+	fp := path.Join(bucket, key)
+	// the directory may have been cleaned up when the object was deleted
+	if err = mkdirRecursive(path.Dir(fp), _vfs); err != nil {
+		return err
+	}
+	fs.Debugf(latest.fp, "Restoring old version to %q", fp)
+	err = _vfs.Rename(latest.fp, fp)
+	if err != nil {
+		return err
+	}
+	// keep the version ID it had as an old version
+	_, err = b.markWritten(_vfs, bucket, key, latest.modTime)
+	return err
+}
+
+// VersioningConfiguration returns the versioning configuration of
+// the bucket which is the same for all the buckets.
+func (b *s3Backend) VersioningConfiguration(bucket string) (config gofakes3.VersioningConfiguration, err error) {
+	_vfs, err := b.s.getVFS(b.s.ctx)
+	if err != nil {
+		return config, err
+	}
+	if _, err = b.statBucket(_vfs, bucket); err != nil {
+		return config, gofakes3.BucketNotFound(bucket)
+	}
+	config.SetEnabled(true)
+	return config, nil
+}
+
+// SetVersioningConfiguration accepts enabling versioning as it is
+// always on but can't suspend it.
+func (b *s3Backend) SetVersioningConfiguration(bucket string, config gofakes3.VersioningConfiguration) error {
+	current, err := b.VersioningConfiguration(bucket)
+	if err != nil {
+		return err
+	}
+	if config.Status != current.Status || config.MFADelete == gofakes3.MFADeleteEnabled {
+		return gofakes3.ErrNotImplemented
+	}
+	return nil
+}
+
+// GetObjectVersion fetches the version of the object.
+func (b *s3Backend) GetObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	_vfs, err := b.s.getVFS(b.s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.findVersion(_vfs, bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if v.marker {
+		return &gofakes3.Object{Name: objectName, VersionID: v.id, IsDeleteMarker: true, Contents: noOpReadCloser{}}, nil
+	}
+	obj, err := b.getNode(v.node, v.fp, objectName, rangeRequest)
+	if err != nil {
+		return nil, err
+	}
+	obj.VersionID = v.id
+	return obj, nil
+}
+
+// HeadObjectVersion fetches the info of the version of the object.
+func (b *s3Backend) HeadObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.Object, error) {
+	_vfs, err := b.s.getVFS(b.s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.findVersion(_vfs, bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if v.marker {
+		return &gofakes3.Object{Name: objectName, VersionID: v.id, IsDeleteMarker: true, Contents: noOpReadCloser{}}, nil
+	}
+	obj, err := b.headNode(v.node, v.fp, objectName)
+	if err != nil {
+		return nil, err
+	}
+	obj.VersionID = v.id
+	return obj, nil
+}
+
+// DeleteObjectVersion permanently deletes the version of the object.
+func (b *s3Backend) DeleteObjectVersion(bucketName, objectName string, versionID gofakes3.VersionID) (result gofakes3.ObjectDeleteResult, err error) {
+	_vfs, err := b.s.getVFS(b.s.ctx)
+	if err != nil {
+		return result, err
+	}
+	defer b.versionLocks.lock(path.Join(bucketName, objectName))()
+	v, err := b.findVersion(_vfs, bucketName, objectName, versionID)
+	if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchVersion) || gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+	fs.Debugf(v.fp, "Deleting version %s", v.id)
+	if err = _vfs.Remove(v.fp); err != nil {
+		return result, err
+	}
+	if v.current {
+		_, written, err := b.readVersions(_vfs, bucketName, objectName)
+		if err != nil {
+			return result, err
+		}
+		if err = removeWritten(_vfs, written); err != nil {
+			return result, err
+		}
+	}
+	if err = b.restoreLatest(_vfs, bucketName, objectName); err != nil {
+		return result, err
+	}
+	if !b.s.opt.NoCleanup {
+		rmdirRecursive(v.fp, _vfs)
+	}
+	return gofakes3.ObjectDeleteResult{
+		IsDeleteMarker: v.marker,
+		VersionID:      v.id,
+	}, nil
+}
+
+// ListBucketVersions lists the versions of the objects in the bucket.
+//
+// The listing carries on from the markers in page without reading the
+// directories before them.
+func (b *s3Backend) ListBucketVersions(bucketName string, prefix *gofakes3.Prefix, page *gofakes3.ListBucketVersionsPage) (*gofakes3.ListBucketVersionsResult, error) {
+	_vfs, err := b.s.getVFS(b.s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = b.statBucket(_vfs, bucketName); err != nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	var match gofakes3.PrefixMatch
+	p := *emptyPrefix
+	if prefix != nil {
+		p = *prefix
+	}
+	// treat empty prefixes and delimiters as missing like ListBucket
+	p.HasPrefix = p.HasPrefix && strings.TrimSpace(p.Prefix) != ""
+	p.HasDelimiter = p.HasDelimiter && strings.TrimSpace(p.Delimiter) != ""
+	if page == nil {
+		page = &gofakes3.ListBucketVersionsPage{}
+	}
+
+	result := gofakes3.NewListBucketVersionsResult(bucketName, prefix, page)
+	var (
+		skipping = page.HasKeyMarker
+		maxKeys  = page.MaxKeys
+		returned int64
+	)
+	if maxKeys <= 0 {
+		maxKeys = gofakes3.DefaultMaxBucketVersionKeys
+	}
+
+	// skipDir returns true if none of the objects under the
+	// directory with keys starting with dirPrefix are listed
+	skipDir := func(dirPrefix string) bool {
+		if page.HasKeyMarker && dirPrefix < page.KeyMarker && !strings.HasPrefix(page.KeyMarker, dirPrefix) {
+			return true
+		}
+		if p.HasPrefix && !strings.HasPrefix(dirPrefix, p.Prefix) && !strings.HasPrefix(p.Prefix, dirPrefix) {
+			return true
+		}
+		if p.HasDelimiter && !(page.HasKeyMarker && strings.HasPrefix(page.KeyMarker, dirPrefix)) {
+			// all the objects in the directory share a common prefix
+			if p.Match(dirPrefix, &match) && match.CommonPrefix && len(match.MatchedPart) <= len(dirPrefix) {
+				result.AddPrefix(match.MatchedPart)
+				return true
+			}
+		}
+		return false
+	}
+
+	err = b.walkVersions(_vfs, bucketName, "", skipDir, func(versions []objectVersion) error {
+		for i, v := range versions {
+			if skipping {
+				// Carry on after the key marker or after the version
+				// marker of the key marker if set
+				if v.key < page.KeyMarker || (v.key == page.KeyMarker && !page.HasVersionIDMarker) {
+					continue
+				}
+				if v.key == page.KeyMarker {
+					skipping = v.id != page.VersionIDMarker
+					continue
+				}
+				skipping = false
+			}
+			if !p.Match(v.key, &match) {
+				continue
+			}
+			if match.CommonPrefix {
+				result.AddPrefix(match.MatchedPart)
+				continue
+			}
+			if returned >= maxKeys {
+				result.IsTruncated = true
+				return errStopWalk
+			}
+			returned++
+			result.NextKeyMarker = v.key
+			result.NextVersionIDMarker = v.id
+			if v.marker {
+				result.Versions = append(result.Versions, &gofakes3.DeleteMarker{
+					Key:          v.key,
+					VersionID:    v.id,
+					IsLatest:     i == 0,
+					LastModified: gofakes3.NewContentTime(v.modTime),
+				})
+				continue
+			}
+			result.Versions = append(result.Versions, &gofakes3.Version{
+				Key:          v.key,
+				VersionID:    v.id,
+				IsLatest:     i == 0,
+				LastModified: gofakes3.NewContentTime(v.modTime),
+				Size:         v.node.Size(),
+				StorageClass: gofakes3.StorageStandard,
+				ETag:         `"` + getFileHash(v.node, b.s.etagHashType) + `"`,
+			})
+		}
+		return nil
+	})
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker = ""
+		result.NextVersionIDMarker = ""
+	}
+	return result, nil
+}
+
+// check interfaces
+var _ gofakes3.VersionedBackend = (*s3Backend)(nil)
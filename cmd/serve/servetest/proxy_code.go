@@ -28,6 +28,11 @@ func main() {
 		"_root":    root,
 		"_obscure": "pass",
 	}
+	// If no credentials were passed, return the password for the
+	// server to check the login with
+	if in["pass"] == "" && in["public_key"] == "" {
+		out["_password"] = "testpass"
+	}
 	json.NewEncoder(os.Stdout).Encode(&out)
 	if err != nil {
 		log.Fatal(err)
@@ -26,7 +26,8 @@ var subRun = flag.String("sub-run", "", "pass this to the -run command of the ba
 // StartFn describes the callback which should start the server with
 // the Fs passed in.
 // It should return a config for the backend used to connect to the
-// server and a clean up function
+// server and a clean up function. If the config contains "_root" then
+// it is used as the path on the backend.
 type StartFn func(f fs.Fs) (configmap.Simple, func())
 
 // run runs the server then runs the unit tests for the remote against
@@ -80,7 +81,13 @@ func run(t *testing.T, name string, start StartFn, useProxy bool) {
 	if *subRun != "" {
 		args = append(args, "-run", *subRun)
 	}
-	args = append(args, "-remote", remoteName)
+	// A "_root" in the config is the path to use on the remote
+	remotePath := remoteName
+	if root, ok := config["_root"]; ok {
+		remotePath += root
+		delete(config, "_root")
+	}
+	args = append(args, "-remote", remotePath)
 	args = append(args, "-list-retries", fmt.Sprint(*fstest.ListRetries))
 	cmd := exec.Command("go", args...)
 
@@ -0,0 +1,556 @@
+package smb
+
+// NTLMv2 authentication wrapped in SPNEGO from [MS-NLMP] and
+// [MS-SPNG] along with the SMB2/3 message signing algorithms
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // md4 is needed for the NTLM hash
+)
+
+// Object identifiers
+var (
+	spnegoOID  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 2}
+	ntlmsspOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 2, 10}
+)
+
+// SPNEGO negotiation states
+const (
+	negStateAcceptCompleted  = 0
+	negStateAcceptIncomplete = 1
+	negStateReject           = 2
+)
+
+// negTokenInit is the first SPNEGO token sent by the client
+type negTokenInit struct {
+	MechTypes   []asn1.ObjectIdentifier `asn1:"explicit,optional,tag:0"`
+	ReqFlags    asn1.BitString          `asn1:"explicit,optional,tag:1"`
+	MechToken   []byte                  `asn1:"explicit,optional,tag:2"`
+	MechListMIC []byte                  `asn1:"explicit,optional,tag:3"`
+}
+
+// negTokenResp is used for the subsequent SPNEGO tokens
+type negTokenResp struct {
+	NegState      asn1.Enumerated       `asn1:"explicit,optional,tag:0"`
+	SupportedMech asn1.ObjectIdentifier `asn1:"explicit,optional,tag:1"`
+	ResponseToken []byte                `asn1:"explicit,optional,tag:2"`
+	MechListMIC   []byte                `asn1:"explicit,optional,tag:3"`
+}
+
+// initialContextToken wraps the negTokenInit
+type initialContextToken struct {
+	ThisMech asn1.ObjectIdentifier
+	Init     negTokenInit `asn1:"explicit,tag:0"`
+}
+
+// spnegoInit returns the negTokenInit advertising NTLMSSP which is
+// sent in the NEGOTIATE response
+func spnegoInit() []byte {
+	token, err := asn1.MarshalWithParams(initialContextToken{
+		ThisMech: spnegoOID,
+		Init: negTokenInit{
+			MechTypes: []asn1.ObjectIdentifier{ntlmsspOID},
+		},
+	}, "application,tag:0")
+	if err != nil {
+		panic(err)
+	}
+	return token
+}
+
+// spnegoToken is a decoded token from the client
+type spnegoToken struct {
+	raw         bool   // set if NTLMSSP wasn't wrapped in SPNEGO
+	mechToken   []byte // the NTLMSSP message
+	mechTypes   []byte // the DER encoded mechTypes if sent
+	mechListMIC []byte // the mechListMIC if sent
+}
+
+// parseSpnego decodes an SPNEGO token from the client
+func parseSpnego(token []byte) (t spnegoToken, err error) {
+	switch {
+	case bytes.HasPrefix(token, ntlmSignature):
+		t.raw = true
+		t.mechToken = token
+	case len(token) > 0 && token[0] == 0x60:
+		var outer asn1.RawValue
+		_, err = asn1.Unmarshal(token, &outer)
+		if err != nil {
+			return t, err
+		}
+		var oid asn1.ObjectIdentifier
+		rest, err := asn1.Unmarshal(outer.Bytes, &oid)
+		if err != nil {
+			return t, err
+		}
+		if !oid.Equal(spnegoOID) {
+			return t, fmt.Errorf("unknown mechanism %v", oid)
+		}
+		var init negTokenInit
+		_, err = asn1.UnmarshalWithParams(rest, &init, "explicit,tag:0")
+		if err != nil {
+			return t, err
+		}
+		if len(init.MechTypes) == 0 || !init.MechTypes[0].Equal(ntlmsspOID) {
+			// Only NTLMSSP is supported so it must be the
+			// client's first choice to use its token
+			return t, errors.New("client doesn't offer NTLMSSP first")
+		}
+		t.mechTypes, err = asn1.Marshal(init.MechTypes)
+		if err != nil {
+			return t, err
+		}
+		t.mechToken = init.MechToken
+		t.mechListMIC = init.MechListMIC
+	case len(token) > 0 && token[0] == 0xa1:
+		var resp negTokenResp
+		_, err = asn1.UnmarshalWithParams(token, &resp, "explicit,tag:1")
+		if err != nil {
+			return t, err
+		}
+		t.mechToken = resp.ResponseToken
+		t.mechListMIC = resp.MechListMIC
+	default:
+		return t, errors.New("unknown security token")
+	}
+	if len(t.mechToken) == 0 {
+		return t, errors.New("no NTLMSSP token")
+	}
+	return t, nil
+}
+
+// spnegoResp makes a negTokenResp to send to the client
+func spnegoResp(state asn1.Enumerated, first bool, token, mechListMIC []byte) []byte {
+	resp := negTokenResp{
+		NegState:      state,
+		ResponseToken: token,
+		MechListMIC:   mechListMIC,
+	}
+	if first {
+		resp.SupportedMech = ntlmsspOID
+	}
+	out, err := asn1.MarshalWithParams(resp, "explicit,tag:1")
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// NTLMSSP message types
+const (
+	ntlmNegotiate    = 1
+	ntlmChallenge    = 2
+	ntlmAuthenticate = 3
+)
+
+// NTLMSSP negotiate flags
+const (
+	ntlmNegotiateUnicode                 = 0x00000001
+	ntlmRequestTarget                    = 0x00000004
+	ntlmNegotiateSign                    = 0x00000010
+	ntlmNegotiateSeal                    = 0x00000020
+	ntlmNegotiateNTLM                    = 0x00000200
+	ntlmNegotiateAnonymous               = 0x00000800
+	ntlmNegotiateAlwaysSign              = 0x00008000
+	ntlmTargetTypeServer                 = 0x00020000
+	ntlmNegotiateExtendedSessionSecurity = 0x00080000
+	ntlmNegotiateTargetInfo              = 0x00800000
+	ntlmNegotiateVersion                 = 0x02000000
+	ntlmNegotiate128                     = 0x20000000
+	ntlmNegotiateKeyExch                 = 0x40000000
+	ntlmNegotiate56                      = 0x80000000
+
+	// flags the server supports
+	ntlmServerFlags = ntlmNegotiateUnicode | ntlmRequestTarget | ntlmNegotiateSign | ntlmNegotiateSeal |
+		ntlmNegotiateNTLM | ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSessionSecurity |
+		ntlmNegotiateTargetInfo | ntlmNegotiateVersion | ntlmNegotiate128 | ntlmNegotiateKeyExch | ntlmNegotiate56
+)
+
+// AV_PAIR ids
+const (
+	avEOL             = 0
+	avNbComputerName  = 1
+	avNbDomainName    = 2
+	avDNSComputerName = 3
+	avDNSDomainName   = 4
+	avFlags           = 6
+	avTimestamp       = 7
+)
+
+// MsvAvFlags bit showing the AUTHENTICATE message has a MIC
+const avFlagMICPresent = 0x00000002
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+// Windows 10 version with NTLM revision 15
+var ntlmVersion = []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 15}
+
+// ntlmServer is the server side of one NTLM authentication
+type ntlmServer struct {
+	name      string // NetBIOS name of the server
+	negotiate []byte // the NEGOTIATE message
+	challenge []byte // the CHALLENGE message
+	flags     uint32 // the negotiated flags
+}
+
+// ntlmAuth is a decoded AUTHENTICATE message
+type ntlmAuth struct {
+	msg          []byte // the raw message
+	flags        uint32
+	user         string
+	domain       []byte // UTF-16 domain name
+	ntResponse   []byte
+	encryptedKey []byte
+	micOffset    int // offset of the MIC or 0 if not present
+}
+
+// avPair encodes an AV_PAIR
+func avPair(id uint16, value []byte) []byte {
+	b := make([]byte, 4, 4+len(value))
+	le.PutUint16(b[0:], id)
+	le.PutUint16(b[2:], uint16(len(value)))
+	return append(b, value...)
+}
+
+// challengeMessage reads the client's NEGOTIATE message and returns
+// the CHALLENGE message to send back
+func (n *ntlmServer) challengeMessage(negotiate []byte) ([]byte, error) {
+	if len(negotiate) < 16 || !bytes.Equal(negotiate[:8], ntlmSignature) || le.Uint32(negotiate[8:]) != ntlmNegotiate {
+		return nil, errors.New("bad NTLM NEGOTIATE message")
+	}
+	n.negotiate = negotiate
+	n.flags = le.Uint32(negotiate[12:])&ntlmServerFlags | ntlmTargetTypeServer | ntlmNegotiateTargetInfo
+	if n.flags&ntlmNegotiateUnicode == 0 {
+		return nil, errors.New("NTLM client doesn't support unicode")
+	}
+
+	name := encodeUTF16(n.name)
+	var timestamp [8]byte
+	le.PutUint64(timestamp[:], toFiletime(time.Now()))
+	var targetInfo []byte
+	targetInfo = append(targetInfo, avPair(avNbDomainName, name)...)
+	targetInfo = append(targetInfo, avPair(avNbComputerName, name)...)
+	targetInfo = append(targetInfo, avPair(avDNSDomainName, name)...)
+	targetInfo = append(targetInfo, avPair(avDNSComputerName, name)...)
+	targetInfo = append(targetInfo, avPair(avTimestamp, timestamp[:])...)
+	targetInfo = append(targetInfo, avPair(avEOL, nil)...)
+
+	//   0-8: Signature
+	//  8-12: MessageType
+	// 12-20: TargetNameFields
+	// 20-24: NegotiateFlags
+	// 24-32: ServerChallenge
+	// 32-40: Reserved
+	// 40-48: TargetInfoFields
+	// 48-56: Version
+	//   56-: Payload
+	const payload = 56
+	msg := make([]byte, payload, payload+len(name)+len(targetInfo))
+	copy(msg[0:], ntlmSignature)
+	le.PutUint32(msg[8:], ntlmChallenge)
+	le.PutUint16(msg[12:], uint16(len(name)))
+	le.PutUint16(msg[14:], uint16(len(name)))
+	le.PutUint32(msg[16:], payload)
+	le.PutUint32(msg[20:], n.flags)
+	if _, err := rand.Read(msg[24:32]); err != nil {
+		return nil, err
+	}
+	le.PutUint16(msg[40:], uint16(len(targetInfo)))
+	le.PutUint16(msg[42:], uint16(len(targetInfo)))
+	le.PutUint32(msg[44:], uint32(payload+len(name)))
+	copy(msg[48:], ntlmVersion)
+	msg = append(msg, name...)
+	msg = append(msg, targetInfo...)
+	n.challenge = msg
+	return msg, nil
+}
+
+// ntlmField reads the security buffer described at offset in msg
+func ntlmField(msg []byte, offset int) ([]byte, error) {
+	length := int(le.Uint16(msg[offset:]))
+	start := int(le.Uint32(msg[offset+4:]))
+	if length == 0 {
+		return nil, nil
+	}
+	if start < 0 || start+length > len(msg) {
+		return nil, errors.New("bad NTLM AUTHENTICATE message")
+	}
+	return msg[start : start+length], nil
+}
+
+// parseAuthenticate decodes the client's AUTHENTICATE message
+func (n *ntlmServer) parseAuthenticate(msg []byte) (a *ntlmAuth, err error) {
+	//   0-8: Signature
+	//  8-12: MessageType
+	// 12-20: LmChallengeResponseFields
+	// 20-28: NtChallengeResponseFields
+	// 28-36: DomainNameFields
+	// 36-44: UserNameFields
+	// 44-52: WorkstationFields
+	// 52-60: EncryptedRandomSessionKeyFields
+	// 60-64: NegotiateFlags
+	// 64-72: Version
+	// 72-88: MIC
+	if n.challenge == nil {
+		return nil, errors.New("NTLM AUTHENTICATE before CHALLENGE")
+	}
+	if len(msg) < 64 || !bytes.Equal(msg[:8], ntlmSignature) || le.Uint32(msg[8:]) != ntlmAuthenticate {
+		return nil, errors.New("bad NTLM AUTHENTICATE message")
+	}
+	a = &ntlmAuth{
+		msg:   msg,
+		flags: le.Uint32(msg[60:]),
+	}
+	if a.ntResponse, err = ntlmField(msg, 20); err != nil {
+		return nil, err
+	}
+	if a.domain, err = ntlmField(msg, 28); err != nil {
+		return nil, err
+	}
+	user, err := ntlmField(msg, 36)
+	if err != nil {
+		return nil, err
+	}
+	a.user = decodeUTF16(user)
+	if a.encryptedKey, err = ntlmField(msg, 52); err != nil {
+		return nil, err
+	}
+
+	// The MIC is present if the client says so in the MsvAvFlags
+	// of the NTLMv2 response
+	if len(a.ntResponse) > 44 && len(msg) >= 88 {
+		pairs := a.ntResponse[44:]
+		for len(pairs) >= 4 {
+			id, length := le.Uint16(pairs[0:]), int(le.Uint16(pairs[2:]))
+			if id == avEOL || 4+length > len(pairs) {
+				break
+			}
+			if id == avFlags && length == 4 && le.Uint32(pairs[4:])&avFlagMICPresent != 0 {
+				a.micOffset = 72
+			}
+			pairs = pairs[4+length:]
+		}
+	}
+	return a, nil
+}
+
+// anonymous returns true if this is an anonymous authentication
+func (a *ntlmAuth) anonymous() bool {
+	return a.user == "" && len(a.ntResponse) == 0
+}
+
+// ntowfv2 computes the NTLMv2 hash from the password
+func ntowfv2(password, user string, domain []byte) []byte {
+	h := md4.New()
+	_, _ = h.Write(encodeUTF16(password))
+	hm := hmac.New(md5.New, h.Sum(nil))
+	_, _ = hm.Write(encodeUTF16(strings.ToUpper(user)))
+	_, _ = hm.Write(domain)
+	return hm.Sum(nil)
+}
+
+// verify checks the AUTHENTICATE message was made with password,
+// returning the exported session key if it was
+func (n *ntlmServer) verify(a *ntlmAuth, password string) (sessionKey []byte, ok bool) {
+	// Only NTLMv2 responses are supported
+	if len(a.ntResponse) < 48 {
+		return nil, false
+	}
+	serverChallenge := n.challenge[24:32]
+	ntProofStr, blob := a.ntResponse[:16], a.ntResponse[16:]
+
+	// Clients don't agree on which domain name to use so try
+	// the common variations
+	domains := [][]byte{a.domain, encodeUTF16(strings.ToUpper(decodeUTF16(a.domain))), nil}
+	for _, domain := range domains {
+		hash := ntowfv2(password, a.user, domain)
+		hm := hmac.New(md5.New, hash)
+		_, _ = hm.Write(serverChallenge)
+		_, _ = hm.Write(blob)
+		if subtle.ConstantTimeCompare(hm.Sum(nil), ntProofStr) != 1 {
+			continue
+		}
+
+		// Work out the session key
+		hm = hmac.New(md5.New, hash)
+		_, _ = hm.Write(ntProofStr)
+		sessionKey = hm.Sum(nil)
+		if a.flags&ntlmNegotiateKeyExch != 0 && len(a.encryptedKey) == 16 {
+			c, err := rc4.NewCipher(sessionKey)
+			if err != nil {
+				return nil, false
+			}
+			exported := make([]byte, 16)
+			c.XORKeyStream(exported, a.encryptedKey)
+			sessionKey = exported
+		}
+
+		// Check the MIC over all the messages
+		if a.micOffset != 0 {
+			msg := bytes.Clone(a.msg)
+			mic := bytes.Clone(msg[a.micOffset : a.micOffset+16])
+			clear(msg[a.micOffset : a.micOffset+16])
+			hm = hmac.New(md5.New, sessionKey)
+			_, _ = hm.Write(n.negotiate)
+			_, _ = hm.Write(n.challenge)
+			_, _ = hm.Write(msg)
+			if !hmac.Equal(hm.Sum(nil), mic) {
+				return nil, false
+			}
+		}
+		return sessionKey, true
+	}
+	return nil, false
+}
+
+// ntlmKey derives the NTLM signing or sealing key
+func ntlmKey(sessionKey []byte, magic string) []byte {
+	h := md5.New()
+	_, _ = h.Write(sessionKey)
+	_, _ = h.Write([]byte(magic))
+	return h.Sum(nil)
+}
+
+// mechListMIC computes the NTLM signature of the first message in a
+// direction with sequence number 0 as used for the SPNEGO
+// mechListMIC.
+func (n *ntlmServer) mechListMIC(sessionKey, mechTypes []byte, fromClient bool) []byte {
+	direction := "server-to-client"
+	if fromClient {
+		direction = "client-to-server"
+	}
+	signKey := ntlmKey(sessionKey, "session key to "+direction+" signing key magic constant\x00")
+	sealKey := ntlmKey(sessionKey, "session key to "+direction+" sealing key magic constant\x00")
+
+	// Version, Checksum, SeqNum
+	sig := make([]byte, 16)
+	le.PutUint32(sig[0:], 1)
+	hm := hmac.New(md5.New, signKey)
+	_, _ = hm.Write(sig[12:16])
+	_, _ = hm.Write(mechTypes)
+	copy(sig[4:12], hm.Sum(nil))
+	if n.flags&ntlmNegotiateKeyExch != 0 {
+		c, err := rc4.NewCipher(sealKey)
+		if err != nil {
+			return nil
+		}
+		c.XORKeyStream(sig[4:12], sig[4:12])
+	}
+	return sig
+}
+
+// kdf is the SP800-108 counter mode KDF with HMAC-SHA256 used to make
+// the SMB3 keys
+func kdf(key []byte, label, context string) []byte {
+	hm := hmac.New(sha256.New, key)
+	_, _ = hm.Write([]byte{0, 0, 0, 1})
+	_, _ = hm.Write([]byte(label))
+	_, _ = hm.Write([]byte{0})
+	_, _ = hm.Write([]byte(context))
+	_, _ = hm.Write([]byte{0, 0, 0, 128})
+	return hm.Sum(nil)[:16]
+}
+
+// signer signs and verifies SMB2 messages
+type signer func(msg []byte) []byte
+
+// newSigner makes the signer for the dialect from the session key
+//
+// preauth is the pre-authentication integrity hash for SMB 3.1.1
+func newSigner(dialect uint16, sessionKey, preauth []byte) signer {
+	// The session key is always 16 bytes for signing
+	key := make([]byte, 16)
+	copy(key, sessionKey)
+	switch dialect {
+	case dialect202, dialect210:
+		return func(msg []byte) []byte {
+			hm := hmac.New(sha256.New, key)
+			_, _ = hm.Write(msg)
+			return hm.Sum(nil)[:16]
+		}
+	case dialect300, dialect302:
+		key = kdf(key, "SMB2AESCMAC\x00", "SmbSign\x00")
+	default:
+		key = kdf(key, "SMBSigningKey\x00", string(preauth))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	return func(msg []byte) []byte {
+		return cmac(block, msg)
+	}
+}
+
+// sign the message in place
+func (s signer) sign(msg []byte) {
+	le.PutUint32(msg[16:], le.Uint32(msg[16:])|flagsSigned)
+	clear(msg[48:64])
+	copy(msg[48:64], s(msg))
+}
+
+// check the signature on the message returning true if it is good
+func (s signer) check(msg []byte) bool {
+	msg = bytes.Clone(msg)
+	signature := bytes.Clone(msg[48:64])
+	clear(msg[48:64])
+	return hmac.Equal(signature, s(msg))
+}
+
+// cmac computes the AES-CMAC of msg from RFC 4493
+func cmac(block cipher.Block, msg []byte) []byte {
+	const size = aes.BlockSize
+
+	// Make the subkeys
+	shift := func(in []byte) []byte {
+		out := make([]byte, size)
+		var carry byte
+		for i := size - 1; i >= 0; i-- {
+			out[i] = in[i]<<1 | carry
+			carry = in[i] >> 7
+		}
+		if in[0]&0x80 != 0 {
+			out[size-1] ^= 0x87
+		}
+		return out
+	}
+	l := make([]byte, size)
+	block.Encrypt(l, l)
+	k1 := shift(l)
+	k2 := shift(k1)
+
+	// Process all but the last block
+	x := make([]byte, size)
+	for len(msg) > size {
+		subtle.XORBytes(x, x, msg[:size])
+		block.Encrypt(x, x)
+		msg = msg[size:]
+	}
+
+	// Pad and process the last block
+	last := make([]byte, size)
+	copy(last, msg)
+	if len(msg) == size {
+		subtle.XORBytes(last, last, k1)
+	} else {
+		last[len(msg)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+	subtle.XORBytes(x, x, last)
+	block.Encrypt(x, x)
+	return x
+}
@@ -0,0 +1,492 @@
+package smb
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// openFile is a file or directory opened with CREATE
+type openFile struct {
+	id            uint64
+	sess          *session
+	tree          *tree
+	name          string // VFS path
+	isDir         bool
+	access        uint32     // granted access
+	handle        vfs.Handle // open handle or nil if not opened yet
+	deleteOnClose bool
+
+	// directory listing state
+	listing  []dirEntry
+	listPos  int
+	listSent bool // set if any entries have been returned
+	pattern  string
+}
+
+// dirEntry is an entry in a directory listing
+type dirEntry struct {
+	name string
+	node vfs.Node
+}
+
+// mapError converts a VFS error into an NTSTATUS
+func (c *conn) mapError(err error) uint32 {
+	switch {
+	case err == nil:
+		return statusSuccess
+	case errors.Is(err, vfs.ENOENT):
+		return statusObjectNameNotFound
+	case errors.Is(err, vfs.EEXIST):
+		return statusObjectNameCollision
+	case errors.Is(err, vfs.EPERM), errors.Is(err, vfs.EROFS):
+		return statusAccessDenied
+	case errors.Is(err, vfs.ENOTEMPTY):
+		return statusDirectoryNotEmpty
+	case errors.Is(err, vfs.EINVAL):
+		return statusInvalidParameter
+	case errors.Is(err, vfs.ENOSYS), errors.Is(err, vfs.ENOTSUP):
+		return statusNotSupported
+	case errors.Is(err, vfs.ECLOSED), errors.Is(err, vfs.EBADF):
+		return statusFileClosed
+	}
+	fs.Errorf(c.what, "IO error: %v", err)
+	return statusUnexpectedIOError
+}
+
+// maximalAccess returns the most access anyone can have to the VFS
+func maximalAccess(VFS *vfs.VFS) uint32 {
+	if VFS.Opt.ReadOnly {
+		return fileGenericRead | fileGenericExecute
+	}
+	return fileAllAccess
+}
+
+// grantedAccess works out the access granted from the desired access
+func grantedAccess(desired uint32, VFS *vfs.VFS) uint32 {
+	access := desired &^ (genericAll | genericRead | genericWrite | genericExecute | maximumAllowed)
+	if desired&(genericAll|maximumAllowed) != 0 {
+		access |= maximalAccess(VFS)
+	}
+	if desired&genericRead != 0 {
+		access |= fileGenericRead
+	}
+	if desired&genericWrite != 0 {
+		access |= fileGenericWrite
+	}
+	if desired&genericExecute != 0 {
+		access |= fileGenericExecute
+	}
+	return access
+}
+
+// fileAttributes returns the SMB attributes for the node
+func fileAttributes(node vfs.Node) uint32 {
+	var attrs uint32
+	if node.IsDir() {
+		attrs = fileAttributeDirectory
+	} else {
+		attrs = fileAttributeArchive
+	}
+	if node.VFS().Opt.ReadOnly {
+		attrs |= fileAttributeReadonly
+	}
+	return attrs
+}
+
+// allocationSize returns the space size bytes would use on disk
+func allocationSize(size int64) uint64 {
+	return uint64(size+4095) &^ 4095
+}
+
+// putTimes writes the creation, access, write and change times of
+// the node into b
+func putTimes(b []byte, node vfs.Node) {
+	t := toFiletime(node.ModTime())
+	le.PutUint64(b[0:], t)
+	le.PutUint64(b[8:], t)
+	le.PutUint64(b[16:], t)
+	le.PutUint64(b[24:], t)
+}
+
+// putNetworkInfo writes the times, sizes and attributes of the node
+// in FILE_NETWORK_OPEN_INFORMATION format into b
+func putNetworkInfo(b []byte, node vfs.Node) {
+	putTimes(b, node)
+	var size int64
+	if !node.IsDir() {
+		size = node.Size()
+	}
+	le.PutUint64(b[32:], allocationSize(size))
+	le.PutUint64(b[40:], uint64(size))
+	le.PutUint32(b[48:], fileAttributes(node))
+}
+
+// node returns the VFS node for the open file
+func (o *openFile) node() (vfs.Node, error) {
+	if o.handle != nil {
+		return o.handle.Node(), nil
+	}
+	return o.sess.vfs.Stat(o.name)
+}
+
+// getHandle returns the open handle, opening the file if necessary
+func (o *openFile) getHandle(write bool) (vfs.Handle, error) {
+	if o.handle != nil {
+		return o.handle, nil
+	}
+	VFS := o.sess.vfs
+	canRW := VFS.Opt.CacheMode >= vfscommon.CacheModeMinimal
+	flags := os.O_RDONLY
+	if write {
+		flags = os.O_WRONLY
+		if canRW && o.access&fileReadData != 0 {
+			flags = os.O_RDWR
+		}
+	} else if canRW && o.access&(fileWriteData|fileAppendData) != 0 {
+		flags = os.O_RDWR
+	}
+	handle, err := VFS.OpenFile(o.name, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	o.handle = handle
+	return handle, nil
+}
+
+// getFile finds the open file the request refers to
+func (c *conn) getFile(r *request, state *compound) (*openFile, uint32) {
+	offset := 0
+	switch r.hdr.command {
+	case cmdClose, cmdFlush, cmdQueryDirectory:
+		offset = 8
+	case cmdRead, cmdWrite, cmdSetInfo:
+		offset = 16
+	case cmdLock:
+		offset = 8
+	case cmdQueryInfo:
+		offset = 24
+	}
+	if len(r.body) < offset+16 {
+		return nil, statusInvalidParameter
+	}
+	id := le.Uint64(r.body[offset+8:])
+	if id == ^uint64(0) && le.Uint64(r.body[offset:]) == ^uint64(0) && r.hdr.flags&flagsRelatedOps != 0 {
+		id = state.fileID
+	}
+	o := c.files[id]
+	if o == nil || o.sess != r.sess || o.tree != r.tree {
+		return nil, statusFileClosed
+	}
+	return o, statusSuccess
+}
+
+// closeFile closes the open file, deleting it if requested
+func (c *conn) closeFile(o *openFile) (err error) {
+	delete(c.files, o.id)
+	if o.handle != nil {
+		err = o.handle.Close()
+		o.handle = nil
+	}
+	if o.deleteOnClose {
+		if removeErr := o.sess.vfs.Remove(o.name); removeErr != nil && !errors.Is(removeErr, vfs.ENOENT) {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// create handles the CREATE command
+func (c *conn) create(r *request) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 56 {
+		return statusInvalidParameter, nil
+	}
+	desiredAccess := le.Uint32(b[24:])
+	disposition := le.Uint32(b[36:])
+	options := le.Uint32(b[40:])
+	name := decodeUTF16(slice(r.raw, 0, int(le.Uint16(b[44:])), int(le.Uint16(b[46:]))))
+	if r.tree.ipc {
+		// Named pipes aren't supported
+		return statusObjectNameNotFound, nil
+	}
+
+	// Only the default data stream is supported
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		stream := strings.ToUpper(name[i:])
+		if stream != "::$DATA" && stream != ":$DATA" {
+			return statusObjectNameNotFound, nil
+		}
+		name = name[:i]
+	}
+	name, ok := smbPath(name)
+	if !ok {
+		return statusObjectNameInvalid, nil
+	}
+
+	VFS := r.sess.vfs
+	access := grantedAccess(desiredAccess, VFS)
+	node, err := VFS.Stat(name)
+	exists := err == nil
+	if err != nil {
+		if !errors.Is(err, vfs.ENOENT) {
+			return c.mapError(err), nil
+		}
+		if _, _, err := VFS.StatParent(name); err != nil {
+			return statusObjectPathNotFound, nil
+		}
+	}
+	if exists {
+		if options&fileDirectoryFile != 0 && !node.IsDir() {
+			return statusNotADirectory, nil
+		}
+		if options&fileNonDirectoryFile != 0 && node.IsDir() {
+			return statusFileIsADirectory, nil
+		}
+	}
+
+	// Work out what to do
+	var create, truncate bool
+	action := uint32(fileOpened)
+	switch disposition {
+	case fileOpen:
+		if !exists {
+			return statusObjectNameNotFound, nil
+		}
+	case fileCreate:
+		if exists {
+			return statusObjectNameCollision, nil
+		}
+		create = true
+	case fileOpenIf:
+		create = !exists
+	case fileOverwrite:
+		if !exists {
+			return statusObjectNameNotFound, nil
+		}
+		truncate = true
+	case fileSupersede, fileOverwriteIf:
+		create = !exists
+		truncate = exists
+	default:
+		return statusInvalidParameter, nil
+	}
+	if truncate && node.IsDir() {
+		return statusFileIsADirectory, nil
+	}
+	if VFS.Opt.ReadOnly && (create || truncate || access&modifyAccess != 0) {
+		return statusAccessDenied, nil
+	}
+	if options&fileDeleteOnClose != 0 && access&accessDelete == 0 {
+		return statusAccessDenied, nil
+	}
+
+	o := &openFile{
+		sess:          r.sess,
+		tree:          r.tree,
+		name:          name,
+		access:        access,
+		deleteOnClose: options&fileDeleteOnClose != 0,
+	}
+	flags := os.O_WRONLY
+	if access&fileReadData != 0 && VFS.Opt.CacheMode >= vfscommon.CacheModeMinimal {
+		flags = os.O_RDWR
+	}
+	switch {
+	case create && options&fileDirectoryFile != 0:
+		err = VFS.Mkdir(name, 0777)
+		if err == nil {
+			node, err = VFS.Stat(name)
+		}
+		action = fileCreated
+	case create:
+		o.handle, err = VFS.OpenFile(name, flags|os.O_CREATE|os.O_TRUNC, 0666)
+		action = fileCreated
+	case truncate:
+		o.handle, err = VFS.OpenFile(name, flags|os.O_TRUNC, 0666)
+		action = fileOverwritten
+		if disposition == fileSupersede {
+			action = fileSuperseded
+		}
+	}
+	if err != nil {
+		return c.mapError(err), nil
+	}
+	if o.handle != nil {
+		node = o.handle.Node()
+	}
+	o.isDir = node.IsDir()
+	c.lastFileID++
+	o.id = c.lastFileID
+	c.files[o.id] = o
+
+	// Reply to the create contexts we know about
+	var contexts []byte
+	ctxOffset, ctxLength := int(le.Uint32(b[48:])), int(le.Uint32(b[52:]))
+	for ctxs := slice(r.raw, 0, ctxOffset, ctxLength); len(ctxs) >= 16; {
+		next := int(le.Uint32(ctxs[0:]))
+		ctxName := slice(ctxs, 0, int(le.Uint16(ctxs[4:])), int(le.Uint16(ctxs[6:])))
+		var data []byte
+		switch string(ctxName) {
+		case "MxAc":
+			data = make([]byte, 8)
+			le.PutUint32(data[4:], maximalAccess(VFS))
+		case "QFid":
+			data = make([]byte, 32)
+			le.PutUint64(data[0:], node.Inode())
+		}
+		if data != nil {
+			contexts = appendCreateContext(contexts, string(ctxName), data)
+		}
+		if next == 0 || next > len(ctxs) {
+			break
+		}
+		ctxs = ctxs[next:]
+	}
+
+	body = makeBody(89)
+	le.PutUint32(body[4:], action)
+	putNetworkInfo(body[8:], node)
+	le.PutUint64(body[64:], o.id)
+	le.PutUint64(body[72:], o.id)
+	if len(contexts) > 0 {
+		le.PutUint32(body[80:], headerSize+88)
+		le.PutUint32(body[84:], uint32(len(contexts)))
+		return statusSuccess, append(body, contexts...)
+	}
+	return statusSuccess, append(body, 0)
+}
+
+// appendCreateContext appends a create context response to contexts
+func appendCreateContext(contexts []byte, name string, data []byte) []byte {
+	if len(contexts) > 0 {
+		// Link the previous context to this one
+		start := align8(len(contexts))
+		prev := 0
+		for next := int(le.Uint32(contexts[prev:])); next != 0; next = int(le.Uint32(contexts[prev:])) {
+			prev += next
+		}
+		le.PutUint32(contexts[prev:], uint32(start-prev))
+		contexts = append(contexts, make([]byte, start-len(contexts))...)
+	}
+	ctx := make([]byte, 24, 24+len(data))
+	le.PutUint16(ctx[4:], 16)
+	le.PutUint16(ctx[6:], uint16(len(name)))
+	le.PutUint16(ctx[10:], 24)
+	le.PutUint32(ctx[12:], uint32(len(data)))
+	copy(ctx[16:], name)
+	return append(contexts, append(ctx, data...)...)
+}
+
+// close handles the CLOSE command
+func (c *conn) close(r *request, o *openFile) (status uint32, body []byte) {
+	err := c.closeFile(o)
+	if err != nil {
+		return c.mapError(err), nil
+	}
+	body = makeBody(60)
+	if le.Uint16(r.body[2:])&closeFlagPostQueryAttrib != 0 && !o.deleteOnClose {
+		if node, err := o.sess.vfs.Stat(o.name); err == nil {
+			le.PutUint16(body[2:], closeFlagPostQueryAttrib)
+			putNetworkInfo(body[8:], node)
+		}
+	}
+	return statusSuccess, body
+}
+
+// flush handles the FLUSH command
+func (c *conn) flush(o *openFile) (status uint32, body []byte) {
+	if o.handle != nil {
+		if err := o.handle.Flush(); err != nil {
+			return c.mapError(err), nil
+		}
+	}
+	return statusSuccess, makeBody(4)
+}
+
+// read handles the READ command
+func (c *conn) read(r *request, o *openFile) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	length := le.Uint32(b[4:])
+	offset := int64(le.Uint64(b[8:]))
+	minCount := le.Uint32(b[32:])
+	if length > c.maxSize || offset < 0 {
+		return statusInvalidParameter, nil
+	}
+	if o.isDir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if o.access&(fileReadData|fileExecute) == 0 {
+		return statusAccessDenied, nil
+	}
+	handle, err := o.getHandle(false)
+	if err != nil {
+		return c.mapError(err), nil
+	}
+	const dataOffset = headerSize + 16
+	body = makeBody(17)
+	body = append(body, make([]byte, length)...)
+	n, err := handle.ReadAt(body[16:], offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return c.mapError(err), nil
+	}
+	if (n == 0 && length > 0) || uint32(n) < minCount {
+		return statusEndOfFile, nil
+	}
+	body[2] = dataOffset
+	le.PutUint32(body[4:], uint32(n))
+	return statusSuccess, body[:16+n]
+}
+
+// write handles the WRITE command
+func (c *conn) write(r *request, o *openFile) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	length := int(le.Uint32(b[4:]))
+	offset := le.Uint64(b[8:])
+	data := slice(r.raw, 0, int(le.Uint16(b[2:])), length)
+	if data == nil && length > 0 {
+		return statusInvalidParameter, nil
+	}
+	if o.isDir {
+		return statusInvalidDeviceRequest, nil
+	}
+	if o.access&(fileWriteData|fileAppendData) == 0 {
+		return statusAccessDenied, nil
+	}
+	handle, err := o.getHandle(true)
+	if err != nil {
+		return c.mapError(err), nil
+	}
+	if offset == ^uint64(0) {
+		// Write to the end of the file
+		offset = uint64(handle.Node().Size())
+	}
+	n, err := handle.WriteAt(data, int64(offset))
+	if err != nil {
+		return c.mapError(err), nil
+	}
+	body = makeBody(17)
+	le.PutUint32(body[4:], uint32(n))
+	return statusSuccess, body
+}
+
+// setModTime sets the modification time of the open file
+func (o *openFile) setModTime(t time.Time) error {
+	node, err := o.node()
+	if err != nil {
+		return err
+	}
+	return node.SetModTime(t)
+}
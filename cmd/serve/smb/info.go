@@ -0,0 +1,591 @@
+package smb
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// match returns true if name matches the Windows wildcard pattern
+// ignoring case
+func match(pattern, name string) bool {
+	if pattern == "" || pattern == "*" || pattern == "*.*" {
+		return true
+	}
+	return matchRunes([]rune(strings.ToLower(pattern)), []rune(strings.ToLower(name)))
+}
+
+// matchRunes does the work for match
+//
+// As well as * and ? the DOS wildcards < > and " are supported
+func matchRunes(p, n []rune) bool {
+	for len(p) > 0 {
+		switch p[0] {
+		case '*', '<':
+			for i := 0; i <= len(n); i++ {
+				if matchRunes(p[1:], n[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?', '>':
+			if len(n) == 0 {
+				if p[0] == '>' {
+					p = p[1:]
+					continue
+				}
+				return false
+			}
+		case '"':
+			if len(n) == 0 {
+				p = p[1:]
+				continue
+			}
+			if n[0] != '.' {
+				return false
+			}
+		default:
+			if len(n) == 0 || unicode.ToLower(p[0]) != n[0] {
+				return false
+			}
+		}
+		p, n = p[1:], n[1:]
+	}
+	return len(n) == 0
+}
+
+// readDir makes a snapshot of the directory for listing
+func (o *openFile) readDir() error {
+	VFS := o.sess.vfs
+	node, err := VFS.Stat(o.name)
+	if err != nil {
+		return err
+	}
+	dir, ok := node.(*vfs.Dir)
+	if !ok {
+		return vfs.EINVAL
+	}
+	parent := node
+	if o.name != "" {
+		if parent, err = VFS.Stat(path.Dir(o.name)); err != nil {
+			parent = node
+		}
+	}
+	nodes, err := dir.ReadDirAll()
+	if err != nil {
+		return err
+	}
+	o.listing = make([]dirEntry, 0, len(nodes)+2)
+	o.listing = append(o.listing, dirEntry{name: ".", node: node}, dirEntry{name: "..", node: parent})
+	for _, node := range nodes {
+		o.listing = append(o.listing, dirEntry{name: node.Name(), node: node})
+	}
+	o.listPos = 0
+	o.listSent = false
+	return nil
+}
+
+// encodeDirEntry encodes the directory entry in the information
+// class or returns nil if the class isn't supported
+func encodeDirEntry(class byte, e dirEntry) []byte {
+	name := encodeUTF16(e.name)
+	var b []byte
+	var nameOffset int
+	switch class {
+	case fileNamesInformation:
+		b = make([]byte, 12+len(name))
+		le.PutUint32(b[8:], uint32(len(name)))
+		copy(b[12:], name)
+		return b
+	case fileDirectoryInformation:
+		nameOffset = 64
+	case fileFullDirectoryInformation:
+		nameOffset = 68
+	case fileBothDirectoryInformation:
+		nameOffset = 94
+	case fileIDBothDirectoryInformation:
+		nameOffset = 104
+	case fileIDFullDirectoryInformation:
+		nameOffset = 80
+	default:
+		return nil
+	}
+	b = make([]byte, nameOffset+len(name))
+	putTimes(b[8:], e.node)
+	var size int64
+	if !e.node.IsDir() {
+		size = e.node.Size()
+	}
+	le.PutUint64(b[40:], uint64(size))
+	le.PutUint64(b[48:], allocationSize(size))
+	le.PutUint32(b[56:], fileAttributes(e.node))
+	le.PutUint32(b[60:], uint32(len(name)))
+	switch class {
+	case fileIDBothDirectoryInformation:
+		le.PutUint64(b[96:], e.node.Inode())
+	case fileIDFullDirectoryInformation:
+		le.PutUint64(b[72:], e.node.Inode())
+	}
+	copy(b[nameOffset:], name)
+	return b
+}
+
+// queryDirectory handles the QUERY_DIRECTORY command
+func (c *conn) queryDirectory(r *request, o *openFile) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 32 {
+		return statusInvalidParameter, nil
+	}
+	class, flags := b[2], b[3]
+	pattern := decodeUTF16(slice(r.raw, 0, int(le.Uint16(b[24:])), int(le.Uint16(b[26:]))))
+	outLen := int(le.Uint32(b[28:]))
+	if !o.isDir {
+		return statusInvalidParameter, nil
+	}
+	if o.listing == nil || flags&(restartScans|reopen) != 0 {
+		if err := o.readDir(); err != nil {
+			return c.mapError(err), nil
+		}
+		o.pattern = pattern
+	}
+
+	var out []byte
+	last := -1 // offset of the last entry added
+	for ; o.listPos < len(o.listing); o.listPos++ {
+		e := o.listing[o.listPos]
+		if !match(o.pattern, e.name) {
+			continue
+		}
+		entry := encodeDirEntry(class, e)
+		if entry == nil {
+			return statusInvalidInfoClass, nil
+		}
+		start := align8(len(out))
+		if start+len(entry) > outLen {
+			break
+		}
+		if last >= 0 {
+			le.PutUint32(out[last:], uint32(start-last))
+		}
+		out = append(out, make([]byte, start-len(out))...)
+		out = append(out, entry...)
+		last = start
+		if flags&returnSingleEntry != 0 {
+			o.listPos++
+			break
+		}
+	}
+	if len(out) == 0 {
+		switch {
+		case o.listPos < len(o.listing):
+			return statusInfoLengthMismatch, nil
+		case !o.listSent:
+			return statusNoSuchFile, nil
+		default:
+			return statusNoMoreFiles, nil
+		}
+	}
+	o.listSent = true
+	return statusSuccess, infoResponse(out)
+}
+
+// infoResponse makes the response for QUERY_DIRECTORY and QUERY_INFO
+func infoResponse(out []byte) []byte {
+	body := makeBody(9)
+	le.PutUint16(body[2:], headerSize+8)
+	le.PutUint32(body[4:], uint32(len(out)))
+	return append(body, out...)
+}
+
+// queryInfo handles the QUERY_INFO command
+func (c *conn) queryInfo(r *request, o *openFile) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 40 {
+		return statusInvalidParameter, nil
+	}
+	infoType, class := b[2], b[3]
+	outLen := int(le.Uint32(b[4:]))
+	var (
+		out      []byte
+		variable bool // set if the result is variable length
+	)
+	switch infoType {
+	case infoFile:
+		node, err := o.node()
+		if err != nil {
+			return c.mapError(err), nil
+		}
+		out, variable = o.fileInfo(class, node)
+	case infoFilesystem:
+		out, variable = fsInfo(class, r.sess.vfs, c.s.opt.Share)
+	case infoSecurity:
+		out = securityDescriptor(le.Uint32(b[16:]), o.isDir)
+		if len(out) > outLen {
+			var need [4]byte
+			le.PutUint32(need[:], uint32(len(out)))
+			return statusBufferTooSmall, errorResponse(need[:])
+		}
+	default:
+		return statusNotSupported, nil
+	}
+	if out == nil {
+		return statusInvalidInfoClass, nil
+	}
+	if len(out) > outLen {
+		if !variable {
+			return statusInfoLengthMismatch, nil
+		}
+		return statusBufferOverflow, infoResponse(out[:outLen])
+	}
+	return statusSuccess, infoResponse(out)
+}
+
+// fileInfo returns the file information class for the open file or
+// nil if it isn't supported
+func (o *openFile) fileInfo(class byte, node vfs.Node) (out []byte, variable bool) {
+	basic := func() []byte {
+		b := make([]byte, 40)
+		putTimes(b, node)
+		le.PutUint32(b[32:], fileAttributes(node))
+		return b
+	}
+	standard := func() []byte {
+		b := make([]byte, 24)
+		var size int64
+		if !node.IsDir() {
+			size = node.Size()
+		}
+		le.PutUint64(b[0:], allocationSize(size))
+		le.PutUint64(b[8:], uint64(size))
+		le.PutUint32(b[16:], 1)
+		if o.deleteOnClose {
+			b[20] = 1
+		}
+		if node.IsDir() {
+			b[21] = 1
+		}
+		return b
+	}
+	internal := func() []byte {
+		b := make([]byte, 8)
+		le.PutUint64(b, node.Inode())
+		return b
+	}
+	access := func() []byte {
+		b := make([]byte, 4)
+		le.PutUint32(b, o.access)
+		return b
+	}
+	switch class {
+	case fileBasicInformation:
+		return basic(), false
+	case fileStandardInformation:
+		return standard(), false
+	case fileInternalInformation:
+		return internal(), false
+	case fileEaInformation, fileModeInformation, fileAlignmentInformation:
+		return make([]byte, 4), false
+	case fileAccessInformation:
+		return access(), false
+	case filePositionInformation:
+		return make([]byte, 8), false
+	case fileAllInformation:
+		name := encodeUTF16(`\` + strings.ReplaceAll(o.name, "/", `\`))
+		b := basic()
+		b = append(b, standard()...)
+		b = append(b, internal()...)
+		b = append(b, make([]byte, 4)...) // EA
+		b = append(b, access()...)
+		b = append(b, make([]byte, 8+4+4)...) // position, mode, alignment
+		b = le.AppendUint32(b, uint32(len(name)))
+		return append(b, name...), true
+	case fileStreamInformation:
+		if node.IsDir() {
+			return []byte{}, true
+		}
+		name := encodeUTF16("::$DATA")
+		b := make([]byte, 24, 24+len(name))
+		le.PutUint32(b[4:], uint32(len(name)))
+		le.PutUint64(b[8:], uint64(node.Size()))
+		le.PutUint64(b[16:], allocationSize(node.Size()))
+		return append(b, name...), true
+	case fileNetworkOpenInformation:
+		b := make([]byte, 56)
+		putNetworkInfo(b, node)
+		return b, false
+	case fileAttributeTagInformation:
+		b := make([]byte, 8)
+		le.PutUint32(b, fileAttributes(node))
+		return b, false
+	}
+	return nil, false
+}
+
+// fsInfo returns the filesystem information class or nil if it isn't
+// supported
+func fsInfo(class byte, VFS *vfs.VFS, share string) (out []byte, variable bool) {
+	const (
+		sectorSize        = 512
+		sectorsPerUnit    = 8
+		bytesPerUnit      = sectorSize * sectorsPerUnit
+		fileDeviceDisk    = 7
+		maxComponentBytes = 255
+	)
+	sizes := func() (total, free uint64) {
+		t, _, f := VFS.Statfs()
+		return uint64(max(t, 0)) / bytesPerUnit, uint64(max(f, 0)) / bytesPerUnit
+	}
+	switch class {
+	case fileFsVolumeInformation:
+		label := encodeUTF16(share)
+		b := make([]byte, 18, 18+len(label))
+		le.PutUint32(b[8:], 0x52434C4E) // serial number "RCLN"
+		le.PutUint32(b[12:], uint32(len(label)))
+		return append(b, label...), true
+	case fileFsSizeInformation:
+		total, free := sizes()
+		b := make([]byte, 24)
+		le.PutUint64(b[0:], total)
+		le.PutUint64(b[8:], free)
+		le.PutUint32(b[16:], sectorsPerUnit)
+		le.PutUint32(b[20:], sectorSize)
+		return b, false
+	case fileFsDeviceInformation:
+		b := make([]byte, 8)
+		le.PutUint32(b[0:], fileDeviceDisk)
+		return b, false
+	case fileFsAttributeInformation:
+		// Say NTFS as some clients check it for features
+		name := encodeUTF16("NTFS")
+		attrs := uint32(fileCasePreservedNames | fileUnicodeOnDisk)
+		if !VFS.Opt.CaseInsensitive {
+			attrs |= fileCaseSensitiveSearch
+		}
+		b := make([]byte, 12, 12+len(name))
+		le.PutUint32(b[0:], attrs)
+		le.PutUint32(b[4:], maxComponentBytes)
+		le.PutUint32(b[8:], uint32(len(name)))
+		return append(b, name...), true
+	case fileFsFullSizeInformation:
+		total, free := sizes()
+		b := make([]byte, 32)
+		le.PutUint64(b[0:], total)
+		le.PutUint64(b[8:], free)
+		le.PutUint64(b[16:], free)
+		le.PutUint32(b[24:], sectorsPerUnit)
+		le.PutUint32(b[28:], sectorSize)
+		return b, false
+	case fileFsSectorSizeInformation:
+		b := make([]byte, 28)
+		for i := range 4 {
+			le.PutUint32(b[4*i:], sectorSize)
+		}
+		return b, false
+	}
+	return nil, false
+}
+
+// SIDs used in the security descriptor
+var (
+	sidEveryone = []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}               // S-1-1-0
+	sidAdmins   = []byte{1, 2, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0, 32, 2, 0, 0} // S-1-5-32-544
+)
+
+// securityDescriptor makes a self relative security descriptor with
+// the parts asked for in addInfo giving everyone full access
+func securityDescriptor(addInfo uint32, isDir bool) []byte {
+	const (
+		selfRelative    = 0x8000
+		daclPresent     = 0x0004
+		accessAllowed   = 0
+		inheritFlags    = 0x03 // object and container inherit
+		aclRevision     = 2
+		descriptorBytes = 20
+	)
+	b := make([]byte, descriptorBytes)
+	b[0] = 1
+	control := uint16(selfRelative)
+	if addInfo&ownerSecurityInformation != 0 {
+		le.PutUint32(b[4:], uint32(len(b)))
+		b = append(b, sidAdmins...)
+	}
+	if addInfo&groupSecurityInformation != 0 {
+		le.PutUint32(b[8:], uint32(len(b)))
+		b = append(b, sidAdmins...)
+	}
+	if addInfo&daclSecurityInformation != 0 {
+		control |= daclPresent
+		le.PutUint32(b[16:], uint32(len(b)))
+		ace := make([]byte, 8, 8+len(sidEveryone))
+		ace[0] = accessAllowed
+		if isDir {
+			ace[1] = inheritFlags
+		}
+		le.PutUint16(ace[2:], uint16(8+len(sidEveryone)))
+		le.PutUint32(ace[4:], fileAllAccess)
+		ace = append(ace, sidEveryone...)
+		acl := make([]byte, 8, 8+len(ace))
+		acl[0] = aclRevision
+		le.PutUint16(acl[2:], uint16(8+len(ace)))
+		le.PutUint16(acl[4:], 1)
+		b = append(b, append(acl, ace...)...)
+	}
+	le.PutUint16(b[2:], control)
+	return b
+}
+
+// setInfo handles the SET_INFO command
+func (c *conn) setInfo(r *request, o *openFile) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 32 {
+		return statusInvalidParameter, nil
+	}
+	infoType, class := b[2], b[3]
+	buf := slice(r.raw, 0, int(le.Uint16(b[8:])), int(le.Uint32(b[4:])))
+	switch infoType {
+	case infoFile:
+		status = c.setFileInfo(o, class, buf)
+	case infoSecurity:
+		// Permissions can't be changed so ignore them
+		status = statusSuccess
+	default:
+		status = statusNotSupported
+	}
+	if status != statusSuccess {
+		return status, nil
+	}
+	return statusSuccess, makeBody(2)
+}
+
+// setFileInfo sets the file information class for the open file
+func (c *conn) setFileInfo(o *openFile, class byte, buf []byte) uint32 {
+	VFS := o.sess.vfs
+	switch class {
+	case fileBasicInformation:
+		if len(buf) < 36 {
+			return statusInfoLengthMismatch
+		}
+		// 0 means don't change and -1 and -2 are for suspending
+		// and resuming automatic updates
+		lastWrite := le.Uint64(buf[16:])
+		if lastWrite == 0 || lastWrite >= ^uint64(1) {
+			return statusSuccess
+		}
+		if o.access&fileWriteAttributes == 0 {
+			return statusAccessDenied
+		}
+		return c.mapError(o.setModTime(fromFiletime(lastWrite)))
+	case fileRenameInformation:
+		if len(buf) < 20 {
+			return statusInfoLengthMismatch
+		}
+		replace := buf[0] != 0
+		newName := slice(buf, 0, 20, int(le.Uint32(buf[16:])))
+		if newName == nil {
+			return statusInvalidParameter
+		}
+		if o.access&accessDelete == 0 {
+			return statusAccessDenied
+		}
+		name, ok := smbPath(decodeUTF16(newName))
+		if !ok {
+			return statusObjectNameInvalid
+		}
+		return c.rename(o, name, replace)
+	case fileDispositionInformation, fileDispositionInformationEx:
+		if len(buf) < 1 {
+			return statusInfoLengthMismatch
+		}
+		deletePending := buf[0]&1 != 0
+		if deletePending {
+			if o.access&accessDelete == 0 || VFS.Opt.ReadOnly {
+				return statusAccessDenied
+			}
+			if o.isDir {
+				// Check the directory is empty now as it
+				// can't be reported on CLOSE
+				node, err := VFS.Stat(o.name)
+				if err != nil {
+					return c.mapError(err)
+				}
+				nodes, err := node.(*vfs.Dir).ReadDirAll()
+				if err != nil {
+					return c.mapError(err)
+				}
+				if len(nodes) > 0 {
+					return statusDirectoryNotEmpty
+				}
+			}
+		}
+		o.deleteOnClose = deletePending
+		return statusSuccess
+	case fileEndOfFileInformation:
+		if len(buf) < 8 {
+			return statusInfoLengthMismatch
+		}
+		if o.access&(fileWriteData|fileAppendData) == 0 {
+			return statusAccessDenied
+		}
+		size := int64(le.Uint64(buf))
+		if o.handle == nil && VFS.Opt.CacheMode < vfscommon.CacheModeWrites {
+			// Without the cache the file can only be truncated
+			// through the node
+			node, err := o.node()
+			if err != nil {
+				return c.mapError(err)
+			}
+			return c.mapError(node.Truncate(size))
+		}
+		handle, err := o.getHandle(true)
+		if err != nil {
+			return c.mapError(err)
+		}
+		return c.mapError(handle.Truncate(size))
+	case fileAllocationInformation, filePositionInformation, fileModeInformation:
+		// Nothing to do
+		return statusSuccess
+	}
+	return statusInvalidInfoClass
+}
+
+// rename the open file to newName
+func (c *conn) rename(o *openFile, newName string, replace bool) uint32 {
+	VFS := o.sess.vfs
+	oldName := o.name
+	if newName == oldName {
+		return statusSuccess
+	}
+	if node, err := VFS.Stat(newName); err == nil {
+		// Renaming a file to a different case of itself is OK
+		if !strings.EqualFold(newName, oldName) {
+			if !replace {
+				return statusObjectNameCollision
+			}
+			if node.IsDir() {
+				return statusAccessDenied
+			}
+		}
+	} else if !errors.Is(err, vfs.ENOENT) {
+		return c.mapError(err)
+	}
+	if err := VFS.Rename(oldName, newName); err != nil {
+		return c.mapError(err)
+	}
+
+	// Update the names of other open files which have moved
+	for _, f := range c.files {
+		if f.sess.vfs != VFS {
+			continue
+		}
+		if f.name == oldName {
+			f.name = newName
+		} else if strings.HasPrefix(f.name, oldName+"/") {
+			f.name = newName + f.name[len(oldName):]
+		}
+	}
+	return statusSuccess
+}
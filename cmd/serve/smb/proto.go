@@ -0,0 +1,413 @@
+package smb
+
+// Wire format definitions for SMB2/3 from [MS-SMB2] and [MS-FSCC]
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+var le = binary.LittleEndian
+
+// Protocol identifiers
+var (
+	smb2ProtocolID = []byte{0xFE, 'S', 'M', 'B'}
+	smb1ProtocolID = []byte{0xFF, 'S', 'M', 'B'}
+)
+
+// Size of the SMB2 packet header
+const headerSize = 64
+
+// Dialects
+const (
+	dialect202      = 0x0202
+	dialect210      = 0x0210
+	dialect300      = 0x0300
+	dialect302      = 0x0302
+	dialect311      = 0x0311
+	dialectWildcard = 0x02FF
+)
+
+// Supported dialects in order of preference
+var serverDialects = []uint16{dialect311, dialect302, dialect300, dialect210, dialect202}
+
+// Commands
+const (
+	cmdNegotiate      = 0x0000
+	cmdSessionSetup   = 0x0001
+	cmdLogoff         = 0x0002
+	cmdTreeConnect    = 0x0003
+	cmdTreeDisconnect = 0x0004
+	cmdCreate         = 0x0005
+	cmdClose          = 0x0006
+	cmdFlush          = 0x0007
+	cmdRead           = 0x0008
+	cmdWrite          = 0x0009
+	cmdLock           = 0x000A
+	cmdIoctl          = 0x000B
+	cmdCancel         = 0x000C
+	cmdEcho           = 0x000D
+	cmdQueryDirectory = 0x000E
+	cmdChangeNotify   = 0x000F
+	cmdQueryInfo      = 0x0010
+	cmdSetInfo        = 0x0011
+	cmdOplockBreak    = 0x0012
+)
+
+// Header flags
+const (
+	flagsServerToRedir = 0x00000001
+	flagsAsyncCommand  = 0x00000002
+	flagsRelatedOps    = 0x00000004
+	flagsSigned        = 0x00000008
+)
+
+// Negotiate security modes
+const (
+	signingEnabled  = 0x0001
+	signingRequired = 0x0002
+)
+
+// Global capabilities
+const (
+	globalCapLargeMTU = 0x00000004
+)
+
+// Negotiate contexts
+const (
+	preauthIntegrityCapabilities = 0x0001
+	hashAlgorithmSHA512          = 0x0001
+)
+
+// Session flags
+const (
+	sessionFlagIsGuest = 0x0001
+	sessionFlagIsNull  = 0x0002
+)
+
+// Session setup request flags
+const (
+	sessionFlagBinding = 0x01
+)
+
+// Share types and flags
+const (
+	shareTypeDisk      = 0x01
+	shareTypePipe      = 0x02
+	shareFlagNoCaching = 0x00000030
+)
+
+// Access mask bits
+const (
+	fileReadData        = 0x00000001
+	fileWriteData       = 0x00000002
+	fileAppendData      = 0x00000004
+	fileReadEA          = 0x00000008
+	fileWriteEA         = 0x00000010
+	fileExecute         = 0x00000020
+	fileDeleteChild     = 0x00000040
+	fileReadAttributes  = 0x00000080
+	fileWriteAttributes = 0x00000100
+	accessDelete        = 0x00010000
+	readControl         = 0x00020000
+	writeDAC            = 0x00040000
+	writeOwner          = 0x00080000
+	synchronize         = 0x00100000
+	maximumAllowed      = 0x02000000
+	genericAll          = 0x10000000
+	genericExecute      = 0x20000000
+	genericWrite        = 0x40000000
+	genericRead         = 0x80000000
+
+	fileGenericRead    = readControl | fileReadData | fileReadAttributes | fileReadEA | synchronize
+	fileGenericWrite   = readControl | fileWriteData | fileWriteAttributes | fileWriteEA | fileAppendData | synchronize
+	fileGenericExecute = readControl | fileReadAttributes | fileExecute | synchronize
+	fileAllAccess      = 0x001F01FF
+
+	// access which modifies the file or directory
+	modifyAccess = fileWriteData | fileAppendData | fileWriteEA | fileDeleteChild | fileWriteAttributes | accessDelete | writeDAC | writeOwner
+)
+
+// Create dispositions
+const (
+	fileSupersede   = 0
+	fileOpen        = 1
+	fileCreate      = 2
+	fileOpenIf      = 3
+	fileOverwrite   = 4
+	fileOverwriteIf = 5
+)
+
+// Create options
+const (
+	fileDirectoryFile    = 0x00000001
+	fileNonDirectoryFile = 0x00000040
+	fileDeleteOnClose    = 0x00001000
+)
+
+// Create actions
+const (
+	fileSuperseded  = 0
+	fileOpened      = 1
+	fileCreated     = 2
+	fileOverwritten = 3
+)
+
+// File attributes
+const (
+	fileAttributeReadonly  = 0x00000001
+	fileAttributeDirectory = 0x00000010
+	fileAttributeArchive   = 0x00000020
+)
+
+// Close flags
+const (
+	closeFlagPostQueryAttrib = 0x0001
+)
+
+// Query directory flags
+const (
+	restartScans      = 0x01
+	returnSingleEntry = 0x02
+	indexSpecified    = 0x04
+	reopen            = 0x10
+)
+
+// Info types
+const (
+	infoFile       = 0x01
+	infoFilesystem = 0x02
+	infoSecurity   = 0x03
+	infoQuota      = 0x04
+)
+
+// File information classes
+const (
+	fileDirectoryInformation       = 1
+	fileFullDirectoryInformation   = 2
+	fileBothDirectoryInformation   = 3
+	fileBasicInformation           = 4
+	fileStandardInformation        = 5
+	fileInternalInformation        = 6
+	fileEaInformation              = 7
+	fileAccessInformation          = 8
+	fileRenameInformation          = 10
+	fileNamesInformation           = 12
+	fileDispositionInformation     = 13
+	filePositionInformation        = 14
+	fileModeInformation            = 16
+	fileAlignmentInformation       = 17
+	fileAllInformation             = 18
+	fileAllocationInformation      = 19
+	fileEndOfFileInformation       = 20
+	fileStreamInformation          = 22
+	fileNetworkOpenInformation     = 34
+	fileAttributeTagInformation    = 35
+	fileIDBothDirectoryInformation = 37
+	fileIDFullDirectoryInformation = 38
+	fileDispositionInformationEx   = 64
+)
+
+// Filesystem information classes
+const (
+	fileFsVolumeInformation     = 1
+	fileFsSizeInformation       = 3
+	fileFsDeviceInformation     = 4
+	fileFsAttributeInformation  = 5
+	fileFsFullSizeInformation   = 7
+	fileFsSectorSizeInformation = 11
+)
+
+// Filesystem attributes
+const (
+	fileCaseSensitiveSearch = 0x00000001
+	fileCasePreservedNames  = 0x00000002
+	fileUnicodeOnDisk       = 0x00000004
+)
+
+// Security information
+const (
+	ownerSecurityInformation = 0x00000001
+	groupSecurityInformation = 0x00000002
+	daclSecurityInformation  = 0x00000004
+)
+
+// IOCTL codes
+const (
+	fsctlDfsGetReferrals       = 0x00060194
+	fsctlValidateNegotiateInfo = 0x00140204
+	fsctlDfsGetReferralsEx     = 0x000601B0
+	ioctlFlagIsFsctl           = 0x00000001
+)
+
+// NTSTATUS codes
+const (
+	statusSuccess                = 0x00000000
+	statusBufferOverflow         = 0x80000005
+	statusNoMoreFiles            = 0x80000006
+	statusNotImplemented         = 0xC0000002
+	statusInvalidInfoClass       = 0xC0000003
+	statusInfoLengthMismatch     = 0xC0000004
+	statusInvalidParameter       = 0xC000000D
+	statusNoSuchFile             = 0xC000000F
+	statusInvalidDeviceRequest   = 0xC0000010
+	statusEndOfFile              = 0xC0000011
+	statusMoreProcessingRequired = 0xC0000016
+	statusAccessDenied           = 0xC0000022
+	statusBufferTooSmall         = 0xC0000023
+	statusObjectNameInvalid      = 0xC0000033
+	statusObjectNameNotFound     = 0xC0000034
+	statusObjectNameCollision    = 0xC0000035
+	statusObjectPathNotFound     = 0xC000003A
+	statusDeletePending          = 0xC0000056
+	statusLogonFailure           = 0xC000006D
+	statusFileIsADirectory       = 0xC00000BA
+	statusNotSupported           = 0xC00000BB
+	statusBadNetworkName         = 0xC00000CC
+	statusRequestNotAccepted     = 0xC00000D0
+	statusUnexpectedIOError      = 0xC00000E9
+	statusDirectoryNotEmpty      = 0xC0000101
+	statusNotADirectory          = 0xC0000103
+	statusFileClosed             = 0xC0000128
+	statusFsDriverRequired       = 0xC000019C
+	statusUserSessionDeleted     = 0xC0000203
+	statusNetworkNameDeleted     = 0xC00000C9
+	statusMediaWriteProtected    = 0xC00000A2
+)
+
+// header is a decoded SMB2 packet header
+type header struct {
+	creditCharge  uint16
+	status        uint32
+	command       uint16
+	creditRequest uint16
+	flags         uint32
+	nextCommand   uint32
+	messageID     uint64
+	asyncID       uint64
+	reserved      uint32 // ProcessId for sync messages
+	treeID        uint32
+	sessionID     uint64
+	signature     [16]byte
+}
+
+// decodeHeader decodes the header at the start of buf which must be
+// at least headerSize long
+func decodeHeader(buf []byte) (h header) {
+	h.creditCharge = le.Uint16(buf[6:])
+	h.status = le.Uint32(buf[8:])
+	h.command = le.Uint16(buf[12:])
+	h.creditRequest = le.Uint16(buf[14:])
+	h.flags = le.Uint32(buf[16:])
+	h.nextCommand = le.Uint32(buf[20:])
+	h.messageID = le.Uint64(buf[24:])
+	if h.flags&flagsAsyncCommand != 0 {
+		h.asyncID = le.Uint64(buf[32:])
+	} else {
+		h.reserved = le.Uint32(buf[32:])
+		h.treeID = le.Uint32(buf[36:])
+	}
+	h.sessionID = le.Uint64(buf[40:])
+	copy(h.signature[:], buf[48:64])
+	return h
+}
+
+// encode the header into the start of buf which must be at least
+// headerSize long
+func (h *header) encode(buf []byte) {
+	copy(buf[0:4], smb2ProtocolID)
+	le.PutUint16(buf[4:], headerSize)
+	le.PutUint16(buf[6:], h.creditCharge)
+	le.PutUint32(buf[8:], h.status)
+	le.PutUint16(buf[12:], h.command)
+	le.PutUint16(buf[14:], h.creditRequest)
+	le.PutUint32(buf[16:], h.flags)
+	le.PutUint32(buf[20:], h.nextCommand)
+	le.PutUint64(buf[24:], h.messageID)
+	if h.flags&flagsAsyncCommand != 0 {
+		le.PutUint64(buf[32:], h.asyncID)
+	} else {
+		le.PutUint32(buf[32:], h.reserved)
+		le.PutUint32(buf[36:], h.treeID)
+	}
+	le.PutUint64(buf[40:], h.sessionID)
+	copy(buf[48:64], h.signature[:])
+}
+
+// errorResponse makes the body of an SMB2 ERROR response with data
+// as the ErrorData
+func errorResponse(data []byte) []byte {
+	body := make([]byte, 8, 9+len(data))
+	le.PutUint16(body[0:], 9)
+	le.PutUint32(body[4:], uint32(len(data)))
+	if len(data) == 0 {
+		// There must always be at least one byte of data
+		return append(body, 0)
+	}
+	return append(body, data...)
+}
+
+// encodeUTF16 encodes s as UTF-16LE
+func encodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		le.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+// decodeUTF16 decodes UTF-16LE in b
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = le.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// Number of 100ns intervals between 1601-01-01 and 1970-01-01
+const filetimeEpoch = 116444736000000000
+
+// toFiletime converts t to a Windows FILETIME
+func toFiletime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100 + filetimeEpoch)
+}
+
+// fromFiletime converts a Windows FILETIME to a time.Time
+func fromFiletime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-filetimeEpoch)*100)
+}
+
+// slice returns the length bytes at offset in buf, where offset is
+// measured from base, or nil if they are out of range
+func slice(buf []byte, base, offset, length int) []byte {
+	offset -= base
+	if length == 0 || offset < 0 || length < 0 || offset+length > len(buf) {
+		return nil
+	}
+	return buf[offset : offset+length]
+}
+
+// align8 rounds n up to a multiple of 8
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// smbPath converts an SMB path relative to the share root into a VFS
+// path returning false if it has "." or ".." segments which could
+// escape the share
+func smbPath(name string) (string, bool) {
+	name = strings.Trim(strings.ReplaceAll(name, `\`, "/"), "/")
+	for segment := range strings.SplitSeq(name, "/") {
+		if segment == "." || segment == ".." {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,941 @@
+package smb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/fs"
+	sdActivation "github.com/rclone/rclone/lib/sdactivation"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+const (
+	// NetBIOS name of the server used in NTLM
+	serverName = "RCLONE"
+	// maximum read, write and transact size for SMB 2.1 and later
+	maxLargeSize = 1024 * 1024
+	// maximum read, write and transact size for SMB 2.0.2
+	maxSmallSize = 64 * 1024
+	// maximum number of credits a client can hold
+	maxCredits = 512
+)
+
+// server contains everything to run the server
+type server struct {
+	f         fs.Fs
+	opt       Options
+	vfs       *vfs.VFS
+	ctx       context.Context // for global config
+	listener  net.Listener
+	stopped   chan struct{} // for waiting on the listener to stop
+	proxy     *proxy.Proxy
+	guid      [16]byte      // server GUID
+	sessionID atomic.Uint64 // last session ID issued
+
+	mu    sync.Mutex
+	conns map[*conn]struct{} // open connections
+}
+
+func newServer(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Options, proxyOpt *proxy.Options) (*server, error) {
+	s := &server{
+		f:       f,
+		ctx:     ctx,
+		opt:     *opt,
+		stopped: make(chan struct{}),
+		conns:   make(map[*conn]struct{}),
+	}
+	if s.opt.Share == "" || strings.ContainsAny(s.opt.Share, `\/`) || strings.EqualFold(s.opt.Share, "IPC$") {
+		return nil, fmt.Errorf("smb: invalid share name %q", s.opt.Share)
+	}
+	if proxyOpt.AuthProxy != "" {
+		s.proxy = proxy.New(ctx, proxyOpt, vfsOpt)
+	} else {
+		if s.opt.User == "" && !s.opt.NoAuth {
+			return nil, errors.New("smb: no authentication configured - use --user and --pass, --auth-proxy or --no-auth")
+		}
+		s.vfs = vfs.New(f, vfsOpt)
+	}
+	if _, err := rand.Read(s.guid[:]); err != nil {
+		return nil, err
+	}
+
+	// In case we run in a socket-activated environment, listen on (the first)
+	// passed FD.
+	sdListeners, err := sdActivation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("smb: unable to acquire listeners: %w", err)
+	}
+	if len(sdListeners) > 0 {
+		if len(sdListeners) > 1 {
+			fs.LogPrintf(fs.LogLevelWarning, nil, "more than one listener passed, ignoring all but the first.\n")
+		}
+		s.listener = sdListeners[0]
+	} else {
+		s.listener, err = net.Listen("tcp", s.opt.ListenAddr)
+		if err != nil {
+			return nil, fmt.Errorf("smb: failed to listen for connection: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Serve SMB until the server is Shutdown
+func (s *server) Serve() (err error) {
+	fs.Logf(nil, "SMB server listening on %v serving share %q\n", s.listener.Addr(), s.opt.Share)
+	for {
+		nConn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			fs.Errorf(nil, "Failed to accept incoming connection: %v", err)
+			continue
+		}
+		c := newConn(s, nConn)
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go c.serve()
+	}
+	close(s.stopped)
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Wait blocks while the listener is open.
+func (s *server) Wait() {
+	<-s.stopped
+}
+
+// Shutdown shuts the running server down
+func (s *server) Shutdown() error {
+	err := s.listener.Close()
+	s.Wait()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.c.Close()
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// authenticate checks the NTLM AUTHENTICATE message returning the
+// VFS to use, the session key and whether this is a guest login
+func (s *server) authenticate(n *ntlmServer, a *ntlmAuth) (VFS *vfs.VFS, sessionKey []byte, guest bool, err error) {
+	switch {
+	case s.proxy != nil:
+		if a.anonymous() {
+			return nil, nil, false, errors.New("anonymous login not allowed")
+		}
+		VFS, _, err = s.proxy.CallVerify(a.user, func(password string) bool {
+			var ok bool
+			sessionKey, ok = n.verify(a, password)
+			return ok
+		})
+		return VFS, sessionKey, false, err
+	case a.anonymous():
+		err = errors.New("anonymous login not allowed")
+	case s.opt.User == "":
+		err = errors.New("no user configured")
+	case !strings.EqualFold(a.user, s.opt.User):
+		err = errors.New("unknown user")
+	default:
+		// The configured user must log in with the password
+		// even if guest access is allowed
+		var ok bool
+		sessionKey, ok = n.verify(a, s.opt.Pass)
+		if ok {
+			return s.vfs, sessionKey, false, nil
+		}
+		return nil, nil, false, errors.New("incorrect password")
+	}
+	// Anonymous logins and unknown users get guest access if allowed
+	if s.opt.NoAuth {
+		return s.vfs, nil, true, nil
+	}
+	return nil, nil, false, err
+}
+
+// session is an authenticated user on a connection
+type session struct {
+	id              uint64
+	ntlm            *ntlmServer // authentication in progress
+	mechTypes       []byte      // SPNEGO mechTypes sent by the client
+	rawNTLM         bool        // set if the client isn't using SPNEGO
+	valid           bool        // set when authentication is complete
+	guest           bool        // set for a guest session
+	user            string
+	vfs             *vfs.VFS
+	sign            signer // nil if not signing
+	signingRequired bool
+	preauth         []byte // SMB 3.1.1 pre-authentication integrity hash
+	trees           map[uint32]*tree
+	lastTreeID      uint32
+}
+
+// tree is a connection to a share
+type tree struct {
+	id  uint32
+	ipc bool // set if this is the IPC$ share
+}
+
+// conn is a single client connection
+type conn struct {
+	s    *server
+	c    net.Conn
+	what string
+
+	negotiated         bool
+	dialect            uint16
+	clientGUID         [16]byte
+	clientCapabilities uint32
+	clientSecurity     uint16
+	clientDialects     []uint16
+	capabilities       uint32
+	maxSize            uint32 // maximum read, write and transact size
+	preauth            []byte // SMB 3.1.1 pre-authentication integrity hash
+	credits            uint32 // credits granted and not yet used
+	sessions           map[uint64]*session
+	files              map[uint64]*openFile
+	lastFileID         uint64
+}
+
+func newConn(s *server, c net.Conn) *conn {
+	return &conn{
+		s:        s,
+		c:        c,
+		what:     fmt.Sprintf("serve smb %s->%s", c.RemoteAddr(), c.LocalAddr()),
+		credits:  1,
+		maxSize:  maxSmallSize,
+		sessions: make(map[uint64]*session),
+		files:    make(map[uint64]*openFile),
+	}
+}
+
+// serve the connection until it is closed
+func (c *conn) serve() {
+	fs.Debugf(c.what, "New connection")
+	defer func() {
+		for _, o := range c.files {
+			_ = c.closeFile(o)
+		}
+		_ = c.c.Close()
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+		fs.Debugf(c.what, "Connection closed")
+	}()
+	for {
+		msg, err := c.readMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				fs.Debugf(c.what, "Closing connection: %v", err)
+			}
+			return
+		}
+		var out []byte
+		if bytes.HasPrefix(msg, smb1ProtocolID) {
+			out, err = c.smb1Negotiate(msg)
+		} else {
+			out, err = c.handleMessages(msg)
+		}
+		if err != nil {
+			fs.Infof(c.what, "Closing connection: %v", err)
+			return
+		}
+		if out != nil {
+			err = c.writeMessage(out)
+			if err != nil {
+				fs.Debugf(c.what, "Closing connection: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// readMessage reads a message using the Direct TCP transport
+func (c *conn) readMessage() ([]byte, error) {
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(c.c, hdr[:]); err != nil {
+			return nil, err
+		}
+		length := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+		if hdr[0] == 0 && length > 0 {
+			if length > int(c.maxSize)+maxSmallSize {
+				return nil, fmt.Errorf("message too long (%d bytes)", length)
+			}
+			msg := make([]byte, length)
+			if _, err := io.ReadFull(c.c, msg); err != nil {
+				return nil, err
+			}
+			return msg, nil
+		}
+		// Ignore keep alives and other NetBIOS messages
+		if _, err := io.CopyN(io.Discard, c.c, int64(length)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeMessage writes a message using the Direct TCP transport
+func (c *conn) writeMessage(msg []byte) error {
+	buf := make([]byte, 4+len(msg))
+	buf[1], buf[2], buf[3] = byte(len(msg)>>16), byte(len(msg)>>8), byte(len(msg))
+	copy(buf[4:], msg)
+	_, err := c.c.Write(buf)
+	return err
+}
+
+// request is a single decoded SMB2 request
+type request struct {
+	hdr  header
+	raw  []byte // the whole message including the header
+	body []byte // the message after the header
+	sess *session
+	tree *tree
+}
+
+// response is a single SMB2 response
+type response struct {
+	hdr   header
+	body  []byte
+	sign  signer           // sign with this if set
+	after func(msg []byte) // called with the final message if set
+}
+
+// compound holds the state carried between related requests
+type compound struct {
+	sessionID uint64
+	treeID    uint32
+	fileID    uint64 // FileId of the last CREATE
+	fileErr   uint32 // status of a failed CREATE
+}
+
+// handleMessages handles the possibly compounded SMB2 requests in msg
+func (c *conn) handleMessages(msg []byte) ([]byte, error) {
+	var (
+		state compound
+		rsps  []*response
+	)
+	for len(msg) > 0 {
+		if len(msg) < headerSize || !bytes.HasPrefix(msg, smb2ProtocolID) {
+			return nil, errors.New("bad SMB2 message")
+		}
+		h := decodeHeader(msg)
+		size := len(msg)
+		if h.nextCommand != 0 {
+			size = int(h.nextCommand)
+			if size < headerSize || size > len(msg) {
+				return nil, errors.New("bad SMB2 compound message")
+			}
+		}
+		r := &request{hdr: h, raw: msg[:size], body: msg[headerSize:size]}
+		msg = msg[size:]
+		if h.nextCommand == 0 {
+			msg = nil
+		}
+		rsp, err := c.handleRequest(r, &state)
+		if err != nil {
+			return nil, err
+		}
+		if rsp != nil {
+			rsps = append(rsps, rsp)
+		}
+	}
+	if len(rsps) == 0 {
+		return nil, nil
+	}
+
+	// Assemble the responses, padding and signing each
+	var out []byte
+	for i, rsp := range rsps {
+		size := headerSize + len(rsp.body)
+		if i < len(rsps)-1 {
+			size = align8(size)
+			rsp.hdr.nextCommand = uint32(size)
+		}
+		buf := make([]byte, size)
+		rsp.hdr.encode(buf)
+		copy(buf[headerSize:], rsp.body)
+		if rsp.sign != nil {
+			rsp.sign.sign(buf)
+		}
+		if rsp.after != nil {
+			rsp.after(buf)
+		}
+		out = append(out, buf...)
+	}
+	return out, nil
+}
+
+// grantCredits charges the request for its credits and works out how
+// many credits to grant in the response
+func (c *conn) grantCredits(h *header) uint16 {
+	charge := max(uint32(h.creditCharge), 1)
+	c.credits -= min(charge, c.credits)
+	grant := max(uint32(h.creditRequest), 1)
+	if c.credits+grant > maxCredits {
+		grant = maxCredits - c.credits
+	}
+	if grant == 0 && c.credits == 0 {
+		grant = 1
+	}
+	c.credits += grant
+	return uint16(grant)
+}
+
+// handleRequest handles a single request returning a response to
+// send, nil for no response or an error to close the connection
+func (c *conn) handleRequest(r *request, state *compound) (*response, error) {
+	h := &r.hdr
+	if h.command == cmdCancel {
+		// Requests are processed synchronously so there is nothing to cancel
+		return nil, nil
+	}
+	if !c.negotiated && h.command != cmdNegotiate {
+		return nil, fmt.Errorf("command 0x%x before negotiate", h.command)
+	}
+	rsp := &response{
+		hdr: header{
+			creditCharge: h.creditCharge,
+			command:      h.command,
+			flags:        flagsServerToRedir | h.flags&flagsRelatedOps,
+			messageID:    h.messageID,
+			reserved:     h.reserved,
+			treeID:       h.treeID,
+			sessionID:    h.sessionID,
+		},
+	}
+	rsp.hdr.creditRequest = c.grantCredits(h)
+	if h.flags&flagsRelatedOps != 0 {
+		h.sessionID, rsp.hdr.sessionID = state.sessionID, state.sessionID
+		h.treeID, rsp.hdr.treeID = state.treeID, state.treeID
+	}
+
+	status, body, err := c.dispatch(r, rsp, state)
+	if err != nil {
+		return nil, err
+	}
+	rsp.hdr.status = status
+	if body == nil {
+		body = errorResponse(nil)
+	}
+	rsp.body = body
+
+	state.sessionID = rsp.hdr.sessionID
+	state.treeID = rsp.hdr.treeID
+	return rsp, nil
+}
+
+// dispatch checks the session and tree then calls the handler for the command
+func (c *conn) dispatch(r *request, rsp *response, state *compound) (status uint32, body []byte, err error) {
+	h := &r.hdr
+	switch h.command {
+	case cmdNegotiate:
+		return c.negotiate(r, rsp)
+	case cmdSessionSetup:
+		status, body = c.sessionSetup(r, rsp)
+		return status, body, nil
+	}
+
+	// Check the session and the signature
+	sess := c.sessions[h.sessionID]
+	if sess == nil || !sess.valid {
+		if h.command == cmdEcho {
+			return statusSuccess, makeBody(4), nil
+		}
+		return statusUserSessionDeleted, nil, nil
+	}
+	if sess.sign != nil {
+		if h.flags&flagsSigned != 0 {
+			if !sess.sign.check(r.raw) {
+				fs.Errorf(c.what, "Bad signature on message")
+				return statusAccessDenied, nil, nil
+			}
+			rsp.sign = sess.sign
+		} else if sess.signingRequired {
+			return statusAccessDenied, nil, nil
+		}
+	}
+	r.sess = sess
+
+	switch h.command {
+	case cmdLogoff:
+		for _, o := range c.files {
+			if o.sess == sess {
+				_ = c.closeFile(o)
+			}
+		}
+		delete(c.sessions, sess.id)
+		return statusSuccess, makeBody(4), nil
+	case cmdTreeConnect:
+		status, body = c.treeConnect(r, rsp)
+		return status, body, nil
+	case cmdEcho:
+		return statusSuccess, makeBody(4), nil
+	}
+
+	// Check the tree
+	r.tree = sess.trees[h.treeID]
+	if r.tree == nil {
+		return statusNetworkNameDeleted, nil, nil
+	}
+
+	// Fail operations on the file from a failed CREATE in the compound
+	if h.flags&flagsRelatedOps != 0 && state.fileErr != statusSuccess && h.command != cmdCreate {
+		return state.fileErr, nil, nil
+	}
+
+	switch h.command {
+	case cmdTreeDisconnect:
+		for _, o := range c.files {
+			if o.tree == r.tree {
+				_ = c.closeFile(o)
+			}
+		}
+		delete(sess.trees, r.tree.id)
+		return statusSuccess, makeBody(4), nil
+	case cmdCreate:
+		status, body = c.create(r)
+		state.fileErr = status
+		if status == statusSuccess {
+			state.fileID = le.Uint64(body[72:])
+		}
+	case cmdIoctl:
+		return c.ioctl(r, state)
+	case cmdChangeNotify, cmdOplockBreak:
+		status = statusNotSupported
+	default:
+		var o *openFile
+		o, status = c.getFile(r, state)
+		if status != statusSuccess {
+			return status, nil, nil
+		}
+		switch h.command {
+		case cmdClose:
+			status, body = c.close(r, o)
+		case cmdFlush:
+			status, body = c.flush(o)
+		case cmdRead:
+			status, body = c.read(r, o)
+		case cmdWrite:
+			status, body = c.write(r, o)
+		case cmdLock:
+			// Byte range locks aren't supported by the VFS so
+			// just say they were granted
+			status, body = statusSuccess, makeBody(4)
+		case cmdQueryDirectory:
+			status, body = c.queryDirectory(r, o)
+		case cmdQueryInfo:
+			status, body = c.queryInfo(r, o)
+		case cmdSetInfo:
+			status, body = c.setInfo(r, o)
+		default:
+			status = statusNotSupported
+		}
+	}
+	return status, body, nil
+}
+
+// makeBody makes a response body with the structure size set
+func makeBody(structureSize int) []byte {
+	body := make([]byte, structureSize&^1)
+	le.PutUint16(body, uint16(structureSize))
+	return body
+}
+
+// negotiateResponse makes the body of the NEGOTIATE response
+func (c *conn) negotiateResponse(dialect uint16) []byte {
+	maxSize := uint32(maxSmallSize)
+	var capabilities uint32
+	if dialect != dialect202 && dialect != dialectWildcard {
+		maxSize = maxLargeSize
+		capabilities = globalCapLargeMTU
+	}
+	security := spnegoInit()
+	const securityOffset = headerSize + 64
+	body := makeBody(65)
+	le.PutUint16(body[2:], signingEnabled)
+	le.PutUint16(body[4:], dialect)
+	copy(body[8:24], c.s.guid[:])
+	le.PutUint32(body[24:], capabilities)
+	le.PutUint32(body[28:], maxSize)
+	le.PutUint32(body[32:], maxSize)
+	le.PutUint32(body[36:], maxSize)
+	le.PutUint64(body[40:], toFiletime(time.Now()))
+	le.PutUint16(body[56:], securityOffset)
+	le.PutUint16(body[58:], uint16(len(security)))
+	body = append(body, security...)
+	if dialect == dialect311 {
+		// Add the pre-authentication integrity capabilities
+		contextOffset := align8(securityOffset + len(security))
+		body = append(body, make([]byte, contextOffset-headerSize-len(body))...)
+		le.PutUint16(body[6:], 1)
+		le.PutUint32(body[60:], uint32(contextOffset))
+		ctx := make([]byte, 8+6+32)
+		le.PutUint16(ctx[0:], preauthIntegrityCapabilities)
+		le.PutUint16(ctx[2:], 6+32)
+		le.PutUint16(ctx[8:], 1)
+		le.PutUint16(ctx[10:], 32)
+		le.PutUint16(ctx[12:], hashAlgorithmSHA512)
+		_, _ = rand.Read(ctx[14:])
+		body = append(body, ctx...)
+	}
+	c.capabilities = capabilities
+	c.maxSize = maxSize
+	return body
+}
+
+// smb1Negotiate handles an SMB1 NEGOTIATE from a client which is
+// trying to find out whether SMB2 is supported
+func (c *conn) smb1Negotiate(msg []byte) ([]byte, error) {
+	if c.negotiated || len(msg) < 35 || msg[4] != 0x72 {
+		return nil, errors.New("SMB1 is not supported")
+	}
+	var wildcard, smb202 bool
+	for _, dialect := range bytes.Split(msg[35:], []byte{0}) {
+		switch string(bytes.TrimPrefix(dialect, []byte{0x02})) {
+		case "SMB 2.???":
+			wildcard = true
+		case "SMB 2.002":
+			smb202 = true
+		}
+	}
+	dialect := uint16(dialectWildcard)
+	switch {
+	case wildcard:
+	case smb202:
+		dialect = dialect202
+		c.dialect = dialect
+		c.negotiated = true
+	default:
+		return nil, errors.New("SMB1 is not supported")
+	}
+	rsp := header{
+		command:       cmdNegotiate,
+		flags:         flagsServerToRedir,
+		creditRequest: 1,
+	}
+	body := c.negotiateResponse(dialect)
+	out := make([]byte, headerSize+len(body))
+	rsp.encode(out)
+	copy(out[headerSize:], body)
+	return out, nil
+}
+
+// negotiate handles the SMB2 NEGOTIATE command
+func (c *conn) negotiate(r *request, rsp *response) (status uint32, body []byte, err error) {
+	if c.negotiated {
+		return 0, nil, errors.New("second NEGOTIATE on connection")
+	}
+	b := r.body
+	if len(b) < 36 {
+		return statusInvalidParameter, nil, nil
+	}
+	count := int(le.Uint16(b[2:]))
+	if len(b) < 36+2*count {
+		return statusInvalidParameter, nil, nil
+	}
+	c.clientSecurity = le.Uint16(b[4:])
+	c.clientCapabilities = le.Uint32(b[8:])
+	copy(c.clientGUID[:], b[12:28])
+	c.clientDialects = make([]uint16, count)
+	for i := range c.clientDialects {
+		c.clientDialects[i] = le.Uint16(b[36+2*i:])
+	}
+
+	// Choose the dialect
+	var dialect uint16
+outer:
+	for _, d := range serverDialects {
+		for _, clientDialect := range c.clientDialects {
+			if d == clientDialect {
+				dialect = d
+				break outer
+			}
+		}
+	}
+	if dialect == 0 {
+		return statusNotSupported, nil, nil
+	}
+
+	// SMB 3.1.1 must use SHA-512 for pre-authentication integrity
+	if dialect == dialect311 {
+		var sha512OK bool
+		offset := int(le.Uint32(b[28:]))
+		for range le.Uint16(b[32:]) {
+			ctx := slice(r.raw, 0, offset, 8)
+			if ctx == nil {
+				break
+			}
+			length := int(le.Uint16(ctx[2:]))
+			data := slice(r.raw, 0, offset+8, length)
+			if le.Uint16(ctx[0:]) == preauthIntegrityCapabilities && len(data) >= 4 {
+				for i := range int(le.Uint16(data[0:])) {
+					if 4+2*i+2 <= len(data) && le.Uint16(data[4+2*i:]) == hashAlgorithmSHA512 {
+						sha512OK = true
+					}
+				}
+			}
+			offset = align8(offset + 8 + length)
+		}
+		if !sha512OK {
+			return statusInvalidParameter, nil, nil
+		}
+		h := sha512.Sum512(append(make([]byte, sha512.Size), r.raw...))
+		c.preauth = h[:]
+		rsp.after = func(msg []byte) {
+			h := sha512.Sum512(append(c.preauth, msg...))
+			c.preauth = h[:]
+		}
+	}
+
+	c.dialect = dialect
+	c.negotiated = true
+	fs.Debugf(c.what, "Negotiated SMB dialect %x.%02x", dialect>>8, dialect&0xFF)
+	return statusSuccess, c.negotiateResponse(dialect), nil
+}
+
+// sessionSetup handles the SESSION_SETUP command
+func (c *conn) sessionSetup(r *request, rsp *response) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 24 {
+		return statusInvalidParameter, nil
+	}
+	if b[2]&sessionFlagBinding != 0 {
+		// Multichannel isn't supported
+		return statusRequestNotAccepted, nil
+	}
+	securityMode := uint16(b[3])
+	token := slice(r.raw, 0, int(le.Uint16(b[12:])), int(le.Uint16(b[14:])))
+
+	// Find or make the session
+	var sess *session
+	if r.hdr.sessionID == 0 {
+		sess = &session{
+			id:    c.s.sessionID.Add(1),
+			trees: make(map[uint32]*tree),
+		}
+		if c.dialect == dialect311 {
+			sess.preauth = bytes.Clone(c.preauth)
+		}
+		c.sessions[sess.id] = sess
+	} else {
+		sess = c.sessions[r.hdr.sessionID]
+		if sess == nil {
+			return statusUserSessionDeleted, nil
+		}
+	}
+	rsp.hdr.sessionID = sess.id
+	fail := func(format string, args ...any) (uint32, []byte) {
+		fs.Infof(c.what, "SMB login failed: "+format, args...)
+		if !sess.valid {
+			delete(c.sessions, sess.id)
+		}
+		sess.ntlm = nil
+		return statusLogonFailure, nil
+	}
+	if c.dialect == dialect311 && !sess.valid {
+		h := sha512.Sum512(append(sess.preauth, r.raw...))
+		sess.preauth = h[:]
+	}
+
+	t, err := parseSpnego(token)
+	if err != nil {
+		return fail("%v", err)
+	}
+	if len(t.mechToken) < 12 {
+		return fail("short NTLM message")
+	}
+	var out []byte
+	switch le.Uint32(t.mechToken[8:]) {
+	case ntlmNegotiate:
+		sess.ntlm = &ntlmServer{name: serverName}
+		sess.rawNTLM = t.raw
+		sess.mechTypes = t.mechTypes
+		challenge, err := sess.ntlm.challengeMessage(t.mechToken)
+		if err != nil {
+			return fail("%v", err)
+		}
+		out = challenge
+		if !t.raw {
+			out = spnegoResp(negStateAcceptIncomplete, true, challenge, nil)
+		}
+		status = statusMoreProcessingRequired
+		if c.dialect == dialect311 && !sess.valid {
+			rsp.after = func(msg []byte) {
+				h := sha512.Sum512(append(sess.preauth, msg...))
+				sess.preauth = h[:]
+			}
+		}
+	case ntlmAuthenticate:
+		if sess.ntlm == nil {
+			return fail("NTLM AUTHENTICATE without NEGOTIATE")
+		}
+		a, err := sess.ntlm.parseAuthenticate(t.mechToken)
+		if err != nil {
+			return fail("%v", err)
+		}
+		VFS, sessionKey, guest, err := c.s.authenticate(sess.ntlm, a)
+		if err != nil {
+			return fail("user %q: %v", a.user, err)
+		}
+		var mic []byte
+		if sessionKey != nil && sess.mechTypes != nil {
+			if t.mechListMIC != nil && !bytes.Equal(t.mechListMIC, sess.ntlm.mechListMIC(sessionKey, sess.mechTypes, true)) {
+				return fail("user %q: bad mechListMIC", a.user)
+			}
+			mic = sess.ntlm.mechListMIC(sessionKey, sess.mechTypes, false)
+		}
+		if !t.raw {
+			out = spnegoResp(negStateAcceptCompleted, false, nil, mic)
+		}
+		if !sess.valid {
+			// Re-authentication doesn't change the session
+			sess.user = a.user
+			sess.vfs = VFS
+			sess.guest = guest
+			if !guest {
+				sess.sign = newSigner(c.dialect, sessionKey, sess.preauth)
+				sess.signingRequired = (c.clientSecurity|securityMode)&signingRequired != 0
+			}
+			sess.valid = true
+		}
+		sess.ntlm = nil
+		// The final response is signed so the client knows the
+		// session key is right
+		rsp.sign = sess.sign
+		if guest {
+			fs.Infof(c.what, "SMB guest login")
+		} else {
+			fs.Infof(c.what, "SMB login from %q", a.user)
+		}
+		status = statusSuccess
+	default:
+		return fail("unexpected NTLM message")
+	}
+
+	body = makeBody(9)
+	if sess.guest {
+		le.PutUint16(body[2:], sessionFlagIsGuest)
+	}
+	le.PutUint16(body[4:], headerSize+8)
+	le.PutUint16(body[6:], uint16(len(out)))
+	return status, append(body, out...)
+}
+
+// treeConnect handles the TREE_CONNECT command
+func (c *conn) treeConnect(r *request, rsp *response) (status uint32, body []byte) {
+	b := r.body
+	if len(b) < 8 {
+		return statusInvalidParameter, nil
+	}
+	path := decodeUTF16(slice(r.raw, 0, int(le.Uint16(b[4:])), int(le.Uint16(b[6:]))))
+	share := path[strings.LastIndex(path, `\`)+1:]
+	t := &tree{}
+	switch {
+	case strings.EqualFold(share, "IPC$"):
+		t.ipc = true
+	case strings.EqualFold(share, c.s.opt.Share):
+	default:
+		fs.Debugf(c.what, "Tree connect to unknown share %q", path)
+		return statusBadNetworkName, nil
+	}
+	sess := r.sess
+	sess.lastTreeID++
+	t.id = sess.lastTreeID
+	sess.trees[t.id] = t
+	rsp.hdr.treeID = t.id
+
+	body = makeBody(16)
+	if t.ipc {
+		body[2] = shareTypePipe
+	} else {
+		body[2] = shareTypeDisk
+		le.PutUint32(body[4:], shareFlagNoCaching)
+	}
+	le.PutUint32(body[12:], maximalAccess(sess.vfs))
+	return statusSuccess, body
+}
+
+// ioctl handles the IOCTL command
+func (c *conn) ioctl(r *request, state *compound) (status uint32, body []byte, err error) {
+	b := r.body
+	if len(b) < 56 {
+		return statusInvalidParameter, nil, nil
+	}
+	ctlCode := le.Uint32(b[4:])
+	switch ctlCode {
+	case fsctlValidateNegotiateInfo:
+		in := slice(r.raw, 0, int(le.Uint32(b[24:])), int(le.Uint32(b[28:])))
+		if len(in) < 24 {
+			return statusInvalidParameter, nil, nil
+		}
+		count := int(le.Uint16(in[22:]))
+		if len(in) < 24+2*count {
+			return statusInvalidParameter, nil, nil
+		}
+		dialects := make([]uint16, count)
+		for i := range dialects {
+			dialects[i] = le.Uint16(in[24+2*i:])
+		}
+		// If what the client sent doesn't match what we
+		// negotiated then it may be a downgrade attack so
+		// drop the connection.
+		if le.Uint32(in[0:]) != c.clientCapabilities || !bytes.Equal(in[4:20], c.clientGUID[:]) ||
+			le.Uint16(in[20:]) != c.clientSecurity || !slicesEqual(dialects, c.clientDialects) {
+			return 0, nil, errors.New("FSCTL_VALIDATE_NEGOTIATE_INFO didn't match NEGOTIATE")
+		}
+		out := make([]byte, 24)
+		le.PutUint32(out[0:], c.capabilities)
+		copy(out[4:20], c.s.guid[:])
+		le.PutUint16(out[20:], signingEnabled)
+		le.PutUint16(out[22:], c.dialect)
+		return statusSuccess, ioctlResponse(b, out), nil
+	case fsctlDfsGetReferrals, fsctlDfsGetReferralsEx:
+		return statusFsDriverRequired, nil, nil
+	}
+	return statusNotSupported, nil, nil
+}
+
+// ioctlResponse makes the response to an IOCTL request b with output out
+func ioctlResponse(b, out []byte) []byte {
+	const offset = headerSize + 48
+	body := makeBody(49)
+	le.PutUint32(body[4:], le.Uint32(b[4:]))
+	copy(body[8:24], b[8:24])
+	le.PutUint32(body[24:], offset)
+	le.PutUint32(body[32:], offset)
+	le.PutUint32(body[36:], uint32(len(out)))
+	return append(body, out...)
+}
+
+// slicesEqual returns true if a and b are the same
+func slicesEqual(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
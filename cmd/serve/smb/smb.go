@@ -0,0 +1,187 @@
+// Package smb implements an SMB2/3 server to serve an rclone VFS
+package smb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rclone/rclone/cmd"
+	"github.com/rclone/rclone/cmd/serve"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/proxy/proxyflags"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configstruct"
+	"github.com/rclone/rclone/fs/config/flags"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/systemd"
+	"github.com/rclone/rclone/vfs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rclone/rclone/vfs/vfsflags"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// OptionsInfo descripts the Options in use
+var OptionsInfo = fs.Options{{
+	Name:    "addr",
+	Default: "localhost:4450",
+	Help:    "IPaddress:Port or :Port to bind server to",
+}, {
+	Name:    "share",
+	Default: "rclone",
+	Help:    "Name of the share to serve",
+}, {
+	Name:    "user",
+	Default: "",
+	Help:    "User name for authentication",
+}, {
+	Name:    "pass",
+	Default: "",
+	Help:    "Password for authentication",
+}, {
+	Name:    "no_auth",
+	Default: false,
+	Help:    "Allow guest connections with no authentication if set",
+}}
+
+// Options contains options for the SMB Server
+type Options struct {
+	ListenAddr string `config:"addr"`    // Port to listen on
+	Share      string `config:"share"`   // name of the share
+	User       string `config:"user"`    // single username
+	Pass       string `config:"pass"`    // password for user
+	NoAuth     bool   `config:"no_auth"` // allow guest connections
+}
+
+func init() {
+	fs.RegisterGlobalOptions(fs.OptionsInfo{Name: "smb", Opt: &Opt, Options: OptionsInfo})
+}
+
+// Opt is options set by command line flags
+var Opt Options
+
+// AddFlags adds flags for the smb
+func AddFlags(flagSet *pflag.FlagSet, Opt *Options) {
+	flags.AddFlagsFromOptions(flagSet, "", OptionsInfo)
+}
+
+func init() {
+	vfsflags.AddFlags(Command.Flags())
+	proxyflags.AddFlags(Command.Flags())
+	AddFlags(Command.Flags(), &Opt)
+	serve.Command.AddCommand(Command)
+	serve.AddRc("smb", func(ctx context.Context, f fs.Fs, in rc.Params) (serve.Handle, error) {
+		// Read VFS Opts
+		var vfsOpt = vfscommon.Opt // set default opts
+		err := configstruct.SetAny(in, &vfsOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read Proxy Opts
+		var proxyOpt = proxy.Opt // set default opts
+		err = configstruct.SetAny(in, &proxyOpt)
+		if err != nil {
+			return nil, err
+		}
+		// Read opts
+		var opt = Opt // set default opts
+		err = configstruct.SetAny(in, &opt)
+		if err != nil {
+			return nil, err
+		}
+		// Create server
+		return newServer(ctx, f, &opt, &vfsOpt, &proxyOpt)
+	})
+}
+
+// Command definition for cobra
+var Command = &cobra.Command{
+	Use:   "smb remote:path",
+	Short: `Serve the remote over SMB.`,
+	Long: `Run an SMB server to serve a remote over SMB2/3. This can be used
+with the file sharing built into Windows, macOS and Linux or you can
+make a remote of type [smb](/smb) to use with it.
+
+You can use the [filter](/filtering) flags (e.g. ` + "`--include`, `--exclude`" + `)
+to control what is served.
+
+The remote is served as a single share called ` + "`rclone`" + ` which can be
+changed with ` + "`--share`" + `, so on Windows you would connect to
+` + "`\\\\hostname\\rclone`" + `.
+
+The server will log errors.  Use ` + "`-v`" + ` to see access logs.
+
+` + "`--bwlimit`" + ` will be respected for file transfers.
+Use ` + "`--stats`" + ` to control the stats printing.
+
+### Server options
+
+By default the server binds to localhost:4450 - if you want it to be
+reachable externally then supply ` + "`--addr :4450`" + ` for example.
+
+The default port isn't the standard SMB port 445 as on most systems
+binding to ports below 1024 needs extra privileges. Clients such as
+the rclone smb backend (with ` + "`--smb-port 4450`" + `) or the Linux kernel
+client (with ` + "`-o port=4450`" + `) can use any port, but Windows will
+only connect to SMB servers on port 445. To serve Windows clients use
+` + "`--addr :445`" + ` and run rclone as root or, on Linux, give it the
+` + "`CAP_NET_BIND_SERVICE`" + ` capability with
+` + "`setcap cap_net_bind_service=+ep /path/to/rclone`" + `.
+
+This also supports being run with socket activation, in which case it will
+listen on the first passed FD.
+
+The server supports the SMB 2.0.2, 2.1, 3.0, 3.0.2 and 3.1.1 dialects
+with message signing. Encryption, oplocks, leases, change notification
+and byte range locks are not supported.
+
+### Authentication
+
+You must provide some means of authentication, either with
+` + "`--user`/`--pass`" + `, an ` + "`--auth-proxy`" + `, or set the
+` + "`--no-auth`" + ` flag to allow guest connections. Users are
+authenticated with NTLMv2 - Kerberos is not supported.
+
+With ` + "`--no-auth`" + ` anonymous logins and logins as users other than
+the ` + "`--user`" + ` are given guest access. Logins as the ` + "`--user`" + `
+with the wrong password are still refused. Guest sessions can't be signed so recent versions of
+Windows will refuse to use them unless insecure guest logons are
+enabled.
+
+If you use ` + "`--auth-proxy`" + ` then the proxy is called with only the
+` + "`user`" + ` as NTLM never sends the password to the server. The
+proxy must return the password for the user in the ` + "`_password`" + `
+key of its output which is used to check the login.
+
+### VFS cache mode
+
+SMB clients open files for reading and writing at the same time and
+write at random offsets so the server will work best with
+` + "`--vfs-cache-mode writes`" + ` or ` + "`--vfs-cache-mode full`" + `.
+With ` + "`--vfs-cache-mode off`" + ` files can only be written
+sequentially when they are created or overwritten.
+
+` + strings.TrimSpace(vfs.Help()+proxy.Help),
+	Annotations: map[string]string{
+		"versionIntroduced": "v1.72",
+		"groups":            "Filter",
+	},
+	Run: func(command *cobra.Command, args []string) {
+		var f fs.Fs
+		if proxy.Opt.AuthProxy == "" {
+			cmd.CheckArgs(1, 1, command, args)
+			f = cmd.NewFsSrc(args)
+		} else {
+			cmd.CheckArgs(0, 0, command, args)
+		}
+		cmd.Run(false, true, command, func() error {
+			s, err := newServer(context.Background(), f, &Opt, &vfscommon.Opt, &proxy.Opt)
+			if err != nil {
+				fs.Fatal(nil, fmt.Sprint(err))
+			}
+			defer systemd.Notify()()
+			return s.Serve()
+		})
+	},
+}
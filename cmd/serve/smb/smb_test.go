@@ -0,0 +1,336 @@
+// Serve smb tests set up a server and run the integration tests
+// for the smb remote against it.
+//
+// We skip tests on platforms with troublesome character mappings
+
+//go:build !windows && !darwin && !plan9
+
+package smb
+
+import (
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudsoda/go-smb2"
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/cmd/serve/proxy"
+	"github.com/rclone/rclone/cmd/serve/servetest"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBindAddress = "localhost:0"
+	testUser        = "testuser"
+	testPass        = "testpass"
+)
+
+// TestSmb runs the smb server then runs the unit tests for the
+// smb remote against it.
+func TestSmb(t *testing.T) {
+	// Configure and start the server
+	start := func(f fs.Fs) (configmap.Simple, func()) {
+		opt := Opt
+		opt.ListenAddr = testBindAddress
+		opt.User = testUser
+		opt.Pass = testPass
+		vfsOpt := vfscommon.Opt
+		vfsOpt.CacheMode = vfscommon.CacheModeWrites
+
+		w, err := newServer(context.Background(), f, &opt, &vfsOpt, &proxy.Opt)
+		require.NoError(t, err)
+		go func() {
+			require.NoError(t, w.Serve())
+		}()
+
+		// Read the host and port we started on
+		addr := w.Addr().String()
+		colon := strings.LastIndex(addr, ":")
+
+		// Config for the backend we'll use to connect to the server
+		config := configmap.Simple{
+			"type":             "smb",
+			"user":             testUser,
+			"pass":             obscure.MustObscure(testPass),
+			"host":             addr[:colon],
+			"port":             addr[colon+1:],
+			"case_insensitive": "false",
+			"_root":            opt.Share,
+		}
+
+		// return a stop function
+		return config, func() {
+			assert.NoError(t, w.Shutdown())
+		}
+	}
+
+	servetest.Run(t, "smb", start)
+}
+
+func TestRc(t *testing.T) {
+	servetest.TestRc(t, rc.Params{
+		"type":           "smb",
+		"user":           "test",
+		"pass":           "test",
+		"vfs_cache_mode": "off",
+	})
+}
+
+// startServer starts a server on a temporary directory returning
+// its address and the directory
+func startServer(t *testing.T, opt Options, cacheMode vfscommon.CacheMode) (addr, dir string) {
+	fstest.Initialise()
+	dir = t.TempDir()
+	f, err := fs.NewFs(context.Background(), dir)
+	require.NoError(t, err)
+	opt.ListenAddr = testBindAddress
+	vfsOpt := vfscommon.Opt
+	vfsOpt.CacheMode = cacheMode
+	s, err := newServer(context.Background(), f, &opt, &vfsOpt, &proxy.Opt)
+	require.NoError(t, err)
+	go func() {
+		assert.NoError(t, s.Serve())
+	}()
+	t.Cleanup(func() {
+		assert.NoError(t, s.Shutdown())
+	})
+	return s.Addr().String(), dir
+}
+
+// mount dials the server and mounts the share
+func mount(t *testing.T, addr string, dialect uint16, user, pass string) (*smb2.Share, error) {
+	d := &smb2.Dialer{
+		Negotiator: smb2.Negotiator{SpecifiedDialect: dialect},
+		Initiator:  &smb2.NTLMInitiator{User: user, Password: pass, Domain: "WORKGROUP"},
+	}
+	s, err := d.Dial(context.Background(), addr)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		_ = s.Logoff()
+	})
+	share, err := s.Mount("rclone")
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() {
+		_ = share.Umount()
+	})
+	return share, nil
+}
+
+// Test the file operations with each dialect
+func TestDialects(t *testing.T) {
+	for _, dialect := range serverDialects {
+		t.Run(fmt.Sprintf("%x", dialect), func(t *testing.T) {
+			addr, _ := startServer(t, Options{Share: "rclone", User: testUser, Pass: testPass}, vfscommon.CacheModeWrites)
+			share, err := mount(t, addr, dialect, strings.ToUpper(testUser), testPass)
+			require.NoError(t, err)
+
+			// Create and read back a file
+			data := []byte(strings.Repeat("hello world ", 100000))
+			require.NoError(t, share.Mkdir("dir", 0777))
+			require.NoError(t, share.WriteFile(`dir\file.txt`, data, 0666))
+			got, err := share.ReadFile(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+
+			// Ranged read and write
+			f, err := share.OpenFile(`dir\file.txt`, os.O_RDWR, 0666)
+			require.NoError(t, err)
+			_, err = f.WriteAt([]byte("HELLO"), 12)
+			require.NoError(t, err)
+			buf := make([]byte, 11)
+			_, err = f.ReadAt(buf, 12)
+			require.NoError(t, err)
+			assert.Equal(t, "HELLO world", string(buf))
+			require.NoError(t, f.Close())
+
+			// Stat and set the modification time
+			mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+			require.NoError(t, share.Chtimes(`dir\file.txt`, mtime, mtime))
+			fi, err := share.Stat(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(data)), fi.Size())
+			assert.True(t, mtime.Equal(fi.ModTime()), fi.ModTime())
+			assert.False(t, fi.IsDir())
+
+			// Truncate
+			require.NoError(t, share.Truncate(`dir\file.txt`, 5))
+			got, err = share.ReadFile(`dir\file.txt`)
+			require.NoError(t, err)
+			assert.Equal(t, "hello", string(got))
+
+			// List, rename and delete
+			require.NoError(t, share.Rename(`dir\file.txt`, `dir\file2.txt`))
+			fis, err := share.ReadDir("dir")
+			require.NoError(t, err)
+			require.Len(t, fis, 1)
+			assert.Equal(t, "file2.txt", fis[0].Name())
+			_, err = share.Stat(`dir\file.txt`)
+			assert.True(t, os.IsNotExist(err), err)
+			assert.Error(t, share.Remove("dir"))
+			require.NoError(t, share.Remove(`dir\file2.txt`))
+			require.NoError(t, share.Remove("dir"))
+			fis, err = share.ReadDir("")
+			require.NoError(t, err)
+			assert.Len(t, fis, 0)
+
+			// Filesystem info
+			_, err = share.Statfs("")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	addr, dir := startServer(t, Options{Share: "rclone", User: testUser, Pass: testPass}, vfscommon.CacheModeOff)
+
+	_, err := mount(t, addr, 0, testUser, "potato")
+	assert.Error(t, err)
+	_, err = mount(t, addr, 0, "potato", testPass)
+	assert.Error(t, err)
+	_, err = mount(t, addr, 0, "", "")
+	assert.Error(t, err)
+	share, err := mount(t, addr, 0, testUser, testPass)
+	require.NoError(t, err)
+
+	// Unknown shares are rejected
+	d := &smb2.Dialer{Initiator: &smb2.NTLMInitiator{User: testUser, Password: testPass}}
+	s, err := d.Dial(context.Background(), addr)
+	require.NoError(t, err)
+	_, err = s.Mount("potato")
+	assert.Error(t, err)
+	require.NoError(t, s.Logoff())
+
+	// Writing sequentially works without the cache
+	require.NoError(t, share.WriteFile("file.txt", []byte("potato"), 0666))
+	got, err := share.ReadFile("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "potato", string(got))
+	got, err = os.ReadFile(dir + "/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "potato", string(got))
+
+	// Guest logins are only allowed with --no-auth
+	addr, _ = startServer(t, Options{Share: "rclone", NoAuth: true}, vfscommon.CacheModeOff)
+	share, err = mount(t, addr, 0, "guest", "")
+	require.NoError(t, err)
+	_, err = share.ReadDir("")
+	require.NoError(t, err)
+
+	// With --no-auth the user still needs the right password
+	addr, _ = startServer(t, Options{Share: "rclone", User: testUser, Pass: testPass, NoAuth: true}, vfscommon.CacheModeOff)
+	_, err = mount(t, addr, 0, testUser, "potato")
+	assert.Error(t, err)
+	_, err = mount(t, addr, 0, testUser, testPass)
+	require.NoError(t, err)
+	share, err = mount(t, addr, 0, "potato", "potato")
+	require.NoError(t, err)
+	_, err = share.ReadDir("")
+	require.NoError(t, err)
+
+	// No authentication is an error
+	_, err = newServer(context.Background(), nil, &Options{Share: "rclone"}, &vfscommon.Opt, &proxy.Opt)
+	assert.ErrorContains(t, err, "no authentication")
+}
+
+func TestMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*", "potato", true},
+		{"*.*", "potato", true},
+		{"", "potato", true},
+		{"potato", "POTATO", true},
+		{"potato", "potatoes", false},
+		{"pot*", "potato.txt", true},
+		{"*.txt", "potato.txt", true},
+		{"*.txt", "potato.jpg", false},
+		{"p?tato", "potato", true},
+		{"p?tato", "ptato", false},
+		{"<.txt", "potato.txt", true},
+		{"potato>>>", "potato", true},
+		{`potato"txt`, "potato.txt", true},
+		{`potato"`, "potato", true},
+	} {
+		assert.Equal(t, test.want, match(test.pattern, test.name), test)
+	}
+}
+
+func TestSmbPath(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{``, ``, true},
+		{`\`, ``, true},
+		{`dir\file.txt`, `dir/file.txt`, true},
+		{`\dir\sub\`, `dir/sub`, true},
+		{`...`, `...`, true},
+		{`dir\..file`, `dir/..file`, true},
+		{`.`, ``, false},
+		{`..`, ``, false},
+		{`dir\..\..\secret`, ``, false},
+		{`dir\.\file`, ``, false},
+		{`dir/../file`, ``, false},
+	} {
+		got, ok := smbPath(test.in)
+		assert.Equal(t, test.ok, ok, test.in)
+		assert.Equal(t, test.want, got, test.in)
+	}
+}
+
+// Test vectors from RFC 4493
+func TestCmac(t *testing.T) {
+	key, _ := hex.DecodeString("2b7e151628aed2a6abf7158809cf4f3c")
+	msg, _ := hex.DecodeString("6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	for _, test := range []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		assert.Equal(t, test.want, hex.EncodeToString(cmac(block, msg[:test.n])), test.n)
+	}
+}
+
+func TestSecurityDescriptor(t *testing.T) {
+	sd := securityDescriptor(ownerSecurityInformation|groupSecurityInformation|daclSecurityInformation, true)
+	assert.Equal(t, byte(1), sd[0])
+	assert.Equal(t, uint16(0x8004), le.Uint16(sd[2:]))
+	assert.Equal(t, sidAdmins, sd[le.Uint32(sd[4:]):][:len(sidAdmins)])
+	assert.Equal(t, sidAdmins, sd[le.Uint32(sd[8:]):][:len(sidAdmins)])
+	acl := sd[le.Uint32(sd[16:]):]
+	assert.Equal(t, len(acl), int(le.Uint16(acl[2:])))
+	assert.Equal(t, uint16(1), le.Uint16(acl[4:]))
+	assert.Equal(t, uint32(fileAllAccess), le.Uint32(acl[12:]))
+	assert.Equal(t, sidEveryone, acl[16:])
+
+	// Only the parts asked for are returned
+	sd = securityDescriptor(daclSecurityInformation, false)
+	assert.Equal(t, uint32(0), le.Uint32(sd[4:]))
+	assert.Equal(t, uint32(0), le.Uint32(sd[8:]))
+	assert.Equal(t, uint32(20), le.Uint32(sd[16:]))
+}
@@ -0,0 +1,605 @@
+package webdav
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/cache"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/kv"
+	"golang.org/x/net/webdav"
+)
+
+type lockStoreChoices struct{}
+
+func (lockStoreChoices) Choices() []string {
+	return []string{
+		lockStoreMemory: "memory",
+		lockStoreDisk:   "disk",
+		lockStoreRemote: "remote",
+	}
+}
+
+// lockStoreType chooses where the WebDAV locks are kept
+type lockStoreType = fs.Enum[lockStoreChoices]
+
+// lockStoreType options
+const (
+	lockStoreMemory lockStoreType = iota // in memory, lost on restart
+	lockStoreDisk                        // in a database in the cache directory
+	lockStoreRemote                      // as files on a remote
+)
+
+// Type of the value
+func (lockStoreChoices) Type() string {
+	return "LockStore"
+}
+
+// maxLockDuration is the longest a stored lock lasts without being
+// refreshed. The handler takes locks with an infinite timeout while
+// writing, so without this those of a server which stopped while
+// writing would never expire.
+const maxLockDuration = time.Hour
+
+// lockFacility returns the name of the key-value database holding the
+// locks for f. This includes the root of f as the locks are kept by
+// paths relative to it.
+func lockFacility(f fs.Fs) string {
+	if f == nil || f.Root() == "" {
+		return "webdav_locks"
+	}
+	sum := sha256.Sum256([]byte(f.Root()))
+	return "webdav_locks_" + hex.EncodeToString(sum[:8])
+}
+
+// newLockSystem makes the webdav.LockSystem chosen by opt for
+// serving f which may be nil.
+//
+// It returns a function to call when the server is shut down.
+func newLockSystem(ctx context.Context, f fs.Fs, opt *Options) (ls webdav.LockSystem, stop func() error, err error) {
+	stop = func() error { return nil }
+	switch opt.LockStore {
+	case lockStoreMemory:
+		if opt.LockStoreRemote != "" {
+			return nil, nil, errors.New("--lock-store-remote needs --lock-store remote")
+		}
+		return webdav.NewMemLS(), stop, nil
+	case lockStoreDisk:
+		db, err := kv.Start(ctx, lockFacility(f), f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open lock database: %w", err)
+		}
+		fs.Debugf(f, "Storing WebDAV locks in %q", db.Path())
+		stop = func() error { return db.Stop(false) }
+		return newStoreLS(ctx, &kvLockStore{db: db}), stop, nil
+	case lockStoreRemote:
+		if opt.LockStoreRemote == "" {
+			return nil, nil, errors.New("--lock-store remote needs --lock-store-remote")
+		}
+		lockFs, err := cache.Get(ctx, opt.LockStoreRemote)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open --lock-store-remote: %w", err)
+		}
+		return newStoreLS(ctx, newRemoteLockStore(lockFs)), stop, nil
+	}
+	return nil, nil, fmt.Errorf("unknown lock store %q", opt.LockStore)
+}
+
+// lockRecord is a lock as kept in a lockStore
+type lockRecord struct {
+	Token     string        `json:"token"`
+	Root      string        `json:"root"`
+	ZeroDepth bool          `json:"zeroDepth"`
+	OwnerXML  string        `json:"ownerXML"`
+	Duration  time.Duration `json:"duration"` // negative means infinite
+	Expires   time.Time     `json:"expires"`
+	Created   time.Time     `json:"created"`
+}
+
+// setDuration sets the duration of the lock and when it expires,
+// which is at most maxLockDuration from now
+func (r *lockRecord) setDuration(now time.Time, duration time.Duration) {
+	r.Duration = duration
+	if duration < 0 || duration > maxLockDuration {
+		duration = maxLockDuration
+	}
+	r.Expires = now.Add(duration)
+}
+
+// expired returns true if the lock has expired at now
+func (r *lockRecord) expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+// details returns the webdav.LockDetails of the lock
+func (r *lockRecord) details() webdav.LockDetails {
+	return webdav.LockDetails{
+		Root:      r.Root,
+		Duration:  r.Duration,
+		OwnerXML:  r.OwnerXML,
+		ZeroDepth: r.ZeroDepth,
+	}
+}
+
+// isDescendant returns true if name is below parent
+func isDescendant(name, parent string) bool {
+	if parent == "/" {
+		return name != "/"
+	}
+	return strings.HasPrefix(name, parent+"/")
+}
+
+// covers returns true if the lock applies to the resource name
+func (r *lockRecord) covers(name string) bool {
+	return name == r.Root || (!r.ZeroDepth && isDescendant(name, r.Root))
+}
+
+// conflicts returns true if the lock stops a lock on root being created
+func (r *lockRecord) conflicts(root string, zeroDepth bool) bool {
+	return r.covers(root) || (!zeroDepth && isDescendant(r.Root, root))
+}
+
+// before returns true if r was created before other
+func (r *lockRecord) before(other *lockRecord) bool {
+	if r.Created.Equal(other.Created) {
+		return r.Token < other.Token
+	}
+	return r.Created.Before(other.Created)
+}
+
+// lockStore keeps the lock records for a storeLS
+type lockStore interface {
+	// list returns all the lock records
+	list(ctx context.Context) ([]*lockRecord, error)
+	// put adds the record replacing any with the same token
+	put(ctx context.Context, record *lockRecord) error
+	// remove deletes the record with token
+	remove(ctx context.Context, token string) error
+}
+
+// storeLS is a webdav.LockSystem which keeps its locks in a lockStore
+// so they can outlive the server and be shared between servers.
+//
+// A lock is created by checking there are no conflicting locks,
+// storing it, then checking again. If a conflicting lock which was
+// created first is found the second time the lock is removed and
+// creating it fails.
+type storeLS struct {
+	ctx   context.Context
+	store lockStore
+
+	mu   sync.Mutex      // protects the following and serializes changes
+	held map[string]bool // tokens of locks held by Confirm
+}
+
+// check interface
+var _ webdav.LockSystem = (*storeLS)(nil)
+
+// newStoreLS makes a new storeLS using store
+func newStoreLS(ctx context.Context, store lockStore) *storeLS {
+	return &storeLS{
+		ctx:   ctx,
+		store: store,
+		held:  make(map[string]bool),
+	}
+}
+
+// slashClean is equivalent to but slightly more efficient than
+// path.Clean("/" + name).
+func slashClean(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+// locks reads the unexpired locks, returning the expired ones
+// separately.
+//
+// call with mu held
+func (ls *storeLS) locks(now time.Time) (locks map[string]*lockRecord, expired []*lockRecord, err error) {
+	records, err := ls.store.list(ls.ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read WebDAV locks: %w", err)
+	}
+	locks = make(map[string]*lockRecord, len(records))
+	for _, record := range records {
+		if record.expired(now) && !ls.held[record.Token] {
+			expired = append(expired, record)
+			continue
+		}
+		locks[record.Token] = record
+	}
+	return locks, expired, nil
+}
+
+// lookup returns the lock which matches one of conditions and
+// covers name and isn't held or nil if there isn't one.
+//
+// call with mu held
+func (ls *storeLS) lookup(locks map[string]*lockRecord, name string, conditions ...webdav.Condition) *lockRecord {
+	for _, c := range conditions {
+		record := locks[c.Token]
+		if record == nil || ls.held[c.Token] {
+			continue
+		}
+		if record.covers(name) {
+			return record
+		}
+	}
+	return nil
+}
+
+// Confirm confirms that the caller can claim all of the locks
+// specified by the given conditions.
+func (ls *storeLS) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks, _, err := ls.locks(now)
+	if err != nil {
+		return nil, err
+	}
+	var held []string
+	for _, name := range []string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		record := ls.lookup(locks, slashClean(name), conditions...)
+		if record == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		if len(held) == 0 || held[0] != record.Token {
+			held = append(held, record.Token)
+		}
+	}
+	for _, token := range held {
+		ls.held[token] = true
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		for _, token := range held {
+			delete(ls.held, token)
+		}
+	}, nil
+}
+
+// Create creates a lock with the given details.
+func (ls *storeLS) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	record := &lockRecord{
+		Token:     "urn:uuid:" + uuid.New().String(),
+		Root:      slashClean(details.Root),
+		ZeroDepth: details.ZeroDepth,
+		OwnerXML:  details.OwnerXML,
+		Created:   time.Now(),
+	}
+	record.setDuration(now, details.Duration)
+	locks, expired, err := ls.locks(now)
+	if err != nil {
+		return "", err
+	}
+	for _, old := range expired {
+		err = ls.store.remove(ls.ctx, old.Token)
+		if err != nil {
+			fs.Debugf(old.Root, "Failed to remove expired WebDAV lock: %v", err)
+		}
+	}
+	if conflicts(locks, record, false) {
+		return "", webdav.ErrLocked
+	}
+	err = ls.store.put(ls.ctx, record)
+	if err != nil {
+		return "", fmt.Errorf("failed to store WebDAV lock: %w", err)
+	}
+	// Check again in case another server created a lock at the same time
+	locks, _, err = ls.locks(now)
+	if err == nil && !conflicts(locks, record, true) {
+		return record.Token, nil
+	}
+	removeErr := ls.store.remove(ls.ctx, record.Token)
+	if removeErr != nil {
+		fs.Errorf(record.Root, "Failed to remove WebDAV lock: %v", removeErr)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", webdav.ErrLocked
+}
+
+// conflicts returns true if one of locks stops record being created.
+// If onlyBefore is set only locks created before record are
+// considered.
+func conflicts(locks map[string]*lockRecord, record *lockRecord, onlyBefore bool) bool {
+	for token, other := range locks {
+		if token == record.Token || (onlyBefore && !other.before(record)) {
+			continue
+		}
+		if other.conflicts(record.Root, record.ZeroDepth) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresh refreshes the lock with the given token.
+func (ls *storeLS) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks, _, err := ls.locks(now)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+	record := locks[token]
+	if record == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if ls.held[token] {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+	record.setDuration(now, duration)
+	err = ls.store.put(ls.ctx, record)
+	if err != nil {
+		return webdav.LockDetails{}, fmt.Errorf("failed to store WebDAV lock: %w", err)
+	}
+	return record.details(), nil
+}
+
+// Unlock unlocks the lock with the given token.
+func (ls *storeLS) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	locks, _, err := ls.locks(now)
+	if err != nil {
+		return err
+	}
+	if locks[token] == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if ls.held[token] {
+		return webdav.ErrLocked
+	}
+	err = ls.store.remove(ls.ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to remove WebDAV lock: %w", err)
+	}
+	return nil
+}
+
+// kvLockStore keeps the locks in a key-value database
+type kvLockStore struct {
+	db *kv.DB
+}
+
+// ignoreEmpty ignores the error returned when reading an empty database
+func ignoreEmpty(err error) error {
+	if errors.Is(err, kv.ErrEmpty) {
+		return nil
+	}
+	return err
+}
+
+func (s *kvLockStore) list(ctx context.Context) ([]*lockRecord, error) {
+	op := &kvLockList{}
+	err := ignoreEmpty(s.db.Do(false, op))
+	return op.records, err
+}
+
+func (s *kvLockStore) put(ctx context.Context, record *lockRecord) error {
+	return s.db.Do(true, &kvLockPut{record: record})
+}
+
+func (s *kvLockStore) remove(ctx context.Context, token string) error {
+	return s.db.Do(true, &kvLockDelete{token: token})
+}
+
+// kvLockList reads all the lock records
+type kvLockList struct {
+	records []*lockRecord
+}
+
+func (op *kvLockList) Do(ctx context.Context, b kv.Bucket) error {
+	return b.ForEach(func(key, data []byte) error {
+		record := new(lockRecord)
+		if err := json.Unmarshal(data, record); err != nil {
+			fs.Debugf(nil, "Ignoring invalid WebDAV lock record: %v", err)
+			return nil
+		}
+		op.records = append(op.records, record)
+		return nil
+	})
+}
+
+// kvLockPut stores a lock record
+type kvLockPut struct {
+	record *lockRecord
+}
+
+func (op *kvLockPut) Do(ctx context.Context, b kv.Bucket) error {
+	data, err := json.Marshal(op.record)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(op.record.Token), data)
+}
+
+// kvLockDelete removes a lock record
+type kvLockDelete struct {
+	token string
+}
+
+func (op *kvLockDelete) Do(ctx context.Context, b kv.Bucket) error {
+	return b.Delete([]byte(op.token))
+}
+
+// remoteLockStore keeps the locks as files on a remote, one per lock,
+// so they can be shared by servers on different hosts.
+//
+// The records read are cached so listing the locks only needs to read
+// the files which have changed since they were last read.
+type remoteLockStore struct {
+	f fs.Fs
+
+	mu    sync.Mutex                     // protects the following
+	cache map[string]remoteLockCacheItem // records by file name
+}
+
+// remoteLockCacheItem is a lock record read from a file
+type remoteLockCacheItem struct {
+	modTime time.Time
+	size    int64
+	record  lockRecord
+}
+
+// newRemoteLockStore makes a remoteLockStore keeping the locks in f
+func newRemoteLockStore(f fs.Fs) *remoteLockStore {
+	return &remoteLockStore{
+		f:     f,
+		cache: make(map[string]remoteLockCacheItem),
+	}
+}
+
+// cached returns the cached record for o if it hasn't changed
+func (s *remoteLockStore) cached(ctx context.Context, o fs.Object) *lockRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, found := s.cache[o.Remote()]
+	if !found || item.size != o.Size() || !item.modTime.Equal(o.ModTime(ctx)) {
+		return nil
+	}
+	record := item.record
+	return &record
+}
+
+// setCached caches record as the contents of o
+func (s *remoteLockStore) setCached(ctx context.Context, o fs.Object, record *lockRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[o.Remote()] = remoteLockCacheItem{
+		modTime: o.ModTime(ctx),
+		size:    o.Size(),
+		record:  *record,
+	}
+}
+
+// recordName returns the name of the file for the lock with token
+func (s *remoteLockStore) recordName(token string) string {
+	return strings.TrimPrefix(token, "urn:uuid:") + ".json"
+}
+
+func (s *remoteLockStore) list(ctx context.Context) (records []*lockRecord, err error) {
+	entries, err := s.f.List(ctx, "")
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		entries, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok || !strings.HasSuffix(o.Remote(), ".json") {
+			continue
+		}
+		seen[o.Remote()] = struct{}{}
+		record := s.cached(ctx, o)
+		if record == nil {
+			record, err = s.read(ctx, o)
+			if err != nil {
+				// the record may have just been removed
+				fs.Debugf(o, "Ignoring WebDAV lock record: %v", err)
+				continue
+			}
+			s.setCached(ctx, o, record)
+		}
+		records = append(records, record)
+	}
+	// Forget the records which have gone
+	s.mu.Lock()
+	for remote := range s.cache {
+		if _, found := seen[remote]; !found {
+			delete(s.cache, remote)
+		}
+	}
+	s.mu.Unlock()
+	return records, nil
+}
+
+// read the lock record in o
+func (s *remoteLockStore) read(ctx context.Context, o fs.Object) (*lockRecord, error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(in)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := new(lockRecord)
+	err = json.Unmarshal(data, record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *remoteLockStore) put(ctx context.Context, record *lockRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	remote := s.recordName(record.Token)
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, s.f)
+	s.mu.Lock()
+	_, exists := s.cache[remote]
+	s.mu.Unlock()
+	var o fs.Object
+	if exists {
+		o, err = s.f.NewObject(ctx, remote)
+		if err == nil {
+			err = o.Update(ctx, bytes.NewReader(data), info)
+		} else if errors.Is(err, fs.ErrorObjectNotFound) {
+			exists = false
+		}
+	}
+	if !exists {
+		o, err = s.f.Put(ctx, bytes.NewReader(data), info)
+	}
+	if err != nil {
+		return err
+	}
+	s.setCached(ctx, o, record)
+	return nil
+}
+
+func (s *remoteLockStore) remove(ctx context.Context, token string) error {
+	remote := s.recordName(token)
+	s.mu.Lock()
+	delete(s.cache, remote)
+	s.mu.Unlock()
+	o, err := s.f.NewObject(ctx, remote)
+	if err == nil {
+		err = o.Remove(ctx)
+	}
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package webdav
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	_ "github.com/rclone/rclone/backend/local"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/lib/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// testLockSystem checks ls behaves like webdav.NewMemLS
+func testLockSystem(t *testing.T, ls webdav.LockSystem) {
+	now := time.Now()
+	details := func(root string, zeroDepth bool) webdav.LockDetails {
+		return webdav.LockDetails{Root: root, Duration: time.Minute, ZeroDepth: zeroDepth}
+	}
+
+	// Conflicting locks can't be created
+	token, err := ls.Create(now, details("/dir/file", true))
+	require.NoError(t, err)
+	_, err = ls.Create(now, details("/dir/file", true))
+	assert.Equal(t, webdav.ErrLocked, err)
+	_, err = ls.Create(now, details("/dir", false))
+	assert.Equal(t, webdav.ErrLocked, err)
+	dirToken, err := ls.Create(now, details("/dir", true))
+	require.NoError(t, err)
+	require.NoError(t, ls.Unlock(now, dirToken))
+
+	// Locks are confirmed only with the right token
+	_, err = ls.Confirm(now, "/dir/file", "")
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: "potato"})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	release, err := ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+
+	// A held lock can't be confirmed again, refreshed or unlocked
+	_, err = ls.Confirm(now, "/dir/file", "", webdav.Condition{Token: token})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	_, err = ls.Refresh(now, token, time.Hour)
+	assert.Equal(t, webdav.ErrLocked, err)
+	assert.Equal(t, webdav.ErrLocked, ls.Unlock(now, token))
+	release()
+
+	// Refreshing changes the expiry time
+	got, err := ls.Refresh(now, token, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, "/dir/file", got.Root)
+	assert.Equal(t, time.Hour, got.Duration)
+	_, err = ls.Create(now.Add(30*time.Minute), details("/dir/file", true))
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	// Infinite depth locks cover the resources below them
+	require.NoError(t, ls.Unlock(now, token))
+	dirToken, err = ls.Create(now, details("/dir", false))
+	require.NoError(t, err)
+	release, err = ls.Confirm(now, "/dir/file", "/dir/other", webdav.Condition{Token: dirToken})
+	require.NoError(t, err)
+	release()
+	_, err = ls.Create(now, details("/dir/file", true))
+	assert.Equal(t, webdav.ErrLocked, err)
+
+	// Expired locks are ignored
+	later := now.Add(2 * time.Minute)
+	_, err = ls.Confirm(later, "/dir/file", "", webdav.Condition{Token: dirToken})
+	assert.Equal(t, webdav.ErrConfirmationFailed, err)
+	assert.Equal(t, webdav.ErrNoSuchLock, ls.Unlock(later, dirToken))
+	token, err = ls.Create(later, details("/dir/file", true))
+	require.NoError(t, err)
+	require.NoError(t, ls.Unlock(later, token))
+}
+
+func TestMemLockSystem(t *testing.T) {
+	testLockSystem(t, webdav.NewMemLS())
+}
+
+func TestDiskLockSystem(t *testing.T) {
+	if !kv.Supported() {
+		t.Skip("kv not supported on this OS")
+	}
+	ctx := context.Background()
+	f, err := fs.NewFs(ctx, t.TempDir())
+	require.NoError(t, err)
+	opt := Opt
+	opt.LockStore = lockStoreDisk
+	ls, stop, err := newLockSystem(ctx, f, &opt)
+	require.NoError(t, err)
+	db := kv.Get(lockFacility(f), f)
+	require.NotNil(t, db)
+	defer func() {
+		require.NoError(t, stop())
+		require.NoError(t, db.Stop(true))
+	}()
+	testLockSystem(t, ls)
+
+	// The locks are kept in the database not in memory
+	token, err := ls.Create(time.Now(), webdav.LockDetails{Root: "/file", Duration: time.Minute})
+	require.NoError(t, err)
+	ls = newStoreLS(ctx, &kvLockStore{db: db})
+	_, err = ls.Refresh(time.Now(), token, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, ls.Unlock(time.Now(), token))
+}
+
+func TestLockFacility(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	f1, err := fs.NewFs(ctx, dir+"/one")
+	require.NoError(t, err)
+	f2, err := fs.NewFs(ctx, dir+"/two")
+	require.NoError(t, err)
+	assert.NotEqual(t, lockFacility(f1), lockFacility(f2))
+	assert.Equal(t, "webdav_locks", lockFacility(nil))
+}
+
+func TestRemoteLockSystem(t *testing.T) {
+	ctx := context.Background()
+	opt := Opt
+	opt.LockStore = lockStoreRemote
+	opt.LockStoreRemote = t.TempDir()
+	ls, _, err := newLockSystem(ctx, nil, &opt)
+	require.NoError(t, err)
+	testLockSystem(t, ls)
+
+	// A second server sees the locks of the first
+	ls2, _, err := newLockSystem(ctx, nil, &opt)
+	require.NoError(t, err)
+	now := time.Now()
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	require.NoError(t, err)
+	_, err = ls2.Create(now, webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	assert.Equal(t, webdav.ErrLocked, err)
+	release, err := ls2.Confirm(now, "/file", "", webdav.Condition{Token: token})
+	require.NoError(t, err)
+	release()
+	require.NoError(t, ls2.Unlock(now, token))
+	_, err = ls.Refresh(now, token, time.Minute)
+	assert.Equal(t, webdav.ErrNoSuchLock, err)
+
+	// Changes made by the other server are seen
+	token, err = ls.Create(now, webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	require.NoError(t, err)
+	_, err = ls2.Refresh(now, token, time.Hour)
+	require.NoError(t, err)
+	_, err = ls.Create(now.Add(30*time.Minute), webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	assert.Equal(t, webdav.ErrLocked, err)
+	require.NoError(t, ls.Unlock(now, token))
+
+	// Locks with an infinite timeout expire eventually
+	_, err = ls.Create(now, webdav.LockDetails{Root: "/file", Duration: -1, ZeroDepth: true})
+	require.NoError(t, err)
+	_, err = ls2.Create(now.Add(maxLockDuration-time.Second), webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	assert.Equal(t, webdav.ErrLocked, err)
+	token, err = ls2.Create(now.Add(maxLockDuration), webdav.LockDetails{Root: "/file", Duration: time.Minute, ZeroDepth: true})
+	require.NoError(t, err)
+	require.NoError(t, ls2.Unlock(now.Add(maxLockDuration), token))
+
+	// The lock store must be configured correctly
+	opt.LockStoreRemote = ""
+	_, _, err = newLockSystem(ctx, nil, &opt)
+	assert.ErrorContains(t, err, "needs --lock-store-remote")
+}
@@ -45,6 +45,14 @@ var OptionsInfo = fs.Options{{
 	Name:    "disable_dir_list",
 	Default: false,
 	Help:    "Disable HTML directory list on GET request for a directory",
+}, {
+	Name:    "lock_store",
+	Default: lockStoreMemory,
+	Help:    "Where to keep WebDAV locks memory|disk|remote",
+}, {
+	Name:    "lock_store_remote",
+	Default: "",
+	Help:    "Remote path to keep WebDAV locks in with --lock-store remote",
 }}.
 	Add(libhttp.ConfigInfo).
 	Add(libhttp.AuthConfigInfo).
@@ -52,11 +60,13 @@ var OptionsInfo = fs.Options{{
 
 // Options required for http server
 type Options struct {
-	Auth           libhttp.AuthConfig
-	HTTP           libhttp.Config
-	Template       libhttp.TemplateConfig
-	EtagHash       string `config:"etag_hash"`
-	DisableDirList bool   `config:"disable_dir_list"`
+	Auth            libhttp.AuthConfig
+	HTTP            libhttp.Config
+	Template        libhttp.TemplateConfig
+	EtagHash        string        `config:"etag_hash"`
+	DisableDirList  bool          `config:"disable_dir_list"`
+	LockStore       lockStoreType `config:"lock_store"`
+	LockStoreRemote string        `config:"lock_store_remote"`
 }
 
 // Opt is options set by command line flags
@@ -117,6 +127,30 @@ supported hash on the backend or you can use a named hash such as
 "MD5" or "SHA-1". Use the [hashsum](/commands/rclone_hashsum/) command
 to see the full list.
 
+#### --lock-store
+
+WebDAV clients such as Microsoft Office and macOS Finder lock files
+with LOCK and UNLOCK requests while they are editing them. By default
+(` + "`--lock-store memory`" + `) the locks are kept in memory so they are lost
+when rclone restarts and can't be seen by other rclone servers.
+
+Use ` + "`--lock-store disk`" + ` to keep the locks in a database in the rclone
+cache directory so they survive a restart.
+
+Use ` + "`--lock-store remote`" + ` with ` + "`--lock-store-remote remote:path`" + ` to keep
+each lock as a file in ` + "`remote:path`" + ` so it can be shared by several
+rclone servers serving the same remote, for example behind a load
+balancer. This should be a path which isn't being served, otherwise the
+lock files will be visible to clients. Storing the locks on a remote
+adds some requests to each write, so choose a fast remote for it.
+
+Locks expire after the timeout the client asked for unless they are
+refreshed, so the locks of a client which has gone away are removed
+eventually. Locks kept on disk or on a remote last at most an hour
+without being refreshed, even if the client asked for an infinite
+timeout, so the locks rclone takes while writing a file don't outlive
+a server which was stopped.
+
 ### Access WebDAV on Windows
 
 WebDAV shared folder can be mapped as a drive on Windows, however the default
@@ -216,6 +250,7 @@ type WebDAV struct {
 	proxy         *proxy.Proxy
 	ctx           context.Context // for global config
 	etagHashType  hash.Type
+	stopLocks     func() error // close the lock store
 }
 
 // check interface
@@ -260,10 +295,16 @@ func newWebDAV(ctx context.Context, f fs.Fs, opt *Options, vfsOpt *vfscommon.Opt
 	// Make sure BaseURL starts with a / and doesn't end with one
 	w.opt.HTTP.BaseURL = "/" + strings.Trim(w.opt.HTTP.BaseURL, "/")
 
+	lockSystem, stopLocks, err := newLockSystem(ctx, f, &w.opt)
+	if err != nil {
+		return nil, err
+	}
+	w.stopLocks = stopLocks
+
 	webdavHandler := &webdav.Handler{
 		Prefix:     w.opt.HTTP.BaseURL,
 		FileSystem: w,
-		LockSystem: webdav.NewMemLS(),
+		LockSystem: lockSystem,
 		Logger:     w.logRequest, // FIXME
 	}
 	w.webdavhandler = webdavHandler
@@ -449,7 +490,11 @@ func (w *WebDAV) Addr() net.Addr {
 
 // Shutdown the server
 func (w *WebDAV) Shutdown() error {
-	return w.server.Shutdown()
+	err := w.server.Shutdown()
+	if stopErr := w.stopLocks(); err == nil {
+		err = stopErr
+	}
+	return err
 }
 
 // logRequest is called by the webdav module on every request
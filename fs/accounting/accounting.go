@@ -304,6 +304,20 @@ func (acc *Account) ServerSideTransferEnd(n int64) {
 	acc.stats.Bytes(n)
 }
 
+// Skip accounts for n bytes of the transfer which don't need to be
+// transferred, for example the parts of a resumed upload which were
+// uploaded already.
+//
+// These aren't counted as transferred bytes so the size of the
+// transfer is reduced instead.
+func (acc *Account) Skip(n int64) {
+	acc.values.mu.Lock()
+	if acc.size >= n {
+		acc.size -= n
+	}
+	acc.values.mu.Unlock()
+}
+
 // serverSideEnd accounts for non specific server-side data
 func (acc *Account) serverSideEnd(n int64) {
 	// Account for bytes unless we are checking
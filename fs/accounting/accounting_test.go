@@ -195,6 +195,30 @@ func TestAccountString(t *testing.T) {
 	assert.NoError(t, acc.Close())
 }
 
+func TestAccountSkip(t *testing.T) {
+	ctx := context.Background()
+	in := io.NopCloser(bytes.NewBuffer([]byte{1, 2, 3}))
+	stats := NewStats(ctx)
+	acc := newAccountSizeName(ctx, stats, in, 4, "test")
+
+	// Skipped bytes reduce the size rather than counting as transferred
+	acc.Skip(1)
+	done, size := acc.progress()
+	assert.Equal(t, int64(0), done)
+	assert.Equal(t, int64(3), size)
+	assert.Equal(t, int64(0), stats.GetBytes())
+
+	data, err := io.ReadAll(acc)
+	assert.NoError(t, err)
+	assert.Len(t, data, 3)
+	done, size = acc.progress()
+	assert.Equal(t, int64(3), done)
+	assert.Equal(t, int64(3), size)
+	assert.Equal(t, int64(3), stats.GetBytes())
+
+	assert.NoError(t, acc.Close())
+}
+
 // Test the Accounter interface methods on Account and accountStream
 func TestAccountAccounter(t *testing.T) {
 	ctx := context.Background()
@@ -262,6 +262,11 @@ var ConfigOptionsInfo = Options{{
 	Default: "",
 	Help:    "Make backups into hierarchy based in DIR",
 	Groups:  "Sync",
+}, {
+	Name:    "checkpoint_file",
+	Default: "",
+	Help:    "Record the progress of a sync or copy in this file so it can be resumed",
+	Groups:  "Copy",
 }, {
 	Name:    "suffix",
 	Default: "",
@@ -464,6 +469,11 @@ var ConfigOptionsInfo = Options{{
 	Default: SizeSuffix(64 * 1024 * 1024),
 	Help:    "Chunk size for multi-thread downloads / uploads, if not set by filesystem",
 	Groups:  "Copy",
+}, {
+	Name:    "multi_thread_resume",
+	Default: false,
+	Help:    "Save the state of multi-thread uploads so they can be resumed if interrupted (s3 only)",
+	Groups:  "Copy",
 }, {
 	Name:    "use_json_log",
 	Default: false,
@@ -614,6 +624,7 @@ type ConfigInfo struct {
 	CompareDest                []string          `config:"compare_dest"`
 	CopyDest                   []string          `config:"copy_dest"`
 	BackupDir                  string            `config:"backup_dir"`
+	CheckpointFile             string            `config:"checkpoint_file"`
 	Suffix                     string            `config:"suffix"`
 	SuffixKeepExtension        bool              `config:"suffix_keep_extension"`
 	UseListR                   bool              `config:"fast_list"`
@@ -656,6 +667,7 @@ type ConfigInfo struct {
 	MultiThreadSet             bool              `config:"multi_thread_set"`        // whether MultiThreadStreams was set (set in fs/config/configflags)
 	MultiThreadChunkSize       SizeSuffix        `config:"multi_thread_chunk_size"` // Chunk size for multi-thread downloads / uploads, if not set by filesystem
 	MultiThreadWriteBufferSize SizeSuffix        `config:"multi_thread_write_buffer_size"`
+	MultiThreadResume          bool              `config:"multi_thread_resume"`
 	OrderBy                    string            `config:"order_by"` // instructions on how to order the transfer
 	UploadHeaders              []*HTTPOption     `config:"upload_headers"`
 	DownloadHeaders            []*HTTPOption     `config:"download_headers"`
@@ -191,6 +191,13 @@ type Features struct {
 	//
 	OpenChunkWriter func(ctx context.Context, remote string, src ObjectInfo, options ...OpenOption) (info ChunkWriterInfo, writer ChunkWriter, err error)
 
+	// ResumeChunkWriter re-opens an interrupted upload started
+	// with OpenChunkWriter
+	//
+	// Pass in the remote, the src object and the state returned by
+	// the ChunkWriterState method of the ChunkWriter
+	ResumeChunkWriter func(ctx context.Context, remote string, src ObjectInfo, state []byte, options ...OpenOption) (info ChunkWriterInfo, writer ChunkWriter, err error)
+
 	// UserInfo returns info about the connected user
 	UserInfo func(ctx context.Context) (map[string]string, error)
 
@@ -354,6 +361,9 @@ func (ft *Features) Fill(ctx context.Context, f Fs) *Features {
 	if do, ok := f.(OpenChunkWriter); ok {
 		ft.OpenChunkWriter = do.OpenChunkWriter
 	}
+	if do, ok := f.(ChunkWriterResumer); ok {
+		ft.ResumeChunkWriter = do.ResumeChunkWriter
+	}
 	if do, ok := f.(UserInfoer); ok {
 		ft.UserInfo = do.UserInfo
 	}
@@ -465,6 +475,9 @@ func (ft *Features) Mask(ctx context.Context, f Fs) *Features {
 	if mask.OpenChunkWriter == nil {
 		ft.OpenChunkWriter = nil
 	}
+	if mask.ResumeChunkWriter == nil {
+		ft.ResumeChunkWriter = nil
+	}
 	if mask.UserInfo == nil {
 		ft.UserInfo = nil
 	}
@@ -767,6 +780,27 @@ type ChunkWriter interface {
 	Abort(ctx context.Context) error
 }
 
+// ChunkWriterResumer is an optional interface for Fs to resume chunked
+// writing after an interruption
+type ChunkWriterResumer interface {
+	// ResumeChunkWriter re-opens an interrupted upload started
+	// with OpenChunkWriter
+	//
+	// Pass in the remote, the src object and the state returned by
+	// the ChunkWriterState method of the ChunkWriter. It should
+	// return an error if the upload can no longer be resumed.
+	ResumeChunkWriter(ctx context.Context, remote string, src ObjectInfo, state []byte, options ...OpenOption) (info ChunkWriterInfo, writer ChunkWriter, err error)
+}
+
+// ChunkWriterStater is an optional interface for ChunkWriter to
+// return its state so that the upload can be resumed with
+// ResumeChunkWriter
+type ChunkWriterStater interface {
+	// ChunkWriterState returns the ID of the upload on the remote
+	// and the state of the chunks written so far
+	ChunkWriterState() (uploadID string, state []byte, err error)
+}
+
 // UserInfoer is an optional interface for Fs
 type UserInfoer interface {
 	// UserInfo returns info about the connected user
@@ -0,0 +1,482 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// exprEnv is the file an expression is evaluated on
+type exprEnv struct {
+	ctx        context.Context
+	remote     string
+	size       int64
+	modTime    time.Time
+	metadata   fs.Metadata
+	o          fs.Object // may be nil
+	ignoreCase bool
+}
+
+// exprNode is a part of a parsed --filter-expr
+type exprNode interface {
+	eval(e *exprEnv) bool
+}
+
+// exprAnd is true if both a and b are
+type exprAnd struct{ a, b exprNode }
+
+func (x exprAnd) eval(e *exprEnv) bool { return x.a.eval(e) && x.b.eval(e) }
+
+// exprOr is true if either a or b is
+type exprOr struct{ a, b exprNode }
+
+func (x exprOr) eval(e *exprEnv) bool { return x.a.eval(e) || x.b.eval(e) }
+
+// exprNot is true if a isn't
+type exprNot struct{ a exprNode }
+
+func (x exprNot) eval(e *exprEnv) bool { return !x.a.eval(e) }
+
+// exprString compares a string field of the file with the values
+type exprString struct {
+	get    func(e *exprEnv) string
+	op     string
+	values []string
+	re     *regexp.Regexp // for ~ and !~
+}
+
+func (x *exprString) eval(e *exprEnv) bool {
+	value := x.get(e)
+	switch x.op {
+	case "~":
+		return x.re.MatchString(value)
+	case "!~":
+		return !x.re.MatchString(value)
+	}
+	equal := false
+	for _, want := range x.values {
+		if value == want || (e.ignoreCase && strings.EqualFold(value, want)) {
+			equal = true
+			break
+		}
+	}
+	if x.op == "!=" {
+		return !equal
+	}
+	return equal
+}
+
+// exprNumber compares a numeric field of the file with the values
+type exprNumber struct {
+	get    func(e *exprEnv) int64
+	op     string
+	values []int64
+}
+
+func (x *exprNumber) eval(e *exprEnv) bool {
+	value := x.get(e)
+	switch x.op {
+	case "<":
+		return value < x.values[0]
+	case "<=":
+		return value <= x.values[0]
+	case ">":
+		return value > x.values[0]
+	case ">=":
+		return value >= x.values[0]
+	case "!=":
+		return value != x.values[0]
+	}
+	for _, want := range x.values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// expr is a parsed --filter-expr
+type expr struct {
+	source       string
+	root         exprNode
+	needModTime  bool // set if the expression uses the modification time
+	needMetadata bool // set if the expression uses the metadata
+}
+
+// include returns whether the file passes the expression
+func (x *expr) include(e *exprEnv) bool {
+	return x.root.eval(e)
+}
+
+// exprOps are the operators in expressions longest first
+var exprOps = []string{"&&", "||", "==", "!=", "<=", ">=", "!~", "!", "(", ")", "[", "]", ",", "<", ">", "~"}
+
+// exprToken is a token of an expression
+type exprToken struct {
+	pos    int    // offset in the expression
+	text   string // the token
+	quoted bool   // set if the token was a quoted string
+}
+
+// exprTokenize splits the expression s into tokens
+func exprTokenize(s string) (tokens []exprToken, err error) {
+	i := 0
+outer:
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"' || c == '\'':
+			// "..." allows \" and \\ escapes, '...' has none
+			start := i
+			var text strings.Builder
+			for i++; i < len(s); i++ {
+				switch {
+				case s[i] == c:
+					tokens = append(tokens, exprToken{pos: start, text: text.String(), quoted: true})
+					i++
+					continue outer
+				case c == '"' && s[i] == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+					i++
+				}
+				text.WriteByte(s[i])
+			}
+			return nil, fmt.Errorf("unterminated string at offset %d", start)
+		}
+		for _, op := range exprOps {
+			if strings.HasPrefix(s[i:], op) {
+				tokens = append(tokens, exprToken{pos: i, text: op})
+				i += len(op)
+				continue outer
+			}
+		}
+		// a bare word runs up to the next space or operator
+		start := i
+		for i < len(s) && !strings.ContainsRune(" \t\n\r\"'&|=!<>~()[],", rune(s[i])) {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+		}
+		tokens = append(tokens, exprToken{pos: start, text: s[start:i]})
+	}
+	return tokens, nil
+}
+
+// exprParser parses a list of tokens into an expression
+type exprParser struct {
+	tokens     []exprToken
+	i          int
+	ignoreCase bool
+	x          *expr
+}
+
+// peek returns the next token or "" at the end
+func (p *exprParser) peek() string {
+	if p.i >= len(p.tokens) || p.tokens[p.i].quoted {
+		return ""
+	}
+	return p.tokens[p.i].text
+}
+
+// next returns the next token or an error at the end
+func (p *exprParser) next(what string) (exprToken, error) {
+	if p.i >= len(p.tokens) {
+		return exprToken{}, fmt.Errorf("expecting %s at end of expression", what)
+	}
+	p.i++
+	return p.tokens[p.i-1], nil
+}
+
+// isOp returns true if tok is an operator
+func (tok exprToken) isOp() bool {
+	return !tok.quoted && slices.Contains(exprOps, tok.text)
+}
+
+// errorfAt returns an error about the token tok
+func errorfAt(tok exprToken, format string, a ...any) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, a...), tok.pos)
+}
+
+// parseOr parses a || b || ...
+func (p *exprParser) parseOr() (exprNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.i++
+		b, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node = exprOr{node, b}
+	}
+	return node, nil
+}
+
+// parseAnd parses a && b && ...
+func (p *exprParser) parseAnd() (exprNode, error) {
+	node, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.i++
+		b, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		node = exprAnd{node, b}
+	}
+	return node, nil
+}
+
+// parseNot parses !a, (a) and comparisons
+func (p *exprParser) parseNot() (exprNode, error) {
+	switch p.peek() {
+	case "!":
+		p.i++
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return exprNot{node}, nil
+	case "(":
+		p.i++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		tok, err := p.next("\")\"")
+		if err != nil {
+			return nil, err
+		}
+		if tok.quoted || tok.text != ")" {
+			return nil, errorfAt(tok, "expecting \")\" but got %q", tok.text)
+		}
+		return node, nil
+	}
+	return p.parseCompare()
+}
+
+// parseValues parses a value or a list of values in [...]
+func (p *exprParser) parseValues() (values []exprToken, err error) {
+	tok, err := p.next("value")
+	if err != nil {
+		return nil, err
+	}
+	if tok.quoted || tok.text != "[" {
+		if tok.isOp() {
+			return nil, errorfAt(tok, "expecting value but got %q", tok.text)
+		}
+		return []exprToken{tok}, nil
+	}
+	for {
+		tok, err := p.next("value")
+		if err != nil {
+			return nil, err
+		}
+		if tok.isOp() {
+			return nil, errorfAt(tok, "expecting value but got %q", tok.text)
+		}
+		values = append(values, tok)
+		sep, err := p.next("\",\" or \"]\"")
+		if err != nil {
+			return nil, err
+		}
+		if sep.quoted || (sep.text != "," && sep.text != "]") {
+			return nil, errorfAt(sep, "expecting \",\" or \"]\" but got %q", sep.text)
+		}
+		if sep.text == "]" {
+			return values, nil
+		}
+	}
+}
+
+// exprStringFields gets the string fields of the file
+var exprStringFields = map[string]func(e *exprEnv) string{
+	"name": func(e *exprEnv) string {
+		return path.Base(e.remote)
+	},
+	"path": func(e *exprEnv) string {
+		return e.remote
+	},
+	"ext": func(e *exprEnv) string {
+		return strings.TrimPrefix(path.Ext(e.remote), ".")
+	},
+	"mime": func(e *exprEnv) string {
+		var mimeType string
+		if e.o != nil {
+			mimeType = fs.MimeType(e.ctx, e.o)
+		} else {
+			mimeType = fs.MimeTypeFromName(e.remote)
+		}
+		// compare without parameters like "; charset=utf-8"
+		mediaType, _, err := mime.ParseMediaType(mimeType)
+		if err != nil {
+			mediaType, _, _ = strings.Cut(mimeType, ";")
+			return strings.TrimSpace(mediaType)
+		}
+		return mediaType
+	},
+}
+
+// exprNumberFields gets the numeric fields of the file
+var exprNumberFields = map[string]func(e *exprEnv) int64{
+	"size": func(e *exprEnv) int64 {
+		return e.size
+	},
+	"modtime": func(e *exprEnv) int64 {
+		return e.modTime.UnixNano()
+	},
+	"age": func(e *exprEnv) int64 {
+		return int64(time.Since(e.modTime))
+	},
+}
+
+// parseCompare parses field op value
+func (p *exprParser) parseCompare() (exprNode, error) {
+	fieldTok, err := p.next("field name")
+	if err != nil {
+		return nil, err
+	}
+	field := fieldTok.text
+	if fieldTok.quoted || fieldTok.isOp() {
+		return nil, errorfAt(fieldTok, "expecting field name but got %q", field)
+	}
+	opTok, err := p.next("operator")
+	if err != nil {
+		return nil, err
+	}
+	op := opTok.text
+	if opTok.quoted {
+		op = ""
+	}
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "~", "!~", "in":
+	default:
+		return nil, errorfAt(opTok, "expecting operator after %q but got %q", field, opTok.text)
+	}
+	valueToks, err := p.parseValues()
+	if err != nil {
+		return nil, err
+	}
+	if op != "in" && len(valueToks) != 1 {
+		return nil, errorfAt(opTok, "only \"in\" can be used with a list")
+	}
+
+	// Numeric fields
+	if get, ok := exprNumberFields[field]; ok {
+		if op == "~" || op == "!~" {
+			return nil, errorfAt(opTok, "can't use %q with %q", op, field)
+		}
+		if field != "size" {
+			p.x.needModTime = true
+		}
+		node := &exprNumber{get: get, op: op}
+		for _, tok := range valueToks {
+			var value int64
+			switch field {
+			case "size":
+				var size fs.SizeSuffix
+				err = size.Set(tok.text)
+				value = int64(size)
+			case "modtime":
+				var t time.Time
+				t, err = fs.ParseTime(tok.text)
+				value = t.UnixNano()
+			case "age":
+				var d time.Duration
+				d, err = fs.ParseDuration(tok.text)
+				value = int64(d)
+			}
+			if err != nil {
+				return nil, errorfAt(tok, "bad %s %q: %v", field, tok.text, err)
+			}
+			node.values = append(node.values, value)
+		}
+		return node, nil
+	}
+
+	// String fields
+	get, ok := exprStringFields[field]
+	switch {
+	case ok:
+	case strings.HasPrefix(field, "meta."):
+		key := strings.ToLower(strings.TrimPrefix(field, "meta."))
+		p.x.needMetadata = true
+		get = func(e *exprEnv) string {
+			return e.metadata[key]
+		}
+	case strings.HasPrefix(field, "hash."):
+		var ht hash.Type
+		err := ht.Set(strings.TrimPrefix(field, "hash."))
+		if err != nil {
+			return nil, errorfAt(fieldTok, "bad hash in %q: %v", field, err)
+		}
+		get = func(e *exprEnv) string {
+			if e.o == nil {
+				return ""
+			}
+			sum, err := e.o.Hash(e.ctx, ht)
+			if err != nil {
+				fs.Debugf(e.o, "Failed to read hash for --filter-expr: %v", err)
+				return ""
+			}
+			return sum
+		}
+	default:
+		return nil, errorfAt(fieldTok, "unknown field %q", field)
+	}
+	switch op {
+	case "<", "<=", ">", ">=":
+		return nil, errorfAt(opTok, "can't use %q with %q", op, field)
+	}
+	node := &exprString{get: get, op: op}
+	for _, tok := range valueToks {
+		node.values = append(node.values, tok.text)
+	}
+	if op == "~" || op == "!~" {
+		re := node.values[0]
+		if p.ignoreCase {
+			re = "(?i)" + re
+		}
+		node.re, err = regexp.Compile(re)
+		if err != nil {
+			return nil, errorfAt(valueToks[0], "bad regexp: %v", err)
+		}
+	}
+	return node, nil
+}
+
+// newExpr parses the --filter-expr s
+func newExpr(s string, ignoreCase bool) (*expr, error) {
+	x := &expr{source: s}
+	tokens, err := exprTokenize(s)
+	if err == nil && len(tokens) == 0 {
+		err = errors.New("empty expression")
+	}
+	if err == nil {
+		p := &exprParser{tokens: tokens, ignoreCase: ignoreCase, x: x}
+		x.root, err = p.parseOr()
+		if err == nil && p.i < len(tokens) {
+			err = errorfAt(tokens[p.i], "unexpected %q", tokens[p.i].text)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter: --filter-expr: %w", err)
+	}
+	return x, nil
+}
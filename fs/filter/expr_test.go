@@ -0,0 +1,182 @@
+package filter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExprTokenize(t *testing.T) {
+	tokens, err := exprTokenize(`size>=5M&&(ext in [jpg,"p n\"g"]||path!~'^raw\/')`)
+	require.NoError(t, err)
+	var got []string
+	for _, tok := range tokens {
+		got = append(got, tok.text)
+	}
+	assert.Equal(t, []string{"size", ">=", "5M", "&&", "(", "ext", "in", "[", "jpg", ",", `p n"g`, "]", "||", "path", "!~", `^raw\/`, ")"}, got)
+
+	_, err = exprTokenize(`name == "potato`)
+	assert.ErrorContains(t, err, "unterminated string at offset 8")
+	_, err = exprTokenize(`size > 5 & name == a`)
+	assert.ErrorContains(t, err, `unexpected '&' at offset 9`)
+}
+
+func TestExprParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		in  string
+		err string
+	}{
+		{"", "empty expression"},
+		{"size", "expecting operator at end of expression"},
+		{"size >", "expecting value at end of expression"},
+		{"size > )", `expecting value but got ")" at offset 7`},
+		{"size = 5", `unexpected '=' at offset 5`},
+		{"potato == 5", `unknown field "potato" at offset 0`},
+		{"size potato 5", `expecting operator after "size" but got "potato" at offset 5`},
+		{"size > potato", `bad size "potato"`},
+		{"age > potato", `bad age "potato"`},
+		{"modtime > potato", `bad modtime "potato"`},
+		{"size ~ 5", `can't use "~" with "size" at offset 5`},
+		{"name > a", `can't use ">" with "name" at offset 5`},
+		{"name == [a,b]", `only "in" can be used with a list at offset 5`},
+		{"name in [a b]", `expecting "," or "]" but got "b" at offset 11`},
+		{"name in [a,", "expecting value at end of expression"},
+		{"name ~ '['", "bad regexp"},
+		{"hash.potato == a", `bad hash in "hash.potato"`},
+		{"(name == a", `expecting ")" at end of expression`},
+		{"name == a name == b", `unexpected "name" at offset 10`},
+	} {
+		_, err := newExpr(test.in, false)
+		require.Error(t, err, test.in)
+		assert.Contains(t, err.Error(), "filter: --filter-expr: ", test.in)
+		assert.Contains(t, err.Error(), test.err, test.in)
+	}
+}
+
+func TestExprInclude(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	o := mockobject.New("raw/dir/photo.JPG").WithContent([]byte("hello"), mockobject.SeekModeNone)
+	md5, err := o.Hash(ctx, hash.MD5)
+	require.NoError(t, err)
+	env := &exprEnv{
+		ctx:      ctx,
+		remote:   o.Remote(),
+		size:     6 * 1024 * 1024,
+		modTime:  now.Add(-48 * time.Hour),
+		metadata: fs.Metadata{"camera": "potato"},
+		o:        o,
+	}
+	for _, test := range []struct {
+		in   string
+		want bool
+	}{
+		{"size > 5M", true},
+		{"size < 5M", false},
+		{"size >= 6M && size <= 6M", true},
+		{"size == 6M", true},
+		{"size != 6M", false},
+		{"size in [1k, 6M]", true},
+		{"age < 1w", true},
+		{"age > 1d", true},
+		{"age > 3d", false},
+		{"modtime > 1w", true},
+		{"modtime < 1d", true},
+		{"modtime > 2001-01-01", true},
+		{"name == photo.JPG", true},
+		{"name == photo.jpg", false},
+		{"name != photo.jpg", true},
+		{"ext in [jpg,JPG]", true},
+		{"ext == png", false},
+		{"path ~ '^raw/'", true},
+		{`path ~ "^dir/"`, false},
+		{`path !~ "^dir/"`, true},
+		{"mime == image/jpeg", true},
+		{"meta.camera == potato", true},
+		{"meta.Camera == potato", true},
+		{"meta.lens == potato", false},
+		{"meta.lens == ''", true},
+		{"hash.md5 == " + md5, true},
+		{"hash.md5 != " + md5, false},
+		{"!(size > 5M)", false},
+		{"! size > 5M", false},
+		{"size > 5M && (ext in [png] || path ~ '^raw/')", true},
+		{"size > 10M || ext == png || name ~ photo", true},
+		{"size > 10M || ext == png && name ~ photo", false},
+		{"size < 10M || ext == png && name ~ potato", true},
+	} {
+		x, err := newExpr(test.in, false)
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, x.include(env), test.in)
+	}
+
+	// Case insensitive matching
+	env.ignoreCase = true
+	for _, test := range []struct {
+		in   string
+		want bool
+	}{
+		{"name == photo.jpg", true},
+		{"ext in [png,jpg]", true},
+		{"name ~ '^PHOTO'", true},
+	} {
+		x, err := newExpr(test.in, true)
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, x.include(env), test.in)
+	}
+
+	// MIME types are compared without their parameters
+	env = &exprEnv{ctx: ctx, remote: "notes.txt"}
+	require.Equal(t, "text/plain; charset=utf-8", fs.MimeTypeFromName(env.remote))
+	for _, test := range []struct {
+		in   string
+		want bool
+	}{
+		{"mime == text/plain", true},
+		{"mime in [text/html, text/plain]", true},
+		{"mime ~ '^text/'", true},
+		{"mime == 'text/plain; charset=utf-8'", false},
+	} {
+		x, err := newExpr(test.in, false)
+		require.NoError(t, err, test.in)
+		assert.Equal(t, test.want, x.include(env), test.in)
+	}
+}
+
+func TestNewFilterExpr(t *testing.T) {
+	ctx := context.Background()
+	opt := Opt
+	opt.FilterExpr = "size > 1k || path ~ '^raw/'"
+	f, err := NewFilter(&opt)
+	require.NoError(t, err)
+	assert.False(t, f.InActive())
+	assert.False(t, f.expr.needModTime)
+	assert.False(t, f.expr.needMetadata)
+
+	assert.True(t, f.Include("big", 2048, time.Now(), nil))
+	assert.False(t, f.Include("small", 10, time.Now(), nil))
+	assert.True(t, f.Include("raw/small", 10, time.Now(), nil))
+	assert.True(t, f.IncludeObject(ctx, mockobject.New("raw/small")))
+	assert.False(t, f.IncludeObject(ctx, mockobject.New("small")))
+	assert.Contains(t, f.DumpFilters(), "Files must match the expression: size > 1k || path ~ '^raw/'")
+
+	// The expression is combined with the other filters
+	require.NoError(t, f.AddRule("- raw/**"))
+	assert.False(t, f.Include("raw/small", 10, time.Now(), nil))
+
+	opt.FilterExpr = "age < 1d && meta.potato == yes"
+	f, err = NewFilter(&opt)
+	require.NoError(t, err)
+	assert.True(t, f.expr.needModTime)
+	assert.True(t, f.expr.needMetadata)
+
+	opt.FilterExpr = "size >"
+	_, err = NewFilter(&opt)
+	assert.ErrorContains(t, err, "--filter-expr")
+}
@@ -35,6 +35,11 @@ var OptionsInfo = fs.Options{{
 	Default: []string{},
 	Help:    "Exclude directories if filename is present",
 	Groups:  "Filter",
+}, {
+	Name:    "ignore_file",
+	Default: []string{},
+	Help:    "Read gitignore style exclude patterns from files with this name in each directory",
+	Groups:  "Filter",
 }, {
 	Name:    "files_from",
 	Default: []string{},
@@ -106,6 +111,11 @@ var OptionsInfo = fs.Options{{
 	Default: []string{},
 	Help:    "Read file include patterns from file (use - to read from stdin)",
 	Groups:  "Filter",
+}, {
+	Name:    "filter_expr",
+	Default: "",
+	Help:    "Only transfer files matching this expression, e.g. 'size > 5M && ext in [jpg,png]'",
+	Groups:  "Filter",
 }, {
 	Name:    "metadata_filter",
 	Default: []string{},
@@ -143,6 +153,7 @@ type Options struct {
 	DeleteExcluded bool          `config:"delete_excluded"`
 	RulesOpt                     // embedded so we don't change the JSON API
 	ExcludeFile    []string      `config:"exclude_if_present"`
+	IgnoreFile     []string      `config:"ignore_file"`
 	FilesFrom      []string      `config:"files_from"`
 	FilesFromRaw   []string      `config:"files_from_raw"`
 	MetaRules      RulesOpt      `config:"metadata"`
@@ -152,6 +163,7 @@ type Options struct {
 	MaxSize        fs.SizeSuffix `config:"max_size"`
 	IgnoreCase     bool          `config:"ignore_case"`
 	HashFilter     string        `config:"hash_filter"`
+	FilterExpr     string        `config:"filter_expr"`
 }
 
 func init() {
@@ -177,10 +189,12 @@ type Filter struct {
 	fileRules   rules
 	dirRules    rules
 	metaRules   rules
-	files       FilesMap // files if filesFrom
-	dirs        FilesMap // dirs from filesFrom
-	hashFilterN uint64   // if non 0 do hash filtering
-	hashFilterK uint64   // select partition K/N
+	files       FilesMap     // files if filesFrom
+	dirs        FilesMap     // dirs from filesFrom
+	hashFilterN uint64       // if non 0 do hash filtering
+	hashFilterK uint64       // select partition K/N
+	ignore      *ignoreFiles // ignore files read if --ignore-file
+	expr        *expr        // parsed --filter-expr if set
 }
 
 // NewFilter parses the command line options and creates a Filter
@@ -215,6 +229,17 @@ func NewFilter(opt *Options) (f *Filter, err error) {
 		fs.Debugf(nil, "Using --hash-filter %d/%d", f.hashFilterK, f.hashFilterN)
 	}
 
+	if len(f.Opt.IgnoreFile) > 0 {
+		f.ignore = newIgnoreFiles(f.Opt.IgnoreFile, f.Opt.IgnoreCase)
+	}
+	if f.Opt.FilterExpr != "" {
+		f.expr, err = newExpr(f.Opt.FilterExpr, f.Opt.IgnoreCase)
+		if err != nil {
+			return nil, err
+		}
+		fs.Debugf(nil, "Using --filter-expr %q", f.Opt.FilterExpr)
+	}
+
 	err = parseRules(&f.Opt.RulesOpt, f.Add, f.Clear)
 	if err != nil {
 		return nil, err
@@ -414,6 +439,8 @@ func (f *Filter) InActive() bool {
 		f.dirRules.len() == 0 &&
 		f.metaRules.len() == 0 &&
 		len(f.Opt.ExcludeFile) == 0 &&
+		len(f.Opt.IgnoreFile) == 0 &&
+		f.expr == nil &&
 		f.hashFilterN == 0)
 }
 
@@ -474,6 +501,11 @@ func (f *Filter) IncludeDirectory(ctx context.Context, fs fs.Fs) func(string) (b
 			return false, nil
 		}
 
+		// then if an ignore file excludes it
+		if f.ignore != nil && f.ignore.excluded(ignoreRoot(ctx, fs), remote, true) {
+			return false, nil
+		}
+
 		// filesFrom takes precedence
 		if f.files != nil {
 			_, include := f.dirs[remote]
@@ -502,9 +534,65 @@ func (f *Filter) DirContainsExcludeFile(ctx context.Context, fremote fs.Fs, remo
 	return false, nil
 }
 
+// UsesIgnoreFiles returns true if --ignore-file is in use
+func (f *Filter) UsesIgnoreFiles() bool {
+	return f.ignore != nil
+}
+
+// LoadIgnoreFiles reads the ignore files set with --ignore-file in
+// the directory dir of f, and its parents if not already read, so
+// they are used to filter the entries listed from dir.
+//
+// The ignore files are looked for with NewObject. Use
+// LoadIgnoreFilesFromList when dir has been listed.
+//
+// If the context was made with SetIgnoreRoot the ignore files are
+// read from the same directory of that Fs instead.
+//
+// It does nothing if --ignore-file isn't in use.
+func (f *Filter) LoadIgnoreFiles(ctx context.Context, fremote fs.Fs, dir string) error {
+	if f.ignore == nil {
+		return nil
+	}
+	if root := getIgnoreRoot(ctx); root != nil {
+		fremote = root
+	}
+	return f.ignore.load(ctx, fremote, dir, nil)
+}
+
+// LoadIgnoreFilesFromList is like LoadIgnoreFiles but takes the
+// ignore files in dir from entries, its listing, with the size and
+// modification time they were listed with, so nothing needs to be
+// looked up to check the cached rules are up to date.
+//
+// If the context was made with SetIgnoreRoot for a different Fs the
+// ignore files aren't in entries so they are looked for as with
+// LoadIgnoreFiles.
+func (f *Filter) LoadIgnoreFilesFromList(ctx context.Context, fremote fs.Fs, dir string, entries fs.DirEntries) error {
+	if f.ignore == nil {
+		return nil
+	}
+	if root := getIgnoreRoot(ctx); root != nil && fs.ConfigString(root) != fs.ConfigString(fremote) {
+		return f.ignore.load(ctx, root, dir, nil)
+	}
+	if entries == nil {
+		// an empty listing has no ignore files in
+		entries = fs.DirEntries{}
+	}
+	return f.ignore.load(ctx, fremote, dir, entries)
+}
+
 // Include returns whether this object should be included into the
 // sync or not and logs the reason for exclusion if not included
 func (f *Filter) Include(remote string, size int64, modTime time.Time, metadata fs.Metadata) bool {
+	return f.include(context.Background(), remote, size, modTime, metadata, nil)
+}
+
+// include returns whether this object should be included into the
+// sync or not and logs the reason for exclusion if not included.
+//
+// o may be nil if the object isn't known.
+func (f *Filter) include(ctx context.Context, remote string, size int64, modTime time.Time, metadata fs.Metadata, o fs.Object) bool {
 	// filesFrom takes precedence
 	if f.files != nil {
 		_, include := f.files[remote]
@@ -544,6 +632,21 @@ func (f *Filter) Include(remote string, size int64, modTime time.Time, metadata
 			return false
 		}
 	}
+	if f.expr != nil {
+		env := exprEnv{
+			ctx:        ctx,
+			remote:     remote,
+			size:       size,
+			modTime:    modTime,
+			metadata:   metadata,
+			o:          o,
+			ignoreCase: f.Opt.IgnoreCase,
+		}
+		if !f.expr.include(&env) {
+			fs.Debugf(remote, "Excluded (Expression Filter)")
+			return false
+		}
+	}
 	include := f.IncludeRemote(remote)
 	if !include {
 		fs.Debugf(remote, "Excluded (Path Filter)")
@@ -555,15 +658,20 @@ func (f *Filter) Include(remote string, size int64, modTime time.Time, metadata
 // the sync or not. This is a convenience function to avoid calling
 // o.ModTime(), which is an expensive operation.
 func (f *Filter) IncludeObject(ctx context.Context, o fs.Object) bool {
+	if f.ignore != nil && f.files == nil && f.ignore.excluded(ignoreRoot(ctx, o.Fs()), o.Remote(), false) {
+		fs.Debugf(o, "Excluded (Ignore File)")
+		return false
+	}
+
 	var modTime time.Time
 
-	if !f.ModTimeFrom.IsZero() || !f.ModTimeTo.IsZero() {
+	if !f.ModTimeFrom.IsZero() || !f.ModTimeTo.IsZero() || (f.expr != nil && f.expr.needModTime) {
 		modTime = o.ModTime(ctx)
 	} else {
 		modTime = time.Unix(0, 0)
 	}
 	var metadata fs.Metadata
-	if f.metaRules.len() > 0 {
+	if f.metaRules.len() > 0 || (f.expr != nil && f.expr.needMetadata) {
 		var err error
 		metadata, err = fs.GetMetadata(ctx, o)
 		if err != nil {
@@ -572,7 +680,7 @@ func (f *Filter) IncludeObject(ctx context.Context, o fs.Object) bool {
 		}
 
 	}
-	return f.Include(o.Remote(), o.Size(), modTime, metadata)
+	return f.include(ctx, o.Remote(), o.Size(), modTime, metadata, o)
 }
 
 // DumpFilters dumps the filters in textual form, 1 per line
@@ -590,6 +698,12 @@ func (f *Filter) DumpFilters() string {
 	if f.Opt.MaxSize >= 0 {
 		rules = append(rules, fmt.Sprintf("Maximum size is: %s", f.Opt.MaxSize.ByteUnit()))
 	}
+	if f.expr != nil {
+		rules = append(rules, fmt.Sprintf("Files must match the expression: %s", f.expr.source))
+	}
+	for _, name := range f.Opt.IgnoreFile {
+		rules = append(rules, fmt.Sprintf("Exclude patterns are read from files called: %s", name))
+	}
 	rules = append(rules, "--- File filter rules ---")
 	for _, rule := range f.fileRules.rules {
 		rules = append(rules, rule.String())
@@ -0,0 +1,369 @@
+package filter
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// ignorePattern is one pattern from an ignore file
+type ignorePattern struct {
+	negate  bool           // set if the pattern re-includes paths
+	dirOnly bool           // set if the pattern only matches directories
+	re      *regexp.Regexp // matches paths relative to the ignore file
+}
+
+// ignoreFile is the parsed patterns of the ignore files in a directory
+type ignoreFile struct {
+	sizes    []int64     // sizes of the ignore files read
+	modTimes []time.Time // modification times of the ignore files read
+	patterns []ignorePattern
+}
+
+// ignoreFiles caches the ignore files read from the directories of
+// each remote as they are listed
+type ignoreFiles struct {
+	names      []string // the names of the ignore files
+	ignoreCase bool
+
+	mu   sync.Mutex
+	dirs map[string]*ignoreFile // keyed on the root the rules apply to and the directory
+}
+
+// newIgnoreFiles makes a new cache for the ignore files called names
+func newIgnoreFiles(names []string, ignoreCase bool) *ignoreFiles {
+	return &ignoreFiles{
+		names:      names,
+		ignoreCase: ignoreCase,
+		dirs:       make(map[string]*ignoreFile),
+	}
+}
+
+// ignoreKey returns the cache key of the rules for directory dir
+// relative to root, the Fs the ignore files are read from
+func ignoreKey(root fs.Info, dir string) string {
+	return fs.ConfigString(root) + "\x00" + dir
+}
+
+// Context key for the Fs the ignore files are read from
+type ignoreRootContextKeyType struct{}
+
+var ignoreRootContextKey = ignoreRootContextKeyType{}
+
+// SetIgnoreRoot returns a context which makes the listings done with
+// it read the ignore files set with --ignore-file from root instead
+// of from the Fs being listed.
+//
+// The rules are keyed on the directory relative to root so this is
+// used to filter the destination of a sync with the ignore files of
+// the source, like rsync's dir-merge rules, otherwise files excluded
+// from the source would be deleted from the destination.
+func SetIgnoreRoot(ctx context.Context, root fs.Fs) context.Context {
+	return context.WithValue(ctx, ignoreRootContextKey, root)
+}
+
+// getIgnoreRoot returns the Fs set with SetIgnoreRoot or nil
+func getIgnoreRoot(ctx context.Context) fs.Fs {
+	if ctx == nil {
+		return nil
+	}
+	root, _ := ctx.Value(ignoreRootContextKey).(fs.Fs)
+	return root
+}
+
+// ignoreRoot returns the Fs the ignore files for f are read from
+func ignoreRoot(ctx context.Context, f fs.Info) fs.Info {
+	if root := getIgnoreRoot(ctx); root != nil {
+		return root
+	}
+	return f
+}
+
+// classEscaper escapes the characters in a character class which are
+// special to regexp
+var classEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// ignoreGlobToRegexp converts the gitignore style glob pattern into
+// a regular expression matching a path.
+//
+// `*` and `?` don't match `/`, `**/` matches any number of
+// directories and a trailing `/**` matches everything inside.
+func ignoreGlobToRegexp(pattern string) string {
+	var re strings.Builder
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "**" {
+			if last {
+				re.WriteString(".*")
+			} else {
+				re.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+		for j := 0; j < len(segment); j++ {
+			c := segment[j]
+			switch c {
+			case '\\':
+				if j+1 < len(segment) {
+					j++
+					c = segment[j]
+				}
+				re.WriteString(regexp.QuoteMeta(string(c)))
+			case '*':
+				re.WriteString("[^/]*")
+				for j+1 < len(segment) && segment[j+1] == '*' {
+					j++
+				}
+			case '?':
+				re.WriteString("[^/]")
+			case '[':
+				// find the end of the class allowing a leading ]
+				k := j + 1
+				if k < len(segment) && (segment[k] == '!' || segment[k] == '^') {
+					k++
+				}
+				if k < len(segment) && segment[k] == ']' {
+					k++
+				}
+				end := strings.IndexByte(segment[k:], ']')
+				if end < 0 {
+					re.WriteString(`\[`)
+					break
+				}
+				class := segment[j+1 : k+end]
+				j = k + end
+				re.WriteByte('[')
+				if class[0] == '!' || class[0] == '^' {
+					re.WriteByte('^')
+					class = class[1:]
+				}
+				re.WriteString(classEscaper.Replace(class))
+				re.WriteByte(']')
+			default:
+				re.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		}
+		if !last {
+			re.WriteByte('/')
+		}
+	}
+	return re.String()
+}
+
+// parseIgnoreLine parses a line of a gitignore style ignore file
+// returning false if it doesn't hold a pattern.
+//
+// Patterns containing a `/` other than at the end match paths
+// relative to the directory of the ignore file, otherwise they match
+// names at any depth below it. A leading `!` re-includes paths
+// excluded by earlier patterns and a trailing `/` only matches
+// directories.
+func parseIgnoreLine(line string, ignoreCase bool) (p ignorePattern, ok bool, err error) {
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return p, false, nil
+	}
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimLeft(line, "/")
+	if line == "" {
+		return p, false, nil
+	}
+	re := ignoreGlobToRegexp(line)
+	if !anchored {
+		re = "(?:.*/)?" + re
+	}
+	re = "^" + re + "$"
+	if ignoreCase {
+		re = "(?i)" + re
+	}
+	p.re, err = regexp.Compile(re)
+	if err != nil {
+		return p, false, fmt.Errorf("bad ignore pattern %q: %w", line, err)
+	}
+	return p, true, nil
+}
+
+// read reads the ignore file o adding its patterns to ignore
+func (ignore *ignoreFile) read(ctx context.Context, o fs.Object, ignoreCase bool) (err error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(in, &err)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		p, ok, err := parseIgnoreLine(scanner.Text(), ignoreCase)
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.Remote(), err)
+		}
+		if ok {
+			ignore.patterns = append(ignore.patterns, p)
+		}
+	}
+	return scanner.Err()
+}
+
+// load reads the ignore files in dir of f if they have changed since
+// they were last read, and those in the parent directories of dir if
+// they haven't been read yet.
+//
+// f is the root the rules apply to. If entries is not nil it is the
+// listing of dir in f which the ignore files are taken from,
+// otherwise they are looked for with NewObject.
+func (is *ignoreFiles) load(ctx context.Context, f fs.Fs, dir string, entries fs.DirEntries) error {
+	dir = strings.Trim(dir, "/")
+	for parent := dir; parent != ""; {
+		parent = path.Dir(parent)
+		if parent == "." {
+			parent = ""
+		}
+		is.mu.Lock()
+		_, found := is.dirs[ignoreKey(f, parent)]
+		is.mu.Unlock()
+		if !found {
+			err := is.loadDir(ctx, f, parent, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return is.loadDir(ctx, f, dir, entries)
+}
+
+// find returns the ignore files in dir of f in the order of their
+// names, taking them from entries if it is not nil
+func (is *ignoreFiles) find(ctx context.Context, f fs.Fs, dir string, entries fs.DirEntries) (objs []fs.Object, err error) {
+	if entries == nil {
+		for _, name := range is.names {
+			o, err := f.NewObject(ctx, path.Join(dir, name))
+			if errors.Is(err, fs.ErrorObjectNotFound) || errors.Is(err, fs.ErrorIsDir) || errors.Is(err, fs.ErrorDirNotFound) || errors.Is(err, fs.ErrorNotAFile) {
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to find ignore file: %w", err)
+			}
+			objs = append(objs, o)
+		}
+		return objs, nil
+	}
+	found := make([]fs.Object, len(is.names))
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok {
+			continue
+		}
+		leaf := path.Base(o.Remote())
+		for i, name := range is.names {
+			if leaf == name {
+				found[i] = o
+			}
+		}
+	}
+	for _, o := range found {
+		if o != nil {
+			objs = append(objs, o)
+		}
+	}
+	return objs, nil
+}
+
+// loadDir reads the ignore files in dir of f if they have changed
+//
+// If entries is not nil the ignore files are taken from it.
+func (is *ignoreFiles) loadDir(ctx context.Context, f fs.Fs, dir string, entries fs.DirEntries) error {
+	objs, err := is.find(ctx, f, dir, entries)
+	if err != nil {
+		return err
+	}
+	key := ignoreKey(f, dir)
+	is.mu.Lock()
+	old := is.dirs[key]
+	is.mu.Unlock()
+	if old != nil && !old.changed(ctx, objs) {
+		return nil
+	}
+	ignore := &ignoreFile{}
+	for _, o := range objs {
+		fs.Debugf(o, "Reading ignore file")
+		ignore.sizes = append(ignore.sizes, o.Size())
+		ignore.modTimes = append(ignore.modTimes, o.ModTime(ctx))
+		err := ignore.read(ctx, o, is.ignoreCase)
+		if err != nil {
+			return fmt.Errorf("failed to read ignore file: %w", err)
+		}
+	}
+	is.mu.Lock()
+	is.dirs[key] = ignore
+	is.mu.Unlock()
+	return nil
+}
+
+// changed returns true if objs are different to the ignore files read
+func (ignore *ignoreFile) changed(ctx context.Context, objs []fs.Object) bool {
+	if len(objs) != len(ignore.sizes) {
+		return true
+	}
+	for i, o := range objs {
+		if o.Size() != ignore.sizes[i] || !o.ModTime(ctx).Equal(ignore.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded returns true if the ignore files read from root exclude
+// remote, the path relative to root.
+//
+// The patterns in deeper directories take precedence and in each
+// directory the last pattern to match is used.
+func (is *ignoreFiles) excluded(root fs.Info, remote string, isDir bool) bool {
+	remote = strings.Trim(remote, "/")
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for dir := remote; dir != ""; {
+		dir = path.Dir(dir)
+		if dir == "." {
+			dir = ""
+		}
+		ignore := is.dirs[ignoreKey(root, dir)]
+		if ignore == nil {
+			continue
+		}
+		rel := remote
+		if dir != "" {
+			rel = remote[len(dir)+1:]
+		}
+		for i := len(ignore.patterns) - 1; i >= 0; i-- {
+			p := &ignore.patterns[i]
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if p.re.MatchString(rel) {
+				return !p.negate
+			}
+		}
+	}
+	return false
+}
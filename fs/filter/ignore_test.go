@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnoreLine(t *testing.T) {
+	for _, test := range []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+		re      string
+	}{
+		{line: ""},
+		{line: "   "},
+		{line: "# comment"},
+		{line: "/"},
+		{line: "*.o", ok: true, re: `^(?:.*/)?[^/]*\.o$`},
+		{line: "*.o  ", ok: true, re: `^(?:.*/)?[^/]*\.o$`},
+		{line: `a\ `, ok: true, re: `^(?:.*/)?a $`},
+		{line: `\#file`, ok: true, re: `^(?:.*/)?#file$`},
+		{line: `\!file`, ok: true, re: `^(?:.*/)?!file$`},
+		{line: "!keep.o", ok: true, negate: true, re: `^(?:.*/)?keep\.o$`},
+		{line: "build/", ok: true, dirOnly: true, re: `^(?:.*/)?build$`},
+		{line: "/build", ok: true, re: `^build$`},
+		{line: "doc/*.txt", ok: true, re: `^doc/[^/]*\.txt$`},
+		{line: "**/logs", ok: true, re: `^(?:.*/)?logs$`},
+		{line: "logs/**", ok: true, re: `^logs/.*$`},
+		{line: "a/**/b", ok: true, re: `^a/(?:.*/)?b$`},
+		{line: "file?.[ch]", ok: true, re: `^(?:.*/)?file[^/]\.[ch]$`},
+		{line: "[!a-c]x", ok: true, re: `^(?:.*/)?[^a-c]x$`},
+		{line: "[]]x", ok: true, re: `^(?:.*/)?[\]]x$`},
+		{line: "[x", ok: true, re: `^(?:.*/)?\[x$`},
+	} {
+		p, ok, err := parseIgnoreLine(test.line, false)
+		require.NoError(t, err, test.line)
+		assert.Equal(t, test.ok, ok, test.line)
+		if !ok {
+			continue
+		}
+		assert.Equal(t, test.negate, p.negate, test.line)
+		assert.Equal(t, test.dirOnly, p.dirOnly, test.line)
+		assert.Equal(t, test.re, p.re.String(), test.line)
+	}
+
+	p, ok, err := parseIgnoreLine("*.O", true)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, p.re.MatchString("dir/file.o"))
+}
+
+func TestIgnoreFilesExcluded(t *testing.T) {
+	ctx := context.Background()
+	f, err := mockfs.NewFs(ctx, "mock", "", nil)
+	require.NoError(t, err)
+	is := newIgnoreFiles([]string{".rcloneignore"}, false)
+	add := func(dir string, lines ...string) {
+		ignore := &ignoreFile{}
+		for _, line := range lines {
+			p, ok, err := parseIgnoreLine(line, false)
+			require.NoError(t, err)
+			require.True(t, ok)
+			ignore.patterns = append(ignore.patterns, p)
+		}
+		is.dirs[ignoreKey(f, dir)] = ignore
+	}
+	add("", "*.o", "!keep.o", "build/", "/top.txt")
+	add("sub", "*.txt", "!*.o", "doc/*.md")
+
+	for _, test := range []struct {
+		remote string
+		isDir  bool
+		want   bool
+	}{
+		{"file.c", false, false},
+		{"file.o", false, true},
+		{"keep.o", false, false},
+		{"deep/down/file.o", false, true},
+		{"build", true, true},
+		{"build", false, false},
+		{"deep/build", true, true},
+		{"top.txt", false, true},
+		{"deep/top.txt", false, false},
+		{"sub/file.txt", false, true},
+		{"sub/deep/file.txt", false, true},
+		{"sub/file.o", false, false},
+		{"sub/doc/file.md", false, true},
+		{"sub/deep/doc/file.md", false, false},
+		{"doc/file.md", false, false},
+		{"other/file.txt", false, false},
+	} {
+		assert.Equal(t, test.want, is.excluded(f, test.remote, test.isDir), test.remote)
+	}
+}
+
+func TestIgnoreFilesLoad(t *testing.T) {
+	ctx := context.Background()
+	fremote, err := mockfs.NewFs(ctx, "mock", "", nil)
+	require.NoError(t, err)
+	opt := Opt
+	opt.IgnoreFile = []string{".rcloneignore"}
+	f, err := NewFilter(&opt)
+	require.NoError(t, err)
+	assert.False(t, f.InActive())
+
+	// No ignore file
+	require.NoError(t, f.LoadIgnoreFiles(ctx, fremote, ""))
+	include, err := f.IncludeDirectory(ctx, fremote)("tmp")
+	require.NoError(t, err)
+	assert.True(t, include)
+
+	// The ignore file is read when the directory is listed
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	o := mockobject.New(".rcloneignore").WithContent([]byte("tmp/\n*.bak\n"), mockobject.SeekModeNone)
+	require.NoError(t, o.SetModTime(ctx, t1))
+	fremote.(*mockfs.Fs).AddObject(o)
+	require.NoError(t, f.LoadIgnoreFiles(ctx, fremote, ""))
+	include, err = f.IncludeDirectory(ctx, fremote)("tmp")
+	require.NoError(t, err)
+	assert.False(t, include)
+	bak := mockobject.New("file.bak").WithContent(nil, mockobject.SeekModeNone)
+	bak.SetFs(fremote)
+	assert.False(t, f.IncludeObject(ctx, bak))
+
+	assert.Contains(t, f.DumpFilters(), "Exclude patterns are read from files called: .rcloneignore")
+}
+
+// newObjectCounter counts the calls to NewObject
+type newObjectCounter struct {
+	fs.Fs
+	newObjects int
+}
+
+// NewObject finds the Object at remote
+func (f *newObjectCounter) NewObject(ctx context.Context, remote string) (fs.Object, error) {
+	f.newObjects++
+	return f.Fs.NewObject(ctx, remote)
+}
+
+func TestIgnoreFilesLoadFromList(t *testing.T) {
+	ctx := context.Background()
+	mock, err := mockfs.NewFs(ctx, "mock", "", nil)
+	require.NoError(t, err)
+	fremote := &newObjectCounter{Fs: mock}
+	opt := Opt
+	opt.IgnoreFile = []string{".rcloneignore"}
+	f, err := NewFilter(&opt)
+	require.NoError(t, err)
+	assert.True(t, f.UsesIgnoreFiles())
+	excluded := func(remote string) bool {
+		return f.ignore.excluded(fremote, remote, false)
+	}
+	ignoreFile := func(contents string, modTime time.Time) fs.Object {
+		o := mockobject.New("dir/.rcloneignore").WithContent([]byte(contents), mockobject.SeekModeNone)
+		require.NoError(t, o.SetModTime(ctx, modTime))
+		return o
+	}
+
+	// The ignore file is taken from the listing and only the
+	// parent directory which hasn't been read is looked up
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	entries := fs.DirEntries{ignoreFile("*.bak\n", t1), mockobject.Object("dir/file.bak")}
+	require.NoError(t, f.LoadIgnoreFilesFromList(ctx, fremote, "dir", entries))
+	assert.Equal(t, 1, fremote.newObjects)
+	assert.True(t, excluded("dir/file.bak"))
+
+	// The cached rules are checked against the listing without
+	// looking anything up or reading the file again
+	entries[0] = ignoreFile("*.txt\n", t1)
+	require.NoError(t, f.LoadIgnoreFilesFromList(ctx, fremote, "dir", entries))
+	assert.Equal(t, 1, fremote.newObjects)
+	assert.True(t, excluded("dir/file.bak"))
+
+	// A changed ignore file is read again
+	entries[0] = ignoreFile("*.txt\n", t1.Add(time.Second))
+	require.NoError(t, f.LoadIgnoreFilesFromList(ctx, fremote, "dir", entries))
+	assert.Equal(t, 1, fremote.newObjects)
+	assert.False(t, excluded("dir/file.bak"))
+	assert.True(t, excluded("dir/file.txt"))
+
+	// and the rules are dropped when it is removed
+	require.NoError(t, f.LoadIgnoreFilesFromList(ctx, fremote, "dir", nil))
+	assert.Equal(t, 1, fremote.newObjects)
+	assert.False(t, excluded("dir/file.txt"))
+}
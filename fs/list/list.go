@@ -22,16 +22,23 @@ import (
 //
 // Files will be returned in sorted order
 func DirSorted(ctx context.Context, f fs.Fs, includeAll bool, dir string) (entries fs.DirEntries, err error) {
+	fi := filter.GetConfig(ctx)
+
 	// Get unfiltered entries from the fs
 	entries, err = f.List(ctx, dir)
 	accounting.Stats(ctx).Listed(int64(len(entries)))
 	if err != nil {
 		return nil, err
 	}
+	if !includeAll {
+		err = fi.LoadIgnoreFilesFromList(ctx, f, dir, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// This should happen only if exclude files lives in the
 	// starting directory, otherwise ListDirSorted should not be
 	// called.
-	fi := filter.GetConfig(ctx)
 	if !includeAll && fi.ListContainsExcludeFile(entries) {
 		fs.Debugf(dir, "Excluded")
 		return nil, nil
@@ -71,8 +78,8 @@ func DirSortedFn(ctx context.Context, f fs.Fs, includeAll bool, dir string, call
 	}
 	defer sorter.CleanUp()
 
-	// Get unfiltered entries from the fs
-	err = listP(ctx, f, dir, func(entries fs.DirEntries) error {
+	// Filter the unfiltered entries from the fs into the sorter
+	add := func(entries fs.DirEntries) error {
 		stats.Listed(int64(len(entries)))
 
 		// This should happen only if exclude files lives in the
@@ -88,7 +95,27 @@ func DirSortedFn(ctx context.Context, f fs.Fs, includeAll bool, dir string, call
 			return err
 		}
 		return sorter.Add(entries)
-	})
+	}
+
+	if !includeAll && fi.UsesIgnoreFiles() {
+		// The ignore files are taken from the listing so all of
+		// it is needed before any of it can be filtered
+		var entries fs.DirEntries
+		err = listP(ctx, f, dir, func(batch fs.DirEntries) error {
+			entries = append(entries, batch...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = fi.LoadIgnoreFilesFromList(ctx, f, dir, entries)
+		if err != nil {
+			return err
+		}
+		err = add(entries)
+	} else {
+		err = listP(ctx, f, dir, add)
+	}
 	if err != nil {
 		return err
 	}
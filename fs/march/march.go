@@ -56,6 +56,15 @@ type Marcher interface {
 	Match(ctx context.Context, dst, src fs.DirEntry) (recurse bool)
 }
 
+// DirMarcher is an optional interface which may be implemented by a
+// Marcher.
+type DirMarcher interface {
+	// MarchDirDone is called when all the entries of a pair of
+	// directories have been passed to SrcOnly, DstOnly or Match. err
+	// is set if either of the listings failed.
+	MarchDirDone(ctx context.Context, srcDir, dstDir string, err error)
+}
+
 // init sets up a march over opt.Fsrc, and opt.Fdst calling back callback for each match
 // Note: this will flag filter-aware backends on the source side
 func (m *March) init(ctx context.Context) {
@@ -119,10 +128,15 @@ func (m *March) dstKey(entry fs.DirEntry) string {
 func (m *March) makeListDir(ctx context.Context, f fs.Fs, includeAll bool, keyFn list.KeyFn) listDirFn {
 	ci := fs.GetConfig(ctx)
 	fi := filter.GetConfig(ctx)
+	listCtx := m.Ctx
+	if f != m.Fsrc {
+		// filter the destination with the ignore files of the source
+		listCtx = filter.SetIgnoreRoot(listCtx, m.Fsrc)
+	}
 	if !(ci.UseListR && f.Features().ListR != nil) && // !--fast-list active and
 		!(ci.NoTraverse && fi.HaveFilesFrom()) { // !(--files-from and --no-traverse)
 		return func(dir string, callback fs.ListRCallback) (err error) {
-			dirCtx := filter.SetUseFilter(m.Ctx, f.Features().FilterAware && !includeAll) // make filter-aware backends constrain List
+			dirCtx := filter.SetUseFilter(listCtx, f.Features().FilterAware && !includeAll) // make filter-aware backends constrain List
 			return list.DirSortedFn(dirCtx, f, includeAll, dir, callback, keyFn)
 		}
 	}
@@ -138,7 +152,7 @@ func (m *March) makeListDir(ctx context.Context, f fs.Fs, includeAll bool, keyFn
 	return func(dir string, callback fs.ListRCallback) (err error) {
 		mu.Lock()
 		if !started {
-			dirCtx := filter.SetUseFilter(m.Ctx, f.Features().FilterAware && !includeAll) // make filter-aware backends constrain List
+			dirCtx := filter.SetUseFilter(listCtx, f.Features().FilterAware && !includeAll) // make filter-aware backends constrain List
 			dirs, dirsErr = walk.NewDirTree(dirCtx, f, m.Dir, includeAll, ci.MaxDepth)
 			started = true
 		}
@@ -376,9 +390,8 @@ func (m *March) matchListings(srcChan, dstChan <-chan fs.DirEntry, srcOnly, dstO
 // more jobs
 //
 // returns errors using processError
-func (m *March) processJob(job listDirJob) ([]listDirJob, error) {
+func (m *March) processJob(job listDirJob) (jobs []listDirJob, err error) {
 	var (
-		jobs                   []listDirJob
 		srcChan                = make(chan fs.DirEntry, 100)
 		dstChan                = make(chan fs.DirEntry, 100)
 		srcListErr, dstListErr error
@@ -493,9 +506,14 @@ func (m *March) processJob(job listDirJob) ([]listDirJob, error) {
 	if !startedDst {
 		close(dstChan)
 	}
+	if dirMarcher, ok := m.Callback.(DirMarcher); ok {
+		defer func() {
+			dirMarcher.MarchDirDone(m.Ctx, job.srcRemote, job.dstRemote, err)
+		}()
+	}
 
 	// Work out what to do and do it
-	err := m.matchListings(srcChan, dstChan, func(src fs.DirEntry) {
+	err = m.matchListings(srcChan, dstChan, func(src fs.DirEntry) {
 		recurse := m.Callback.SrcOnly(src)
 		if recurse && job.srcDepth > 0 {
 			jobs = append(jobs, listDirJob{
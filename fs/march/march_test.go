@@ -6,6 +6,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"path"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -274,6 +276,83 @@ func TestMarch(t *testing.T) {
 	}
 }
 
+// dirMarchTester is a marchTester which implements DirMarcher
+type dirMarchTester struct {
+	marchTester
+	dirs []string // directories passed to MarchDirDone
+	late int      // number of entries seen after MarchDirDone for their directory
+}
+
+// checkLate counts entry as late if its directory is already done
+func (mt *dirMarchTester) checkLate(entry fs.DirEntry) {
+	mt.entryMutex.Lock()
+	defer mt.entryMutex.Unlock()
+	dir := path.Dir(entry.Remote())
+	if dir == "." {
+		dir = ""
+	}
+	if slices.Contains(mt.dirs, dir+"|"+dir) {
+		mt.late++
+	}
+}
+
+func (mt *dirMarchTester) SrcOnly(src fs.DirEntry) (recurse bool) {
+	mt.checkLate(src)
+	return mt.marchTester.SrcOnly(src)
+}
+
+func (mt *dirMarchTester) DstOnly(dst fs.DirEntry) (recurse bool) {
+	mt.checkLate(dst)
+	return mt.marchTester.DstOnly(dst)
+}
+
+func (mt *dirMarchTester) Match(ctx context.Context, dst, src fs.DirEntry) (recurse bool) {
+	mt.checkLate(src)
+	return mt.marchTester.Match(ctx, dst, src)
+}
+
+// MarchDirDone is called when each pair of directories is finished
+func (mt *dirMarchTester) MarchDirDone(ctx context.Context, srcDir, dstDir string, err error) {
+	mt.processError(err)
+	mt.entryMutex.Lock()
+	defer mt.entryMutex.Unlock()
+	mt.dirs = append(mt.dirs, srcDir+"|"+dstDir)
+}
+
+func TestMarchDirDone(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.WriteFile("srcOnly", "hello world", t1)
+	r.WriteFile("srcOnlyDir/sub", "hello world", t1)
+	r.WriteBoth(ctx, "match", "hello world", t1)
+	r.WriteBoth(ctx, "matchDir/match file", "hello world", t1)
+	r.WriteObject(ctx, "dstOnly", "hello world", t1)
+	r.WriteObject(ctx, "dstOnlyDir/sub", "hello world", t1)
+
+	mt := &dirMarchTester{
+		marchTester: marchTester{
+			ctx:    ctx,
+			cancel: cancel,
+		},
+	}
+	m := &March{
+		Ctx:      ctx,
+		Fdst:     r.Fremote,
+		Fsrc:     r.Flocal,
+		Callback: mt,
+	}
+	mt.processError(m.Run(ctx))
+	require.NoError(t, mt.currentError())
+
+	slices.Sort(mt.dirs)
+	assert.Equal(t, []string{"dstOnlyDir|dstOnlyDir", "matchDir|matchDir", "srcOnlyDir|srcOnlyDir", "|"}, mt.dirs)
+	assert.Equal(t, 0, mt.late)
+	assert.Len(t, mt.srcOnly, 3)
+	assert.Len(t, mt.dstOnly, 3)
+	assert.Len(t, mt.match, 3)
+}
+
 // matchPair is a matched pair of direntries returned by matchListings
 type matchPair struct {
 	src, dst fs.DirEntry
@@ -105,22 +105,74 @@ func testListDirSorted(t *testing.T, listFn func(ctx context.Context, f fs.Fs, i
 	assert.Equal(t, "sub dir/ignore dir/should be ignored", str(1))
 }
 
+// testListDirSortedIgnoreFile checks the --ignore-file rules are read
+// from each directory as it is listed
+func testListDirSortedIgnoreFile(t *testing.T, listFn func(ctx context.Context, f fs.Fs, includeAll bool, dir string) (entries fs.DirEntries, err error)) {
+	r := fstest.NewRun(t)
+
+	opt := filter.Opt
+	opt.IgnoreFile = []string{".rcloneignore"}
+	fi, err := filter.NewFilter(&opt)
+	require.NoError(t, err)
+	ctx := filter.ReplaceConfig(context.Background(), fi)
+
+	files := []fstest.Item{
+		r.WriteObject(ctx, ".rcloneignore", "*.o\nbuild/\n", t1),
+		r.WriteObject(ctx, "a.c", "a", t1),
+		r.WriteObject(ctx, "a.o", "a", t1),
+		r.WriteObject(ctx, "build/a.o", "a", t1),
+		r.WriteObject(ctx, "src/.rcloneignore", "!keep.o\n/*.c\n", t1),
+		r.WriteObject(ctx, "src/b.c", "b", t1),
+		r.WriteObject(ctx, "src/b.o", "b", t1),
+		r.WriteObject(ctx, "src/keep.o", "k", t1),
+		r.WriteObject(ctx, "src/sub/c.c", "c", t1),
+	}
+	r.CheckRemoteItems(t, files...)
+
+	names := func(dir string) (names []string) {
+		items, err := listFn(ctx, r.Fremote, false, dir)
+		require.NoError(t, err)
+		for _, item := range items {
+			name := item.Remote()
+			if _, ok := item.(fs.Directory); ok {
+				name += "/"
+			}
+			names = append(names, name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{".rcloneignore", "a.c", "src/"}, names(""))
+	assert.Equal(t, []string{"src/.rcloneignore", "src/keep.o", "src/sub/"}, names("src"))
+	assert.Equal(t, []string{"src/sub/c.c"}, names("src/sub"))
+}
+
 // TestListDirSorted is integration testing code in fs/list/list.go
 // which can't be tested there due to import loops.
 func TestListDirSorted(t *testing.T) {
 	testListDirSorted(t, list.DirSorted)
 }
 
+// listDirSortedFn calls list.DirSortedFn returning all the entries
+func listDirSortedFn(ctx context.Context, f fs.Fs, includeAll bool, dir string) (entries fs.DirEntries, err error) {
+	callback := func(newEntries fs.DirEntries) error {
+		entries = append(entries, newEntries...)
+		return nil
+	}
+	err = list.DirSortedFn(ctx, f, includeAll, dir, callback, nil)
+	return entries, err
+}
+
 // TestListDirSortedFn is integration testing code in fs/list/list.go
 // which can't be tested there due to import loops.
 func TestListDirSortedFn(t *testing.T) {
-	listFn := func(ctx context.Context, f fs.Fs, includeAll bool, dir string) (entries fs.DirEntries, err error) {
-		callback := func(newEntries fs.DirEntries) error {
-			entries = append(entries, newEntries...)
-			return nil
-		}
-		err = list.DirSortedFn(ctx, f, includeAll, dir, callback, nil)
-		return entries, err
-	}
-	testListDirSorted(t, listFn)
+	testListDirSorted(t, listDirSortedFn)
+}
+
+func TestListDirSortedIgnoreFile(t *testing.T) {
+	testListDirSortedIgnoreFile(t, list.DirSorted)
+}
+
+func TestListDirSortedFnIgnoreFile(t *testing.T) {
+	testListDirSortedIgnoreFile(t, listDirSortedFn)
 }
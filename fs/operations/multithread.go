@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/rclone/rclone/fs"
@@ -60,7 +62,8 @@ type multiThreadCopyState struct {
 	src         fs.Object
 	acc         *accounting.Account
 	numChunks   int
-	noBuffering bool // set to read the input without buffering
+	noBuffering bool               // set to read the input without buffering
+	resume      *multiThreadResume // if set, record the chunks uploaded here
 }
 
 // Copy a single chunk into place
@@ -77,6 +80,12 @@ func (mc *multiThreadCopyState) copyChunk(ctx context.Context, chunk int, writer
 	end := min(start+mc.partSize, mc.size)
 	size := end - start
 
+	if mc.resume != nil && mc.resume.isDone(chunk) {
+		fs.Debugf(mc.src, "multi-thread copy: chunk %d/%d (%d-%d) size %v already uploaded", chunk+1, mc.numChunks, start, end, fs.SizeSuffix(size))
+		mc.acc.Skip(size)
+		return nil
+	}
+
 	// Reserve the memory first so we don't open the source and wait for memory buffers for ages
 	var rw *pool.RW
 	if !mc.noBuffering {
@@ -114,6 +123,9 @@ func (mc *multiThreadCopyState) copyChunk(ctx context.Context, chunk int, writer
 	if err != nil {
 		return fmt.Errorf("multi-thread copy: failed to write chunk: %w", err)
 	}
+	if mc.resume != nil {
+		mc.resume.chunkDone(chunk)
+	}
 
 	fs.Debugf(mc.src, "multi-thread copy: chunk %d/%d (%d-%d) size %v finished", chunk+1, mc.numChunks, start, end, fs.SizeSuffix(bytesWritten))
 	return nil
@@ -164,7 +176,16 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		return nil, fmt.Errorf("multi-thread copy: can't copy zero sized file")
 	}
 
-	info, chunkWriter, err := openChunkWriter(ctx, remote, src, options...)
+	var (
+		info        fs.ChunkWriterInfo
+		chunkWriter fs.ChunkWriter
+		resume      *multiThreadResume
+	)
+	if ci.MultiThreadResume && !usingOpenWriterAt {
+		info, chunkWriter, resume, err = openResumableChunkWriter(ctx, f, openChunkWriter, remote, src, options...)
+	} else {
+		info, chunkWriter, err = openChunkWriter(ctx, remote, src, options...)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("multi-thread copy: failed to open chunk writer: %w", err)
 	}
@@ -174,7 +195,20 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	uploadedOK := false
 	defer atexit.OnError(&err, func() {
 		cancel()
-		if info.LeavePartsOnError || uploadedOK {
+		if uploadedOK {
+			return
+		}
+		if resume != nil {
+			resume.mu.Lock()
+			saveErr := resume.save()
+			resume.mu.Unlock()
+			if saveErr == nil {
+				fs.Infof(src, "multi-thread copy: saved upload state so it can be resumed")
+				return
+			}
+			fs.Errorf(src, "multi-thread copy: %v", saveErr)
+		}
+		if info.LeavePartsOnError {
 			return
 		}
 		fs.Debugf(src, "multi-thread copy: cancelling transfer on exit")
@@ -215,6 +249,7 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		partSize:    info.ChunkSize,
 		numChunks:   numChunks,
 		noBuffering: noBuffering,
+		resume:      resume,
 	}
 
 	// Make accounting
@@ -241,6 +276,9 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 		return nil, fmt.Errorf("multi-thread copy: failed to close object after copy: %w", err)
 	}
 	uploadedOK = true // file is definitely uploaded OK so no need to abort
+	if resume != nil {
+		resume.remove()
+	}
 
 	obj, err := f.NewObject(ctx, remote)
 	if err != nil {
@@ -283,6 +321,136 @@ func multiThreadCopy(ctx context.Context, f fs.Fs, remote string, src fs.Object,
 	return obj, nil
 }
 
+// multiThreadResume saves the progress of a multi-thread copy so it
+// can be resumed if it is interrupted
+type multiThreadResume struct {
+	mu       sync.Mutex
+	src      fs.Object
+	rs       *multipart.ResumeState
+	stater   fs.ChunkWriterStater
+	done     map[int]struct{} // chunks uploaded so far
+	lastSave time.Time
+}
+
+// isDone returns true if chunk has been uploaded already
+func (r *multiThreadResume) isDone(chunk int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, found := r.done[chunk]
+	return found
+}
+
+// chunkDone records that chunk has been uploaded, saving the state
+// at most once a second
+func (r *multiThreadResume) chunkDone(chunk int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.done[chunk] = struct{}{}
+	if time.Since(r.lastSave) < time.Second {
+		return
+	}
+	err := r.save()
+	if err != nil {
+		fs.Debugf(r.src, "multi-thread copy: %v", err)
+	}
+}
+
+// save the state to disk - call with mu held
+func (r *multiThreadResume) save() error {
+	uploadID, state, err := r.stater.ChunkWriterState()
+	if err != nil {
+		return fmt.Errorf("failed to read upload state: %w", err)
+	}
+	r.rs.UploadID = uploadID
+	r.rs.State = state
+	r.rs.Chunks = r.rs.Chunks[:0]
+	for chunk := range r.done {
+		r.rs.Chunks = append(r.rs.Chunks, chunk)
+	}
+	slices.Sort(r.rs.Chunks)
+	r.lastSave = time.Now()
+	return r.rs.Save()
+}
+
+// remove the saved state
+func (r *multiThreadResume) remove() {
+	err := r.rs.Remove()
+	if err != nil {
+		fs.Debugf(r.src, "multi-thread copy: %v", err)
+	}
+}
+
+// openResumableChunkWriter opens a chunk writer for src which saves
+// its state so it can be resumed, resuming the previous upload of
+// src if there is one.
+//
+// If the backend doesn't support resuming then resume will be nil.
+func openResumableChunkWriter(ctx context.Context, f fs.Fs, openChunkWriter fs.OpenChunkWriterFn, remote string, src fs.Object, options ...fs.OpenOption) (info fs.ChunkWriterInfo, chunkWriter fs.ChunkWriter, resume *multiThreadResume, err error) {
+	resumeChunkWriter := f.Features().ResumeChunkWriter
+	if resumeChunkWriter == nil {
+		info, chunkWriter, err = openChunkWriter(ctx, remote, src, options...)
+		return info, chunkWriter, nil, err
+	}
+	fingerprint := fs.Fingerprint(ctx, src, true)
+
+	// Try to resume a previous upload
+	rs, err := multipart.LoadResumeState(f, remote)
+	if err != nil {
+		fs.Debugf(src, "multi-thread copy: can't resume: %v", err)
+	} else if rs != nil {
+		info, chunkWriter, err = resumeChunkWriter(ctx, remote, src, rs.State, options...)
+		if err != nil {
+			fs.Debugf(src, "multi-thread copy: can't resume upload %q: %v", rs.UploadID, err)
+		} else if rs.Src != fingerprint || info.ChunkSize != rs.ChunkSize {
+			fs.Debugf(src, "multi-thread copy: source has changed so not resuming upload %q", rs.UploadID)
+			err = chunkWriter.Abort(ctx)
+			if err != nil {
+				fs.Debugf(src, "multi-thread copy: failed to abort old upload: %v", err)
+			}
+		} else if stater, ok := chunkWriter.(fs.ChunkWriterStater); ok {
+			resume = &multiThreadResume{
+				src:    src,
+				rs:     rs,
+				stater: stater,
+				done:   make(map[int]struct{}, len(rs.Chunks)),
+			}
+			for _, chunk := range rs.Chunks {
+				resume.done[chunk] = struct{}{}
+			}
+			fs.Infof(src, "multi-thread copy: resuming upload %q with %d chunks already uploaded", rs.UploadID, len(rs.Chunks))
+			return info, chunkWriter, resume, nil
+		}
+		if err := rs.Remove(); err != nil {
+			fs.Debugf(src, "multi-thread copy: %v", err)
+		}
+	}
+
+	// Otherwise start a new one
+	info, chunkWriter, err = openChunkWriter(ctx, remote, src, options...)
+	if err != nil {
+		return info, nil, nil, err
+	}
+	stater, ok := chunkWriter.(fs.ChunkWriterStater)
+	if !ok {
+		return info, chunkWriter, nil, nil
+	}
+	rs = multipart.NewResumeState(f, remote)
+	rs.Src = fingerprint
+	rs.ChunkSize = info.ChunkSize
+	resume = &multiThreadResume{
+		src:    src,
+		rs:     rs,
+		stater: stater,
+		done:   make(map[int]struct{}),
+	}
+	err = resume.save()
+	if err != nil {
+		fs.Debugf(src, "multi-thread copy: not saving upload state: %v", err)
+		return info, chunkWriter, nil, nil
+	}
+	return info, chunkWriter, resume, nil
+}
+
 // writerAtChunkWriter converts a WriterAtCloser into a ChunkWriter
 type writerAtChunkWriter struct {
 	remote          string
@@ -1,6 +1,7 @@
 package operations
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -10,10 +11,12 @@ import (
 	"time"
 
 	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/config"
 	"github.com/rclone/rclone/fs/hash"
 	"github.com/rclone/rclone/fs/object"
 	"github.com/rclone/rclone/fstest/mockfs"
 	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/rclone/rclone/lib/multipart"
 	"github.com/rclone/rclone/lib/random"
 
 	"github.com/rclone/rclone/fs"
@@ -331,3 +334,171 @@ func TestMultithreadCopyAbort(t *testing.T) {
 		require.NoError(t, o.Remove(ctx))
 	}
 }
+
+// resumeFs wraps an Fs adding resumable chunked uploads which are
+// assembled in memory
+type resumeFs struct {
+	fs.Fs
+	mu        sync.Mutex
+	uploads   map[string]*resumeChunkWriter
+	nextID    int
+	failChunk int   // return an error writing this chunk
+	written   []int // chunks written
+	features  *fs.Features
+}
+
+// Features returns the optional features of this Fs
+func (f *resumeFs) Features() *fs.Features {
+	return f.features
+}
+
+// OpenChunkWriter starts a new upload
+func (f *resumeFs) OpenChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	w := &resumeChunkWriter{
+		f:      f,
+		id:     fmt.Sprintf("upload-%d", f.nextID),
+		remote: remote,
+		src:    src,
+		chunks: map[int][]byte{},
+	}
+	f.uploads[w.id] = w
+	return fs.ChunkWriterInfo{ChunkSize: 1024, Concurrency: 1}, w, nil
+}
+
+// ResumeChunkWriter re-opens an upload from its state
+func (f *resumeFs) ResumeChunkWriter(ctx context.Context, remote string, src fs.ObjectInfo, state []byte, options ...fs.OpenOption) (info fs.ChunkWriterInfo, writer fs.ChunkWriter, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w, ok := f.uploads[string(state)]
+	if !ok {
+		return info, nil, errors.New("upload not found")
+	}
+	w.src = src
+	return fs.ChunkWriterInfo{ChunkSize: 1024, Concurrency: 1}, w, nil
+}
+
+type resumeChunkWriter struct {
+	f      *resumeFs
+	id     string
+	remote string
+	src    fs.ObjectInfo
+	chunks map[int][]byte
+}
+
+func (w *resumeChunkWriter) WriteChunk(ctx context.Context, chunkNumber int, reader io.ReadSeeker) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+	if chunkNumber == w.f.failChunk {
+		return -1, errors.New("BOOM: simulated write failure")
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return -1, err
+	}
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	w.chunks[chunkNumber] = data
+	w.f.written = append(w.f.written, chunkNumber)
+	return int64(len(data)), nil
+}
+
+func (w *resumeChunkWriter) Close(ctx context.Context) error {
+	w.f.mu.Lock()
+	var data []byte
+	for i := range len(w.chunks) {
+		data = append(data, w.chunks[i]...)
+	}
+	delete(w.f.uploads, w.id)
+	w.f.mu.Unlock()
+	src := object.NewStaticObjectInfo(w.remote, w.src.ModTime(ctx), int64(len(data)), true, nil, nil)
+	_, err := w.f.Fs.Put(ctx, bytes.NewReader(data), src)
+	return err
+}
+
+func (w *resumeChunkWriter) Abort(ctx context.Context) error {
+	w.f.mu.Lock()
+	defer w.f.mu.Unlock()
+	delete(w.f.uploads, w.id)
+	return nil
+}
+
+func (w *resumeChunkWriter) ChunkWriterState() (uploadID string, state []byte, err error) {
+	return w.id, []byte(w.id), nil
+}
+
+func TestMultithreadCopyResume(t *testing.T) {
+	r := fstest.NewRun(t)
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	ci.MultiThreadResume = true
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	t.Cleanup(func() { _ = config.SetCacheDir(oldCacheDir) })
+
+	f := &resumeFs{
+		Fs:        r.Fremote,
+		uploads:   map[string]*resumeChunkWriter{},
+		failChunk: 2,
+	}
+	f.features = (&fs.Features{}).Fill(ctx, f)
+	require.NotNil(t, f.features.ResumeChunkWriter)
+
+	const fileName = "test-multithread-resume"
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	file1 := r.WriteFile(fileName, random.String(4*1024-1), t1)
+	src, err := r.Flocal.NewObject(ctx, fileName)
+	require.NoError(t, err)
+
+	doCopy := func() (fs.Object, error) {
+		tr := accounting.GlobalStats().NewTransfer(src, nil)
+		dst, err := multiThreadCopy(ctx, f, fileName, src, 1, tr)
+		tr.Done(ctx, err)
+		return dst, err
+	}
+
+	// The first copy fails part way through and leaves the upload
+	_, err = doCopy()
+	require.Error(t, err)
+	assert.Equal(t, []int{0, 1}, f.written)
+	assert.Len(t, f.uploads, 1)
+	rs, err := multipart.LoadResumeState(f, fileName)
+	require.NoError(t, err)
+	require.NotNil(t, rs)
+	assert.Equal(t, []int{0, 1}, rs.Chunks)
+	assert.Equal(t, "upload-1", rs.UploadID)
+
+	// The second copy resumes the upload, only writing and
+	// accounting the rest
+	f.written = nil
+	f.failChunk = -1
+	accounting.GlobalStats().ResetCounters()
+	dst, err := doCopy()
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3}, f.written)
+	assert.Equal(t, src.Size()-2*1024, accounting.GlobalStats().GetBytes())
+	assert.Len(t, f.uploads, 0)
+	assert.Equal(t, src.Size(), dst.Size())
+	r.CheckRemoteItems(t, file1)
+	rs, err = multipart.LoadResumeState(f, fileName)
+	require.NoError(t, err)
+	assert.Nil(t, rs)
+
+	// If the source changes the old upload is abandoned
+	f.failChunk = 2
+	_, err = doCopy()
+	require.Error(t, err)
+	file1 = r.WriteFile(fileName, random.String(4*1024-1), fstest.Time("2011-12-25T12:59:59.123456789Z"))
+	src, err = r.Flocal.NewObject(ctx, fileName)
+	require.NoError(t, err)
+	f.written = nil
+	f.failChunk = -1
+	_, err = doCopy()
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2, 3}, f.written)
+	assert.Len(t, f.uploads, 0)
+	r.CheckRemoteItems(t, file1)
+}
@@ -0,0 +1,93 @@
+package operations
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// ReaderAt implements io.ReaderAt on an fs.Object using range
+// requests.
+//
+// It reads in blocks of blockSize and keeps the last block read so
+// that many small reads near each other, as made by parsers of
+// archive formats, don't cause a request each.
+type ReaderAt struct {
+	ctx       context.Context
+	o         fs.Object
+	size      int64
+	blockSize int64
+	mu        sync.Mutex
+	block     []byte // the data in the last block read
+	start     int64  // offset of block in the object or -1 if no block
+}
+
+// NewReaderAt makes an io.ReaderAt reading o in blocks of blockSize
+func NewReaderAt(ctx context.Context, o fs.Object, blockSize int64) *ReaderAt {
+	return &ReaderAt{
+		ctx:       ctx,
+		o:         o,
+		size:      o.Size(),
+		blockSize: blockSize,
+		start:     -1,
+	}
+}
+
+// fill reads the block containing off
+func (r *ReaderAt) fill(off int64) (err error) {
+	start := off - off%r.blockSize
+	end := min(start+r.blockSize, r.size)
+	rc, err := r.o.Open(r.ctx, &fs.RangeOption{Start: start, End: end - 1})
+	if err != nil {
+		return err
+	}
+	defer fs.CheckClose(rc, &err)
+	if cap(r.block) < int(end-start) {
+		r.block = make([]byte, end-start)
+	}
+	r.block = r.block[:end-start]
+	r.start = -1
+	_, err = io.ReadFull(rc, r.block)
+	if err != nil {
+		return err
+	}
+	r.start = start
+	return nil
+}
+
+// ReadAt reads len(p) bytes at off
+func (r *ReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("ReadAt: negative offset")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n < len(p) && off < r.size {
+		if r.start < 0 || off < r.start || off >= r.start+int64(len(r.block)) {
+			err = r.fill(off)
+			if err != nil {
+				return n, err
+			}
+		}
+		// copy is shadowed by the copy type in this package
+		copied, _ := bytes.NewReader(r.block).ReadAt(p[n:], off-r.start)
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size returns the size of the underlying object
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// check interfaces
+var _ io.ReaderAt = (*ReaderAt)(nil)
@@ -0,0 +1,47 @@
+package operations
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderAt(t *testing.T) {
+	ctx := context.Background()
+	const blockSize = 100
+	data := make([]byte, 3*blockSize+23)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	o := mockobject.New("data.bin").WithContent(data, mockobject.SeekModeNone)
+	ra := NewReaderAt(ctx, o, blockSize)
+	assert.Equal(t, int64(len(data)), ra.Size())
+
+	for _, test := range []struct {
+		off  int64
+		size int
+	}{
+		{0, 10},
+		{blockSize - 5, 10},
+		{blockSize * 2, blockSize + 20},
+		{int64(len(data)) - 10, 10},
+		{int64(len(data)) - 10, 20},
+		{int64(len(data)), 10},
+	} {
+		buf := make([]byte, test.size)
+		n, err := ra.ReadAt(buf, test.off)
+		end := min(test.off+int64(test.size), int64(len(data)))
+		if end-test.off < int64(test.size) {
+			assert.Equal(t, io.EOF, err)
+		} else {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, data[test.off:end], buf[:n])
+	}
+
+	_, err := ra.ReadAt(make([]byte, 1), -1)
+	assert.Error(t, err)
+}
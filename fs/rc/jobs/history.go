@@ -0,0 +1,551 @@
+package jobs
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/fspath"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/kv"
+)
+
+// historyFacility is the name of the key-value database holding the history
+const historyFacility = "rcjobs"
+
+// errInterrupted is recorded for jobs which were running when rclone stopped
+const errInterrupted = "job interrupted: rclone stopped while it was running"
+
+// historyEntry is the record of a job kept in the history
+type historyEntry struct {
+	ID          int64     `json:"id"`
+	ExecuteID   string    `json:"executeId"`
+	Path        string    `json:"path"`
+	Params      rc.Params `json:"params,omitempty"`
+	Group       string    `json:"group"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	Error       string    `json:"error"`
+	Finished    bool      `json:"finished"`
+	Success     bool      `json:"success"`
+	Duration    float64   `json:"duration"`
+	Stats       rc.Params `json:"stats,omitempty"`
+	Interrupted bool      `json:"interrupted"`
+	RestartedAs int64     `json:"restartedAs,omitempty"`
+	Redacted    bool      `json:"redacted,omitempty"`
+}
+
+// historyKey returns the database key for the job with id.
+//
+// Keys are big endian so the records are stored in job ID order.
+func historyKey(id int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// history stores the records of jobs in a key-value database so they
+// survive a restart of rclone
+type history struct {
+	db *kv.DB
+}
+
+// redactedValue replaces the values of sensitive parameters in the
+// history
+const redactedValue = "XXX"
+
+// sensitiveKeys are the names of the backend options which are
+// redacted by "rclone config redacted"
+var sensitiveKeys = sync.OnceValue(func() map[string]struct{} {
+	keys := make(map[string]struct{})
+	for _, info := range fs.Registry {
+		for _, option := range info.Options {
+			if option.IsPassword || option.Sensitive {
+				keys[option.Name] = struct{}{}
+			}
+		}
+	}
+	return keys
+})
+
+// isSensitive returns true if the parameter called key should be
+// redacted
+func isSensitive(key string) bool {
+	_, found := sensitiveKeys()[strings.ToLower(key)]
+	return found
+}
+
+// redactString redacts the sensitive parameters in a connection
+// string such as ":s3,secret_access_key=XXX:bucket", returning the
+// new string and whether anything was redacted
+func redactString(in string) (string, bool) {
+	if !strings.Contains(in, "=") {
+		return in, false
+	}
+	parsed, err := fspath.Parse(in)
+	if err != nil || len(parsed.Config) == 0 {
+		return in, false
+	}
+	redacted := false
+	for k := range parsed.Config {
+		if isSensitive(k) {
+			parsed.Config[k] = redactedValue
+			redacted = true
+		}
+	}
+	if !redacted {
+		return in, false
+	}
+	return parsed.Name + "," + parsed.Config.String() + ":" + parsed.Path, true
+}
+
+// redact returns a copy of v with the values of any sensitive
+// parameters in it replaced, and whether anything was replaced
+func redact(v any) (any, bool) {
+	switch x := v.(type) {
+	case string:
+		return redactString(x)
+	case map[string]any:
+		out := make(map[string]any, len(x))
+		redacted := false
+		for k, item := range x {
+			if isSensitive(k) {
+				out[k] = redactedValue
+				redacted = true
+				continue
+			}
+			var itemRedacted bool
+			out[k], itemRedacted = redact(item)
+			redacted = redacted || itemRedacted
+		}
+		return out, redacted
+	case rc.Params:
+		out, redacted := redact(map[string]any(x))
+		return rc.Params(out.(map[string]any)), redacted
+	case []any:
+		out := make([]any, len(x))
+		redacted := false
+		for i, item := range x {
+			var itemRedacted bool
+			out[i], itemRedacted = redact(item)
+			redacted = redacted || itemRedacted
+		}
+		return out, redacted
+	}
+	return v, false
+}
+
+// historyParams returns the parameters of a job which can be stored
+// in the history or nil if they can't be, and whether any sensitive
+// values were redacted from them.
+//
+// The HTTP request and response passed to some calls are left out.
+// The values of parameters which "rclone config redacted" would hide,
+// such as passwords and secret keys, are replaced with XXX.
+func historyParams(in rc.Params) (rc.Params, bool) {
+	params := make(rc.Params, len(in))
+	for k, v := range in {
+		if k == "_request" || k == "_response" {
+			continue
+		}
+		params[k] = v
+	}
+	if _, err := json.Marshal(params); err != nil {
+		return nil, false
+	}
+	out, redacted := redact(params)
+	return out.(rc.Params), redacted
+}
+
+// kvHistoryPut stores a history entry
+type kvHistoryPut struct {
+	entry *historyEntry
+}
+
+func (op *kvHistoryPut) Do(ctx context.Context, b kv.Bucket) error {
+	data, err := json.Marshal(op.entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(historyKey(op.entry.ID), data)
+}
+
+// kvHistoryGet reads a single history entry
+type kvHistoryGet struct {
+	id    int64
+	entry *historyEntry
+}
+
+func (op *kvHistoryGet) Do(ctx context.Context, b kv.Bucket) error {
+	data := b.Get(historyKey(op.id))
+	if data == nil {
+		return nil
+	}
+	op.entry = new(historyEntry)
+	return json.Unmarshal(data, op.entry)
+}
+
+// kvHistoryList reads the history entries newest first, starting
+// with the newest entry with an ID less than before if it is set, and
+// stopping when fn returns false
+type kvHistoryList struct {
+	before int64
+	fn     func(entry *historyEntry) bool
+}
+
+func (op *kvHistoryList) Do(ctx context.Context, b kv.Bucket) error {
+	cur := b.Cursor()
+	var k, v []byte
+	if op.before > 0 {
+		// Seek finds the first key >= before
+		k, v = cur.Seek(historyKey(op.before))
+		if k == nil {
+			k, v = cur.Last()
+		} else {
+			k, v = cur.Prev()
+		}
+	} else {
+		k, v = cur.Last()
+	}
+	for ; k != nil; k, v = cur.Prev() {
+		entry := new(historyEntry)
+		if err := json.Unmarshal(v, entry); err != nil {
+			fs.Debugf(nil, "rc: skipping invalid job history record: %v", err)
+			continue
+		}
+		if !op.fn(entry) {
+			break
+		}
+	}
+	return nil
+}
+
+// kvHistoryStart prunes old entries from the history and marks the
+// jobs which were running when rclone stopped as interrupted, returning
+// them and the largest job ID in the history.
+type kvHistoryStart struct {
+	maxAge      time.Duration
+	maxID       int64
+	interrupted []*historyEntry
+}
+
+func (op *kvHistoryStart) Do(ctx context.Context, b kv.Bucket) error {
+	var expired [][]byte
+	var changed []*historyEntry
+	cur := b.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		entry := new(historyEntry)
+		if err := json.Unmarshal(v, entry); err != nil {
+			fs.Debugf(nil, "rc: removing invalid job history record: %v", err)
+			expired = append(expired, k)
+			continue
+		}
+		op.maxID = max(op.maxID, entry.ID)
+		if entry.Finished {
+			if op.maxAge > 0 && time.Since(entry.EndTime) > op.maxAge {
+				expired = append(expired, k)
+			}
+			continue
+		}
+		if entry.ExecuteID == executeID {
+			continue
+		}
+		entry.Finished = true
+		entry.Interrupted = true
+		entry.Error = errInterrupted
+		entry.EndTime = time.Now()
+		changed = append(changed, entry)
+	}
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	for _, entry := range changed {
+		if err := (&kvHistoryPut{entry: entry}).Do(ctx, b); err != nil {
+			return err
+		}
+	}
+	op.interrupted = changed
+	return nil
+}
+
+// ignoreEmpty ignores the error returned when reading an empty database
+func ignoreEmpty(err error) error {
+	if errors.Is(err, kv.ErrEmpty) {
+		return nil
+	}
+	return err
+}
+
+// record stores the entry for job in the history
+func (h *history) record(job *Job) {
+	job.mu.Lock()
+	entry := &historyEntry{
+		ID:        job.ID,
+		ExecuteID: executeID,
+		Path:      job.path,
+		Params:    job.params,
+		Redacted:  job.redacted,
+		Group:     job.Group,
+		StartTime: job.StartTime,
+		EndTime:   job.EndTime,
+		Error:     job.Error,
+		Finished:  job.Finished,
+		Success:   job.Success,
+		Duration:  job.Duration,
+		Stats:     job.stats,
+	}
+	job.mu.Unlock()
+	if err := h.db.Do(true, &kvHistoryPut{entry: entry}); err != nil {
+		fs.Errorf(nil, "rc: failed to record job %d in history: %v", entry.ID, err)
+	}
+}
+
+// finish records the final state and stats of job in the history
+func (h *history) finish(ctx context.Context, job *Job) {
+	stats, err := accounting.Stats(ctx).RemoteStats(true)
+	if err != nil {
+		fs.Debugf(nil, "rc: failed to read stats for job %d: %v", job.ID, err)
+	}
+	job.mu.Lock()
+	job.stats = stats
+	job.mu.Unlock()
+	h.record(job)
+}
+
+// get reads the entry for the job with id from the history or returns nil
+func (h *history) get(id int64) (*historyEntry, error) {
+	op := &kvHistoryGet{id: id}
+	if err := ignoreEmpty(h.db.Do(false, op)); err != nil {
+		return nil, err
+	}
+	return op.entry, nil
+}
+
+// list calls fn for each entry in the history with an ID less than
+// before (or all entries if it is 0) newest first until fn returns
+// false
+func (h *history) list(before int64, fn func(entry *historyEntry) bool) error {
+	return ignoreEmpty(h.db.Do(false, &kvHistoryList{before: before, fn: fn}))
+}
+
+// setRestarted records that the job with id was restarted as newID
+func (h *history) setRestarted(id, newID int64) error {
+	entry, err := h.get(id)
+	if err != nil || entry == nil {
+		return err
+	}
+	entry.RestartedAs = newID
+	return h.db.Do(true, &kvHistoryPut{entry: entry})
+}
+
+// StartHistory opens the on-disk job history.
+//
+// Old entries are pruned and jobs which were running when rclone last
+// stopped are marked as interrupted. If opt.JobRestart is set then the
+// interrupted jobs are started again as new async jobs.
+//
+// This should be called before any jobs are started.
+func StartHistory(ctx context.Context, opt *rc.Options) error {
+	return running.startHistory(ctx, opt)
+}
+
+func (jobs *Jobs) startHistory(ctx context.Context, opt *rc.Options) error {
+	jobs.mu.Lock()
+	if jobs.history != nil {
+		jobs.mu.Unlock()
+		return nil
+	}
+	db, err := kv.Start(ctx, historyFacility, nil)
+	if err != nil {
+		jobs.mu.Unlock()
+		return fmt.Errorf("failed to open job history: %w", err)
+	}
+	h := &history{db: db}
+	op := &kvHistoryStart{maxAge: time.Duration(opt.JobHistoryMaxAge)}
+	if err = ignoreEmpty(db.Do(true, op)); err != nil {
+		jobs.mu.Unlock()
+		_ = db.Stop(false)
+		return fmt.Errorf("failed to read job history: %w", err)
+	}
+	jobs.history = h
+	jobs.mu.Unlock()
+
+	// Carry on numbering jobs after the ones in the history so
+	// the IDs stay unique
+	for {
+		id := jobID.Load()
+		if id >= op.maxID || jobID.CompareAndSwap(id, op.maxID) {
+			break
+		}
+	}
+
+	for _, entry := range op.interrupted {
+		fs.Logf(nil, "rc: job %d (%s) was interrupted by rclone stopping", entry.ID, entry.Path)
+		if opt.JobRestart {
+			jobs.restart(ctx, h, entry)
+		}
+	}
+	return nil
+}
+
+// restart starts the interrupted job in entry again
+func (jobs *Jobs) restart(ctx context.Context, h *history, entry *historyEntry) {
+	call := rc.Calls.Get(entry.Path)
+	if call == nil || entry.Params == nil || call.NeedsRequest || call.NeedsResponse {
+		fs.Logf(nil, "rc: can't restart job %d (%s)", entry.ID, entry.Path)
+		return
+	}
+	if entry.Redacted {
+		fs.Logf(nil, "rc: can't restart job %d (%s) as secrets were removed from its parameters", entry.ID, entry.Path)
+		return
+	}
+	in := entry.Params.Copy()
+	in["_async"] = true
+	if entry.Group == fmt.Sprintf("job/%d", entry.ID) {
+		// Use the default group for the new job
+		delete(in, "_group")
+	}
+	job, _, err := jobs.NewCallJob(ctx, call, in)
+	if err != nil {
+		fs.Errorf(nil, "rc: failed to restart job %d (%s): %v", entry.ID, entry.Path, err)
+		return
+	}
+	fs.Logf(nil, "rc: restarted job %d (%s) as job %d", entry.ID, entry.Path, job.ID)
+	if err = h.setRestarted(entry.ID, job.ID); err != nil {
+		fs.Errorf(nil, "rc: failed to record restart of job %d: %v", entry.ID, err)
+	}
+}
+
+// stopHistory closes and removes the job history - used in tests
+func (jobs *Jobs) stopHistory() {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	if jobs.history != nil {
+		_ = jobs.history.db.Stop(true)
+		jobs.history = nil
+	}
+}
+
+// getHistory returns the job history or nil if it isn't in use
+func (jobs *Jobs) getHistory() *history {
+	jobs.mu.RLock()
+	defer jobs.mu.RUnlock()
+	return jobs.history
+}
+
+// historyJobStatus returns the status of the job with id from the
+// history for job/status
+func historyJobStatus(id int64) (out rc.Params, err error) {
+	h := running.getHistory()
+	if h == nil {
+		return nil, errors.New("job not found")
+	}
+	entry, err := h.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, errors.New("job not found")
+	}
+	out = make(rc.Params)
+	err = rc.Reshape(&out, entry)
+	if err != nil {
+		return nil, fmt.Errorf("reshape failed in job status: %w", err)
+	}
+	return out, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "job/history",
+		Fn:    rcJobHistory,
+		Title: "Lists the jobs in the job history",
+		Help: `This needs the job history to be enabled with --rc-job-history.
+
+The history is kept on disk so it includes jobs from before rclone
+was last restarted. Jobs are listed newest first.
+
+Parameters:
+
+- group - only list the jobs in this group (optional string).
+- limit - maximum number of jobs to list (optional integer, default 100, 0 for all).
+- before - only list jobs with an id less than this (optional integer).
+
+To read the history a page at a time pass the id of the last job
+returned as before in the next call.
+
+The values of parameters which "rclone config redacted" would hide,
+such as passwords and secret keys, are replaced with XXX in the
+history. This includes values set in connection strings.
+
+Results:
+
+- executeId - string id of rclone executing (change after restart)
+- jobs - array of jobs, each with
+    - id - id of the job
+    - executeId - the executeId of the rclone which ran the job
+    - path - the rc call the job ran, e.g. "sync/sync"
+    - params - the parameters of the call with any secrets redacted
+    - group - the stats group of the job
+    - startTime - time the job started
+    - endTime - time the job finished
+    - error - error from the job or empty string for no error
+    - finished - boolean whether the job has finished or not
+    - success - boolean - true for success false otherwise
+    - duration - time in seconds that the job ran for
+    - stats - the stats of the job when it finished as returned by core/stats
+    - interrupted - boolean - true if rclone stopped while the job was running
+    - restartedAs - id of the job it was restarted as, if it was
+    - redacted - boolean - true if secrets were removed from the params
+`,
+	})
+}
+
+// Returns the jobs in the history
+func rcJobHistory(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	h := running.getHistory()
+	if h == nil {
+		return nil, errors.New("job history is not enabled - use --rc-job-history")
+	}
+	group, err := in.GetString("group")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	limit, err := in.GetInt64("limit")
+	if rc.IsErrParamNotFound(err) {
+		limit = 100
+	} else if err != nil {
+		return nil, err
+	}
+	before, err := in.GetInt64("before")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	entries := []*historyEntry{}
+	err = h.list(before, func(entry *historyEntry) bool {
+		if group != "" && entry.Group != group {
+			return true
+		}
+		entries = append(entries, entry)
+		return limit <= 0 || int64(len(entries)) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	out = make(rc.Params)
+	err = rc.Reshape(&out, map[string]any{
+		"jobs":      entries,
+		"executeId": executeID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reshape failed in job history: %w", err)
+	}
+	return out, nil
+}
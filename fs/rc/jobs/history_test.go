@@ -0,0 +1,237 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestHistory starts the job history on the global jobs, removing
+// it and any jobs run at the end of the test
+func startTestHistory(ctx context.Context, t *testing.T, opt *rc.Options) *history {
+	jobID.Store(0)
+	require.NoError(t, running.startHistory(ctx, opt))
+	t.Cleanup(func() {
+		running.stopHistory()
+		running.mu.Lock()
+		running.jobs = map[int64]*Job{}
+		running.mu.Unlock()
+	})
+	return running.getHistory()
+}
+
+// waitFinished waits for job to finish
+func waitFinished(t *testing.T, job *Job) {
+	done := make(chan struct{})
+	job.OnFinish(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for job")
+	}
+}
+
+// waitRecorded waits for the history entry for id to be finished
+func waitRecorded(t *testing.T, h *history, id int64) *historyEntry {
+	for range 100 {
+		entry, err := h.get(id)
+		require.NoError(t, err)
+		if entry != nil && entry.Finished {
+			return entry
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %d not recorded as finished", id)
+	return nil
+}
+
+func TestJobHistory(t *testing.T) {
+	ctx := context.Background()
+	opt := rc.Opt
+	opt.JobHistoryMaxAge = fs.Duration(time.Hour)
+	h := startTestHistory(ctx, t, &opt)
+	require.NotNil(t, h)
+
+	called := make(chan rc.Params, 1)
+	call := &rc.Call{
+		Path: "test/history",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			called <- in
+			return rc.Params{"ok": true}, nil
+		},
+	}
+
+	// Sync jobs aren't recorded
+	job, _, err := NewCallJob(ctx, call, rc.Params{"a": 1})
+	require.NoError(t, err)
+	<-called
+	entry, err := h.get(job.ID)
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	// Async jobs are
+	job, _, err = NewCallJob(ctx, call, rc.Params{"a": 2, "_async": true})
+	require.NoError(t, err)
+	<-called
+	waitFinished(t, job)
+	entry = waitRecorded(t, h, job.ID)
+	assert.Equal(t, "test/history", entry.Path)
+	assert.Equal(t, rc.Params{"a": float64(2), "_async": true}, entry.Params)
+	assert.True(t, entry.Success)
+	assert.False(t, entry.Interrupted)
+	assert.NotNil(t, entry.Stats)
+
+	// job/status reads expired jobs from the history
+	running.mu.Lock()
+	delete(running.jobs, job.ID)
+	running.mu.Unlock()
+	out, err := rcJobStatus(ctx, rc.Params{"jobid": job.ID})
+	require.NoError(t, err)
+	assert.Equal(t, float64(job.ID), out["id"])
+	assert.Equal(t, true, out["success"])
+
+	out, err = rcJobList(ctx, rc.Params{})
+	require.NoError(t, err)
+	assert.Equal(t, []int64{job.ID}, out["historyJobids"])
+
+	out, err = rcJobHistory(ctx, rc.Params{"group": "potato"})
+	require.NoError(t, err)
+	assert.Len(t, out["jobs"], 0)
+	out, err = rcJobHistory(ctx, rc.Params{})
+	require.NoError(t, err)
+	require.Len(t, out["jobs"], 1)
+}
+
+func TestJobHistoryRestart(t *testing.T) {
+	ctx := context.Background()
+	opt := rc.Opt
+	opt.JobRestart = true
+	h := startTestHistory(ctx, t, &opt)
+
+	called := make(chan rc.Params, 1)
+	rc.Add(rc.Call{
+		Path: "test/restart",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			called <- in
+			return nil, nil
+		},
+	})
+
+	// Records left by a previous rclone
+	old := time.Now().Add(-2 * time.Hour)
+	for _, entry := range []*historyEntry{
+		{ID: 41, ExecuteID: "old", Path: "test/restart", Group: "job/41", Finished: true, Success: true, StartTime: old, EndTime: old},
+		{ID: 42, ExecuteID: "old", Path: "test/restart", Group: "job/42", StartTime: old, Params: rc.Params{"a": 1, "_async": true, "_group": "job/42"}},
+		{ID: 43, ExecuteID: "old", Path: "test/unknown", Group: "job/43", StartTime: old},
+	} {
+		require.NoError(t, h.db.Do(true, &kvHistoryPut{entry: entry}))
+	}
+
+	// Simulate starting rclone again
+	running.mu.Lock()
+	running.history = nil
+	running.mu.Unlock()
+	opt.JobHistoryMaxAge = fs.Duration(time.Hour)
+	require.NoError(t, running.startHistory(ctx, &opt))
+	h = running.getHistory()
+	t.Cleanup(func() { _ = h.db.Stop(true) }) // the database was started twice
+
+	in := <-called
+	assert.Equal(t, float64(1), in["a"])
+
+	// The finished job has expired
+	entry, err := h.get(41)
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	// The interrupted job was restarted with a new ID
+	entry, err = h.get(42)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.Finished)
+	assert.True(t, entry.Interrupted)
+	assert.False(t, entry.Success)
+	assert.Equal(t, errInterrupted, entry.Error)
+	assert.Equal(t, int64(44), entry.RestartedAs)
+	newEntry := waitRecorded(t, h, 44)
+	assert.Equal(t, "job/44", newEntry.Group)
+	assert.True(t, newEntry.Success)
+
+	// The unknown call couldn't be restarted
+	entry, err = h.get(43)
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.True(t, entry.Interrupted)
+	assert.Equal(t, int64(0), entry.RestartedAs)
+}
+
+func init() {
+	// A backend with options which need redacting
+	fs.Register(&fs.RegInfo{
+		Name: "historytest",
+		Options: []fs.Option{{
+			Name:       "historytest_pass",
+			IsPassword: true,
+		}, {
+			Name:      "historytest_token",
+			Sensitive: true,
+		}, {
+			Name: "historytest_user",
+		}},
+	})
+}
+
+func TestHistoryParams(t *testing.T) {
+	params, redacted := historyParams(rc.Params{"a": 1, "_request": struct{}{}})
+	assert.Equal(t, rc.Params{"a": 1}, params)
+	assert.False(t, redacted)
+	params, _ = historyParams(rc.Params{"a": func() {}})
+	assert.Nil(t, params)
+
+	params, redacted = historyParams(rc.Params{
+		"name": "remote",
+		"parameters": map[string]any{
+			"historytest_pass": "secret",
+			"historytest_user": "user",
+		},
+		"list":  []any{rc.Params{"historytest_token": "secret"}},
+		"fs":    ":historytest,historytest_user=user,historytest_token=secret:path/to/dir",
+		"other": "a=b",
+	})
+	assert.True(t, redacted)
+	assert.Equal(t, rc.Params{
+		"name": "remote",
+		"parameters": map[string]any{
+			"historytest_pass": "XXX",
+			"historytest_user": "user",
+		},
+		"list":  []any{rc.Params{"historytest_token": "XXX"}},
+		"fs":    ":historytest,historytest_token='XXX',historytest_user='user':path/to/dir",
+		"other": "a=b",
+	}, params)
+}
+
+func TestJobHistoryPaging(t *testing.T) {
+	ctx := context.Background()
+	h := startTestHistory(ctx, t, &rc.Opt)
+	for id := int64(1); id <= 5; id++ {
+		require.NoError(t, h.db.Do(true, &kvHistoryPut{entry: &historyEntry{ID: id, Finished: true}}))
+	}
+	ids := func(in rc.Params) (ids []int64) {
+		out, err := rcJobHistory(ctx, in)
+		require.NoError(t, err)
+		for _, job := range out["jobs"].([]any) {
+			ids = append(ids, int64(job.(map[string]any)["id"].(float64)))
+		}
+		return ids
+	}
+	assert.Equal(t, []int64{5, 4}, ids(rc.Params{"limit": 2}))
+	assert.Equal(t, []int64{3, 2}, ids(rc.Params{"limit": 2, "before": 4}))
+	assert.Equal(t, []int64{1}, ids(rc.Params{"limit": 2, "before": 2}))
+	assert.Equal(t, []int64{5, 4, 3, 2, 1}, ids(rc.Params{"limit": 0, "before": 9}))
+}
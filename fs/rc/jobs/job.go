@@ -39,6 +39,11 @@ type Job struct {
 	Output    rc.Params `json:"output"`
 	Stop      func()    `json:"-"`
 	listeners []*func()
+	path      string    // the rc call being run if known
+	params    rc.Params // parameters of the call to store in the history
+	redacted  bool      // set if secrets were removed from params
+	stats     rc.Params // stats when the job finished
+	history   *history  // set if the job is recorded in the history
 
 	// realErr is the Error before printing it as a string, it's used to return
 	// the real error to the upper application layers while still printing the
@@ -101,6 +106,9 @@ func (job *Job) OnFinish(fn func()) func() {
 
 // run the job until completion writing the return status
 func (job *Job) run(ctx context.Context, fn rc.Func, in rc.Params) {
+	if job.history != nil {
+		defer job.history.finish(ctx, job)
+	}
 	defer func() {
 		if r := recover(); r != nil {
 			job.finish(nil, fmt.Errorf("panic received: %v \n%s", r, string(debug.Stack())))
@@ -115,6 +123,7 @@ type Jobs struct {
 	jobs          map[int64]*Job
 	opt           *rc.Options
 	expireRunning bool
+	history       *history // on-disk history if enabled
 }
 
 var (
@@ -261,8 +270,28 @@ var jobKey = jobKeyType{}
 
 // NewJob creates a Job and executes it, possibly in the background if _async is set
 func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Job, out rc.Params, err error) {
+	return jobs.newJob(ctx, "", fn, in)
+}
+
+// NewCallJob creates a Job running call and executes it, possibly in
+// the background if _async is set.
+//
+// This is the same as NewJob except that the path of the call is
+// recorded so the job can be restarted from the job history.
+func (jobs *Jobs) NewCallJob(ctx context.Context, call *rc.Call, in rc.Params) (job *Job, out rc.Params, err error) {
+	return jobs.newJob(ctx, call.Path, call.Fn, in)
+}
+
+func (jobs *Jobs) newJob(ctx context.Context, path string, fn rc.Func, in rc.Params) (job *Job, out rc.Params, err error) {
 	id := jobID.Add(1)
 	in = in.Copy() // copy input so we can change it
+	var (
+		params   rc.Params
+		redacted bool
+	)
+	if jobs.getHistory() != nil {
+		params, redacted = historyParams(in)
+	}
 
 	ctx, isAsync, err := getAsync(ctx, in)
 	if err != nil {
@@ -295,12 +324,24 @@ func (jobs *Jobs) NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Jo
 		Group:     group,
 		StartTime: time.Now(),
 		Stop:      stop,
+		path:      path,
+		params:    params,
+		redacted:  redacted,
 	}
 
 	jobs.mu.Lock()
 	jobs.jobs[job.ID] = job
+	// Only async jobs are recorded as the results of the others
+	// are returned to the caller
+	if isAsync && jobs.history != nil {
+		job.history = jobs.history
+	}
 	jobs.mu.Unlock()
 
+	if job.history != nil {
+		job.history.record(job)
+	}
+
 	// Add the job to the context
 	ctx = context.WithValue(ctx, jobKey, job)
 
@@ -323,6 +364,12 @@ func NewJob(ctx context.Context, fn rc.Func, in rc.Params) (job *Job, out rc.Par
 	return running.NewJob(ctx, fn, in)
 }
 
+// NewCallJob creates a Job running call and executes it on the global
+// job queue, possibly in the background if _async is set
+func NewCallJob(ctx context.Context, call *rc.Call, in rc.Params) (job *Job, out rc.Params, err error) {
+	return running.NewCallJob(ctx, call, in)
+}
+
 // OnFinish adds listener to jobid that will be triggered when job is finished.
 // It returns a function to cancel listening.
 func OnFinish(jobID int64, fn func()) (func(), error) {
@@ -369,6 +416,10 @@ Results:
 - success - boolean - true for success false otherwise
 - output - output of the job as would have been returned if called synchronously
 - progress - output of the progress related to the underlying job
+
+If the job has expired but the job history is enabled with
+--rc-job-history then the job is read from the history instead. This
+has the fields described in [job/history](#job-history) and no output.
 `,
 	})
 }
@@ -381,7 +432,7 @@ func rcJobStatus(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	}
 	job := running.Get(jobID)
 	if job == nil {
-		return nil, errors.New("job not found")
+		return historyJobStatus(jobID)
 	}
 	job.mu.Lock()
 	defer job.mu.Unlock()
@@ -404,6 +455,8 @@ Results:
 
 - executeId - string id of rclone executing (change after restart)
 - jobids - array of integer job ids (starting at 1 on each restart)
+- historyJobids - array of integer job ids in the job history, only
+  present if the job history is enabled with --rc-job-history
 `,
 	})
 }
@@ -413,6 +466,17 @@ func rcJobList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
 	out = make(rc.Params)
 	out["jobids"] = running.IDs()
 	out["executeId"] = executeID
+	if h := running.getHistory(); h != nil {
+		historyIDs := []int64{}
+		err = h.list(0, func(entry *historyEntry) bool {
+			historyIDs = append(historyIDs, entry.ID)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		out["historyJobids"] = historyIDs
+	}
 	return out, nil
 }
 
@@ -83,6 +83,21 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(10 * time.Second),
 	Help:    "Interval to check for expired async jobs",
 	Groups:  "RC",
+}, {
+	Name:    "rc_job_history",
+	Default: false,
+	Help:    "Keep a history of async jobs on disk",
+	Groups:  "RC",
+}, {
+	Name:    "rc_job_history_max_age",
+	Default: fs.Duration(7 * 24 * time.Hour),
+	Help:    "Remove jobs which finished longer ago than this from the job history",
+	Groups:  "RC",
+}, {
+	Name:    "rc_job_restart",
+	Default: false,
+	Help:    "Restart jobs interrupted by rclone stopping when using --rc-job-history",
+	Groups:  "RC",
 }, {
 	Name:    "metrics_addr",
 	Default: []string{},
@@ -122,6 +137,9 @@ type Options struct {
 	MetricsTemplate     libhttp.TemplateConfig `config:"metrics"`
 	JobExpireDuration   fs.Duration            `config:"rc_job_expire_duration"`
 	JobExpireInterval   fs.Duration            `config:"rc_job_expire_interval"`
+	JobHistory          bool                   `config:"rc_job_history"`         // set to keep a history of jobs on disk
+	JobHistoryMaxAge    fs.Duration            `config:"rc_job_history_max_age"` // remove jobs older than this from the history
+	JobRestart          bool                   `config:"rc_job_restart"`         // set to restart interrupted jobs from the history
 }
 
 // Opt is the default values used for Options
@@ -23,6 +23,7 @@ import (
 	"github.com/rclone/rclone/fs/list"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/rc/jobs"
+	_ "github.com/rclone/rclone/fs/rc/schedule" // import the scheduler
 	"github.com/rclone/rclone/fs/rc/webgui"
 	libhttp "github.com/rclone/rclone/lib/http"
 	"github.com/rclone/rclone/lib/http/serve"
@@ -35,6 +36,11 @@ import (
 // If the server wasn't configured the *Server returned may be nil
 func Start(ctx context.Context, opt *rc.Options) (*Server, error) {
 	jobs.SetOpt(opt) // set the defaults for jobs
+	if opt.JobHistory {
+		if err := jobs.StartHistory(ctx, opt); err != nil {
+			return nil, err
+		}
+	}
 	if opt.Enabled {
 		// Serve on the DefaultServeMux so can have global registrations appear
 		s, err := newServer(ctx, opt, http.DefaultServeMux)
@@ -280,7 +286,7 @@ func (s *Server) handlePost(w http.ResponseWriter, r *http.Request, path string)
 	}
 
 	fs.Debugf(nil, "rc: %q: with parameters %+v", path, in)
-	job, out, err := jobs.NewJob(ctx, call.Fn, in)
+	job, out, err := jobs.NewCallJob(ctx, call, in)
 	if job != nil {
 		w.Header().Add("x-rclone-jobid", fmt.Sprintf("%d", job.ID))
 	}
@@ -0,0 +1,95 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, test := range []struct {
+		expr    string
+		wantErr string
+	}{
+		{expr: "* * * * *"},
+		{expr: "*/15 0-6,22,23 1 jan-mar mon-fri"},
+		{expr: "0 0 * * 7"},
+		{expr: "@daily"},
+		{expr: "@HOURLY"},
+		{expr: "5/10 * * * *"},
+		{expr: "* * * *", wantErr: "must have 5 fields"},
+		{expr: "60 * * * *", wantErr: "minute 60 out of range 0-59"},
+		{expr: "* 24 * * *", wantErr: "hour 24 out of range 0-23"},
+		{expr: "* * 0 * *", wantErr: "day of month 0 out of range 1-31"},
+		{expr: "* * * potato * ", wantErr: `bad month "potato"`},
+		{expr: "*/0 * * * *", wantErr: `bad step "0" in minute`},
+		{expr: "5-1 * * * *", wantErr: `bad range "5-1" in minute`},
+		{expr: "@fortnightly", wantErr: "must have 5 fields"},
+	} {
+		_, err := parseCron(test.expr)
+		if test.wantErr == "" {
+			assert.NoError(t, err, test.expr)
+		} else {
+			assert.ErrorContains(t, err, test.wantErr, test.expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	date := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.UTC)
+		require.NoError(t, err)
+		return tm
+	}
+	for _, test := range []struct {
+		expr string
+		from string
+		want string
+	}{
+		{"* * * * *", "2024-01-01 10:00:00", "2024-01-01 10:01:00"},
+		{"* * * * *", "2024-01-01 10:00:30", "2024-01-01 10:01:00"},
+		{"*/15 * * * *", "2024-01-01 10:07:00", "2024-01-01 10:15:00"},
+		{"*/15 * * * *", "2024-01-01 10:45:00", "2024-01-01 11:00:00"},
+		{"30 2 * * *", "2024-01-01 10:00:00", "2024-01-02 02:30:00"},
+		{"@hourly", "2024-12-31 23:59:59", "2025-01-01 00:00:00"},
+		{"@monthly", "2024-01-15 00:00:00", "2024-02-01 00:00:00"},
+		{"@yearly", "2024-01-01 00:00:00", "2025-01-01 00:00:00"},
+		// 2024-01-01 is a Monday
+		{"0 9 * * mon-fri", "2024-01-05 10:00:00", "2024-01-08 09:00:00"},
+		{"0 0 * * 7", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 0", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		// day of month or day of week if both are restricted
+		{"0 0 13 * fri", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 13 * fri", "2024-01-12 00:00:00", "2024-01-13 00:00:00"},
+		// day of month and day of week if either is *
+		{"0 0 */2 * fri", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 29 feb *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 0 31 * *", "2024-04-01 00:00:00", "2024-05-31 00:00:00"},
+	} {
+		c, err := parseCron(test.expr)
+		require.NoError(t, err, test.expr)
+		got, err := c.next(date(test.from))
+		require.NoError(t, err, test.expr)
+		assert.Equal(t, date(test.want), got, "%s from %s", test.expr, test.from)
+	}
+
+	c, err := parseCron("0 0 30 feb *")
+	require.NoError(t, err)
+	_, err = c.next(date("2024-01-01 00:00:00"))
+	assert.Equal(t, errNoNextTime, err)
+}
+
+func TestCronNextTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata") // UTC+5:30
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	c, err := parseCron("0 * * * *")
+	require.NoError(t, err)
+	got, err := c.next(time.Date(2024, 1, 1, 10, 10, 0, 0, loc))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 11, 0, 0, 0, loc), got)
+}
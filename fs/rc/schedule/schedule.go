@@ -0,0 +1,511 @@
+// Package schedule runs rc calls on a schedule.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/fs/rc/jobs"
+)
+
+// Overlap policies say what to do if a run is due while the previous
+// run is still going
+const (
+	OverlapSkip           = "skip"            // don't start the new run
+	OverlapQueue          = "queue"           // start the new run when the previous one finishes
+	OverlapCancelPrevious = "cancel-previous" // stop the previous run and start the new one
+)
+
+var overlapPolicies = []string{OverlapSkip, OverlapQueue, OverlapCancelPrevious}
+
+// Schedule describes an rc call which is run repeatedly
+type Schedule struct {
+	mu        sync.Mutex
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	Path      string      `json:"path"`
+	Params    rc.Params   `json:"params"`
+	Cron      string      `json:"cron,omitempty"`
+	Interval  fs.Duration `json:"interval,omitempty"`
+	Overlap   string      `json:"overlap"`
+	Paused    bool        `json:"paused"`
+	Next      time.Time   `json:"next"`
+	Runs      int64       `json:"runs"`
+	Skipped   int64       `json:"skipped"`
+	LastJobID int64       `json:"lastJobid"`
+	LastStart time.Time   `json:"lastStart"`
+	LastError string      `json:"lastError"`
+	Running   bool        `json:"running"`
+	Queued    bool        `json:"queued"`
+
+	call    *rc.Call
+	cron    *cronSpec
+	timer   *time.Timer
+	job     *jobs.Job // the running job or nil
+	removed bool
+}
+
+// next works out the next time the schedule should run after now
+func (s *Schedule) next(now time.Time) (time.Time, error) {
+	if s.cron != nil {
+		return s.cron.next(now)
+	}
+	next := s.Next.Add(time.Duration(s.Interval))
+	if s.Next.IsZero() || next.Before(now) {
+		next = now.Add(time.Duration(s.Interval))
+	}
+	return next, nil
+}
+
+// arm sets the timer for the next run - call with the lock held
+func (s *Schedule) arm(now time.Time) {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.Paused || s.removed {
+		s.Next = time.Time{}
+		return
+	}
+	next, err := s.next(now)
+	if err != nil {
+		s.Next = time.Time{}
+		s.LastError = err.Error()
+		fs.Errorf(nil, "rc: schedule %d: %v", s.ID, err)
+		return
+	}
+	s.Next = next
+	s.timer = time.AfterFunc(next.Sub(now), s.fire)
+}
+
+// fire is called when the schedule is due
+func (s *Schedule) fire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Paused || s.removed {
+		return
+	}
+	s.trigger()
+	s.arm(time.Now())
+}
+
+// trigger runs the call applying the overlap policy - call with the
+// lock held
+func (s *Schedule) trigger() {
+	if s.job != nil {
+		switch s.Overlap {
+		case OverlapQueue:
+			fs.Debugf(nil, "rc: schedule %d: queueing run as job %d is still running", s.ID, s.job.ID)
+			s.Queued = true
+			return
+		case OverlapCancelPrevious:
+			fs.Logf(nil, "rc: schedule %d: stopping job %d to start a new run", s.ID, s.job.ID)
+			s.job.Stop()
+			s.job = nil
+			s.Running = false
+		default:
+			fs.Logf(nil, "rc: schedule %d: skipping run as job %d is still running", s.ID, s.job.ID)
+			s.Skipped++
+			return
+		}
+	}
+	s.start()
+}
+
+// start runs the call as a new async job - call with the lock held
+func (s *Schedule) start() {
+	in := s.Params.Copy()
+	in["_async"] = true
+	job, _, err := jobs.NewCallJob(context.Background(), s.call, in)
+	s.LastStart = time.Now()
+	s.Runs++
+	if err != nil {
+		s.LastError = err.Error()
+		fs.Errorf(nil, "rc: schedule %d: failed to start %q: %v", s.ID, s.Path, err)
+		return
+	}
+	fs.Debugf(nil, "rc: schedule %d: started %q as job %d", s.ID, s.Path, job.ID)
+	s.job = job
+	s.Running = true
+	s.LastJobID = job.ID
+	s.LastError = ""
+	job.OnFinish(func() { s.finished(job) })
+}
+
+// finished is called when job finishes
+func (s *Schedule) finished(job *jobs.Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.job != job {
+		// job was stopped by a newer run
+		return
+	}
+	s.job = nil
+	s.Running = false
+	if job.Error != "" {
+		s.LastError = job.Error
+	}
+	// Pausing or removing the schedule clears Queued
+	if s.Queued {
+		s.Queued = false
+		s.start()
+	}
+}
+
+// Scheduler holds the schedules
+type Scheduler struct {
+	mu        sync.Mutex
+	schedules map[int64]*Schedule
+	lastID    int64
+}
+
+// NewScheduler makes a new empty Scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		schedules: make(map[int64]*Schedule),
+	}
+}
+
+// Add a schedule to run the rc call at path with params.
+//
+// Exactly one of cronExpr and interval must be set. If overlap is
+// empty then OverlapSkip is used.
+func (sc *Scheduler) Add(name, path string, params rc.Params, cronExpr string, interval time.Duration, overlap string, paused bool) (*Schedule, error) {
+	call := rc.Calls.Get(path)
+	if call == nil {
+		return nil, fmt.Errorf("couldn't find method %q", path)
+	}
+	if call.NeedsRequest || call.NeedsResponse {
+		return nil, fmt.Errorf("method %q can't be scheduled as it needs an HTTP request", path)
+	}
+	if overlap == "" {
+		overlap = OverlapSkip
+	}
+	if !slices.Contains(overlapPolicies, overlap) {
+		return nil, fmt.Errorf("unknown overlap policy %q - must be one of %v", overlap, overlapPolicies)
+	}
+	s := &Schedule{
+		Name:     name,
+		Path:     path,
+		Params:   params.Copy(),
+		Cron:     cronExpr,
+		Interval: fs.Duration(interval),
+		Overlap:  overlap,
+		Paused:   paused,
+		call:     call,
+	}
+	switch {
+	case cronExpr != "" && interval != 0:
+		return nil, errors.New("only one of cron and interval can be set")
+	case cronExpr != "":
+		var err error
+		s.cron, err = parseCron(cronExpr)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = s.cron.next(time.Now()); err != nil {
+			return nil, err
+		}
+	case interval > 0:
+	case interval < 0:
+		return nil, errors.New("interval must be positive")
+	default:
+		return nil, errors.New("one of cron or interval must be set")
+	}
+	sc.mu.Lock()
+	sc.lastID++
+	s.ID = sc.lastID
+	sc.schedules[s.ID] = s
+	sc.mu.Unlock()
+
+	s.mu.Lock()
+	s.arm(time.Now())
+	s.mu.Unlock()
+	return s, nil
+}
+
+// Get the schedule with id or nil if not found
+func (sc *Scheduler) Get(id int64) *Schedule {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.schedules[id]
+}
+
+// List the schedules in ID order
+func (sc *Scheduler) List() []*Schedule {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	schedules := make([]*Schedule, 0, len(sc.schedules))
+	for _, s := range sc.schedules {
+		schedules = append(schedules, s)
+	}
+	slices.SortFunc(schedules, func(a, b *Schedule) int {
+		return int(a.ID - b.ID)
+	})
+	return schedules
+}
+
+// Remove the schedule with id.
+//
+// If stop is set then any running job started by the schedule is
+// stopped too.
+func (sc *Scheduler) Remove(id int64, stop bool) error {
+	sc.mu.Lock()
+	s := sc.schedules[id]
+	delete(sc.schedules, id)
+	sc.mu.Unlock()
+	if s == nil {
+		return errors.New("schedule not found")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removed = true
+	s.Queued = false
+	s.arm(time.Now())
+	if stop && s.job != nil {
+		s.job.Stop()
+	}
+	return nil
+}
+
+// SetPaused pauses or resumes the schedule with id.
+func (sc *Scheduler) SetPaused(id int64, paused bool) error {
+	s := sc.Get(id)
+	if s == nil {
+		return errors.New("schedule not found")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Paused == paused {
+		return nil
+	}
+	s.Paused = paused
+	if paused {
+		s.Queued = false
+	}
+	s.arm(time.Now())
+	return nil
+}
+
+// scheduler is the global scheduler used by the rc
+var scheduler = NewScheduler()
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/add",
+		AuthRequired: true,
+		Fn:           rcAdd,
+		Title:        "Run an rc call on a schedule",
+		Help: `This runs an rc call repeatedly, either at the times given by a cron
+expression or at a fixed interval. Each run is started as a normal
+async job so it appears in job/list and has its own stats group.
+
+Parameters:
+
+- path - the rc call to run, e.g. "sync/sync" (string).
+- params - the parameters to pass to the call (optional object).
+- cron - when to run the call as a cron expression (optional string).
+- interval - how often to run the call, e.g. "1h" (optional duration).
+- overlap - what to do if a run is due while the previous one is still going (optional string).
+    - skip - don't start the new run (default)
+    - queue - start the new run when the previous one finishes
+    - cancel-previous - stop the previous run and start the new one
+- name - a name for the schedule (optional string).
+- paused - set to add the schedule paused (optional boolean).
+
+Exactly one of cron or interval must be given.
+
+The cron expression has 5 fields, minute, hour, day of month, month
+and day of week, and is evaluated in the local time zone. Each field
+may be "*", a number, a range "1-5", a list "1,3,5" and may have a
+step "*/15". Months and days of the week may be given by their three
+letter English names. The shorthands @hourly, @daily, @weekly,
+@monthly and @yearly may also be used.
+
+The params may contain _config, _filter and _group as for any other
+call.
+
+Results:
+
+- id - the id of the schedule (integer)
+- next - the time the call will next run
+
+Eg
+
+    rclone rc schedule/add path=sync/sync cron="30 2 * * *" params='{"srcFs": "/home", "dstFs": "remote:backup"}'
+
+Note that schedules are kept in memory so they need adding again if
+rclone is restarted.
+`,
+	})
+}
+
+// Adds a schedule
+func rcAdd(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	path, err := in.GetString("path")
+	if err != nil {
+		return nil, err
+	}
+	var params rc.Params
+	err = in.GetStructMissingOK("params", &params)
+	if err != nil {
+		return nil, err
+	}
+	cronExpr, err := in.GetString("cron")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	interval, err := in.GetDuration("interval")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	overlap, err := in.GetString("overlap")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	name, err := in.GetString("name")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	paused, err := in.GetBool("paused")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	s, err := scheduler.Add(name, path, params, cronExpr, interval, overlap, paused)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return rc.Params{
+		"id":   s.ID,
+		"next": s.Next,
+	}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/list",
+		AuthRequired: true,
+		Fn:           rcList,
+		Title:        "List the schedules",
+		Help: `Parameters: None.
+
+Results:
+
+- schedules - array of schedules, each with
+    - id - id of the schedule
+    - name - name of the schedule
+    - path - the rc call run
+    - params - the parameters passed to the call
+    - cron - the cron expression if set
+    - interval - the interval if set
+    - overlap - the overlap policy
+    - paused - whether the schedule is paused
+    - next - the time of the next run
+    - runs - number of runs started
+    - skipped - number of runs skipped as the previous run was still going
+    - lastJobid - the job id of the last run
+    - lastStart - the time the last run started
+    - lastError - the error from the last run or empty string for none
+    - running - whether a run is in progress
+    - queued - whether a run is queued waiting for the current run to finish
+`,
+	})
+}
+
+// Lists the schedules
+func rcList(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	schedules := []rc.Params{}
+	for _, s := range scheduler.List() {
+		item := make(rc.Params)
+		s.mu.Lock()
+		err = rc.Reshape(&item, s)
+		s.mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("reshape failed in schedule list: %w", err)
+		}
+		schedules = append(schedules, item)
+	}
+	return rc.Params{"schedules": schedules}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/remove",
+		AuthRequired: true,
+		Fn:           rcRemove,
+		Title:        "Remove a schedule",
+		Help: `Parameters:
+
+- id - id of the schedule (integer).
+- stop - set to stop the running job started by the schedule too (optional boolean).
+`,
+	})
+}
+
+// Removes a schedule
+func rcRemove(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	stop, err := in.GetBool("stop")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	return nil, scheduler.Remove(id, stop)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/pause",
+		AuthRequired: true,
+		Fn:           rcPause,
+		Title:        "Pause a schedule",
+		Help: `No new runs are started while a schedule is paused. A run which is
+in progress carries on.
+
+Parameters:
+
+- id - id of the schedule (integer).
+`,
+	})
+}
+
+// Pauses a schedule
+func rcPause(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	return nil, scheduler.SetPaused(id, true)
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:         "schedule/resume",
+		AuthRequired: true,
+		Fn:           rcResume,
+		Title:        "Resume a paused schedule",
+		Help: `Parameters:
+
+- id - id of the schedule (integer).
+`,
+	})
+}
+
+// Resumes a schedule
+func rcResume(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	id, err := in.GetInt64("id")
+	if err != nil {
+		return nil, err
+	}
+	return nil, scheduler.SetPaused(id, false)
+}
@@ -0,0 +1,265 @@
+package schedule
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testRuns    atomic.Int64
+	testStarted = make(chan rc.Params, 100)
+	testRelease = make(chan struct{})
+)
+
+func init() {
+	rc.Add(rc.Call{
+		Path: "test/schedule",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			testRuns.Add(1)
+			return nil, nil
+		},
+	})
+	rc.Add(rc.Call{
+		Path: "test/schedule-block",
+		Fn: func(ctx context.Context, in rc.Params) (rc.Params, error) {
+			testStarted <- in
+			select {
+			case <-testRelease:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return nil, nil
+		},
+	})
+}
+
+// waitFor waits for cond to become true
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for range 500 {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+// state reads fields of s under the lock
+func state(s *Schedule) (runs, skipped int64, running, queued bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Runs, s.Skipped, s.Running, s.Queued
+}
+
+func TestSchedulerAddErrors(t *testing.T) {
+	sc := NewScheduler()
+	for _, test := range []struct {
+		name     string
+		path     string
+		cron     string
+		interval time.Duration
+		overlap  string
+		wantErr  string
+	}{
+		{name: "unknown call", path: "potato/potato", interval: time.Hour, wantErr: `couldn't find method "potato/potato"`},
+		{name: "neither", path: "test/schedule", wantErr: "one of cron or interval must be set"},
+		{name: "both", path: "test/schedule", cron: "@daily", interval: time.Hour, wantErr: "only one of cron and interval can be set"},
+		{name: "negative", path: "test/schedule", interval: -time.Hour, wantErr: "interval must be positive"},
+		{name: "bad cron", path: "test/schedule", cron: "* * *", wantErr: "must have 5 fields"},
+		{name: "never", path: "test/schedule", cron: "0 0 31 feb *", wantErr: "never matches"},
+		{name: "bad overlap", path: "test/schedule", interval: time.Hour, overlap: "potato", wantErr: `unknown overlap policy "potato"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := sc.Add("", test.path, nil, test.cron, test.interval, test.overlap, false)
+			assert.ErrorContains(t, err, test.wantErr)
+		})
+	}
+	assert.Len(t, sc.List(), 0)
+}
+
+func TestSchedulerInterval(t *testing.T) {
+	sc := NewScheduler()
+	testRuns.Store(0)
+	s, err := sc.Add("fast", "test/schedule", rc.Params{"a": 1}, "", 20*time.Millisecond, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), s.ID)
+	assert.Equal(t, OverlapSkip, s.Overlap)
+	waitFor(t, "runs", func() bool { return testRuns.Load() >= 3 })
+
+	// No runs while paused
+	require.NoError(t, sc.SetPaused(s.ID, true))
+	time.Sleep(50 * time.Millisecond)
+	runs := testRuns.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, runs, testRuns.Load())
+	s.mu.Lock()
+	assert.True(t, s.Next.IsZero())
+	s.mu.Unlock()
+
+	require.NoError(t, sc.SetPaused(s.ID, false))
+	waitFor(t, "runs after resume", func() bool { return testRuns.Load() > runs })
+
+	require.NoError(t, sc.Remove(s.ID, false))
+	time.Sleep(50 * time.Millisecond)
+	runs = testRuns.Load()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, runs, testRuns.Load())
+	assert.Len(t, sc.List(), 0)
+	assert.Error(t, sc.Remove(s.ID, false))
+	assert.Error(t, sc.SetPaused(s.ID, true))
+}
+
+// addBlocking adds a paused schedule which runs test/schedule-block
+// so runs can be triggered by hand
+func addBlocking(t *testing.T, sc *Scheduler, overlap string) *Schedule {
+	s, err := sc.Add("", "test/schedule-block", nil, "", time.Hour, overlap, true)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sc.Remove(s.ID, true) })
+	return s
+}
+
+// trigger a run of s by hand
+func trigger(s *Schedule) {
+	s.mu.Lock()
+	s.trigger()
+	s.mu.Unlock()
+}
+
+func TestSchedulerOverlapSkip(t *testing.T) {
+	s := addBlocking(t, NewScheduler(), OverlapSkip)
+	trigger(s)
+	<-testStarted
+	trigger(s)
+	trigger(s)
+	runs, skipped, running, _ := state(s)
+	assert.Equal(t, int64(1), runs)
+	assert.Equal(t, int64(2), skipped)
+	assert.True(t, running)
+
+	testRelease <- struct{}{}
+	waitFor(t, "run to finish", func() bool {
+		_, _, running, _ := state(s)
+		return !running
+	})
+	trigger(s)
+	<-testStarted
+	runs, _, _, _ = state(s)
+	assert.Equal(t, int64(2), runs)
+	testRelease <- struct{}{}
+}
+
+func TestSchedulerOverlapQueue(t *testing.T) {
+	s := addBlocking(t, NewScheduler(), OverlapQueue)
+	trigger(s)
+	<-testStarted
+	trigger(s)
+	trigger(s) // only one run is queued
+	runs, skipped, running, queued := state(s)
+	assert.Equal(t, int64(1), runs)
+	assert.Equal(t, int64(0), skipped)
+	assert.True(t, running)
+	assert.True(t, queued)
+
+	testRelease <- struct{}{}
+	<-testStarted
+	runs, _, running, queued = state(s)
+	assert.Equal(t, int64(2), runs)
+	assert.True(t, running)
+	assert.False(t, queued)
+	testRelease <- struct{}{}
+	waitFor(t, "run to finish", func() bool {
+		_, _, running, _ := state(s)
+		return !running
+	})
+	runs, _, _, _ = state(s)
+	assert.Equal(t, int64(2), runs)
+}
+
+func TestSchedulerOverlapCancelPrevious(t *testing.T) {
+	s := addBlocking(t, NewScheduler(), OverlapCancelPrevious)
+	trigger(s)
+	<-testStarted
+	s.mu.Lock()
+	first := s.job
+	s.mu.Unlock()
+	require.NotNil(t, first)
+
+	trigger(s)
+	<-testStarted
+	s.mu.Lock()
+	second := s.job
+	s.mu.Unlock()
+	assert.NotEqual(t, first.ID, second.ID)
+
+	// The first job was stopped
+	waitFor(t, "first job to stop", func() bool {
+		status, err := rc.Calls.Get("job/status").Fn(context.Background(), rc.Params{"jobid": first.ID})
+		return err == nil && status["finished"] == true
+	})
+	runs, _, running, _ := state(s)
+	assert.Equal(t, int64(2), runs)
+	assert.True(t, running)
+	testRelease <- struct{}{}
+}
+
+func TestRcSchedule(t *testing.T) {
+	ctx := context.Background()
+	call := func(path string, in rc.Params) rc.Params {
+		t.Helper()
+		out, err := rc.Calls.Get(path).Fn(ctx, in)
+		require.NoError(t, err, path)
+		return out
+	}
+
+	out := call("schedule/add", rc.Params{
+		"path":     "test/schedule",
+		"params":   `{"a": "b"}`,
+		"cron":     "0 3 * * *",
+		"name":     "nightly",
+		"overlap":  "queue",
+		"paused":   false,
+		"ignoreme": true,
+	})
+	id := out["id"].(int64)
+	next := out["next"].(time.Time)
+	assert.Equal(t, 3, next.Hour())
+	assert.Equal(t, 0, next.Minute())
+
+	_, err := rc.Calls.Get("schedule/add").Fn(ctx, rc.Params{"path": "test/schedule"})
+	assert.Error(t, err)
+
+	findSchedule := func() rc.Params {
+		out := call("schedule/list", rc.Params{})
+		for _, item := range out["schedules"].([]rc.Params) {
+			if item["id"] == float64(id) {
+				return item
+			}
+		}
+		return nil
+	}
+	item := findSchedule()
+	require.NotNil(t, item)
+	assert.Equal(t, "nightly", item["name"])
+	assert.Equal(t, "test/schedule", item["path"])
+	assert.Equal(t, map[string]any{"a": "b"}, item["params"])
+	assert.Equal(t, "0 3 * * *", item["cron"])
+	assert.Equal(t, "queue", item["overlap"])
+	assert.Equal(t, false, item["paused"])
+
+	call("schedule/pause", rc.Params{"id": id})
+	assert.Equal(t, true, findSchedule()["paused"])
+	call("schedule/resume", rc.Params{"id": id})
+	assert.Equal(t, false, findSchedule()["paused"])
+
+	call("schedule/remove", rc.Params{"id": id})
+	assert.Nil(t, findSchedule())
+	_, err = rc.Calls.Get("schedule/remove").Fn(ctx, rc.Params{"id": id})
+	assert.ErrorContains(t, err, "schedule not found")
+}
@@ -0,0 +1,609 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/operations"
+)
+
+// checkpointVersion is the version of the checkpoint file format
+const checkpointVersion = 3
+
+// checkpointFlushInterval is how often the checkpoint file is flushed to disk
+var checkpointFlushInterval = time.Second
+
+// Types of checkpointRecord
+const (
+	checkpointHeader = "checkpoint" // first record in the file
+	checkpointObject = "object"     // an object which has been checked or transferred
+	checkpointDir    = "dir"        // a directory whose contents have all been checked or transferred
+)
+
+// checkpointRecord is a single line in the checkpoint file
+type checkpointRecord struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"` // header only
+	ID      string `json:"id,omitempty"`      // header only
+	Path    string `json:"path,omitempty"`    // path of the object or directory
+	Src     string `json:"src,omitempty"`     // fingerprint of the source object
+	Dst     string `json:"dst,omitempty"`     // fingerprint of the destination object
+	Listing string `json:"listing,omitempty"` // fingerprint of the listing of the directory
+}
+
+// checkpointTrack tracks the entries of a directory during the sync
+type checkpointTrack struct {
+	listed   bool                // set once all the entries have been seen
+	failed   bool                // set if the directory can't be recorded as complete
+	pending  map[string]struct{} // leaves of objects and directories (with a trailing /) not finished yet
+	entries  map[string]string   // keys of the finished objects and the directories (with a trailing /) by leaf
+	listing  string              // fingerprint of the listing if the directory was complete in the checkpoint file
+	checking bool                // set while the entries are compared with listing
+	changed  bool                // set if the directory has changed since it was complete
+	deferred []checkpointEntry   // objects not processed until the directory is found to have changed
+}
+
+// checkpointEntry is an object in a directory which was complete in
+// the checkpoint file. It is only processed if the directory has
+// changed.
+type checkpointEntry struct {
+	dst fs.Object // nil if the object is only in the source
+	src fs.Object
+}
+
+// checkpoint records which objects and directories have been checked
+// or transferred so that an interrupted sync can skip them when it is
+// run again.
+//
+// Each object is recorded with the fingerprints of the source and the
+// destination. If either of them change then the fingerprints won't
+// match and the object will be checked as normal.
+//
+// A directory is recorded once it has been listed, everything in it
+// and in all its subdirectories is done and the destination has
+// nothing extra in it. It is recorded with a fingerprint of its
+// listing made from the keys of its objects and the names of its
+// subdirectories. When the sync is resumed completed directories are
+// listed again and the objects in them are only checked if the
+// fingerprint of the listing has changed.
+type checkpoint struct {
+	path     string
+	root     string // the directory the sync starts from
+	mu       sync.Mutex
+	fd       *os.File
+	out      *bufio.Writer
+	enc      *json.Encoder
+	err      error                        // first error writing the file
+	dirs     map[string]string            // fingerprints of completed directories from the file
+	objects  map[string]map[string]string // done objects from the file by dir then leaf
+	tracking map[string]*checkpointTrack  // directories being synced
+	stop     chan struct{}                // close to stop the flusher
+	wg       sync.WaitGroup
+}
+
+// checkpointID makes an ID for the sync from fsrc to fdst including
+// the flags which affect whether objects are the same and which
+// objects are synced
+func checkpointID(ctx context.Context, fdst, fsrc fs.Fs) string {
+	ci := fs.GetConfig(ctx)
+	fi := filter.GetConfig(ctx)
+	filterOpt, err := json.Marshal(fi.Opt)
+	if err != nil {
+		// make an ID which won't match anything
+		filterOpt = []byte(time.Now().String())
+	}
+	filterHash := sha256.Sum256(filterOpt)
+	return fmt.Sprintf("src=%s dst=%s checksum=%v size-only=%v ignore-size=%v ignore-times=%v update=%v ignore-existing=%v metadata=%v filter=%s",
+		fs.ConfigString(fsrc), fs.ConfigString(fdst), ci.CheckSum, ci.SizeOnly, ci.IgnoreSize, ci.IgnoreTimes, ci.UpdateOlder, ci.IgnoreExisting, ci.Metadata, hex.EncodeToString(filterHash[:8]))
+}
+
+// listingFingerprint makes the fingerprint of the listing of a
+// directory from the keys of its entries
+func listingFingerprint(entries map[string]string) string {
+	h := sha256.New()
+	for _, leaf := range slices.Sorted(maps.Keys(entries)) {
+		_, _ = fmt.Fprintf(h, "%q %q\n", leaf, entries[leaf])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// splitRemote splits remote into a directory and leaf
+func splitRemote(remote string) (dir, leaf string) {
+	dir, leaf = path.Split(remote)
+	if dir != "" {
+		dir = dir[:len(dir)-1]
+	}
+	return dir, leaf
+}
+
+// openCheckpoint reads the checkpoint file at filePath if it exists
+// and opens it for writing. root is the directory the sync starts from.
+//
+// If the file was made by a different sync it is started afresh.
+func openCheckpoint(filePath string, id string, root string) (*checkpoint, error) {
+	c := &checkpoint{
+		path:     filePath,
+		root:     root,
+		dirs:     make(map[string]string),
+		objects:  make(map[string]map[string]string),
+		tracking: make(map[string]*checkpointTrack),
+		stop:     make(chan struct{}),
+	}
+	err := c.read(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+	err = c.create(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+	if len(c.dirs) > 0 || len(c.objects) > 0 {
+		fs.Infof(nil, "Resuming from checkpoint file %q with %d completed directories", filePath, len(c.dirs))
+	}
+	c.tracking[root] = newCheckpointTrack()
+	c.wg.Add(1)
+	go c.flusher()
+	return c, nil
+}
+
+// read the checkpoint file into memory if it exists and was made
+// with the same id
+func (c *checkpoint) read(id string) (err error) {
+	fd, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fs.CheckClose(fd, &err)
+	in := bufio.NewReader(fd)
+	for n := 0; ; n++ {
+		line, readErr := in.ReadBytes('\n')
+		if readErr == io.EOF {
+			// A partial line was being written when rclone stopped
+			return nil
+		} else if readErr != nil {
+			return readErr
+		}
+		var record checkpointRecord
+		if jsonErr := json.Unmarshal(line, &record); jsonErr != nil {
+			fs.Logf(nil, "Ignoring rest of corrupted checkpoint file %q: %v", c.path, jsonErr)
+			return nil
+		}
+		if n == 0 {
+			if record.Type != checkpointHeader || record.Version != checkpointVersion || record.ID != id {
+				fs.Logf(nil, "Ignoring checkpoint file %q as it was made by a different sync", c.path)
+				return nil
+			}
+			continue
+		}
+		switch record.Type {
+		case checkpointObject:
+			dir, leaf := splitRemote(record.Path)
+			objects := c.objects[dir]
+			if objects == nil {
+				objects = make(map[string]string)
+				c.objects[dir] = objects
+			}
+			objects[leaf] = record.Src + "\x00" + record.Dst
+		case checkpointDir:
+			c.dirs[record.Path] = record.Listing
+		}
+	}
+}
+
+// covered returns true if dir or any of its parents below the root
+// are complete
+func (c *checkpoint) covered(dir string) bool {
+	for dir != c.root && dir != "" {
+		if _, ok := c.dirs[dir]; ok {
+			return true
+		}
+		dir, _ = splitRemote(dir)
+	}
+	return false
+}
+
+// create the checkpoint file writing out the records read from the
+// old one so superseded records are removed
+func (c *checkpoint) create(id string) (err error) {
+	// Forget the records of the objects inside completed
+	// directories as the fingerprints of the listings are used
+	// instead
+	for dir := range c.objects {
+		if c.covered(dir) {
+			delete(c.objects, dir)
+		}
+	}
+	delete(c.dirs, c.root)
+	tmpPath := c.path + ".tmp"
+	fd, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	c.fd = fd
+	c.out = bufio.NewWriter(fd)
+	c.enc = json.NewEncoder(c.out)
+	c.write(&checkpointRecord{Type: checkpointHeader, Version: checkpointVersion, ID: id})
+	for dir, listing := range c.dirs {
+		c.write(&checkpointRecord{Type: checkpointDir, Path: dir, Listing: listing})
+	}
+	for dir, objects := range c.objects {
+		for leaf, key := range objects {
+			src, dst, _ := strings.Cut(key, "\x00")
+			c.write(&checkpointRecord{Type: checkpointObject, Path: path.Join(dir, leaf), Src: src, Dst: dst})
+		}
+	}
+	if c.err == nil {
+		c.err = c.out.Flush()
+	}
+	if c.err != nil {
+		_ = fd.Close()
+		_ = os.Remove(tmpPath)
+		return c.err
+	}
+	return os.Rename(tmpPath, c.path)
+}
+
+// write a record to the file - call with lock held
+func (c *checkpoint) write(record *checkpointRecord) {
+	if c.err != nil {
+		return
+	}
+	c.err = c.enc.Encode(record)
+	if c.err != nil {
+		fs.Errorf(nil, "Failed to write checkpoint file %q: %v", c.path, c.err)
+	}
+}
+
+// flusher flushes the file to disk regularly until stopped
+func (c *checkpoint) flusher() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(checkpointFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			if c.err == nil {
+				c.err = c.out.Flush()
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// key makes the key for the pair of objects, dst may be nil
+func (c *checkpoint) key(ctx context.Context, src, dst fs.Object) string {
+	key := fs.Fingerprint(ctx, src, true) + "\x00"
+	if dst != nil {
+		key += fs.Fingerprint(ctx, dst, true)
+	}
+	return key
+}
+
+func newCheckpointTrack() *checkpointTrack {
+	return &checkpointTrack{
+		pending: make(map[string]struct{}),
+		entries: make(map[string]string),
+	}
+}
+
+// unchanged returns true if src and dst are unchanged since they were
+// checked or transferred so don't need checking again.
+func (c *checkpoint) unchanged(ctx context.Context, src, dst fs.Object) bool {
+	dir, leaf := splitRemote(src.Remote())
+	key, ok := c.objects[dir][leaf]
+	return ok && key == c.key(ctx, src, dst)
+}
+
+// completed returns true if dir was recorded as complete so its
+// objects only need checking if its listing has changed.
+func (c *checkpoint) completed(dir string) bool {
+	_, ok := c.dirs[dir]
+	return ok
+}
+
+// start tracking remote which is a directory if isDir or an object
+func (c *checkpoint) start(remote string, isDir bool) {
+	dir, leaf := splitRemote(remote)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tracking[dir]
+	if t == nil {
+		return
+	}
+	if isDir {
+		leaf += "/"
+		dt := newCheckpointTrack()
+		if listing, ok := c.dirs[remote]; ok {
+			dt.listing = listing
+			dt.checking = true
+		}
+		c.tracking[remote] = dt
+		t.entries[leaf] = ""
+	}
+	t.pending[leaf] = struct{}{}
+}
+
+// changed records that the directory containing remote is different
+// from when it was recorded as complete.
+func (c *checkpoint) changed(remote string) {
+	dir, _ := splitRemote(remote)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.tracking[dir]; t != nil {
+		t.changed = true
+	}
+}
+
+// deferObject puts off processing src and dst, which may be nil, if
+// their directory was complete in the checkpoint file until it is
+// known whether the directory has changed. It returns false if the
+// objects should be processed now.
+func (c *checkpoint) deferObject(ctx context.Context, src, dst fs.Object) bool {
+	dir, leaf := splitRemote(src.Remote())
+	key := c.key(ctx, src, dst)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tracking[dir]
+	if t == nil || !t.checking {
+		return false
+	}
+	t.entries[leaf] = key
+	t.deferred = append(t.deferred, checkpointEntry{dst: dst, src: src})
+	return true
+}
+
+// checked finishes comparing the listing of dir with its fingerprint
+// if it was complete in the checkpoint file. It returns the objects
+// whose processing was put off and whether the directory is
+// unchanged so they don't need processing.
+func (c *checkpoint) checked(dir string, err error) (deferred []checkpointEntry, unchanged bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tracking[dir]
+	if t == nil || !t.checking {
+		return nil, false
+	}
+	t.checking = false
+	deferred, t.deferred = t.deferred, nil
+	unchanged = err == nil && !t.changed && listingFingerprint(t.entries) == t.listing
+	if unchanged {
+		fs.Debugf(dir, "Not checking objects in directory unchanged since checkpoint")
+	} else {
+		fs.Debugf(dir, "Directory has changed since checkpoint so checking its objects")
+	}
+	return deferred, unchanged
+}
+
+// skipped records that src and dst were skipped as they are
+// unchanged since they were recorded.
+func (c *checkpoint) skipped(ctx context.Context, src, dst fs.Object) {
+	dir, leaf := splitRemote(src.Remote())
+	key := c.key(ctx, src, dst)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.tracking[dir]; t != nil {
+		t.entries[leaf] = key
+	}
+}
+
+// done records that src has been checked or transferred to dst.
+//
+// dst may be nil if the object doesn't exist in the destination, for
+// example if it was found with --compare-dest.
+func (c *checkpoint) done(ctx context.Context, src, dst fs.Object) {
+	dir, leaf := splitRemote(src.Remote())
+	key := c.key(ctx, src, dst)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tracking[dir]
+	if t == nil {
+		return
+	}
+	srcFingerprint, dstFingerprint, _ := strings.Cut(key, "\x00")
+	c.write(&checkpointRecord{Type: checkpointObject, Path: src.Remote(), Src: srcFingerprint, Dst: dstFingerprint})
+	t.entries[leaf] = key
+	delete(t.pending, leaf)
+	c.finishDir(dir, t)
+}
+
+// failed records that src failed to be checked or transferred so its
+// directory won't be recorded as complete.
+func (c *checkpoint) failed(src fs.Object) {
+	dir, leaf := splitRemote(src.Remote())
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tracking[dir]
+	if t == nil {
+		return
+	}
+	t.failed = true
+	delete(t.pending, leaf)
+	c.finishDir(dir, t)
+}
+
+// dstOnly records that remote is only in the destination so its
+// directory won't be recorded as complete.
+func (c *checkpoint) dstOnly(remote string) {
+	dir, _ := splitRemote(remote)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.tracking[dir]; t != nil {
+		t.failed = true
+		t.changed = true
+	}
+}
+
+// listed records that all the entries in dir have been seen
+func (c *checkpoint) listed(dir string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.tracking[dir]
+	if t == nil {
+		return
+	}
+	if err != nil {
+		t.failed = true
+	}
+	t.listed = true
+	c.finishDir(dir, t)
+}
+
+// finishDir records dir as complete if everything in it is done and
+// passes that on to its parents - call with lock held
+func (c *checkpoint) finishDir(dir string, t *checkpointTrack) {
+	for t.listed && len(t.pending) == 0 {
+		delete(c.tracking, dir)
+		if t.failed || dir == c.root {
+			return
+		}
+		c.write(&checkpointRecord{Type: checkpointDir, Path: dir, Listing: listingFingerprint(t.entries)})
+		parent, leaf := splitRemote(dir)
+		t = c.tracking[parent]
+		if t == nil {
+			return
+		}
+		delete(t.pending, leaf+"/")
+		dir = parent
+	}
+}
+
+// close the checkpoint file, removing it if the sync was successful
+func (c *checkpoint) close(success bool) error {
+	close(c.stop)
+	c.wg.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = c.out.Flush()
+	}
+	closeErr := c.fd.Close()
+	if c.err == nil {
+		c.err = closeErr
+	}
+	if success {
+		fs.Infof(nil, "Removing checkpoint file %q as sync completed", c.path)
+		return os.Remove(c.path)
+	}
+	if c.err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", c.err)
+	}
+	fs.Infof(nil, "Saved progress in checkpoint file %q", c.path)
+	return nil
+}
+
+// checkpointMarcher is used as the Marcher instead of syncCopyMove
+// when --checkpoint-file is set so it can track the directories and
+// skip the objects in the ones which are complete
+type checkpointMarcher struct {
+	*syncCopyMove
+}
+
+// SrcOnly is called for entries only in the source
+func (m checkpointMarcher) SrcOnly(src fs.DirEntry) (recurse bool) {
+	if srcX, ok := src.(fs.Object); ok && m.checkpoint.deferObject(m.ctx, srcX, nil) {
+		return false
+	}
+	_, isDir := src.(fs.Directory)
+	if isDir {
+		m.checkpoint.changed(src.Remote())
+	} else {
+		m.checkpoint.start(src.Remote(), false)
+	}
+	recurse = m.syncCopyMove.SrcOnly(src)
+	if isDir && recurse {
+		m.checkpoint.start(src.Remote(), true)
+	}
+	return recurse
+}
+
+// DstOnly is called for entries only in the destination
+func (m checkpointMarcher) DstOnly(dst fs.DirEntry) (recurse bool) {
+	m.checkpoint.dstOnly(dst.Remote())
+	return m.syncCopyMove.DstOnly(dst)
+}
+
+// Match is called for entries in both the source and the destination
+func (m checkpointMarcher) Match(ctx context.Context, dst, src fs.DirEntry) (recurse bool) {
+	switch srcX := src.(type) {
+	case fs.Object:
+		dstX, ok := dst.(fs.Object)
+		if ok && m.checkpoint.deferObject(ctx, srcX, dstX) {
+			return false
+		}
+		if ok && m.checkpoint.unchanged(ctx, srcX, dstX) {
+			fs.Debugf(src, "Unchanged since checkpoint")
+			m.checkpoint.skipped(ctx, srcX, dstX)
+			m.markParentNotEmpty(src)
+			m.logger(ctx, operations.Match, srcX, dstX, nil)
+			return false
+		}
+		if !ok {
+			m.checkpoint.changed(src.Remote())
+		}
+		m.checkpoint.start(src.Remote(), false)
+	case fs.Directory:
+		recurse = m.syncCopyMove.Match(ctx, dst, src)
+		if recurse {
+			m.checkpoint.start(src.Remote(), true)
+		}
+		return recurse
+	}
+	return m.syncCopyMove.Match(ctx, dst, src)
+}
+
+// MarchDirDone is called when all the entries of each pair of
+// directories have been seen
+func (m checkpointMarcher) MarchDirDone(ctx context.Context, srcDir, dstDir string, err error) {
+	deferred, unchanged := m.checkpoint.checked(srcDir, err)
+	for _, entry := range deferred {
+		if unchanged {
+			m.markParentNotEmpty(entry.src)
+			if entry.dst != nil {
+				m.logger(ctx, operations.Match, entry.src, entry.dst, nil)
+			}
+			continue
+		}
+		m.checkpoint.start(entry.src.Remote(), false)
+		if entry.dst == nil {
+			_ = m.syncCopyMove.SrcOnly(entry.src)
+		} else {
+			_ = m.syncCopyMove.Match(ctx, entry.dst, entry.src)
+		}
+	}
+	m.checkpoint.listed(srcDir, err)
+}
+
+// checkpointDone records src as checked or transferred to dst if
+// --checkpoint-file is set
+func (s *syncCopyMove) checkpointDone(src, dst fs.Object) {
+	if s.checkpoint != nil {
+		s.checkpoint.done(s.ctx, src, dst)
+	}
+}
+
+// checkpointFailed records src as failed if --checkpoint-file is set
+func (s *syncCopyMove) checkpointFailed(src fs.Object) {
+	if s.checkpoint != nil {
+		s.checkpoint.failed(src)
+	}
+}
@@ -0,0 +1,221 @@
+// Test --checkpoint-file
+
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/accounting"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/fstest/mockfs"
+	"github.com/rclone/rclone/fstest/mockobject"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	ctx, ci := fs.AddConfig(ctx)
+	r := fstest.NewRun(t)
+	ci.CheckpointFile = filepath.Join(t.TempDir(), "checkpoint.json")
+
+	file1 := r.WriteFile("one", "one", t1)
+	file2 := r.WriteFile("dir/two", "two", t1)
+	file3 := r.WriteFile("dir/three", "three", t1)
+	extra := r.WriteObject(ctx, "extra", "extra", t1)
+
+	// Stop the syncs completing by not allowing the delete
+	ci.MaxDelete = 0
+
+	accounting.GlobalStats().ResetCounters()
+	err := Sync(ctx, r.Fremote, r.Flocal, false)
+	require.Error(t, err)
+	r.CheckRemoteItems(t, file1, file2, file3, extra)
+	assert.FileExists(t, ci.CheckpointFile)
+
+	// Everything was done so nothing needs checking - the only check
+	// is the attempt to delete extra
+	accounting.GlobalStats().ResetCounters()
+	err = Sync(ctx, r.Fremote, r.Flocal, false)
+	require.Error(t, err)
+	assert.Equal(t, int64(1), accounting.GlobalStats().GetChecks())
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetTransfers())
+
+	// Changes in the completed directory are noticed as its listing
+	// has changed so everything in it is checked again
+	file2 = r.WriteFile("dir/two", "TWO", t2)
+	file1 = r.WriteFile("one", "ONE", t2)
+	accounting.GlobalStats().ResetCounters()
+	err = Sync(ctx, r.Fremote, r.Flocal, false)
+	require.Error(t, err)
+	assert.Equal(t, int64(1+3), accounting.GlobalStats().GetChecks())
+	assert.Equal(t, 2*toyFileTransfers(r), accounting.GlobalStats().GetTransfers())
+	r.CheckRemoteItems(t, file1, file2, file3, extra)
+
+	// A copy can use the checkpoint too and removes it when done
+	accounting.GlobalStats().ResetCounters()
+	err = CopyDir(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), accounting.GlobalStats().GetChecks())
+	assert.NoFileExists(t, ci.CheckpointFile)
+
+	// Files only in the destination of a completed directory are
+	// noticed when resuming
+	err = Sync(ctx, r.Fremote, r.Flocal, false)
+	require.Error(t, err)
+	r.WriteObject(ctx, "dir/extra", "extra", t1)
+
+	// The checkpoint is removed when the sync succeeds
+	ci.MaxDelete = -1
+	accounting.GlobalStats().ResetCounters()
+	err = Sync(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+	r.CheckRemoteItems(t, file1, file2, file3)
+	assert.NoFileExists(t, ci.CheckpointFile)
+}
+
+func TestCheckpointID(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	id := checkpointID(ctx, r.Fremote, r.Flocal)
+	assert.Equal(t, id, checkpointID(ctx, r.Fremote, r.Flocal))
+
+	// Different filters make a different ID
+	opt := filter.Opt
+	opt.ExcludeRule = []string{"*.jpg"}
+	fi, err := filter.NewFilter(&opt)
+	require.NoError(t, err)
+	assert.NotEqual(t, id, checkpointID(filter.ReplaceConfig(ctx, fi), r.Fremote, r.Flocal))
+
+	// as do different flags
+	ctx, ci := fs.AddConfig(ctx)
+	ci.CheckSum = true
+	assert.NotEqual(t, id, checkpointID(ctx, r.Fremote, r.Flocal))
+}
+
+func TestCheckpointFile(t *testing.T) {
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	write := func(contents string) {
+		require.NoError(t, os.WriteFile(checkpointPath, []byte(contents), 0666))
+	}
+	const header = `{"type":"checkpoint","version":3,"id":"potato"}` + "\n"
+
+	write(header +
+		`{"type":"object","path":"a/one","src":"1","dst":"1"}` + "\n" +
+		`{"type":"object","path":"b/two","src":"2","dst":"2"}` + "\n" +
+		`{"type":"object","path":"a/sub/four","src":"4","dst":"4"}` + "\n" +
+		`{"type":"dir","path":"a/sub","listing":"L1"}` + "\n" +
+		`{"type":"dir","path":"a","listing":"L2"}` + "\n" +
+		`{"type":"object","path":"c/thr`)
+	c, err := openCheckpoint(checkpointPath, "potato", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "L2", "a/sub": "L1"}, c.dirs)
+	assert.Equal(t, map[string]map[string]string{"b": {"two": "2\x002"}}, c.objects)
+	assert.True(t, c.completed("a"))
+	assert.True(t, c.completed("a/sub"))
+	assert.False(t, c.completed("b"))
+	require.NoError(t, c.close(false))
+
+	// The file is rewritten without the superseded records
+	c, err = openCheckpoint(checkpointPath, "potato", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "L2", "a/sub": "L1"}, c.dirs)
+	assert.Equal(t, map[string]map[string]string{"b": {"two": "2\x002"}}, c.objects)
+	require.NoError(t, c.close(false))
+	data, err := os.ReadFile(checkpointPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Equal(t, strings.TrimSuffix(header, "\n"), lines[0])
+	assert.ElementsMatch(t, []string{
+		`{"type":"dir","path":"a","listing":"L2"}`,
+		`{"type":"dir","path":"a/sub","listing":"L1"}`,
+		`{"type":"object","path":"b/two","src":"2","dst":"2"}`,
+	}, lines[1:])
+
+	// A checkpoint for a different sync is ignored
+	c, err = openCheckpoint(checkpointPath, "other", "")
+	require.NoError(t, err)
+	assert.Len(t, c.dirs, 0)
+	assert.Len(t, c.objects, 0)
+	require.NoError(t, c.close(true))
+	assert.NoFileExists(t, checkpointPath)
+}
+
+func TestCheckpointTracking(t *testing.T) {
+	ctx := context.Background()
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	f, err := mockfs.NewFs(ctx, "mock", "", nil)
+	require.NoError(t, err)
+	object := func(remote string) fs.Object {
+		o := mockobject.New(remote).WithContent([]byte(remote), mockobject.SeekModeNone)
+		o.SetFs(f)
+		return o
+	}
+	c, err := openCheckpoint(checkpointPath, "potato", "")
+	require.NoError(t, err)
+
+	// a/b is complete once it is listed and its object is done
+	c.start("a", true)
+	c.start("a/one", false)
+	c.start("a/b", true)
+	c.start("a/c", true)
+	c.start("a/b/two", false)
+	c.listed("a/b", nil)
+	assert.Len(t, c.tracking["a/b"].pending, 1)
+	c.done(ctx, object("a/b/two"), nil)
+	assert.Nil(t, c.tracking["a/b"])
+
+	// a/c has an extra file in the destination so isn't complete
+	c.dstOnly("a/c/extra")
+	c.listed("a/c", nil)
+	assert.Nil(t, c.tracking["a/c"])
+
+	// so a can't be complete either
+	c.done(ctx, object("a/one"), nil)
+	c.listed("a", nil)
+	assert.Len(t, c.tracking["a"].pending, 1)
+	require.NoError(t, c.close(false))
+
+	c, err = openCheckpoint(checkpointPath, "potato", "")
+	require.NoError(t, err)
+	require.Len(t, c.dirs, 1)
+	listing := c.dirs["a/b"]
+	assert.NotEmpty(t, listing)
+	assert.Len(t, c.objects["a"], 1)
+
+	// a/b is checked when it is listed again and is unchanged if
+	// it has the same objects
+	c.start("a", true)
+	c.start("a/b", true)
+	assert.True(t, c.tracking["a/b"].checking)
+	assert.True(t, c.deferObject(ctx, object("a/b/two"), nil))
+	deferred, unchanged := c.checked("a/b", nil)
+	assert.Len(t, deferred, 1)
+	assert.True(t, unchanged)
+	c.listed("a/b", nil)
+	assert.Nil(t, c.tracking["a/b"])
+
+	// but not if it has a new object
+	c.start("a/b", true)
+	assert.True(t, c.deferObject(ctx, object("a/b/two"), nil))
+	assert.True(t, c.deferObject(ctx, object("a/b/three"), nil))
+	deferred, unchanged = c.checked("a/b", nil)
+	assert.Len(t, deferred, 2)
+	assert.False(t, unchanged)
+
+	// or an extra object in the destination
+	c.start("a/b2", true)
+	c.tracking["a/b2"].checking = true
+	c.tracking["a/b2"].listing = listing
+	assert.True(t, c.deferObject(ctx, object("a/b2/two"), nil))
+	c.dstOnly("a/b2/extra")
+	_, unchanged = c.checked("a/b2", nil)
+	assert.False(t, unchanged)
+	require.NoError(t, c.close(true))
+}
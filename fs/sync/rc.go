@@ -3,6 +3,7 @@ package sync
 import (
 	"context"
 
+	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/rc"
 )
 
@@ -11,6 +12,8 @@ func init() {
 		moveHelp := ""
 		if name == "move" {
 			moveHelp = "- deleteEmptySrcDirs - delete empty src directories if set\n"
+		} else {
+			moveHelp = "- checkpointFile - record progress in this file so an interrupted " + name + " can be resumed, as --checkpoint-file\n"
 		}
 		rc.Add(rc.Call{
 			Path:         "sync/" + name,
@@ -45,6 +48,15 @@ func rcSyncCopyMove(ctx context.Context, in rc.Params, name string) (out rc.Para
 	if rc.NotErrParamNotFound(err) {
 		return nil, err
 	}
+	checkpointFile, err := in.GetString("checkpointFile")
+	if rc.NotErrParamNotFound(err) {
+		return nil, err
+	}
+	if checkpointFile != "" {
+		var ci *fs.ConfigInfo
+		ctx, ci = fs.AddConfig(ctx)
+		ci.CheckpointFile = checkpointFile
+	}
 	switch name {
 	case "sync":
 		return nil, Sync(ctx, dstFs, srcFs, createEmptySrcDirs)
@@ -97,6 +97,7 @@ type syncCopyMove struct {
 	setDirModTimesMaxLevel int                    // max level of the directories to set
 	modifiedDirs           map[string]struct{}    // dirs with changed contents (if s.setDirModTimeAfter)
 	allowOverlap           bool                   // whether we allow src and dst to overlap (i.e. for convmv)
+	checkpoint             *checkpoint            // progress of the sync if --checkpoint-file is set
 }
 
 // For keeping track of delayed modtime sets
@@ -289,6 +290,19 @@ func newSyncCopyMove(ctx context.Context, fdst, fsrc fs.Fs, deleteMode fs.Delete
 			return nil, err
 		}
 	}
+	if ci.CheckpointFile != "" && s.deleteMode != fs.DeleteModeOnly {
+		switch {
+		case s.DoMove:
+			fs.Errorf(nil, "Ignoring --checkpoint-file with move")
+		case s.trackRenames:
+			fs.Errorf(nil, "Ignoring --checkpoint-file with --track-renames")
+		default:
+			s.checkpoint, err = openCheckpoint(ci.CheckpointFile, checkpointID(ctx, fdst, fsrc), s.dir)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	return s, nil
 }
 
@@ -386,6 +400,7 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 				if err != nil {
 					s.processError(err)
 					s.logger(s.ctx, operations.TransferError, pair.Src, pair.Dst, err)
+					s.checkpointFailed(src)
 				}
 				if NoNeedTransfer {
 					needTransfer = false
@@ -407,6 +422,7 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 					err := fs.CountError(s.ctx, fserrors.NoRetryError(fs.ErrorImmutableModified))
 					fs.Errorf(pair.Dst, "Source and destination exist but do not match: %v", err)
 					s.processError(err)
+					s.checkpointFailed(src)
 				} else {
 					if pair.Dst != nil {
 						s.markDirModifiedObject(pair.Dst)
@@ -419,6 +435,7 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 						if err != nil {
 							s.processError(err)
 							s.logger(s.ctx, operations.TransferError, pair.Src, pair.Dst, err)
+							s.checkpointFailed(src)
 						} else {
 							// If successful zero out the dst as it is no longer there and copy the file
 							pair.Dst = nil
@@ -455,6 +472,8 @@ func (s *syncCopyMove) pairChecker(in *pipe, out *pipe, fraction int, wg *sync.W
 						s.processError(deleteFileErr)
 						s.logger(s.ctx, operations.TransferError, pair.Src, pair.Dst, deleteFileErr)
 					}
+				} else {
+					s.checkpointDone(src, pair.Dst)
 				}
 			}
 		}
@@ -494,19 +513,23 @@ func (s *syncCopyMove) pairCopyOrMove(ctx context.Context, in *pipe, fdst fs.Fs,
 		}
 		src := pair.Src
 		dst := pair.Dst
+		var newDst fs.Object
 		if s.DoMove {
 			if src != dst {
-				_, err = operations.MoveTransfer(ctx, fdst, dst, src.Remote(), src)
+				newDst, err = operations.MoveTransfer(ctx, fdst, dst, src.Remote(), src)
 			} else {
 				// src == dst signals delete the src
 				err = operations.DeleteFile(ctx, src)
 			}
 		} else {
-			_, err = operations.Copy(ctx, fdst, dst, src.Remote(), src)
+			newDst, err = operations.Copy(ctx, fdst, dst, src.Remote(), src)
 		}
 		s.processError(err)
 		if err != nil {
 			s.logger(ctx, operations.TransferError, src, dst, err)
+			s.checkpointFailed(src)
+		} else {
+			s.checkpointDone(src, newDst)
 		}
 	}
 }
@@ -924,7 +947,15 @@ func (s *syncCopyMove) tryRename(src fs.Object) bool {
 // If DoMove is true then files will be moved instead of copied.
 //
 // dir is the start directory, "" for root
-func (s *syncCopyMove) run() error {
+func (s *syncCopyMove) run() (err error) {
+	if s.checkpoint != nil {
+		defer func() {
+			closeErr := s.checkpoint.close(err == nil)
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
 	if operations.Same(s.fdst, s.fsrc) && !s.allowOverlap {
 		fs.Errorf(s.fdst, "Nothing to do as source and destination are the same")
 		return nil
@@ -942,13 +973,17 @@ func (s *syncCopyMove) run() error {
 	s.startTrackRenames()
 
 	// set up a march over fdst and fsrc
+	var callback march.Marcher = s
+	if s.checkpoint != nil {
+		callback = checkpointMarcher{s}
+	}
 	m := &march.March{
 		Ctx:                    s.inCtx,
 		Fdst:                   s.fdst,
 		Fsrc:                   s.fsrc,
 		Dir:                    s.dir,
 		NoTraverse:             s.noTraverse,
-		Callback:               s,
+		Callback:               callback,
 		DstIncludeAll:          s.fi.Opt.DeleteExcluded,
 		NoCheckDest:            s.noCheckDest,
 		NoUnicodeNormalization: s.noUnicodeNormalization,
@@ -1246,8 +1281,11 @@ func (s *syncCopyMove) SrcOnly(src fs.DirEntry) (recurse bool) {
 			if err != nil {
 				s.processError(err)
 				s.logger(s.ctx, operations.TransferError, x, nil, err)
+				s.checkpointFailed(x)
 			}
-			if !NoNeedTransfer {
+			if NoNeedTransfer {
+				s.checkpointDone(x, nil)
+			} else {
 				// No need to check since doesn't exist
 				fs.Debugf(src, "Need to transfer - File not found at Destination")
 				s.markDirModifiedObject(x)
@@ -1444,6 +1444,32 @@ func TestSyncWithExcludeAndDeleteExcluded(t *testing.T) {
 	r.CheckLocalItems(t, file2)
 }
 
+// Test with ignore files which are only in the source
+func TestSyncWithIgnoreFile(t *testing.T) {
+	ctx := context.Background()
+	r := fstest.NewRun(t)
+	ignore := r.WriteFile(".rcloneignore", "*.o\n", t1)
+	file1 := r.WriteFile("a.c", "a.c", t1)
+	r.WriteFile("sub/b.o", "b.o", t1)
+	file2 := r.WriteFile("sub/c.c", "c.c", t1)
+	file3 := r.WriteObject(ctx, "keep.o", "keep.o", t1)
+	file4 := r.WriteObject(ctx, "sub/keep.o", "sub/keep.o", t1)
+	r.WriteObject(ctx, "old.c", "old.c", t1)
+
+	opt := filter.Opt
+	opt.IgnoreFile = []string{".rcloneignore"}
+	fi, err := filter.NewFilter(&opt)
+	require.NoError(t, err)
+	ctx = filter.ReplaceConfig(ctx, fi)
+
+	// The files in the destination excluded by the ignore files
+	// of the source aren't deleted
+	accounting.GlobalStats().ResetCounters()
+	err = Sync(ctx, r.Fremote, r.Flocal, false)
+	require.NoError(t, err)
+	r.CheckRemoteItems(t, ignore, file1, file2, file3, file4)
+}
+
 // Test with UpdateOlder set
 func TestSyncWithUpdateOlder(t *testing.T) {
 	ctx := context.Background()
@@ -70,7 +70,8 @@ func Walk(ctx context.Context, f fs.Fs, path string, includeAll bool, maxLevel i
 		return walkR(ctx, f, path, includeAll, maxLevel, fn, fi.MakeListR(ctx, f.NewObject))
 	}
 	// FIXME should this just be maxLevel < 0 - why the maxLevel > 1
-	if (maxLevel < 0 || maxLevel > 1) && ci.UseListR && f.Features().ListR != nil {
+	// ignore files are read as the directories are listed
+	if (maxLevel < 0 || maxLevel > 1) && ci.UseListR && f.Features().ListR != nil && len(fi.Opt.IgnoreFile) == 0 {
 		return walkListR(ctx, f, path, includeAll, maxLevel, fn)
 	}
 	return walkListDirSorted(ctx, f, path, includeAll, maxLevel, fn)
@@ -156,6 +157,7 @@ func ListR(ctx context.Context, f fs.Fs, path string, includeAll bool, maxLevel
 		fi.HaveFilesFrom() || // ...using --files-from
 		maxLevel >= 0 || // ...using bounded recursion
 		len(fi.Opt.ExcludeFile) > 0 || // ...using --exclude-file
+		len(fi.Opt.IgnoreFile) > 0 || // ...using --ignore-file
 		fi.UsesDirectoryFilters() { // ...using any directory filters
 		return listRwalk(ctx, f, path, includeAll, maxLevel, listType, fn)
 	}
@@ -587,8 +589,8 @@ func NewDirTree(ctx context.Context, f fs.Fs, path string, includeAll bool, maxL
 	if ci.NoTraverse && fi.HaveFilesFrom() {
 		return walkRDirTree(ctx, f, path, includeAll, maxLevel, fi.MakeListR(ctx, f.NewObject))
 	}
-	// if have ListR; and recursing; and not using --files-from; then build a DirTree with ListR
-	if ListR := f.Features().ListR; (maxLevel < 0 || maxLevel > 1) && ListR != nil && !fi.HaveFilesFrom() {
+	// if have ListR; and recursing; and not using --files-from or --ignore-file; then build a DirTree with ListR
+	if ListR := f.Features().ListR; (maxLevel < 0 || maxLevel > 1) && ListR != nil && !fi.HaveFilesFrom() && len(fi.Opt.IgnoreFile) == 0 {
 		return walkRDirTree(ctx, f, path, includeAll, maxLevel, ListR)
 	}
 	// otherwise just use List
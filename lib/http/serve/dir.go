@@ -30,17 +30,19 @@ type DirEntry struct {
 
 // Directory represents a directory
 type Directory struct {
-	DirRemote    string
-	Title        string
-	Name         string
-	ZipURL       string
-	DisableZip   bool
-	Entries      []DirEntry
-	Query        string
-	HTMLTemplate *template.Template
-	Breadcrumb   []Crumb
-	Sort         string
-	Order        string
+	DirRemote       string
+	Title           string
+	Name            string
+	ZipURL          string
+	DisableZip      bool
+	AllowWrite      bool  // show the controls to upload, create, rename and delete
+	UploadChunkSize int64 // size of chunks for uploading large files - 0 to upload in one go
+	Entries         []DirEntry
+	Query           string
+	HTMLTemplate    *template.Template
+	Breadcrumb      []Crumb
+	Sort            string
+	Order           string
 }
 
 // Crumb is a breadcrumb entry
@@ -0,0 +1,136 @@
+package multipart
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+)
+
+// ResumeState is the saved state of an in progress multipart upload
+// so that it can be resumed if it is interrupted.
+type ResumeState struct {
+	Fs        string    `json:"fs"`        // destination Fs as returned by fs.ConfigString
+	Remote    string    `json:"remote"`    // destination remote
+	Src       string    `json:"src"`       // fingerprint of the source object
+	ChunkSize int64     `json:"chunkSize"` // size of the chunks being uploaded
+	Chunks    []int     `json:"chunks"`    // chunk numbers uploaded so far
+	UploadID  string    `json:"uploadId"`  // ID of the upload on the remote
+	State     []byte    `json:"state"`     // backend specific state from ChunkWriterState
+	Updated   time.Time `json:"updated"`   // when this was last saved
+	path      string    // where this is saved
+}
+
+// resumeDir returns the directory the resume states are kept in
+func resumeDir() string {
+	return filepath.Join(config.GetCacheDir(), "multipart")
+}
+
+// resumePath returns the file the resume state for remote on f is
+// kept in
+func resumePath(f fs.Fs, remote string) string {
+	hash := md5.Sum([]byte(fs.ConfigString(f) + "\x00" + remote))
+	return filepath.Join(resumeDir(), hex.EncodeToString(hash[:])+".json")
+}
+
+// NewResumeState returns an empty ResumeState for uploading to remote
+// on f.
+//
+// It won't be written to disk until Save is called.
+func NewResumeState(f fs.Fs, remote string) *ResumeState {
+	return &ResumeState{
+		Fs:     fs.ConfigString(f),
+		Remote: remote,
+		path:   resumePath(f, remote),
+	}
+}
+
+// readResumeState reads the ResumeState stored in filePath
+func readResumeState(filePath string) (*ResumeState, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	rs := new(ResumeState)
+	err = json.Unmarshal(data, rs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse multipart resume state %q: %w", filePath, err)
+	}
+	rs.path = filePath
+	return rs, nil
+}
+
+// LoadResumeState loads the saved state of an upload to remote on f.
+//
+// It returns nil with no error if there isn't one.
+func LoadResumeState(f fs.Fs, remote string) (*ResumeState, error) {
+	rs, err := readResumeState(resumePath(f, remote))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return rs, err
+}
+
+// ListResumeStates returns all the saved upload states.
+//
+// States which can't be read are logged and skipped.
+func ListResumeStates() (states []*ResumeState, err error) {
+	entries, err := os.ReadDir(resumeDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list multipart resume states: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		rs, err := readResumeState(filepath.Join(resumeDir(), entry.Name()))
+		if err != nil {
+			fs.Debugf(nil, "Ignoring multipart resume state: %v", err)
+			continue
+		}
+		states = append(states, rs)
+	}
+	return states, nil
+}
+
+// Save writes the state to disk, replacing any previous version.
+func (rs *ResumeState) Save() error {
+	rs.Updated = time.Now()
+	data, err := json.Marshal(rs)
+	if err != nil {
+		return fmt.Errorf("failed to encode multipart resume state: %w", err)
+	}
+	err = os.MkdirAll(filepath.Dir(rs.path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make multipart resume state directory: %w", err)
+	}
+	tmpPath := rs.path + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write multipart resume state: %w", err)
+	}
+	err = os.Rename(tmpPath, rs.path)
+	if err != nil {
+		return fmt.Errorf("failed to write multipart resume state: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the saved state from disk if present.
+func (rs *ResumeState) Remove() error {
+	err := os.Remove(rs.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove multipart resume state: %w", err)
+	}
+	return nil
+}
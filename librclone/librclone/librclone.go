@@ -21,6 +21,7 @@ import (
 	"github.com/rclone/rclone/fs/log"
 	"github.com/rclone/rclone/fs/rc"
 	"github.com/rclone/rclone/fs/rc/jobs"
+	_ "github.com/rclone/rclone/fs/rc/schedule" // import the scheduler
 )
 
 // Initialize initializes rclone as a library
@@ -114,7 +115,7 @@ func RPC(method string, input string) (output string, status int) {
 
 	fs.Debugf(nil, "rc: %q: with parameters %+v", method, in)
 
-	_, out, err := jobs.NewJob(context.Background(), call.Fn, in)
+	_, out, err := jobs.NewCallJob(context.Background(), call, in)
 	if err != nil {
 		return writeError(method, in, err, http.StatusInternalServerError)
 	}
@@ -39,10 +39,15 @@ type Dir struct {
 	virtual map[string]vState // virtual directory entries - may be nil
 	sys     atomic.Value      // user defined info to be attached here
 
+	revalidate     bool      // set if items came from a saved listing which hasn't been checked yet
+	listingModTime time.Time // modification time of the directory in the saved listing
+
 	modTimeMu sync.Mutex // protects the following
 	modTime   time.Time
 
 	_virtuals atomic.Int32 // number of virtual directory entries in this directory and children
+
+	metadata metadataCache // metadata read for the extended attributes
 }
 
 //go:generate stringer -type=vState
@@ -250,6 +255,7 @@ func (d *Dir) ForgetAll() (hasVirtual bool) {
 	if !hasVirtual {
 		d.read = time.Time{}
 		d.items = make(map[string]Node)
+		d.revalidate = false
 		d.cleanupTimer.Stop()
 	} else {
 		d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
@@ -539,6 +545,9 @@ func (d *Dir) _readDir() error {
 	} else {
 		return nil
 	}
+	if d.read.IsZero() && d._readDirSaved(when) {
+		return nil
+	}
 	entries, err := list.DirSorted(context.TODO(), d.f, false, d.path)
 	if err == fs.ErrorDirNotFound {
 		// We treat directory not found as empty because we
@@ -576,6 +585,7 @@ func (d *Dir) _readDir() error {
 		entries = filteredEntries
 	}
 
+	oldDirs := d._subdirs()
 	err = d._readDirFromEntries(entries, nil, time.Time{})
 	if err != nil {
 		return err
@@ -583,14 +593,126 @@ func (d *Dir) _readDir() error {
 
 	d.read = time.Now()
 	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	d._saveListing(entries)
+	d._validateChildren(oldDirs)
 
 	return nil
 }
 
+// _readDirSaved sets d.items from the listing saved by a previous run
+// if there is one, returning true if it did.
+//
+// The saved listing is treated like any other cached listing so it is
+// only listed from the remote again once it has expired, or has been
+// invalidated by polling, or when d's parent is listed and shows it
+// has changed.
+//
+// must be called with the lock held
+func (d *Dir) _readDirSaved(when time.Time) bool {
+	dc := d.vfs.dirCache
+	if dc == nil || !dc.claim(d.path) {
+		return false
+	}
+	listing, err := dc.load(d.path)
+	if err != nil {
+		fs.Debugf(d.path, "Ignoring saved directory listing: %v", err)
+		return false
+	} else if listing == nil {
+		return false
+	}
+	err = d._readDirFromEntries(listing.entries(d.f), nil, time.Time{})
+	if err != nil {
+		return false
+	}
+	fs.Debugf(d.path, "Read saved directory listing (%v old)", when.Sub(listing.Time))
+	d.read = when
+	d.revalidate = true
+	d.listingModTime = listing.ModTime
+	d.cleanupTimer.Reset(time.Duration(d.vfs.Opt.DirCacheTime * 2))
+	return true
+}
+
+// _saveListing saves entries read from the remote as the listing of d
+// if listings are being saved.
+//
+// must be called with the lock held
+func (d *Dir) _saveListing(entries fs.DirEntries) {
+	d.revalidate = false
+	if dc := d.vfs.dirCache; dc != nil {
+		dc.save(d.path, d.ModTime(), entries)
+	}
+}
+
+// _subdirs returns the names of the subdirectories of d if listings
+// are being saved.
+//
+// must be called with the lock held
+func (d *Dir) _subdirs() (names []string) {
+	if d.vfs.dirCache == nil {
+		return nil
+	}
+	for name, node := range d.items {
+		if node.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// _validateChildren is called when d has just been read from the
+// remote. oldDirs are the names of the subdirectories before it was
+// read.
+//
+// It removes the saved listings of the subdirectories which have gone.
+//
+// If the remote updates directory modification times when their
+// contents change then the saved listings of the subdirectories which
+// haven't changed are marked as up to date and those which have are
+// marked to be read from the remote the next time they are used.
+//
+// must be called with the lock held
+func (d *Dir) _validateChildren(oldDirs []string) {
+	dc := d.vfs.dirCache
+	if dc == nil {
+		return
+	}
+	for _, name := range oldDirs {
+		if node, ok := d.items[name]; !ok || !node.IsDir() {
+			dc.removeTree(path.Join(d.path, name))
+		}
+	}
+	if !d.f.Features().DirModTimeUpdatesOnWrite {
+		return
+	}
+	for _, node := range d.items {
+		dir, ok := node.(*Dir)
+		if !ok {
+			continue
+		}
+		dir.mu.Lock()
+		if dir.revalidate && !dir.listingModTime.IsZero() {
+			if dir.listingModTime.Equal(dir.ModTime()) {
+				fs.Debugf(dir.path, "Saved directory listing is up to date")
+			} else {
+				fs.Debugf(dir.path, "Saved directory listing is out of date")
+				dir.read = time.Time{}
+			}
+			dir.revalidate = false
+		}
+		dir.mu.Unlock()
+	}
+}
+
 // update d.items for each dir in the DirTree below this one and
 // set the last read time - must be called with the lock held
 func (d *Dir) _readDirFromDirTree(dirTree dirtree.DirTree, when time.Time) error {
-	return d._readDirFromEntries(dirTree[d.path], dirTree, when)
+	entries := dirTree[d.path]
+	err := d._readDirFromEntries(entries, dirTree, when)
+	if err != nil {
+		return err
+	}
+	d._saveListing(entries)
+	return nil
 }
 
 // Remove the virtual directory entry leaf
@@ -738,6 +860,10 @@ func (d *Dir) _readDirFromEntries(entries fs.DirEntries, dirTree dirtree.DirTree
 		if name == "." || name == ".." {
 			continue
 		}
+		// hide the lock records stored by --vfs-lock-remote
+		if d.parent == nil && name == lockDirName && d.vfs.Opt.LockRemote {
+			continue
+		}
 		if d.vfs.Opt.Links {
 			name, _ = strings.CutSuffix(name, fs.LinkSuffix)
 		}
@@ -815,6 +941,10 @@ func (d *Dir) readDirTree() error {
 func (d *Dir) readDir() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if dc := d.vfs.dirCache; dc != nil {
+		// read from the remote not the saved listing
+		dc.claim(d.path)
+	}
 	d.read = time.Time{}
 	return d._readDir()
 }
@@ -1090,6 +1220,9 @@ func (d *Dir) Mkdir(name string) (*Dir, error) {
 		fs.Errorf(d, "Dir.Mkdir failed to create directory: %v", err)
 		return nil, err
 	}
+	if dc := d.vfs.dirCache; dc != nil {
+		dc.remove(path)
+	}
 	fsDir := fs.NewDir(path, time.Now())
 	dir := newDir(d.vfs, d.f, d, fsDir)
 	d.addObject(dir)
@@ -1122,6 +1255,9 @@ func (d *Dir) Remove() error {
 		fs.Errorf(d, "Dir.Remove failed to remove directory: %v", err)
 		return err
 	}
+	if dc := d.vfs.dirCache; dc != nil {
+		dc.remove(d.path)
+	}
 	// Remove the item from the parent directory listing
 	if d.parent != nil {
 		d.parent.delObject(d.Name())
@@ -1225,6 +1361,10 @@ func (d *Dir) Rename(oldName, newName string, destDir *Dir) error {
 			fs.Errorf(oldPath, "Dir.Rename error: %v", err)
 			return err
 		}
+		if dc := d.vfs.dirCache; dc != nil {
+			dc.removeTree(srcRemote)
+			dc.removeTree(dstRemote)
+		}
 		newDir := fs.NewDirCopy(context.TODO(), x).SetRemote(newPath)
 		// Update the node with the new details
 		if oldNode != nil {
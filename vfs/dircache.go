@@ -0,0 +1,353 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/vfs/vfscache"
+)
+
+// dirListingVersion is the version of the format of the saved listings
+const dirListingVersion = 1
+
+// dirCache saves directory listings to disk so they can be used
+// straight away when the VFS is next started with
+// --vfs-dir-cache-persist.
+//
+// The listings are kept in the vfsDir directory of the rclone cache
+// directory, one file per directory named after a hash of its path.
+type dirCache struct {
+	root string // OS path of the directory the listings are kept in
+
+	mu   sync.Mutex          // protects the following
+	used map[string]struct{} // directories whose saved listing has been used or replaced
+}
+
+// dirListing is the saved listing of a directory
+type dirListing struct {
+	Version int               `json:"version"` // version of the format
+	Path    string            `json:"path"`    // path of the directory
+	Time    time.Time         `json:"time"`    // when the directory was listed
+	ModTime time.Time         `json:"modTime"` // modification time of the directory when listed
+	Entries []dirListingEntry `json:"entries"` // the directory entries
+}
+
+// dirListingEntry is a directory entry in a dirListing
+type dirListingEntry struct {
+	Name    string    `json:"name"`            // leaf name
+	IsDir   bool      `json:"isDir,omitempty"` // set if this is a directory
+	Size    int64     `json:"size"`            // size in bytes
+	ModTime time.Time `json:"modTime"`         // modification time
+}
+
+// newDirCache makes a dirCache for f
+func newDirCache(f fs.Fs) *dirCache {
+	dc := &dirCache{
+		root: vfscache.RootOSPath("vfsDir", f),
+		used: make(map[string]struct{}),
+	}
+	fs.Debugf(f, "Directory listings are saved in %q", dc.root)
+	return dc
+}
+
+// osPath returns the OS path of the saved listing of dirPath
+func (dc *dirCache) osPath(dirPath string) string {
+	sum := sha256.Sum256([]byte(dirPath))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(dc.root, name[:2], name+".json")
+}
+
+// claim returns true the first time it is called for dirPath and
+// false afterwards, or after dirPath has been saved or removed, as
+// saved listings are only used once.
+func (dc *dirCache) claim(dirPath string) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if _, found := dc.used[dirPath]; found {
+		return false
+	}
+	dc.used[dirPath] = struct{}{}
+	return true
+}
+
+// load reads the saved listing of dirPath returning nil if there
+// isn't one
+func (dc *dirCache) load(dirPath string) (*dirListing, error) {
+	data, err := os.ReadFile(dc.osPath(dirPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	listing := new(dirListing)
+	err = json.Unmarshal(data, listing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode listing: %w", err)
+	}
+	if listing.Version != dirListingVersion || listing.Path != dirPath {
+		return nil, nil
+	}
+	return listing, nil
+}
+
+// save writes entries as the listing of dirPath which has
+// modification time modTime.
+//
+// Errors are logged rather than returned as the listing can always
+// be read from the remote.
+func (dc *dirCache) save(dirPath string, modTime time.Time, entries fs.DirEntries) {
+	dc.mu.Lock()
+	dc.used[dirPath] = struct{}{}
+	dc.mu.Unlock()
+	listing := dirListing{
+		Version: dirListingVersion,
+		Path:    dirPath,
+		Time:    time.Now(),
+		ModTime: modTime,
+		Entries: make([]dirListingEntry, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		_, isDir := entry.(fs.Directory)
+		listing.Entries = append(listing.Entries, dirListingEntry{
+			Name:    path.Base(entry.Remote()),
+			IsDir:   isDir,
+			Size:    entry.Size(),
+			ModTime: entry.ModTime(context.TODO()),
+		})
+	}
+	err := dc.write(dc.osPath(dirPath), &listing)
+	if err != nil {
+		fs.Errorf(dirPath, "Failed to save directory listing: %v", err)
+	}
+}
+
+// write listing to osPath atomically
+func (dc *dirCache) write(osPath string, listing *dirListing) error {
+	data, err := json.Marshal(listing)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(osPath)
+	err = file.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(dir, filepath.Base(osPath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), osPath)
+	}
+	if err != nil {
+		_ = os.Remove(out.Name())
+	}
+	return err
+}
+
+// remove removes the saved listing of dirPath if any
+func (dc *dirCache) remove(dirPath string) {
+	dc.mu.Lock()
+	dc.used[dirPath] = struct{}{}
+	dc.mu.Unlock()
+	err := os.Remove(dc.osPath(dirPath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fs.Errorf(dirPath, "Failed to remove saved directory listing: %v", err)
+	}
+}
+
+// removeTree removes the saved listings of dirPath and all the
+// directories below it which were in the saved listings
+func (dc *dirCache) removeTree(dirPath string) {
+	listing, err := dc.load(dirPath)
+	if err != nil {
+		fs.Debugf(dirPath, "Failed to read saved directory listing: %v", err)
+	} else if listing != nil {
+		for _, entry := range listing.Entries {
+			if entry.IsDir {
+				dc.removeTree(path.Join(dirPath, entry.Name))
+			}
+		}
+	}
+	dc.remove(dirPath)
+}
+
+// cleanUp removes all the saved listings
+func (dc *dirCache) cleanUp() error {
+	return os.RemoveAll(dc.root)
+}
+
+// entries turns the listing back into directory entries for f
+func (listing *dirListing) entries(f fs.Fs) fs.DirEntries {
+	entries := make(fs.DirEntries, 0, len(listing.Entries))
+	for _, entry := range listing.Entries {
+		remote := path.Join(listing.Path, entry.Name)
+		if entry.IsDir {
+			entries = append(entries, fs.NewDir(remote, entry.ModTime).SetSize(entry.Size))
+		} else {
+			entries = append(entries, &savedObject{
+				f:       f,
+				remote:  remote,
+				size:    entry.Size,
+				modTime: entry.ModTime,
+			})
+		}
+	}
+	return entries
+}
+
+// savedObject is an object read from a saved directory listing.
+//
+// It finds the object on the remote when something other than its
+// name, size or modification time is needed.
+type savedObject struct {
+	f       fs.Fs
+	remote  string
+	size    int64
+	modTime time.Time
+
+	mu sync.Mutex // protects the following
+	o  fs.Object  // the object on the remote once found
+}
+
+// resolve finds the object on the remote
+func (o *savedObject) resolve(ctx context.Context) (fs.Object, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.o == nil {
+		obj, err := o.f.NewObject(ctx, o.remote)
+		if err != nil {
+			return nil, err
+		}
+		o.o = obj
+	}
+	return o.o, nil
+}
+
+// resolveObject returns the object on the remote for o if it came from
+// a saved directory listing, otherwise o.
+func resolveObject(ctx context.Context, o fs.Object) (fs.Object, error) {
+	if saved, ok := o.(*savedObject); ok {
+		return saved.resolve(ctx)
+	}
+	return o, nil
+}
+
+// Fs returns the parent Fs
+func (o *savedObject) Fs() fs.Info {
+	return o.f
+}
+
+// String returns a description of the Object
+func (o *savedObject) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *savedObject) Remote() string {
+	return o.remote
+}
+
+// ModTime returns the modification time of the object
+func (o *savedObject) ModTime(ctx context.Context) time.Time {
+	return o.modTime
+}
+
+// Size returns the size of the object in bytes
+func (o *savedObject) Size() int64 {
+	return o.size
+}
+
+// Storable returns whether this object is storable
+func (o *savedObject) Storable() bool {
+	return true
+}
+
+// Hash returns the selected checksum of the object
+func (o *savedObject) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return "", err
+	}
+	return obj.Hash(ctx, ht)
+}
+
+// SetModTime sets the modification time of the object
+func (o *savedObject) SetModTime(ctx context.Context, t time.Time) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.SetModTime(ctx, t)
+}
+
+// Open opens the object for read
+func (o *savedObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return obj.Open(ctx, options...)
+}
+
+// Update replaces the contents of the object
+func (o *savedObject) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Update(ctx, in, src, options...)
+}
+
+// Remove removes the object
+func (o *savedObject) Remove(ctx context.Context) error {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	return obj.Remove(ctx)
+}
+
+// Metadata returns the metadata of the object
+func (o *savedObject) Metadata(ctx context.Context) (fs.Metadata, error) {
+	obj, err := o.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetMetadata(ctx, obj)
+}
+
+// UnWrap returns the object on the remote if it has been found
+func (o *savedObject) UnWrap() fs.Object {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.o
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Object          = (*savedObject)(nil)
+	_ fs.Metadataer      = (*savedObject)(nil)
+	_ fs.ObjectUnWrapper = (*savedObject)(nil)
+)
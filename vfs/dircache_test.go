@@ -0,0 +1,158 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config"
+	"github.com/rclone/rclone/fstest"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Use a temporary cache directory for the saved listings
+func setTestCacheDir(t *testing.T) {
+	oldCacheDir := config.GetCacheDir()
+	require.NoError(t, config.SetCacheDir(t.TempDir()))
+	t.Cleanup(func() {
+		_ = config.SetCacheDir(oldCacheDir)
+	})
+}
+
+func TestDirCacheSaveLoad(t *testing.T) {
+	setTestCacheDir(t)
+	r := fstest.NewRun(t)
+	dc := newDirCache(r.Fremote)
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+	t2 := fstest.Time("2011-12-25T12:59:59.123456789Z")
+
+	listing, err := dc.load("dir")
+	require.NoError(t, err)
+	assert.Nil(t, listing)
+
+	assert.True(t, dc.claim("dir"))
+	assert.False(t, dc.claim("dir"))
+
+	entries := fs.DirEntries{
+		fs.NewDir("dir/sub", t1),
+		&savedObject{remote: "dir/file", size: 42, modTime: t2},
+	}
+	dc.save("dir", t1, entries)
+	assert.False(t, dc.claim("dir"))
+
+	listing, err = dc.load("dir")
+	require.NoError(t, err)
+	require.NotNil(t, listing)
+	assert.Equal(t, "dir", listing.Path)
+	assert.True(t, t1.Equal(listing.ModTime))
+	got := listing.entries(r.Fremote)
+	require.Len(t, got, 2)
+	assert.Equal(t, "dir/sub", got[0].Remote())
+	assert.IsType(t, &fs.Dir{}, got[0])
+	assert.True(t, t1.Equal(got[0].ModTime(context.Background())))
+	assert.Equal(t, "dir/file", got[1].Remote())
+	assert.IsType(t, &savedObject{}, got[1])
+	assert.Equal(t, int64(42), got[1].Size())
+	assert.True(t, t2.Equal(got[1].ModTime(context.Background())))
+
+	// A listing for a different path isn't found
+	listing, err = dc.load("dir2")
+	require.NoError(t, err)
+	assert.Nil(t, listing)
+
+	dc.remove("dir")
+	listing, err = dc.load("dir")
+	require.NoError(t, err)
+	assert.Nil(t, listing)
+
+	require.NoError(t, dc.cleanUp())
+	_, err = os.Stat(dc.root)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDirCachePersist(t *testing.T) {
+	setTestCacheDir(t)
+	opt := vfscommon.Opt
+	opt.DirCachePersist = true
+	r, vfs := newTestVFSOpt(t, &opt)
+
+	file1 := r.WriteObject(context.Background(), "dir/one", "one", t1)
+	file2 := r.WriteObject(context.Background(), "two", "two", t1)
+
+	// Read the directories to save the listings
+	_, err := vfs.ReadDir("")
+	require.NoError(t, err)
+	_, err = vfs.ReadDir("dir")
+	require.NoError(t, err)
+
+	// Change the remote behind the VFS's back
+	obj, err := r.Fremote.NewObject(context.Background(), file2.Path)
+	require.NoError(t, err)
+	require.NoError(t, obj.Remove(context.Background()))
+
+	// A new VFS shows the saved listing
+	opt2 := opt
+	opt2.DirCacheTime = fs.Duration(time.Second)
+	vfs2 := New(r.Fremote, &opt2)
+	defer vfs2.Shutdown()
+	readNames := func() (names []string) {
+		infos, err := vfs2.ReadDir("")
+		require.NoError(t, err)
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		return names
+	}
+	assert.Equal(t, []string{"dir", "two"}, readNames())
+
+	// Files from the saved listing can be read
+	data, err := vfs2.ReadFile(file1.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+
+	// The saved listing isn't checked until it expires
+	assert.Equal(t, []string{"dir", "two"}, readNames())
+	dc := vfs2.dirCache
+	listing, err := dc.load("dir")
+	require.NoError(t, err)
+	require.NotNil(t, listing)
+
+	// Remove dir from the remote then once the listing has expired
+	// it is read from the remote again and the saved listing of dir
+	// is removed
+	obj, err = r.Fremote.NewObject(context.Background(), file1.Path)
+	require.NoError(t, err)
+	require.NoError(t, obj.Remove(context.Background()))
+	require.NoError(t, r.Fremote.Rmdir(context.Background(), "dir"))
+	time.Sleep(time.Duration(opt2.DirCacheTime) + 100*time.Millisecond)
+	assert.Equal(t, []string(nil), readNames())
+	listing, err = dc.load("dir")
+	require.NoError(t, err)
+	assert.Nil(t, listing)
+}
+
+func TestDirCacheRemoveTree(t *testing.T) {
+	setTestCacheDir(t)
+	r := fstest.NewRun(t)
+	dc := newDirCache(r.Fremote)
+	t1 := fstest.Time("2001-02-03T04:05:06.499999999Z")
+
+	dc.save("dir", t1, fs.DirEntries{fs.NewDir("dir/sub", t1)})
+	dc.save("dir/sub", t1, fs.DirEntries{fs.NewDir("dir/sub/subsub", t1)})
+	dc.save("dir/sub/subsub", t1, nil)
+	dc.save("other", t1, nil)
+
+	dc.removeTree("dir")
+	for _, dirPath := range []string{"dir", "dir/sub", "dir/sub/subsub"} {
+		listing, err := dc.load(dirPath)
+		require.NoError(t, err)
+		assert.Nil(t, listing, dirPath)
+	}
+	listing, err := dc.load("other")
+	require.NoError(t, err)
+	assert.NotNil(t, listing)
+}
@@ -21,6 +21,9 @@ const (
 	EROFS
 	ENOSYS
 	ELOOP
+	EAGAIN
+	ENOATTR
+	ENOTSUP
 )
 
 // Errors which have exact counterparts in os
@@ -40,6 +43,9 @@ var errorNames = []string{
 	EROFS:     "Read only file system",
 	ENOSYS:    "Function not implemented",
 	ELOOP:     "Too many symbolic links",
+	EAGAIN:    "Resource temporarily unavailable",
+	ENOATTR:   "No such attribute",
+	ENOTSUP:   "Operation not supported",
 }
 
 // Error renders the error as a string
@@ -58,6 +58,7 @@ type File struct {
 	nwriters         atomic.Int32                    // len(writers)
 	appendMode       bool                            // file was opened with O_APPEND
 	isLink           bool                            // file represents a symlink
+	metadata         metadataCache                   // metadata read for the extended attributes
 }
 
 // newFile creates a new File
@@ -233,6 +234,7 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 	d := f.d
 	oldPendingRenameFun := f.pendingRenameFun
 	oldPath := f._cachePath()
+	oldName := f._path()
 	newCacheName := f._fixCachePath(newName)
 	f.mu.RUnlock()
 
@@ -265,6 +267,13 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 				return nil // no need to rename
 			}
 
+			// find the remote object if it came from a saved listing
+			o, err = resolveObject(ctx, o)
+			if err != nil {
+				fs.Errorf(f.Path(), "File.Rename error: %v", err)
+				return err
+			}
+
 			// do the move of the remote object
 			dstOverwritten, _ := d.Fs().NewObject(ctx, newPath)
 			newObject, err = operations.Move(ctx, d.Fs(), dstOverwritten, newPath, o)
@@ -308,6 +317,9 @@ func (f *File) rename(ctx context.Context, destDir *Dir, newName string) error {
 	writing := f._writingInProgress()
 	f.mu.Unlock()
 
+	// the advisory locks move with the file
+	d.vfs.locks.rename(ctx, oldName, path.Join(dPath, newName))
+
 	// Delay the rename if not using RW caching. For the minimal case we
 	// need to look in the cache to see if caching is in use.
 	CacheMode := d.vfs.Opt.CacheMode
@@ -503,6 +515,7 @@ func (f *File) _applyPendingModTime() error {
 	switch err {
 	case nil:
 		fs.Debugf(f.o, "Applied pending mod time %v OK", f.pendingModTime)
+		f.metadata.clear()
 	case fs.ErrorCantSetModTime, fs.ErrorCantSetModTimeWithoutDelete:
 		// do nothing, in order to not break "touch somefile" if it exists already
 	default:
@@ -0,0 +1,597 @@
+package vfs
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+	"github.com/rclone/rclone/lib/random"
+)
+
+// LockType is the type of an advisory lock
+type LockType byte
+
+// Types of lock
+const (
+	LockUnlock LockType = iota // release the lock
+	LockRead                   // shared lock
+	LockWrite                  // exclusive lock
+)
+
+// String turns a LockType into a string
+func (t LockType) String() string {
+	switch t {
+	case LockUnlock:
+		return "unlock"
+	case LockRead:
+		return "read"
+	case LockWrite:
+		return "write"
+	}
+	return fmt.Sprintf("LockType(%d)", t)
+}
+
+// LockEOF as the End of a Lock means the lock extends to the end of
+// the file however big it gets.
+const LockEOF = math.MaxInt64
+
+// Lock describes an advisory lock on a range of bytes of a file.
+//
+// These are used to implement flock and POSIX (fcntl) locks. flock
+// locks are locks on the whole file.
+type Lock struct {
+	Type  LockType // type of the lock
+	Start int64    // first byte locked
+	End   int64    // last byte locked, or LockEOF
+	Owner uint64   // identifies the holder of the lock, eg the lock owner from the kernel
+	Pid   uint32   // process holding the lock if known, 0 if held by another host
+}
+
+// overlaps returns true if the ranges of a and b overlap
+func (a *Lock) overlaps(b *Lock) bool {
+	return a.Start <= b.End && b.Start <= a.End
+}
+
+// excludes returns true if a and b can't both be held by different owners
+func (a *Lock) excludes(b *Lock) bool {
+	return a.overlaps(b) && (a.Type == LockWrite || b.Type == LockWrite)
+}
+
+// conflicts returns true if a and b can't both be held
+func (a *Lock) conflicts(b *Lock) bool {
+	return a.Owner != b.Owner && a.excludes(b)
+}
+
+// applyLock returns the locks owned after lk is applied to the
+// sorted locks held by the same owner.
+//
+// As with POSIX locks, lk replaces any part of the existing locks it
+// overlaps and adjacent locks of the same type are merged.
+func applyLock(owned []Lock, lk Lock) []Lock {
+	var out []Lock
+	for _, old := range owned {
+		if !old.overlaps(&lk) {
+			out = append(out, old)
+			continue
+		}
+		if old.Start < lk.Start {
+			before := old
+			before.End = lk.Start - 1
+			out = append(out, before)
+		}
+		if old.End > lk.End {
+			after := old
+			after.Start = lk.End + 1
+			out = append(out, after)
+		}
+	}
+	if lk.Type != LockUnlock {
+		out = append(out, lk)
+	}
+	slices.SortFunc(out, func(a, b Lock) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	merged := out[:0]
+	for _, l := range out {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.Type == l.Type && last.End != LockEOF && last.End+1 == l.Start {
+				last.End = l.End
+				continue
+			}
+		}
+		merged = append(merged, l)
+	}
+	return merged
+}
+
+// lockManager keeps track of the advisory locks on the files in the VFS
+type lockManager struct {
+	remote *remoteLocks // if set, locks are shared with other hosts
+	setMu  sync.Mutex   // serializes changes to locks when using remote
+
+	mu      sync.Mutex                   // protects the following
+	files   map[string]map[uint64][]Lock // locks by file path then owner
+	changed chan struct{}                // closed when a lock is released
+}
+
+// newLockManager makes a lockManager for vfs
+func newLockManager(ctx context.Context, vfs *VFS) *lockManager {
+	lm := &lockManager{
+		files:   make(map[string]map[uint64][]Lock),
+		changed: make(chan struct{}),
+	}
+	if vfs.Opt.LockRemote {
+		ttl := time.Duration(vfs.Opt.LockRemoteTTL)
+		if ttl <= 0 {
+			ttl = time.Minute
+		}
+		lm.remote = newRemoteLocks(vfs.f, ttl)
+		go lm.refresher(ctx, ttl/3)
+	}
+	return lm
+}
+
+// _conflict returns the first lock held locally which conflicts with lk
+//
+// call with mu held
+func (lm *lockManager) _conflict(name string, lk *Lock) *Lock {
+	for _, locks := range lm.files[name] {
+		for i := range locks {
+			if locks[i].conflicts(lk) {
+				found := locks[i]
+				return &found
+			}
+		}
+	}
+	return nil
+}
+
+// _setOwned sets the locks on name held by owner
+//
+// call with mu held
+func (lm *lockManager) _setOwned(name string, owner uint64, locks []Lock) {
+	owners := lm.files[name]
+	if len(locks) == 0 {
+		delete(owners, owner)
+		if len(owners) == 0 {
+			delete(lm.files, name)
+		}
+	} else {
+		if owners == nil {
+			owners = make(map[uint64][]Lock)
+			lm.files[name] = owners
+		}
+		owners[owner] = locks
+	}
+	// wake anything waiting for a lock
+	close(lm.changed)
+	lm.changed = make(chan struct{})
+}
+
+// test returns a lock which would stop lk being taken on name or nil
+// if there isn't one
+func (lm *lockManager) test(ctx context.Context, name string, lk Lock) (*Lock, error) {
+	lm.mu.Lock()
+	conflict := lm._conflict(name, &lk)
+	lm.mu.Unlock()
+	if conflict != nil || lm.remote == nil {
+		return conflict, nil
+	}
+	return lm.remote.conflict(ctx, name, &lk)
+}
+
+// set applies lk to name returning EAGAIN if it conflicts with a
+// lock held by someone else
+func (lm *lockManager) set(ctx context.Context, name string, lk Lock) error {
+	if lm.remote != nil {
+		lm.setMu.Lock()
+		defer lm.setMu.Unlock()
+	}
+	lm.mu.Lock()
+	if lk.Type != LockUnlock && lm._conflict(name, &lk) != nil {
+		lm.mu.Unlock()
+		return EAGAIN
+	}
+	owned := lm.files[name][lk.Owner]
+	if lk.Type == LockUnlock && len(owned) == 0 {
+		// nothing to do - this is the common case on close
+		lm.mu.Unlock()
+		return nil
+	}
+	locks := applyLock(owned, lk)
+	if lm.remote == nil {
+		lm._setOwned(name, lk.Owner, locks)
+		lm.mu.Unlock()
+		return nil
+	}
+	lm.mu.Unlock()
+
+	// Changes to locks are serialized by setMu so the local locks
+	// can't change while the remote is updated
+	err := lm.remote.set(ctx, name, lk, owned, locks)
+	if err != nil {
+		return err
+	}
+	lm.mu.Lock()
+	lm._setOwned(name, lk.Owner, locks)
+	lm.mu.Unlock()
+	return nil
+}
+
+// wait applies lk to name, waiting until any conflicting locks are
+// released or ctx is cancelled
+func (lm *lockManager) wait(ctx context.Context, name string, lk Lock) error {
+	for {
+		lm.mu.Lock()
+		changed := lm.changed
+		lm.mu.Unlock()
+		err := lm.set(ctx, name, lk)
+		if err != EAGAIN {
+			return err
+		}
+		// Locks held on other hosts have to be polled for
+		var poll <-chan time.Time
+		if lm.remote != nil {
+			poll = time.After(lm.remote.pollInterval())
+		}
+		select {
+		case <-changed:
+		case <-poll:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// rename moves the locks on oldName to newName
+func (lm *lockManager) rename(ctx context.Context, oldName, newName string) {
+	if lm.remote != nil {
+		lm.setMu.Lock()
+		defer lm.setMu.Unlock()
+	}
+	lm.mu.Lock()
+	owners, found := lm.files[oldName]
+	if found {
+		delete(lm.files, oldName)
+		lm.files[newName] = owners
+	}
+	lm.mu.Unlock()
+	if !found || lm.remote == nil {
+		return
+	}
+	for owner, locks := range owners {
+		err := lm.remote.publish(ctx, oldName, owner, nil)
+		if err == nil {
+			err = lm.remote.publish(ctx, newName, owner, locks)
+		}
+		if err != nil {
+			fs.Errorf(newName, "vfs locks: failed to move lock record: %v", err)
+		}
+	}
+}
+
+// refresher rewrites the published records every interval so they
+// don't expire and removes them when ctx is cancelled.
+func (lm *lockManager) refresher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			lm.setMu.Lock()
+			lm.remote.removeAll()
+			lm.setMu.Unlock()
+			return
+		}
+		for _, key := range lm.remote.publishedKeys() {
+			lm.refresh(ctx, key)
+		}
+	}
+}
+
+// refresh rewrites the record for key if it is still published
+func (lm *lockManager) refresh(ctx context.Context, key remoteKey) {
+	// Changes to locks are serialized by setMu so the record can't
+	// be removed while it is rewritten
+	lm.setMu.Lock()
+	defer lm.setMu.Unlock()
+	lm.remote.mu.Lock()
+	locks, found := lm.remote.published[key]
+	lm.remote.mu.Unlock()
+	if !found {
+		return
+	}
+	err := lm.remote.publish(ctx, key.name, key.owner, locks)
+	if err != nil && ctx.Err() == nil {
+		fs.Errorf(key.name, "vfs locks: failed to refresh lock record: %v", err)
+	}
+}
+
+// lockDirName is the directory at the root of the remote the lock
+// records are kept in with --vfs-lock-remote
+const lockDirName = ".rclone-locks"
+
+// remoteLockRecord is stored on the remote to describe the locks
+// on a file held by one owner in one VFS
+type remoteLockRecord struct {
+	Path    string        `json:"path"`    // path of the locked file
+	Host    string        `json:"host"`    // host holding the locks - for information only
+	Expires time.Time     `json:"expires"` // locks are ignored after this time
+	Locks   []remoteRange `json:"locks"`   // the locks held
+}
+
+// remoteRange is a lock in a remoteLockRecord
+type remoteRange struct {
+	Type  LockType `json:"type"`
+	Start int64    `json:"start"`
+	End   int64    `json:"end"`
+}
+
+// remoteKey identifies a published remoteLockRecord
+type remoteKey struct {
+	name  string
+	owner uint64
+}
+
+// remoteLocks stores lock records on the remote so VFSes on other
+// hosts using the same remote can see them.
+//
+// A lock is taken by checking there are no conflicting records,
+// writing a record for it, then checking again. If there is a
+// conflicting record the second time the record is removed and the
+// lock fails, which is safe as long as listings show the objects
+// written before them.
+type remoteLocks struct {
+	f    fs.Fs
+	id   string        // identifies this VFS in the lock record names
+	host string        // name of this host
+	ttl  time.Duration // records expire this long after being written
+
+	mu        sync.Mutex           // protects the following
+	published map[remoteKey][]Lock // records written by this VFS
+}
+
+// newRemoteLocks makes a remoteLocks storing records in f
+func newRemoteLocks(f fs.Fs, ttl time.Duration) *remoteLocks {
+	host, _ := os.Hostname()
+	return &remoteLocks{
+		f:         f,
+		id:        random.String(16),
+		host:      host,
+		ttl:       ttl,
+		published: make(map[remoteKey][]Lock),
+	}
+}
+
+// dir returns the directory the records for the file name are kept in
+func (rl *remoteLocks) dir(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return path.Join(lockDirName, hex.EncodeToString(sum[:16]))
+}
+
+// recordName returns the name of the record for name and owner
+func (rl *remoteLocks) recordName(name string, owner uint64) string {
+	return path.Join(rl.dir(name), rl.id+"-"+strconv.FormatUint(owner, 16)+".json")
+}
+
+// pollInterval returns how often to check for locks on other hosts
+// being released when waiting
+func (rl *remoteLocks) pollInterval() time.Duration {
+	return min(time.Second, rl.ttl/4)
+}
+
+// others reads the unexpired locks on name held by other VFSes
+func (rl *remoteLocks) others(ctx context.Context, name string) (locks []Lock, err error) {
+	entries, err := rl.f.List(ctx, rl.dir(name))
+	if errors.Is(err, fs.ErrorDirNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list lock records: %w", err)
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		o, ok := entry.(fs.Object)
+		if !ok || strings.HasPrefix(path.Base(o.Remote()), rl.id+"-") {
+			continue
+		}
+		record, err := rl.read(ctx, o)
+		if err != nil {
+			// the record may have just been removed
+			fs.Debugf(o, "vfs locks: ignoring lock record: %v", err)
+			continue
+		}
+		if record.Path != name || now.After(record.Expires) {
+			continue
+		}
+		for _, r := range record.Locks {
+			locks = append(locks, Lock{Type: r.Type, Start: r.Start, End: r.End})
+		}
+	}
+	return locks, nil
+}
+
+// read the lock record in o
+func (rl *remoteLocks) read(ctx context.Context, o fs.Object) (*remoteLockRecord, error) {
+	in, err := o.Open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(in)
+	closeErr := in.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := new(remoteLockRecord)
+	err = json.Unmarshal(data, record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// conflict returns a lock held by another VFS which conflicts with
+// lk or nil if there isn't one
+func (rl *remoteLocks) conflict(ctx context.Context, name string, lk *Lock) (*Lock, error) {
+	locks, err := rl.others(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// locks held by other VFSes never have the same owner
+	for i := range locks {
+		if locks[i].excludes(lk) {
+			return &locks[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// set publishes locks as the locks held on name by lk.Owner after
+// applying lk, restoring owned if another VFS holds a conflicting
+// lock.
+func (rl *remoteLocks) set(ctx context.Context, name string, lk Lock, owned, locks []Lock) error {
+	if lk.Type == LockUnlock {
+		return rl.publish(ctx, name, lk.Owner, locks)
+	}
+	conflict, err := rl.conflict(ctx, name, &lk)
+	if err != nil {
+		return err
+	}
+	if conflict != nil {
+		return EAGAIN
+	}
+	err = rl.publish(ctx, name, lk.Owner, locks)
+	if err != nil {
+		return err
+	}
+	// Check again in case another VFS took a lock at the same time
+	conflict, err = rl.conflict(ctx, name, &lk)
+	if err == nil && conflict == nil {
+		return nil
+	}
+	restoreErr := rl.publish(ctx, name, lk.Owner, owned)
+	if restoreErr != nil {
+		fs.Errorf(name, "vfs locks: failed to restore lock record: %v", restoreErr)
+	}
+	if err != nil {
+		return err
+	}
+	return EAGAIN
+}
+
+// publish writes the record of the locks on name held by owner,
+// removing it if there are none.
+func (rl *remoteLocks) publish(ctx context.Context, name string, owner uint64, locks []Lock) error {
+	remote := rl.recordName(name, owner)
+	key := remoteKey{name: name, owner: owner}
+	if len(locks) == 0 {
+		rl.mu.Lock()
+		delete(rl.published, key)
+		rl.mu.Unlock()
+		o, err := rl.f.NewObject(ctx, remote)
+		if err == nil {
+			err = o.Remove(ctx)
+		}
+		if err != nil && !errors.Is(err, fs.ErrorObjectNotFound) {
+			return fmt.Errorf("failed to remove lock record: %w", err)
+		}
+		// Tidy up the directory if it is empty
+		_ = rl.f.Rmdir(ctx, rl.dir(name))
+		return nil
+	}
+	record := remoteLockRecord{
+		Path:    name,
+		Host:    rl.host,
+		Expires: time.Now().Add(rl.ttl),
+	}
+	for _, lk := range locks {
+		record.Locks = append(record.Locks, remoteRange{Type: lk.Type, Start: lk.Start, End: lk.End})
+	}
+	data, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+	info := object.NewStaticObjectInfo(remote, time.Now(), int64(len(data)), true, nil, rl.f)
+	_, err = rl.f.Put(ctx, bytes.NewReader(data), info)
+	if err != nil {
+		return fmt.Errorf("failed to write lock record: %w", err)
+	}
+	rl.mu.Lock()
+	rl.published[key] = locks
+	rl.mu.Unlock()
+	return nil
+}
+
+// publishedKeys returns the keys of the records written by this VFS
+func (rl *remoteLocks) publishedKeys() []remoteKey {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	keys := make([]remoteKey, 0, len(rl.published))
+	for key := range rl.published {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// removeAll removes the records written by this VFS
+func (rl *remoteLocks) removeAll() {
+	ctx := context.Background()
+	for _, key := range rl.publishedKeys() {
+		err := rl.publish(ctx, key.name, key.owner, nil)
+		if err != nil {
+			fs.Errorf(key.name, "vfs locks: %v", err)
+		}
+	}
+}
+
+// Lock applies the advisory lock lk to the file.
+//
+// If lk.Type is LockUnlock then the locks held by lk.Owner in the
+// range are released.
+//
+// If someone else holds a conflicting lock it returns EAGAIN, unless
+// wait is set in which case it waits for the lock until ctx is
+// cancelled.
+func (f *File) Lock(ctx context.Context, lk Lock, wait bool) error {
+	lm := f.VFS().locks
+	if lk.Type == LockUnlock || !wait {
+		return lm.set(ctx, f.Path(), lk)
+	}
+	return lm.wait(ctx, f.Path(), lk)
+}
+
+// TestLock returns a lock held by someone else which would stop lk
+// being taken on the file, or nil if there isn't one.
+func (f *File) TestLock(ctx context.Context, lk Lock) (*Lock, error) {
+	return f.VFS().locks.test(ctx, f.Path(), lk)
+}
+
+// Unlock releases all the advisory locks on the file held by owner.
+func (f *File) Unlock(ctx context.Context, owner uint64) error {
+	return f.VFS().locks.set(ctx, f.Path(), Lock{
+		Type:  LockUnlock,
+		Start: 0,
+		End:   LockEOF,
+		Owner: owner,
+	})
+}
@@ -0,0 +1,168 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyLock(t *testing.T) {
+	rd := func(start, end int64) Lock { return Lock{Type: LockRead, Start: start, End: end} }
+	wr := func(start, end int64) Lock { return Lock{Type: LockWrite, Start: start, End: end} }
+	un := func(start, end int64) Lock { return Lock{Type: LockUnlock, Start: start, End: end} }
+	for _, test := range []struct {
+		name  string
+		owned []Lock
+		lk    Lock
+		want  []Lock
+	}{
+		{"new", nil, wr(0, 9), []Lock{wr(0, 9)}},
+		{"merge adjacent", []Lock{rd(0, 9)}, rd(10, 19), []Lock{rd(0, 19)}},
+		{"no merge different type", []Lock{rd(0, 9)}, wr(10, 19), []Lock{rd(0, 9), wr(10, 19)}},
+		{"convert", []Lock{rd(0, 9)}, wr(0, 9), []Lock{wr(0, 9)}},
+		{"split", []Lock{rd(0, 29)}, wr(10, 19), []Lock{rd(0, 9), wr(10, 19), rd(20, 29)}},
+		{"unlock middle", []Lock{wr(0, LockEOF)}, un(10, 19), []Lock{wr(0, 9), wr(20, LockEOF)}},
+		{"unlock all", []Lock{rd(0, 9), wr(20, 29)}, un(0, LockEOF), nil},
+		{"unlock nothing", []Lock{rd(0, 9)}, un(10, 19), []Lock{rd(0, 9)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := applyLock(test.owned, test.lk)
+			if len(test.want) == 0 {
+				assert.Empty(t, got)
+			} else {
+				assert.Equal(t, test.want, got)
+			}
+		})
+	}
+}
+
+// getFile returns the File at name in vfs
+func getFile(t *testing.T, vfs *VFS, name string) *File {
+	node, err := vfs.Stat(name)
+	require.NoError(t, err)
+	file, ok := node.(*File)
+	require.True(t, ok)
+	return file
+}
+
+func TestFileLock(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	ctx := context.Background()
+	r.WriteObject(ctx, "file", "data", t1)
+	file := getFile(t, vfs, "file")
+
+	whole := func(typ LockType, owner uint64) Lock {
+		return Lock{Type: typ, Start: 0, End: LockEOF, Owner: owner, Pid: uint32(owner)}
+	}
+
+	// Shared locks can be held together
+	require.NoError(t, file.Lock(ctx, whole(LockRead, 1), false))
+	require.NoError(t, file.Lock(ctx, whole(LockRead, 2), false))
+
+	// But not with an exclusive lock
+	assert.Equal(t, EAGAIN, file.Lock(ctx, whole(LockWrite, 3), false))
+	conflict, err := file.TestLock(ctx, whole(LockWrite, 3))
+	require.NoError(t, err)
+	require.NotNil(t, conflict)
+	assert.Equal(t, LockRead, conflict.Type)
+
+	// Non overlapping ranges don't conflict
+	require.NoError(t, file.Unlock(ctx, 2))
+	require.NoError(t, file.Lock(ctx, Lock{Type: LockRead, Start: 0, End: 9, Owner: 2}, false))
+	require.NoError(t, file.Unlock(ctx, 1))
+	require.NoError(t, file.Lock(ctx, Lock{Type: LockWrite, Start: 10, End: LockEOF, Owner: 3}, false))
+	conflict, err = file.TestLock(ctx, whole(LockRead, 4))
+	require.NoError(t, err)
+	require.NotNil(t, conflict)
+
+	// Waiting for a lock succeeds when the conflicting locks are released
+	done := make(chan error)
+	go func() {
+		done <- file.Lock(ctx, whole(LockWrite, 4), true)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("lock didn't wait: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, file.Unlock(ctx, 2))
+	require.NoError(t, file.Unlock(ctx, 3))
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for lock")
+	}
+
+	// Waiting can be cancelled
+	cancelCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, file.Lock(cancelCtx, whole(LockRead, 5), true))
+
+	// Locks follow the file when it is renamed
+	require.NoError(t, vfs.Rename("file", "file2"))
+	file2 := getFile(t, vfs, "file2")
+	assert.Equal(t, EAGAIN, file2.Lock(ctx, whole(LockRead, 5), false))
+	require.NoError(t, file2.Unlock(ctx, 4))
+	require.NoError(t, file2.Lock(ctx, whole(LockRead, 5), false))
+	require.NoError(t, file2.Unlock(ctx, 5))
+}
+
+func TestFileLockRemote(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.LockRemote = true
+	r, vfs1 := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	r.WriteObject(ctx, "file", "data", t1)
+
+	// A second VFS as if on another host
+	opt2 := opt
+	opt2.LockRemoteTTL = fs.Duration(time.Hour)
+	vfs2 := New(r.Fremote, &opt2)
+	defer vfs2.Shutdown()
+	require.NotEqual(t, vfs1, vfs2)
+
+	file1 := getFile(t, vfs1, "file")
+	file2 := getFile(t, vfs2, "file")
+	lk := Lock{Type: LockWrite, Start: 0, End: LockEOF, Owner: 1}
+
+	// A lock taken in one VFS is seen by the other
+	require.NoError(t, file1.Lock(ctx, lk, false))
+	assert.Equal(t, EAGAIN, file2.Lock(ctx, lk, false))
+	conflict, err := file2.TestLock(ctx, lk)
+	require.NoError(t, err)
+	require.NotNil(t, conflict)
+	assert.Equal(t, LockWrite, conflict.Type)
+	assert.Equal(t, uint32(0), conflict.Pid)
+
+	// The lock records aren't shown in the VFS
+	_, err = vfs1.Stat(lockDirName)
+	assert.Equal(t, ENOENT, err)
+
+	// Once released the other VFS can take it
+	require.NoError(t, file1.Unlock(ctx, 1))
+	require.NoError(t, file2.Lock(ctx, lk, false))
+	assert.Equal(t, EAGAIN, file1.Lock(ctx, lk, false))
+	require.NoError(t, file2.Unlock(ctx, 1))
+
+	// Refreshing a record which has been released doesn't bring it back
+	require.NoError(t, file1.Lock(ctx, lk, false))
+	keys := vfs1.locks.remote.publishedKeys()
+	require.Len(t, keys, 1)
+	require.NoError(t, file1.Unlock(ctx, 1))
+	vfs1.locks.refresh(ctx, keys[0])
+	require.NoError(t, file2.Lock(ctx, lk, false))
+	require.NoError(t, file2.Unlock(ctx, 1))
+
+	// Expired records are ignored
+	vfs2.locks.remote.ttl = -time.Second
+	require.NoError(t, file2.Lock(ctx, lk, false))
+	require.NoError(t, file1.Lock(ctx, lk, false))
+	require.NoError(t, file1.Unlock(ctx, 1))
+	require.NoError(t, file2.Unlock(ctx, 1))
+}
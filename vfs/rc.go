@@ -11,6 +11,7 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/cache"
 	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscache"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
 )
 
@@ -403,11 +404,21 @@ This returns stats for the selected VFS.
         "diskCache": {
             "bytesUsed": 0,
             "erroredFiles": 0,
+            // Counters for --vfs-cache-eviction
+            "eviction": {
+                "evicted": 0,
+                "evictedBytes": 0,
+                "hits": 0,
+                "misses": 0,
+                "policy": "lru"
+            },
             "files": 0,
             "hashType": 1,
             "outOfSpace": false,
             "path": "/home/user/.cache/rclone/vfs/local/mnt/a",
             "pathMeta": "/home/user/.cache/rclone/vfsMeta/local/mnt/a",
+            // Status of the pins - see vfs/pinned
+            "pinned": [],
             "uploadsInProgress": 0,
             "uploadsQueued": 0
         },
@@ -460,6 +471,7 @@ the |--vfs-cache-mode| is off, it will return an empty result.
                 "tries":     1,        // integer: number of times we have tried to upload
                 "delay":     5.0,      // float: seconds between upload attempts
                 "uploading": false,    // boolean: true if item is being uploaded
+                "conflict":  false,    // boolean: true if not uploaded as the remote changed
             },
        ],
     }
@@ -471,6 +483,14 @@ only transfers |--transfers| files at once, only the lowest
 may be files with negative expiry times for which |uploading| is
 |false|.
 
+If |--vfs-write-back-conflict abort| is in use then files which
+weren't uploaded because the remote file changed since they were
+opened will have |conflict| set to |true|. These stay in the queue
+without being retried until they are renamed or their expiry is set
+with |vfs/queue-set-expiry|. Setting the expiry overwrites the changed
+remote file whereas after a rename the new name is checked for
+conflicts as usual.
+
 `, "|", "`") + getVFSHelp,
 		Fn: rcQueue,
 	})
@@ -506,6 +526,9 @@ for a long time then set it to a large positive number.
 Setting the |expiry| of an item which has already has started uploading
 will have no effect - the item will carry on being uploaded.
 
+Setting the |expiry| of an item which has |conflict| set retries it,
+overwriting the remote file which changed since it was opened.
+
 This will return an error if called with |--vfs-cache-mode| off or if
 the |id| passed is not found.
 
@@ -554,3 +577,139 @@ func rcQueueSetExpiry(ctx context.Context, in rc.Params) (out rc.Params, err err
 	err = vfs.cache.QueueSetExpiry(writeback.Handle(id), refTime, time.Duration(float64(time.Second)*expiry))
 	return nil, err
 }
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pin",
+		Title: "Pin files so they are kept in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This downloads the files chosen into the VFS cache in the background
+and stops them being removed from it by |--vfs-cache-max-age| or
+|--vfs-cache-max-size|, so they can be read while the remote is
+unavailable. Pins are remembered and restored when the cache is next
+started.
+
+This needs |--vfs-cache-mode full|.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |path| - the file or directory to pin, relative to the root of the VFS (optional - the default is the root)
+- |filter| - a list of filter rules, like |["+ *.jpg"]|, choosing the files under |path| to pin (optional)
+
+Files not chosen by the |filter| rules are not pinned. Pinning the
+same |path| and |filter| again restarts the download.
+
+This returns the status of the pin as described in |vfs/pinned|.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPin,
+	})
+}
+
+func rcPin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using the VFS cache"))
+	}
+	var pin vfscache.Pin
+	pin.Path, err = in.GetString("path")
+	if err != nil && !rc.IsErrParamNotFound(err) {
+		return nil, err
+	}
+	err = in.GetStructMissingOK("filter", &pin.Filter)
+	if err != nil {
+		return nil, err
+	}
+	status, err := vfs.cache.Pin(pin)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"pin": status}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/unpin",
+		Title: "Unpin files in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This removes the pins for |path| made with |vfs/pin| so the files
+they chose can be removed from the VFS cache as normal. Any download
+in progress for them is stopped.
+
+This takes the following parameters
+
+- |fs| - select the VFS in use (optional)
+- |path| - the path which was pinned (optional - the default is the root)
+
+This returns the number of pins removed in |unpinned| or an error if
+there weren't any.
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcUnpin,
+	})
+}
+
+func rcUnpin(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, rc.NewErrParamInvalid(errors.New("can't call this unless using the VFS cache"))
+	}
+	pinPath, err := in.GetString("path")
+	if err != nil && !rc.IsErrParamNotFound(err) {
+		return nil, err
+	}
+	n, err := vfs.cache.Unpin(pinPath)
+	if err != nil {
+		return nil, err
+	}
+	return rc.Params{"unpinned": n}, nil
+}
+
+func init() {
+	rc.Add(rc.Call{
+		Path:  "vfs/pinned",
+		Title: "List the pins in the VFS cache.",
+		Help: strings.ReplaceAll(`
+This returns the pins for the selected VFS and the progress of their
+downloads. If you call it when the |--vfs-cache-mode| is off, it will
+return an empty result.
+
+    {
+        "pinned": [
+            {
+                "path": "photos",       // string: the path pinned
+                "filter": ["+ *.jpg"],  // array: the filter rules if any
+                "fromFlag": false,      // boolean: true if the pin came from --vfs-pin
+                "state": "downloading", // string: listing, downloading, done, failed or cancelled
+                "files": 10,            // integer: number of files pinned
+                "filesDone": 4,         // integer: number of files in the cache
+                "bytes": 1048576,       // integer: size of the files pinned
+                "bytesDone": 419430,    // integer: size of the files in the cache
+                "errors": 0,            // integer: number of files which failed to download
+                "lastError": ""         // string: the last error if any
+            }
+        ]
+    }
+
+`, "|", "`") + getVFSHelp,
+		Fn: rcPinned,
+	})
+}
+
+func rcPinned(ctx context.Context, in rc.Params) (out rc.Params, err error) {
+	vfs, err := getVFS(in)
+	if err != nil {
+		return nil, err
+	}
+	if vfs.cache == nil {
+		return nil, nil
+	}
+	return rc.Params{"pinned": vfs.cache.Pinned()}, nil
+}
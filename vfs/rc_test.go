@@ -125,3 +125,16 @@ func TestRcStats(t *testing.T) {
 	assert.Equal(t, 1, out["metadataCache"].(rc.Params)["dirs"])
 	assert.Equal(t, vfs.Opt, out["opt"].(vfscommon.Options))
 }
+
+func TestRcPin(t *testing.T) {
+	r, _, call := rcNewRun(t, "vfs/pin")
+	in := rc.Params{"fs": fs.ConfigString(r.Fremote), "path": "dir"}
+	_, err := call.Fn(context.Background(), in)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "VFS cache")
+
+	call = rc.Calls.Get("vfs/pinned")
+	out, err := call.Fn(context.Background(), in)
+	require.NoError(t, err)
+	assert.Nil(t, out)
+}
@@ -27,6 +27,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
@@ -185,6 +186,8 @@ type VFS struct {
 	usageTime   time.Time
 	usage       *fs.Usage
 	pollChan    chan time.Duration
+	dirCache    *dirCache    // saved directory listings, nil if not in use
+	locks       *lockManager // advisory locks on the files
 	inUse       atomic.Int32 // count of number of opens
 }
 
@@ -220,7 +223,7 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	defer activeMu.Unlock()
 	configName := fs.ConfigString(f)
 	for _, activeVFS := range active[configName] {
-		if vfs.Opt == activeVFS.Opt {
+		if reflect.DeepEqual(vfs.Opt, activeVFS.Opt) {
 			fs.Debugf(f, "Reusing VFS from active cache")
 			activeVFS.inUse.Add(1)
 			return activeVFS
@@ -229,6 +232,14 @@ func New(f fs.Fs, opt *vfscommon.Options) *VFS {
 	// Put the VFS into the active cache
 	active[configName] = append(active[configName], vfs)
 
+	// Save directory listings if required
+	if vfs.Opt.DirCachePersist {
+		vfs.dirCache = newDirCache(f)
+	}
+
+	// Keep track of advisory locks
+	vfs.locks = newLockManager(ctx, vfs)
+
 	// Create root directory
 	vfs.root = newDir(vfs, f, nil, fsDir)
 
@@ -406,6 +417,12 @@ func (vfs *VFS) Shutdown() {
 
 // CleanUp deletes the contents of the on disk cache
 func (vfs *VFS) CleanUp() error {
+	if vfs.dirCache != nil {
+		err := vfs.dirCache.cleanUp()
+		if err != nil {
+			return err
+		}
+	}
 	if vfs.Opt.CacheMode == vfscommon.CacheModeOff {
 		return nil
 	}
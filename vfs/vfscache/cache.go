@@ -51,6 +51,8 @@ type Cache struct {
 	hashOption *fs.HashesOption     // corresponding OpenOption
 	writeback  *writeback.WriteBack // holds Items for writeback
 	avFn       AddVirtualFn         // if set, can be called to add dir entries
+	cipher     *cacheCipher         // if set, encrypts the cache files
+	keyPath    string               // file the salt for the cache key is saved in
 
 	mu            sync.Mutex       // protects the following variables
 	cond          sync.Cond        // cond lock for synchronous cache cleaning
@@ -62,6 +64,14 @@ type Cache struct {
 	kickerMu      sync.Mutex       // mutex for cleanerKicked
 	kick          chan struct{}    // channel for kicking clear to start
 
+	evictionStats evictionStats    // counters for the eviction policy
+	ghosts        map[string]int64 // use counts of files removed from the cache
+	ghostOrder    []string         // names in ghosts oldest first
+
+	pinMu    sync.Mutex      // protects pins - take Cache.mu first if both are needed
+	pins     []*pinState     // files to keep in the cache
+	pinCtx   context.Context // context for downloading pinned files
+	pinsPath string          // file the pins are saved in
 }
 
 // AddVirtualFn if registered by the WithAddVirtual method, can be
@@ -89,13 +99,7 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	parentPath := fromOSPath(parentOSPath)
 
 	// Get a relative cache path representing the remote.
-	relativeDirPath := fremote.Root() // This is a remote path in standard encoding
-	if runtime.GOOS == "windows" {
-		if strings.HasPrefix(relativeDirPath, `//?/`) {
-			relativeDirPath = relativeDirPath[2:] // Trim off the "//" for the result to be a valid when appending to another path
-		}
-	}
-	relativeDirPath = fremote.Name() + "/" + relativeDirPath
+	relativeDirPath := remoteRelativePath(fremote)
 	relativeDirOSPath := toOSPath(relativeDirPath)
 
 	// Create cache root dirs
@@ -113,6 +117,16 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 	}
 	hashType, hashOption := operations.CommonHash(ctx, fdata, fremote)
 
+	// Make the key if the cache is encrypted
+	keyPath := file.UNCPath(filepath.Join(parentOSPath, "vfsKey", relativeDirOSPath)) + ".json"
+	cipher, err := newCacheCipher(ctx, opt, keyPath, dataOSPath, metaOSPath)
+	if err != nil {
+		return nil, fmt.Errorf("vfs cache: %w", err)
+	}
+	if cipher != nil {
+		fs.Debugf(fremote, "vfs cache: encrypting cache files")
+	}
+
 	// Create the cache object
 	c := &Cache{
 		fremote:    fremote,
@@ -127,6 +141,8 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 		hashOption: hashOption,
 		writeback:  writeback.New(ctx, opt),
 		avFn:       avFn,
+		cipher:     cipher,
+		keyPath:    keyPath,
 	}
 
 	// load in the cache and metadata off disk
@@ -144,6 +160,14 @@ func New(ctx context.Context, fremote fs.Fs, opt *vfscommon.Options, avFn AddVir
 
 	go c.cleaner(ctx)
 
+	// Start downloading any pinned files now the cleaner is running.
+	// If this fails the caller cancels ctx which stops the cleaner.
+	pinsPath := file.UNCPath(filepath.Join(parentOSPath, "vfsPin", relativeDirOSPath)) + ".json"
+	err = c.initPins(ctx, pinsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pins: %w", err)
+	}
+
 	return c, nil
 }
 
@@ -166,6 +190,8 @@ func (c *Cache) Stats() (out rc.Params) {
 	out["erroredFiles"] = len(c.errItems)
 	out["bytesUsed"] = c.used
 	out["outOfSpace"] = c.outOfSpace
+	out["pinned"] = c.Pinned()
+	out["eviction"] = c.evictionStatsParams()
 
 	return out
 }
@@ -256,6 +282,24 @@ func clean(name string) string {
 	return name
 }
 
+// remoteRelativePath returns a relative path in standard encoding
+// representing fremote for use in the cache directory
+func remoteRelativePath(fremote fs.Fs) string {
+	relativeDirPath := fremote.Root() // This is a remote path in standard encoding
+	if runtime.GOOS == "windows" {
+		if strings.HasPrefix(relativeDirPath, `//?/`) {
+			relativeDirPath = relativeDirPath[2:] // Trim off the "//" for the result to be a valid when appending to another path
+		}
+	}
+	return fremote.Name() + "/" + relativeDirPath
+}
+
+// RootOSPath returns the OS path of the directory called name, eg
+// "vfsMeta", for fremote in the rclone cache directory.
+func RootOSPath(name string, fremote fs.Fs) string {
+	return file.UNCPath(filepath.Join(config.GetCacheDir(), name, toOSPath(remoteRelativePath(fremote))))
+}
+
 // fromOSPath turns a OS path into a standard/remote path
 func fromOSPath(osPath string) string {
 	return encoder.OS.ToStandardPath(filepath.ToSlash(osPath))
@@ -268,13 +312,13 @@ func toOSPath(standardPath string) string {
 
 // toOSPath turns a remote relative name into an OS path in the cache
 func (c *Cache) toOSPath(name string) string {
-	return filepath.Join(c.root, toOSPath(name))
+	return filepath.Join(c.root, toOSPath(c.cacheName(name)))
 }
 
 // toOSPathMeta turns a remote relative name into an OS path in the
 // cache for the metadata
 func (c *Cache) toOSPathMeta(name string) string {
-	return filepath.Join(c.metaRoot, toOSPath(name))
+	return filepath.Join(c.metaRoot, toOSPath(c.cacheName(name)))
 }
 
 // _get gets name from the cache or creates a new one
@@ -290,6 +334,7 @@ func (c *Cache) _get(name string) (item *Item, found bool) {
 	found = item != nil
 	if !found {
 		item = newItem(c, name)
+		c._restoreGhost(item)
 		c.item[name] = item
 	}
 	return item, found
@@ -505,10 +550,24 @@ func (c *Cache) SetModTime(name string, modTime time.Time) {
 func (c *Cache) CleanUp() error {
 	err1 := os.RemoveAll(c.root)
 	err2 := os.RemoveAll(c.metaRoot)
+	err3 := os.Remove(c.pinsPath)
+	if errors.Is(err3, os.ErrNotExist) {
+		err3 = nil
+	}
+	err4 := os.Remove(c.keyPath)
+	if errors.Is(err4, os.ErrNotExist) {
+		err4 = nil
+	}
 	if err1 != nil {
 		return err1
 	}
-	return err2
+	if err2 != nil {
+		return err2
+	}
+	if err3 != nil {
+		return err3
+	}
+	return err4
 }
 
 // walk walks the cache calling the function
@@ -543,6 +602,11 @@ func (c *Cache) reload(ctx context.Context) error {
 			if fi.IsDir() {
 				return nil
 			}
+			name, err := c.remoteName(name)
+			if err != nil {
+				fs.Errorf(osPath, "vfs cache: ignoring file: %v", err)
+				return nil
+			}
 			item, found := c.get(name)
 			if !found {
 				err := item.reload(ctx)
@@ -588,8 +652,11 @@ func (c *Cache) KickCleaner() {
 
 // removeNotInUse removes items not in use with a possible maxAge cutoff
 // called with cache mutex locked and up-to-date c.used (as we update it directly here)
-func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool) {
-	removed, spaceFreed := item.RemoveNotInUse(maxAge, emptyOnly)
+func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool) (removed bool, spaceFreed int64) {
+	if c.isPinned(item.name) {
+		return false, 0
+	}
+	removed, spaceFreed = item.RemoveNotInUse(maxAge, emptyOnly)
 	// The item space might be freed even if we get an error after the cache file is removed
 	// The item will not be removed or reset the cache data is dirty (DataDirty)
 	c.used -= spaceFreed
@@ -600,6 +667,7 @@ func (c *Cache) removeNotInUse(item *Item, maxAge time.Duration, emptyOnly bool)
 	} else {
 		fs.Debugf(c.fremote, "vfs cache RemoveNotInUse (maxAge=%d, emptyOnly=%v): item %s not removed, freed %d bytes", maxAge, emptyOnly, item.GetName(), spaceFreed)
 	}
+	return removed, spaceFreed
 }
 
 // Retry failed resets during purgeClean()
@@ -637,14 +705,14 @@ func (c *Cache) purgeClean() {
 
 	var items Items
 
-	// Make a slice of clean cache files
+	// Make a slice of clean cache files which aren't pinned
 	for _, item := range c.item {
-		if !item.IsDirty() {
+		if !item.IsDirty() && !c.isPinned(item.name) {
 			items = append(items, item)
 		}
 	}
 
-	sort.Sort(items)
+	sort.Sort(evictionOrder{Items: items, policy: c.opt.CacheEviction, now: time.Now()})
 
 	// Reset items until the quota is OK
 	for _, item := range items {
@@ -656,6 +724,9 @@ func (c *Cache) purgeClean() {
 		// The item will not be removed or reset if the cache data is dirty (DataDirty)
 		c.used -= spaceFreed
 		fs.Infof(c.fremote, "vfs cache purgeClean item.Reset %s: %s, freed %d bytes", item.GetName(), resetResult.String(), spaceFreed)
+		if spaceFreed > 0 {
+			c._evicted(item, resetResult == RemovedNotInUse, spaceFreed)
+		}
 		if resetResult == RemovedNotInUse {
 			delete(c.item, item.name)
 		}
@@ -690,6 +761,7 @@ func (c *Cache) purgeOld(maxAge time.Duration) {
 // Purge any empty directories
 func (c *Cache) purgeEmptyDirs(dir string, leaveRoot bool) {
 	ctx := context.Background()
+	dir = c.cacheName(dir)
 	err := operations.Rmdirs(ctx, c.fcache, dir, leaveRoot)
 	if err != nil {
 		fs.Errorf(c.fcache, "vfs cache: failed to remove empty directories from cache path %q: %v", dir, err)
@@ -772,11 +844,14 @@ func (c *Cache) purgeOverQuota() {
 		}
 	}
 
-	sort.Sort(items)
+	sort.Sort(evictionOrder{Items: items, policy: c.opt.CacheEviction, now: time.Now()})
 
 	// Remove items until the quota is OK
 	for _, item := range items {
-		c.removeNotInUse(item, 0, c.quotasOK())
+		removed, spaceFreed := c.removeNotInUse(item, 0, c.quotasOK())
+		if removed && spaceFreed > 0 {
+			c._evicted(item, removed, spaceFreed)
+		}
 	}
 	if c.quotasOK() {
 		c.outOfSpace = false
@@ -2,6 +2,7 @@ package vfscache
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -514,6 +515,83 @@ func TestCachePurgeClean(t *testing.T) {
 	assert.Equal(t, []string(nil), itemAsString(c))
 }
 
+func TestCachePin(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CacheMode = vfscommon.CacheModeFull
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	r, c := newTestCacheOpt(t, opt)
+
+	ctx := context.Background()
+	r.WriteObject(ctx, "dir/one.txt", "one", time.Now())
+	r.WriteObject(ctx, "dir/two.jpg", "two", time.Now())
+	r.WriteObject(ctx, "three.txt", "three", time.Now())
+
+	// Pin a directory and the .txt files in the root
+	pin1 := Pin{Path: "dir"}
+	pin2 := Pin{Filter: []string{"+ /*.txt"}}
+	_, err := c.Pin(pin1)
+	require.NoError(t, err)
+	_, err = c.Pin(pin2)
+	require.NoError(t, err)
+	c.waitPin(pin1)
+	c.waitPin(pin2)
+
+	pinned := c.Pinned()
+	require.Len(t, pinned, 2)
+	assert.Equal(t, pinDone, pinned[0].State)
+	assert.Equal(t, int64(2), pinned[0].FilesDone)
+	assert.Equal(t, int64(6), pinned[0].BytesDone)
+	assert.Equal(t, pinDone, pinned[1].State)
+	assert.Equal(t, int64(1), pinned[1].FilesDone)
+	assert.Equal(t, pinned, c.Stats()["pinned"])
+
+	// An unpinned file in the cache
+	potato := c.Item("potato")
+	require.NoError(t, potato.Open(nil))
+	require.NoError(t, potato.Close(nil))
+
+	assert.True(t, c.isPinned("dir/one.txt"))
+	assert.True(t, c.isPinned("three.txt"))
+	assert.False(t, c.isPinned("potato"))
+	assert.False(t, c.isPinned("dir2/one.txt"))
+
+	// Pinned files aren't removed from the cache
+	c.purgeOld(-10 * time.Second)
+	c.opt.CacheMaxSize = 1
+	c.purgeClean()
+	c.purgeOverQuota()
+	assert.Equal(t, []string{
+		`name="dir/one.txt" opens=0 size=3 space=3`,
+		`name="dir/two.jpg" opens=0 size=3 space=3`,
+		`name="three.txt" opens=0 size=5 space=5`,
+	}, itemSpaceAsString(c))
+
+	// The pins are saved
+	data, err := os.ReadFile(c.pinsPath)
+	require.NoError(t, err)
+	var saved []Pin
+	require.NoError(t, json.Unmarshal(data, &saved))
+	assert.Equal(t, []Pin{pin1, pin2}, saved)
+
+	// Unpinning lets the files be removed
+	n, err := c.Unpin("dir")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = c.Unpin("dir")
+	assert.Error(t, err)
+	c.purgeOld(-10 * time.Second)
+	assert.Equal(t, []string{
+		`name="three.txt" opens=0 size=5 space=5`,
+	}, itemSpaceAsString(c))
+}
+
+func TestCachePinNeedsFullMode(t *testing.T) {
+	_, c := newTestCache(t)
+	_, err := c.Pin(Pin{Path: "dir"})
+	assert.Error(t, err)
+}
+
 func TestCacheInUse(t *testing.T) {
 	_, c := newTestCache(t)
 
@@ -0,0 +1,765 @@
+package vfscache
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/backend/crypt/pkcs7"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/fs/hash"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/rfjakob/eme"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Layout of the encrypted cache files
+//
+// The data is split into blocks of encBlockSize bytes. Each block is
+// stored as a random nonce followed by the block sealed with
+// XChaCha20-Poly1305 using the random ID of the file and the block
+// number as additional data, so any block can be read or written on
+// its own but blocks can't be moved within or between files. Blocks
+// which haven't been written are holes in the sparse file and read as
+// zeros. Which blocks have been written is kept in the metadata so a
+// block which has been zeroed is detected rather than read as a hole.
+//
+// Metadata files are stored as a random nonce followed by the sealed
+// JSON of an encMeta using the name of the file as additional data.
+//
+// The names of the files and directories in the cache are encrypted
+// with EME and encoded with base32, like the crypt backend does.
+const (
+	encBlockSize  = 64 * 1024
+	encNonceSize  = chacha20poly1305.NonceSizeX
+	encOverhead   = encNonceSize + chacha20poly1305.Overhead
+	encDiskBlock  = encBlockSize + encOverhead
+	encIDSize     = 16
+	encKeyVersion = 2
+)
+
+// encKeyFile is stored in the vfsKey directory to check the password
+// is the one the cache was made with
+type encKeyFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`  // random salt for the key derivation
+	Check   []byte `json:"check"` // HMAC of a known string with the key
+}
+
+// encMeta is the contents of an encrypted metadata file
+type encMeta struct {
+	ID     []byte          `json:"id"`     // random ID of the cache file
+	Blocks ranges.Ranges   `json:"blocks"` // blocks of the cache file which have been written
+	Info   json.RawMessage `json:"info"`   // the metadata of the item
+}
+
+// encNameEncoding encodes the encrypted names
+var encNameEncoding = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+// cacheCipher encrypts the cache files
+type cacheCipher struct {
+	aead  cipher.AEAD  // seals the data and the metadata
+	names cipher.Block // encrypts the names
+	tweak []byte       // tweak for encrypting the names
+	check []byte       // value to check the key against
+}
+
+// newCipherFromKey makes a cacheCipher using key
+func newCipherFromKey(key []byte) (*cacheCipher, error) {
+	aead, err := chacha20poly1305.NewX(deriveKey(key, "data"))
+	if err != nil {
+		return nil, err
+	}
+	names, err := aes.NewCipher(deriveKey(key, "names"))
+	if err != nil {
+		return nil, err
+	}
+	return &cacheCipher{
+		aead:  aead,
+		names: names,
+		tweak: deriveKey(key, "name tweak")[:aes.BlockSize],
+		check: deriveKey(key, "key check"),
+	}, nil
+}
+
+// deriveKey returns a key for purpose made from key
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("rclone vfs cache " + purpose))
+	return mac.Sum(nil)
+}
+
+// cacheFile is the interface to a cache file which is an *os.File or
+// an *encryptedFile
+type cacheFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Close() error
+}
+
+// Check interfaces
+var (
+	_ cacheFile = (*os.File)(nil)
+	_ cacheFile = (*encryptedFile)(nil)
+)
+
+// cachePassword returns the password for the cache from the options
+// or "" if encryption isn't in use
+func cachePassword(ctx context.Context, opt *vfscommon.Options) (string, error) {
+	if len(opt.CachePasswordCommand) == 0 {
+		if opt.CachePassword == "" {
+			return "", nil
+		}
+		password, err := obscure.Reveal(opt.CachePassword)
+		if err != nil {
+			return "", fmt.Errorf("--vfs-cache-password must be obscured with rclone obscure: %w", err)
+		}
+		return password, nil
+	}
+	cmd := exec.CommandContext(ctx, opt.CachePasswordCommand[0], opt.CachePasswordCommand[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("--vfs-cache-password-command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	password := strings.TrimRight(string(out), "\r\n")
+	if password == "" {
+		return "", errors.New("--vfs-cache-password-command returned an empty password")
+	}
+	return password, nil
+}
+
+// newCacheCipher returns the cipher for the cache using the password
+// in opt, or nil if the cache isn't encrypted.
+//
+// keyPath is the file storing the salt and key check and cacheDirs
+// the cache directories, used to check an unencrypted cache isn't
+// being encrypted.
+func newCacheCipher(ctx context.Context, opt *vfscommon.Options, keyPath string, cacheDirs ...string) (*cacheCipher, error) {
+	password, err := cachePassword(ctx, opt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read cache key file: %w", err)
+	}
+	exists := err == nil
+	if password == "" {
+		if exists {
+			return nil, errors.New("the cache is encrypted: set --vfs-cache-password or remove the cache directory")
+		}
+		return nil, nil
+	}
+	var kf encKeyFile
+	if exists {
+		err = json.Unmarshal(data, &kf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode cache key file %q: %w", keyPath, err)
+		}
+		if kf.Version != encKeyVersion {
+			return nil, fmt.Errorf("cache key file %q has unsupported version %d", keyPath, kf.Version)
+		}
+	} else {
+		if !dirsEmpty(cacheDirs...) {
+			return nil, errors.New("the cache isn't encrypted: let any uploads finish and remove the cache directory before using --vfs-cache-password")
+		}
+		kf.Version = encKeyVersion
+		kf.Salt = make([]byte, 16)
+		if _, err = rand.Read(kf.Salt); err != nil {
+			return nil, fmt.Errorf("failed to make salt: %w", err)
+		}
+	}
+	key, err := scrypt.Key([]byte(password), kf.Salt, 16384, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make key: %w", err)
+	}
+	cc, err := newCipherFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make cipher: %w", err)
+	}
+	if exists {
+		if !hmac.Equal(kf.Check, cc.check) {
+			return nil, errors.New("wrong --vfs-cache-password for the cache")
+		}
+		return cc, nil
+	}
+	kf.Check = cc.check
+	data, err = json.Marshal(&kf)
+	if err != nil {
+		return nil, err
+	}
+	err = file.MkdirAll(filepath.Dir(keyPath), 0700)
+	if err == nil {
+		err = os.WriteFile(keyPath, data, 0600)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write cache key file: %w", err)
+	}
+	return cc, nil
+}
+
+// dirsEmpty returns true if there are no files under dirs
+func dirsEmpty(dirs ...string) bool {
+	empty := true
+	for _, dir := range dirs {
+		_ = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				empty = false
+				return filepath.SkipAll
+			}
+			return nil
+		})
+	}
+	return empty
+}
+
+// seal encrypts plaintext with additional data ad
+func (cc *cacheCipher) seal(plaintext, ad []byte) []byte {
+	out := make([]byte, encNonceSize, encNonceSize+len(plaintext)+chacha20poly1305.Overhead)
+	_, _ = rand.Read(out)
+	return cc.aead.Seal(out, out[:encNonceSize], plaintext, ad)
+}
+
+// open decrypts ciphertext made by seal with additional data ad
+func (cc *cacheCipher) open(ciphertext, ad []byte) ([]byte, error) {
+	if len(ciphertext) < encOverhead {
+		return nil, errors.New("encrypted data too short")
+	}
+	plaintext, err := cc.aead.Open(nil, ciphertext[:encNonceSize], ciphertext[encNonceSize:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// encryptName encrypts each segment of the cache relative name
+func (cc *cacheCipher) encryptName(name string) string {
+	if name == "" {
+		return ""
+	}
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		padded := pkcs7.Pad(aes.BlockSize, []byte(segment))
+		ciphertext := eme.Transform(cc.names, cc.tweak, padded, eme.DirectionEncrypt)
+		segments[i] = strings.ToLower(encNameEncoding.EncodeToString(ciphertext))
+	}
+	return strings.Join(segments, "/")
+}
+
+// decryptName decrypts a name made by encryptName
+func (cc *cacheCipher) decryptName(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		ciphertext, err := encNameEncoding.DecodeString(strings.ToUpper(segment))
+		if err != nil {
+			return "", fmt.Errorf("failed to decode name %q: %w", name, err)
+		}
+		if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return "", fmt.Errorf("failed to decrypt name %q: wrong size", name)
+		}
+		padded := eme.Transform(cc.names, cc.tweak, ciphertext, eme.DirectionDecrypt)
+		plaintext, err := pkcs7.Unpad(aes.BlockSize, padded)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt name %q: %w", name, err)
+		}
+		segments[i] = string(plaintext)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// cacheName returns the name used in the cache directories for the
+// remote relative name
+func (c *Cache) cacheName(name string) string {
+	if c.cipher == nil {
+		return name
+	}
+	return c.cipher.encryptName(name)
+}
+
+// remoteName returns the remote relative name for the name used in
+// the cache directories
+func (c *Cache) remoteName(name string) (string, error) {
+	if c.cipher == nil {
+		return name, nil
+	}
+	return c.cipher.decryptName(name)
+}
+
+// encodeMeta returns the metadata file contents for the JSON data
+func (c *Cache) encodeMeta(data []byte, ad string) []byte {
+	if c.cipher == nil {
+		return data
+	}
+	return c.cipher.seal(data, []byte(ad))
+}
+
+// decodeMeta returns the JSON data from the metadata file contents
+func (c *Cache) decodeMeta(data []byte, ad string) ([]byte, error) {
+	if c.cipher == nil {
+		return data, nil
+	}
+	return c.cipher.open(data, []byte(ad))
+}
+
+// pinsAD is the additional data for sealing the pins file
+const pinsAD = "rclone vfs cache pins"
+
+// metaAD returns the additional data for sealing the metadata of name
+func metaAD(name string) string {
+	return "rclone vfs cache metadata\x00" + name
+}
+
+// _encodeMeta returns the metadata file contents for the JSON info
+// of the item
+//
+// call with the lock held
+func (item *Item) _encodeMeta(info []byte) ([]byte, error) {
+	if item.c.cipher == nil {
+		return info, nil
+	}
+	data, err := json.Marshal(&encMeta{
+		ID:     item.enc.id,
+		Blocks: item.enc.getBlocks(),
+		Info:   info,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item.c.encodeMeta(data, metaAD(item.name)), nil
+}
+
+// _decodeMeta returns the JSON info of the item from the metadata
+// file contents
+//
+// call with the lock held
+func (item *Item) _decodeMeta(data []byte) ([]byte, error) {
+	if item.c.cipher == nil {
+		return data, nil
+	}
+	data, err := item.c.decodeMeta(data, metaAD(item.name))
+	if err != nil {
+		return nil, err
+	}
+	var meta encMeta
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.ID) != encIDSize {
+		return nil, errors.New("bad file ID")
+	}
+	item.enc = &encState{id: meta.ID, blocks: meta.Blocks}
+	return meta.Info, nil
+}
+
+// encState is the state of an encrypted cache file which is shared by
+// all the handles open on it and saved in its metadata
+type encState struct {
+	id     []byte        // random ID of the file
+	mu     sync.Mutex    // protects the following
+	blocks ranges.Ranges // blocks which have been written - the rest are holes
+}
+
+// newEncState returns the state for a new encrypted cache file
+func newEncState() *encState {
+	id := make([]byte, encIDSize)
+	_, _ = rand.Read(id)
+	return &encState{id: id}
+}
+
+// blockAD returns the additional data for block i
+func (s *encState) blockAD(i int64) []byte {
+	ad := make([]byte, 0, len(s.id)+8)
+	ad = append(ad, s.id...)
+	return binary.BigEndian.AppendUint64(ad, uint64(i))
+}
+
+// written returns true if block i has been written
+func (s *encState) written(i int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blocks.Present(ranges.Range{Pos: i, Size: 1})
+}
+
+// setWritten marks block i as written
+func (s *encState) setWritten(i int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks.Insert(ranges.Range{Pos: i, Size: 1})
+}
+
+// truncate forgets the blocks from n onwards
+func (s *encState) truncate(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = s.blocks.Intersection(ranges.Range{Pos: 0, Size: n})
+}
+
+// clear forgets all the blocks as the file has been removed
+func (s *encState) clear() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks = nil
+}
+
+// getBlocks returns a copy of the blocks which have been written
+func (s *encState) getBlocks() ranges.Ranges {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append(ranges.Ranges(nil), s.blocks...)
+}
+
+// openFile opens the cache file at osPath with enc the state of the
+// file if it is encrypted
+func (c *Cache) openFile(osPath string, flags int, enc *encState) (cacheFile, error) {
+	if c.cipher == nil {
+		return file.OpenFile(osPath, flags, 0600)
+	}
+	// Need to read the blocks to write part of them
+	if flags&os.O_WRONLY != 0 {
+		flags = flags&^os.O_WRONLY | os.O_RDWR
+	}
+	fd, err := file.OpenFile(osPath, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return &encryptedFile{
+		cc:   c.cipher,
+		enc:  enc,
+		fd:   fd,
+		size: encPlainSize(fi.Size()),
+	}, nil
+}
+
+// stat returns the info for the cache file at osPath with the size of
+// the unencrypted data
+func (c *Cache) stat(osPath string) (os.FileInfo, error) {
+	fi, err := os.Stat(osPath)
+	if err != nil || c.cipher == nil {
+		return fi, err
+	}
+	return sizedFileInfo{FileInfo: fi, size: encPlainSize(fi.Size())}, nil
+}
+
+// setSparse marks the cache file as sparse
+func setSparse(fd cacheFile) error {
+	switch x := fd.(type) {
+	case *os.File:
+		return file.SetSparse(x)
+	case *encryptedFile:
+		return file.SetSparse(x.fd)
+	}
+	return nil
+}
+
+// encPlainSize returns the size of the data in an encrypted file of
+// size diskSize
+func encPlainSize(diskSize int64) int64 {
+	blocks, rem := diskSize/encDiskBlock, diskSize%encDiskBlock
+	size := blocks * encBlockSize
+	if rem > encOverhead {
+		size += rem - encOverhead
+	}
+	return size
+}
+
+// encDiskSize returns the size of an encrypted file holding size bytes
+func encDiskSize(size int64) int64 {
+	blocks, rem := size/encBlockSize, size%encBlockSize
+	diskSize := blocks * encDiskBlock
+	if rem > 0 {
+		diskSize += rem + encOverhead
+	}
+	return diskSize
+}
+
+// sizedFileInfo is an os.FileInfo with a different size
+type sizedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+// Size returns the size of the unencrypted data
+func (fi sizedFileInfo) Size() int64 {
+	return fi.size
+}
+
+// encryptedFile is an encrypted cache file
+type encryptedFile struct {
+	cc   *cacheCipher
+	enc  *encState
+	fd   *os.File
+	mu   sync.Mutex // protects the following
+	size int64      // size of the unencrypted data
+}
+
+// _readBlock returns the unencrypted data in block i
+//
+// call with mu held
+func (f *encryptedFile) _readBlock(i int64) ([]byte, error) {
+	n := min(encBlockSize, f.size-i*encBlockSize)
+	if n <= 0 {
+		return nil, nil
+	}
+	if !f.enc.written(i) {
+		return make([]byte, n), nil
+	}
+	buf := make([]byte, n+encOverhead)
+	nn, err := f.fd.ReadAt(buf, i*encDiskBlock)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	plaintext, err := f.cc.open(buf[:nn], f.enc.blockAD(i))
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", i, err)
+	}
+	return plaintext, nil
+}
+
+// _writeBlock encrypts data and writes it as block i
+//
+// call with mu held
+func (f *encryptedFile) _writeBlock(i int64, data []byte) error {
+	_, err := f.fd.WriteAt(f.cc.seal(data, f.enc.blockAD(i)), i*encDiskBlock)
+	if err != nil {
+		return err
+	}
+	f.enc.setWritten(i)
+	return nil
+}
+
+// ReadAt reads len(b) bytes from off
+func (f *encryptedFile) ReadAt(b []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(b) > 0 {
+		if off >= f.size {
+			return n, io.EOF
+		}
+		i, within := off/encBlockSize, off%encBlockSize
+		block, err := f._readBlock(i)
+		if err != nil {
+			return n, err
+		}
+		nn := copy(b, block[within:])
+		n += nn
+		off += int64(nn)
+		b = b[nn:]
+	}
+	return n, nil
+}
+
+// WriteAt writes b at off
+func (f *encryptedFile) WriteAt(b []byte, off int64) (n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if off > f.size {
+		err = f._truncate(off)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for len(b) > 0 {
+		i, within := off/encBlockSize, off%encBlockSize
+		block, err := f._readBlock(i)
+		if err != nil {
+			return n, err
+		}
+		nn := min(len(b), encBlockSize-int(within))
+		if end := int(within) + nn; end > len(block) {
+			block = append(block, make([]byte, end-len(block))...)
+		}
+		copy(block[within:], b[:nn])
+		err = f._writeBlock(i, block)
+		if err != nil {
+			return n, err
+		}
+		n += nn
+		off += int64(nn)
+		b = b[nn:]
+		f.size = max(f.size, off)
+	}
+	return n, nil
+}
+
+// Truncate changes the size of the file to size
+func (f *encryptedFile) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f._truncate(size)
+}
+
+// _truncate changes the size of the file to size
+//
+// call with mu held
+func (f *encryptedFile) _truncate(size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	// The last block changes size so needs re-encrypting
+	var i int64
+	if size < f.size {
+		i = size / encBlockSize
+	} else {
+		i = f.size / encBlockSize
+	}
+	if i*encBlockSize < min(size, f.size) || (size > f.size && f.size%encBlockSize != 0) {
+		block, err := f._readBlock(i)
+		if err != nil {
+			return err
+		}
+		n := int(min(encBlockSize, size-i*encBlockSize))
+		if n < len(block) {
+			block = block[:n]
+		} else {
+			block = append(block, make([]byte, n-len(block))...)
+		}
+		err = f._writeBlock(i, block)
+		if err != nil {
+			return err
+		}
+	}
+	err := f.fd.Truncate(encDiskSize(size))
+	if err != nil {
+		return err
+	}
+	f.enc.truncate((size + encBlockSize - 1) / encBlockSize)
+	f.size = size
+	return nil
+}
+
+// Stat returns the info for the file with the size of the unencrypted data
+func (f *encryptedFile) Stat() (os.FileInfo, error) {
+	fi, err := f.fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sizedFileInfo{FileInfo: fi, size: f.size}, nil
+}
+
+// Sync commits the file to disk
+func (f *encryptedFile) Sync() error {
+	return f.fd.Sync()
+}
+
+// Close the file
+func (f *encryptedFile) Close() error {
+	return f.fd.Close()
+}
+
+// encryptedObject is the unencrypted view of an encrypted cache file
+// used to upload it
+type encryptedObject struct {
+	fs.Object // the object in the cache
+	c         *Cache
+	osPath    string
+	enc       *encState
+	size      int64
+}
+
+// newEncryptedObject returns an object to read the unencrypted data
+// of the cacheObj at osPath which has state enc
+func (c *Cache) newEncryptedObject(cacheObj fs.Object, osPath string, enc *encState) (fs.Object, error) {
+	fi, err := c.stat(osPath)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedObject{
+		Object: cacheObj,
+		c:      c,
+		osPath: osPath,
+		enc:    enc,
+		size:   fi.Size(),
+	}, nil
+}
+
+// Size returns the size of the unencrypted data
+func (o *encryptedObject) Size() int64 {
+	return o.size
+}
+
+// Hash returns no hash as the hash of the cache file is of the
+// encrypted data
+func (o *encryptedObject) Hash(ctx context.Context, ht hash.Type) (string, error) {
+	return "", nil
+}
+
+// ModTime returns the modification time of the cache file
+func (o *encryptedObject) ModTime(ctx context.Context) time.Time {
+	return o.Object.ModTime(ctx)
+}
+
+// Open the unencrypted data for reading
+func (o *encryptedObject) Open(ctx context.Context, options ...fs.OpenOption) (io.ReadCloser, error) {
+	var offset, limit int64 = 0, -1
+	for _, option := range options {
+		switch x := option.(type) {
+		case *fs.SeekOption:
+			offset = x.Offset
+		case *fs.RangeOption:
+			offset, limit = x.Decode(o.size)
+		default:
+			if option.Mandatory() {
+				fs.Logf(o, "Unsupported mandatory option: %v", option)
+			}
+		}
+	}
+	fd, err := o.c.openFile(o.osPath, os.O_RDONLY, o.enc)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 {
+		limit = o.size - offset
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.NewSectionReader(fd, offset, limit),
+		Closer: fd,
+	}, nil
+}
+
+// UnWrap returns nil so the object isn't used directly
+func (o *encryptedObject) UnWrap() fs.Object {
+	return nil
+}
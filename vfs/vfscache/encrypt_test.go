@@ -0,0 +1,245 @@
+package vfscache
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rclone/rclone/fs/config/obscure"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedFileSizes(t *testing.T) {
+	for _, size := range []int64{0, 1, encBlockSize - 1, encBlockSize, encBlockSize + 1, 3*encBlockSize + 17} {
+		assert.Equal(t, size, encPlainSize(encDiskSize(size)), size)
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	cc, err := newCipherFromKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	c := &Cache{cipher: cc}
+	enc := newEncState()
+	osPath := filepath.Join(t.TempDir(), "file")
+	fd, err := c.openFile(osPath, os.O_CREATE|os.O_WRONLY, enc)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, fd.Close())
+	}()
+
+	// Do random operations on the file and on a buffer and check
+	// they stay the same
+	rng := rand.New(rand.NewSource(1))
+	var want []byte
+	check := func() {
+		fi, err := fd.Stat()
+		require.NoError(t, err)
+		require.Equal(t, int64(len(want)), fi.Size())
+		got := make([]byte, len(want))
+		n, err := fd.ReadAt(got, 0)
+		require.NoError(t, err)
+		require.Equal(t, len(want), n)
+		require.True(t, bytes.Equal(want, got))
+	}
+	for range 200 {
+		switch rng.Intn(4) {
+		case 0, 1:
+			off := rng.Int63n(4 * encBlockSize)
+			b := make([]byte, rng.Intn(2*encBlockSize))
+			_, _ = rng.Read(b)
+			n, err := fd.WriteAt(b, off)
+			require.NoError(t, err)
+			require.Equal(t, len(b), n)
+			if end := off + int64(len(b)); end > int64(len(want)) {
+				want = append(want, make([]byte, end-int64(len(want)))...)
+			}
+			copy(want[off:], b)
+		case 2:
+			size := rng.Int63n(5 * encBlockSize)
+			require.NoError(t, fd.Truncate(size))
+			if size > int64(len(want)) {
+				want = append(want, make([]byte, size-int64(len(want)))...)
+			}
+			want = want[:size]
+		case 3:
+			off := rng.Int63n(int64(len(want)) + 1)
+			got := make([]byte, rng.Intn(2*encBlockSize))
+			n, err := fd.ReadAt(got, off)
+			wantN := min(len(got), len(want)-int(off))
+			if n < len(got) {
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			require.Equal(t, wantN, n)
+			require.True(t, bytes.Equal(want[off:off+int64(n)], got[:n]))
+		}
+		check()
+	}
+
+	// Reopen the file and check the size and contents are read
+	require.NoError(t, fd.Close())
+	fd, err = c.openFile(osPath, os.O_RDWR, enc)
+	require.NoError(t, err)
+	check()
+
+	// Holes in the sparse file read as zeros
+	require.NoError(t, fd.Truncate(0))
+	want = nil
+	_, err = fd.WriteAt([]byte("end"), 3*encBlockSize)
+	require.NoError(t, err)
+	want = append(make([]byte, 3*encBlockSize), "end"...)
+	check()
+
+	// The data isn't stored in the clear
+	_, err = fd.WriteAt([]byte("potato sandwich"), 10)
+	require.NoError(t, err)
+	raw, err := os.ReadFile(osPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "potato sandwich")
+
+	// Corrupted blocks give an error
+	raw[20] ^= 0xFF
+	require.NoError(t, os.WriteFile(osPath, raw, 0600))
+	_, err = fd.ReadAt(make([]byte, 10), 0)
+	assert.ErrorContains(t, err, "failed to decrypt")
+
+	// Written blocks which have been zeroed give an error
+	raw[20] ^= 0xFF
+	clear(raw[:encDiskBlock])
+	require.NoError(t, os.WriteFile(osPath, raw, 0600))
+	_, err = fd.ReadAt(make([]byte, 10), 0)
+	assert.ErrorContains(t, err, "failed to decrypt")
+
+	// Blocks can't be moved to another file
+	osPath2 := filepath.Join(t.TempDir(), "file2")
+	fd2, err := c.openFile(osPath2, os.O_CREATE|os.O_RDWR, newEncState())
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, fd2.Close())
+	}()
+	_, err = fd2.WriteAt([]byte("end"), 3*encBlockSize)
+	require.NoError(t, err)
+	raw2, err := os.ReadFile(osPath2)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(osPath, raw2, 0600))
+	_, err = fd.ReadAt(make([]byte, 3), 3*encBlockSize)
+	assert.ErrorContains(t, err, "failed to decrypt")
+}
+
+func TestEncryptedNames(t *testing.T) {
+	cc, err := newCipherFromKey(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	for _, name := range []string{"", "potato", "dir/sub dir/file.txt", "ünïcödé", "one/two/three.txt"} {
+		encrypted := cc.encryptName(name)
+		assert.Equal(t, strings.Count(name, "/"), strings.Count(encrypted, "/"))
+		if name != "" {
+			assert.NotContains(t, encrypted, path.Base(name))
+		}
+		decrypted, err := cc.decryptName(encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, name, decrypted)
+	}
+	_, err = cc.decryptName("potato")
+	assert.Error(t, err)
+}
+
+func TestCacheEncrypted(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CachePassword = obscure.MustObscure("potato")
+	r, c := newTestCacheOpt(t, opt)
+	require.NotNil(t, c.cipher)
+
+	const contents = "this is the secret contents of the file"
+	item, _ := c.get("secret.txt")
+	require.NoError(t, item.Open(nil))
+	n, err := item.WriteAt([]byte(contents), 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(contents), n)
+	size, err := item.GetSize()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(contents)), size)
+	require.NoError(t, item.Close(nil))
+
+	// The remote gets the unencrypted data
+	checkObject(t, r, "secret.txt", contents)
+
+	// The cache files and their names are encrypted
+	assert.NotContains(t, c.toOSPath("secret.txt"), "secret")
+	assert.NotContains(t, c.toOSPathMeta("secret.txt"), "secret")
+	data, err := os.ReadFile(c.toOSPath("secret.txt"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	meta, err := os.ReadFile(c.toOSPathMeta("secret.txt"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(meta), "ModTime")
+
+	// The item can be read back
+	obj, err := r.Fremote.NewObject(context.Background(), "secret.txt")
+	require.NoError(t, err)
+	buf := make([]byte, len(contents))
+	require.NoError(t, item.Open(obj))
+	n, err = item.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(contents), n)
+	assert.Equal(t, contents, string(buf))
+	require.NoError(t, item.Close(nil))
+	assert.True(t, item.present())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Metadata can't be used for another file
+	other := &Item{c: c, name: "other.txt"}
+	_, err = other._decodeMeta(meta)
+	assert.ErrorContains(t, err, "failed to decrypt")
+
+	// Renamed files can still be read
+	require.NoError(t, c.Rename("secret.txt", "dir/renamed.txt", nil))
+
+	// The cache can be opened again with the same password
+	c2, err := New(ctx, r.Fremote, &opt, nil)
+	require.NoError(t, err)
+	item2, _ := c2.get("dir/renamed.txt")
+	assert.True(t, item2.present())
+	buf = make([]byte, len(contents))
+	require.NoError(t, item2.Open(obj))
+	n, err = item2.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(buf[:n]))
+	require.NoError(t, item2.Close(nil))
+
+	// But not with the wrong one or with none
+	opt2 := opt
+	opt2.CachePassword = obscure.MustObscure("wrong")
+	_, err = New(ctx, r.Fremote, &opt2, nil)
+	assert.ErrorContains(t, err, "wrong --vfs-cache-password")
+	opt2.CachePassword = ""
+	_, err = New(ctx, r.Fremote, &opt2, nil)
+	assert.ErrorContains(t, err, "the cache is encrypted")
+}
+
+func TestCacheEncryptedUnencryptedCache(t *testing.T) {
+	_, c := newItemTestCache(t)
+	item, _ := c.get("potato")
+	require.NoError(t, item.Open(nil))
+	require.NoError(t, item.Close(nil))
+
+	// An existing unencrypted cache can't be encrypted
+	opt := *c.opt
+	opt.CachePassword = obscure.MustObscure("potato")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := New(ctx, c.fremote, &opt, nil)
+	assert.ErrorContains(t, err, "the cache isn't encrypted")
+}
@@ -0,0 +1,131 @@
+package vfscache
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// maxGhosts is the number of files removed from the cache whose use
+// count is remembered
+const maxGhosts = 10000
+
+// evictionStats counts how well the eviction policy is working
+type evictionStats struct {
+	hits         atomic.Int64 // reads satisfied from the cache
+	misses       atomic.Int64 // reads which needed a download
+	evicted      atomic.Int64 // files removed or emptied to get under quota
+	evictedBytes atomic.Int64 // space freed by those files
+}
+
+// evictBefore returns true if the cache file described by a should
+// be removed from the cache before the one described by b using
+// policy.
+//
+// Call with the locks for the items held.
+func evictBefore(policy vfscommon.CacheEviction, a, b *Info, now time.Time) bool {
+	switch policy {
+	case vfscommon.CacheEvictionLFU:
+		if a.Accesses != b.Accesses {
+			return a.Accesses < b.Accesses
+		}
+	case vfscommon.CacheEvictionOnce:
+		// Files only used once, for example by a job reading
+		// everything, go before files which are used again
+		aOnce, bOnce := a.Accesses <= 1, b.Accesses <= 1
+		if aOnce != bOnce {
+			return aOnce
+		}
+	case vfscommon.CacheEvictionSize:
+		// Weight the time since last use by the space used so
+		// big files which haven't been used for a while go first
+		aScore := float64(a.Rs.Size()) * (now.Sub(a.ATime).Seconds() + 1)
+		bScore := float64(b.Rs.Size()) * (now.Sub(b.ATime).Seconds() + 1)
+		if aScore != bScore {
+			return aScore > bScore
+		}
+	}
+	return a.ATime.Before(b.ATime)
+}
+
+// evictionOrder sorts Items into the order the eviction policy
+// removes them in, using the same time for all the comparisons
+type evictionOrder struct {
+	Items
+	policy vfscommon.CacheEviction
+	now    time.Time
+}
+
+// Less returns true if item i should be evicted before item j
+func (v evictionOrder) Less(i, j int) bool {
+	if i == j {
+		return false
+	}
+	iItem := v.Items[i]
+	jItem := v.Items[j]
+	iItem.mu.Lock()
+	defer iItem.mu.Unlock()
+	jItem.mu.Lock()
+	defer jItem.mu.Unlock()
+
+	return evictBefore(v.policy, &iItem.info, &jItem.info, v.now)
+}
+
+// evicted records that item was removed or emptied to get the cache
+// under quota freeing spaceFreed bytes.
+//
+// If removed is set the item is no longer in the cache so its use
+// count is remembered in case it comes back.
+//
+// call with c.mu held
+func (c *Cache) _evicted(item *Item, removed bool, spaceFreed int64) {
+	c.evictionStats.evicted.Add(1)
+	c.evictionStats.evictedBytes.Add(spaceFreed)
+	if !removed {
+		return
+	}
+	accesses := item.getAccesses()
+	if accesses == 0 {
+		return
+	}
+	if c.ghosts == nil {
+		c.ghosts = make(map[string]int64)
+	}
+	if _, found := c.ghosts[item.name]; !found {
+		c.ghostOrder = append(c.ghostOrder, item.name)
+	}
+	c.ghosts[item.name] = accesses
+	for len(c.ghostOrder) > maxGhosts {
+		delete(c.ghosts, c.ghostOrder[0])
+		c.ghostOrder = c.ghostOrder[1:]
+	}
+}
+
+// _restoreGhost sets the use count of a new item from the one it had
+// when it was last removed from the cache, if known.
+//
+// call with c.mu held
+func (c *Cache) _restoreGhost(item *Item) {
+	accesses, found := c.ghosts[item.name]
+	if !found {
+		return
+	}
+	item.mu.Lock()
+	if item.info.Accesses == 0 {
+		item.info.Accesses = accesses
+	}
+	item.mu.Unlock()
+}
+
+// evictionStatsParams returns the stats for the eviction policy
+func (c *Cache) evictionStatsParams() rc.Params {
+	return rc.Params{
+		"policy":       c.opt.CacheEviction.String(),
+		"hits":         c.evictionStats.hits.Load(),
+		"misses":       c.evictionStats.misses.Load(),
+		"evicted":      c.evictionStats.evicted.Load(),
+		"evictedBytes": c.evictionStats.evictedBytes.Load(),
+	}
+}
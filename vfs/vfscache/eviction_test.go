@@ -0,0 +1,94 @@
+package vfscache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs/rc"
+	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvictBefore(t *testing.T) {
+	now := time.Now()
+	info := func(age time.Duration, accesses int64, size int64) *Info {
+		return &Info{
+			ATime:    now.Add(-age),
+			Accesses: accesses,
+			Rs:       ranges.Ranges{{Pos: 0, Size: size}},
+		}
+	}
+	old := info(time.Hour, 5, 1)         // old, used often, small
+	recent := info(time.Minute, 1, 100)  // recent, used once, big
+	scanned := info(time.Second, 1, 100) // very recent, used once, big
+	for _, test := range []struct {
+		policy vfscommon.CacheEviction
+		a, b   *Info
+		want   bool
+	}{
+		{vfscommon.CacheEvictionLRU, old, recent, true},
+		{vfscommon.CacheEvictionLRU, recent, old, false},
+		{vfscommon.CacheEvictionLFU, old, recent, false},
+		{vfscommon.CacheEvictionLFU, recent, old, true},
+		{vfscommon.CacheEvictionLFU, recent, scanned, true},
+		{vfscommon.CacheEvictionOnce, old, scanned, false},
+		{vfscommon.CacheEvictionOnce, scanned, old, true},
+		{vfscommon.CacheEvictionOnce, recent, scanned, true},
+		{vfscommon.CacheEvictionSize, old, recent, false},
+		{vfscommon.CacheEvictionSize, recent, old, true},
+		{vfscommon.CacheEvictionSize, recent, scanned, true},
+	} {
+		got := evictBefore(test.policy, test.a, test.b, now)
+		assert.Equal(t, test.want, got, fmt.Sprintf("%v %+v %+v", test.policy, test.a, test.b))
+	}
+}
+
+func TestCacheEvictionOnce(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	opt.WriteBack = 0
+	opt.CacheEviction = vfscommon.CacheEvictionOnce
+	_, c := newTestCacheOpt(t, opt)
+
+	// A file used often but not recently
+	hot := c.Item("hot")
+	for range 3 {
+		require.NoError(t, hot.Open(nil))
+		require.NoError(t, hot.Close(nil))
+	}
+	require.NoError(t, hot.Open(nil))
+	require.NoError(t, hot.Truncate(5))
+	require.NoError(t, hot.Close(nil))
+	hot.mu.Lock()
+	hot.info.ATime = time.Now().Add(-time.Hour)
+	hot.mu.Unlock()
+
+	// Files read once by a scan
+	for i := range 3 {
+		item := c.Item(fmt.Sprintf("scan%d", i))
+		require.NoError(t, item.Open(nil))
+		require.NoError(t, item.Truncate(6))
+		require.NoError(t, item.Close(nil))
+	}
+
+	c.updateUsed()
+	c.opt.CacheMaxSize = 5
+	c.purgeClean()
+	assert.Equal(t, []string{
+		`name="hot" opens=0 size=5 space=5`,
+	}, itemSpaceAsString(c))
+
+	stats := c.Stats()["eviction"].(rc.Params)
+	assert.Equal(t, "once", stats["policy"])
+	assert.Equal(t, int64(3), stats["evicted"])
+	assert.Equal(t, int64(18), stats["evictedBytes"])
+
+	// A scanned file used again remembers it was used before
+	scan := c.Item("scan0")
+	require.NoError(t, scan.Open(nil))
+	assert.Equal(t, int64(2), scan.getAccesses())
+	require.NoError(t, scan.Close(nil))
+}
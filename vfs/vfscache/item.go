@@ -13,10 +13,11 @@ import (
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/fserrors"
 	"github.com/rclone/rclone/fs/operations"
-	"github.com/rclone/rclone/lib/file"
 	"github.com/rclone/rclone/lib/ranges"
+	"github.com/rclone/rclone/lib/transform"
 	"github.com/rclone/rclone/vfs/vfscache/downloaders"
 	"github.com/rclone/rclone/vfs/vfscache/writeback"
+	"github.com/rclone/rclone/vfs/vfscommon"
 )
 
 // NB as Cache and Item are tightly linked it is necessary to have a
@@ -62,8 +63,9 @@ type Item struct {
 	opens           int                      // number of times file is open
 	downloaders     *downloaders.Downloaders // a record of the downloaders in action - may be nil
 	o               fs.Object                // object we are caching - may be nil
-	fd              *os.File                 // handle we are using to read and write to the file
+	fd              cacheFile                // handle we are using to read and write to the file
 	info            Info                     // info about the file to persist to backing store
+	enc             *encState                // state of the cache file if it is encrypted
 	writeBackID     writeback.Handle         // id of any writebacks in progress
 	pendingAccesses int                      // number of threads - cache reset not allowed if not zero
 	modified        bool                     // set if the file has been modified since the last Open
@@ -78,6 +80,7 @@ type Info struct {
 	Rs          ranges.Ranges // which parts of the file are present
 	Fingerprint string        // fingerprint of remote object
 	Dirty       bool          // set if the backing file has been modified
+	Accesses    int64         // number of times the file has been opened
 }
 
 // Items are a slice of *Item ordered by ATime
@@ -118,10 +121,14 @@ func (v Items) Less(i, j int) bool {
 }
 
 // clean the item after its cache file has been deleted
+//
+// The number of accesses is kept for the eviction policy.
 func (info *Info) clean() {
+	accesses := info.Accesses
 	*info = Info{}
 	info.ModTime = time.Now()
 	info.ATime = info.ModTime
+	info.Accesses = accesses
 }
 
 // StoreFn is called back with an object after it has been uploaded
@@ -139,9 +146,12 @@ func newItem(c *Cache, name string) (item *Item) {
 		},
 	}
 	item.cond = sync.Cond{L: &item.mu}
+	if c.cipher != nil {
+		item.enc = newEncState()
+	}
 	// check the cache file exists
 	osPath := c.toOSPath(name)
-	fi, statErr := os.Stat(osPath)
+	fi, statErr := c.stat(osPath)
 	if statErr != nil {
 		if os.IsNotExist(statErr) {
 			item._removeMeta("cache file doesn't exist")
@@ -182,21 +192,30 @@ func (item *Item) getDiskSize() int64 {
 	return item.info.Rs.Size()
 }
 
+// getAccesses returns the number of times the item has been opened
+func (item *Item) getAccesses() int64 {
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	return item.info.Accesses
+}
+
 // load reads an item from the disk or returns nil if not found
 func (item *Item) load() (exists bool, err error) {
 	item.mu.Lock()
 	defer item.mu.Unlock()
 	osPathMeta := item.c.toOSPathMeta(item.name) // No locking in Cache
-	in, err := os.Open(osPathMeta)
+	data, err := os.ReadFile(osPathMeta)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, err
 		}
 		return true, fmt.Errorf("vfs cache item: failed to read metadata: %w", err)
 	}
-	defer fs.CheckClose(in, &err)
-	decoder := json.NewDecoder(in)
-	err = decoder.Decode(&item.info)
+	data, err = item._decodeMeta(data)
+	if err != nil {
+		return true, fmt.Errorf("vfs cache item: failed to decrypt metadata: %w", err)
+	}
+	err = json.Unmarshal(data, &item.info)
 	if err != nil {
 		return true, fmt.Errorf("vfs cache item: corrupt metadata: %w", err)
 	}
@@ -208,16 +227,17 @@ func (item *Item) load() (exists bool, err error) {
 // call with the lock held
 func (item *Item) _save() (err error) {
 	osPathMeta := item.c.toOSPathMeta(item.name) // No locking in Cache
-	out, err := os.Create(osPathMeta)
+	data, err := json.MarshalIndent(item.info, "", "\t")
 	if err != nil {
-		return fmt.Errorf("vfs cache item: failed to write metadata: %w", err)
+		return fmt.Errorf("vfs cache item: failed to encode metadata: %w", err)
 	}
-	defer fs.CheckClose(out, &err)
-	encoder := json.NewEncoder(out)
-	encoder.SetIndent("", "\t")
-	err = encoder.Encode(item.info)
+	data, err = item._encodeMeta(append(data, '\n'))
 	if err != nil {
-		return fmt.Errorf("vfs cache item: failed to encode metadata: %w", err)
+		return fmt.Errorf("vfs cache item: failed to encrypt metadata: %w", err)
+	}
+	err = os.WriteFile(osPathMeta, data, 0666)
+	if err != nil {
+		return fmt.Errorf("vfs cache item: failed to write metadata: %w", err)
 	}
 	return nil
 }
@@ -243,7 +263,7 @@ func (item *Item) _truncate(size int64) (err error) {
 			oFlags |= os.O_CREATE
 		}
 		osPath := item.c.toOSPath(item.name) // No locking in Cache
-		fd, err = file.OpenFile(osPath, oFlags, 0600)
+		fd, err = item.c.openFile(osPath, oFlags, item.enc)
 		if err != nil && os.IsNotExist(err) {
 			// If the metadata has info but the file doesn't
 			// not exist then it has been externally removed
@@ -251,7 +271,8 @@ func (item *Item) _truncate(size int64) (err error) {
 			item.info.Rs = nil      // show we have no blocks cached
 			item.info.Dirty = false // file can't be dirty if it doesn't exist
 			item._removeMeta("cache file externally deleted")
-			fd, err = file.OpenFile(osPath, os.O_CREATE|os.O_WRONLY, 0600)
+			item.enc.clear()
+			fd, err = item.c.openFile(osPath, os.O_CREATE|os.O_WRONLY, item.enc)
 		}
 		if err != nil {
 			return fmt.Errorf("vfs cache: truncate: failed to open cache file: %w", err)
@@ -259,7 +280,7 @@ func (item *Item) _truncate(size int64) (err error) {
 
 		defer fs.CheckClose(fd, &err)
 
-		err = file.SetSparse(fd)
+		err = setSparse(fd)
 		if err != nil {
 			fs.Errorf(item.name, "vfs cache: truncate: failed to set as a sparse file: %v", err)
 		}
@@ -371,7 +392,7 @@ func (item *Item) _stat() (fi os.FileInfo, err error) {
 		return item.fd.Stat()
 	}
 	osPath := item.c.toOSPath(item.name) // No locking in Cache
-	return os.Stat(osPath)
+	return item.c.stat(osPath)
 }
 
 // _getSize gets the current size of the item and updates item.info.Size
@@ -468,12 +489,12 @@ func (item *Item) _createFile(osPath string) (err error) {
 	}
 	item.modified = false
 	// t0 := time.Now()
-	fd, err := file.OpenFile(osPath, os.O_RDWR, 0600)
+	fd, err := item.c.openFile(osPath, os.O_RDWR, item.enc)
 	// fs.Debugf(item.name, "OpenFile took %v", time.Since(t0))
 	if err != nil {
 		return fmt.Errorf("vfs cache item: open failed: %w", err)
 	}
-	err = file.SetSparse(fd)
+	err = setSparse(fd)
 	if err != nil {
 		fs.Errorf(item.name, "vfs cache: failed to set as a sparse file: %v", err)
 	}
@@ -519,6 +540,7 @@ func (item *Item) open(o fs.Object) (err error) {
 	defer item.mu.Unlock()
 
 	item.info.ATime = time.Now()
+	item.info.Accesses++
 
 	osPath, err := item.c.createItemDir(item.name) // No locking in Cache
 	if err != nil {
@@ -573,6 +595,29 @@ func (item *Item) open(o fs.Object) (err error) {
 	return err
 }
 
+// Fetch opens the item and downloads all of the object o into the
+// cache if it isn't there already.
+func (item *Item) Fetch(o fs.Object) (err error) {
+	err = item.Open(o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := item.Close(nil)
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	item.preAccess()
+	defer item.postAccess()
+	item.mu.Lock()
+	defer item.mu.Unlock()
+	if item._present() {
+		return nil
+	}
+	return item._ensure(0, item.info.Size)
+}
+
 // Calls f with mu unlocked, re-locking mu if a panic is raised
 //
 // mu must be locked when calling this function
@@ -590,11 +635,27 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 	// defer log.Trace(item.name, "item=%p", item)("err=%v", &err)
 
 	// Transfer the temp file to the remote
-	cacheObj, err := item.c.fcache.NewObject(ctx, item.name)
+	cacheObj, err := item.c.fcache.NewObject(ctx, item.c.cacheName(item.name))
 	if err != nil && err != fs.ErrorObjectNotFound {
 		return fmt.Errorf("vfs cache: failed to find cache file: %w", err)
 	}
 
+	// Upload the unencrypted data if the cache is encrypted
+	if cacheObj != nil && item.c.cipher != nil {
+		cacheObj, err = item.c.newEncryptedObject(cacheObj, item.c.toOSPath(item.name), item.enc)
+		if err != nil {
+			return fmt.Errorf("vfs cache: failed to open encrypted cache file: %w", err)
+		}
+	}
+
+	// Check the remote hasn't changed since the file was opened
+	if cacheObj != nil && item.c.opt.WriteBackConflict != vfscommon.WriteBackConflictOverwrite {
+		err = item._checkConflict(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Object has disappeared if cacheObj == nil
 	if cacheObj != nil {
 		o, name := item.o, item.name
@@ -634,6 +695,71 @@ func (item *Item) _store(ctx context.Context, storeFn StoreFn) (err error) {
 	return nil
 }
 
+// conflictSuffix is added to the name of a remote file which changed
+// before writeback with --vfs-write-back-conflict keep-both
+const conflictSuffix = ".conflict-20060102-150405"
+
+// _checkConflict checks whether the remote file has changed since the
+// item was opened by comparing its fingerprint with the one recorded
+// then, and if it has applies the --vfs-write-back-conflict policy.
+//
+// It returns writeback.ErrorConflict if the item shouldn't be uploaded.
+// If the user retried the upload with vfs/queue-set-expiry after a
+// conflict the fingerprint is updated to the remote file's so it is
+// overwritten.
+//
+// Call with lock held
+func (item *Item) _checkConflict(ctx context.Context) (err error) {
+	name, fingerprint := item.name, item.info.Fingerprint
+	var remote fs.Object
+	unlockMutexForCall(&item.mu, func() {
+		remote, err = item.c.fremote.NewObject(ctx, name)
+	})
+	if errors.Is(err, fs.ErrorObjectNotFound) {
+		// Nothing to overwrite
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("vfs cache: failed to check remote file for conflicts: %w", err)
+	}
+	remoteFingerprint := fs.Fingerprint(ctx, remote, item.c.opt.FastFingerprint)
+	if remoteFingerprint == fingerprint {
+		return nil
+	}
+	fs.Debugf(name, "vfs cache: remote fingerprint %q != fingerprint when opened %q", remoteFingerprint, fingerprint)
+	if writeback.Overwrite(ctx) {
+		// The upload was retried after the conflict so take the
+		// remote file as the one to overwrite
+		fs.Logf(name, "vfs cache: overwriting remote file which changed since it was opened as the upload was retried")
+		item.info.Fingerprint = remoteFingerprint
+		return nil
+	}
+	if item.c.opt.WriteBackConflict == vfscommon.WriteBackConflictAbort {
+		return fmt.Errorf("%w - keeping the local copy in the cache", writeback.ErrorConflict)
+	}
+
+	// Move the remote file out of the way so we don't overwrite it
+	conflictName := transform.SuffixKeepExtension(name, time.Now().Format(conflictSuffix))
+	unlockMutexForCall(&item.mu, func() {
+		_, err = operations.Move(ctx, item.c.fremote, nil, conflictName, remote)
+	})
+	if err != nil {
+		return fmt.Errorf("vfs cache: failed to move changed remote file to %q: %w", conflictName, err)
+	}
+	fs.Logf(name, "vfs cache: remote file has changed since it was opened - moved it to %q", conflictName)
+	if item.c.avFn != nil {
+		unlockMutexForCall(&item.mu, func() {
+			err = item.c.AddVirtual(conflictName, remote.Size(), false)
+		})
+		if err != nil {
+			fs.Errorf(conflictName, "vfs cache: failed to add virtual dir entry: %v", err)
+		}
+	}
+
+	// The old object may refer to the moved file so upload a new one
+	item.o = nil
+	return nil
+}
+
 // Store stores the local cache file to the remote object, returning
 // the new remote object. objOld is the old object if known.
 func (item *Item) store(ctx context.Context, storeFn StoreFn) (err error) {
@@ -854,6 +980,7 @@ func (item *Item) WrittenBack() bool {
 func (item *Item) _removeFile(reason string) {
 	osPath := item.c.toOSPath(item.name) // No locking in Cache
 	err := os.Remove(osPath)
+	item.enc.clear()
 	if err != nil {
 		if !os.IsNotExist(err) {
 			fs.Errorf(item.name, "vfs cache: failed to remove cache file as %s: %v", reason, err)
@@ -1145,6 +1272,11 @@ func (item *Item) _ensure(offset, size int64) (err error) {
 		return errors.New("no space left on device")
 	} */
 	fs.Debugf(nil, "vfs cache: looking for range=%+v in %+v - present %v", r, item.info.Rs, present)
+	if present {
+		item.c.evictionStats.hits.Add(1)
+	} else {
+		item.c.evictionStats.misses.Add(1)
+	}
 	item.mu.Unlock()
 	defer item.mu.Lock()
 	if present {
@@ -1442,6 +1574,13 @@ func (item *Item) rename(name string, newName string, newObj fs.Object) (err err
 		err = err2
 	}
 
+	// Encrypted metadata is sealed with the name so save it again
+	if err == nil && item.c.cipher != nil {
+		if _, statErr := os.Stat(item.c.toOSPathMeta(newName)); statErr == nil {
+			err = item._save()
+		}
+	}
+
 	item.mu.Unlock()
 
 	// close downloader and cancel writebacks with mutex unlocked
@@ -16,6 +16,7 @@ import (
 	"github.com/rclone/rclone/fstest"
 	"github.com/rclone/rclone/lib/random"
 	"github.com/rclone/rclone/lib/readers"
+	"github.com/rclone/rclone/vfs/vfscache/writeback"
 	"github.com/rclone/rclone/vfs/vfscommon"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -266,6 +267,87 @@ func TestItemWriteAtExisting(t *testing.T) {
 	checkObject(t, r, "existing", contents[:10]+"HELLO"+contents[15:95]+"THEND"+zeroes[:20]+"THEVERYEND")
 }
 
+func TestItemWriteBackConflict(t *testing.T) {
+	for _, policy := range []vfscommon.WriteBackConflict{
+		vfscommon.WriteBackConflictOverwrite,
+		vfscommon.WriteBackConflictKeepBoth,
+		vfscommon.WriteBackConflictAbort,
+	} {
+		t.Run(policy.String(), func(t *testing.T) {
+			opt := vfscommon.Opt
+			opt.CachePollInterval = 0
+			opt.WriteBack = 0
+			opt.WriteBackConflict = policy
+			r, c := newTestCacheOpt(t, opt)
+			ctx := context.Background()
+
+			_, obj, item := newFile(t, r, c, "existing.txt")
+			require.NoError(t, item.Open(obj))
+			_, err := item.WriteAt([]byte("local"), 0)
+			require.NoError(t, err)
+			err = item.Truncate(5)
+			require.NoError(t, err)
+
+			// Another client changes the file
+			r.WriteObject(ctx, "existing.txt", "changed by someone else", time.Now().Add(time.Minute))
+
+			err = item.Close(nil)
+			switch policy {
+			case vfscommon.WriteBackConflictOverwrite:
+				require.NoError(t, err)
+				checkObject(t, r, "existing.txt", "local")
+			case vfscommon.WriteBackConflictKeepBoth:
+				require.NoError(t, err)
+				checkObject(t, r, "existing.txt", "local")
+				require.Len(t, avInfos, 1)
+				conflictName := avInfos[0].Remote
+				assert.Regexp(t, `^existing\.conflict-\d{8}-\d{6}\.txt$`, conflictName)
+				checkObject(t, r, conflictName, "changed by someone else")
+			case vfscommon.WriteBackConflictAbort:
+				assert.ErrorIs(t, err, writeback.ErrorConflict)
+				checkObject(t, r, "existing.txt", "changed by someone else")
+				assert.True(t, item.IsDirty())
+			}
+		})
+	}
+}
+
+func TestItemWriteBackConflictRetry(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.CachePollInterval = 0
+	opt.WriteBack = fs.Duration(10 * time.Millisecond)
+	opt.WriteBackConflict = vfscommon.WriteBackConflictAbort
+	r, c := newTestCacheOpt(t, opt)
+	ctx := context.Background()
+
+	_, obj, item := newFile(t, r, c, "existing.txt")
+	require.NoError(t, item.Open(obj))
+	_, err := item.WriteAt([]byte("local"), 0)
+	require.NoError(t, err)
+	require.NoError(t, item.Truncate(5))
+
+	// Another client changes the file
+	r.WriteObject(ctx, "existing.txt", "changed by someone else", time.Now().Add(time.Minute))
+
+	// The upload is abandoned
+	require.NoError(t, item.Close(nil))
+	var queue []writeback.QueueInfo
+	require.Eventually(t, func() bool {
+		queue = c.writeback.Queue()
+		return len(queue) == 1 && queue[0].Conflict
+	}, 10*time.Second, 10*time.Millisecond)
+	checkObject(t, r, "existing.txt", "changed by someone else")
+	assert.True(t, item.IsDirty())
+
+	// Setting the expiry retries it, overwriting the remote file
+	require.NoError(t, c.QueueSetExpiry(queue[0].ID, time.Now(), 0))
+	require.Eventually(t, func() bool {
+		return len(c.writeback.Queue()) == 0
+	}, 10*time.Second, 10*time.Millisecond)
+	checkObject(t, r, "existing.txt", "local")
+	assert.False(t, item.IsDirty())
+}
+
 func TestItemLoadMeta(t *testing.T) {
 	r, c := newItemTestCache(t)
 
@@ -0,0 +1,366 @@
+package vfscache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/filter"
+	"github.com/rclone/rclone/fs/walk"
+	"github.com/rclone/rclone/lib/file"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"golang.org/x/sync/errgroup"
+)
+
+// Pin describes files which are downloaded into the cache and kept
+// there, so they are available offline.
+type Pin struct {
+	Path   string   `json:"path"`             // file or directory to pin, "" for the root
+	Filter []string `json:"filter,omitempty"` // if set, filter rules choosing the files under Path to pin
+}
+
+// States of a pin
+const (
+	pinListing     = "listing"     // finding the files to pin
+	pinDownloading = "downloading" // downloading the files into the cache
+	pinDone        = "done"        // all the files are in the cache
+	pinFailed      = "failed"      // some of the files couldn't be downloaded
+	pinCancelled   = "cancelled"   // the download was stopped
+)
+
+// PinStatus describes a pin and the progress of its download
+type PinStatus struct {
+	Pin
+	FromFlag  bool   `json:"fromFlag"`            // set if the pin came from --vfs-pin
+	State     string `json:"state"`               // one of listing, downloading, done, failed, cancelled
+	Files     int64  `json:"files"`               // number of files pinned
+	FilesDone int64  `json:"filesDone"`           // number of files in the cache
+	Bytes     int64  `json:"bytes"`               // total size of the files pinned
+	BytesDone int64  `json:"bytesDone"`           // size of the files in the cache
+	Errors    int64  `json:"errors"`              // number of files which failed to download
+	LastError string `json:"lastError,omitempty"` // the last error if any
+}
+
+// pinState is a pin in use by the cache
+//
+// The status is protected by Cache.pinMu
+type pinState struct {
+	status PinStatus
+	filter *filter.Filter     // compiled Filter or nil
+	cancel context.CancelFunc // cancel the download
+	done   chan struct{}      // closed when the download has finished
+}
+
+// newPinState checks pin is valid and makes a pinState for it
+func newPinState(pin Pin, fromFlag bool) (*pinState, error) {
+	pin.Path = clean(pin.Path)
+	ps := &pinState{
+		status: PinStatus{
+			Pin:      pin,
+			FromFlag: fromFlag,
+		},
+	}
+	if len(pin.Filter) > 0 {
+		opt := filter.Opt
+		opt.RulesOpt = filter.RulesOpt{
+			// Files not chosen by the rules aren't pinned
+			FilterRule: append(slices.Clone(pin.Filter), "- **"),
+		}
+		var err error
+		ps.filter, err = filter.NewFilter(&opt)
+		if err != nil {
+			return nil, fmt.Errorf("bad pin filter: %w", err)
+		}
+	}
+	return ps, nil
+}
+
+// matches returns true if name is pinned by this pin
+func (ps *pinState) matches(name string) bool {
+	rel := name
+	if pinPath := ps.status.Path; pinPath != "" {
+		if name == pinPath {
+			rel = path.Base(name)
+		} else if after, ok := strings.CutPrefix(name, pinPath+"/"); ok {
+			rel = after
+		} else {
+			return false
+		}
+	}
+	return ps.filter == nil || ps.filter.IncludeRemote(rel)
+}
+
+// same returns true if ps is for the same pin as pin
+func (ps *pinState) same(pin Pin) bool {
+	return ps.status.Path == clean(pin.Path) && slices.Equal(ps.status.Filter, pin.Filter)
+}
+
+// initPins loads the persisted pins and the pins from --vfs-pin and
+// starts them downloading
+func (c *Cache) initPins(ctx context.Context, pinsPath string) error {
+	c.pinCtx = ctx
+	c.pinsPath = pinsPath
+	if c.opt.CacheMode < vfscommon.CacheModeFull {
+		if len(c.opt.Pin) > 0 {
+			return errors.New("--vfs-pin needs --vfs-cache-mode full")
+		}
+		return nil
+	}
+	var pins []Pin
+	data, err := os.ReadFile(pinsPath)
+	if err == nil {
+		data, err = c.decodeMeta(data, pinsAD)
+		if err == nil {
+			err = json.Unmarshal(data, &pins)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse pins file %q: %w", pinsPath, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read pins file: %w", err)
+	}
+	for _, pin := range pins {
+		if _, err := c.addPin(pin, false); err != nil {
+			fs.Errorf(c.fremote, "vfs cache: ignoring pin %q: %v", pin.Path, err)
+		}
+	}
+
+	// Paths in --vfs-pin are pinned on their own and filter rules
+	// are combined into one pin for the root
+	var rules []string
+	for _, value := range c.opt.Pin {
+		if strings.HasPrefix(value, "+ ") || strings.HasPrefix(value, "- ") {
+			rules = append(rules, value)
+			continue
+		}
+		if _, err := c.addPin(Pin{Path: value}, true); err != nil {
+			return fmt.Errorf("--vfs-pin %q: %w", value, err)
+		}
+	}
+	if len(rules) > 0 {
+		if _, err := c.addPin(Pin{Filter: rules}, true); err != nil {
+			return fmt.Errorf("--vfs-pin: %w", err)
+		}
+	}
+	return nil
+}
+
+// savePins writes the pins which didn't come from flags to disk
+//
+// call with pinMu held
+func (c *Cache) _savePins() error {
+	pins := []Pin{}
+	for _, ps := range c.pins {
+		if !ps.status.FromFlag {
+			pins = append(pins, ps.status.Pin)
+		}
+	}
+	data, err := json.MarshalIndent(pins, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode pins: %w", err)
+	}
+	data = c.encodeMeta(data, pinsAD)
+	err = file.MkdirAll(filepath.Dir(c.pinsPath), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make pins directory: %w", err)
+	}
+	tmpPath := c.pinsPath + ".tmp"
+	err = os.WriteFile(tmpPath, data, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, c.pinsPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write pins file: %w", err)
+	}
+	return nil
+}
+
+// addPin adds pin, replacing any identical pin, and starts it
+// downloading
+func (c *Cache) addPin(pin Pin, fromFlag bool) (*pinState, error) {
+	ps, err := newPinState(pin, fromFlag)
+	if err != nil {
+		return nil, err
+	}
+	c.pinMu.Lock()
+	defer c.pinMu.Unlock()
+	c.pins = slices.DeleteFunc(c.pins, func(old *pinState) bool {
+		if old.same(pin) {
+			old.cancel()
+			return true
+		}
+		return false
+	})
+	c.pins = append(c.pins, ps)
+	ctx, cancel := context.WithCancel(c.pinCtx)
+	ps.cancel = cancel
+	ps.done = make(chan struct{})
+	ps.status.State = pinListing
+	go c.pinDownload(ctx, ps)
+	return ps, nil
+}
+
+// Pin adds pin to the cache, saves it so it will be used when the
+// cache is next started, and starts downloading the files it chooses
+// into the cache in the background.
+//
+// The files chosen by pin won't be removed from the cache.
+func (c *Cache) Pin(pin Pin) (PinStatus, error) {
+	if c.opt.CacheMode < vfscommon.CacheModeFull {
+		return PinStatus{}, errors.New("pinning needs --vfs-cache-mode full")
+	}
+	ps, err := c.addPin(pin, false)
+	if err != nil {
+		return PinStatus{}, err
+	}
+	c.pinMu.Lock()
+	defer c.pinMu.Unlock()
+	return ps.status, c._savePins()
+}
+
+// Unpin removes the pins for pinPath so the files they chose can be
+// removed from the cache as normal.
+//
+// It returns the number of pins removed.
+func (c *Cache) Unpin(pinPath string) (n int, err error) {
+	pinPath = clean(pinPath)
+	c.pinMu.Lock()
+	defer c.pinMu.Unlock()
+	c.pins = slices.DeleteFunc(c.pins, func(ps *pinState) bool {
+		if ps.status.Path == pinPath {
+			ps.cancel()
+			n++
+			return true
+		}
+		return false
+	})
+	if n == 0 {
+		return 0, fmt.Errorf("no pins found for %q", pinPath)
+	}
+	return n, c._savePins()
+}
+
+// Pinned returns the status of the pins
+func (c *Cache) Pinned() []PinStatus {
+	c.pinMu.Lock()
+	defer c.pinMu.Unlock()
+	out := make([]PinStatus, 0, len(c.pins))
+	for _, ps := range c.pins {
+		out = append(out, ps.status)
+	}
+	return out
+}
+
+// isPinned returns true if name is chosen by any of the pins
+func (c *Cache) isPinned(name string) bool {
+	c.pinMu.Lock()
+	defer c.pinMu.Unlock()
+	for _, ps := range c.pins {
+		if ps.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// waitPin waits for the download of the pin to finish - for testing
+func (c *Cache) waitPin(pin Pin) {
+	c.pinMu.Lock()
+	var done chan struct{}
+	for _, ps := range c.pins {
+		if ps.same(pin) {
+			done = ps.done
+		}
+	}
+	c.pinMu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// pinDownload finds the files chosen by ps and downloads them into
+// the cache.
+func (c *Cache) pinDownload(ctx context.Context, ps *pinState) {
+	defer close(ps.done)
+	update := func(fn func(s *PinStatus)) {
+		c.pinMu.Lock()
+		fn(&ps.status)
+		c.pinMu.Unlock()
+	}
+	fail := func(err error) {
+		update(func(s *PinStatus) {
+			s.Errors++
+			s.LastError = err.Error()
+		})
+	}
+
+	// Find the files - Path may be a file or a directory
+	var objs []fs.Object
+	pinPath := ps.status.Path
+	o, err := c.fremote.NewObject(ctx, pinPath)
+	if err == nil && pinPath != "" {
+		objs = append(objs, o)
+	} else {
+		err = walk.ListR(ctx, c.fremote, pinPath, false, -1, walk.ListObjects, func(entries fs.DirEntries) error {
+			for _, entry := range entries {
+				if o, ok := entry.(fs.Object); ok && ps.matches(o.Remote()) {
+					objs = append(objs, o)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fs.Errorf(c.fremote, "vfs cache: failed to list pin %q: %v", pinPath, err)
+			fail(err)
+		}
+	}
+	update(func(s *PinStatus) {
+		s.State = pinDownloading
+		s.Files = int64(len(objs))
+		for _, o := range objs {
+			s.Bytes += o.Size()
+		}
+	})
+
+	// Download them into the cache
+	g := new(errgroup.Group)
+	g.SetLimit(fs.GetConfig(ctx).Transfers)
+	for _, o := range objs {
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				return nil
+			}
+			err := c.Item(o.Remote()).Fetch(o)
+			if err != nil {
+				fs.Errorf(o, "vfs cache: failed to download pinned file: %v", err)
+				fail(err)
+				return nil
+			}
+			update(func(s *PinStatus) {
+				s.FilesDone++
+				s.BytesDone += o.Size()
+			})
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	update(func(s *PinStatus) {
+		switch {
+		case ctx.Err() != nil:
+			s.State = pinCancelled
+		case s.Errors > 0:
+			s.State = pinFailed
+		default:
+			s.State = pinDone
+		}
+		fs.Infof(c.fremote, "vfs cache: pin %q %s: %d/%d files", s.Path, s.State, s.FilesDone, s.Files)
+	})
+}
@@ -74,6 +74,8 @@ type writeBackItem struct {
 	putFn     PutFn              // To write the object data
 	tries     int                // number of times we have tried to upload
 	delay     time.Duration      // delay between upload attempts
+	conflict  bool               // set if the upload was abandoned because of a conflict
+	overwrite bool               // set if the upload was retried after a conflict so should overwrite the remote
 }
 
 // A writeBackItems implements a priority queue by implementing
@@ -263,7 +265,7 @@ func (wb *WriteBack) Add(id Handle, name string, size int64, modified bool, putF
 	wbItem, ok := wb.lookup[id]
 	if !ok {
 		wbItem = wb._newItem(id, name, size)
-	} else {
+	} else if !wbItem.conflict {
 		if wbItem.uploading && modified {
 			// We are uploading already so cancel the upload
 			wb._cancelUpload(wbItem)
@@ -334,6 +336,10 @@ func (wb *WriteBack) Rename(id Handle, name string) {
 	}
 
 	wbItem.name = name
+	// Try again under the new name if there was a conflict, checking
+	// for a conflict with any file there too
+	wbItem.overwrite = false
+	wb._retryConflict(wbItem)
 	// Kick the timer on
 	wb.items._update(wbItem, wb._newExpiry())
 
@@ -348,6 +354,9 @@ func (wb *WriteBack) upload(ctx context.Context, wbItem *writeBackItem) {
 	defer wb.mu.Unlock()
 	putFn := wbItem.putFn
 	wbItem.tries++
+	if wbItem.overwrite {
+		ctx = context.WithValue(ctx, overwriteContextKey, true)
+	}
 
 	fs.Debugf(wbItem.name, "vfs cache: starting upload")
 
@@ -360,7 +369,12 @@ func (wb *WriteBack) upload(ctx context.Context, wbItem *writeBackItem) {
 	wbItem.uploading = false
 	wb.uploads--
 
-	if err != nil {
+	if errors.Is(err, ErrorConflict) {
+		// Leave the item in the lookup map so it shows in the
+		// queue but don't retry it
+		fs.Errorf(wbItem.name, "vfs cache: not uploading: %v", err)
+		wbItem.conflict = true
+	} else if err != nil {
 		// FIXME should this have a max number of transfer attempts?
 		wbItem.delay *= 2
 		if wbItem.delay > maxUploadDelay {
@@ -385,6 +399,19 @@ func (wb *WriteBack) upload(ctx context.Context, wbItem *writeBackItem) {
 	close(wbItem.done)
 }
 
+// put an item which wasn't uploaded because of a conflict back on
+// the heap
+//
+// call with lock held
+func (wb *WriteBack) _retryConflict(wbItem *writeBackItem) {
+	if !wbItem.conflict {
+		return
+	}
+	wbItem.conflict = false
+	wbItem.delay = time.Duration(wb.opt.WriteBack)
+	wb._pushItem(wbItem)
+}
+
 // cancel the upload - the item should be on the heap after this returns
 //
 // call with lock held
@@ -475,6 +502,7 @@ type QueueInfo struct {
 	Tries     int     `json:"tries"`     // number of times we have tried to upload
 	Delay     float64 `json:"delay"`     // delay between upload attempts (s)
 	Uploading bool    `json:"uploading"` // true if item is being uploaded
+	Conflict  bool    `json:"conflict"`  // true if the remote changed so the item wasn't uploaded
 }
 
 // Queue return info about the current upload queue
@@ -495,6 +523,7 @@ func (wb *WriteBack) Queue() []QueueInfo {
 			Tries:     wbItem.tries,
 			Delay:     wbItem.delay.Seconds(),
 			Uploading: wbItem.uploading,
+			Conflict:  wbItem.conflict,
 		})
 	}
 
@@ -512,6 +541,25 @@ func (wb *WriteBack) Queue() []QueueInfo {
 // ErrorIDNotFound is returned from SetExpiry when the item is not found
 var ErrorIDNotFound = errors.New("id not found in queue")
 
+// ErrorConflict should be returned from a PutFn if the item shouldn't
+// be uploaded because the remote has changed. The item is kept in
+// the queue but isn't retried unless it is renamed or its expiry is
+// set.
+var ErrorConflict = errors.New("remote file has changed since it was opened")
+
+// Context key for Overwrite
+type overwriteContextKeyType struct{}
+
+var overwriteContextKey = overwriteContextKeyType{}
+
+// Overwrite returns true if the context passed to a PutFn is for an
+// item which returned ErrorConflict and then had its expiry set, so
+// the user has asked for the changed remote file to be overwritten.
+func Overwrite(ctx context.Context) bool {
+	overwrite, _ := ctx.Value(overwriteContextKey).(bool)
+	return overwrite
+}
+
 // SetExpiry sets the expiry time for an item in the writeback queue.
 //
 // id should be as returned from the Queue call
@@ -535,7 +583,13 @@ func (wb *WriteBack) SetExpiry(id Handle, expiry time.Time, relative time.Durati
 	}
 	expiry = expiry.Add(relative)
 
-	// Update the expiry with the user requested value
+	// Update the expiry with the user requested value. If the item
+	// wasn't uploaded because of a conflict this overwrites the
+	// remote file.
+	if wbItem.conflict {
+		wbItem.overwrite = true
+	}
+	wb._retryConflict(wbItem)
 	wb.items._update(wbItem, expiry)
 	wb._resetTimer()
 	return nil
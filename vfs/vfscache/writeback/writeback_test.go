@@ -221,6 +221,7 @@ type putItem struct {
 	running   bool
 	cancelled bool
 	called    bool
+	overwrite bool // set if the put was asked to overwrite
 }
 
 func newPutItem(t *testing.T) *putItem {
@@ -237,6 +238,7 @@ func (pi *putItem) put(ctx context.Context) (err error) {
 
 	pi.mu.Lock()
 	pi.called = true
+	pi.overwrite = Overwrite(ctx)
 	if pi.running {
 		assert.Fail(pi.t, "upload already running")
 	}
@@ -348,6 +350,47 @@ func TestWriteBackAddFailRetry(t *testing.T) {
 	checkNotInLookup(t, wb, wbItem)
 }
 
+func TestWriteBackAddConflict(t *testing.T) {
+	wb, cancel := newTestWriteBack(t)
+	defer cancel()
+
+	pi := newPutItem(t)
+
+	id := wb.Add(0, "one", 10, true, pi.put)
+	wbItem := wb.lookup[id]
+
+	<-pi.started
+	pi.finish(fmt.Errorf("changed: %w", ErrorConflict))
+	waitUntilNoTransfers(t, wb)
+
+	// The item isn't retried but is shown in the queue
+	checkNotOnHeap(t, wb, wbItem)
+	checkInLookup(t, wb, wbItem)
+	queue := wb.Queue()
+	require.Len(t, queue, 1)
+	assert.True(t, queue[0].Conflict)
+
+	// Adding it again doesn't retry it
+	wb.Add(id, "one", 10, false, pi.put)
+	checkNotOnHeap(t, wb, wbItem)
+
+	// Setting the expiry does, overwriting the remote file
+	require.NoError(t, wb.SetExpiry(id, time.Now(), 0))
+	assert.False(t, wbItem.conflict)
+
+	<-pi.started
+	checkNotOnHeap(t, wb, wbItem)
+	checkInLookup(t, wb, wbItem)
+	pi.mu.Lock()
+	assert.True(t, pi.overwrite)
+	pi.mu.Unlock()
+
+	pi.finish(nil) // transfer successful
+	waitUntilNoTransfers(t, wb)
+	checkNotOnHeap(t, wb, wbItem)
+	checkNotInLookup(t, wb, wbItem)
+}
+
 // Now test the upload being cancelled by another upload being added
 func TestWriteBackAddUpdate(t *testing.T) {
 	wb, cancel := newTestWriteBack(t)
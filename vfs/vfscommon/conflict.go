@@ -0,0 +1,31 @@
+package vfscommon
+
+import (
+	"github.com/rclone/rclone/fs"
+)
+
+type writeBackConflictChoices struct{}
+
+func (writeBackConflictChoices) Choices() []string {
+	return []string{
+		WriteBackConflictOverwrite: "overwrite",
+		WriteBackConflictKeepBoth:  "keep-both",
+		WriteBackConflictAbort:     "abort",
+	}
+}
+
+// WriteBackConflict chooses what happens when a file is written back
+// but the remote file has changed since it was opened
+type WriteBackConflict = fs.Enum[writeBackConflictChoices]
+
+// WriteBackConflict options
+const (
+	WriteBackConflictOverwrite WriteBackConflict = iota // upload over the changed remote file
+	WriteBackConflictKeepBoth                           // move the changed remote file to a conflict name then upload
+	WriteBackConflictAbort                              // don't upload and keep the local copy in the cache
+)
+
+// Type of the value
+func (writeBackConflictChoices) Type() string {
+	return "WriteBackConflict"
+}
@@ -0,0 +1,33 @@
+package vfscommon
+
+import (
+	"github.com/rclone/rclone/fs"
+)
+
+type cacheEvictionChoices struct{}
+
+func (cacheEvictionChoices) Choices() []string {
+	return []string{
+		CacheEvictionLRU:  "lru",
+		CacheEvictionLFU:  "lfu",
+		CacheEvictionOnce: "once",
+		CacheEvictionSize: "size",
+	}
+}
+
+// CacheEviction chooses which files are removed from the cache first
+// when it is over quota
+type CacheEviction = fs.Enum[cacheEvictionChoices]
+
+// CacheEviction options
+const (
+	CacheEvictionLRU  CacheEviction = iota // least recently used first
+	CacheEvictionLFU                       // least frequently used first
+	CacheEvictionOnce                      // files used once before files used again, then least recently used
+	CacheEvictionSize                      // largest files which haven't been used for longest first
+)
+
+// Type of the value
+func (cacheEvictionChoices) Type() string {
+	return "CacheEviction"
+}
@@ -35,6 +35,11 @@ var OptionsInfo = fs.Options{{
 	Default: false,
 	Help:    "Refreshes the directory cache recursively in the background on start",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_dir_cache_persist",
+	Default: false,
+	Help:    "Save directory listings in the cache directory and use them when next started",
+	Groups:  "VFS",
 }, {
 	Name:    "poll_interval",
 	Default: fs.Duration(time.Minute),
@@ -70,11 +75,32 @@ var OptionsInfo = fs.Options{{
 	Default: fs.SizeSuffix(-1),
 	Help:    "Max total size of objects in the cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_cache_eviction",
+	Default: CacheEvictionLRU,
+	Help:    "Which files to remove from the cache first when over quota lru|lfu|once|size",
+	Groups:  "VFS",
+}, {
+	Name:       "vfs_cache_password",
+	Default:    "",
+	Help:       "If set, encrypt the files in the cache with a key made from this password (obscured)",
+	Groups:     "VFS",
+	IsPassword: true,
+}, {
+	Name:    "vfs_cache_password_command",
+	Default: fs.SpaceSepList{},
+	Help:    "Command for supplying the password to encrypt the files in the cache",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_cache_min_free_space",
 	Default: fs.SizeSuffix(-1),
 	Help:    "Target minimum free space on the disk containing the cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_pin",
+	Default: []string{},
+	Help:    "Download files under this path or matching this filter rule into the cache and keep them there",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_read_chunk_size",
 	Default: 128 * fs.Mebi,
@@ -130,6 +156,11 @@ var OptionsInfo = fs.Options{{
 	Default: fs.Duration(5 * time.Second),
 	Help:    "Time to writeback files after last use when using cache",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_write_back_conflict",
+	Default: WriteBackConflictOverwrite,
+	Help:    "What to do if the remote file changed before writeback overwrite|keep-both|abort",
+	Groups:  "VFS",
 }, {
 	Name:    "vfs_read_ahead",
 	Default: 0 * fs.Mebi,
@@ -170,6 +201,16 @@ var OptionsInfo = fs.Options{{
 	Default: "",
 	Help:    "Set the extension to read metadata from.",
 	Groups:  "VFS",
+}, {
+	Name:    "vfs_lock_remote",
+	Default: false,
+	Help:    "Store advisory file locks on the remote so other hosts mounting it see them",
+	Groups:  "VFS",
+}, {
+	Name:    "vfs_lock_remote_ttl",
+	Default: fs.Duration(time.Minute),
+	Help:    "Time after which locks stored on the remote are ignored if not refreshed",
+	Groups:  "VFS",
 }}
 
 func init() {
@@ -178,38 +219,46 @@ func init() {
 
 // Options is options for creating the vfs
 type Options struct {
-	NoSeek             bool          `config:"no_seek"`        // don't allow seeking if set
-	NoChecksum         bool          `config:"no_checksum"`    // don't check checksums if set
-	ReadOnly           bool          `config:"read_only"`      // if set VFS is read only
-	Links              bool          `config:"vfs_links"`      // if set interpret link files
-	NoModTime          bool          `config:"no_modtime"`     // don't read mod times for files
-	DirCacheTime       fs.Duration   `config:"dir_cache_time"` // how long to consider directory listing cache valid
-	Refresh            bool          `config:"vfs_refresh"`    // refreshes the directory listing recursively on start
-	PollInterval       fs.Duration   `config:"poll_interval"`
-	Umask              FileMode      `config:"umask"`
-	UID                uint32        `config:"uid"`
-	GID                uint32        `config:"gid"`
-	DirPerms           FileMode      `config:"dir_perms"`
-	FilePerms          FileMode      `config:"file_perms"`
-	LinkPerms          FileMode      `config:"link_perms"`
-	ChunkSize          fs.SizeSuffix `config:"vfs_read_chunk_size"`       // if > 0 read files in chunks
-	ChunkSizeLimit     fs.SizeSuffix `config:"vfs_read_chunk_size_limit"` // if > ChunkSize double the chunk size after each chunk until reached
-	ChunkStreams       int           `config:"vfs_read_chunk_streams"`    // Number of download streams to use
-	CacheMode          CacheMode     `config:"vfs_cache_mode"`
-	CacheMaxAge        fs.Duration   `config:"vfs_cache_max_age"`
-	CacheMaxSize       fs.SizeSuffix `config:"vfs_cache_max_size"`
-	CacheMinFreeSpace  fs.SizeSuffix `config:"vfs_cache_min_free_space"`
-	CachePollInterval  fs.Duration   `config:"vfs_cache_poll_interval"`
-	CaseInsensitive    bool          `config:"vfs_case_insensitive"`
-	BlockNormDupes     bool          `config:"vfs_block_norm_dupes"`
-	WriteWait          fs.Duration   `config:"vfs_write_wait"`       // time to wait for in-sequence write
-	ReadWait           fs.Duration   `config:"vfs_read_wait"`        // time to wait for in-sequence read
-	WriteBack          fs.Duration   `config:"vfs_write_back"`       // time to wait before writing back dirty files
-	ReadAhead          fs.SizeSuffix `config:"vfs_read_ahead"`       // bytes to read ahead in cache mode "full"
-	UsedIsSize         bool          `config:"vfs_used_is_size"`     // if true, use the `rclone size` algorithm for Used size
-	FastFingerprint    bool          `config:"vfs_fast_fingerprint"` // if set use fast fingerprints
-	DiskSpaceTotalSize fs.SizeSuffix `config:"vfs_disk_space_total_size"`
-	MetadataExtension  string        `config:"vfs_metadata_extension"` // if set respond to files with this extension with metadata
+	NoSeek               bool              `config:"no_seek"`               // don't allow seeking if set
+	NoChecksum           bool              `config:"no_checksum"`           // don't check checksums if set
+	ReadOnly             bool              `config:"read_only"`             // if set VFS is read only
+	Links                bool              `config:"vfs_links"`             // if set interpret link files
+	NoModTime            bool              `config:"no_modtime"`            // don't read mod times for files
+	DirCacheTime         fs.Duration       `config:"dir_cache_time"`        // how long to consider directory listing cache valid
+	Refresh              bool              `config:"vfs_refresh"`           // refreshes the directory listing recursively on start
+	DirCachePersist      bool              `config:"vfs_dir_cache_persist"` // save directory listings to disk for the next start
+	PollInterval         fs.Duration       `config:"poll_interval"`
+	Umask                FileMode          `config:"umask"`
+	UID                  uint32            `config:"uid"`
+	GID                  uint32            `config:"gid"`
+	DirPerms             FileMode          `config:"dir_perms"`
+	FilePerms            FileMode          `config:"file_perms"`
+	LinkPerms            FileMode          `config:"link_perms"`
+	ChunkSize            fs.SizeSuffix     `config:"vfs_read_chunk_size"`       // if > 0 read files in chunks
+	ChunkSizeLimit       fs.SizeSuffix     `config:"vfs_read_chunk_size_limit"` // if > ChunkSize double the chunk size after each chunk until reached
+	ChunkStreams         int               `config:"vfs_read_chunk_streams"`    // Number of download streams to use
+	CacheMode            CacheMode         `config:"vfs_cache_mode"`
+	CacheMaxAge          fs.Duration       `config:"vfs_cache_max_age"`
+	CacheMaxSize         fs.SizeSuffix     `config:"vfs_cache_max_size"`
+	CacheMinFreeSpace    fs.SizeSuffix     `config:"vfs_cache_min_free_space"`
+	CacheEviction        CacheEviction     `config:"vfs_cache_eviction"`
+	CachePassword        string            `config:"vfs_cache_password"`         // if set encrypt the cache with this password (obscured)
+	CachePasswordCommand fs.SpaceSepList   `config:"vfs_cache_password_command"` // command to get CachePassword
+	CachePollInterval    fs.Duration       `config:"vfs_cache_poll_interval"`
+	Pin                  []string          `config:"vfs_pin"` // paths or filter rules to keep in the cache
+	CaseInsensitive      bool              `config:"vfs_case_insensitive"`
+	BlockNormDupes       bool              `config:"vfs_block_norm_dupes"`
+	WriteWait            fs.Duration       `config:"vfs_write_wait"`          // time to wait for in-sequence write
+	ReadWait             fs.Duration       `config:"vfs_read_wait"`           // time to wait for in-sequence read
+	WriteBack            fs.Duration       `config:"vfs_write_back"`          // time to wait before writing back dirty files
+	WriteBackConflict    WriteBackConflict `config:"vfs_write_back_conflict"` // what to do if the remote changed before writeback
+	ReadAhead            fs.SizeSuffix     `config:"vfs_read_ahead"`          // bytes to read ahead in cache mode "full"
+	UsedIsSize           bool              `config:"vfs_used_is_size"`        // if true, use the `rclone size` algorithm for Used size
+	FastFingerprint      bool              `config:"vfs_fast_fingerprint"`    // if set use fast fingerprints
+	DiskSpaceTotalSize   fs.SizeSuffix     `config:"vfs_disk_space_total_size"`
+	MetadataExtension    string            `config:"vfs_metadata_extension"` // if set respond to files with this extension with metadata
+	LockRemote           bool              `config:"vfs_lock_remote"`        // if set store advisory locks on the remote
+	LockRemoteTTL        fs.Duration       `config:"vfs_lock_remote_ttl"`    // lock records on the remote expire after this
 }
 
 // Opt is the default options modified by the environment variables and command line flags
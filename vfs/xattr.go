@@ -0,0 +1,215 @@
+package vfs
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+)
+
+// XattrPrefix is the prefix of the extended attributes which show the
+// metadata of files and directories, so the metadata key
+// "content-type" is the extended attribute "user.rclone.content-type".
+const XattrPrefix = "user.rclone."
+
+// Flags for SetXattr
+const (
+	XattrCreate  = 1 // fail if the attribute exists
+	XattrReplace = 2 // fail if the attribute doesn't exist
+)
+
+// metadataCache caches the metadata of a node so the extended
+// attributes can be read without going to the remote each time.
+//
+// The metadata is kept for --dir-cache-time or until the entry of the
+// node changes.
+type metadataCache struct {
+	mu       sync.Mutex
+	entry    fs.DirEntry // the entry the metadata was read for
+	metadata fs.Metadata
+	read     time.Time // when the metadata was read
+}
+
+// get returns the cached metadata if it is for entry and hasn't expired
+func (mc *metadataCache) get(entry fs.DirEntry, maxAge time.Duration) (metadata fs.Metadata, ok bool) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.entry == nil || mc.entry != entry || time.Since(mc.read) > maxAge {
+		return nil, false
+	}
+	return mc.metadata, true
+}
+
+// set caches metadata read for entry
+func (mc *metadataCache) set(entry fs.DirEntry, metadata fs.Metadata) {
+	mc.mu.Lock()
+	mc.entry, mc.metadata, mc.read = entry, metadata, time.Now()
+	mc.mu.Unlock()
+}
+
+// clear removes the cached metadata
+func (mc *metadataCache) clear() {
+	mc.mu.Lock()
+	mc.entry, mc.metadata = nil, nil
+	mc.mu.Unlock()
+}
+
+// nodeMetadataCache returns the metadata cache of node or nil
+func nodeMetadataCache(node Node) *metadataCache {
+	switch x := node.(type) {
+	case *File:
+		return &x.metadata
+	case *Dir:
+		return &x.metadata
+	}
+	return nil
+}
+
+// metadataEntry returns the entry on the remote for node
+func metadataEntry(ctx context.Context, node Node) (fs.DirEntry, error) {
+	entry := node.DirEntry()
+	if entry == nil {
+		return nil, nil
+	}
+	// find the object on the remote if it came from a saved listing
+	if o, ok := entry.(fs.Object); ok {
+		return resolveObject(ctx, o)
+	}
+	return entry, nil
+}
+
+// Metadata returns the metadata for node read from the remote.
+//
+// The metadata is cached on the node for --dir-cache-time so the
+// caller mustn't modify it.
+//
+// It returns nil if there isn't any, for example if the backend
+// doesn't support metadata or the file is being written.
+func Metadata(ctx context.Context, node Node) (fs.Metadata, error) {
+	listed := node.DirEntry()
+	if listed == nil {
+		return nil, nil
+	}
+	mc := nodeMetadataCache(node)
+	if mc != nil {
+		if metadata, ok := mc.get(listed, time.Duration(node.VFS().Opt.DirCacheTime)); ok {
+			return metadata, nil
+		}
+	}
+	entry, err := metadataEntry(ctx, node)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	metadata, err := fs.GetMetadata(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	if mc != nil {
+		mc.set(listed, metadata)
+	}
+	return metadata, nil
+}
+
+// ListXattr returns the names of the extended attributes of node
+func ListXattr(ctx context.Context, node Node) ([]string, error) {
+	metadata, err := Metadata(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(metadata))
+	for key := range metadata {
+		names = append(names, XattrPrefix+key)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// GetXattr returns the value of the extended attribute name of node.
+//
+// It returns ENOATTR if there isn't one.
+func GetXattr(ctx context.Context, node Node, name string) ([]byte, error) {
+	key, ok := strings.CutPrefix(name, XattrPrefix)
+	if !ok {
+		// Quick return as the kernel asks for security attributes a lot
+		return nil, ENOATTR
+	}
+	metadata, err := Metadata(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	value, found := metadata[key]
+	if !found {
+		return nil, ENOATTR
+	}
+	return []byte(value), nil
+}
+
+// SetXattr sets the extended attribute name of node to value by
+// setting the metadata on the remote.
+//
+// flags may contain XattrCreate or XattrReplace.
+func SetXattr(ctx context.Context, node Node, name string, value []byte, flags int) error {
+	key, ok := strings.CutPrefix(name, XattrPrefix)
+	if !ok || key == "" {
+		return ENOTSUP
+	}
+	if node.VFS().Opt.ReadOnly {
+		return EROFS
+	}
+	entry, err := metadataEntry(ctx, node)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		// The file is being written so doesn't exist on the remote yet
+		return EAGAIN
+	}
+	do, ok := entry.(fs.SetMetadataer)
+	if !ok {
+		return ENOTSUP
+	}
+	if flags&(XattrCreate|XattrReplace) != 0 {
+		metadata, err := fs.GetMetadata(ctx, entry)
+		if err != nil {
+			return err
+		}
+		_, found := metadata[key]
+		if found && flags&XattrCreate != 0 {
+			return EEXIST
+		}
+		if !found && flags&XattrReplace != 0 {
+			return ENOATTR
+		}
+	}
+	err = do.SetMetadata(ctx, fs.Metadata{key: string(value)})
+	if err == fs.ErrorNotImplemented {
+		return ENOTSUP
+	}
+	if err != nil {
+		fs.Errorf(node, "Failed to set metadata %q: %v", key, err)
+		return err
+	}
+	if mc := nodeMetadataCache(node); mc != nil {
+		mc.clear()
+	}
+	// Read the parent directory again so the node picks up any
+	// changes the metadata made, such as to the modification time
+	node.VFS().root.invalidateDir(vfscommon.FindParent(node.Path()))
+	return nil
+}
+
+// RemoveXattr removes the extended attribute name of node.
+//
+// Backends can't remove metadata keys so this returns ENOTSUP if the
+// attribute exists.
+func RemoveXattr(ctx context.Context, node Node, name string) error {
+	_, err := GetXattr(ctx, node, name)
+	if err != nil {
+		return err
+	}
+	return ENOTSUP
+}
@@ -0,0 +1,117 @@
+package vfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/vfs/vfscommon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXattr(t *testing.T) {
+	r, vfs := newTestVFS(t)
+	ctx := context.Background()
+	r.WriteObject(ctx, "dir/file", "data", t1)
+	file := getFile(t, vfs, "dir/file")
+
+	metadata, err := Metadata(ctx, file)
+	require.NoError(t, err)
+	if _, ok := metadata["mode"]; !ok {
+		t.Skip("remote doesn't support mode metadata")
+	}
+
+	// The metadata is listed with the prefix
+	names, err := ListXattr(ctx, file)
+	require.NoError(t, err)
+	assert.Contains(t, names, "user.rclone.mode")
+	assert.Equal(t, len(metadata), len(names))
+
+	// Attributes can be read
+	value, err := GetXattr(ctx, file, "user.rclone.mode")
+	require.NoError(t, err)
+	assert.Equal(t, metadata["mode"], string(value))
+	_, err = GetXattr(ctx, file, "user.rclone.potato")
+	assert.Equal(t, ENOATTR, err)
+	_, err = GetXattr(ctx, file, "security.capability")
+	assert.Equal(t, ENOATTR, err)
+
+	// And written through to the remote
+	require.NoError(t, SetXattr(ctx, file, "user.rclone.mode", []byte("100600"), XattrReplace))
+	value, err = GetXattr(ctx, file, "user.rclone.mode")
+	require.NoError(t, err)
+	assert.Equal(t, "100600", string(value))
+	assert.Equal(t, EEXIST, SetXattr(ctx, file, "user.rclone.mode", []byte("100600"), XattrCreate))
+	assert.Equal(t, ENOATTR, SetXattr(ctx, file, "user.rclone.potato", []byte("1"), XattrReplace))
+	assert.Equal(t, ENOTSUP, SetXattr(ctx, file, "user.other", []byte("1"), 0))
+
+	// They can't be removed
+	assert.Equal(t, ENOTSUP, RemoveXattr(ctx, file, "user.rclone.mode"))
+	assert.Equal(t, ENOATTR, RemoveXattr(ctx, file, "user.rclone.potato"))
+
+	// Directories have them too
+	node, err := vfs.Stat("dir")
+	require.NoError(t, err)
+	names, err = ListXattr(ctx, node)
+	require.NoError(t, err)
+	assert.Contains(t, names, "user.rclone.mode")
+
+	// Changes to the modification time are seen by the VFS
+	require.NoError(t, SetXattr(ctx, node, "user.rclone.mtime", []byte(t2.Format(time.RFC3339Nano)), 0))
+	node, err = vfs.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, t2.Equal(node.ModTime()), node.ModTime())
+}
+
+func TestXattrReadOnly(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.ReadOnly = true
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	r.WriteObject(ctx, "file", "data", t1)
+	file := getFile(t, vfs, "file")
+
+	assert.Equal(t, EROFS, SetXattr(ctx, file, "user.rclone.mode", []byte("100600"), 0))
+}
+
+func TestXattrCache(t *testing.T) {
+	opt := vfscommon.Opt
+	opt.DirCacheTime = fs.Duration(time.Hour)
+	r, vfs := newTestVFSOpt(t, &opt)
+	ctx := context.Background()
+	r.WriteObject(ctx, "file", "data", t1)
+	file := getFile(t, vfs, "file")
+
+	value, err := GetXattr(ctx, file, "user.rclone.mode")
+	if err == ENOATTR {
+		t.Skip("remote doesn't support mode metadata")
+	}
+	require.NoError(t, err)
+
+	// Changes made on the remote aren't seen while the metadata is cached
+	o, err := r.Fremote.NewObject(ctx, "file")
+	require.NoError(t, err)
+	do, ok := o.(fs.SetMetadataer)
+	if !ok {
+		t.Skip("remote can't set metadata")
+	}
+	require.NoError(t, do.SetMetadata(ctx, fs.Metadata{"mode": "100600"}))
+	got, err := GetXattr(ctx, file, "user.rclone.mode")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	// but are once it expires
+	vfs.Opt.DirCacheTime = 0
+	got, err = GetXattr(ctx, file, "user.rclone.mode")
+	require.NoError(t, err)
+	assert.Equal(t, "100600", string(got))
+
+	// Changes made through the VFS are seen straight away
+	vfs.Opt.DirCacheTime = fs.Duration(time.Hour)
+	require.NoError(t, SetXattr(ctx, file, "user.rclone.mode", []byte("100644"), 0))
+	got, err = GetXattr(ctx, file, "user.rclone.mode")
+	require.NoError(t, err)
+	assert.Equal(t, "100644", string(got))
+}